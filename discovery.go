@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/yuuki/rdma_exporter/internal/config"
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+const (
+	rediscoveryInitialInterval = 5 * time.Second
+	rediscoveryMaxInterval     = 5 * time.Minute
+)
+
+// checkDevices applies --startup.no-devices to the first device enumeration.
+// With the wait policy it blocks, re-discovering with exponential backoff,
+// until a device appears or ctx is done, so the exporter only starts serving
+// on hosts that have RDMA devices.
+func checkDevices(ctx context.Context, cfg config.Config, logger *slog.Logger, source rdma.Provider) error {
+	probeCtx, cancel := ctx, context.CancelFunc(func() {})
+	if cfg.ScrapeTimeout > 0 {
+		probeCtx, cancel = context.WithTimeout(ctx, cfg.ScrapeTimeout)
+	}
+	devices, err := source.Devices(probeCtx)
+	cancel()
+	if err == nil && len(devices) > 0 {
+		logger.Info("rdma devices found", "devices", len(devices))
+		return nil
+	}
+
+	switch cfg.NoDevicesPolicy {
+	case config.NoDevicesFail:
+		if err != nil {
+			return err
+		}
+		return rdma.ErrNoDevices
+	case config.NoDevicesWait:
+		logger.Warn("no rdma devices found, waiting for one before serving", "err", err, "max_interval", rediscoveryMaxInterval.String())
+		devices, err := rdma.WaitForDevices(ctx, source, rediscoveryInitialInterval, rediscoveryMaxInterval, func(wait time.Duration, err error) {
+			logger.Info("rdma device discovery found no devices", "retry_in", wait.String(), "err", err)
+		})
+		if err != nil {
+			return err
+		}
+		logger.Info("rdma devices discovered", "devices", len(devices))
+		return nil
+	default:
+		logger.Warn("no rdma devices found; exporting rdma_devices 0", "err", err)
+		return nil
+	}
+}
@@ -0,0 +1,281 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/yuuki/rdma_exporter/internal/influx"
+)
+
+const (
+	classInfinibandPath = "class/infiniband"
+
+	// maxFileSize bounds how much of a single sysfs file is captured. Attribute
+	// and counter files are tiny; anything larger is not worth shipping.
+	maxFileSize = 64 << 10
+
+	redactedValue = "REDACTED"
+)
+
+var (
+	// GIDs and IPv6 addresses: eight colon-separated hextets.
+	gidPattern = regexp.MustCompile(`(?i)\b(?:[0-9a-f]{4}:){7}[0-9a-f]{4}\b`)
+	// ipv6CandidatePattern matches anything that may be an IPv6 address in
+	// any notation (fe80::1, ::ffff:10.0.0.1); matches are validated with
+	// netip.ParseAddr before they are redacted.
+	ipv6CandidatePattern = regexp.MustCompile(`(?i)[0-9a-f]*:[0-9a-f:.]*:[0-9a-f.]*`)
+	// Node, system image and port GUIDs as printed by sysfs.
+	guidPattern = regexp.MustCompile(`(?i)\b(?:[0-9a-f]{4}:){3}[0-9a-f]{4}\b`)
+	ipv4Pattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	// URLs in the encoded configuration, such as --output.influx.url.
+	urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^"\s\\]+`)
+)
+
+// Options configures the contents of a support bundle.
+type Options struct {
+	// SysfsRoot is the root of the sysfs tree whose class/infiniband subtree is
+	// captured.
+	SysfsRoot string
+	// Config is the effective exporter configuration, serialized as JSON.
+	Config any
+	// Gatherer provides one exposition sample, including the exporter's own
+	// error counters.
+	Gatherer prometheus.Gatherer
+	// Version is free-form build information.
+	Version string
+	// Redact replaces GIDs, GUIDs and IP addresses in every captured file.
+	Redact bool
+	// Now is used for the bundle timestamp; defaults to time.Now.
+	Now func() time.Time
+}
+
+// Write streams a gzip-compressed tarball to w.
+func Write(ctx context.Context, w io.Writer, opts Options) error {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	gz := gzip.NewWriter(w)
+	b := &builder{
+		tw:     tar.NewWriter(gz),
+		redact: opts.Redact,
+		mtime:  opts.Now(),
+	}
+
+	if err := b.add("version.txt", []byte(opts.Version)); err != nil {
+		return err
+	}
+
+	// URLs are written unescaped so their credentials can be found.
+	var configJSON bytes.Buffer
+	encoder := json.NewEncoder(&configJSON)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(opts.Config); err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	if err := b.add("config.json", redactURLCredentials(configJSON.Bytes())); err != nil {
+		return err
+	}
+
+	if opts.Gatherer != nil {
+		if err := b.addExposition(opts.Gatherer); err != nil {
+			return err
+		}
+	}
+
+	if err := b.addSysfs(ctx, opts.SysfsRoot); err != nil {
+		return err
+	}
+
+	if err := b.tw.Close(); err != nil {
+		return fmt.Errorf("close tar: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("close gzip: %w", err)
+	}
+	return nil
+}
+
+// redactURLCredentials hides the credentials of the URLs in the encoded
+// configuration. Unlike Redact it is always applied, since credentials have
+// no place in a bundle meant to be attached to public issues.
+func redactURLCredentials(data []byte) []byte {
+	return urlPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		u, err := url.Parse(string(match))
+		if err != nil {
+			return []byte(redactedValue)
+		}
+		return []byte(influx.RedactURL(u))
+	})
+}
+
+type builder struct {
+	tw     *tar.Writer
+	redact bool
+	mtime  time.Time
+	errors []string
+}
+
+func (b *builder) add(name string, data []byte) error {
+	if b.redact {
+		data = Redact(data)
+	}
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: b.mtime,
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write header %s: %w", name, err)
+	}
+	if _, err := b.tw.Write(data); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+func (b *builder) addExposition(g prometheus.Gatherer) error {
+	var buf bytes.Buffer
+	mfs, err := g.Gather()
+	if err != nil {
+		// Keep whatever was gathered; the error itself is useful in a bundle.
+		fmt.Fprintf(&buf, "# gather error: %v\n", err)
+	}
+	enc := expfmt.NewEncoder(&buf, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return fmt.Errorf("encode metrics: %w", err)
+		}
+	}
+	return b.add("metrics.txt", buf.Bytes())
+}
+
+// addSysfs captures class/infiniband. Device entries are symlinks into the PCI
+// tree, so they are resolved once; nested symlinks (device, subsystem, ...)
+// are not followed to keep the walk bounded.
+func (b *builder) addSysfs(ctx context.Context, root string) error {
+	classDir := filepath.Join(root, classInfinibandPath)
+	entries, err := os.ReadDir(classDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read %s: %w", classDir, err)
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		devDir, err := filepath.EvalSymlinks(filepath.Join(classDir, entry.Name()))
+		if err != nil {
+			b.errors = append(b.errors, fmt.Sprintf("%s: %v", entry.Name(), err))
+			continue
+		}
+		prefix := path.Join("sysfs", classInfinibandPath, entry.Name())
+		if err := b.walk(ctx, devDir, prefix); err != nil {
+			return err
+		}
+	}
+
+	if len(b.errors) > 0 {
+		return b.add("sysfs_errors.txt", []byte(strings.Join(b.errors, "\n")+"\n"))
+	}
+	return nil
+}
+
+func (b *builder) walk(ctx context.Context, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			b.errors = append(b.errors, fmt.Sprintf("%s: %v", p, err))
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil
+		}
+		data, err := readLimited(p)
+		if err != nil {
+			// Some hw counters and write-only attributes fail to read; record
+			// and move on so one bad file does not sink the bundle.
+			b.errors = append(b.errors, fmt.Sprintf("%s: %v", p, err))
+			return nil
+		}
+		return b.add(path.Join(prefix, filepath.ToSlash(rel)), data)
+	})
+}
+
+func readLimited(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, maxFileSize))
+}
+
+// Redact replaces GIDs, GUIDs and IPv4 and IPv6 addresses with a fixed
+// marker.
+func Redact(data []byte) []byte {
+	data = gidPattern.ReplaceAll(data, []byte(redactedValue))
+	data = redactIPv6(data)
+	data = guidPattern.ReplaceAll(data, []byte(redactedValue))
+	return ipv4Pattern.ReplaceAll(data, []byte(redactedValue))
+}
+
+// redactIPv6 replaces IPv6 addresses, including compressed ones such as
+// fe80::1, that stand on their own: a candidate glued to other address-like
+// characters, such as the PCI address 0000:1a:00.0, is left alone.
+func redactIPv6(data []byte) []byte {
+	var out []byte
+	last := 0
+	for _, loc := range ipv6CandidatePattern.FindAllIndex(data, -1) {
+		start, end := loc[0], loc[1]
+		if start > 0 && isAddrByte(data[start-1]) || end < len(data) && isAddrByte(data[end]) {
+			continue
+		}
+		addr, err := netip.ParseAddr(string(data[start:end]))
+		if err != nil || !addr.Is6() {
+			continue
+		}
+		out = append(out, data[last:start]...)
+		out = append(out, redactedValue...)
+		last = end
+	}
+	if out == nil {
+		return data
+	}
+	return append(out, data[last:]...)
+}
+
+func isAddrByte(c byte) bool {
+	return c == ':' || c == '.' || c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
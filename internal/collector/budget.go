@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+const (
+	// budgetWindow is the number of recent scrapes the p95 is computed over.
+	budgetWindow = 20
+	// budgetEnterRatio and budgetExitRatio are the fractions of the scrape
+	// timeout at which degraded mode is entered and left. The gap keeps the
+	// collector from flapping around a single threshold.
+	budgetEnterRatio = 0.8
+	budgetExitRatio  = 0.5
+)
+
+// OptionsProvider is implemented by providers that can skip part of a device
+// read. The collector uses it in degraded mode.
+type OptionsProvider interface {
+	DevicesWithOptions(ctx context.Context, opts rdma.ReadOptions) ([]rdma.Device, error)
+}
+
+// scrapeBudget tracks the duration of recent scrapes and decides whether the
+// collector should shed optional work. It is only used while collectMu is
+// held.
+type scrapeBudget struct {
+	timeout   time.Duration
+	durations []time.Duration
+	next      int
+	degraded  bool
+}
+
+func newScrapeBudget(timeout time.Duration) *scrapeBudget {
+	return &scrapeBudget{
+		timeout:   timeout,
+		durations: make([]time.Duration, 0, budgetWindow),
+	}
+}
+
+// observe records the duration of a scrape and reports whether the degraded
+// state changed. Degraded mode is entered as soon as the p95 reaches
+// budgetEnterRatio of the timeout, and left only after a full window of
+// degraded scrapes stays below budgetExitRatio.
+func (b *scrapeBudget) observe(d time.Duration) bool {
+	if len(b.durations) < budgetWindow {
+		b.durations = append(b.durations, d)
+	} else {
+		b.durations[b.next] = d
+	}
+	b.next = (b.next + 1) % budgetWindow
+
+	p95 := b.p95()
+	if !b.degraded {
+		if p95 < time.Duration(float64(b.timeout)*budgetEnterRatio) {
+			return false
+		}
+		b.degraded = true
+		// Start over so the exit decision is based on degraded scrapes only.
+		b.durations = b.durations[:0]
+		b.next = 0
+		return true
+	}
+	if len(b.durations) < budgetWindow || p95 >= time.Duration(float64(b.timeout)*budgetExitRatio) {
+		return false
+	}
+	b.degraded = false
+	return true
+}
+
+func (b *scrapeBudget) p95() time.Duration {
+	if len(b.durations) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(b.durations)
+	slices.Sort(sorted)
+	idx := (len(sorted)*95+99)/100 - 1
+	return sorted[idx]
+}
+
+// WithScrapeBudget adapts the work done per scrape to timeout. When the p95 of
+// recent scrape durations approaches timeout, the collector enters degraded
+// mode: hw_counters, port attributes, RoCE PFC, link settings and vport
+// counters are skipped until scrapes are fast again. A non-positive timeout
+// disables the budget.
+func WithScrapeBudget(timeout time.Duration) Option {
+	return func(c *RdmaCollector) {
+		if timeout <= 0 {
+			return
+		}
+		c.budget = newScrapeBudget(timeout)
+	}
+}
+
+// degraded reports whether the current scrape should shed optional work.
+func (c *RdmaCollector) degraded() bool {
+	return c.budget != nil && c.budget.degraded
+}
+
+// observeScrape feeds the duration of a finished scrape to the budget.
+func (c *RdmaCollector) observeScrape(d time.Duration) {
+	if !c.budget.observe(d) {
+		return
+	}
+	if c.budget.degraded {
+		c.logger.Warn("rdma scrapes approaching timeout, entering degraded mode",
+			"p95", c.budget.p95(), "timeout", c.budget.timeout)
+	} else {
+		c.logger.Info("rdma scrapes recovered, leaving degraded mode")
+	}
+}
+
+func (c *RdmaCollector) collectDegradedMode(ch chan<- prometheus.Metric) {
+	if c.budget == nil {
+		return
+	}
+	value := 0.0
+	if c.budget.degraded {
+		value = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.degradedModeDesc, prometheus.GaugeValue, value)
+}
+
+// readDevices reads the device snapshot, trimmed to counters when degraded
+// and the provider supports it. A host without an RDMA subsystem scrapes as
+// one with zero devices.
+func (c *RdmaCollector) readDevices(ctx context.Context, degraded bool) ([]rdma.Device, error) {
+	var devices []rdma.Device
+	var err error
+	if provider, ok := c.provider.(OptionsProvider); ok && degraded {
+		devices, err = provider.DevicesWithOptions(ctx, rdma.ReadOptions{SkipHwCounters: true, SkipAttributes: true})
+	} else {
+		devices, err = c.provider.Devices(ctx)
+	}
+	if errors.Is(err, rdma.ErrNoDevices) {
+		return nil, nil
+	}
+	return devices, err
+}
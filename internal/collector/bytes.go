@@ -0,0 +1,36 @@
+package collector
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// byteCounters maps the data counters, which count 4-octet words, to the
+// byte counters derived from them.
+var byteCounters = map[string]string{
+	"port_xmit_data": "rdma_port_xmit_bytes_total",
+	"port_rcv_data":  "rdma_port_rcv_bytes_total",
+}
+
+// WithByteCounters additionally exports port_xmit_data and port_rcv_data in
+// bytes, as rdma_port_xmit_bytes_total and rdma_port_rcv_bytes_total, so
+// dashboards need not know that the data counters count 4-octet words.
+func WithByteCounters() Option {
+	return func(c *RdmaCollector) {
+		c.byteCounters = true
+	}
+}
+
+// initByteCounterDescs builds the descriptors of the byte counters. It runs
+// with initPortDescs.
+func (c *RdmaCollector) initByteCounterDescs() {
+	if !c.byteCounters {
+		return
+	}
+	c.byteCounterDescs = make(map[string]*prometheus.Desc, len(byteCounters))
+	for stat, name := range byteCounters {
+		c.byteCounterDescs[stat] = prometheus.NewDesc(
+			name,
+			"The total number of data octets of "+stat+", which counts 4-octet words, multiplied by 4.",
+			c.portLabelNames(),
+			nil,
+		)
+	}
+}
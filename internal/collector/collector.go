@@ -2,9 +2,11 @@ package collector
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"log/slog"
+	"math"
 	"regexp"
 	"slices"
 	"strconv"
@@ -29,6 +31,11 @@ type NetDevStatsProvider interface {
 	Stats(ctx context.Context, netDev string) (map[string]uint64, error)
 }
 
+// FlowLabelProvider reads the node-wide IPv6 flow label sysctls.
+type FlowLabelProvider interface {
+	FlowLabelSettings(ctx context.Context) (rdma.FlowLabelSettings, error)
+}
+
 // Option configures collector behavior.
 type Option func(*RdmaCollector)
 
@@ -37,12 +44,56 @@ type RdmaCollector struct {
 	provider Provider
 	logger   *slog.Logger
 
-	portInfoDesc *prometheus.Desc
+	deviceInfoDesc  *prometheus.Desc
+	portInfoDesc    *prometheus.Desc
+	portFabricDesc  *prometheus.Desc
+	pcieLimitedDesc *prometheus.Desc
+
+	// InfiniBand addressing of a port, which changes on SM failovers.
+	portLIDDesc     *prometheus.Desc
+	portSMLIDDesc   *prometheus.Desc
+	portLMCDesc     *prometheus.Desc
+	portCapMaskDesc *prometheus.Desc
+
+	// Numeric port states and link rate, for alerting and PromQL arithmetic
+	// without parsing rdma_port_info labels.
+	portStateDesc     *prometheus.Desc
+	portPhysStateDesc *prometheus.Desc
+	portLinkSpeedDesc *prometheus.Desc
+	portLinkWidthDesc *prometheus.Desc
+
+	// silences maps silenced devices to the end of their silence.
+	silenceMu          sync.Mutex
+	silences           map[string]time.Time
+	deviceSilencedDesc *prometheus.Desc
+
+	counterUnitDesc      *prometheus.Desc
+	portTickDurationDesc *prometheus.Desc
+	tickDuration         time.Duration
 
 	portStatMetrics  map[string]metricEntry
 	portStatLookup   map[string]string
 	portHwMetrics    map[string]metricEntry
 	portHwStatLookup map[string]string
+	// deviceHwMetrics and deviceHwStatLookup hold the descriptors of
+	// device-scoped hw counters.
+	deviceHwMetrics    map[string]metricEntry
+	deviceHwStatLookup map[string]string
+	// vlHwMetrics and vlHwStatLookup hold the descriptors of per virtual
+	// lane hw counters.
+	vlHwMetrics    map[string]metricEntry
+	vlHwStatLookup map[string]string
+	// gaugeCounters names the counters and hw_counters exported as gauges.
+	gaugeCounters map[string]struct{}
+	// counterSpecs maps counters to the canonical names of WithCounterSpecs
+	// and counterHelp those names to their help texts.
+	counterSpecs map[string]string
+	counterHelp  map[string]string
+	// dynamicDescs is the copy-on-write snapshot of the counter descriptors
+	// above that Describe reads; pendingDescs holds the ones created by the
+	// running Collect.
+	dynamicDescs atomic.Pointer[[]*prometheus.Desc]
+	pendingDescs []*prometheus.Desc
 
 	rocePFCPauseFramesDesc      *prometheus.Desc
 	rocePFCPauseDurationDesc    *prometheus.Desc
@@ -51,10 +102,196 @@ type RdmaCollector struct {
 	scrapeErrors        prometheus.Counter
 	rocePFCScrapeErrors prometheus.Counter
 
+	// deviceReadRetriesDesc and deviceReadErrorsDesc are only used with a
+	// ReadStatsProvider.
+	deviceReadRetriesDesc *prometheus.Desc
+	deviceReadErrorsDesc  *prometheus.Desc
+
+	// warnings counts the collector's own warnings by type.
+	warnings     map[string]uint64
+	warningsDesc *prometheus.Desc
+
+	collectorEnabledDesc *prometheus.Desc
+	// enabledMetrics caches the rdma_exporter_collector_enabled series,
+	// which cannot change once the collector is built.
+	enabledMetrics []prometheus.Metric
+
 	netDevStatsProvider NetDevStatsProvider
 
+	linkSettingsProvider  LinkSettingsProvider
+	links                 *linkTracker
+	netDevLinkSpeedDesc   *prometheus.Desc
+	netDevLinkDuplexDesc  *prometheus.Desc
+	netDevLinkAutonegDesc *prometheus.Desc
+	netDevLinkChangesDesc *prometheus.Desc
+
+	dcbProvider            DCBProvider
+	pfcPriorityEnabledDesc *prometheus.Desc
+	pfcDelayDesc           *prometheus.Desc
+	etsBandwidthDesc       *prometheus.Desc
+	etsPriorityTCDesc      *prometheus.Desc
+
+	// netDevStatisticsDescs maps sysfs netdev statistics to descriptors.
+	netDevStatisticsProvider NetDevStatisticsProvider
+	netDevStatisticsDescs    map[string]*prometheus.Desc
+
+	// ethtoolStatsDescs maps the selected ethtool statistics to descriptors,
+	// or to nil for statistics skipped because their metric name is taken;
+	// ethtoolStatsNames maps the metric names to the statistics they belong
+	// to.
+	ethtoolStatsProvider NetDevStatsProvider
+	ethtoolStatsPatterns []string
+	ethtoolStatsDescs    map[string]*prometheus.Desc
+	ethtoolStatsNames    map[string]string
+
+	flowLabelProvider FlowLabelProvider
+	ipv6FlowLabelDesc *prometheus.Desc
+
+	resourceProvider ResourceProvider
+	resourceDescs    map[string]*prometheus.Desc
+
+	// deviceLimits caches the limits of every device by name; a nil entry
+	// is a failed query.
+	deviceLimitProvider DeviceLimitProvider
+	deviceLimits        map[string]map[string]uint64
+	deviceLimitDesc     *prometheus.Desc
+
+	processResourceProvider ProcessResourceProvider
+	processResourceDescs    map[string]*prometheus.Desc
+
+	gidTableProvider GIDTableProvider
+	portGIDInfoDesc  *prometheus.Desc
+
+	pkeyTableProvider PKeyTableProvider
+	portPKeyInfoDesc  *prometheus.Desc
+
+	ueventProvider    UEventProvider
+	deviceUEventsDesc *prometheus.Desc
+
+	roceConfigProvider RoCEConfigProvider
+	roceQoSInfoDesc    *prometheus.Desc
+	roceECNEnabledDesc *prometheus.Desc
+	roceDCQCNParamDesc *prometheus.Desc
+	// roceDefaultVersionDesc and roceDefaultToSDesc carry the RDMA CM
+	// defaults of rdma_roce_qos_info as values.
+	roceDefaultVersionDesc *prometheus.Desc
+	roceDefaultToSDesc     *prometheus.Desc
+
+	// byteCounterDescs is keyed by the data counter the byte counter is
+	// derived from; nil unless byteCounters is set.
+	byteCounters     bool
+	byteCounterDescs map[string]*prometheus.Desc
+
+	// portRoleRules classify ports into the role label; portRoles holds the
+	// role of every port as of the last classification.
+	portRoleRules []PortRoleRule
+	portRoleGIDs  GIDTableProvider
+	portRoles     map[portKey]string
+
+	// qpCounterProvider is set when per-QP counters are exported.
+	qpCounterProvider     QPCounterProvider
+	qpCounterLimit        int
+	qpCounterDesc         *prometheus.Desc
+	qpCounterQPsDesc      *prometheus.Desc
+	qpCountersDroppedDesc *prometheus.Desc
+
+	representorProvider RepresentorProvider
+	vportStatsProvider  NetDevStatsProvider
+	// vportMetrics maps representor ethtool stats to descriptors; nil
+	// entries mark stats that are not vport counters.
+	vportMetrics map[string]*prometheus.Desc
+
+	// hostname is set when node_desc is checked against it.
+	hostname             string
+	nodeDescMismatchDesc *prometheus.Desc
+	// expectationsProvider is set when the node's expectations are compared
+	// with its devices.
+	expectationsProvider    ExpectationsProvider
+	expectedDesc            *prometheus.Desc
+	expectationViolatedDesc *prometheus.Desc
+
+	// dedupMode is set when devices surfacing the same hardware are
+	// deduplicated; duplicates maps each dropped device to its canonical one.
+	dedupMode     string
+	duplicates    map[string]string
+	duplicateDesc *prometheus.Desc
+
+	schemaVersion   int
+	schemaInfoDesc  *prometheus.Desc
+	portPacketsDesc *prometheus.Desc
+
+	// emitZeros exports 0 for metricSpecs counters a port does not expose.
+	emitZeros bool
+	zeroStats []string
+
+	labels *labelCache
+
+	startTime       time.Time
+	lastSuccess     time.Time
+	startTimeDesc   *prometheus.Desc
+	lastSuccessDesc *prometheus.Desc
+	devicesDesc     *prometheus.Desc
+	portsDesc       *prometheus.Desc
+
+	// jitter is non-nil when counter rate distributions are exported.
+	jitter              *jitterTracker
+	portCounterRateDesc *prometheus.Desc
+
+	// utilization is non-nil when link utilization is exported.
+	utilization         *utilizationTracker
+	portUtilizationDesc *prometheus.Desc
+
+	// topCounters is non-nil when the fastest growing counters are exported.
+	topCounters *topCounterTracker
+
+	// suppress is non-nil when unchanged counters are suppressed.
+	suppress *suppressTracker
+
+	// state is non-nil in stateful mode.
+	state                   *stateTracker
+	portIdleDesc            *prometheus.Desc
+	portRetransmitRatioDesc *prometheus.Desc
+	now                     func() time.Time
+	// recoveryBursts defines the link error recovery bursts counted in
+	// stateful mode.
+	recoveryBursts            recoveryBurstPolicy
+	portRecoveryBurstsDesc    *prometheus.Desc
+	portRecoveryBurstSizeDesc *prometheus.Desc
+
+	deepScanProvider      DeepScanProvider
+	deepScanTimestampDesc *prometheus.Desc
+	pcieAERErrorsDesc     *prometheus.Desc
+	deepMu                sync.Mutex
+	deepResult            *deepScanResult
+
+	// budget is non-nil when the scrape budget adapts work to the timeout.
+	budget           *scrapeBudget
+	degradedModeDesc *prometheus.Desc
+
+	// snapshot is non-nil when scrapes within a lifespan share a device read.
+	snapshot           *snapshotCache
+	snapshotAgeDesc    *prometheus.Desc
+	snapshotReusesDesc *prometheus.Desc
+	// last is the device read of the last full scrape, served to the JSON API.
+	last lastSnapshot
+
+	// warmup is the window after startup in which derived metrics are
+	// withheld; zero disables it.
+	warmup     time.Duration
+	warmupDesc *prometheus.Desc
+
+	// collectorTimeouts bounds individual collectors within a scrape.
+	collectorTimeouts      map[string]time.Duration
+	collectorTimeoutCounts map[string]uint64
+	collectorTimeoutsDesc  *prometheus.Desc
+
 	collectMu sync.Mutex
 	ctxValue  atomic.Pointer[context.Context]
+
+	// lockWait and lockHold observe how long scrapes wait for collectMu and
+	// then hold it, showing contention between concurrent scrapers.
+	lockWait prometheus.Histogram
+	lockHold prometheus.Histogram
 }
 
 type metricEntry struct {
@@ -65,10 +302,26 @@ type metricEntry struct {
 type metricSpec struct {
 	DocName string
 	Help    string
+	// Unit is set for counters whose value is not a plain event count, such
+	// as "ticks" for counters incremented once per device-specific tick.
+	Unit string
 }
 
 var (
-	rocePFCStatPattern = regexp.MustCompile(`^(rx|tx)_prio([0-7])_pause(?:_(duration|transition))?$`)
+	// rocePFCStatPatterns map the per-priority PFC counters NIC drivers report
+	// in their ethtool stats to the PFC metrics. Durations are in microseconds.
+	rocePFCStatPatterns = []rocePFCStatPattern{
+		// mlx5: rx_prio3_pause, tx_prio3_pause_duration, rx_prio3_pause_transition
+		{regexp.MustCompile(`^(?P<direction>rx|tx)_prio(?P<priority>[0-7])_pause$`), rocePFCMetricKindFrames},
+		{regexp.MustCompile(`^(?P<direction>rx|tx)_prio(?P<priority>[0-7])_pause_duration$`), rocePFCMetricKindDuration},
+		{regexp.MustCompile(`^(?P<direction>rx|tx)_prio(?P<priority>[0-7])_pause_transition$`), rocePFCMetricKindTransitions},
+		// bnxt_en: rx_pfc_ena_frames_pri3, pfc_pri3_tx_duration_us, pfc_pri3_rx_transitions
+		{regexp.MustCompile(`^(?P<direction>rx|tx)_pfc_ena_frames_pri(?P<priority>[0-7])$`), rocePFCMetricKindFrames},
+		{regexp.MustCompile(`^pfc_pri(?P<priority>[0-7])_(?P<direction>rx|tx)_duration_us$`), rocePFCMetricKindDuration},
+		{regexp.MustCompile(`^pfc_pri(?P<priority>[0-7])_(?P<direction>rx|tx)_transitions$`), rocePFCMetricKindTransitions},
+		// ice: rx_priority_3_xoff.nic, counting XOFF (pause) frames
+		{regexp.MustCompile(`^(?P<direction>rx|tx)_priority_(?P<priority>[0-7])_xoff\.nic$`), rocePFCMetricKindFrames},
+	}
 
 	// ref. "Understanding mlx5 Linux Counters and Status Parameters", https://enterprise-support.nvidia.com/s/article/understanding-mlx5-linux-counters-and-status-parameters
 	metricSpecs = map[string]metricSpec{
@@ -122,7 +375,8 @@ var (
 		},
 		"port_xmit_wait": {
 			DocName: "port_xmit_wait",
-			Help:    "Number of ticks during which the port had data to transmit but no data was sent during the entire tick.",
+			Help:    "Number of ticks during which the port had data to transmit but no data was sent during the entire tick. The tick length is device specific; see rdma_port_tick_duration_seconds.",
+			Unit:    counterUnitTicks,
 		},
 		"port_xmit_discards": {
 			DocName: "port_xmit_discards",
@@ -140,6 +394,10 @@ var (
 			DocName: "symbol_error",
 			Help:    "Total number of minor link errors detected on one or more physical lanes.",
 		},
+		"excessive_buffer_overrun_errors": {
+			DocName: "excessive_buffer_overrun_errors",
+			Help:    "Number of times that OverrunErrors consecutive flow control update periods occurred, each having at least one overrun error.",
+		},
 		"VL15_dropped": {
 			DocName: "VL15_dropped",
 			Help:    "Number of incoming VL15 packets dropped due to resource limitations.",
@@ -275,6 +533,10 @@ var (
 	}
 
 	metricHelpByDocName = buildMetricHelpByDocName()
+
+	// lockBuckets span uncontended locks (sub-millisecond) to scrapes queued
+	// behind a full scrape timeout.
+	lockBuckets = prometheus.ExponentialBuckets(0.0005, 4, 8)
 )
 
 type rocePFCMetricKind int
@@ -285,6 +547,15 @@ const (
 	rocePFCMetricKindTransitions
 )
 
+type rocePFCStatPattern struct {
+	re   *regexp.Regexp
+	kind rocePFCMetricKind
+}
+
+// netDevStatsCacheEntry is a read of a netdev's statistics. The cache shared
+// by the PFC and ethtool collectors only holds successful reads: each
+// collector reads under its own timeout, so the error of one is not the
+// other's.
 type netDevStatsCacheEntry struct {
 	stats map[string]uint64
 	err   error
@@ -301,29 +572,70 @@ func buildMetricHelpByDocName() map[string]string {
 	return help
 }
 
-func (c *RdmaCollector) hwMetricDesc(stat string) *prometheus.Desc {
-	docName := canonicalDocName(stat)
-	return c.metricDesc(stat, docName, "RDMA port hardware counter sourced from sysfs hw_counters.", c.portHwMetrics, c.portHwStatLookup)
+// hwMetricDesc returns the descriptor of a port hw counter. driver selects the
+// help pack of the device's driver when the descriptor is created.
+func (c *RdmaCollector) hwMetricDesc(stat, driver string) *prometheus.Desc {
+	docName := c.canonicalDocName(stat)
+	return c.metricDesc(stat, docName, driver, "rdma_", "RDMA port hardware counter sourced from sysfs hw_counters.", c.portLabelNames, c.portHwMetrics, c.portHwStatLookup)
+}
+
+// deviceLabelNames has the signature of portLabelNames for metricDesc.
+func deviceLabelNames(...string) []string {
+	return []string{"device"}
+}
+
+// deviceHwMetricDesc returns the descriptor of a device-scoped hw counter,
+// named rdma_device_<counter>_total so it cannot clash with the port counter
+// of the same name.
+func (c *RdmaCollector) deviceHwMetricDesc(stat, driver string) *prometheus.Desc {
+	docName := c.canonicalDocName(stat)
+	return c.metricDesc(stat, docName, driver, "rdma_device_", "RDMA device hardware counter sourced from sysfs hw_counters.", deviceLabelNames, c.deviceHwMetrics, c.deviceHwStatLookup)
+}
+
+// vlHwMetricDesc returns the descriptor of a per virtual lane hw counter,
+// named rdma_vl_<counter>_total with a vl label, so the per-VL and port-wide
+// counters of the same name do not add up in a sum over the metric.
+func (c *RdmaCollector) vlHwMetricDesc(stat, driver string) *prometheus.Desc {
+	docName := c.canonicalDocName(stat)
+	labels := func(...string) []string { return c.portLabelNames("vl") }
+	return c.metricDesc(stat, docName, driver, "rdma_vl_", "RDMA per virtual lane hardware counter sourced from sysfs hw_counters.", labels, c.vlHwMetrics, c.vlHwStatLookup)
 }
 
 func (c *RdmaCollector) statMetricDesc(stat string) *prometheus.Desc {
-	docName := canonicalDocName(stat)
-	return c.metricDesc(stat, docName, "RDMA port counter sourced from sysfs counters.", c.portStatMetrics, c.portStatLookup)
+	docName := c.canonicalDocName(stat)
+	return c.metricDesc(stat, docName, "", "rdma_", "RDMA port counter sourced from sysfs counters.", c.portLabelNames, c.portStatMetrics, c.portStatLookup)
 }
 
-func (c *RdmaCollector) metricDesc(stat, docName, fallback string, entries map[string]metricEntry, lookup map[string]string) *prometheus.Desc {
+// metricDesc returns the cached descriptor of stat or creates one named
+// <prefix><counter>_total, or <prefix><counter> for gauges. labels is only
+// called on creation, so the scrape path does not build label names. The help
+// text comes from the help pack of driver, if any, when the descriptor is
+// created; a counter shared by devices of different drivers, under its own or
+// a normalized name, keeps the help of the first one.
+func (c *RdmaCollector) metricDesc(stat, docName, driver, prefix, fallback string, labels func(...string) []string, entries map[string]metricEntry, lookup map[string]string) *prometheus.Desc {
 	if metricName, ok := lookup[stat]; ok {
 		if entry, exists := entries[metricName]; exists {
 			return entry.desc
 		}
 	}
 
-	metricName := buildMetricName(docName, entries)
-	help := metricDocHelp(docName, fallback)
+	metricName := buildMetricName(prefix, docName, c.isGauge(stat), entries)
+	if entry, exists := entries[metricName]; exists {
+		// Vendor counters normalized to the same canonical name share one
+		// descriptor, as the registry rejects a metric with two help texts.
+		lookup[stat] = metricName
+		return entry.desc
+	}
+
+	help, known := c.metricDocHelp(docName, driver)
+	if !known {
+		c.addWarning(WarningUnknownCounter)
+		help = fallback
+	}
 	desc := prometheus.NewDesc(
 		metricName,
 		help,
-		[]string{"device", "port"},
+		labels(),
 		nil,
 	)
 
@@ -332,30 +644,28 @@ func (c *RdmaCollector) metricDesc(stat, docName, fallback string, entries map[s
 		docName: docName,
 	}
 	lookup[stat] = metricName
+	c.addDynamicDesc(desc)
 
 	return desc
 }
 
-func buildMetricName(docName string, existing map[string]metricEntry) string {
+func buildMetricName(prefix, docName string, gauge bool, existing map[string]metricEntry) string {
 	base := sanitizeStatName(docName)
-	metricName := fmt.Sprintf("rdma_%s_total", base)
+	suffix := "_total"
+	if gauge {
+		suffix = ""
+	}
+	metricName := prefix + base + suffix
 
 	if entry, ok := existing[metricName]; ok && entry.docName != docName {
 		h := fnv.New32a()
 		_, _ = h.Write([]byte(docName))
-		metricName = fmt.Sprintf("rdma_%s_%x_total", base, h.Sum32())
+		metricName = fmt.Sprintf("%s%s_%x%s", prefix, base, h.Sum32(), suffix)
 	}
 
 	return metricName
 }
 
-func metricDocHelp(docName, fallback string) string {
-	if help, ok := metricHelpByDocName[docName]; ok {
-		return help
-	}
-	return fallback
-}
-
 func sanitizeStatName(stat string) string {
 	if stat == "" {
 		return "unknown"
@@ -396,6 +706,9 @@ func canonicalDocName(stat string) string {
 	if spec, ok := metricSpecs[stat]; ok && spec.DocName != "" {
 		return spec.DocName
 	}
+	if docName, ok := driverDocNames[stat]; ok {
+		return docName
+	}
 	sanitized := sanitizeStatName(stat)
 	if spec, ok := metricSpecs[sanitized]; ok && spec.DocName != "" {
 		return spec.DocName
@@ -415,53 +728,140 @@ func New(provider Provider, logger *slog.Logger, opts ...Option) *RdmaCollector
 	c := &RdmaCollector{
 		provider: provider,
 		logger:   logger,
-		portInfoDesc: prometheus.NewDesc(
-			"rdma_port_info",
-			"RDMA port metadata exported as labels.",
-			[]string{
-				"device", "port",
-				"link_layer", "state", "phys_state", "link_width", "link_speed",
-				// SR-IOV VF/PF identification labels.
-				// pci_addr matches the pciAddr label in sriov_kubepoddevice, enabling join queries.
-				"pci_addr",
-				// is_vf is "true" for Virtual Functions, "false" for Physical Functions.
-				"is_vf",
-				// pf_device is the IB device name of the parent PF (e.g. "mlx5_0").
-				// Empty for PF devices.
-				"pf_device",
-			},
+		deviceInfoDesc: prometheus.NewDesc(
+			"rdma_device_info",
+			"Device-level metadata of an RDMA device from /sys/class/infiniband/<dev>.",
+			[]string{"device", "fw_ver", "board_id", "hca_type", "node_guid", "sys_image_guid", "node_desc", "node_type", "vendor"},
 			nil,
 		),
-		rocePFCPauseFramesDesc: prometheus.NewDesc(
-			"rdma_roce_pfc_pause_frames_total",
-			"RoCEv2 PFC pause frame counter sourced from ethtool stats.",
-			[]string{"device", "port", "netdev", "direction", "priority"},
+		ipv6FlowLabelDesc: prometheus.NewDesc(
+			"rdma_ipv6_flowlabel_sysctl_info",
+			"The net.ipv6 flow label sysctls of the node, exported as labels. They apply to the kernel's IPv6 sockets, not to the UDP source port of RoCEv2 traffic. Empty labels mean the sysctl is not available.",
+			[]string{"auto_flowlabels", "flowlabel_state_ranges", "flowlabel_reflect"},
 			nil,
 		),
-		rocePFCPauseDurationDesc: prometheus.NewDesc(
-			"rdma_roce_pfc_pause_duration_total",
-			"RoCEv2 PFC pause duration counter sourced from ethtool stats.",
-			[]string{"device", "port", "netdev", "direction", "priority"},
+		schemaVersion: SchemaV1,
+		schemaInfoDesc: prometheus.NewDesc(
+			"rdma_exporter_schema_info",
+			"Metric schema version the exporter serves, selected with --metrics.schema.",
+			[]string{"version"},
 			nil,
 		),
-		rocePFCPauseTransitionsDesc: prometheus.NewDesc(
-			"rdma_roce_pfc_pause_transitions_total",
-			"RoCEv2 PFC pause transition counter sourced from ethtool stats.",
-			[]string{"device", "port", "netdev", "direction", "priority"},
+		collectorEnabledDesc: prometheus.NewDesc(
+			"rdma_exporter_collector_enabled",
+			"Whether an optional part of the RDMA collector is enabled at runtime (1) or not (0).",
+			[]string{"collector"},
 			nil,
 		),
 		scrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{
 			Name: "rdma_scrape_errors_total",
 			Help: "Total number of errors encountered while scraping RDMA sysfs.",
 		}),
+		lockWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "rdma_exporter_collect_lock_wait_seconds",
+			Help:    "Time scrapes waited for another scrape to finish before collecting.",
+			Buckets: lockBuckets,
+		}),
+		lockHold: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "rdma_exporter_collect_lock_hold_seconds",
+			Help:    "Time scrapes held the collector exclusively, making concurrent scrapes wait. A scrape is observed when it ends, so it shows up in the next scrape.",
+			Buckets: lockBuckets,
+		}),
+		deviceReadRetriesDesc: prometheus.NewDesc(
+			"rdma_device_read_retries_total",
+			"Number of times reading an RDMA device from sysfs was retried after a transient error such as EBUSY.",
+			[]string{"device"},
+			nil,
+		),
+		deviceReadErrorsDesc: prometheus.NewDesc(
+			"rdma_device_read_errors_total",
+			"Number of reads of an RDMA device from sysfs that failed after all retries.",
+			[]string{"device"},
+			nil,
+		),
+		warningsDesc: prometheus.NewDesc(
+			"rdma_exporter_warnings_total",
+			"Number of non-fatal anomalies met while collecting, such as unparsable counter files or undocumented counters, by type.",
+			[]string{"type"},
+			nil,
+		),
 		rocePFCScrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{
 			Name: "rdma_roce_pfc_scrape_errors_total",
 			Help: "Total number of errors encountered while scraping RoCEv2 PFC ethtool stats.",
 		}),
+		startTimeDesc: prometheus.NewDesc(
+			"rdma_exporter_start_time_seconds",
+			"Unix time at which the exporter started.",
+			nil,
+			nil,
+		),
+		lastSuccessDesc: prometheus.NewDesc(
+			"rdma_last_successful_collect_timestamp_seconds",
+			"Unix time of the last collection that read RDMA devices without error. Absent until the first success.",
+			nil,
+			nil,
+		),
+		devicesDesc: prometheus.NewDesc(
+			"rdma_devices",
+			"Number of RDMA devices found by the last collection, after exclusions.",
+			nil,
+			nil,
+		),
+		portsDesc: prometheus.NewDesc(
+			"rdma_ports",
+			"Number of ports of the RDMA devices found by the last collection, by port state.",
+			[]string{"state"},
+			nil,
+		),
+		counterUnitDesc: prometheus.NewDesc(
+			"rdma_counter_unit_info",
+			"Unit of RDMA counters whose value is not a plain event count.",
+			[]string{"counter", "unit"},
+			nil,
+		),
+		deviceSilencedDesc: prometheus.NewDesc(
+			"rdma_device_silenced",
+			"Constant 1 for every device excluded from collection by an active silence.",
+			[]string{"device"},
+			nil,
+		),
+		pcieLimitedDesc: prometheus.NewDesc(
+			"rdma_device_pcie_limited",
+			"Whether the negotiated PCIe link bandwidth is below the combined line rate of the device's active ports (1) or not (0).",
+			[]string{"device"},
+			nil,
+		),
+		deepScanTimestampDesc: prometheus.NewDesc(
+			"rdma_exporter_deep_scan_timestamp_seconds",
+			"Unix time of the last deep scan, whose results are included in every scrape.",
+			nil,
+			nil,
+		),
+		pcieAERErrorsDesc: prometheus.NewDesc(
+			"rdma_device_pcie_aer_errors_total",
+			"PCIe Advanced Error Reporting counters of the device's PCI function, as read by the last deep scan.",
+			[]string{"device", "severity", "error"},
+			nil,
+		),
+		degradedModeDesc: prometheus.NewDesc(
+			"rdma_exporter_degraded_mode",
+			"Whether the collector skips optional work because recent scrapes approached the scrape timeout (1) or not (0).",
+			nil,
+			nil,
+		),
+		labels:           newLabelCache(),
+		now:              time.Now,
 		portStatMetrics:  make(map[string]metricEntry),
 		portStatLookup:   make(map[string]string),
 		portHwMetrics:    make(map[string]metricEntry),
 		portHwStatLookup: make(map[string]string),
+
+		deviceHwMetrics:    make(map[string]metricEntry),
+		deviceHwStatLookup: make(map[string]string),
+		vlHwMetrics:        make(map[string]metricEntry),
+		vlHwStatLookup:     make(map[string]string),
+		gaugeCounters:      make(map[string]struct{}),
+		recoveryBursts:     recoveryBurstPolicy{threshold: defaultRecoveryBurstThreshold, window: defaultRecoveryBurstWindow},
 	}
 
 	for _, opt := range opts {
@@ -469,12 +869,278 @@ func New(provider Provider, logger *slog.Logger, opts ...Option) *RdmaCollector
 			opt(c)
 		}
 	}
+	c.initPortDescs()
+	c.startTime = c.now()
+	if c.state != nil {
+		c.state.recoveryBursts = c.recoveryBursts
+	}
+
+	if c.emitZeros {
+		c.zeroStats = make([]string, 0, len(metricSpecs))
+		for stat := range metricSpecs {
+			c.zeroStats = append(c.zeroStats, stat)
+		}
+		slices.Sort(c.zeroStats)
+	}
 
 	c.storeContext(context.Background())
 
 	return c
 }
 
+// initPortDescs builds the descriptors of per-port series. It runs after the
+// options are applied, since WithRailLabels and WithPortRoles add a label to
+// all of them.
+func (c *RdmaCollector) initPortDescs() {
+	c.portInfoDesc = prometheus.NewDesc(
+		"rdma_port_info",
+		"RDMA port metadata exported as labels.",
+		c.portLabelNames(
+			"link_layer", "state", "phys_state", "link_width", "link_speed",
+			// SR-IOV VF/PF identification labels.
+			// pci_addr matches the pciAddr label in sriov_kubepoddevice, enabling join queries.
+			"pci_addr",
+			// is_vf is "true" for Virtual Functions, "false" for Physical Functions.
+			"is_vf",
+			// pf_device is the IB device name of the parent PF (e.g. "mlx5_0").
+			// Empty for PF devices.
+			"pf_device",
+		),
+		nil,
+	)
+	// The fabric is exported on its own series, so rdma_port_info keeps its
+	// label set and existing joins on it keep working.
+	c.portFabricDesc = prometheus.NewDesc(
+		"rdma_port_fabric_info",
+		"Fabric a port is attached to: the IB subnet prefix or RoCE GID prefix, distinguishing rails on multi-fabric nodes.",
+		c.portLabelNames("fabric"),
+		nil,
+	)
+	c.portLIDDesc = prometheus.NewDesc(
+		"rdma_port_lid",
+		"Local identifier (LID) the subnet manager assigned to an InfiniBand port.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.portSMLIDDesc = prometheus.NewDesc(
+		"rdma_port_sm_lid",
+		"LID of the subnet manager managing an InfiniBand port; changes when another SM takes over.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.portLMCDesc = prometheus.NewDesc(
+		"rdma_port_lmc",
+		"LID mask control of an InfiniBand port: the port answers to 2^lmc LIDs starting at rdma_port_lid.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.portCapMaskDesc = prometheus.NewDesc(
+		"rdma_port_cap_mask",
+		"Capability mask (PortInfo:CapabilityMask) of an InfiniBand port, as the integer value of cap_mask.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.portStateDesc = prometheus.NewDesc(
+		"rdma_port_state",
+		"Logical port state as the numeric value of the sysfs state file: 0=NOP, 1=DOWN, 2=INIT, 3=ARMED, 4=ACTIVE, 5=ACTIVE_DEFER.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.portPhysStateDesc = prometheus.NewDesc(
+		"rdma_port_phys_state",
+		"Physical port state as the numeric value of the sysfs phys_state file: 1=SLEEP, 2=POLLING, 3=DISABLED, 4=PORT_CONFIGURATION_TRAINING, 5=LINK_UP, 6=LINK_ERROR_RECOVERY, 7=PHY_TEST.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.portLinkSpeedDesc = prometheus.NewDesc(
+		"rdma_port_link_speed_bps",
+		"Link rate of the port in bits per second, parsed from the sysfs rate file (e.g. \"100 Gb/sec (4X EDR)\").",
+		c.portLabelNames(),
+		nil,
+	)
+	c.portLinkWidthDesc = prometheus.NewDesc(
+		"rdma_port_link_width_lanes",
+		"Number of lanes of the port's link, parsed from its width (e.g. \"4X\").",
+		c.portLabelNames(),
+		nil,
+	)
+	c.rocePFCPauseFramesDesc = prometheus.NewDesc(
+		"rdma_roce_pfc_pause_frames_total",
+		"RoCEv2 PFC pause frame counter sourced from ethtool stats.",
+		c.portLabelNames("netdev", "direction", "priority"),
+		nil,
+	)
+	c.rocePFCPauseDurationDesc = prometheus.NewDesc(
+		"rdma_roce_pfc_pause_duration_total",
+		"RoCEv2 PFC pause duration counter sourced from ethtool stats.",
+		c.portLabelNames("netdev", "direction", "priority"),
+		nil,
+	)
+	c.rocePFCPauseTransitionsDesc = prometheus.NewDesc(
+		"rdma_roce_pfc_pause_transitions_total",
+		"RoCEv2 PFC pause transition counter sourced from ethtool stats.",
+		c.portLabelNames("netdev", "direction", "priority"),
+		nil,
+	)
+	c.netDevLinkSpeedDesc = prometheus.NewDesc(
+		"rdma_netdev_link_speed_bps",
+		"Negotiated link speed of the netdev backing a RoCE port in bits per second, from ethtool.",
+		c.portLabelNames("netdev"),
+		nil,
+	)
+	c.netDevLinkDuplexDesc = prometheus.NewDesc(
+		"rdma_netdev_link_full_duplex",
+		"Whether the netdev backing a RoCE port runs full duplex (1) or half duplex (0), from ethtool.",
+		c.portLabelNames("netdev"),
+		nil,
+	)
+	c.netDevLinkAutonegDesc = prometheus.NewDesc(
+		"rdma_netdev_link_autoneg",
+		"Whether autonegotiation is enabled (1) on the netdev backing a RoCE port, from ethtool.",
+		c.portLabelNames("netdev"),
+		nil,
+	)
+	c.netDevLinkChangesDesc = prometheus.NewDesc(
+		"rdma_netdev_link_settings_changes_total",
+		"Number of times the netdev's negotiated link setting changed between scrapes since the exporter started.",
+		c.portLabelNames("netdev", "setting"),
+		nil,
+	)
+	c.portIdleDesc = prometheus.NewDesc(
+		"rdma_port_idle_seconds",
+		"Seconds since the port's port_xmit_data or port_rcv_data counter last changed. Only exported in stateful mode.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.portRetransmitRatioDesc = prometheus.NewDesc(
+		"rdma_port_retransmit_ratio",
+		"Retransmission events (packet_seq_err, implied_nak_seq_err, local_ack_timeout_err) per port_xmit_packets over the last scrape window. Only exported in stateful mode.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.portRecoveryBurstsDesc = prometheus.NewDesc(
+		"rdma_port_link_recovery_bursts_total",
+		"Bursts of link_error_recovery increments on the port, with at least the burst threshold of recoveries within the burst window, since the exporter started. Only exported in stateful mode.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.portRecoveryBurstSizeDesc = prometheus.NewDesc(
+		"rdma_port_link_recovery_burst_size",
+		"Link error recoveries in the port's current or latest burst, 0 before the first one. Only exported in stateful mode.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.portCounterRateDesc = prometheus.NewDesc(
+		"rdma_port_counter_rate",
+		"Distribution of the per-second rate of a counter between consecutive scrapes over the last scrapes. Only exported for counters selected for rate jitter tracking.",
+		c.portLabelNames("counter"),
+		nil,
+	)
+	c.portUtilizationDesc = prometheus.NewDesc(
+		"rdma_port_utilization_ratio",
+		"Share of the port's link rate used over the utilization window, from port_xmit_data (direction tx) and port_rcv_data (direction rx).",
+		c.portLabelNames("direction"),
+		nil,
+	)
+	c.portGIDInfoDesc = prometheus.NewDesc(
+		"rdma_port_gid_info",
+		"Populated entry of the port's GID table with its type (\"IB/RoCE v1\" or \"RoCE v2\") and netdev, from /sys/class/infiniband/<dev>/ports/<port>/gids and gid_attrs.",
+		c.portLabelNames("index", "gid", "type", "netdev"),
+		nil,
+	)
+	c.initByteCounterDescs()
+	c.portPKeyInfoDesc = prometheus.NewDesc(
+		"rdma_port_pkey_info",
+		"Populated entry of the port's partition key table, from /sys/class/infiniband/<dev>/ports/<port>/pkeys. Bit 15 of pkey is set for full and clear for limited membership.",
+		c.portLabelNames("index", "pkey"),
+		nil,
+	)
+	c.roceQoSInfoDesc = prometheus.NewDesc(
+		"rdma_roce_qos_info",
+		"QoS configuration of a RoCE port: the trust state of its netdev, the default ToS and DSCP of RDMA CM connections and the traffic class forced by MLNX_OFED. Labels are empty when unset.",
+		c.portLabelNames("netdev", "trust", "default_tos", "default_dscp", "traffic_class"),
+		nil,
+	)
+	c.roceECNEnabledDesc = prometheus.NewDesc(
+		"rdma_roce_ecn_enabled",
+		"Whether ECN is enabled (1) or not (0) for a priority of the netdev of a RoCE port, at the notification point (np) or reaction point (rp).",
+		c.portLabelNames("point", "priority"),
+		nil,
+	)
+	c.roceDefaultVersionDesc = prometheus.NewDesc(
+		"rdma_roce_default_version",
+		"RoCE version (1 or 2) RDMA CM connections of a RoCE port use, from configfs rdma_cm default_roce_mode.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.roceDefaultToSDesc = prometheus.NewDesc(
+		"rdma_roce_default_tos",
+		"ToS byte of RDMA CM connections of a RoCE port that do not set one, from configfs rdma_cm default_roce_tos.",
+		c.portLabelNames(),
+		nil,
+	)
+	c.roceDCQCNParamDesc = prometheus.NewDesc(
+		"rdma_roce_dcqcn_parameter",
+		"DCQCN congestion control parameter of the netdev of a RoCE port at the notification point (np) or reaction point (rp), in the parameter's own unit.",
+		c.portLabelNames("point", "parameter"),
+		nil,
+	)
+	c.pfcPriorityEnabledDesc = prometheus.NewDesc(
+		"rdma_pfc_priority_enabled",
+		"Whether PFC is enabled (1) or not (0) for a priority of the netdev of a RoCE port, from dcbnl.",
+		c.portLabelNames("netdev", "priority"),
+		nil,
+	)
+	c.pfcDelayDesc = prometheus.NewDesc(
+		"rdma_pfc_delay_bits",
+		"PFC delay allowance for the round-trip delay of the link of a RoCE port's netdev, in bits, from dcbnl.",
+		c.portLabelNames("netdev"),
+		nil,
+	)
+	c.etsBandwidthDesc = prometheus.NewDesc(
+		"rdma_ets_bandwidth_percent",
+		"ETS share of transmit bandwidth of a traffic class of the netdev of a RoCE port, from dcbnl.",
+		c.portLabelNames("netdev", "tc"),
+		nil,
+	)
+	c.etsPriorityTCDesc = prometheus.NewDesc(
+		"rdma_ets_priority_traffic_class",
+		"Traffic class a priority of the netdev of a RoCE port is mapped to by ETS, from dcbnl.",
+		c.portLabelNames("netdev", "priority"),
+		nil,
+	)
+	c.qpCounterDesc = prometheus.NewDesc(
+		"rdma_qp_counter_total",
+		"Hardware counter of the queue pairs bound to a kernel statistics counter. lqpn is only set when a single QP is bound.",
+		c.portLabelNames("counter_id", "qp_type", "lqpn", "counter"),
+		nil,
+	)
+	c.qpCounterQPsDesc = prometheus.NewDesc(
+		"rdma_qp_counter_qps",
+		"Number of queue pairs bound to a kernel statistics counter.",
+		c.portLabelNames("counter_id", "mode", "qp_type"),
+		nil,
+	)
+	c.qpCountersDroppedDesc = prometheus.NewDesc(
+		"rdma_qp_counters_dropped",
+		"Number of QP counters left out of the last scrape by the QP counter limit.",
+		nil,
+		nil,
+	)
+	c.portPacketsDesc = prometheus.NewDesc(
+		"rdma_port_packets_total",
+		"Unicast and multicast packets sent (direction=\"tx\") or received (direction=\"rx\") by the port, from the port_{unicast,multicast}_{xmit,rcv}_packets counters. Only exported in schema v2.",
+		c.portLabelNames("direction", "cast"),
+		nil,
+	)
+	c.portTickDurationDesc = prometheus.NewDesc(
+		"rdma_port_tick_duration_seconds",
+		"Length of one tick of tick-based counters such as port_xmit_wait. Only exported when known.",
+		c.portLabelNames(),
+		nil,
+	)
+}
+
 func (c *RdmaCollector) storeContext(ctx context.Context) {
 	c.ctxValue.Store(&ctx)
 }
@@ -487,6 +1153,44 @@ func WithNetDevStatsProvider(provider NetDevStatsProvider) Option {
 	}
 }
 
+// WithFlowLabelSettings configures a provider used to export the node's IPv6
+// flow label sysctls.
+func WithFlowLabelSettings(provider FlowLabelProvider) Option {
+	return func(c *RdmaCollector) {
+		c.flowLabelProvider = provider
+	}
+}
+
+// WithEmitZeros exports explicit zero series for counters known in
+// metricSpecs but missing on a port, so heterogeneous hardware yields the same
+// set of series.
+func WithEmitZeros() Option {
+	return func(c *RdmaCollector) {
+		c.emitZeros = true
+	}
+}
+
+// WithSuppressUnchanged omits counter series whose value has not changed for
+// after consecutive scrapes, re-emitting them every keepAlive scrapes (0
+// disables keep-alives). This is experimental: suppressed series go stale in
+// Prometheus, so queries must tolerate gaps.
+func WithSuppressUnchanged(after, keepAlive int) Option {
+	return func(c *RdmaCollector) {
+		if after < 1 {
+			return
+		}
+		c.suppress = newSuppressTracker(after, keepAlive)
+	}
+}
+
+// WithStatefulMode enables tracking of per-port state across scrapes, which
+// derived metrics such as rdma_port_idle_seconds depend on.
+func WithStatefulMode() Option {
+	return func(c *RdmaCollector) {
+		c.state = newStateTracker()
+	}
+}
+
 // SetContext updates the context used by the next Collect invocation.
 func (c *RdmaCollector) SetContext(ctx context.Context) {
 	if ctx == nil {
@@ -500,122 +1204,661 @@ func (c *RdmaCollector) ResetContext() {
 	c.storeContext(context.Background())
 }
 
+// Devices returns a fresh device snapshot from the underlying provider.
+// Callers outside the Prometheus scrape path (e.g. JSON APIs) use it so they
+// observe the same device exclusions as the exposition. Provider errors such
+// as rdma.ErrNoDevices are passed through for callers to branch on.
+func (c *RdmaCollector) Devices(ctx context.Context) ([]rdma.Device, error) {
+	devices, err := c.provider.Devices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.dropSilenced(devices), nil
+}
+
 // Describe implements prometheus.Collector.
 func (c *RdmaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.deviceInfoDesc
+	ch <- c.deviceSilencedDesc
+	if c.nodeDescMismatchDesc != nil {
+		ch <- c.nodeDescMismatchDesc
+	}
+	if c.expectationsProvider != nil {
+		ch <- c.expectedDesc
+		ch <- c.expectationViolatedDesc
+	}
+	if c.duplicateDesc != nil {
+		ch <- c.duplicateDesc
+	}
+	for _, desc := range c.resourceDescs {
+		ch <- desc
+	}
+	if c.deviceLimitProvider != nil {
+		ch <- c.deviceLimitDesc
+	}
+	for _, desc := range c.processResourceDescs {
+		ch <- desc
+	}
+	if c.gidTableProvider != nil {
+		ch <- c.portGIDInfoDesc
+	}
+	if c.pkeyTableProvider != nil {
+		ch <- c.portPKeyInfoDesc
+	}
+	if c.ueventProvider != nil {
+		ch <- c.deviceUEventsDesc
+	}
+	if c.roceConfigProvider != nil {
+		ch <- c.roceQoSInfoDesc
+		ch <- c.roceECNEnabledDesc
+		ch <- c.roceDCQCNParamDesc
+		ch <- c.roceDefaultVersionDesc
+		ch <- c.roceDefaultToSDesc
+	}
+	if c.dcbProvider != nil {
+		ch <- c.pfcPriorityEnabledDesc
+		ch <- c.pfcDelayDesc
+		ch <- c.etsBandwidthDesc
+		ch <- c.etsPriorityTCDesc
+	}
+	for _, desc := range c.byteCounterDescs {
+		ch <- desc
+	}
+	if c.qpCounterProvider != nil {
+		ch <- c.qpCounterDesc
+		ch <- c.qpCounterQPsDesc
+		ch <- c.qpCountersDroppedDesc
+	}
 	ch <- c.portInfoDesc
+	ch <- c.portFabricDesc
+	ch <- c.portLIDDesc
+	ch <- c.portStateDesc
+	ch <- c.portPhysStateDesc
+	ch <- c.portLinkSpeedDesc
+	ch <- c.portLinkWidthDesc
+	ch <- c.portSMLIDDesc
+	ch <- c.portLMCDesc
+	ch <- c.portCapMaskDesc
+	ch <- c.pcieLimitedDesc
+	ch <- c.counterUnitDesc
+	ch <- c.startTimeDesc
+	ch <- c.lastSuccessDesc
+	ch <- c.devicesDesc
+	ch <- c.portsDesc
+	ch <- c.portTickDurationDesc
 	ch <- c.rocePFCPauseFramesDesc
 	ch <- c.rocePFCPauseDurationDesc
 	ch <- c.rocePFCPauseTransitionsDesc
-	c.scrapeErrors.Describe(ch)
-	c.rocePFCScrapeErrors.Describe(ch)
-
-	c.collectMu.Lock()
-	statDescs := make([]*prometheus.Desc, 0, len(c.portStatMetrics))
-	for _, entry := range c.portStatMetrics {
-		statDescs = append(statDescs, entry.desc)
+	ch <- c.schemaInfoDesc
+	if c.schemaVersion >= SchemaV2 {
+		ch <- c.portPacketsDesc
 	}
-	hwDescs := make([]*prometheus.Desc, 0, len(c.portHwMetrics))
-	for _, entry := range c.portHwMetrics {
-		hwDescs = append(hwDescs, entry.desc)
+	if c.state != nil {
+		ch <- c.portIdleDesc
+		ch <- c.portRetransmitRatioDesc
+		if c.recoveryBursts.threshold > 0 {
+			ch <- c.portRecoveryBurstsDesc
+			ch <- c.portRecoveryBurstSizeDesc
+		}
 	}
-	c.collectMu.Unlock()
-
-	for _, desc := range statDescs {
-		ch <- desc
+	if c.utilization != nil {
+		ch <- c.portUtilizationDesc
 	}
-	for _, desc := range hwDescs {
-		ch <- desc
+	if c.jitter != nil {
+		ch <- c.portCounterRateDesc
+	}
+	if c.topCounters != nil {
+		ch <- c.topCounters.desc
+	}
+	if c.deepScanProvider != nil {
+		ch <- c.deepScanTimestampDesc
+		ch <- c.pcieAERErrorsDesc
+	}
+	if c.budget != nil {
+		ch <- c.degradedModeDesc
+	}
+	if c.snapshot != nil {
+		ch <- c.snapshotAgeDesc
+		ch <- c.snapshotReusesDesc
 	}
+	if c.warmupDesc != nil {
+		ch <- c.warmupDesc
+	}
+	if c.collectorTimeoutsDesc != nil {
+		ch <- c.collectorTimeoutsDesc
+	}
+	ch <- c.collectorEnabledDesc
+	ch <- c.ipv6FlowLabelDesc
+	ch <- c.netDevLinkSpeedDesc
+	ch <- c.netDevLinkDuplexDesc
+	ch <- c.netDevLinkAutonegDesc
+	ch <- c.netDevLinkChangesDesc
+	c.scrapeErrors.Describe(ch)
+	if _, ok := c.provider.(ReadStatsProvider); ok {
+		ch <- c.deviceReadRetriesDesc
+		ch <- c.deviceReadErrorsDesc
+	}
+	ch <- c.warningsDesc
+	c.rocePFCScrapeErrors.Describe(ch)
+	c.lockWait.Describe(ch)
+	c.lockHold.Describe(ch)
+	c.describeDynamicDescs(ch)
 }
 
 // Collect implements prometheus.Collector.
 func (c *RdmaCollector) Collect(ch chan<- prometheus.Metric) {
+	waitStart := time.Now()
 	c.collectMu.Lock()
-	defer c.collectMu.Unlock()
+	locked := time.Now()
+	c.lockWait.Observe(locked.Sub(waitStart).Seconds())
+	defer func() {
+		c.lockHold.Observe(time.Since(locked).Seconds())
+		c.collectMu.Unlock()
+	}()
+	defer c.publishDynamicDescs()
 
 	ctx := context.Background()
 	if stored := c.ctxValue.Load(); stored != nil {
 		ctx = *stored
 	}
 
-	devices, err := c.provider.Devices(ctx)
+	if c.budget != nil {
+		start := c.now()
+		defer func() { c.observeScrape(c.now().Sub(start)) }()
+	}
+	degraded := c.degraded()
+	warming := c.warmingUp(c.now())
+
+	c.collectSchemaInfo(ch)
+	c.collectEnabledCollectors(ch)
+	c.collectDegradedMode(ch)
+	c.collectWarmup(ch, warming)
+	flowLabelCtx, flowLabelDone := c.withCollectorTimeout(ctx, "ipv6_flowlabel")
+	c.collectFlowLabelSettings(flowLabelCtx, ch)
+	flowLabelDone()
+	c.collectDeepScan(ch)
+	c.collectCounterUnits(ch)
+	c.collectUEvents(ch)
+	if !degraded {
+		vportCtx, vportDone := c.withCollectorTimeout(ctx, "vport")
+		c.collectVPortMetrics(vportCtx, ch)
+		vportDone()
+	}
+
+	countersCtx, countersDone := c.withCollectorTimeout(ctx, "counters")
+	devices, readAt, err := c.snapshotDevices(countersCtx, degraded)
+	countersTimedOut := err != nil && errors.Is(countersCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+	countersDone()
+	if countersTimedOut {
+		// Like any other collector, timed-out counters only cut off
+		// themselves: the scrape serves the devices of the last full scrape,
+		// or none, and the timeout is counted.
+		devices, readAt, _ = c.last.load(c.now(), math.MaxInt64)
+		if devices == nil {
+			devices, readAt = []rdma.Device{}, c.now()
+		}
+		err = nil
+	}
 	if err != nil {
-		if ctx.Err() != nil {
+		switch {
+		case ctx.Err() != nil:
 			c.logger.Warn("rdma scrape aborted by context", "err", ctx.Err())
-		} else {
+		case errors.Is(err, rdma.ErrPermission):
+			c.logger.Warn("rdma scrape failed: sysfs is not readable by the exporter", "err", err)
+		default:
 			c.logger.Warn("rdma scrape failed", "err", err)
 		}
 		c.scrapeErrors.Inc()
 		c.scrapeErrors.Collect(ch)
+		c.collectReadStats(ch)
+		c.collectWarnings(ch)
+		c.collectCollectorTimeouts(ch)
+		c.collectLiveness(ch)
+		c.lockWait.Collect(ch)
+		c.lockHold.Collect(ch)
 		return
 	}
 
+	devices = c.dropSilenced(devices)
+	if !degraded && !countersTimedOut {
+		c.last.store(devices, readAt)
+	}
+	c.collectSilences(ch)
+	devices = c.dedupDevices(devices, !degraded)
+	c.collectDuplicates(ch)
+	c.collectSnapshot(ch)
+
 	netDevStatsCache := make(map[string]netDevStatsCacheEntry)
+	linkSeen := make(map[string]bool)
+	netDevStatistics := make(map[string]netDevStatsCacheEntry)
+	// Derived metrics are computed at the time of the read, which is earlier
+	// than the scrape when the snapshot is reused.
+	now := readAt
+	if !countersTimedOut {
+		c.lastSuccess = now
+	}
+	c.collectLiveness(ch)
+	ch <- prometheus.MustNewConstMetric(c.devicesDesc, prometheus.GaugeValue, float64(len(devices)))
+	if c.state != nil {
+		c.state.begin(warming)
+		defer c.state.prune()
+	}
+	if c.suppress != nil {
+		c.suppress.begin()
+		defer c.suppress.prune()
+	}
+	if c.utilization != nil {
+		c.utilization.begin()
+		defer c.utilization.prune()
+	}
+	if c.jitter != nil {
+		c.jitter.begin()
+		defer c.jitter.prune()
+	}
+	if c.topCounters != nil {
+		c.topCounters.begin()
+		defer c.topCounters.prune()
+	}
+	// Degraded scrapes skip the link settings, so they keep the netdevs.
+	if c.links != nil && !degraded {
+		c.links.begin()
+		defer c.links.prune()
+	}
+	if !degraded {
+		c.updatePortRoles(ctx)
+	}
+	c.labels.begin()
+	defer c.labels.prune()
+
+	if !degraded {
+		c.collectPortCounts(ch, devices)
+		c.collectExpectations(ch, devices)
+		resourcesCtx, resourcesDone := c.withCollectorTimeout(ctx, "resources")
+		c.collectResources(resourcesCtx, ch, devices)
+		resourcesDone()
+		limitsCtx, limitsDone := c.withCollectorTimeout(ctx, "device_limits")
+		c.collectDeviceLimits(limitsCtx, ch, devices)
+		limitsDone()
+		processCtx, processDone := c.withCollectorTimeout(ctx, "resources_by_process")
+		c.collectProcessResources(processCtx, ch, devices)
+		processDone()
+		qpCtx, qpDone := c.withCollectorTimeout(ctx, "qp_counters")
+		c.collectQPCounters(qpCtx, ch, devices)
+		qpDone()
+		gidCtx, gidDone := c.withCollectorTimeout(ctx, "gid_table")
+		c.collectGIDTable(gidCtx, ch, devices)
+		gidDone()
+		pkeyCtx, pkeyDone := c.withCollectorTimeout(ctx, "pkey_table")
+		c.collectPKeyTable(pkeyCtx, ch, devices)
+		pkeyDone()
+		roceConfigCtx, roceConfigDone := c.withCollectorTimeout(ctx, "roce_config")
+		c.collectRoCEConfig(roceConfigCtx, ch, devices)
+		roceConfigDone()
+	}
+
+	// The per-port collectors are bounded over all ports of the scrape.
+	pfcCtx, pfcDone := c.withCollectorTimeout(ctx, "roce_pfc")
+	linkCtx, linkDone := c.withCollectorTimeout(ctx, "netdev_link")
+	netDevStatsCtx, netDevStatsDone := c.withCollectorTimeout(ctx, "netdev_statistics")
+	ethtoolStatsCtx, ethtoolStatsDone := c.withCollectorTimeout(ctx, "netdev_ethtool")
+	dcbCtx, dcbDone := c.withCollectorTimeout(ctx, "dcb")
 
 	for _, device := range devices {
 		deviceStart := time.Now()
+		if !degraded {
+			ch <- prometheus.MustNewConstMetric(
+				c.deviceInfoDesc,
+				prometheus.GaugeValue,
+				1,
+				device.Name,
+				device.Attributes.FWVer,
+				device.Attributes.BoardID,
+				device.Attributes.HCAType,
+				device.Attributes.NodeGUID,
+				device.Attributes.SysImageGUID,
+				device.Attributes.NodeDesc,
+				device.Attributes.NodeType,
+				rdma.GUIDVendor(device.Attributes.NodeGUID),
+			)
+			c.collectNodeDescMismatch(ch, device)
+			for _, name := range sortedKeys(device.HwStats) {
+				ch <- prometheus.MustNewConstMetric(
+					c.deviceHwMetricDesc(name, device.Attributes.Driver),
+					c.valueType(name),
+					float64(device.HwStats[name]),
+					device.Name,
+				)
+			}
+		}
 		portIDStrings := make([]string, len(device.Ports))
 		for i, port := range device.Ports {
-			portID := strconv.Itoa(port.ID)
+			labels := c.labels.port(device.Name, port.ID)
+			portID := labels.port
 			portIDStrings[i] = portID
 
 			if len(port.Stats) > 0 {
 				names := sortedKeys(port.Stats)
 				for _, name := range names {
-					value := float64(port.Stats[name])
-					desc := c.statMetricDesc(name)
-					ch <- prometheus.MustNewConstMetric(
-						desc,
-						prometheus.CounterValue,
-						value,
-						device.Name,
-						portID,
-					)
+					if c.suppress != nil && !c.suppress.emit(device.Name, port.ID, name, port.Stats[name]) {
+						continue
+					}
+					if cc, ok := c.castCounter(name); ok {
+						c.collectCastCounter(ch, labels, cc, port.Stats[name])
+						continue
+					}
+					ch <- &portCounter{
+						desc:   c.statMetricDesc(name),
+						labels: labels.pairs,
+						value:  float64(port.Stats[name]),
+						gauge:  c.isGauge(name),
+					}
+					if desc, ok := c.byteCounterDescs[name]; ok {
+						ch <- &portCounter{
+							desc:   desc,
+							labels: labels.pairs,
+							value:  float64(port.Stats[name]) * 4,
+						}
+					}
 				}
 			}
 
-			if len(port.HwStats) > 0 {
+			if len(port.HwStats) > 0 && !degraded {
 				names := sortedKeys(port.HwStats)
 				for _, name := range names {
-					value := float64(port.HwStats[name])
-					desc := c.hwMetricDesc(name)
+					if c.suppress != nil && !c.suppress.emit(device.Name, port.ID, hwCounterKeyPrefix+name, port.HwStats[name]) {
+						continue
+					}
+					ch <- &portCounter{
+						desc:   c.hwMetricDesc(name, device.Attributes.Driver),
+						labels: labels.pairs,
+						value:  float64(port.HwStats[name]),
+						gauge:  c.isGauge(name),
+					}
+				}
+			}
+			if len(port.VLStats) > 0 && !degraded {
+				c.collectVLStats(ch, labels, device, port)
+			}
+
+			// The documented counters are InfiniBand and mlx5 ones, so a
+			// port without a counters directory, such as an EFA port, has
+			// none of them to stand in for. Degraded scrapes skip
+			// hw_counters, which must not read as counters reset to 0.
+			if c.emitZeros && port.Stats != nil && !degraded {
+				c.collectZeroStats(ch, labels, device, port)
+			}
+			c.collectTickDuration(ch, labels, port)
+
+			if c.state != nil {
+				// Keep tracking while warming up so the metrics are right
+				// once the window ends.
+				state := c.state.observe(device.Name, port, now)
+				if !warming {
+					ch <- prometheus.MustNewConstMetric(
+						c.portIdleDesc,
+						prometheus.GaugeValue,
+						now.Sub(state.lastChange).Seconds(),
+						labels.values()...,
+					)
+				}
+				if state.retransmitRatioOK && !warming {
 					ch <- prometheus.MustNewConstMetric(
-						desc,
-						prometheus.CounterValue,
-						value,
-						device.Name,
-						portID,
+						c.portRetransmitRatioDesc,
+						prometheus.GaugeValue,
+						state.retransmitRatio,
+						labels.values()...,
 					)
 				}
+				if c.recoveryBursts.threshold > 0 && !warming {
+					c.collectRecoveryBursts(ch, labels, state)
+				}
+			}
+
+			// Rates of the warm-up window would linger in the summary, so
+			// they are not recorded at all.
+			if c.jitter != nil && !warming {
+				c.collectRateJitter(ch, labels, device.Name, port, now)
+			}
+			if c.utilization != nil {
+				c.collectUtilization(ch, labels, device.Name, port, now, warming)
+			}
+			if c.topCounters != nil {
+				c.topCounters.observePort(device.Name, port, now)
+			}
+
+			if degraded {
+				continue
 			}
 
 			attr := port.Attributes
-			c.collectRoCEPFCMetrics(ctx, ch, device.Name, portID, attr, device.IsVF, netDevStatsCache)
+			c.collectRoCEPFCMetrics(pfcCtx, ch, labels, attr, device.IsVF, netDevStatsCache)
+			c.collectLinkSettings(linkCtx, ch, labels, attr, device.IsVF, linkSeen, warming)
+			c.collectNetDevStatistics(netDevStatsCtx, ch, labels, attr, netDevStatistics)
+			c.collectNetDevEthtoolStats(ethtoolStatsCtx, ch, labels, attr, device.IsVF, netDevStatsCache)
+			c.collectDCB(dcbCtx, ch, labels, attr, device.IsVF)
 
 			ch <- prometheus.MustNewConstMetric(
 				c.portInfoDesc,
 				prometheus.GaugeValue,
 				1,
-				device.Name,
-				portID,
-				attr.LinkLayer,
-				attr.State,
-				attr.PhysState,
-				attr.LinkWidth,
-				attr.LinkSpeed,
-				device.PCIAddr,
-				strconv.FormatBool(device.IsVF),
-				device.PFDevice,
+				labels.values(
+					attr.LinkLayer,
+					attr.State,
+					attr.PhysState,
+					attr.LinkWidth,
+					attr.LinkSpeed,
+					device.PCIAddr,
+					strconv.FormatBool(device.IsVF),
+					device.PFDevice,
+				)...,
 			)
+			if attr.Fabric != "" {
+				ch <- prometheus.MustNewConstMetric(
+					c.portFabricDesc,
+					prometheus.GaugeValue,
+					1,
+					labels.values(attr.Fabric)...,
+				)
+			}
+
+			c.collectPortState(ch, labels, attr)
+			c.collectPortLinkRate(ch, labels, attr)
+			c.collectPortLID(ch, labels, attr)
+		}
+		if !degraded {
+			c.collectPCIeLimited(ch, device)
 		}
 		c.logger.Debug("rdma device scraped",
 			"device", device.Name,
 			"ports", portIDStrings,
 			"duration", time.Since(deviceStart))
 	}
-
+	pfcDone()
+	linkDone()
+	netDevStatsDone()
+	ethtoolStatsDone()
+	dcbDone()
+
+	if c.topCounters != nil {
+		c.collectTopCounters(ch, warming)
+	}
 	c.scrapeErrors.Collect(ch)
+	c.collectReadStats(ch)
+	c.collectWarnings(ch)
+	c.collectCollectorTimeouts(ch)
 	c.rocePFCScrapeErrors.Collect(ch)
+	c.lockWait.Collect(ch)
+	c.lockHold.Collect(ch)
+}
+
+// collectPortState exports the port states rdma_port_info carries as labels
+// as numbers. Unknown states are left out.
+func (c *RdmaCollector) collectPortState(ch chan<- prometheus.Metric, labels *portLabels, attr rdma.PortAttributes) {
+	if state, ok := rdma.PortStateValue(attr.State); ok {
+		ch <- prometheus.MustNewConstMetric(c.portStateDesc, prometheus.GaugeValue, float64(state), labels.values()...)
+	}
+	if state, ok := rdma.PortPhysStateValue(attr.PhysState); ok {
+		ch <- prometheus.MustNewConstMetric(c.portPhysStateDesc, prometheus.GaugeValue, float64(state), labels.values()...)
+	}
+}
+
+// collectPortLinkRate exports the link speed and width rdma_port_info
+// carries as labels as numbers. Unparsable values are left out.
+func (c *RdmaCollector) collectPortLinkRate(ch chan<- prometheus.Metric, labels *portLabels, attr rdma.PortAttributes) {
+	if bps, ok := rdma.LinkRateBps(attr.LinkSpeed); ok {
+		ch <- prometheus.MustNewConstMetric(c.portLinkSpeedDesc, prometheus.GaugeValue, bps, labels.values()...)
+	}
+	if lanes, ok := rdma.LinkWidthLanes(attr.LinkWidth, attr.LinkSpeed); ok {
+		ch <- prometheus.MustNewConstMetric(c.portLinkWidthDesc, prometheus.GaugeValue, float64(lanes), labels.values()...)
+	}
+}
+
+// collectPortLID exports the addressing of InfiniBand ports. RoCE ports have
+// no LIDs; their lid file reads 0.
+func (c *RdmaCollector) collectPortLID(ch chan<- prometheus.Metric, labels *portLabels, attr rdma.PortAttributes) {
+	if !attr.HasLID || attr.LinkLayer != "InfiniBand" {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.portLIDDesc, prometheus.GaugeValue, float64(attr.LID), labels.values()...)
+	ch <- prometheus.MustNewConstMetric(c.portSMLIDDesc, prometheus.GaugeValue, float64(attr.SMLID), labels.values()...)
+	ch <- prometheus.MustNewConstMetric(c.portLMCDesc, prometheus.GaugeValue, float64(attr.LMC), labels.values()...)
+	ch <- prometheus.MustNewConstMetric(c.portCapMaskDesc, prometheus.GaugeValue, float64(attr.CapMask), labels.values()...)
+}
+
+// collectLiveness exports when the exporter started and when it last read
+// devices successfully, so restarts can be told apart from failing scrapes.
+func (c *RdmaCollector) collectLiveness(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.startTimeDesc, prometheus.GaugeValue, unixSeconds(c.startTime))
+	if !c.lastSuccess.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.lastSuccessDesc, prometheus.GaugeValue, unixSeconds(c.lastSuccess))
+	}
+}
+
+// collectPortCounts exports the number of ports per state. States come from
+// port attributes, so it is skipped in degraded mode.
+func (c *RdmaCollector) collectPortCounts(ch chan<- prometheus.Metric, devices []rdma.Device) {
+	counts := make(map[string]uint64)
+	for _, device := range devices {
+		for _, port := range device.Ports {
+			counts[port.Attributes.State]++
+		}
+	}
+	for _, state := range sortedKeys(counts) {
+		ch <- prometheus.MustNewConstMetric(c.portsDesc, prometheus.GaugeValue, float64(counts[state]), state)
+	}
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// enabledCollectors reports the runtime state of each optional part of the
+// collector. It reflects what is actually wired, so a flag whose backing
+// provider failed to initialize reports as disabled.
+func (c *RdmaCollector) enabledCollectors() []collectorState {
+	return []collectorState{
+		{name: "counters", enabled: true},
+		{name: "hw_counters", enabled: true},
+		{name: "roce_pfc", enabled: c.netDevStatsProvider != nil},
+		{name: "deep_scan", enabled: c.deepScanProvider != nil},
+		{name: "emit_zeros", enabled: c.emitZeros},
+		{name: "byte_counters", enabled: c.byteCounters},
+		{name: "netdev_link", enabled: c.linkSettingsProvider != nil},
+		{name: "netdev_statistics", enabled: c.netDevStatisticsProvider != nil},
+		{name: "netdev_ethtool", enabled: c.ethtoolStatsProvider != nil},
+		{name: "vport", enabled: c.representorProvider != nil},
+		{name: "ipv6_flowlabel", enabled: c.flowLabelProvider != nil},
+		{name: "resources", enabled: c.resourceProvider != nil},
+		{name: "device_limits", enabled: c.deviceLimitProvider != nil},
+		{name: "resources_by_process", enabled: c.processResourceProvider != nil},
+		{name: "qp_counters", enabled: c.qpCounterProvider != nil},
+		{name: "gid_table", enabled: c.gidTableProvider != nil},
+		{name: "pkey_table", enabled: c.pkeyTableProvider != nil},
+		{name: "uevents", enabled: c.ueventProvider != nil},
+		{name: "roce_config", enabled: c.roceConfigProvider != nil},
+		{name: "dcb", enabled: c.dcbProvider != nil},
+		{name: "stateful", enabled: c.state != nil},
+		{name: "suppress_unchanged", enabled: c.suppress != nil},
+		{name: "rate_jitter", enabled: c.jitter != nil},
+		{name: "utilization", enabled: c.utilization != nil},
+		{name: "top_counters", enabled: c.topCounters != nil},
+		{name: "adaptive_budget", enabled: c.budget != nil},
+	}
+}
+
+type collectorState struct {
+	name    string
+	enabled bool
+}
+
+func (c *RdmaCollector) collectEnabledCollectors(ch chan<- prometheus.Metric) {
+	if c.enabledMetrics == nil {
+		for _, state := range c.enabledCollectors() {
+			value := 0.0
+			if state.enabled {
+				value = 1
+			}
+			c.enabledMetrics = append(c.enabledMetrics, prometheus.MustNewConstMetric(c.collectorEnabledDesc, prometheus.GaugeValue, value, state.name))
+		}
+	}
+	for _, metric := range c.enabledMetrics {
+		ch <- metric
+	}
+}
+
+func (c *RdmaCollector) collectFlowLabelSettings(ctx context.Context, ch chan<- prometheus.Metric) {
+	if c.flowLabelProvider == nil {
+		return
+	}
+	settings, err := c.flowLabelProvider.FlowLabelSettings(ctx)
+	if err != nil {
+		c.logger.Warn("ipv6 flow label sysctls read failed", "err", err)
+		return
+	}
+	// Kernels without IPv6 expose none of the knobs; omit the series then.
+	if settings.Empty() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.ipv6FlowLabelDesc,
+		prometheus.GaugeValue,
+		1,
+		settings.AutoFlowLabels,
+		settings.FlowLabelStateRanges,
+		settings.FlowLabelReflect,
+	)
+}
+
+// collectZeroStats emits 0 for every documented counter the port lacks in both
+// counters and hw_counters, among those its driver and link layer can report.
+// Presence is compared by canonical name so a driver exposing a counter under
+// a variant spelling is not double reported.
+func (c *RdmaCollector) collectZeroStats(ch chan<- prometheus.Metric, labels *portLabels, device rdma.Device, port rdma.Port) {
+	present := make(map[string]struct{}, len(port.Stats)+len(port.HwStats))
+	for name := range port.Stats {
+		present[c.canonicalDocName(name)] = struct{}{}
+	}
+	for name := range port.HwStats {
+		present[c.canonicalDocName(name)] = struct{}{}
+	}
+
+	for _, stat := range c.zeroStats {
+		if _, ok := present[c.canonicalDocName(stat)]; ok {
+			continue
+		}
+		if !zeroStatApplies(stat, device, port) {
+			continue
+		}
+		if cc, ok := c.castCounter(stat); ok {
+			c.collectCastCounter(ch, labels, cc, 0)
+			continue
+		}
+		ch <- &portCounter{
+			desc:   c.statMetricDesc(stat),
+			labels: labels.pairs,
+			gauge:  c.isGauge(stat),
+		}
+	}
 }
 
 func sortedKeys(m map[string]uint64) []string {
@@ -633,7 +1876,7 @@ func sortedKeys(m map[string]uint64) []string {
 func (c *RdmaCollector) collectRoCEPFCMetrics(
 	ctx context.Context,
 	ch chan<- prometheus.Metric,
-	deviceName, portID string,
+	labels *portLabels,
 	attr rdma.PortAttributes,
 	isVF bool,
 	cache map[string]netDevStatsCacheEntry,
@@ -645,7 +1888,7 @@ func (c *RdmaCollector) collectRoCEPFCMetrics(
 	// VFs do not independently send/receive PFC frames; skip to avoid
 	// meaningless stats and blocking ethtool ioctls on VF interfaces.
 	if isVF {
-		c.logger.Debug("skipping PFC collection for VF device", "device", deviceName, "port", portID)
+		c.logger.Debug("skipping PFC collection for VF device", "device", labels.device, "port", labels.port)
 		return
 	}
 	if attr.LinkLayer != "Ethernet" || attr.NetDev == "" {
@@ -655,10 +1898,10 @@ func (c *RdmaCollector) collectRoCEPFCMetrics(
 	stats, err := c.readNetDevStatsWithCache(ctx, attr.NetDev, cache)
 	if err != nil {
 		if ctx.Err() != nil {
-			c.logger.Warn("roce pfc scrape aborted by context", "device", deviceName, "port", portID, "netdev", attr.NetDev, "err", ctx.Err())
+			c.logger.Warn("roce pfc scrape aborted by context", "device", labels.device, "port", labels.port, "netdev", attr.NetDev, "err", ctx.Err())
 			return
 		}
-		c.logger.Warn("roce pfc scrape failed", "device", deviceName, "port", portID, "netdev", attr.NetDev, "err", err)
+		c.logger.Warn("roce pfc scrape failed", "device", labels.device, "port", labels.port, "netdev", attr.NetDev, "err", err)
 		return
 	}
 
@@ -680,11 +1923,7 @@ func (c *RdmaCollector) collectRoCEPFCMetrics(
 			desc,
 			prometheus.CounterValue,
 			float64(stats[name]),
-			deviceName,
-			portID,
-			attr.NetDev,
-			direction,
-			priority,
+			labels.values(attr.NetDev, direction, priority)...,
 		)
 	}
 }
@@ -701,30 +1940,21 @@ func (c *RdmaCollector) readNetDevStatsWithCache(
 	stats, err := c.netDevStatsProvider.Stats(ctx, netDev)
 	if err != nil {
 		c.rocePFCScrapeErrors.Inc()
+		return nil, err
 	}
-	cache[netDev] = netDevStatsCacheEntry{
-		stats: stats,
-		err:   err,
-	}
-	return stats, err
+	cache[netDev] = netDevStatsCacheEntry{stats: stats}
+	return stats, nil
 }
 
 func parseRoCEPFCMetricName(name string) (direction, priority string, kind rocePFCMetricKind, ok bool) {
-	matches := rocePFCStatPattern.FindStringSubmatch(name)
-	if matches == nil {
-		return "", "", rocePFCMetricKindFrames, false
-	}
-
-	direction = matches[1]
-	priority = matches[2]
-	switch matches[3] {
-	case "":
-		return direction, priority, rocePFCMetricKindFrames, true
-	case "duration":
-		return direction, priority, rocePFCMetricKindDuration, true
-	case "transition":
-		return direction, priority, rocePFCMetricKindTransitions, true
-	default:
-		return "", "", rocePFCMetricKindFrames, false
+	for _, pattern := range rocePFCStatPatterns {
+		matches := pattern.re.FindStringSubmatch(name)
+		if matches == nil {
+			continue
+		}
+		direction = matches[pattern.re.SubexpIndex("direction")]
+		priority = matches[pattern.re.SubexpIndex("priority")]
+		return direction, priority, pattern.kind, true
 	}
+	return "", "", rocePFCMetricKindFrames, false
 }
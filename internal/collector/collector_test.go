@@ -3,16 +3,24 @@ package collector
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	dto "github.com/prometheus/client_model/go"
 
+	"github.com/yuuki/rdma_exporter/internal/netdev"
 	"github.com/yuuki/rdma_exporter/internal/rdma"
 )
 
@@ -99,6 +107,7 @@ func TestCollectorExportsMetrics(t *testing.T) {
 							PhysState: "LinkUp",
 							LinkWidth: "4X",
 							LinkSpeed: "100 Gb/sec",
+							Fabric:    "fe80:0000:0000:0000",
 						},
 					},
 				},
@@ -114,6 +123,9 @@ func TestCollectorExportsMetrics(t *testing.T) {
 	defer c.ResetContext()
 
 	expected := `
+# HELP rdma_port_fabric_info Fabric a port is attached to: the IB subnet prefix or RoCE GID prefix, distinguishing rails on multi-fabric nodes.
+# TYPE rdma_port_fabric_info gauge
+rdma_port_fabric_info{device="mlx5_0",fabric="fe80:0000:0000:0000",port="1"} 1
 # HELP rdma_port_info RDMA port metadata exported as labels.
 # TYPE rdma_port_info gauge
 rdma_port_info{device="mlx5_0",is_vf="false",link_layer="InfiniBand",link_speed="100 Gb/sec",link_width="4X",pci_addr="0000:1a:00.0",pf_device="",phys_state="LinkUp",port="1",state="ACTIVE"} 1
@@ -129,7 +141,7 @@ rdma_symbol_error_total{device="mlx5_0",port="1"} 1
 `
 
 	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
-		"rdma_port_rcv_data_total", "rdma_port_xmit_data_total", "rdma_symbol_error_total", "rdma_port_info"); err != nil {
+		"rdma_port_rcv_data_total", "rdma_port_xmit_data_total", "rdma_symbol_error_total", "rdma_port_info", "rdma_port_fabric_info"); err != nil {
 		t.Fatalf("unexpected metrics output: %v", err)
 	}
 }
@@ -157,6 +169,38 @@ func TestCollectorIncrementsErrorCounter(t *testing.T) {
 	}
 }
 
+type stubReadStatsProvider struct {
+	stubProvider
+	stats []rdma.DeviceReadStats
+}
+
+func (s *stubReadStatsProvider) ReadStats() []rdma.DeviceReadStats {
+	return s.stats
+}
+
+func TestCollectorExportsDeviceReadStats(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubReadStatsProvider{
+		stats: []rdma.DeviceReadStats{{Device: "mlx5_0", Retries: 3, Errors: 1}},
+	}
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_device_read_errors_total Number of reads of an RDMA device from sysfs that failed after all retries.
+# TYPE rdma_device_read_errors_total counter
+rdma_device_read_errors_total{device="mlx5_0"} 1
+# HELP rdma_device_read_retries_total Number of times reading an RDMA device from sysfs was retried after a transient error such as EBUSY.
+# TYPE rdma_device_read_retries_total counter
+rdma_device_read_retries_total{device="mlx5_0"} 3
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_device_read_errors_total", "rdma_device_read_retries_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
 func TestCollectorExportsRoCEPFCMetrics(t *testing.T) {
 	t.Parallel()
 
@@ -211,6 +255,38 @@ rdma_roce_pfc_pause_transitions_total{device="mlx5_0",direction="tx",netdev="ens
 	}
 }
 
+func TestParseRoCEPFCMetricName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		direction string
+		priority  string
+		kind      rocePFCMetricKind
+		ok        bool
+	}{
+		{name: "rx_prio3_pause", direction: "rx", priority: "3", kind: rocePFCMetricKindFrames, ok: true},
+		{name: "tx_prio0_pause_duration", direction: "tx", priority: "0", kind: rocePFCMetricKindDuration, ok: true},
+		{name: "rx_prio7_pause_transition", direction: "rx", priority: "7", kind: rocePFCMetricKindTransitions, ok: true},
+		{name: "tx_pfc_ena_frames_pri5", direction: "tx", priority: "5", kind: rocePFCMetricKindFrames, ok: true},
+		{name: "pfc_pri2_rx_duration_us", direction: "rx", priority: "2", kind: rocePFCMetricKindDuration, ok: true},
+		{name: "pfc_pri4_tx_transitions", direction: "tx", priority: "4", kind: rocePFCMetricKindTransitions, ok: true},
+		{name: "rx_priority_1_xoff.nic", direction: "rx", priority: "1", kind: rocePFCMetricKindFrames, ok: true},
+		{name: "rx_priority_1_xon.nic"},
+		{name: "rx_prio8_pause"},
+		{name: "rx_prio3_packets"},
+		{name: "rx_pfc_frames"},
+	}
+
+	for _, tt := range tests {
+		direction, priority, kind, ok := parseRoCEPFCMetricName(tt.name)
+		if direction != tt.direction || priority != tt.priority || kind != tt.kind || ok != tt.ok {
+			t.Errorf("parseRoCEPFCMetricName(%q) = %q, %q, %v, %v; want %q, %q, %v, %v",
+				tt.name, direction, priority, kind, ok, tt.direction, tt.priority, tt.kind, tt.ok)
+		}
+	}
+}
+
 func TestCollectorSkipsRoCEPFCForInfiniBandPort(t *testing.T) {
 	t.Parallel()
 
@@ -321,8 +397,8 @@ func TestCollectorSkipsRoCEPFCForVirtualFunction(t *testing.T) {
 	provider := &stubProvider{
 		devices: []rdma.Device{
 			{
-				Name:  "mlx5_12",
-				IsVF:  true,
+				Name: "mlx5_12",
+				IsVF: true,
 				Ports: []rdma.Port{
 					{
 						ID: 1,
@@ -472,3 +548,3518 @@ func findMetricValue(t *testing.T, families []*dto.MetricFamily, name string) fl
 	t.Fatalf("metric %s not found", name)
 	return 0
 }
+
+func TestCollectorStatefulExportsPortIdleSeconds(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{
+					{
+						ID: 1,
+						Stats: map[string]uint64{
+							"port_xmit_data": 10,
+							"port_rcv_data":  5,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	c := New(provider, newDiscardLogger(), WithStatefulMode())
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	gatherIdle := func() float64 {
+		t.Helper()
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("unexpected gather error: %v", err)
+		}
+		for _, mf := range mfs {
+			if mf.GetName() == "rdma_port_idle_seconds" {
+				return mf.Metric[0].GetGauge().GetValue()
+			}
+		}
+		t.Fatalf("metric rdma_port_idle_seconds not found")
+		return 0
+	}
+
+	if got := gatherIdle(); got != 0 {
+		t.Fatalf("expected idle 0 on first scrape, got %v", got)
+	}
+
+	now = now.Add(30 * time.Second)
+	if got := gatherIdle(); got != 30 {
+		t.Fatalf("expected idle 30 with unchanged counters, got %v", got)
+	}
+
+	provider.devices[0].Ports[0].Stats = map[string]uint64{
+		"port_xmit_data": 11,
+		"port_rcv_data":  5,
+	}
+	now = now.Add(15 * time.Second)
+	if got := gatherIdle(); got != 0 {
+		t.Fatalf("expected idle reset after counter change, got %v", got)
+	}
+}
+
+func TestCollectorStatefulExportsRetransmitRatio(t *testing.T) {
+	t.Parallel()
+
+	port := func(xmitPackets, seqErr, ackTimeout uint64) rdma.Port {
+		return rdma.Port{
+			ID:    1,
+			Stats: map[string]uint64{"port_xmit_packets": xmitPackets},
+			HwStats: map[string]uint64{
+				"packet_seq_err":        seqErr,
+				"local_ack_timeout_err": ackTimeout,
+			},
+		}
+	}
+	provider := &stubProvider{
+		devices: []rdma.Device{{Name: "mlx5_0", Ports: []rdma.Port{port(1000, 0, 0)}}},
+	}
+
+	c := New(provider, newDiscardLogger(), WithStatefulMode())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if count, err := testutil.GatherAndCount(reg, "rdma_port_retransmit_ratio"); err != nil || count != 0 {
+		t.Fatalf("expected no ratio on first scrape, got %d (err=%v)", count, err)
+	}
+
+	provider.devices[0].Ports[0] = port(2000, 3, 2)
+	expected := `
+# HELP rdma_port_retransmit_ratio Retransmission events (packet_seq_err, implied_nak_seq_err, local_ack_timeout_err) per port_xmit_packets over the last scrape window. Only exported in stateful mode.
+# TYPE rdma_port_retransmit_ratio gauge
+rdma_port_retransmit_ratio{device="mlx5_0",port="1"} 0.005
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_port_retransmit_ratio"); err != nil {
+		t.Fatalf("unexpected ratio: %v", err)
+	}
+
+	// A counter reset must not produce a ratio for that window.
+	provider.devices[0].Ports[0] = port(10, 0, 0)
+	if count, err := testutil.GatherAndCount(reg, "rdma_port_retransmit_ratio"); err != nil || count != 0 {
+		t.Fatalf("expected no ratio after counter reset, got %d (err=%v)", count, err)
+	}
+}
+
+func TestCollectorStatefulExportsLinkRecoveryBursts(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{devices: []rdma.Device{{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1}}}}}
+	c := New(provider, newDiscardLogger(), WithStatefulMode(), WithLinkRecoveryBursts(3, time.Minute))
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	scrape := func(recoveries uint64) {
+		t.Helper()
+		now = now.Add(20 * time.Second)
+		provider.devices[0].Ports[0].Stats = map[string]uint64{"link_error_recovery": recoveries}
+		if _, err := reg.Gather(); err != nil {
+			t.Fatalf("unexpected gather error: %v", err)
+		}
+	}
+	// Gathering again at the same time does not observe the port twice.
+	expect := func(bursts, size int) {
+		t.Helper()
+		expected := fmt.Sprintf(`
+# HELP rdma_port_link_recovery_burst_size Link error recoveries in the port's current or latest burst, 0 before the first one. Only exported in stateful mode.
+# TYPE rdma_port_link_recovery_burst_size gauge
+rdma_port_link_recovery_burst_size{device="mlx5_0",port="1"} %d
+# HELP rdma_port_link_recovery_bursts_total Bursts of link_error_recovery increments on the port, with at least the burst threshold of recoveries within the burst window, since the exporter started. Only exported in stateful mode.
+# TYPE rdma_port_link_recovery_bursts_total counter
+rdma_port_link_recovery_bursts_total{device="mlx5_0",port="1"} %d
+`, size, bursts)
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+			"rdma_port_link_recovery_burst_size", "rdma_port_link_recovery_bursts_total"); err != nil {
+			t.Fatalf("unexpected burst metrics: %v", err)
+		}
+	}
+
+	// A recovery every 40 seconds never puts 3 within a minute.
+	for _, recoveries := range []uint64{0, 1, 1, 2, 2} {
+		scrape(recoveries)
+	}
+	expect(0, 0)
+
+	// Two more complete a burst with the one 40 seconds earlier, and the
+	// burst grows while recoveries keep coming.
+	scrape(4)
+	scrape(6)
+	expect(1, 5)
+
+	// A quiet minute ends the burst; the next storm is a new one.
+	for _, recoveries := range []uint64{6, 6, 6, 9} {
+		scrape(recoveries)
+	}
+	expect(2, 3)
+}
+
+func TestCollectorExportsRateJitter(t *testing.T) {
+	t.Parallel()
+
+	port := func(xmitData, symbolErrors uint64) rdma.Port {
+		return rdma.Port{
+			ID:      1,
+			Stats:   map[string]uint64{"port_xmit_data": xmitData},
+			HwStats: map[string]uint64{"symbol_errors": symbolErrors},
+		}
+	}
+	provider := &stubProvider{
+		devices: []rdma.Device{{Name: "mlx5_0", Ports: []rdma.Port{port(0, 0)}}},
+	}
+
+	c := New(provider, newDiscardLogger(), WithRateJitter([]string{"port_xmit_data", "symbol_errors", "missing"}, 4))
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if count, err := testutil.GatherAndCount(reg, "rdma_port_counter_rate"); err != nil || count != 0 {
+		t.Fatalf("expected no rates on first scrape, got %d (err=%v)", count, err)
+	}
+
+	// Rates of 100, 300, 200, a counter reset that adds none, then 500 and
+	// 400, of which the window keeps the last four.
+	xmit := []uint64{1000, 4000, 6000, 10, 5010, 9010}
+	for i, value := range xmit {
+		now = now.Add(10 * time.Second)
+		provider.devices[0].Ports[0] = port(value, uint64(i+1))
+		if _, err := reg.Gather(); err != nil {
+			t.Fatalf("unexpected gather error: %v", err)
+		}
+	}
+
+	expected := `
+# HELP rdma_port_counter_rate Distribution of the per-second rate of a counter between consecutive scrapes over the last scrapes. Only exported for counters selected for rate jitter tracking.
+# TYPE rdma_port_counter_rate summary
+rdma_port_counter_rate{counter="port_xmit_data",device="mlx5_0",port="1",quantile="0.01"} 200
+rdma_port_counter_rate{counter="port_xmit_data",device="mlx5_0",port="1",quantile="0.05"} 200
+rdma_port_counter_rate{counter="port_xmit_data",device="mlx5_0",port="1",quantile="0.5"} 300
+rdma_port_counter_rate{counter="port_xmit_data",device="mlx5_0",port="1",quantile="0.95"} 500
+rdma_port_counter_rate{counter="port_xmit_data",device="mlx5_0",port="1",quantile="0.99"} 500
+rdma_port_counter_rate_sum{counter="port_xmit_data",device="mlx5_0",port="1"} 1400
+rdma_port_counter_rate_count{counter="port_xmit_data",device="mlx5_0",port="1"} 4
+rdma_port_counter_rate{counter="symbol_errors",device="mlx5_0",port="1",quantile="0.01"} 0.1
+rdma_port_counter_rate{counter="symbol_errors",device="mlx5_0",port="1",quantile="0.05"} 0.1
+rdma_port_counter_rate{counter="symbol_errors",device="mlx5_0",port="1",quantile="0.5"} 0.1
+rdma_port_counter_rate{counter="symbol_errors",device="mlx5_0",port="1",quantile="0.95"} 0.1
+rdma_port_counter_rate{counter="symbol_errors",device="mlx5_0",port="1",quantile="0.99"} 0.1
+rdma_port_counter_rate_sum{counter="symbol_errors",device="mlx5_0",port="1"} 0.4
+rdma_port_counter_rate_count{counter="symbol_errors",device="mlx5_0",port="1"} 4
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_port_counter_rate"); err != nil {
+		t.Fatalf("unexpected rates: %v", err)
+	}
+}
+
+func TestCollectorOmitsPortIdleSecondsWithoutStatefulMode(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1}}},
+		},
+	}
+
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if n, err := testutil.GatherAndCount(reg, "rdma_port_idle_seconds"); err != nil || n != 0 {
+		t.Fatalf("expected no idle series, got %d (err=%v)", n, err)
+	}
+}
+
+func TestCollectorExportsEnabledCollectors(t *testing.T) {
+	t.Parallel()
+
+	c := New(&stubProvider{}, newDiscardLogger(), WithNetDevStatsProvider(newStubNetDevStatsProvider()))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_exporter_collector_enabled Whether an optional part of the RDMA collector is enabled at runtime (1) or not (0).
+# TYPE rdma_exporter_collector_enabled gauge
+rdma_exporter_collector_enabled{collector="adaptive_budget"} 0
+rdma_exporter_collector_enabled{collector="counters"} 1
+rdma_exporter_collector_enabled{collector="deep_scan"} 0
+rdma_exporter_collector_enabled{collector="device_limits"} 0
+rdma_exporter_collector_enabled{collector="emit_zeros"} 0
+rdma_exporter_collector_enabled{collector="gid_table"} 0
+rdma_exporter_collector_enabled{collector="byte_counters"} 0
+rdma_exporter_collector_enabled{collector="utilization"} 0
+rdma_exporter_collector_enabled{collector="top_counters"} 0
+rdma_exporter_collector_enabled{collector="pkey_table"} 0
+rdma_exporter_collector_enabled{collector="uevents"} 0
+rdma_exporter_collector_enabled{collector="netdev_link"} 0
+rdma_exporter_collector_enabled{collector="netdev_ethtool"} 0
+rdma_exporter_collector_enabled{collector="netdev_statistics"} 0
+rdma_exporter_collector_enabled{collector="qp_counters"} 0
+rdma_exporter_collector_enabled{collector="rate_jitter"} 0
+rdma_exporter_collector_enabled{collector="resources"} 0
+rdma_exporter_collector_enabled{collector="resources_by_process"} 0
+rdma_exporter_collector_enabled{collector="hw_counters"} 1
+rdma_exporter_collector_enabled{collector="ipv6_flowlabel"} 0
+rdma_exporter_collector_enabled{collector="roce_config"} 0
+rdma_exporter_collector_enabled{collector="dcb"} 0
+rdma_exporter_collector_enabled{collector="roce_pfc"} 1
+rdma_exporter_collector_enabled{collector="stateful"} 0
+rdma_exporter_collector_enabled{collector="suppress_unchanged"} 0
+rdma_exporter_collector_enabled{collector="vport"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_exporter_collector_enabled"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+type stubFlowLabelProvider struct {
+	settings rdma.FlowLabelSettings
+}
+
+func (s stubFlowLabelProvider) FlowLabelSettings(context.Context) (rdma.FlowLabelSettings, error) {
+	return s.settings, nil
+}
+
+func TestCollectorExportsFlowLabelSettings(t *testing.T) {
+	t.Parallel()
+
+	flowLabels := stubFlowLabelProvider{settings: rdma.FlowLabelSettings{
+		AutoFlowLabels:       "1",
+		FlowLabelStateRanges: "1",
+		FlowLabelReflect:     "0",
+	}}
+	c := New(&stubProvider{}, newDiscardLogger(), WithFlowLabelSettings(flowLabels))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_ipv6_flowlabel_sysctl_info The net.ipv6 flow label sysctls of the node, exported as labels. They apply to the kernel's IPv6 sockets, not to the UDP source port of RoCEv2 traffic. Empty labels mean the sysctl is not available.
+# TYPE rdma_ipv6_flowlabel_sysctl_info gauge
+rdma_ipv6_flowlabel_sysctl_info{auto_flowlabels="1",flowlabel_reflect="0",flowlabel_state_ranges="1"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_ipv6_flowlabel_sysctl_info"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestCollectorOmitsFlowLabelSettingsWhenUnavailable(t *testing.T) {
+	t.Parallel()
+
+	c := New(&stubProvider{}, newDiscardLogger(), WithFlowLabelSettings(stubFlowLabelProvider{}))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if n, err := testutil.GatherAndCount(reg, "rdma_ipv6_flowlabel_sysctl_info"); err != nil || n != 0 {
+		t.Fatalf("expected no flow label series, got %d (err=%v)", n, err)
+	}
+}
+
+func TestCollectorExportsDeviceInfo(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Attributes: rdma.DeviceAttributes{
+					FWVer:    "20.31.1014",
+					NodeGUID: "0c42:a103:0000:0001",
+					NodeDesc: "host01 mlx5_0",
+					NodeType: "CA",
+
+					BoardID:      "MT_0000000222",
+					HCAType:      "MT4123",
+					SysImageGUID: "0c42:a103:0000:0000",
+				},
+				Ports: []rdma.Port{{ID: 1}},
+			},
+			{Name: "rxe0"},
+		},
+	}
+
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_device_info Device-level metadata of an RDMA device from /sys/class/infiniband/<dev>.
+# TYPE rdma_device_info gauge
+rdma_device_info{board_id="MT_0000000222",device="mlx5_0",fw_ver="20.31.1014",hca_type="MT4123",node_desc="host01 mlx5_0",node_guid="0c42:a103:0000:0001",node_type="CA",sys_image_guid="0c42:a103:0000:0000",vendor="NVIDIA"} 1
+rdma_device_info{board_id="",device="rxe0",fw_ver="",hca_type="",node_desc="",node_guid="",node_type="",sys_image_guid="",vendor=""} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_device_info"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestNodeDescMatches(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		nodeDesc string
+		host     string
+		want     bool
+	}{
+		{nodeDesc: "host01 mlx5_0", host: "host01", want: true},
+		{nodeDesc: "host01 mlx5_0", host: "host01.example.com", want: true},
+		{nodeDesc: "host01.example.com mlx5_0", host: "HOST01", want: true},
+		{nodeDesc: "oldhost mlx5_0", host: "host01", want: false},
+		// The kernel default before rdma-ndd runs.
+		{nodeDesc: "MT4123 ConnectX6   Mellanox Technologies", host: "host01", want: false},
+		{nodeDesc: "", host: "host01", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := nodeDescMatches(tt.nodeDesc, tt.host); got != tt.want {
+			t.Fatalf("nodeDescMatches(%q, %q) = %v, want %v", tt.nodeDesc, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestCollectorExportsNodeDescMismatch(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{Name: "mlx5_0", Attributes: rdma.DeviceAttributes{NodeDesc: "host01 mlx5_0"}},
+			{Name: "mlx5_1", Attributes: rdma.DeviceAttributes{NodeDesc: "oldhost mlx5_1"}},
+			{Name: "rxe0"},
+		},
+	}
+
+	c := New(provider, newDiscardLogger(), WithNodeDescCheck("host01.example.com"))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_device_node_desc_mismatch Whether the device's node_desc does not start with the host name (1) or does (0).
+# TYPE rdma_device_node_desc_mismatch gauge
+rdma_device_node_desc_mismatch{device="mlx5_0",hostname="host01.example.com",node_desc="host01 mlx5_0"} 0
+rdma_device_node_desc_mismatch{device="mlx5_1",hostname="host01.example.com",node_desc="oldhost mlx5_1"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_device_node_desc_mismatch"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestCollectorDeduplicatesDevices(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{Name: "mlx5_0", PCIAddr: "0000:08:00.0", Attributes: rdma.DeviceAttributes{NodeGUID: "a"}},
+			{Name: "mlx5_bond_0", PCIAddr: "0000:08:00.0", Attributes: rdma.DeviceAttributes{NodeGUID: "b"}},
+			{Name: "mlx5_2", PCIAddr: "0000:09:00.0", Attributes: rdma.DeviceAttributes{NodeGUID: "b"}},
+			{Name: "rxe0"},
+		},
+	}
+
+	tests := []struct {
+		mode     string
+		expected string
+	}{
+		{
+			mode: DedupPCI,
+			expected: `
+# HELP rdma_device_duplicate Device left out of the exposition because it surfaces the same hardware as the canonical device.
+# TYPE rdma_device_duplicate gauge
+rdma_device_duplicate{canonical="mlx5_bond_0",device="mlx5_0"} 1
+# HELP rdma_devices Number of RDMA devices found by the last collection, after exclusions.
+# TYPE rdma_devices gauge
+rdma_devices 3
+`,
+		},
+		{
+			mode: DedupNodeGUID,
+			expected: `
+# HELP rdma_device_duplicate Device left out of the exposition because it surfaces the same hardware as the canonical device.
+# TYPE rdma_device_duplicate gauge
+rdma_device_duplicate{canonical="mlx5_bond_0",device="mlx5_2"} 1
+# HELP rdma_devices Number of RDMA devices found by the last collection, after exclusions.
+# TYPE rdma_devices gauge
+rdma_devices 3
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		c := New(provider, newDiscardLogger(), WithDeviceDedup(tt.mode))
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(c)
+
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(tt.expected), "rdma_device_duplicate", "rdma_devices"); err != nil {
+			t.Fatalf("%s: unexpected metrics output: %v", tt.mode, err)
+		}
+	}
+}
+
+func TestCollectorExcludesSilencedDevices(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{{Name: "mlx5_0"}, {Name: "mlx5_1"}},
+	}
+	c := New(provider, newDiscardLogger())
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	c.RestoreSilences([]Silence{
+		{Device: "mlx5_0", Until: now.Add(time.Hour)},
+		{Device: "mlx5_1", Until: now.Add(-time.Hour)},
+	})
+
+	expected := `
+# HELP rdma_device_silenced Constant 1 for every device excluded from collection by an active silence.
+# TYPE rdma_device_silenced gauge
+rdma_device_silenced{device="mlx5_0"} 1
+# HELP rdma_devices Number of RDMA devices found by the last collection, after exclusions.
+# TYPE rdma_devices gauge
+rdma_devices 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_device_silenced", "rdma_devices"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	devices, err := c.Devices(context.Background())
+	if err != nil || len(devices) != 1 || devices[0].Name != "mlx5_1" {
+		t.Fatalf("expected only mlx5_1 from Devices, got %+v (err=%v)", devices, err)
+	}
+
+	// The silence ends on its own.
+	now = now.Add(2 * time.Hour)
+	if count, err := testutil.GatherAndCount(reg, "rdma_device_silenced"); err != nil || count != 0 {
+		t.Fatalf("expected no silenced devices after expiry, got %d (err=%v)", count, err)
+	}
+}
+
+type stubResourceProvider map[string]map[string]uint64
+
+func (s stubResourceProvider) ResourceCounts(context.Context) (map[string]map[string]uint64, error) {
+	return s, nil
+}
+
+func TestCollectorExportsResourceCounts(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{{Name: "mlx5_0"}, {Name: "mlx5_1"}},
+	}
+	resources := stubResourceProvider{
+		"mlx5_0": {"qp": 12, "cq": 24, "pd": 3, "new_kind": 1},
+		"mlx5_1": {"qp": 0},
+		// Devices missing from the snapshot, e.g. excluded ones, are skipped.
+		"mlx5_2": {"qp": 7},
+	}
+	c := New(provider, newDiscardLogger(), WithResourceProvider(resources))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_resource_cq Number of completion queues allocated on the device, from the kernel's RDMA resource tracking.
+# TYPE rdma_resource_cq gauge
+rdma_resource_cq{device="mlx5_0"} 24
+# HELP rdma_resource_pd Number of protection domains allocated on the device, from the kernel's RDMA resource tracking.
+# TYPE rdma_resource_pd gauge
+rdma_resource_pd{device="mlx5_0"} 3
+# HELP rdma_resource_qp Number of queue pairs allocated on the device, from the kernel's RDMA resource tracking.
+# TYPE rdma_resource_qp gauge
+rdma_resource_qp{device="mlx5_0"} 12
+rdma_resource_qp{device="mlx5_1"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_resource_cq", "rdma_resource_pd", "rdma_resource_qp"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+type stubDeviceLimitProvider struct {
+	limits map[string]map[string]uint64
+	calls  int
+}
+
+func (s *stubDeviceLimitProvider) DeviceLimits(_ context.Context, device string) (map[string]uint64, error) {
+	s.calls++
+	limits, ok := s.limits[device]
+	if !ok {
+		return nil, errors.New("no verbs device")
+	}
+	return limits, nil
+}
+
+func TestCollectorExportsDeviceLimits(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{{Name: "mlx5_0"}, {Name: "rxe0"}},
+	}
+	limits := &stubDeviceLimitProvider{limits: map[string]map[string]uint64{
+		"mlx5_0": {"qp": 262144, "cq": 16777216, "mr": 16777216},
+	}}
+	c := New(provider, newDiscardLogger(), WithDeviceLimits(limits))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	// Devices whose query failed are left out.
+	expected := `
+# HELP rdma_device_limit Maximum number of a verbs resource (qp, cq, mr, ...) the RDMA device supports, from ibv_query_device.
+# TYPE rdma_device_limit gauge
+rdma_device_limit{device="mlx5_0",resource="cq"} 1.6777216e+07
+rdma_device_limit{device="mlx5_0",resource="mr"} 1.6777216e+07
+rdma_device_limit{device="mlx5_0",resource="qp"} 262144
+`
+	for i := 0; i < 2; i++ {
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_device_limit"); err != nil {
+			t.Fatalf("scrape %d: unexpected metrics output: %v", i, err)
+		}
+	}
+	if limits.calls != 2 {
+		t.Fatalf("expected each device to be queried once, got %d queries", limits.calls)
+	}
+
+	c.InvalidateCache()
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	if limits.calls != 4 {
+		t.Fatalf("expected invalidation to query the devices again, got %d queries", limits.calls)
+	}
+}
+
+// blockingDeviceLimitProvider waits until its context is done, as a verbs
+// query that hangs would.
+type blockingDeviceLimitProvider struct {
+	calls int
+}
+
+func (p *blockingDeviceLimitProvider) DeviceLimits(ctx context.Context, _ string) (map[string]uint64, error) {
+	p.calls++
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCollectorDeviceLimitsTimeout(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{devices: []rdma.Device{{Name: "mlx5_0"}}}
+	limits := &blockingDeviceLimitProvider{}
+	c := New(provider, newDiscardLogger(),
+		WithDeviceLimits(limits),
+		WithCollectorTimeouts(map[string]time.Duration{"device_limits": 10 * time.Millisecond}),
+	)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+
+	// A query cut off by the timeout is not cached and is retried.
+	expected := `
+# HELP rdma_exporter_collector_timeouts_total Number of scrapes in which a collector was cut off by its --collect.<collector>.timeout.
+# TYPE rdma_exporter_collector_timeouts_total counter
+rdma_exporter_collector_timeouts_total{collector="device_limits"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_exporter_collector_timeouts_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if limits.calls != 2 {
+		t.Fatalf("expected the device to be queried on both scrapes, got %d queries", limits.calls)
+	}
+}
+
+func TestCollectorExportsDeviceHwCounters(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{devices: []rdma.Device{{
+		Name:    "efa_0",
+		HwStats: map[string]uint64{"submitted_cmds": 42, "out_of_buffer": 3},
+		Ports: []rdma.Port{{
+			ID:      1,
+			HwStats: map[string]uint64{"out_of_buffer": 1},
+		}},
+	}}}
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_device_out_of_buffer_total The number of drops that occurred due to lack of WQE for the associated QPs.
+# TYPE rdma_device_out_of_buffer_total counter
+rdma_device_out_of_buffer_total{device="efa_0"} 3
+# HELP rdma_device_submitted_cmds_total RDMA device hardware counter sourced from sysfs hw_counters.
+# TYPE rdma_device_submitted_cmds_total counter
+rdma_device_submitted_cmds_total{device="efa_0"} 42
+# HELP rdma_out_of_buffer_total The number of drops that occurred due to lack of WQE for the associated QPs.
+# TYPE rdma_out_of_buffer_total counter
+rdma_out_of_buffer_total{device="efa_0",port="1"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_device_out_of_buffer_total", "rdma_device_submitted_cmds_total", "rdma_out_of_buffer_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestCollectorExportsGaugeCounters(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{devices: []rdma.Device{{
+		Name:    "bnxt_re0",
+		HwStats: map[string]uint64{"lifespan": 10},
+		Ports: []rdma.Port{{
+			ID:      1,
+			Stats:   map[string]uint64{"port_xmit_data": 7},
+			HwStats: map[string]uint64{"active_qps": 12, "watermark_qps": 40, "fw_buffer_occupancy": 3},
+		}},
+	}}}
+	c := New(provider, newDiscardLogger(), WithBuiltinGaugeCounters(), WithGaugeCounters([]string{"fw_buffer_occupancy"}))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_active_qps RDMA port hardware counter sourced from sysfs hw_counters.
+# TYPE rdma_active_qps gauge
+rdma_active_qps{device="bnxt_re0",port="1"} 12
+# HELP rdma_device_lifespan The maximum period in ms which defines the aging of the counter reads. Two consecutive reads within this period might return the same values.
+# TYPE rdma_device_lifespan gauge
+rdma_device_lifespan{device="bnxt_re0"} 10
+# HELP rdma_fw_buffer_occupancy RDMA port hardware counter sourced from sysfs hw_counters.
+# TYPE rdma_fw_buffer_occupancy gauge
+rdma_fw_buffer_occupancy{device="bnxt_re0",port="1"} 3
+# HELP rdma_port_xmit_data_total The total number of data octets, divided by 4, transmitted on all VLs from the port.
+# TYPE rdma_port_xmit_data_total counter
+rdma_port_xmit_data_total{device="bnxt_re0",port="1"} 7
+# HELP rdma_watermark_qps RDMA port hardware counter sourced from sysfs hw_counters.
+# TYPE rdma_watermark_qps gauge
+rdma_watermark_qps{device="bnxt_re0",port="1"} 40
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_active_qps", "rdma_device_lifespan", "rdma_fw_buffer_occupancy", "rdma_port_xmit_data_total", "rdma_watermark_qps"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestCollectorKeepsCounterNamesWithoutBuiltinGauges(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{devices: []rdma.Device{{
+		Name: "bnxt_re0",
+		Ports: []rdma.Port{{
+			ID:      1,
+			HwStats: map[string]uint64{"active_qps": 12},
+		}},
+	}}}
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_active_qps_total RDMA port hardware counter sourced from sysfs hw_counters.
+# TYPE rdma_active_qps_total counter
+rdma_active_qps_total{device="bnxt_re0",port="1"} 12
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_active_qps_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if count, err := testutil.GatherAndCount(reg, "rdma_active_qps"); err != nil || count != 0 {
+		t.Fatalf("expected no gauge without --collect.builtin-gauge-counters, got %d (err=%v)", count, err)
+	}
+}
+
+type stubUEventProvider []rdma.UEventCount
+
+func (s stubUEventProvider) UEventCounts() []rdma.UEventCount {
+	return s
+}
+
+func TestCollectorExportsUEvents(t *testing.T) {
+	t.Parallel()
+
+	// The counts are exported even when the devices cannot be read.
+	provider := &stubProvider{err: errors.New("sysfs unavailable")}
+	c := New(provider, newDiscardLogger(), WithUEvents(stubUEventProvider{
+		{Device: "mlx5_0", Action: "add", Count: 3},
+		{Device: "mlx5_0", Action: "remove", Count: 3},
+	}))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_device_uevents_total Number of kernel uevents of an RDMA device since the exporter started, by action: add and remove when a driver registers and unregisters the device, e.g. around a driver reload or firmware reset, change and move on renames.
+# TYPE rdma_device_uevents_total counter
+rdma_device_uevents_total{action="add",device="mlx5_0"} 3
+rdma_device_uevents_total{action="remove",device="mlx5_0"} 3
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_device_uevents_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestLoadCounterSpecs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]CounterSpec
+		wantErr bool
+	}{
+		{
+			name: "specs",
+			data: `
+counters:
+  rx_fw_drops:
+    name: fw_rx_drops
+    help: Packets the firmware dropped on receive.
+  tx_fw_stalls:
+    help: Transmit stalls reported by the firmware.
+`,
+			want: map[string]CounterSpec{
+				"rx_fw_drops":  {Name: "fw_rx_drops", Help: "Packets the firmware dropped on receive."},
+				"tx_fw_stalls": {Help: "Transmit stalls reported by the firmware."},
+			},
+		},
+		{name: "empty", data: ""},
+		{name: "unknown field", data: "counters:\n  rx_fw_drops:\n    helptext: typo\n", wantErr: true},
+		{name: "empty spec", data: "counters:\n  rx_fw_drops: {}\n", wantErr: true},
+		{name: "invalid", data: "counters: [", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "counters.yaml")
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatalf("write specs: %v", err)
+			}
+			got, err := LoadCounterSpecs(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadCounterSpecs returned error: %v", err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("unexpected specs:\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadCounterSpecs(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatalf("expected error for missing file")
+	}
+}
+
+func TestCollectorAppliesCounterSpecs(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{devices: []rdma.Device{{
+		Name: "mlx5_0",
+		Ports: []rdma.Port{{
+			ID:      1,
+			Stats:   map[string]uint64{"port_xmit_data": 7},
+			HwStats: map[string]uint64{"rx_fw_drops": 3, "tx_fw_stalls": 2},
+		}},
+	}}}
+	c := New(provider, newDiscardLogger(), WithCounterSpecs(map[string]CounterSpec{
+		"rx_fw_drops":    {Name: "fw_rx_drops", Help: "Packets the firmware dropped on receive."},
+		"tx_fw_stalls":   {Help: "Transmit stalls reported by the firmware."},
+		"port_xmit_data": {Help: "Data octets transmitted, divided by 4."},
+	}))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_fw_rx_drops_total Packets the firmware dropped on receive.
+# TYPE rdma_fw_rx_drops_total counter
+rdma_fw_rx_drops_total{device="mlx5_0",port="1"} 3
+# HELP rdma_port_xmit_data_total Data octets transmitted, divided by 4.
+# TYPE rdma_port_xmit_data_total counter
+rdma_port_xmit_data_total{device="mlx5_0",port="1"} 7
+# HELP rdma_tx_fw_stalls_total Transmit stalls reported by the firmware.
+# TYPE rdma_tx_fw_stalls_total counter
+rdma_tx_fw_stalls_total{device="mlx5_0",port="1"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_fw_rx_drops_total", "rdma_port_xmit_data_total", "rdma_tx_fw_stalls_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if got := c.warnings[WarningUnknownCounter]; got != 0 {
+		t.Fatalf("expected counters with specs to be known, got %d unknown counter warnings", got)
+	}
+}
+
+func TestCollectorAppliesDriverHelpPacks(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{devices: []rdma.Device{
+		{
+			Name:       "mlx5_0",
+			Attributes: rdma.DeviceAttributes{Driver: "mlx5_core"},
+			Ports: []rdma.Port{{
+				ID:      1,
+				HwStats: map[string]uint64{"rx_icrc_encapsulated": 1},
+			}},
+		},
+		{
+			Name:       "rdmap0s6",
+			Attributes: rdma.DeviceAttributes{Driver: "efa"},
+			HwStats:    map[string]uint64{"completed_cmds": 12},
+			Ports: []rdma.Port{{
+				ID:      1,
+				HwStats: map[string]uint64{"recv_bytes": 4096},
+			}},
+		},
+	}}
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_device_completed_cmds_total The number of EFA admin commands that completed.
+# TYPE rdma_device_completed_cmds_total counter
+rdma_device_completed_cmds_total{device="rdmap0s6"} 12
+# HELP rdma_recv_bytes_total The number of bytes received by receive work requests.
+# TYPE rdma_recv_bytes_total counter
+rdma_recv_bytes_total{device="rdmap0s6",port="1"} 4096
+# HELP rdma_rx_icrc_encapsulated_total The number of RoCE packets with ICRC errors. This counter was added in MLNX_OFED 4.4 and kernel 4.19.
+# TYPE rdma_rx_icrc_encapsulated_total counter
+rdma_rx_icrc_encapsulated_total{device="mlx5_0",port="1"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_device_completed_cmds_total", "rdma_recv_bytes_total", "rdma_rx_icrc_encapsulated_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if got := c.warnings[WarningUnknownCounter]; got != 0 {
+		t.Fatalf("expected driver counters to be known, got %d unknown counter warnings", got)
+	}
+}
+
+func TestCollectorExportsEFADevice(t *testing.T) {
+	t.Parallel()
+
+	provider := rdma.NewSysfsProvider()
+	if err := provider.SetSysfsRoot(filepath.Join("..", "rdma", "testdata", "sysfs", "efa")); err != nil {
+		t.Fatal(err)
+	}
+	c := New(provider, newDiscardLogger(), WithEmitZeros())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_device_keep_alive_rcvd_total The number of keep-alive events received from the EFA device.
+# TYPE rdma_device_keep_alive_rcvd_total counter
+rdma_device_keep_alive_rcvd_total{device="rdmap16s27"} 7215
+# HELP rdma_read_bytes_total The number of bytes read by RDMA read requests sent.
+# TYPE rdma_read_bytes_total counter
+rdma_read_bytes_total{device="rdmap16s27",port="1"} 8.589934592e+09
+# HELP rdma_tx_bytes_total The number of bytes transmitted by the EFA device, including protocol headers.
+# TYPE rdma_tx_bytes_total counter
+rdma_tx_bytes_total{device="rdmap16s27",port="1"} 1.073741824e+10
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_device_keep_alive_rcvd_total", "rdma_read_bytes_total", "rdma_tx_bytes_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	// EFA ports have no InfiniBand counters for zeros to stand in for.
+	if n, err := testutil.GatherAndCount(reg, "rdma_port_xmit_data_total", "rdma_symbol_error_total"); err != nil || n != 0 {
+		t.Fatalf("expected no zero InfiniBand counters on an EFA port, got %d (err=%v)", n, err)
+	}
+	if got := c.warnings[WarningUnknownCounter]; got != 0 {
+		t.Fatalf("expected EFA counters to be known, got %d unknown counter warnings", got)
+	}
+}
+
+func TestCollectorExportsIRDMADevice(t *testing.T) {
+	t.Parallel()
+
+	provider := rdma.NewSysfsProvider()
+	if err := provider.SetSysfsRoot(filepath.Join("..", "rdma", "testdata", "sysfs", "irdma")); err != nil {
+		t.Fatal(err)
+	}
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	// irdma counters take the names of their mlx5 equivalents, or snake-case
+	// ones, and the function-wide ones are device-scoped.
+	expected := `
+# HELP rdma_device_ip4_in_octets_total The number of octets received in IPv4 packets.
+# TYPE rdma_device_ip4_in_octets_total counter
+rdma_device_ip4_in_octets_total{device="rocep23s0f0"} 1.23456789e+08
+# HELP rdma_rp_cnp_handled_total The number of CNP packets handled by the Reaction Point to throttle the transmission rate.
+# TYPE rdma_rp_cnp_handled_total counter
+rdma_rp_cnp_handled_total{device="rocep23s0f0",port="1"} 42
+# HELP rdma_rx_read_requests_total The number of RDMA read requests received.
+# TYPE rdma_rx_read_requests_total counter
+rdma_rx_read_requests_total{device="rocep23s0f0",port="1"} 1200
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_device_ip4_in_octets_total", "rdma_rp_cnp_handled_total", "rdma_rx_read_requests_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if got := c.warnings[WarningUnknownCounter]; got != 0 {
+		t.Fatalf("expected irdma counters to be known, got %d unknown counter warnings", got)
+	}
+}
+
+func TestCollectorExportsHFI1VLCounters(t *testing.T) {
+	t.Parallel()
+
+	provider := rdma.NewSysfsProvider()
+	if err := provider.SetSysfsRoot(filepath.Join("..", "rdma", "testdata", "sysfs", "hfi1")); err != nil {
+		t.Fatal(err)
+	}
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_tx_packets_total The number of packets transmitted.
+# TYPE rdma_tx_packets_total counter
+rdma_tx_packets_total{device="hfi1_0",port="1"} 500
+# HELP rdma_vl_rx_flits_total The number of flits received.
+# TYPE rdma_vl_rx_flits_total counter
+rdma_vl_rx_flits_total{device="hfi1_0",port="1",vl="0"} 90
+rdma_vl_rx_flits_total{device="hfi1_0",port="1",vl="1"} 10
+# HELP rdma_vl_tx_flits_total The number of flits transmitted.
+# TYPE rdma_vl_tx_flits_total counter
+rdma_vl_tx_flits_total{device="hfi1_0",port="1",vl="0"} 100
+rdma_vl_tx_flits_total{device="hfi1_0",port="1",vl="15"} 3
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_tx_packets_total", "rdma_vl_rx_flits_total", "rdma_vl_tx_flits_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if got := c.warnings[WarningUnknownCounter]; got != 0 {
+		t.Fatalf("expected hfi1 counters to be known, got %d unknown counter warnings", got)
+	}
+}
+
+func TestCollectorExportsCxgb4Device(t *testing.T) {
+	t.Parallel()
+
+	provider := rdma.NewSysfsProvider()
+	if err := provider.SetSysfsRoot(filepath.Join("..", "rdma", "testdata", "sysfs", "cxgb4")); err != nil {
+		t.Fatal(err)
+	}
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_device_ip4_in_segments_total The number of TCP segments over IPv4 received by the adapter's TCP offload engine, shared by all ports.
+# TYPE rdma_device_ip4_in_segments_total counter
+rdma_device_ip4_in_segments_total{device="cxgb4_0"} 1200
+# HELP rdma_device_ip4_retransmitted_segments_total The number of TCP segments over IPv4 retransmitted by the adapter's TCP offload engine, shared by all ports.
+# TYPE rdma_device_ip4_retransmitted_segments_total counter
+rdma_device_ip4_retransmitted_segments_total{device="cxgb4_0"} 7
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_device_ip4_in_segments_total", "rdma_device_ip4_retransmitted_segments_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if got := c.warnings[WarningUnknownCounter]; got != 0 {
+		t.Fatalf("expected cxgb4 counters to be known, got %d unknown counter warnings", got)
+	}
+	// iWARP ports have no LIDs to export.
+	if n, err := testutil.GatherAndCount(reg, "rdma_port_lid", "rdma_port_sm_lid"); err != nil || n != 0 {
+		t.Fatalf("expected no LID series for iWARP ports, got %d (err %v)", n, err)
+	}
+}
+
+func TestCollectorNormalizesBnxtReCounters(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{devices: []rdma.Device{
+		{
+			Name:       "bnxt_re0",
+			Attributes: rdma.DeviceAttributes{Driver: "bnxt_re"},
+			Ports: []rdma.Port{{
+				ID: 1,
+				HwStats: map[string]uint64{
+					"rx_good_pkts":      900,
+					"seq_err_naks_rcvd": 3,
+					"tx_cnp_pkts":       17,
+					"tx_roce_only_pkts": 1000,
+				},
+			}},
+		},
+		{
+			Name: "mlx5_0",
+			Ports: []rdma.Port{{
+				ID: 1,
+				HwStats: map[string]uint64{
+					"np_cnp_sent":     5,
+					"rdma_tx_packets": 2000,
+				},
+			}},
+		},
+	}}
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	// The first device a metric is seen on decides its help text.
+	expected := `
+# HELP rdma_np_cnp_sent_total The number of CNP packets transmitted.
+# TYPE rdma_np_cnp_sent_total counter
+rdma_np_cnp_sent_total{device="bnxt_re0",port="1"} 17
+rdma_np_cnp_sent_total{device="mlx5_0",port="1"} 5
+# HELP rdma_packet_seq_err_total The number of sequence error NAKs received.
+# TYPE rdma_packet_seq_err_total counter
+rdma_packet_seq_err_total{device="bnxt_re0",port="1"} 3
+# HELP rdma_rdma_tx_packets_total The number of RoCE packets transmitted, excluding other traffic of the function.
+# TYPE rdma_rdma_tx_packets_total counter
+rdma_rdma_tx_packets_total{device="bnxt_re0",port="1"} 1000
+rdma_rdma_tx_packets_total{device="mlx5_0",port="1"} 2000
+# HELP rdma_rx_good_packets_total The number of RoCE packets received without errors.
+# TYPE rdma_rx_good_packets_total counter
+rdma_rx_good_packets_total{device="bnxt_re0",port="1"} 900
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_np_cnp_sent_total", "rdma_packet_seq_err_total", "rdma_rdma_tx_packets_total", "rdma_rx_good_packets_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if got := c.warnings[WarningUnknownCounter]; got != 0 {
+		t.Fatalf("expected bnxt_re counters to be known, got %d unknown counter warnings", got)
+	}
+}
+
+type stubProcessResourceProvider []rdma.ProcessResourceCount
+
+func (s stubProcessResourceProvider) ProcessResourceCounts(context.Context) ([]rdma.ProcessResourceCount, error) {
+	return s, nil
+}
+
+func TestCollectorExportsProcessResources(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{devices: []rdma.Device{{Name: "mlx5_0"}}}
+	counts := stubProcessResourceProvider{
+		{Device: "mlx5_0", Resource: "mr", PID: 4242, Comm: "trainer", Count: 512},
+		{Device: "mlx5_0", Resource: "qp", PID: 0, Comm: "[ib_core]", Count: 2},
+		{Device: "mlx5_0", Resource: "qp", PID: 4242, Comm: "trainer", Count: 8},
+		{Device: "mlx5_0", Resource: "pd", PID: 4242, Comm: "trainer", Count: 1},
+		{Device: "mlx5_2", Resource: "qp", PID: 4343, Comm: "ib_write_bw", Count: 1},
+	}
+	c := New(provider, newDiscardLogger(), WithProcessResources(counts))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_resource_mr_by_process Number of memory regions a process has allocated on the device, from the kernel's RDMA resource tracking.
+# TYPE rdma_resource_mr_by_process gauge
+rdma_resource_mr_by_process{comm="trainer",device="mlx5_0",pid="4242"} 512
+# HELP rdma_resource_qp_by_process Number of queue pairs a process has allocated on the device, from the kernel's RDMA resource tracking.
+# TYPE rdma_resource_qp_by_process gauge
+rdma_resource_qp_by_process{comm="[ib_core]",device="mlx5_0",pid="0"} 2
+rdma_resource_qp_by_process{comm="trainer",device="mlx5_0",pid="4242"} 8
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_resource_mr_by_process", "rdma_resource_qp_by_process"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+type warningStubProvider struct {
+	stubProvider
+	warnings map[string]uint64
+}
+
+func (w *warningStubProvider) Warnings() map[string]uint64 {
+	return w.warnings
+}
+
+func TestCollectorExportsWarnings(t *testing.T) {
+	t.Parallel()
+
+	provider := &warningStubProvider{
+		stubProvider: stubProvider{
+			devices: []rdma.Device{{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{{
+					ID:      1,
+					Stats:   map[string]uint64{"port_rcv_data": 1, "vendor_private_counter": 2},
+					HwStats: map[string]uint64{"another_new_counter": 3},
+				}},
+			}},
+		},
+		warnings: map[string]uint64{rdma.WarningCounterParse: 4},
+	}
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_exporter_warnings_total Number of non-fatal anomalies met while collecting, such as unparsable counter files or undocumented counters, by type.
+# TYPE rdma_exporter_warnings_total counter
+rdma_exporter_warnings_total{type="counter_parse_error"} 4
+rdma_exporter_warnings_total{type="unknown_counter"} 2
+`
+	// Undocumented counters are counted once, not on every scrape.
+	for range 2 {
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_exporter_warnings_total"); err != nil {
+			t.Fatalf("unexpected metrics output: %v", err)
+		}
+	}
+}
+
+type stubQPCounterProvider []rdma.QPCounter
+
+func (s stubQPCounterProvider) QPCounters(context.Context) ([]rdma.QPCounter, error) {
+	return s, nil
+}
+
+func TestCollectorExportsQPCounters(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{{Name: "mlx5_0"}, {Name: "mlx5_1"}},
+	}
+	counters := stubQPCounterProvider{
+		{Device: "mlx5_0", Port: 1, ID: 1, Mode: "auto", QPType: "RC", QPNs: []uint32{179, 180}, Stats: map[string]uint64{"out_of_sequence": 1}},
+		{Device: "mlx5_0", Port: 1, ID: 2, Mode: "manual", QPNs: []uint32{178}, Stats: map[string]uint64{"packet_seq_err": 3}},
+		{Device: "mlx5_1", Port: 1, ID: 1, Mode: "manual", QPNs: []uint32{7}, Stats: map[string]uint64{"packet_seq_err": 9}},
+		// Not in the snapshot, e.g. silenced.
+		{Device: "mlx5_2", Port: 1, ID: 1, Mode: "manual", QPNs: []uint32{8}, Stats: map[string]uint64{"packet_seq_err": 1}},
+	}
+	c := New(provider, newDiscardLogger(), WithQPCounters(counters, 2))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_qp_counter_qps Number of queue pairs bound to a kernel statistics counter.
+# TYPE rdma_qp_counter_qps gauge
+rdma_qp_counter_qps{counter_id="1",device="mlx5_0",mode="auto",port="1",qp_type="RC"} 2
+rdma_qp_counter_qps{counter_id="2",device="mlx5_0",mode="manual",port="1",qp_type=""} 1
+# HELP rdma_qp_counter_total Hardware counter of the queue pairs bound to a kernel statistics counter. lqpn is only set when a single QP is bound.
+# TYPE rdma_qp_counter_total counter
+rdma_qp_counter_total{counter="out_of_sequence",counter_id="1",device="mlx5_0",lqpn="",port="1",qp_type="RC"} 1
+rdma_qp_counter_total{counter="packet_seq_err",counter_id="2",device="mlx5_0",lqpn="178",port="1",qp_type=""} 3
+# HELP rdma_qp_counters_dropped Number of QP counters left out of the last scrape by the QP counter limit.
+# TYPE rdma_qp_counters_dropped gauge
+rdma_qp_counters_dropped 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_qp_counter_qps", "rdma_qp_counter_total", "rdma_qp_counters_dropped"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestDedupDevicesKeepsDuplicatesWithoutAttributes(t *testing.T) {
+	t.Parallel()
+
+	c := New(&stubProvider{}, newDiscardLogger(), WithDeviceDedup(DedupPCI))
+	c.dedupDevices([]rdma.Device{
+		{Name: "mlx5_0", PCIAddr: "0000:08:00.0"},
+		{Name: "mlx5_bond_0", PCIAddr: "0000:08:00.0"},
+	}, true)
+
+	// Degraded reads carry no PCI address; the last full read decides.
+	got := c.dedupDevices([]rdma.Device{{Name: "mlx5_0"}, {Name: "mlx5_bond_0"}}, false)
+	if len(got) != 1 || got[0].Name != "mlx5_bond_0" {
+		t.Fatalf("expected only mlx5_bond_0, got %+v", got)
+	}
+}
+
+func TestCollectorExportsPortLID(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{
+					{ID: 1, Attributes: rdma.PortAttributes{LinkLayer: "InfiniBand", LID: 0x1a, SMLID: 1, LMC: 2, CapMask: 0xa651e848, HasLID: true}},
+					// RoCE ports report a zero LID, which is not exported.
+					{ID: 2, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", HasLID: true, CapMask: 0x4010000}},
+				},
+			},
+		},
+	}
+
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_port_cap_mask Capability mask (PortInfo:CapabilityMask) of an InfiniBand port, as the integer value of cap_mask.
+# TYPE rdma_port_cap_mask gauge
+rdma_port_cap_mask{device="mlx5_0",port="1"} 2.790385736e+09
+# HELP rdma_port_lid Local identifier (LID) the subnet manager assigned to an InfiniBand port.
+# TYPE rdma_port_lid gauge
+rdma_port_lid{device="mlx5_0",port="1"} 26
+# HELP rdma_port_lmc LID mask control of an InfiniBand port: the port answers to 2^lmc LIDs starting at rdma_port_lid.
+# TYPE rdma_port_lmc gauge
+rdma_port_lmc{device="mlx5_0",port="1"} 2
+# HELP rdma_port_sm_lid LID of the subnet manager managing an InfiniBand port; changes when another SM takes over.
+# TYPE rdma_port_sm_lid gauge
+rdma_port_sm_lid{device="mlx5_0",port="1"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_port_cap_mask", "rdma_port_lid", "rdma_port_lmc", "rdma_port_sm_lid"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestCollectorExportsNumericPortState(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{
+					{ID: 1, Attributes: rdma.PortAttributes{State: "ACTIVE", PhysState: "LINK_UP"}},
+					{ID: 2, Attributes: rdma.PortAttributes{State: "DOWN", PhysState: "DISABLED"}},
+					// Unknown states are left out.
+					{ID: 3, Attributes: rdma.PortAttributes{State: "", PhysState: "BOGUS"}},
+				},
+			},
+		},
+	}
+
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_port_phys_state Physical port state as the numeric value of the sysfs phys_state file: 1=SLEEP, 2=POLLING, 3=DISABLED, 4=PORT_CONFIGURATION_TRAINING, 5=LINK_UP, 6=LINK_ERROR_RECOVERY, 7=PHY_TEST.
+# TYPE rdma_port_phys_state gauge
+rdma_port_phys_state{device="mlx5_0",port="1"} 5
+rdma_port_phys_state{device="mlx5_0",port="2"} 3
+# HELP rdma_port_state Logical port state as the numeric value of the sysfs state file: 0=NOP, 1=DOWN, 2=INIT, 3=ARMED, 4=ACTIVE, 5=ACTIVE_DEFER.
+# TYPE rdma_port_state gauge
+rdma_port_state{device="mlx5_0",port="1"} 4
+rdma_port_state{device="mlx5_0",port="2"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_port_phys_state", "rdma_port_state"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestCollectorExportsSwitchManagementPort(t *testing.T) {
+	t.Parallel()
+
+	provider := rdma.NewSysfsProvider()
+	provider.SetSysfsRoot(filepath.Join("..", "rdma", "testdata", "sysfs", "ibswitch"))
+
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_port_lid Local identifier (LID) the subnet manager assigned to an InfiniBand port.
+# TYPE rdma_port_lid gauge
+rdma_port_lid{device="mlx5_0",port="0"} 5
+# HELP rdma_port_rcv_data_total The total number of data octets, divided by 4 (counting in double words, 32 bits), received on all VLs from the port.
+# TYPE rdma_port_rcv_data_total counter
+rdma_port_rcv_data_total{device="mlx5_0",port="0"} 4.8213377e+07
+# HELP rdma_port_xmit_data_total The total number of data octets, divided by 4, transmitted on all VLs from the port.
+# TYPE rdma_port_xmit_data_total counter
+rdma_port_xmit_data_total{device="mlx5_0",port="0"} 5.1920044e+07
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_port_lid", "rdma_port_rcv_data_total", "rdma_port_xmit_data_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestCollectorExportsNumericLinkRate(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{
+					{ID: 1, Attributes: rdma.PortAttributes{LinkWidth: "4X", LinkSpeed: "100 Gb/sec (4X EDR)"}},
+					{ID: 2, Attributes: rdma.PortAttributes{LinkSpeed: "200 Gb/sec (2X NDR)"}},
+					// Unparsable rates are left out.
+					{ID: 3, Attributes: rdma.PortAttributes{LinkSpeed: "invalid"}},
+				},
+			},
+		},
+	}
+
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_port_link_speed_bps Link rate of the port in bits per second, parsed from the sysfs rate file (e.g. "100 Gb/sec (4X EDR)").
+# TYPE rdma_port_link_speed_bps gauge
+rdma_port_link_speed_bps{device="mlx5_0",port="1"} 1e+11
+rdma_port_link_speed_bps{device="mlx5_0",port="2"} 2e+11
+# HELP rdma_port_link_width_lanes Number of lanes of the port's link, parsed from its width (e.g. "4X").
+# TYPE rdma_port_link_width_lanes gauge
+rdma_port_link_width_lanes{device="mlx5_0",port="1"} 4
+rdma_port_link_width_lanes{device="mlx5_0",port="2"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_port_link_speed_bps", "rdma_port_link_width_lanes"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestCollectorExportsByteCounters(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{{
+					ID:    1,
+					Stats: map[string]uint64{"port_xmit_data": 250, "port_rcv_data": 1000, "port_xmit_packets": 7},
+				}},
+			},
+		},
+	}
+
+	c := New(provider, newDiscardLogger(), WithByteCounters())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	// The word counters are still exported unchanged.
+	expected := `
+# HELP rdma_port_rcv_bytes_total The total number of data octets of port_rcv_data, which counts 4-octet words, multiplied by 4.
+# TYPE rdma_port_rcv_bytes_total counter
+rdma_port_rcv_bytes_total{device="mlx5_0",port="1"} 4000
+# HELP rdma_port_xmit_bytes_total The total number of data octets of port_xmit_data, which counts 4-octet words, multiplied by 4.
+# TYPE rdma_port_xmit_bytes_total counter
+rdma_port_xmit_bytes_total{device="mlx5_0",port="1"} 1000
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_port_rcv_bytes_total", "rdma_port_xmit_bytes_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if got, err := testutil.GatherAndCount(reg, "rdma_port_xmit_data_total"); err != nil || got != 1 {
+		t.Fatalf("expected rdma_port_xmit_data_total to remain, got %d series (err=%v)", got, err)
+	}
+}
+
+func TestCollectorExportsUtilization(t *testing.T) {
+	t.Parallel()
+
+	stats := map[string]uint64{"port_xmit_data": 0, "port_rcv_data": 0}
+	provider := &stubProvider{
+		devices: []rdma.Device{{
+			Name:  "mlx5_0",
+			Ports: []rdma.Port{{ID: 1, Stats: stats, Attributes: rdma.PortAttributes{LinkSpeed: "100 Gb/sec (4X EDR)"}}},
+		}},
+	}
+	c := New(provider, newDiscardLogger(), WithUtilization(20*time.Second))
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	// A 100 Gb/s link carries 3.125e10 4-octet words in 10s.
+	const words = 3.125e10
+	tests := []struct {
+		xmit, rcv uint64
+		expected  string
+	}{
+		// The first read has nothing to compare with.
+		{0, 0, ""},
+		{words / 2, words / 4, `
+rdma_port_utilization_ratio{device="mlx5_0",direction="rx",port="1"} 0.25
+rdma_port_utilization_ratio{device="mlx5_0",direction="tx",port="1"} 0.5
+`},
+		{words * 3 / 2, words / 4, `
+rdma_port_utilization_ratio{device="mlx5_0",direction="rx",port="1"} 0.125
+rdma_port_utilization_ratio{device="mlx5_0",direction="tx",port="1"} 0.75
+`},
+		// The read of the start of the window is the new base.
+		{words * 3 / 2, words / 4, `
+rdma_port_utilization_ratio{device="mlx5_0",direction="rx",port="1"} 0
+rdma_port_utilization_ratio{device="mlx5_0",direction="tx",port="1"} 0.5
+`},
+		// Counter resets restart the window.
+		{0, 0, ""},
+	}
+	for i, tt := range tests {
+		stats["port_xmit_data"] = tt.xmit
+		stats["port_rcv_data"] = tt.rcv
+		if tt.expected == "" {
+			if count, err := testutil.GatherAndCount(reg, "rdma_port_utilization_ratio"); err != nil || count != 0 {
+				t.Fatalf("scrape %d: expected no utilization, got %d series (err=%v)", i, count, err)
+			}
+		} else {
+			expected := `
+# HELP rdma_port_utilization_ratio Share of the port's link rate used over the utilization window, from port_xmit_data (direction tx) and port_rcv_data (direction rx).
+# TYPE rdma_port_utilization_ratio gauge` + tt.expected
+			if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_port_utilization_ratio"); err != nil {
+				t.Fatalf("scrape %d: unexpected metrics output: %v", i, err)
+			}
+		}
+		now = now.Add(10 * time.Second)
+	}
+}
+
+func TestCollectorKeepsUtilizationOnReusedSnapshot(t *testing.T) {
+	t.Parallel()
+
+	stats := map[string]uint64{"port_xmit_data": 0, "port_rcv_data": 0}
+	provider := &stubProvider{
+		devices: []rdma.Device{{
+			Name:  "mlx5_0",
+			Ports: []rdma.Port{{ID: 1, Stats: stats, Attributes: rdma.PortAttributes{LinkSpeed: "100 Gb/sec (4X EDR)"}}},
+		}},
+	}
+	c := New(provider, newDiscardLogger(), WithUtilization(60*time.Second), WithSnapshotLifespan(5*time.Second))
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if count, err := testutil.GatherAndCount(reg, "rdma_port_utilization_ratio"); err != nil || count != 0 {
+		t.Fatalf("expected no utilization on the first read, got %d series (err=%v)", count, err)
+	}
+
+	// A 100 Gb/s link carries 3.125e10 4-octet words in 10s.
+	stats["port_xmit_data"] = 3.125e10 / 2
+	stats["port_rcv_data"] = 3.125e10 / 4
+	now = now.Add(10 * time.Second)
+	expected := `
+# HELP rdma_port_utilization_ratio Share of the port's link rate used over the utilization window, from port_xmit_data (direction tx) and port_rcv_data (direction rx).
+# TYPE rdma_port_utilization_ratio gauge
+rdma_port_utilization_ratio{device="mlx5_0",direction="rx",port="1"} 0.25
+rdma_port_utilization_ratio{device="mlx5_0",direction="tx",port="1"} 0.5
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_port_utilization_ratio"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	// The second scrape within the lifespan reuses the snapshot and its
+	// window.
+	now = now.Add(time.Second)
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_port_utilization_ratio"); err != nil {
+		t.Fatalf("unexpected metrics output on a reused snapshot: %v", err)
+	}
+}
+
+func TestCollectorExportsTopCounters(t *testing.T) {
+	t.Parallel()
+
+	stats := map[string]uint64{"port_xmit_data": 0, "symbol_error": 0, "link_downed": 3}
+	hwStats := map[string]uint64{"out_of_buffer": 0}
+	provider := &stubProvider{
+		devices: []rdma.Device{{
+			Name:  "mlx5_0",
+			Ports: []rdma.Port{{ID: 1, Stats: stats, HwStats: hwStats}},
+		}},
+	}
+	c := New(provider, newDiscardLogger(), WithTopCounters(2, 20*time.Second))
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	tests := []struct {
+		xmit, symbolErrors, outOfBuffer uint64
+		expected                        string
+	}{
+		// The first read has nothing to compare with.
+		{0, 0, 0, ""},
+		// Ties are broken by name; unchanged counters are left out.
+		// port_xmit_data hashes to f1f99c2a, symbol_error to c7e6642c and
+		// hw_counters/out_of_buffer to c8247534.
+		{100, 5, 5, `
+rdma_exporter_top_counter_increase{counter_hash="c8247534",device="mlx5_0",port="1"} 5
+rdma_exporter_top_counter_increase{counter_hash="f1f99c2a",device="mlx5_0",port="1"} 100
+`},
+		{100, 500, 6, `
+rdma_exporter_top_counter_increase{counter_hash="c7e6642c",device="mlx5_0",port="1"} 500
+rdma_exporter_top_counter_increase{counter_hash="f1f99c2a",device="mlx5_0",port="1"} 100
+`},
+		// The read of the start of the window is the new base.
+		{100, 501, 6, `
+rdma_exporter_top_counter_increase{counter_hash="c7e6642c",device="mlx5_0",port="1"} 496
+rdma_exporter_top_counter_increase{counter_hash="c8247534",device="mlx5_0",port="1"} 1
+`},
+	}
+	for i, tt := range tests {
+		stats["port_xmit_data"] = tt.xmit
+		stats["symbol_error"] = tt.symbolErrors
+		hwStats["out_of_buffer"] = tt.outOfBuffer
+		if tt.expected == "" {
+			if count, err := testutil.GatherAndCount(reg, "rdma_exporter_top_counter_increase"); err != nil || count != 0 {
+				t.Fatalf("scrape %d: expected no top counters, got %d series (err=%v)", i, count, err)
+			}
+		} else {
+			expected := `
+# HELP rdma_exporter_top_counter_increase Increase over the top counters window of the counters and hw_counters that increased the most, identified by the FNV-1a hash of the counter name. Only the top counters are exported, so the number of series is bounded.
+# TYPE rdma_exporter_top_counter_increase gauge` + tt.expected
+			if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_exporter_top_counter_increase"); err != nil {
+				t.Fatalf("scrape %d: unexpected metrics output: %v", i, err)
+			}
+		}
+		now = now.Add(10 * time.Second)
+	}
+}
+
+func TestCollectorKeepsTopCountersOnReusedSnapshot(t *testing.T) {
+	t.Parallel()
+
+	stats := map[string]uint64{"symbol_error": 0}
+	provider := &stubProvider{
+		devices: []rdma.Device{{
+			Name:  "mlx5_0",
+			Ports: []rdma.Port{{ID: 1, Stats: stats}},
+		}},
+	}
+	c := New(provider, newDiscardLogger(), WithTopCounters(2, 60*time.Second), WithSnapshotLifespan(5*time.Second))
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_exporter_top_counter_increase Increase over the top counters window of the counters and hw_counters that increased the most, identified by the FNV-1a hash of the counter name. Only the top counters are exported, so the number of series is bounded.
+# TYPE rdma_exporter_top_counter_increase gauge
+rdma_exporter_top_counter_increase{counter_hash="c7e6642c",device="mlx5_0",port="1"} %d
+`
+	scrape := func(increase int) {
+		t.Helper()
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(fmt.Sprintf(expected, increase)), "rdma_exporter_top_counter_increase"); err != nil {
+			t.Fatalf("unexpected metrics output: %v", err)
+		}
+	}
+
+	if count, err := testutil.GatherAndCount(reg, "rdma_exporter_top_counter_increase"); err != nil || count != 0 {
+		t.Fatalf("expected no top counters on the first read, got %d series (err=%v)", count, err)
+	}
+	stats["symbol_error"] = 5
+	now = now.Add(10 * time.Second)
+	scrape(5)
+	// The second scrape within the lifespan reuses the snapshot.
+	now = now.Add(time.Second)
+	scrape(5)
+	// The next fresh read still ranks over the whole window.
+	stats["symbol_error"] = 7
+	now = now.Add(10 * time.Second)
+	scrape(7)
+}
+
+func TestCollectorEmitZerosForMissingKnownCounters(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{
+					{
+						ID:      1,
+						Stats:   map[string]uint64{"port_xmit_data": 10},
+						HwStats: map[string]uint64{"out_of_buffer": 2},
+					},
+				},
+			},
+		},
+	}
+
+	c := New(provider, newDiscardLogger(), WithEmitZeros())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_out_of_buffer_total The number of drops that occurred due to lack of WQE for the associated QPs.
+# TYPE rdma_out_of_buffer_total counter
+rdma_out_of_buffer_total{device="mlx5_0",port="1"} 2
+# HELP rdma_port_rcv_data_total The total number of data octets, divided by 4 (counting in double words, 32 bits), received on all VLs from the port.
+# TYPE rdma_port_rcv_data_total counter
+rdma_port_rcv_data_total{device="mlx5_0",port="1"} 0
+# HELP rdma_port_xmit_data_total The total number of data octets, divided by 4, transmitted on all VLs from the port.
+# TYPE rdma_port_xmit_data_total counter
+rdma_port_xmit_data_total{device="mlx5_0",port="1"} 10
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_out_of_buffer_total", "rdma_port_rcv_data_total", "rdma_port_xmit_data_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+
+	if n, err := testutil.GatherAndCount(reg, "rdma_symbol_error_total"); err != nil || n != 1 {
+		t.Fatalf("expected zero-valued symbol_error series, got %d (err=%v)", n, err)
+	}
+}
+
+func TestZeroStatApplies(t *testing.T) {
+	t.Parallel()
+
+	mlx5 := rdma.Device{Attributes: rdma.DeviceAttributes{Driver: "mlx5_core"}}
+	mlx5VF := rdma.Device{IsVF: true, Attributes: rdma.DeviceAttributes{Driver: "mlx5_core"}}
+	irdma := rdma.Device{Attributes: rdma.DeviceAttributes{Driver: "ice"}}
+	ib := rdma.Port{Attributes: rdma.PortAttributes{LinkLayer: "InfiniBand"}}
+	eth := rdma.Port{Attributes: rdma.PortAttributes{LinkLayer: "Ethernet"}}
+
+	tests := []struct {
+		name   string
+		stat   string
+		device rdma.Device
+		port   rdma.Port
+		want   bool
+	}{
+		{name: "ib counter on any driver", stat: "symbol_error", device: irdma, port: eth, want: true},
+		{name: "mlx5 hw counter", stat: "out_of_buffer", device: mlx5, port: ib, want: true},
+		{name: "mlx5 hw counter on another driver", stat: "out_of_buffer", device: irdma, port: eth, want: false},
+		{name: "roce counter on ib port", stat: "roce_adp_retrans", device: mlx5, port: ib, want: false},
+		{name: "roce counter on roce vf", stat: "roce_adp_retrans", device: mlx5VF, port: eth, want: true},
+		{name: "congestion counter on roce pf", stat: "np_cnp_sent", device: mlx5, port: eth, want: true},
+		{name: "congestion counter on roce vf", stat: "np_cnp_sent", device: mlx5VF, port: eth, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := zeroStatApplies(tt.stat, tt.device, tt.port); got != tt.want {
+				t.Fatalf("zeroStatApplies(%q) = %t, want %t", tt.stat, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectorSchemaV2PacketsByCast(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{{
+			Name: "mlx5_0",
+			Ports: []rdma.Port{{
+				ID: 1,
+				Stats: map[string]uint64{
+					"port_unicast_xmit_packets":   10,
+					"port_multicast_xmit_packets": 2,
+					"port_unicast_rcv_packets":    7,
+					"port_xmit_data":              100,
+				},
+			}},
+		}},
+	}
+
+	c := New(provider, newDiscardLogger(), WithSchemaVersion(SchemaV2), WithEmitZeros())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	// port_multicast_rcv_packets is missing and emitted as zero.
+	expected := `
+# HELP rdma_port_packets_total Unicast and multicast packets sent (direction="tx") or received (direction="rx") by the port, from the port_{unicast,multicast}_{xmit,rcv}_packets counters. Only exported in schema v2.
+# TYPE rdma_port_packets_total counter
+rdma_port_packets_total{cast="multicast",device="mlx5_0",direction="rx",port="1"} 0
+rdma_port_packets_total{cast="multicast",device="mlx5_0",direction="tx",port="1"} 2
+rdma_port_packets_total{cast="unicast",device="mlx5_0",direction="rx",port="1"} 7
+rdma_port_packets_total{cast="unicast",device="mlx5_0",direction="tx",port="1"} 10
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_port_packets_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	for _, name := range []string{"rdma_port_unicast_xmit_packets_total", "rdma_port_multicast_rcv_packets_total"} {
+		if n, err := testutil.GatherAndCount(reg, name); err != nil || n != 0 {
+			t.Fatalf("expected no %s series in schema v2, got %d (err=%v)", name, n, err)
+		}
+	}
+	if n, err := testutil.GatherAndCount(reg, "rdma_port_xmit_data_total"); err != nil || n != 1 {
+		t.Fatalf("expected other counters to keep their names, got %d (err=%v)", n, err)
+	}
+}
+
+func TestCollectorExportsSchemaInfo(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		version string
+	}{
+		{name: "default", version: "1"},
+		{name: "v2", opts: []Option{WithSchemaVersion(SchemaV2)}, version: "2"},
+		{name: "unknown", opts: []Option{WithSchemaVersion(7)}, version: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := New(&stubProvider{}, newDiscardLogger(), tt.opts...)
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(c)
+
+			expected := `
+# HELP rdma_exporter_schema_info Metric schema version the exporter serves, selected with --metrics.schema.
+# TYPE rdma_exporter_schema_info gauge
+rdma_exporter_schema_info{version="` + tt.version + `"} 1
+`
+			if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_exporter_schema_info"); err != nil {
+				t.Fatalf("unexpected metrics output: %v", err)
+			}
+		})
+	}
+}
+
+func TestCollectorOmitsMissingCountersByDefault(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1, Stats: map[string]uint64{"port_xmit_data": 10}}}},
+		},
+	}
+
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if n, err := testutil.GatherAndCount(reg, "rdma_port_rcv_data_total"); err != nil || n != 0 {
+		t.Fatalf("expected no rcv_data series, got %d (err=%v)", n, err)
+	}
+}
+
+type stubLinkSettingsProvider struct {
+	mu       sync.Mutex
+	settings map[string]netdev.LinkSettings
+	calls    int
+}
+
+func (s *stubLinkSettingsProvider) LinkSettings(_ context.Context, netDev string) (netdev.LinkSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return s.settings[netDev], nil
+}
+
+func TestCollectorExportsNetDevLinkSettings(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{
+					{ID: 1, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: "ens1f0np0"}},
+				},
+			},
+		},
+	}
+	links := &stubLinkSettingsProvider{settings: map[string]netdev.LinkSettings{
+		"ens1f0np0": {SpeedMbps: 100000, Duplex: netdev.DuplexFull, Autoneg: true},
+	}}
+
+	c := New(provider, newDiscardLogger(), WithLinkSettingsProvider(links))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("unexpected gather error: %v", err)
+	}
+
+	links.mu.Lock()
+	links.settings["ens1f0np0"] = netdev.LinkSettings{SpeedMbps: 40000, Duplex: netdev.DuplexFull, Autoneg: true}
+	links.mu.Unlock()
+
+	expected := `
+# HELP rdma_netdev_link_autoneg Whether autonegotiation is enabled (1) on the netdev backing a RoCE port, from ethtool.
+# TYPE rdma_netdev_link_autoneg gauge
+rdma_netdev_link_autoneg{device="mlx5_0",netdev="ens1f0np0",port="1"} 1
+# HELP rdma_netdev_link_full_duplex Whether the netdev backing a RoCE port runs full duplex (1) or half duplex (0), from ethtool.
+# TYPE rdma_netdev_link_full_duplex gauge
+rdma_netdev_link_full_duplex{device="mlx5_0",netdev="ens1f0np0",port="1"} 1
+# HELP rdma_netdev_link_settings_changes_total Number of times the netdev's negotiated link setting changed between scrapes since the exporter started.
+# TYPE rdma_netdev_link_settings_changes_total counter
+rdma_netdev_link_settings_changes_total{device="mlx5_0",netdev="ens1f0np0",port="1",setting="autoneg"} 0
+rdma_netdev_link_settings_changes_total{device="mlx5_0",netdev="ens1f0np0",port="1",setting="duplex"} 0
+rdma_netdev_link_settings_changes_total{device="mlx5_0",netdev="ens1f0np0",port="1",setting="speed"} 1
+# HELP rdma_netdev_link_speed_bps Negotiated link speed of the netdev backing a RoCE port in bits per second, from ethtool.
+# TYPE rdma_netdev_link_speed_bps gauge
+rdma_netdev_link_speed_bps{device="mlx5_0",netdev="ens1f0np0",port="1"} 4e+10
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_netdev_link_autoneg", "rdma_netdev_link_full_duplex",
+		"rdma_netdev_link_settings_changes_total", "rdma_netdev_link_speed_bps"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestCollectorForgetsVanishedNetDevLinks(t *testing.T) {
+	t.Parallel()
+
+	port := func(netDev string) []rdma.Device {
+		return []rdma.Device{{
+			Name:  "mlx5_0",
+			Ports: []rdma.Port{{ID: 1, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: netDev}}},
+		}}
+	}
+	provider := &stubProvider{devices: port("ens1f0np0")}
+	links := &stubLinkSettingsProvider{settings: map[string]netdev.LinkSettings{
+		"ens1f0np0": {SpeedMbps: 100000, Duplex: netdev.DuplexFull},
+		"ens1f0":    {SpeedMbps: 100000, Duplex: netdev.DuplexFull},
+	}}
+
+	c := New(provider, newDiscardLogger(), WithLinkSettingsProvider(links))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("unexpected gather error: %v", err)
+	}
+	provider.devices = port("ens1f0")
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("unexpected gather error: %v", err)
+	}
+
+	if _, ok := c.links.last["ens1f0np0"]; ok {
+		t.Fatalf("expected the renamed netdev to be forgotten")
+	}
+	if _, ok := c.links.changes["ens1f0np0"]; ok {
+		t.Fatalf("expected the change counts of the renamed netdev to be forgotten")
+	}
+	if _, ok := c.links.last["ens1f0"]; !ok {
+		t.Fatalf("expected the new netdev to be tracked")
+	}
+}
+
+type stubDCBProvider map[string]rdma.DCBConfig
+
+func (s stubDCBProvider) DCB(_ context.Context, netDev string) (rdma.DCBConfig, error) {
+	return s[netDev], nil
+}
+
+func TestCollectorExportsDCB(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{
+					{ID: 1, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: "ens1f0np0"}},
+				},
+			},
+			{
+				// A netdev whose driver does not implement dcbnl.
+				Name: "rxe0",
+				Ports: []rdma.Port{
+					{ID: 1, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: "eth0"}},
+				},
+			},
+			{
+				Name: "mlx5_2",
+				IsVF: true,
+				Ports: []rdma.Port{
+					{ID: 1, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: "ens1f0v0"}},
+				},
+			},
+		},
+	}
+	dcb := stubDCBProvider{
+		"ens1f0np0": {
+			PFC:          true,
+			PFCEnabled:   1 << 3,
+			PFCDelay:     32,
+			ETS:          true,
+			ETSBandwidth: [8]uint8{40, 60},
+			PriorityTC:   [8]uint8{0, 0, 0, 1},
+		},
+		"ens1f0v0": {PFC: true, PFCEnabled: 0xff},
+	}
+
+	c := New(provider, newDiscardLogger(), WithDCB(dcb))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_ets_bandwidth_percent ETS share of transmit bandwidth of a traffic class of the netdev of a RoCE port, from dcbnl.
+# TYPE rdma_ets_bandwidth_percent gauge
+rdma_ets_bandwidth_percent{device="mlx5_0",netdev="ens1f0np0",port="1",tc="0"} 40
+rdma_ets_bandwidth_percent{device="mlx5_0",netdev="ens1f0np0",port="1",tc="1"} 60
+rdma_ets_bandwidth_percent{device="mlx5_0",netdev="ens1f0np0",port="1",tc="2"} 0
+rdma_ets_bandwidth_percent{device="mlx5_0",netdev="ens1f0np0",port="1",tc="3"} 0
+rdma_ets_bandwidth_percent{device="mlx5_0",netdev="ens1f0np0",port="1",tc="4"} 0
+rdma_ets_bandwidth_percent{device="mlx5_0",netdev="ens1f0np0",port="1",tc="5"} 0
+rdma_ets_bandwidth_percent{device="mlx5_0",netdev="ens1f0np0",port="1",tc="6"} 0
+rdma_ets_bandwidth_percent{device="mlx5_0",netdev="ens1f0np0",port="1",tc="7"} 0
+# HELP rdma_ets_priority_traffic_class Traffic class a priority of the netdev of a RoCE port is mapped to by ETS, from dcbnl.
+# TYPE rdma_ets_priority_traffic_class gauge
+rdma_ets_priority_traffic_class{device="mlx5_0",netdev="ens1f0np0",port="1",priority="0"} 0
+rdma_ets_priority_traffic_class{device="mlx5_0",netdev="ens1f0np0",port="1",priority="1"} 0
+rdma_ets_priority_traffic_class{device="mlx5_0",netdev="ens1f0np0",port="1",priority="2"} 0
+rdma_ets_priority_traffic_class{device="mlx5_0",netdev="ens1f0np0",port="1",priority="3"} 1
+rdma_ets_priority_traffic_class{device="mlx5_0",netdev="ens1f0np0",port="1",priority="4"} 0
+rdma_ets_priority_traffic_class{device="mlx5_0",netdev="ens1f0np0",port="1",priority="5"} 0
+rdma_ets_priority_traffic_class{device="mlx5_0",netdev="ens1f0np0",port="1",priority="6"} 0
+rdma_ets_priority_traffic_class{device="mlx5_0",netdev="ens1f0np0",port="1",priority="7"} 0
+# HELP rdma_pfc_delay_bits PFC delay allowance for the round-trip delay of the link of a RoCE port's netdev, in bits, from dcbnl.
+# TYPE rdma_pfc_delay_bits gauge
+rdma_pfc_delay_bits{device="mlx5_0",netdev="ens1f0np0",port="1"} 32
+# HELP rdma_pfc_priority_enabled Whether PFC is enabled (1) or not (0) for a priority of the netdev of a RoCE port, from dcbnl.
+# TYPE rdma_pfc_priority_enabled gauge
+rdma_pfc_priority_enabled{device="mlx5_0",netdev="ens1f0np0",port="1",priority="0"} 0
+rdma_pfc_priority_enabled{device="mlx5_0",netdev="ens1f0np0",port="1",priority="1"} 0
+rdma_pfc_priority_enabled{device="mlx5_0",netdev="ens1f0np0",port="1",priority="2"} 0
+rdma_pfc_priority_enabled{device="mlx5_0",netdev="ens1f0np0",port="1",priority="3"} 1
+rdma_pfc_priority_enabled{device="mlx5_0",netdev="ens1f0np0",port="1",priority="4"} 0
+rdma_pfc_priority_enabled{device="mlx5_0",netdev="ens1f0np0",port="1",priority="5"} 0
+rdma_pfc_priority_enabled{device="mlx5_0",netdev="ens1f0np0",port="1",priority="6"} 0
+rdma_pfc_priority_enabled{device="mlx5_0",netdev="ens1f0np0",port="1",priority="7"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_ets_bandwidth_percent", "rdma_ets_priority_traffic_class",
+		"rdma_pfc_delay_bits", "rdma_pfc_priority_enabled"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestCollectorWithholdsDerivedMetricsDuringWarmup(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{
+					{ID: 1, Stats: map[string]uint64{"port_xmit_data": 10}, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: "ens1f0np0"}},
+				},
+			},
+		},
+	}
+	links := &stubLinkSettingsProvider{settings: map[string]netdev.LinkSettings{
+		"ens1f0np0": {SpeedMbps: 100000, Duplex: netdev.DuplexFull, Autoneg: true},
+	}}
+
+	c := New(provider, newDiscardLogger(), WithStatefulMode(), WithLinkSettingsProvider(links), WithWarmup(time.Minute))
+	now := time.Unix(1000, 0)
+	c.startTime = now
+	c.now = func() time.Time { return now }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if count, err := testutil.GatherAndCount(reg, "rdma_port_idle_seconds", "rdma_netdev_link_settings_changes_total"); err != nil || count != 0 {
+		t.Fatalf("expected derived metrics to be withheld while warming up, got %d (err=%v)", count, err)
+	}
+
+	// A renegotiation during the warm-up window only moves the baseline.
+	links.mu.Lock()
+	links.settings["ens1f0np0"] = netdev.LinkSettings{SpeedMbps: 25000, Duplex: netdev.DuplexFull, Autoneg: true}
+	links.mu.Unlock()
+	now = now.Add(30 * time.Second)
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("unexpected gather error: %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	expected := `
+# HELP rdma_exporter_warming_up Whether the exporter is within its warm-up window after startup (1), during which metrics derived from earlier scrapes are withheld, or not (0).
+# TYPE rdma_exporter_warming_up gauge
+rdma_exporter_warming_up 0
+# HELP rdma_netdev_link_settings_changes_total Number of times the netdev's negotiated link setting changed between scrapes since the exporter started.
+# TYPE rdma_netdev_link_settings_changes_total counter
+rdma_netdev_link_settings_changes_total{device="mlx5_0",netdev="ens1f0np0",port="1",setting="autoneg"} 0
+rdma_netdev_link_settings_changes_total{device="mlx5_0",netdev="ens1f0np0",port="1",setting="duplex"} 0
+rdma_netdev_link_settings_changes_total{device="mlx5_0",netdev="ens1f0np0",port="1",setting="speed"} 0
+# HELP rdma_port_idle_seconds Seconds since the port's port_xmit_data or port_rcv_data counter last changed. Only exported in stateful mode.
+# TYPE rdma_port_idle_seconds gauge
+rdma_port_idle_seconds{device="mlx5_0",port="1"} 60
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_exporter_warming_up", "rdma_netdev_link_settings_changes_total", "rdma_port_idle_seconds"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+type stubGIDTableProvider struct {
+	entries []rdma.GIDEntry
+}
+
+func (s *stubGIDTableProvider) GIDTable(context.Context) ([]rdma.GIDEntry, error) {
+	return s.entries, nil
+}
+
+func TestCollectorExportsGIDTable(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1}}}},
+	}
+	gids := &stubGIDTableProvider{entries: []rdma.GIDEntry{
+		{Device: "mlx5_0", Port: 1, Index: 1, GID: "fe80:0000:0000:0000:0e42:a1ff:fe03:0001", Type: "RoCE v2", NetDev: "ens1f0np0"},
+		{Device: "mlx5_0", Port: 1, Index: 3, GID: "0000:0000:0000:0000:0000:ffff:0a00:0a01", Type: "RoCE v2", NetDev: "ens1f0np0.100"},
+		// Devices missing from the snapshot, e.g. silenced ones, are skipped.
+		{Device: "mlx5_1", Port: 1, Index: 0, GID: "fe80:0000:0000:0000:0e42:a1ff:fe03:0002", Type: "RoCE v2", NetDev: "ens2f0np0"},
+	}}
+
+	c := New(provider, newDiscardLogger(), WithGIDTable(gids))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_port_gid_info Populated entry of the port's GID table with its type ("IB/RoCE v1" or "RoCE v2") and netdev, from /sys/class/infiniband/<dev>/ports/<port>/gids and gid_attrs.
+# TYPE rdma_port_gid_info gauge
+rdma_port_gid_info{device="mlx5_0",gid="0000:0000:0000:0000:0000:ffff:0a00:0a01",index="3",netdev="ens1f0np0.100",port="1",type="RoCE v2"} 1
+rdma_port_gid_info{device="mlx5_0",gid="fe80:0000:0000:0000:0e42:a1ff:fe03:0001",index="1",netdev="ens1f0np0",port="1",type="RoCE v2"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_port_gid_info"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+type stubPKeyTableProvider struct {
+	entries []rdma.PKeyEntry
+}
+
+func (s *stubPKeyTableProvider) PKeyTable(context.Context) ([]rdma.PKeyEntry, error) {
+	return s.entries, nil
+}
+
+func TestCollectorExportsPKeyTable(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1}}}},
+	}
+	pkeys := &stubPKeyTableProvider{entries: []rdma.PKeyEntry{
+		{Device: "mlx5_0", Port: 1, Index: 0, PKey: "0xffff"},
+		{Device: "mlx5_0", Port: 1, Index: 1, PKey: "0x0a12"},
+		{Device: "mlx5_1", Port: 1, Index: 0, PKey: "0xffff"},
+	}}
+
+	c := New(provider, newDiscardLogger(), WithPKeyTable(pkeys))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_port_pkey_info Populated entry of the port's partition key table, from /sys/class/infiniband/<dev>/ports/<port>/pkeys. Bit 15 of pkey is set for full and clear for limited membership.
+# TYPE rdma_port_pkey_info gauge
+rdma_port_pkey_info{device="mlx5_0",index="0",pkey="0xffff",port="1"} 1
+rdma_port_pkey_info{device="mlx5_0",index="1",pkey="0x0a12",port="1"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_port_pkey_info"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+type stubRoCEConfigProvider []rdma.RoCEConfig
+
+func (s stubRoCEConfigProvider) RoCEConfigs(context.Context) ([]rdma.RoCEConfig, error) {
+	return s, nil
+}
+
+func TestCollectorExportsRoCEConfig(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{devices: []rdma.Device{
+		{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1}}},
+		{Name: "mlx5_1", Ports: []rdma.Port{{ID: 1}}},
+	}}
+	configs := stubRoCEConfigProvider{
+		{
+			Device: "mlx5_0", Port: 1, NetDev: "ens1f0np0", DefaultToS: 106, DefaultRoCEVersion: 2, TrafficClass: -1, Trust: "dscp",
+			ECN: []rdma.ECNConfig{{Point: "np", Priority: 3, Enabled: true}, {Point: "rp", Priority: 3, Enabled: false}},
+			DCQCN: []rdma.DCQCNParam{
+				{Point: "np", Name: "cnp_dscp", Value: 48},
+				{Point: "rp", Name: "rpg_min_rate", Value: 1},
+			},
+		},
+		{Device: "mlx5_1", Port: 1, NetDev: "ens2f0np0", DefaultToS: -1, DefaultRoCEVersion: 1, TrafficClass: -1},
+		{Device: "mlx5_2", Port: 1, NetDev: "ens3f0np0", DefaultToS: 106, TrafficClass: -1},
+	}
+	c := New(provider, newDiscardLogger(), WithRoCEConfig(configs))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_roce_dcqcn_parameter DCQCN congestion control parameter of the netdev of a RoCE port at the notification point (np) or reaction point (rp), in the parameter's own unit.
+# TYPE rdma_roce_dcqcn_parameter gauge
+rdma_roce_dcqcn_parameter{device="mlx5_0",parameter="cnp_dscp",point="np",port="1"} 48
+rdma_roce_dcqcn_parameter{device="mlx5_0",parameter="rpg_min_rate",point="rp",port="1"} 1
+# HELP rdma_roce_default_tos ToS byte of RDMA CM connections of a RoCE port that do not set one, from configfs rdma_cm default_roce_tos.
+# TYPE rdma_roce_default_tos gauge
+rdma_roce_default_tos{device="mlx5_0",port="1"} 106
+# HELP rdma_roce_default_version RoCE version (1 or 2) RDMA CM connections of a RoCE port use, from configfs rdma_cm default_roce_mode.
+# TYPE rdma_roce_default_version gauge
+rdma_roce_default_version{device="mlx5_0",port="1"} 2
+rdma_roce_default_version{device="mlx5_1",port="1"} 1
+# HELP rdma_roce_ecn_enabled Whether ECN is enabled (1) or not (0) for a priority of the netdev of a RoCE port, at the notification point (np) or reaction point (rp).
+# TYPE rdma_roce_ecn_enabled gauge
+rdma_roce_ecn_enabled{device="mlx5_0",point="np",port="1",priority="3"} 1
+rdma_roce_ecn_enabled{device="mlx5_0",point="rp",port="1",priority="3"} 0
+# HELP rdma_roce_qos_info QoS configuration of a RoCE port: the trust state of its netdev, the default ToS and DSCP of RDMA CM connections and the traffic class forced by MLNX_OFED. Labels are empty when unset.
+# TYPE rdma_roce_qos_info gauge
+rdma_roce_qos_info{default_dscp="26",default_tos="106",device="mlx5_0",netdev="ens1f0np0",port="1",traffic_class="",trust="dscp"} 1
+rdma_roce_qos_info{default_dscp="",default_tos="",device="mlx5_1",netdev="ens2f0np0",port="1",traffic_class="",trust=""} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_roce_dcqcn_parameter", "rdma_roce_default_tos", "rdma_roce_default_version", "rdma_roce_ecn_enabled", "rdma_roce_qos_info"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+// blockingGIDTableProvider waits until its context is done.
+type blockingGIDTableProvider struct{}
+
+func (blockingGIDTableProvider) GIDTable(ctx context.Context) ([]rdma.GIDEntry, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCollectorTimeoutBoundsSingleCollector(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1}}}},
+	}
+	c := New(provider, newDiscardLogger(),
+		WithGIDTable(blockingGIDTableProvider{}),
+		WithCollectorTimeouts(map[string]time.Duration{
+			"gid_table":   10 * time.Millisecond,
+			"qp_counters": time.Second,
+			"roce_config": time.Second,
+			"transceiver": time.Second,
+		}),
+	)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+
+	// The other collectors complete; unknown collectors are ignored.
+	expected := `
+# HELP rdma_devices Number of RDMA devices found by the last collection, after exclusions.
+# TYPE rdma_devices gauge
+rdma_devices 1
+# HELP rdma_exporter_collector_timeouts_total Number of scrapes in which a collector was cut off by its --collect.<collector>.timeout.
+# TYPE rdma_exporter_collector_timeouts_total counter
+rdma_exporter_collector_timeouts_total{collector="gid_table"} 2
+rdma_exporter_collector_timeouts_total{collector="qp_counters"} 0
+rdma_exporter_collector_timeouts_total{collector="roce_config"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_devices", "rdma_exporter_collector_timeouts_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+// slowProvider returns devices on the first call and waits until its
+// context is done on later ones.
+type slowProvider struct {
+	devices []rdma.Device
+	calls   int
+}
+
+func (p *slowProvider) Devices(ctx context.Context) ([]rdma.Device, error) {
+	p.calls++
+	if p.calls == 1 {
+		return p.devices, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCollectorCountersTimeoutServesLastDevices(t *testing.T) {
+	t.Parallel()
+
+	provider := &slowProvider{
+		devices: []rdma.Device{{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1, Stats: map[string]uint64{"port_rcv_data": 10}}}}},
+	}
+	c := New(provider, newDiscardLogger(),
+		WithCollectorTimeouts(map[string]time.Duration{"counters": 10 * time.Millisecond}),
+	)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	for range 2 {
+		if _, err := reg.Gather(); err != nil {
+			t.Fatalf("gather: %v", err)
+		}
+	}
+
+	// The timed-out read serves the devices of the first scrape without
+	// counting as a scrape error.
+	expected := `
+# HELP rdma_devices Number of RDMA devices found by the last collection, after exclusions.
+# TYPE rdma_devices gauge
+rdma_devices 1
+# HELP rdma_exporter_collector_timeouts_total Number of scrapes in which a collector was cut off by its --collect.<collector>.timeout.
+# TYPE rdma_exporter_collector_timeouts_total counter
+rdma_exporter_collector_timeouts_total{collector="counters"} 2
+# HELP rdma_port_rcv_data_total The total number of data octets, divided by 4 (counting in double words, 32 bits), received on all VLs from the port.
+# TYPE rdma_port_rcv_data_total counter
+rdma_port_rcv_data_total{device="mlx5_0",port="1"} 10
+# HELP rdma_scrape_errors_total Total number of errors encountered while scraping RDMA sysfs.
+# TYPE rdma_scrape_errors_total counter
+rdma_scrape_errors_total 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_devices", "rdma_exporter_collector_timeouts_total", "rdma_port_rcv_data_total", "rdma_scrape_errors_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+type stubNetDevStatisticsProvider struct {
+	stats map[string]map[string]uint64
+	calls int
+}
+
+func (s *stubNetDevStatisticsProvider) NetDevStatistics(_ context.Context, netDev string) (map[string]uint64, error) {
+	s.calls++
+	stats, ok := s.stats[netDev]
+	if !ok {
+		return nil, errors.New("no such netdev")
+	}
+	return stats, nil
+}
+
+func TestCollectorExportsNetDevStatistics(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_bond_0",
+				Ports: []rdma.Port{
+					{ID: 1, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: "bond0"}},
+					{ID: 2, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: "bond0"}},
+				},
+			},
+			{
+				Name: "mlx5_1",
+				Ports: []rdma.Port{
+					{ID: 1, Attributes: rdma.PortAttributes{LinkLayer: "InfiniBand", NetDev: "ib0"}},
+				},
+			},
+		},
+	}
+	stats := &stubNetDevStatisticsProvider{stats: map[string]map[string]uint64{
+		"bond0": {"rx_dropped": 12, "tx_errors": 3},
+		"ib0":   {"rx_dropped": 1},
+	}}
+
+	c := New(provider, newDiscardLogger(), WithNetDevStatistics(stats))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_netdev_rx_dropped_total Netdev counter rx_dropped of the interface backing a RoCE port, from /sys/class/net/<netdev>/statistics.
+# TYPE rdma_netdev_rx_dropped_total counter
+rdma_netdev_rx_dropped_total{device="mlx5_bond_0",netdev="bond0",port="1"} 12
+rdma_netdev_rx_dropped_total{device="mlx5_bond_0",netdev="bond0",port="2"} 12
+# HELP rdma_netdev_tx_errors_total Netdev counter tx_errors of the interface backing a RoCE port, from /sys/class/net/<netdev>/statistics.
+# TYPE rdma_netdev_tx_errors_total counter
+rdma_netdev_tx_errors_total{device="mlx5_bond_0",netdev="bond0",port="1"} 3
+rdma_netdev_tx_errors_total{device="mlx5_bond_0",netdev="bond0",port="2"} 3
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_netdev_rx_dropped_total", "rdma_netdev_tx_errors_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if stats.calls != 1 {
+		t.Fatalf("expected the shared netdev to be read once per scrape, got %d reads", stats.calls)
+	}
+}
+
+func TestCollectorExportsNetDevEthtoolStats(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{
+					{ID: 1, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: "ens1f0np0"}},
+				},
+			},
+			{
+				Name: "mlx5_2",
+				IsVF: true,
+				Ports: []rdma.Port{
+					{ID: 1, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: "ens1f0v0"}},
+				},
+			},
+		},
+	}
+	netDevProvider := newStubNetDevStatsProvider()
+	netDevProvider.stats["ens1f0np0"] = map[string]uint64{
+		"rx_discards_phy":               4,
+		"rx_vport_rdma_unicast_packets": 100,
+		"rx0_packets":                   7,
+		"rx_prio3_pause":                2,
+	}
+	netDevProvider.stats["ens1f0v0"] = map[string]uint64{"rx_discards_phy": 1}
+
+	c := New(provider, newDiscardLogger(),
+		WithNetDevStatsProvider(netDevProvider),
+		WithNetDevEthtoolStats(netDevProvider, []string{"rx_discards_phy", "rx_vport_rdma_*"}))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_netdev_ethtool_rx_discards_phy_total Ethtool statistic rx_discards_phy of the interface backing a RoCE port.
+# TYPE rdma_netdev_ethtool_rx_discards_phy_total counter
+rdma_netdev_ethtool_rx_discards_phy_total{device="mlx5_0",netdev="ens1f0np0",port="1"} 4
+# HELP rdma_netdev_ethtool_rx_vport_rdma_unicast_packets_total Ethtool statistic rx_vport_rdma_unicast_packets of the interface backing a RoCE port.
+# TYPE rdma_netdev_ethtool_rx_vport_rdma_unicast_packets_total counter
+rdma_netdev_ethtool_rx_vport_rdma_unicast_packets_total{device="mlx5_0",netdev="ens1f0np0",port="1"} 100
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_netdev_ethtool_rx_discards_phy_total", "rdma_netdev_ethtool_rx_vport_rdma_unicast_packets_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if n, err := testutil.GatherAndCount(reg, "rdma_netdev_ethtool_rx0_packets_total"); err != nil || n != 0 {
+		t.Fatalf("expected unselected stats to be left out, got %d series (err %v)", n, err)
+	}
+	// PFC and the selected stats share one read per netdev and scrape; VFs
+	// are not read.
+	netDevProvider.mu.Lock()
+	defer netDevProvider.mu.Unlock()
+	if got := netDevProvider.calls["ens1f0np0"]; got != 2 {
+		t.Fatalf("expected one ethtool read per scrape over 2 scrapes, got %d", got)
+	}
+	if got := netDevProvider.calls["ens1f0v0"]; got != 0 {
+		t.Fatalf("expected VF netdev not to be read, got %d reads", got)
+	}
+}
+
+// failFirstNetDevStatsProvider fails its first read, as a PFC read cut off
+// by its own timeout would.
+type failFirstNetDevStatsProvider struct {
+	stats map[string]uint64
+	calls int
+}
+
+func (p *failFirstNetDevStatsProvider) Stats(context.Context, string) (map[string]uint64, error) {
+	p.calls++
+	if p.calls == 1 {
+		return nil, context.DeadlineExceeded
+	}
+	return p.stats, nil
+}
+
+func TestCollectorNetDevEthtoolStatsSkipsCollisionsAndPFCErrors(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{{
+			Name: "mlx5_0",
+			Ports: []rdma.Port{
+				{ID: 1, Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: "ens1f0np0"}},
+			},
+		}},
+	}
+	netDevProvider := &failFirstNetDevStatsProvider{stats: map[string]uint64{"foo.nic": 1, "foo_nic": 2}}
+	c := New(provider, newDiscardLogger(),
+		WithNetDevStatsProvider(netDevProvider),
+		WithNetDevEthtoolStats(netDevProvider, []string{"foo*"}))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	// The failed PFC read is not served to the ethtool collector, which
+	// reads on its own; of the two statistics named rdma_netdev_ethtool_foo_nic_total
+	// only the first is exported.
+	expected := `
+# HELP rdma_netdev_ethtool_foo_nic_total Ethtool statistic foo.nic of the interface backing a RoCE port.
+# TYPE rdma_netdev_ethtool_foo_nic_total counter
+rdma_netdev_ethtool_foo_nic_total{device="mlx5_0",netdev="ens1f0np0",port="1"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_netdev_ethtool_foo_nic_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if netDevProvider.calls != 2 {
+		t.Fatalf("expected the ethtool collector to read again after the PFC failure, got %d reads", netDevProvider.calls)
+	}
+}
+
+type stubDeepScanProvider struct {
+	aer   []rdma.DeviceAER
+	calls int
+}
+
+func (s *stubDeepScanProvider) AER(context.Context) ([]rdma.DeviceAER, error) {
+	s.calls++
+	return s.aer, nil
+}
+
+func TestCollectorDeepScanKeptForLaterScrapes(t *testing.T) {
+	t.Parallel()
+
+	deep := &stubDeepScanProvider{
+		aer: []rdma.DeviceAER{
+			{
+				Device: "mlx5_0",
+				Counters: map[string]map[string]uint64{
+					"correctable": {"BadTLP": 1},
+					"fatal":       {"TOTAL_ERR_FATAL": 0},
+				},
+			},
+		},
+	}
+	c := New(&stubProvider{}, newDiscardLogger(), WithDeepScan(deep))
+	c.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if count, err := testutil.GatherAndCount(reg, "rdma_device_pcie_aer_errors_total"); err != nil || count != 0 {
+		t.Fatalf("expected no AER series before a deep scan, got %d (err=%v)", count, err)
+	}
+
+	if err := c.DeepScan(context.Background()); err != nil {
+		t.Fatalf("DeepScan returned error: %v", err)
+	}
+	if deep.calls != 1 {
+		t.Fatalf("expected one provider call, got %d", deep.calls)
+	}
+
+	expected := `
+# HELP rdma_device_pcie_aer_errors_total PCIe Advanced Error Reporting counters of the device's PCI function, as read by the last deep scan.
+# TYPE rdma_device_pcie_aer_errors_total counter
+rdma_device_pcie_aer_errors_total{device="mlx5_0",error="BadTLP",severity="correctable"} 1
+rdma_device_pcie_aer_errors_total{device="mlx5_0",error="TOTAL_ERR_FATAL",severity="fatal"} 0
+# HELP rdma_exporter_deep_scan_timestamp_seconds Unix time of the last deep scan, whose results are included in every scrape.
+# TYPE rdma_exporter_deep_scan_timestamp_seconds gauge
+rdma_exporter_deep_scan_timestamp_seconds 1.7e+09
+`
+	// The counters must not disappear between deep scans, or rate() and
+	// increase() over them would see resets.
+	for i := 0; i < 3; i++ {
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+			"rdma_device_pcie_aer_errors_total", "rdma_exporter_deep_scan_timestamp_seconds"); err != nil {
+			t.Fatalf("scrape %d: unexpected metrics: %v", i+1, err)
+		}
+	}
+	if deep.calls != 1 {
+		t.Fatalf("scrapes must not trigger the deep scan provider, got %d calls", deep.calls)
+	}
+}
+
+func TestCollectorDeepScanDisabled(t *testing.T) {
+	t.Parallel()
+
+	c := New(&stubProvider{}, newDiscardLogger())
+	if err := c.DeepScan(context.Background()); !errors.Is(err, ErrDeepScanDisabled) {
+		t.Fatalf("expected ErrDeepScanDisabled, got %v", err)
+	}
+}
+
+func TestCollectorExportsPCIeLimited(t *testing.T) {
+	t.Parallel()
+
+	activePort := func(id int, speed string) rdma.Port {
+		return rdma.Port{ID: id, Attributes: rdma.PortAttributes{State: "ACTIVE", LinkSpeed: speed}}
+	}
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				// HDR200 on Gen3 x16 (~126 Gb/s) cannot sustain line rate.
+				Name:     "mlx5_0",
+				PCIeLink: rdma.PCIeLink{SpeedGTs: 8, Width: 16},
+				Ports:    []rdma.Port{activePort(1, "200 Gb/sec (4X HDR)")},
+			},
+			{
+				Name:     "mlx5_1",
+				PCIeLink: rdma.PCIeLink{SpeedGTs: 16, Width: 16},
+				Ports: []rdma.Port{
+					activePort(1, "100 Gb/sec (4X EDR)"),
+					{ID: 2, Attributes: rdma.PortAttributes{State: "DOWN", LinkSpeed: "100 Gb/sec (4X EDR)"}},
+				},
+			},
+			{
+				// Unknown PCIe link (e.g. a VF) is not reported.
+				Name:  "mlx5_2",
+				Ports: []rdma.Port{activePort(1, "200 Gb/sec (4X HDR)")},
+			},
+		},
+	}
+
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_device_pcie_limited Whether the negotiated PCIe link bandwidth is below the combined line rate of the device's active ports (1) or not (0).
+# TYPE rdma_device_pcie_limited gauge
+rdma_device_pcie_limited{device="mlx5_0"} 1
+rdma_device_pcie_limited{device="mlx5_1"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_device_pcie_limited"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
+
+func TestCollectorSuppressUnchangedCounters(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{
+					{
+						ID:      1,
+						Stats:   map[string]uint64{"port_xmit_data": 10},
+						HwStats: map[string]uint64{"out_of_buffer": 0},
+					},
+				},
+			},
+		},
+	}
+
+	c := New(provider, newDiscardLogger(), WithSuppressUnchanged(2, 3))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	count := func() int {
+		t.Helper()
+		n, err := testutil.GatherAndCount(reg, "rdma_port_xmit_data_total", "rdma_out_of_buffer_total")
+		if err != nil {
+			t.Fatalf("unexpected gather error: %v", err)
+		}
+		return n
+	}
+
+	// Scrapes 1-2 emit (new, then unchanged once); scrapes 3-4 are
+	// suppressed; scrape 5 is the keep-alive, three scrapes after the last
+	// emission.
+	want := []int{2, 2, 0, 0, 2, 0}
+	for i, w := range want {
+		if got := count(); got != w {
+			t.Fatalf("scrape %d: expected %d series, got %d", i+1, w, got)
+		}
+	}
+
+	provider.devices[0].Ports[0].Stats = map[string]uint64{"port_xmit_data": 11}
+	if got := count(); got != 1 {
+		t.Fatalf("expected only the changed counter after suppression, got %d series", got)
+	}
+}
+
+func TestCollectorExportsTickDuration(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{
+				Name: "mlx5_0",
+				Ports: []rdma.Port{
+					{ID: 1, Stats: map[string]uint64{"port_xmit_wait": 7}, TickDuration: 2 * time.Microsecond},
+					// Falls back to the configured tick length.
+					{ID: 2, Stats: map[string]uint64{"port_xmit_wait": 9}},
+					// No tick counter to convert.
+					{ID: 3, Stats: map[string]uint64{"port_rcv_data": 1}},
+				},
+			},
+		},
+	}
+
+	c := New(provider, newDiscardLogger(), WithTickDuration(time.Microsecond))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_counter_unit_info Unit of RDMA counters whose value is not a plain event count.
+# TYPE rdma_counter_unit_info gauge
+rdma_counter_unit_info{counter="port_xmit_wait",unit="ticks"} 1
+# HELP rdma_port_tick_duration_seconds Length of one tick of tick-based counters such as port_xmit_wait. Only exported when known.
+# TYPE rdma_port_tick_duration_seconds gauge
+rdma_port_tick_duration_seconds{device="mlx5_0",port="1"} 2e-06
+rdma_port_tick_duration_seconds{device="mlx5_0",port="2"} 1e-06
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_counter_unit_info", "rdma_port_tick_duration_seconds"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
+
+func TestCollectorOmitsUnknownTickDuration(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1}}}},
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(New(provider, newDiscardLogger()))
+
+	if count, err := testutil.GatherAndCount(reg, "rdma_port_tick_duration_seconds"); err != nil || count != 0 {
+		t.Fatalf("expected no tick duration without a known tick, got %d (err=%v)", count, err)
+	}
+}
+
+// allocDevices returns a dual-port HCA pair with a realistic counter set for
+// allocation tests.
+func allocDevices() []rdma.Device {
+	stats := make(map[string]uint64)
+	hwStats := make(map[string]uint64)
+	for name, spec := range metricSpecs {
+		if strings.HasPrefix(spec.DocName, "port_") {
+			stats[name] = 1
+		} else {
+			hwStats[name] = 1
+		}
+	}
+	devices := make([]rdma.Device, 0, 2)
+	for _, name := range []string{"mlx5_0", "mlx5_1"} {
+		device := rdma.Device{Name: name}
+		for id := 1; id <= 2; id++ {
+			device.Ports = append(device.Ports, rdma.Port{
+				ID:         id,
+				Stats:      stats,
+				HwStats:    hwStats,
+				Attributes: rdma.PortAttributes{LinkLayer: "InfiniBand", State: "ACTIVE"},
+			})
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+func collectAll(c *RdmaCollector) {
+	ch := make(chan prometheus.Metric, 1024)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	for range ch {
+	}
+}
+
+func BenchmarkCollectorCollect(b *testing.B) {
+	c := New(&stubProvider{devices: allocDevices()}, newDiscardLogger())
+	collectAll(c)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		collectAll(c)
+	}
+}
+
+// collectAllocBudget bounds allocations of one Collect over allocDevices.
+// Counter series share cached label pairs, so the total must stay well below
+// the ~2700 allocations of building label pairs per series. Raise it only
+// with a justification in the change.
+const collectAllocBudget = 600
+
+func TestCollectorCollectAllocations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocation budget check skipped in short mode")
+	}
+
+	c := New(&stubProvider{devices: allocDevices()}, newDiscardLogger())
+	collectAll(c)
+
+	allocs := testing.AllocsPerRun(20, func() { collectAll(c) })
+	if allocs > collectAllocBudget {
+		t.Fatalf("Collect allocated %.0f times per run, budget is %d", allocs, collectAllocBudget)
+	}
+}
+
+func TestCollectorObservesLockContention(t *testing.T) {
+	t.Parallel()
+
+	c := New(&stubProvider{devices: []rdma.Device{{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1}}}}}, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	// A scrape arriving while another holds the collector waits for it.
+	c.collectMu.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := reg.Gather()
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	c.collectMu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+
+	var wait, hold dto.Metric
+	if err := c.lockWait.Write(&wait); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.lockHold.Write(&hold); err != nil {
+		t.Fatal(err)
+	}
+	if got := wait.GetHistogram().GetSampleCount(); got != 2 {
+		t.Fatalf("expected 2 lock waits, got %d", got)
+	}
+	if got := wait.GetHistogram().GetSampleSum(); got < 0.02 {
+		t.Fatalf("expected the contended scrape to wait at least 20ms, waited %gs", got)
+	}
+	if got := hold.GetHistogram().GetSampleCount(); got != 2 {
+		t.Fatalf("expected 2 lock holds, got %d", got)
+	}
+}
+
+func TestCollectorRailLabels(t *testing.T) {
+	t.Parallel()
+
+	port := func(id int) rdma.Port {
+		return rdma.Port{
+			ID:         id,
+			Stats:      map[string]uint64{"port_xmit_data": 10},
+			Attributes: rdma.PortAttributes{LinkLayer: "InfiniBand", State: "ACTIVE"},
+		}
+	}
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{Name: "mlx5_0", Ports: []rdma.Port{port(1)}},
+			{Name: "mlx5_1", Ports: []rdma.Port{port(1)}},
+			{Name: "rxe_eth", Ports: []rdma.Port{port(1)}},
+		},
+	}
+
+	c := New(provider, newDiscardLogger(), WithRailLabels(map[string]string{"mlx5_1": "storage"}), WithStatefulMode())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_port_idle_seconds Seconds since the port's port_xmit_data or port_rcv_data counter last changed. Only exported in stateful mode.
+# TYPE rdma_port_idle_seconds gauge
+rdma_port_idle_seconds{device="mlx5_0",port="1",rail="rail0"} 0
+rdma_port_idle_seconds{device="mlx5_1",port="1",rail="storage"} 0
+rdma_port_idle_seconds{device="rxe_eth",port="1",rail=""} 0
+# HELP rdma_port_info RDMA port metadata exported as labels.
+# TYPE rdma_port_info gauge
+rdma_port_info{device="mlx5_0",is_vf="false",link_layer="InfiniBand",link_speed="",link_width="",pci_addr="",pf_device="",phys_state="",port="1",rail="rail0",state="ACTIVE"} 1
+rdma_port_info{device="mlx5_1",is_vf="false",link_layer="InfiniBand",link_speed="",link_width="",pci_addr="",pf_device="",phys_state="",port="1",rail="storage",state="ACTIVE"} 1
+rdma_port_info{device="rxe_eth",is_vf="false",link_layer="InfiniBand",link_speed="",link_width="",pci_addr="",pf_device="",phys_state="",port="1",rail="",state="ACTIVE"} 1
+# HELP rdma_port_xmit_data_total The total number of data octets, divided by 4, transmitted on all VLs from the port.
+# TYPE rdma_port_xmit_data_total counter
+rdma_port_xmit_data_total{device="mlx5_0",port="1",rail="rail0"} 10
+rdma_port_xmit_data_total{device="mlx5_1",port="1",rail="storage"} 10
+rdma_port_xmit_data_total{device="rxe_eth",port="1",rail=""} 10
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_port_idle_seconds", "rdma_port_info", "rdma_port_xmit_data_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestRailFromDeviceIndex(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		device string
+		want   string
+	}{
+		{"mlx5_0", "rail0"},
+		{"mlx5_11", "rail11"},
+		{"mlx5_bond_02", "rail2"},
+		{"bnxt_re3", "rail3"},
+		{"rxe_eth", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := railFromDeviceIndex(tt.device); got != tt.want {
+			t.Errorf("railFromDeviceIndex(%q) = %q, want %q", tt.device, got, tt.want)
+		}
+	}
+}
+
+func TestCollectorPortRoles(t *testing.T) {
+	t.Parallel()
+
+	port := func(id int) rdma.Port {
+		return rdma.Port{
+			ID:         id,
+			Stats:      map[string]uint64{"port_xmit_data": 10},
+			Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", State: "ACTIVE"},
+		}
+	}
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{Name: "mlx5_0", Ports: []rdma.Port{port(1)}},
+			{Name: "mlx5_1", Ports: []rdma.Port{port(1)}},
+			{Name: "mlx5_2", Ports: []rdma.Port{port(1)}},
+		},
+	}
+	gids := &stubGIDTableProvider{entries: []rdma.GIDEntry{
+		// Link-local GIDs are ignored.
+		{Device: "mlx5_0", Port: 1, Index: 0, GID: "fe80:0000:0000:0000:0e42:a1ff:fe03:0001", NetDev: "ens1f0np0"},
+		{Device: "mlx5_0", Port: 1, Index: 3, GID: "0000:0000:0000:0000:0000:ffff:0a01:0005", NetDev: "ens1f0np0"},
+		// The storage VLAN rule comes first, so it wins over the compute CIDR.
+		{Device: "mlx5_1", Port: 1, Index: 3, GID: "0000:0000:0000:0000:0000:ffff:0a02:0005", NetDev: "ens2f0np0"},
+		{Device: "mlx5_1", Port: 1, Index: 5, GID: "0000:0000:0000:0000:0000:ffff:c0a8:6405", NetDev: "ens2f0np0.100"},
+		{Device: "mlx5_2", Port: 1, Index: 3, GID: "0000:0000:0000:0000:0000:ffff:ac10:0005", NetDev: "ens3f0np0"},
+	}}
+	rules := []PortRoleRule{
+		{Role: "storage", VLAN: 100},
+		{Role: "compute", Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+	}
+
+	c := New(provider, newDiscardLogger(), WithPortRoles(rules, gids))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_port_xmit_data_total The total number of data octets, divided by 4, transmitted on all VLs from the port.
+# TYPE rdma_port_xmit_data_total counter
+rdma_port_xmit_data_total{device="mlx5_0",port="1",role="compute"} 10
+rdma_port_xmit_data_total{device="mlx5_1",port="1",role="storage"} 10
+rdma_port_xmit_data_total{device="mlx5_2",port="1",role=""} 10
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_port_xmit_data_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+
+	// Readdressed ports are reclassified on the next scrape.
+	gids.entries = gids.entries[:3]
+	expected = `
+# HELP rdma_port_xmit_data_total The total number of data octets, divided by 4, transmitted on all VLs from the port.
+# TYPE rdma_port_xmit_data_total counter
+rdma_port_xmit_data_total{device="mlx5_0",port="1",role="compute"} 10
+rdma_port_xmit_data_total{device="mlx5_1",port="1",role="compute"} 10
+rdma_port_xmit_data_total{device="mlx5_2",port="1",role=""} 10
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_port_xmit_data_total"); err != nil {
+		t.Fatalf("unexpected metrics output after readdressing: %v", err)
+	}
+}
+
+func TestNetDevVLAN(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		netDev string
+		want   int
+	}{
+		{"ens1f0np0.100", 100},
+		{"bond0.4094", 4094},
+		{"ens1f0np0.100.200", 200},
+		{"ens1f0np0", 0},
+		{"ens1f0np0.4095", 0},
+		{"ens1f0np0.", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := netDevVLAN(tt.netDev); got != tt.want {
+			t.Fatalf("netDevVLAN(%q) = %d, want %d", tt.netDev, got, tt.want)
+		}
+	}
+}
+
+// blockingProvider blocks Devices until release is closed, once block is set.
+type blockingProvider struct {
+	devices []rdma.Device
+	block   bool
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProvider) Devices(context.Context) ([]rdma.Device, error) {
+	if p.block {
+		close(p.entered)
+		<-p.release
+	}
+	return p.devices, nil
+}
+
+func TestCollectorDescribeDoesNotWaitForCollect(t *testing.T) {
+	t.Parallel()
+
+	stats := make(map[string]uint64, 2000)
+	for i := range 2000 {
+		stats["vendor_counter_"+strconv.Itoa(i)] = uint64(i)
+	}
+	provider := &blockingProvider{
+		devices: []rdma.Device{{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1, HwStats: stats}}}},
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	c := New(provider, newDiscardLogger())
+	collectAll(c)
+
+	provider.block = true
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		collectAll(c)
+	}()
+	<-provider.entered
+
+	described := make(chan int)
+	go func() {
+		ch := make(chan *prometheus.Desc)
+		go func() {
+			c.Describe(ch)
+			close(ch)
+		}()
+		count := 0
+		for range ch {
+			count++
+		}
+		described <- count
+	}()
+
+	select {
+	case count := <-described:
+		if count < len(stats) {
+			t.Errorf("expected at least %d descriptors, got %d", len(stats), count)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Describe blocked while Collect was in progress")
+	}
+	close(provider.release)
+	<-collected
+}
+
+type stubRepresentorProvider struct {
+	reps []rdma.Representor
+}
+
+func (s *stubRepresentorProvider) Representors(context.Context) ([]rdma.Representor, error) {
+	return s.reps, nil
+}
+
+func TestCollectorExportsVPortMetrics(t *testing.T) {
+	t.Parallel()
+
+	reps := &stubRepresentorProvider{reps: []rdma.Representor{
+		{NetDev: "ens1f0npf0vf0", PFDevice: "mlx5_0", PF: 0, VF: 0},
+		{NetDev: "ens1f0npf0vf1", PFDevice: "mlx5_0", PF: 0, VF: 1},
+	}}
+	stats := newStubNetDevStatsProvider()
+	stats.stats["ens1f0npf0vf0"] = map[string]uint64{"vport_rx_packets": 10, "rx_vport_rdma_unicast_packets": 4, "rx_packets": 99}
+	stats.errs["ens1f0npf0vf1"] = errors.New("boom")
+
+	c := New(&stubProvider{}, newDiscardLogger(), WithVPortMetrics(reps, stats))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_vport_rx_packets_total VF vport counter vport_rx_packets from the ethtool stats of the switchdev representor.
+# TYPE rdma_vport_rx_packets_total counter
+rdma_vport_rx_packets_total{device="mlx5_0",netdev="ens1f0npf0vf0",pf="0",vf="0"} 10
+# HELP rdma_vport_rx_rdma_unicast_packets_total VF vport counter rx_vport_rdma_unicast_packets from the ethtool stats of the switchdev representor.
+# TYPE rdma_vport_rx_rdma_unicast_packets_total counter
+rdma_vport_rx_rdma_unicast_packets_total{device="mlx5_0",netdev="ens1f0npf0vf0",pf="0",vf="0"} 4
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"rdma_vport_rx_packets_total", "rdma_vport_rx_rdma_unicast_packets_total"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if n, err := testutil.GatherAndCount(reg, "rdma_vport_rx_total"); err != nil || n != 0 {
+		t.Fatalf("expected non-vport stats to be skipped, got %d series (err %v)", n, err)
+	}
+}
+
+func TestLabelCacheReusesPortLabels(t *testing.T) {
+	t.Parallel()
+
+	cache := newLabelCache()
+	cache.begin()
+	first := cache.port("mlx5_0", 1)
+	cache.prune()
+
+	cache.begin()
+	if again := cache.port("mlx5_0", 1); again != first {
+		t.Fatalf("expected cached labels to be reused across scrapes")
+	}
+	cache.prune()
+
+	// A port missing from a scrape is forgotten.
+	cache.begin()
+	cache.prune()
+	if len(cache.ports) != 0 {
+		t.Fatalf("expected stale port labels to be pruned, got %d", len(cache.ports))
+	}
+}
+
+// ibCorePortCounters lists every file the kernel's ib core creates under
+// ports/<n>/counters (drivers/infiniband/core/sysfs.c).
+var ibCorePortCounters = []string{
+	"symbol_error",
+	"link_error_recovery",
+	"link_downed",
+	"port_rcv_errors",
+	"port_rcv_remote_physical_errors",
+	"port_rcv_switch_relay_errors",
+	"port_xmit_discards",
+	"port_xmit_constraint_errors",
+	"port_rcv_constraint_errors",
+	"local_link_integrity_errors",
+	"excessive_buffer_overrun_errors",
+	"VL15_dropped",
+	"port_xmit_data",
+	"port_rcv_data",
+	"port_xmit_packets",
+	"port_rcv_packets",
+	"port_xmit_wait",
+	"port_unicast_xmit_packets",
+	"port_unicast_rcv_packets",
+	"port_multicast_xmit_packets",
+	"port_multicast_rcv_packets",
+}
+
+func TestMetricSpecsCoverIBCorePortCounters(t *testing.T) {
+	t.Parallel()
+
+	stats := make(map[string]uint64, len(ibCorePortCounters))
+	for _, name := range ibCorePortCounters {
+		stats[name] = 1
+	}
+	provider := &stubProvider{
+		devices: []rdma.Device{{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1, Stats: stats}}}},
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(New(provider, newDiscardLogger()))
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected gather error: %v", err)
+	}
+	help := make(map[string]string, len(mfs))
+	for _, mf := range mfs {
+		help[mf.GetName()] = mf.GetHelp()
+	}
+	for _, name := range ibCorePortCounters {
+		metric := "rdma_" + sanitizeStatName(name) + "_total"
+		got, ok := help[metric]
+		if !ok {
+			t.Errorf("%s: expected metric %s", name, metric)
+			continue
+		}
+		if got == "RDMA port counter sourced from sysfs counters." {
+			t.Errorf("%s uses the fallback help text; add it to metricSpecs", metric)
+		}
+	}
+}
+
+func TestCollectorExportsLivenessTimestamps(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{err: errors.New("sysfs unavailable")}
+	c := New(provider, newDiscardLogger())
+	c.startTime = time.Unix(1000, 0)
+	c.now = func() time.Time { return time.Unix(1060, 0) }
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	failing := `
+# HELP rdma_exporter_start_time_seconds Unix time at which the exporter started.
+# TYPE rdma_exporter_start_time_seconds gauge
+rdma_exporter_start_time_seconds 1000
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(failing), "rdma_exporter_start_time_seconds"); err != nil {
+		t.Fatalf("unexpected metrics while collections fail: %v", err)
+	}
+	if count, err := testutil.GatherAndCount(reg, "rdma_last_successful_collect_timestamp_seconds"); err != nil || count != 0 {
+		t.Fatalf("expected no last success before the first success, got %d (err=%v)", count, err)
+	}
+
+	provider.err = nil
+	succeeding := failing + `# HELP rdma_last_successful_collect_timestamp_seconds Unix time of the last collection that read RDMA devices without error. Absent until the first success.
+# TYPE rdma_last_successful_collect_timestamp_seconds gauge
+rdma_last_successful_collect_timestamp_seconds 1060
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(succeeding),
+		"rdma_exporter_start_time_seconds", "rdma_last_successful_collect_timestamp_seconds"); err != nil {
+		t.Fatalf("unexpected metrics after a successful collection: %v", err)
+	}
+}
+
+func TestCollectorExportsDeviceCount(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{}
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	for _, tt := range []struct {
+		devices []rdma.Device
+		want    string
+	}{
+		{devices: nil, want: "0"},
+		{devices: []rdma.Device{{Name: "mlx5_0"}, {Name: "mlx5_1"}}, want: "2"},
+	} {
+		provider.devices = tt.devices
+		expected := `
+# HELP rdma_devices Number of RDMA devices found by the last collection, after exclusions.
+# TYPE rdma_devices gauge
+rdma_devices ` + tt.want + "\n"
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_devices"); err != nil {
+			t.Fatalf("unexpected metrics output: %v", err)
+		}
+	}
+
+	provider.err = errors.New("sysfs unavailable")
+	if n, err := testutil.GatherAndCount(reg, "rdma_devices", "rdma_ports"); err != nil || n != 0 {
+		t.Fatalf("expected no device count when enumeration fails, got %d (err=%v)", n, err)
+	}
+}
+
+func TestCollectorExportsPortCountByState(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{Name: "mlx5_0", Ports: []rdma.Port{
+				{ID: 1, Attributes: rdma.PortAttributes{State: "ACTIVE"}},
+				{ID: 2, Attributes: rdma.PortAttributes{State: "DOWN"}},
+			}},
+			{Name: "mlx5_1", Ports: []rdma.Port{
+				{ID: 1, Attributes: rdma.PortAttributes{State: "ACTIVE"}},
+			}},
+			{Name: "rxe0"},
+		},
+	}
+	c := New(provider, newDiscardLogger())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	expected := `
+# HELP rdma_devices Number of RDMA devices found by the last collection, after exclusions.
+# TYPE rdma_devices gauge
+rdma_devices 3
+# HELP rdma_ports Number of ports of the RDMA devices found by the last collection, by port state.
+# TYPE rdma_ports gauge
+rdma_ports{state="ACTIVE"} 2
+rdma_ports{state="DOWN"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_devices", "rdma_ports"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+type stubExpectationsProvider struct {
+	expectations Expectations
+	ok           bool
+}
+
+func (s *stubExpectationsProvider) Expectations() (Expectations, bool) {
+	return s.expectations, s.ok
+}
+
+func TestCollectorExportsExpectations(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{
+		devices: []rdma.Device{
+			{Name: "mlx5_0", Ports: []rdma.Port{
+				{ID: 1, Attributes: rdma.PortAttributes{State: "ACTIVE", LinkSpeed: "400 Gb/sec (4X NDR)"}},
+			}},
+			{Name: "mlx5_1", Ports: []rdma.Port{
+				{ID: 1, Attributes: rdma.PortAttributes{State: "ACTIVE", LinkSpeed: "200 Gb/sec (4X HDR)"}},
+			}},
+			{Name: "mlx5_2", Ports: []rdma.Port{
+				{ID: 1, Attributes: rdma.PortAttributes{State: "DOWN"}},
+			}},
+		},
+	}
+	expectations := &stubExpectationsProvider{}
+	c := New(provider, newDiscardLogger(), WithExpectations(expectations))
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if n, err := testutil.GatherAndCount(reg, "rdma_expected", "rdma_expectation_violated"); err != nil || n != 0 {
+		t.Fatalf("expected no series before the node was read, got %d (err=%v)", n, err)
+	}
+
+	expectations.expectations = Expectations{Devices: 3, ActivePorts: 3, PortRateGbps: 400}
+	expectations.ok = true
+	expected := `
+# HELP rdma_expectation_violated Whether the node does not meet an RDMA expectation (1) or does (0).
+# TYPE rdma_expectation_violated gauge
+rdma_expectation_violated{expectation="active_ports"} 1
+rdma_expectation_violated{expectation="devices"} 0
+rdma_expectation_violated{expectation="port_rate_bps"} 1
+# HELP rdma_expected Expected value of an RDMA expectation of the node: devices, active_ports or port_rate_bps.
+# TYPE rdma_expected gauge
+rdma_expected{expectation="active_ports"} 3
+rdma_expected{expectation="devices"} 3
+rdma_expected{expectation="port_rate_bps"} 4e+11
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_expected", "rdma_expectation_violated"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+
+	// Unset expectations are left out.
+	expectations.expectations = Expectations{Devices: -1, ActivePorts: 2, PortRateGbps: -1}
+	expected = `
+# HELP rdma_expectation_violated Whether the node does not meet an RDMA expectation (1) or does (0).
+# TYPE rdma_expectation_violated gauge
+rdma_expectation_violated{expectation="active_ports"} 0
+# HELP rdma_expected Expected value of an RDMA expectation of the node: devices, active_ports or port_rate_bps.
+# TYPE rdma_expected gauge
+rdma_expected{expectation="active_ports"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_expected", "rdma_expectation_violated"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestScrapeBudget(t *testing.T) {
+	t.Parallel()
+
+	const timeout = 10 * time.Second
+
+	b := newScrapeBudget(timeout)
+	for range budgetWindow {
+		if b.observe(time.Second) {
+			t.Fatalf("fast scrapes must not change the degraded state")
+		}
+	}
+	// A few slow scrapes push the p95 over the enter threshold.
+	var entered bool
+	for range 2 {
+		entered = b.observe(9*time.Second) || entered
+	}
+	if !entered || !b.degraded {
+		t.Fatalf("expected degraded mode after slow scrapes")
+	}
+
+	// Leaving requires a full window of fast degraded scrapes.
+	for i := range budgetWindow - 1 {
+		if b.observe(time.Second) {
+			t.Fatalf("left degraded mode after %d scrapes", i+1)
+		}
+	}
+	if !b.observe(time.Second) || b.degraded {
+		t.Fatalf("expected degraded mode to end after a full fast window")
+	}
+}
+
+func TestScrapeBudgetStaysDegradedNearTimeout(t *testing.T) {
+	t.Parallel()
+
+	b := newScrapeBudget(10 * time.Second)
+	b.observe(9 * time.Second)
+	for range 2 * budgetWindow {
+		b.observe(6 * time.Second)
+	}
+	if !b.degraded {
+		t.Fatalf("expected degraded mode while p95 stays above the exit threshold")
+	}
+}
+
+type stubOptionsProvider struct {
+	stubProvider
+	opts []rdma.ReadOptions
+}
+
+func (s *stubOptionsProvider) DevicesWithOptions(ctx context.Context, opts rdma.ReadOptions) ([]rdma.Device, error) {
+	s.opts = append(s.opts, opts)
+	return s.Devices(ctx)
+}
+
+func TestCollectorDegradedModeSkipsOptionalWork(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubOptionsProvider{stubProvider: stubProvider{
+		devices: []rdma.Device{{
+			Name:       "mlx5_0",
+			Attributes: rdma.DeviceAttributes{Driver: "mlx5_core"},
+			Ports: []rdma.Port{{
+				ID:         1,
+				Stats:      map[string]uint64{"port_xmit_data": 1},
+				HwStats:    map[string]uint64{"out_of_buffer": 2},
+				Attributes: rdma.PortAttributes{LinkLayer: "Ethernet", NetDev: "eth0"},
+			}},
+		}},
+	}}
+	netdevStats := newStubNetDevStatsProvider()
+
+	c := New(provider, newDiscardLogger(), WithNetDevStatsProvider(netdevStats), WithScrapeBudget(10*time.Second), WithEmitZeros())
+	c.now = func() time.Time { return time.Unix(1700000000, 0) }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	count := func(name string) int {
+		t.Helper()
+		n, err := testutil.GatherAndCount(reg, name)
+		if err != nil {
+			t.Fatalf("gather %s: %v", name, err)
+		}
+		return n
+	}
+
+	degradedMode := func(value string) {
+		t.Helper()
+		expected := `
+# HELP rdma_exporter_degraded_mode Whether the collector skips optional work because recent scrapes approached the scrape timeout (1) or not (0).
+# TYPE rdma_exporter_degraded_mode gauge
+rdma_exporter_degraded_mode ` + value + "\n"
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_exporter_degraded_mode"); err != nil {
+			t.Fatalf("unexpected metrics output: %v", err)
+		}
+	}
+
+	degradedMode("0")
+	if count("rdma_port_info") != 1 || count("rdma_out_of_buffer_total") != 1 || count("rdma_rx_write_requests_total") != 1 {
+		t.Fatalf("expected full output before degraded mode")
+	}
+	if len(provider.opts) != 0 {
+		t.Fatalf("expected full device reads, got %+v", provider.opts)
+	}
+
+	c.collectMu.Lock()
+	c.observeScrape(9 * time.Second)
+	c.collectMu.Unlock()
+	calls := netdevStats.CallCount("eth0")
+
+	degradedMode("1")
+	if count("rdma_port_xmit_data_total") != 1 {
+		t.Fatalf("expected counters to be exported in degraded mode")
+	}
+	// Zeros standing in for the skipped hw_counters would read as resets.
+	for _, name := range []string{"rdma_port_info", "rdma_device_info", "rdma_out_of_buffer_total", "rdma_rx_write_requests_total"} {
+		if n := count(name); n != 0 {
+			t.Fatalf("expected no %s series in degraded mode, got %d", name, n)
+		}
+	}
+	if got := netdevStats.CallCount("eth0"); got != calls {
+		t.Fatalf("expected no ethtool reads in degraded mode, got %d more", got-calls)
+	}
+	want := rdma.ReadOptions{SkipHwCounters: true, SkipAttributes: true}
+	if len(provider.opts) == 0 || provider.opts[len(provider.opts)-1] != want {
+		t.Fatalf("expected trimmed device reads, got %+v", provider.opts)
+	}
+}
+
+func FuzzSanitizeStatName(f *testing.F) {
+	for _, seed := range []string{"", "port_xmit_data", "rx_prio0_pause", "7_bytes", "Rx-Bytes/sec", "ÿ", "\x00", "__"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, stat string) {
+		got := sanitizeStatName(stat)
+		if got == "" || (got[0] >= '0' && got[0] <= '9') {
+			t.Fatalf("sanitizeStatName(%q) = %q", stat, got)
+		}
+		for _, r := range got {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+				t.Fatalf("sanitizeStatName(%q) = %q contains %q", stat, got, r)
+			}
+		}
+		if again := sanitizeStatName(got); again != got {
+			t.Fatalf("sanitizeStatName is not idempotent: %q -> %q -> %q", stat, got, again)
+		}
+	})
+}
+
+type countingProvider struct {
+	stubProvider
+	calls int
+}
+
+func (p *countingProvider) Devices(ctx context.Context) ([]rdma.Device, error) {
+	p.calls++
+	return p.stubProvider.Devices(ctx)
+}
+
+func TestCollectorDeviceSnapshot(t *testing.T) {
+	t.Parallel()
+
+	provider := &countingProvider{stubProvider: stubProvider{
+		devices: []rdma.Device{{
+			Name:  "mlx5_0",
+			Ports: []rdma.Port{{ID: 1, Stats: map[string]uint64{"port_xmit_data": 1}}},
+		}},
+	}}
+	c := New(provider, newDiscardLogger())
+	now := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return now }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("unexpected gather error: %v", err)
+	}
+	scrapedAt := now
+	now = now.Add(30 * time.Second)
+
+	devices, readAt, err := c.DeviceSnapshot(context.Background(), time.Minute)
+	if err != nil {
+		t.Fatalf("DeviceSnapshot returned error: %v", err)
+	}
+	if !readAt.Equal(scrapedAt) || provider.calls != 1 {
+		t.Fatalf("expected the scrape's snapshot read at %s, got %s after %d reads", scrapedAt, readAt, provider.calls)
+	}
+	// Snapshots are copied on read, so callers cannot modify the shared one.
+	devices[0].Ports[0].Stats["port_xmit_data"] = 99
+	devices, _, _ = c.DeviceSnapshot(context.Background(), time.Minute)
+	if got := devices[0].Ports[0].Stats["port_xmit_data"]; got != 1 {
+		t.Fatalf("expected the shared snapshot to be unchanged, got port_xmit_data=%d", got)
+	}
+
+	now = now.Add(time.Minute)
+	if _, readAt, _ = c.DeviceSnapshot(context.Background(), time.Minute); !readAt.Equal(now) || provider.calls != 2 {
+		t.Fatalf("expected a fresh read of a snapshot older than the max age, got one read at %s after %d reads", readAt, provider.calls)
+	}
+	c.DeviceSnapshot(context.Background(), time.Minute)
+	if provider.calls != 2 {
+		t.Fatalf("expected the fresh read to be shared, got %d reads", provider.calls)
+	}
+}
+
+func TestCollectorReusesSnapshotWithinLifespan(t *testing.T) {
+	t.Parallel()
+
+	provider := &countingProvider{stubProvider: stubProvider{
+		devices: []rdma.Device{{
+			Name: "mlx5_0",
+			Ports: []rdma.Port{{
+				ID:      1,
+				Stats:   map[string]uint64{"port_xmit_data": 1},
+				HwStats: map[string]uint64{"lifespan": 3000},
+			}},
+		}},
+	}}
+	c := New(provider, newDiscardLogger(), WithSnapshotLifespan(time.Second))
+	now := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return now }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	scrape := func(age, reuses string) {
+		t.Helper()
+		expected := `
+# HELP rdma_exporter_snapshot_age_seconds Age of the device snapshot served by this scrape; 0 when it was read for this scrape.
+# TYPE rdma_exporter_snapshot_age_seconds gauge
+rdma_exporter_snapshot_age_seconds ` + age + `
+# HELP rdma_exporter_snapshot_reuses_total Number of scrapes served from a device snapshot read by an earlier scrape.
+# TYPE rdma_exporter_snapshot_reuses_total counter
+rdma_exporter_snapshot_reuses_total ` + reuses + "\n"
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_exporter_snapshot_age_seconds", "rdma_exporter_snapshot_reuses_total"); err != nil {
+			t.Fatalf("unexpected metrics output: %v", err)
+		}
+	}
+
+	scrape("0", "0")
+	now = now.Add(500 * time.Millisecond)
+	scrape("0.5", "1")
+	// The hw counter lifespan of 3s outlasts the configured second.
+	now = now.Add(2 * time.Second)
+	scrape("2.5", "2")
+	if provider.calls != 1 {
+		t.Fatalf("expected one device read within the lifespan, got %d", provider.calls)
+	}
+
+	now = now.Add(time.Second)
+	scrape("0", "2")
+	if provider.calls != 2 {
+		t.Fatalf("expected a new device read after the lifespan, got %d", provider.calls)
+	}
+
+	c.InvalidateCache()
+	scrape("0", "2")
+	if provider.calls != 3 {
+		t.Fatalf("expected a new device read after invalidation, got %d", provider.calls)
+	}
+}
@@ -0,0 +1,65 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// DCBProvider reads the DCB configuration of a netdev.
+type DCBProvider interface {
+	DCB(ctx context.Context, netDev string) (rdma.DCBConfig, error)
+}
+
+// WithDCB exports the PFC and ETS configuration of the netdevs backing RoCE
+// ports, so a switch port or host whose lossless priorities drifted from the
+// rest of the fabric shows up in a query.
+func WithDCB(provider DCBProvider) Option {
+	return func(c *RdmaCollector) {
+		c.dcbProvider = provider
+	}
+}
+
+func (c *RdmaCollector) collectDCB(
+	ctx context.Context,
+	ch chan<- prometheus.Metric,
+	labels *portLabels,
+	attr rdma.PortAttributes,
+	isVF bool,
+) {
+	if c.dcbProvider == nil {
+		return
+	}
+	// DCB is configured on the physical port; skip VFs like PFC.
+	if isVF || attr.LinkLayer != "Ethernet" || attr.NetDev == "" {
+		return
+	}
+
+	config, err := c.dcbProvider.DCB(ctx, attr.NetDev)
+	if err != nil {
+		c.logger.Warn("netdev dcb read failed", "device", labels.device, "port", labels.port, "netdev", attr.NetDev, "err", err)
+		return
+	}
+
+	if config.PFC {
+		for priority := range rdma.DCBPriorities {
+			ch <- prometheus.MustNewConstMetric(c.pfcPriorityEnabledDesc, prometheus.GaugeValue,
+				boolToFloat(config.PFCEnabled&(1<<priority) != 0), labels.values(attr.NetDev, strconv.Itoa(priority))...)
+		}
+		ch <- prometheus.MustNewConstMetric(c.pfcDelayDesc, prometheus.GaugeValue,
+			float64(config.PFCDelay), labels.values(attr.NetDev)...)
+	}
+	if config.ETS {
+		for tc, bandwidth := range config.ETSBandwidth {
+			ch <- prometheus.MustNewConstMetric(c.etsBandwidthDesc, prometheus.GaugeValue,
+				float64(bandwidth), labels.values(attr.NetDev, strconv.Itoa(tc))...)
+		}
+		for priority, tc := range config.PriorityTC {
+			ch <- prometheus.MustNewConstMetric(c.etsPriorityTCDesc, prometheus.GaugeValue,
+				float64(tc), labels.values(attr.NetDev, strconv.Itoa(priority))...)
+		}
+	}
+}
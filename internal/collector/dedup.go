@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// Device deduplication modes for WithDeviceDedup.
+const (
+	// DedupPCI treats devices bound to the same PCI function as one, as
+	// with the mlx5_bond_N device of RoCE LAG and its first PF.
+	DedupPCI = "pci"
+	// DedupNodeGUID treats devices reporting the same node_guid as one, as
+	// with the per-plane devices of multi-plane HCAs.
+	DedupNodeGUID = "guid"
+)
+
+// WithDeviceDedup exports only one of the devices that surface the same
+// hardware, so sum() over counters does not count traffic twice. The other
+// devices are reported by rdma_device_duplicate. Unknown modes are ignored.
+func WithDeviceDedup(mode string) Option {
+	return func(c *RdmaCollector) {
+		if mode != DedupPCI && mode != DedupNodeGUID {
+			return
+		}
+		c.dedupMode = mode
+		c.duplicates = make(map[string]string)
+		c.duplicateDesc = prometheus.NewDesc(
+			"rdma_device_duplicate",
+			"Device left out of the exposition because it surfaces the same hardware as the canonical device.",
+			[]string{"device", "canonical"},
+			nil,
+		)
+	}
+}
+
+// dedupKey returns the identity of the hardware behind a device, or "" when
+// it is unknown.
+func (c *RdmaCollector) dedupKey(device rdma.Device) string {
+	if c.dedupMode == DedupNodeGUID {
+		return device.Attributes.NodeGUID
+	}
+	return device.PCIAddr
+}
+
+// canonicalBefore orders the devices of one group: LAG bond devices carry
+// the traffic of all their ports, so they win; otherwise the lowest name.
+func canonicalBefore(a, b string) bool {
+	aBond, bBond := strings.Contains(a, "bond"), strings.Contains(b, "bond")
+	if aBond != bBond {
+		return aBond
+	}
+	return a < b
+}
+
+// dedupDevices drops duplicate devices and records them. Without attributes,
+// as in degraded mode, the duplicates found by the last full read are
+// dropped instead. It is only called while collectMu is held.
+func (c *RdmaCollector) dedupDevices(devices []rdma.Device, attributes bool) []rdma.Device {
+	if c.dedupMode == "" {
+		return devices
+	}
+
+	if attributes {
+		canonical := make(map[string]string)
+		for _, device := range devices {
+			key := c.dedupKey(device)
+			if key == "" {
+				continue
+			}
+			if current, ok := canonical[key]; !ok || canonicalBefore(device.Name, current) {
+				canonical[key] = device.Name
+			}
+		}
+		clear(c.duplicates)
+		for _, device := range devices {
+			key := c.dedupKey(device)
+			if key == "" || canonical[key] == device.Name {
+				continue
+			}
+			c.duplicates[device.Name] = canonical[key]
+		}
+	}
+
+	return slices.DeleteFunc(slices.Clone(devices), func(device rdma.Device) bool {
+		_, dup := c.duplicates[device.Name]
+		return dup
+	})
+}
+
+func (c *RdmaCollector) collectDuplicates(ch chan<- prometheus.Metric) {
+	if c.dedupMode == "" {
+		return
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.duplicates)) {
+		ch <- prometheus.MustNewConstMetric(c.duplicateDesc, prometheus.GaugeValue, 1, name, c.duplicates[name])
+	}
+}
@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// ErrDeepScanDisabled is returned by DeepScan when the collector was built
+// without WithDeepScan.
+var ErrDeepScanDisabled = errors.New("deep scan is not enabled")
+
+// DeepScanProvider reads data that is too expensive to collect on every
+// scrape.
+type DeepScanProvider interface {
+	AER(ctx context.Context) ([]rdma.DeviceAER, error)
+}
+
+// deepScanResult is the output of the last deep scan.
+type deepScanResult struct {
+	timestamp time.Time
+	aer       []rdma.DeviceAER
+}
+
+// WithDeepScan enables on-demand deep scans through DeepScan. The result of
+// the last deep scan is included in every scrape: the AER counters are
+// cumulative, so they are exported as counters whose value only moves when a
+// deep scan reads them again, and rdma_exporter_deep_scan_timestamp_seconds
+// tells how old they are.
+func WithDeepScan(provider DeepScanProvider) Option {
+	return func(c *RdmaCollector) {
+		c.deepScanProvider = provider
+	}
+}
+
+// DeepScan runs the expensive collectors once and keeps the result for the
+// following scrapes. It does not block concurrent scrapes.
+func (c *RdmaCollector) DeepScan(ctx context.Context) error {
+	if c.deepScanProvider == nil {
+		return ErrDeepScanDisabled
+	}
+
+	aer, err := c.deepScanProvider.AER(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.deepMu.Lock()
+	c.deepResult = &deepScanResult{
+		timestamp: c.now(),
+		aer:       aer,
+	}
+	c.deepMu.Unlock()
+	return nil
+}
+
+func (c *RdmaCollector) collectDeepScan(ch chan<- prometheus.Metric) {
+	c.deepMu.Lock()
+	result := c.deepResult
+	c.deepMu.Unlock()
+	if result == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.deepScanTimestampDesc,
+		prometheus.GaugeValue,
+		unixSeconds(result.timestamp),
+	)
+
+	for _, device := range result.aer {
+		severities := make([]string, 0, len(device.Counters))
+		for severity := range device.Counters {
+			severities = append(severities, severity)
+		}
+		slices.Sort(severities)
+		for _, severity := range severities {
+			counters := device.Counters[severity]
+			for _, name := range sortedKeys(counters) {
+				ch <- prometheus.MustNewConstMetric(
+					c.pcieAERErrorsDesc,
+					prometheus.CounterValue,
+					float64(counters[name]),
+					device.Device,
+					severity,
+					name,
+				)
+			}
+		}
+	}
+}
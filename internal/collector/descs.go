@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// addDynamicDesc records a counter descriptor created during Collect. It is
+// only called while collectMu is held.
+func (c *RdmaCollector) addDynamicDesc(desc *prometheus.Desc) {
+	c.pendingDescs = append(c.pendingDescs, desc)
+}
+
+// publishDynamicDescs makes the descriptors added during the current Collect
+// visible to Describe. The published slice is never modified; a new one is
+// swapped in instead, so Describe reads it without taking collectMu and never
+// waits for a scrape. Publishing once per Collect keeps the copying linear
+// when a scrape discovers thousands of counters. It is only called while
+// collectMu is held.
+func (c *RdmaCollector) publishDynamicDescs() {
+	if len(c.pendingDescs) == 0 {
+		return
+	}
+	var current []*prometheus.Desc
+	if published := c.dynamicDescs.Load(); published != nil {
+		current = *published
+	}
+	next := make([]*prometheus.Desc, 0, len(current)+len(c.pendingDescs))
+	next = append(next, current...)
+	next = append(next, c.pendingDescs...)
+	c.dynamicDescs.Store(&next)
+	c.pendingDescs = c.pendingDescs[:0]
+}
+
+func (c *RdmaCollector) describeDynamicDescs(ch chan<- *prometheus.Desc) {
+	published := c.dynamicDescs.Load()
+	if published == nil {
+		return
+	}
+	for _, desc := range *published {
+		ch <- desc
+	}
+}
@@ -0,0 +1,318 @@
+package collector
+
+// driverHelpPacks maps the kernel driver of a device, as reported in
+// DeviceAttributes.Driver, to the help pack documenting its hw_counters.
+// mlx5 and bnxt_re devices hang off an auxiliary device on newer kernels, so
+// both the RDMA and the core driver name are listed.
+var driverHelpPacks = map[string]string{
+	"mlx5_core": "mlx5",
+	"mlx5_ib":   "mlx5",
+	"efa":       "efa",
+	"irdma":     "irdma",
+	"ice":       "irdma",
+	"i40e":      "irdma",
+	"bnxt_re":   "bnxt_re",
+	"bnxt_en":   "bnxt_re",
+	"hfi1":      "hfi1",
+	"cxgb4":     "cxgb4",
+}
+
+// driverHelp holds the help texts of vendor hw_counters, keyed by help pack
+// and canonical counter name. Drivers reuse short names such as rx_bytes or
+// duplicate_request with different meanings, so they are only applied to
+// devices bound to the driver. The mlx5 counters documented in metricSpecs
+// are not repeated here.
+var driverHelp = map[string]map[string]string{
+	"mlx5": {
+		"cc_rx_ce_pkts":                  "The number of received RoCE packets marked with ECN Congestion Experienced. Added in kernel v6.5.",
+		"cc_rx_cnp_pkts":                 "The number of CNP packets received. Added in kernel v6.5.",
+		"cc_tx_cnp_pkts":                 "The number of CNP packets sent. Added in kernel v6.5.",
+		"rdma_rx_bytes":                  "The number of bytes received by RDMA traffic. Optional counter added in kernel v6.7.",
+		"rdma_rx_packets":                "The number of packets received by RDMA traffic. Optional counter added in kernel v6.7.",
+		"rdma_tx_bytes":                  "The number of bytes transmitted by RDMA traffic. Optional counter added in kernel v6.7.",
+		"rdma_tx_packets":                "The number of packets transmitted by RDMA traffic. Optional counter added in kernel v6.7.",
+		"req_rnr_retries_exceeded":       "The number of times the requester exceeded the RNR NAK retry limit.",
+		"req_transport_retries_exceeded": "The number of times the requester exceeded the transport retry limit.",
+	},
+	"efa": {
+		"alloc_pd_err":       "The number of protection domain allocations the EFA device rejected.",
+		"alloc_ucontext_err": "The number of user context allocations the EFA device rejected.",
+		"cmds_err":           "The number of EFA admin commands that completed with an error.",
+		"completed_cmds":     "The number of EFA admin commands that completed.",
+		"create_ah_err":      "The number of address handle creations the EFA device rejected.",
+		"create_cq_err":      "The number of completion queue creations the EFA device rejected.",
+		"create_qp_err":      "The number of queue pair creations the EFA device rejected.",
+		"keep_alive_rcvd":    "The number of keep-alive events received from the EFA device.",
+		"mmap_err":           "The number of EFA memory mapping requests that failed.",
+		"no_completion_cmds": "The number of EFA admin commands that timed out without a completion.",
+		"read_bytes":         "The number of bytes read by RDMA read requests sent.",
+		"read_resp_bytes":    "The number of bytes returned in responses to RDMA read requests received.",
+		"read_wr_err":        "The number of RDMA read work requests that completed with an error.",
+		"read_wrs":           "The number of RDMA read work requests posted.",
+		"recv_bytes":         "The number of bytes received by receive work requests.",
+		"recv_wrs":           "The number of receive work requests completed.",
+		"reg_mr_err":         "The number of memory region registrations the EFA device rejected.",
+		"rx_bytes":           "The number of bytes received by the EFA device, including protocol headers.",
+		"rx_drops":           "The number of packets the EFA device dropped on receive.",
+		"rx_pkts":            "The number of packets received by the EFA device.",
+		"send_bytes":         "The number of bytes sent by send work requests.",
+		"send_wrs":           "The number of send work requests posted.",
+		"submitted_cmds":     "The number of EFA admin commands submitted.",
+		"tx_bytes":           "The number of bytes transmitted by the EFA device, including protocol headers.",
+		"tx_pkts":            "The number of packets transmitted by the EFA device.",
+		"write_bytes":        "The number of bytes written by RDMA write requests sent.",
+		"write_recv_bytes":   "The number of bytes written to this device by RDMA write requests received.",
+		"write_wr_err":       "The number of RDMA write work requests that completed with an error.",
+		"write_wrs":          "The number of RDMA write work requests posted.",
+	},
+	"irdma": {
+		"invalidates":                   "The number of invalidate operations.",
+		"ip4_in_discards":               "The number of received IPv4 packets discarded by the RDMA engine.",
+		"ip4_in_multicast_octets":       "The number of octets received in IPv4 multicast packets.",
+		"ip4_in_multicast_packets":      "The number of IPv4 multicast packets received.",
+		"ip4_in_octets":                 "The number of octets received in IPv4 packets.",
+		"ip4_in_packets":                "The number of IPv4 packets received.",
+		"ip4_in_reassembly_required":    "The number of received IPv4 fragments that needed reassembly.",
+		"ip4_in_truncated_packets":      "The number of received IPv4 packets truncated by the RDMA engine.",
+		"ip4_out_multicast_octets":      "The number of octets transmitted in IPv4 multicast packets.",
+		"ip4_out_multicast_packets":     "The number of IPv4 multicast packets transmitted.",
+		"ip4_out_no_routes":             "The number of IPv4 packets not transmitted for lack of a route.",
+		"ip4_out_octets":                "The number of octets transmitted in IPv4 packets.",
+		"ip4_out_packets":               "The number of IPv4 packets transmitted.",
+		"ip4_out_segmentation_required": "The number of transmitted IPv4 packets that needed fragmentation.",
+		"ip6_in_discards":               "The number of received IPv6 packets discarded by the RDMA engine.",
+		"ip6_in_multicast_octets":       "The number of octets received in IPv6 multicast packets.",
+		"ip6_in_multicast_packets":      "The number of IPv6 multicast packets received.",
+		"ip6_in_octets":                 "The number of octets received in IPv6 packets.",
+		"ip6_in_packets":                "The number of IPv6 packets received.",
+		"ip6_in_reassembly_required":    "The number of received IPv6 fragments that needed reassembly.",
+		"ip6_in_truncated_packets":      "The number of received IPv6 packets truncated by the RDMA engine.",
+		"ip6_out_multicast_octets":      "The number of octets transmitted in IPv6 multicast packets.",
+		"ip6_out_multicast_packets":     "The number of IPv6 multicast packets transmitted.",
+		"ip6_out_no_routes":             "The number of IPv6 packets not transmitted for lack of a route.",
+		"ip6_out_octets":                "The number of octets transmitted in IPv6 packets.",
+		"ip6_out_packets":               "The number of IPv6 packets transmitted.",
+		"ip6_out_segmentation_required": "The number of transmitted IPv6 packets that needed fragmentation.",
+		"mw_binds":                      "The number of memory window bind operations.",
+		"np_cnp_sent":                   "The number of CNP packets sent by the Notification Point.",
+		"np_ecn_marked_roce_packets":    "The number of received RoCEv2 packets marked with ECN Congestion Experienced.",
+		"rp_cnp_handled":                "The number of CNP packets handled by the Reaction Point to throttle the transmission rate.",
+		"rp_cnp_ignored":                "The number of CNP packets received and ignored by the Reaction Point.",
+		"rx_read_requests":              "The number of RDMA read requests received.",
+		"rx_send_requests":              "The number of RDMA send requests received.",
+		"rx_udp_packets":                "The number of RoCEv2 UDP packets received.",
+		"rx_vlan_errors":                "The number of received packets dropped for a VLAN error.",
+		"rx_write_requests":             "The number of RDMA write requests received.",
+		"tcp_in_option_errors":          "The number of received iWARP TCP segments with an option error.",
+		"tcp_in_protocol_errors":        "The number of received iWARP TCP segments with a protocol error.",
+		"tcp_in_segments":               "The number of iWARP TCP segments received.",
+		"tcp_out_segments":              "The number of iWARP TCP segments transmitted.",
+		"tcp_retransmitted_segments":    "The number of iWARP TCP segments retransmitted.",
+		"tx_read_requests":              "The number of RDMA read requests transmitted.",
+		"tx_send_requests":              "The number of RDMA send requests transmitted.",
+		"tx_udp_packets":                "The number of RoCEv2 UDP packets transmitted.",
+		"tx_write_requests":             "The number of RDMA write requests transmitted.",
+	},
+	"bnxt_re": {
+		"active_ahs":                     "The number of address handles currently allocated.",
+		"active_cqs":                     "The number of completion queues currently allocated.",
+		"active_mrs":                     "The number of memory regions currently registered.",
+		"active_mws":                     "The number of memory windows currently allocated.",
+		"active_pds":                     "The number of protection domains currently allocated.",
+		"active_qps":                     "The number of queue pairs currently allocated.",
+		"active_rc_qps":                  "The number of RC queue pairs currently allocated.",
+		"active_srqs":                    "The number of shared receive queues currently allocated.",
+		"active_ud_qps":                  "The number of UD queue pairs currently allocated.",
+		"bad_resp_err":                   "The number of bad responses received by the requester.",
+		"duplicate_request":              "The number of duplicate requests received by the responder.",
+		"local_ack_timeout_err":          "The number of times the requester's ACK timer expired and it retransmitted.",
+		"local_protection_err":           "The number of local protection errors.",
+		"local_qp_op_err":                "The number of local queue pair operation errors.",
+		"mem_mgmt_op_err":                "The number of memory management operation errors.",
+		"missing_resp":                   "The number of responses the requester did not receive in time.",
+		"np_cnp_sent":                    "The number of CNP packets transmitted.",
+		"np_ecn_marked_roce_packets":     "The number of received RoCE packets marked with ECN Congestion Experienced.",
+		"oos_drop_count":                 "The number of packets dropped because the out-of-sequence buffer was full.",
+		"packet_seq_err":                 "The number of sequence error NAKs received.",
+		"rdma_rx_bytes":                  "The number of bytes received in RoCE packets, excluding other traffic of the function.",
+		"rdma_rx_packets":                "The number of RoCE packets received, excluding other traffic of the function.",
+		"rdma_tx_bytes":                  "The number of bytes transmitted in RoCE packets, excluding other traffic of the function.",
+		"rdma_tx_packets":                "The number of RoCE packets transmitted, excluding other traffic of the function.",
+		"recoverable_errors":             "The number of recoverable errors reported by the firmware.",
+		"remote_access_err":              "The number of remote access errors reported to the requester.",
+		"remote_invalid_req":             "The number of remote invalid request errors reported to the requester.",
+		"remote_op_err":                  "The number of remote operation errors reported to the requester.",
+		"req_transport_retries_exceeded": "The number of times the transport retry limit was exceeded.",
+		"res_exceed_max":                 "The number of requests rejected for exceeding a responder resource limit.",
+		"res_length_mismatch":            "The number of requests with a length mismatch detected by the responder.",
+		"res_oos_drop_count":             "The number of out-of-sequence packets dropped by the responder.",
+		"res_rx_pci_err":                 "The number of PCI errors the responder hit on receive.",
+		"res_tx_pci_err":                 "The number of PCI errors the responder hit on transmit.",
+		"rnr_nak_retry_err":              "The number of times the RNR NAK retry limit was exceeded.",
+		"rnr_naks_rcvd":                  "The number of RNR NAKs received.",
+		"rp_cnp_handled":                 "The number of CNP packets received.",
+		"rx_atomic_requests":             "The number of atomic requests received.",
+		"rx_bytes":                       "The number of RoCE bytes received.",
+		"rx_good_bytes":                  "The number of bytes received in RoCE packets without errors.",
+		"rx_good_packets":                "The number of RoCE packets received without errors.",
+		"rx_pkts":                        "The number of RoCE packets received.",
+		"rx_read_requests":               "The number of RDMA read requests received.",
+		"rx_roce_discards":               "The number of received RoCE packets discarded.",
+		"rx_roce_errors":                 "The number of received RoCE packets with errors.",
+		"rx_send_requests":               "The number of send requests received.",
+		"rx_write_requests":              "The number of RDMA write requests received.",
+		"tx_atomic_requests":             "The number of atomic requests transmitted.",
+		"tx_bytes":                       "The number of RoCE bytes transmitted.",
+		"tx_pkts":                        "The number of RoCE packets transmitted.",
+		"tx_read_requests":               "The number of RDMA read requests transmitted.",
+		"tx_read_resp":                   "The number of RDMA read responses transmitted.",
+		"tx_roce_discards":               "The number of transmitted RoCE packets discarded.",
+		"tx_roce_errors":                 "The number of transmitted RoCE packets with errors.",
+		"tx_send_requests":               "The number of send requests transmitted.",
+		"tx_write_requests":              "The number of RDMA write requests transmitted.",
+		"unrecoverable_err":              "The number of unrecoverable errors reported by the firmware.",
+		"watermark_ahs":                  "The highest number of address handles allocated at once.",
+		"watermark_cqs":                  "The highest number of completion queues allocated at once.",
+		"watermark_mrs":                  "The highest number of memory regions registered at once.",
+		"watermark_mws":                  "The highest number of memory windows allocated at once.",
+		"watermark_pds":                  "The highest number of protection domains allocated at once.",
+		"watermark_qps":                  "The highest number of queue pairs allocated at once.",
+		"watermark_rc_qps":               "The highest number of RC queue pairs allocated at once.",
+		"watermark_srqs":                 "The highest number of shared receive queues allocated at once.",
+		"watermark_ud_qps":               "The highest number of UD queue pairs allocated at once.",
+	},
+	"cxgb4": {
+		"ip4_in_segments":            "The number of TCP segments over IPv4 received by the adapter's TCP offload engine, shared by all ports.",
+		"ip4_out_resets":             "The number of TCP segments over IPv4 with the RST flag sent by the adapter's TCP offload engine, shared by all ports.",
+		"ip4_out_segments":           "The number of TCP segments over IPv4 sent by the adapter's TCP offload engine, shared by all ports.",
+		"ip4_retransmitted_segments": "The number of TCP segments over IPv4 retransmitted by the adapter's TCP offload engine, shared by all ports.",
+		"ip6_in_segments":            "The number of TCP segments over IPv6 received by the adapter's TCP offload engine, shared by all ports.",
+		"ip6_out_resets":             "The number of TCP segments over IPv6 with the RST flag sent by the adapter's TCP offload engine, shared by all ports.",
+		"ip6_out_segments":           "The number of TCP segments over IPv6 sent by the adapter's TCP offload engine, shared by all ports.",
+		"ip6_retransmitted_segments": "The number of TCP segments over IPv6 retransmitted by the adapter's TCP offload engine, shared by all ports.",
+	},
+	"hfi1": {
+		"rx_flits":   "The number of flits received.",
+		"rx_packets": "The number of packets received.",
+		"rx_words":   "The number of 4-byte words received.",
+		"tx_flits":   "The number of flits transmitted.",
+		"tx_packets": "The number of packets transmitted.",
+		"tx_wait":    "The number of ticks in which the port had data to transmit but no flow control credits.",
+		"tx_words":   "The number of 4-byte words transmitted.",
+	},
+}
+
+// driverDocNames maps vendor hw_counters to canonical names. EFA's counters
+// of RDMA read and write operations drop their "rdma_" prefix, so they are
+// exported as rdma_read_bytes_total rather than rdma_rdma_read_bytes_total.
+// irdma's camel-case counters take the name of their mlx5 equivalent where
+// there is one and a snake-case name otherwise, and so do bnxt_re's
+// abbreviated counters and the camel-case ones of cxgb4 and hfi1. No other
+// driver uses these names, so the mapping does not depend on the device.
+var driverDocNames = map[string]string{
+	// efa
+	"rdma_read_bytes":       "read_bytes",
+	"rdma_read_resp_bytes":  "read_resp_bytes",
+	"rdma_read_wr_err":      "read_wr_err",
+	"rdma_read_wrs":         "read_wrs",
+	"rdma_write_bytes":      "write_bytes",
+	"rdma_write_recv_bytes": "write_recv_bytes",
+	"rdma_write_wr_err":     "write_wr_err",
+	"rdma_write_wrs":        "write_wrs",
+
+	// irdma
+	"cnpHandled":         "rp_cnp_handled",
+	"cnpIgnored":         "rp_cnp_ignored",
+	"cnpSent":            "np_cnp_sent",
+	"ip4InDiscards":      "ip4_in_discards",
+	"ip4InMcastOctets":   "ip4_in_multicast_octets",
+	"ip4InMcastPkts":     "ip4_in_multicast_packets",
+	"ip4InOctets":        "ip4_in_octets",
+	"ip4InPkts":          "ip4_in_packets",
+	"ip4InReasmRqd":      "ip4_in_reassembly_required",
+	"ip4InTruncatedPkts": "ip4_in_truncated_packets",
+	"ip4OutMcastOctets":  "ip4_out_multicast_octets",
+	"ip4OutMcastPkts":    "ip4_out_multicast_packets",
+	"ip4OutNoRoutes":     "ip4_out_no_routes",
+	"ip4OutOctets":       "ip4_out_octets",
+	"ip4OutPkts":         "ip4_out_packets",
+	"ip4OutSegRqd":       "ip4_out_segmentation_required",
+	"ip6InDiscards":      "ip6_in_discards",
+	"ip6InMcastOctets":   "ip6_in_multicast_octets",
+	"ip6InMcastPkts":     "ip6_in_multicast_packets",
+	"ip6InOctets":        "ip6_in_octets",
+	"ip6InPkts":          "ip6_in_packets",
+	"ip6InReasmRqd":      "ip6_in_reassembly_required",
+	"ip6InTruncatedPkts": "ip6_in_truncated_packets",
+	"ip6OutMcastOctets":  "ip6_out_multicast_octets",
+	"ip6OutMcastPkts":    "ip6_out_multicast_packets",
+	"ip6OutNoRoutes":     "ip6_out_no_routes",
+	"ip6OutOctets":       "ip6_out_octets",
+	"ip6OutPkts":         "ip6_out_packets",
+	"ip6OutSegRqd":       "ip6_out_segmentation_required",
+	"iwInRdmaReads":      "rx_read_requests",
+	"iwInRdmaSends":      "rx_send_requests",
+	"iwInRdmaWrites":     "rx_write_requests",
+	"iwOutRdmaReads":     "tx_read_requests",
+	"iwOutRdmaSends":     "tx_send_requests",
+	"iwOutRdmaWrites":    "tx_write_requests",
+	"iwRdmaBnd":          "mw_binds",
+	"iwRdmaInv":          "invalidates",
+	"RxECNMrkd":          "np_ecn_marked_roce_packets",
+	"RxUDP":              "rx_udp_packets",
+	"rxVlanErrors":       "rx_vlan_errors",
+	"tcpInOptErrors":     "tcp_in_option_errors",
+	"tcpInProtoErrors":   "tcp_in_protocol_errors",
+	"tcpInSegs":          "tcp_in_segments",
+	"tcpOutSegs":         "tcp_out_segments",
+	"tcpRetransSegs":     "tcp_retransmitted_segments",
+	"TxUDP":              "tx_udp_packets",
+
+	// bnxt_re
+	"dup_req":            "duplicate_request",
+	"max_retry_exceeded": "req_transport_retries_exceeded",
+	"rx_atomic_req":      "rx_atomic_requests",
+	"rx_cnp_pkts":        "rp_cnp_handled",
+	"rx_ecn_marked_pkts": "np_ecn_marked_roce_packets",
+	"rx_good_pkts":       "rx_good_packets",
+	"rx_read_req":        "rx_read_requests",
+	"rx_roce_only_bytes": "rdma_rx_bytes",
+	"rx_roce_only_pkts":  "rdma_rx_packets",
+	"rx_send_req":        "rx_send_requests",
+	"rx_write_req":       "rx_write_requests",
+	"seq_err_naks_rcvd":  "packet_seq_err",
+	"to_retransmits":     "local_ack_timeout_err",
+	"tx_atomic_req":      "tx_atomic_requests",
+	"tx_cnp_pkts":        "np_cnp_sent",
+	"tx_read_req":        "tx_read_requests",
+	"tx_roce_only_bytes": "rdma_tx_bytes",
+	"tx_roce_only_pkts":  "rdma_tx_packets",
+	"tx_send_req":        "tx_send_requests",
+	"tx_write_req":       "tx_write_requests",
+
+	// cxgb4
+	"ip4InSegs":      "ip4_in_segments",
+	"ip4OutRsts":     "ip4_out_resets",
+	"ip4OutSegs":     "ip4_out_segments",
+	"ip4RetransSegs": "ip4_retransmitted_segments",
+	"ip6InSegs":      "ip6_in_segments",
+	"ip6OutRsts":     "ip6_out_resets",
+	"ip6OutSegs":     "ip6_out_segments",
+	"ip6RetransSegs": "ip6_retransmitted_segments",
+
+	// hfi1, also per virtual lane without their VL suffix
+	"RxFlit":  "rx_flits",
+	"RxPkt":   "rx_packets",
+	"RxWords": "rx_words",
+	"TxFlit":  "tx_flits",
+	"TxPkt":   "tx_packets",
+	"TxWait":  "tx_wait",
+	"TxWords": "tx_words",
+}
+
+// driverDocHelp returns the help text a driver's help pack has for a
+// canonical counter name, and whether there is one.
+func driverDocHelp(driver, docName string) (string, bool) {
+	help, ok := driverHelp[driverHelpPacks[driver]][docName]
+	return help, ok
+}
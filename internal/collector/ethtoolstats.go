@@ -0,0 +1,108 @@
+package collector
+
+import (
+	"context"
+	"path"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// WithNetDevEthtoolStats exports the ethtool statistics of the netdevs
+// backing RoCE ports whose names match one of patterns, path.Match globs
+// such as rx_vport_rdma_* or tx_pause_ctrl_phy, as
+// rdma_netdev_ethtool_<stat>_total. NIC drivers report hundreds of
+// statistics per netdev, most of them per queue, so only selected ones are
+// exported. Statistics are read once per netdev and scrape, shared with the
+// PFC metrics.
+func WithNetDevEthtoolStats(provider NetDevStatsProvider, patterns []string) Option {
+	return func(c *RdmaCollector) {
+		if provider == nil || len(patterns) == 0 {
+			return
+		}
+		c.ethtoolStatsProvider = provider
+		c.ethtoolStatsPatterns = patterns
+		c.ethtoolStatsDescs = make(map[string]*prometheus.Desc)
+		c.ethtoolStatsNames = make(map[string]string)
+	}
+}
+
+func (c *RdmaCollector) ethtoolStatSelected(stat string) bool {
+	for _, pattern := range c.ethtoolStatsPatterns {
+		if ok, _ := path.Match(pattern, stat); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ethtoolStatDesc returns the descriptor of an ethtool statistic, e.g.
+// rx_discards_phy → rdma_netdev_ethtool_rx_discards_phy_total. Statistics
+// whose names sanitize to the metric name of another one, such as foo.nic
+// and foo_nic, would export one metric with two help texts and fail the
+// scrape, so only the first one seen gets a descriptor and the others nil.
+// It is only called while collectMu is held.
+func (c *RdmaCollector) ethtoolStatDesc(stat string) *prometheus.Desc {
+	if desc, ok := c.ethtoolStatsDescs[stat]; ok {
+		return desc
+	}
+	name := "rdma_netdev_ethtool_" + sanitizeStatName(stat) + "_total"
+	if other, ok := c.ethtoolStatsNames[name]; ok {
+		c.logger.Warn("skipping ethtool statistic whose metric name is taken", "stat", stat, "metric", name, "taken_by", other)
+		c.ethtoolStatsDescs[stat] = nil
+		return nil
+	}
+	desc := prometheus.NewDesc(
+		name,
+		"Ethtool statistic "+stat+" of the interface backing a RoCE port.",
+		c.portLabelNames("netdev"),
+		nil,
+	)
+	c.ethtoolStatsDescs[stat] = desc
+	c.ethtoolStatsNames[name] = stat
+	c.addDynamicDesc(desc)
+	return desc
+}
+
+// collectNetDevEthtoolStats exports the selected ethtool statistics of the
+// port's netdev. Like PFC, it skips VFs, whose netdevs may sit in a container
+// and whose ethtool ioctls can block.
+func (c *RdmaCollector) collectNetDevEthtoolStats(
+	ctx context.Context,
+	ch chan<- prometheus.Metric,
+	labels *portLabels,
+	attr rdma.PortAttributes,
+	isVF bool,
+	cache map[string]netDevStatsCacheEntry,
+) {
+	if c.ethtoolStatsProvider == nil || isVF {
+		return
+	}
+	if attr.LinkLayer != "Ethernet" || attr.NetDev == "" {
+		return
+	}
+
+	entry, ok := cache[attr.NetDev]
+	if !ok {
+		stats, err := c.ethtoolStatsProvider.Stats(ctx, attr.NetDev)
+		if err != nil {
+			c.logger.Warn("netdev ethtool stats read failed", "device", labels.device, "port", labels.port, "netdev", attr.NetDev, "err", err)
+			return
+		}
+		entry = netDevStatsCacheEntry{stats: stats}
+		cache[attr.NetDev] = entry
+	}
+
+	for _, stat := range sortedKeys(entry.stats) {
+		if !c.ethtoolStatSelected(stat) {
+			continue
+		}
+		desc := c.ethtoolStatDesc(stat)
+		if desc == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue,
+			float64(entry.stats[stat]), labels.values(attr.NetDev)...)
+	}
+}
@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// Expectations is the desired RDMA state of a node. Negative fields are not
+// set and are not exported.
+type Expectations struct {
+	// Devices is the number of RDMA devices the node should have.
+	Devices int
+	// ActivePorts is the number of ports that should be ACTIVE.
+	ActivePorts int
+	// PortRateGbps is the link rate, in Gb/s, every active port should run
+	// at, at least.
+	PortRateGbps float64
+}
+
+// ExpectationsProvider returns the desired RDMA state of the node, such as
+// kube.NodeWatcher. ok is false while it is not known yet.
+type ExpectationsProvider interface {
+	Expectations() (expectations Expectations, ok bool)
+}
+
+// WithExpectations exports the expectations of provider as rdma_expected and
+// whether the node meets them as rdma_expectation_violated, so the desired
+// state of each node can live with the node object instead of in alert
+// rules.
+func WithExpectations(provider ExpectationsProvider) Option {
+	return func(c *RdmaCollector) {
+		if provider == nil {
+			return
+		}
+		c.expectationsProvider = provider
+		c.expectedDesc = prometheus.NewDesc(
+			"rdma_expected",
+			"Expected value of an RDMA expectation of the node: devices, active_ports or port_rate_bps.",
+			[]string{"expectation"},
+			nil,
+		)
+		c.expectationViolatedDesc = prometheus.NewDesc(
+			"rdma_expectation_violated",
+			"Whether the node does not meet an RDMA expectation (1) or does (0).",
+			[]string{"expectation"},
+			nil,
+		)
+	}
+}
+
+// collectExpectations compares devices with the node's expectations. Port
+// attributes are needed, so it does not run in degraded mode.
+func (c *RdmaCollector) collectExpectations(ch chan<- prometheus.Metric, devices []rdma.Device) {
+	if c.expectationsProvider == nil {
+		return
+	}
+	expectations, ok := c.expectationsProvider.Expectations()
+	if !ok {
+		return
+	}
+
+	activePorts := 0
+	slowPort := false
+	for _, device := range devices {
+		for _, port := range device.Ports {
+			if port.Attributes.State != "ACTIVE" {
+				continue
+			}
+			activePorts++
+			if expectations.PortRateGbps < 0 {
+				continue
+			}
+			if bps, ok := rdma.LinkRateBps(port.Attributes.LinkSpeed); !ok || bps < expectations.PortRateGbps*1e9 {
+				slowPort = true
+			}
+		}
+	}
+
+	if expectations.Devices >= 0 {
+		c.emitExpectation(ch, "devices", float64(expectations.Devices), len(devices) != expectations.Devices)
+	}
+	if expectations.ActivePorts >= 0 {
+		c.emitExpectation(ch, "active_ports", float64(expectations.ActivePorts), activePorts != expectations.ActivePorts)
+	}
+	if expectations.PortRateGbps >= 0 {
+		c.emitExpectation(ch, "port_rate_bps", expectations.PortRateGbps*1e9, slowPort)
+	}
+}
+
+func (c *RdmaCollector) emitExpectation(ch chan<- prometheus.Metric, name string, expected float64, violated bool) {
+	ch <- prometheus.MustNewConstMetric(c.expectedDesc, prometheus.GaugeValue, expected, name)
+	ch <- prometheus.MustNewConstMetric(c.expectationViolatedDesc, prometheus.GaugeValue, boolToFloat(violated), name)
+}
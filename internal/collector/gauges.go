@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"maps"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// builtinGaugeCounters are the counters and hw_counters that report a
+// current value rather than a count of events: the verbs resources bnxt_re
+// has allocated and their high-water marks, and the lifespan in milliseconds
+// the kernel caches hw_counters reads for.
+var builtinGaugeCounters = map[string]struct{}{
+	"active_ahs":       {},
+	"active_cqs":       {},
+	"active_mrs":       {},
+	"active_mws":       {},
+	"active_pds":       {},
+	"active_qps":       {},
+	"active_rc_qps":    {},
+	"active_srqs":      {},
+	"active_ud_qps":    {},
+	"lifespan":         {},
+	"watermark_ahs":    {},
+	"watermark_cqs":    {},
+	"watermark_mrs":    {},
+	"watermark_mws":    {},
+	"watermark_pds":    {},
+	"watermark_qps":    {},
+	"watermark_rc_qps": {},
+	"watermark_srqs":   {},
+	"watermark_ud_qps": {},
+}
+
+// WithBuiltinGaugeCounters exports builtinGaugeCounters as gauges. It is
+// opt-in because gauges are named without the _total suffix, so it renames
+// existing series, e.g. rdma_lifespan_total to rdma_lifespan.
+func WithBuiltinGaugeCounters() Option {
+	return func(c *RdmaCollector) {
+		maps.Copy(c.gaugeCounters, builtinGaugeCounters)
+	}
+}
+
+// WithGaugeCounters exports the named counters or hw_counters as gauges, for
+// firmware that reports occupancy values in hw_counters. Gauges are named
+// without the _total suffix, e.g. rdma_active_qps.
+func WithGaugeCounters(names []string) Option {
+	return func(c *RdmaCollector) {
+		for _, name := range names {
+			c.gaugeCounters[name] = struct{}{}
+		}
+	}
+}
+
+// isGauge reports whether a counter or hw_counter is exported as a gauge.
+func (c *RdmaCollector) isGauge(stat string) bool {
+	_, ok := c.gaugeCounters[stat]
+	return ok
+}
+
+// valueType returns the type of the metric a counter or hw_counter is
+// exported as.
+func (c *RdmaCollector) valueType(stat string) prometheus.ValueType {
+	if c.isGauge(stat) {
+		return prometheus.GaugeValue
+	}
+	return prometheus.CounterValue
+}
@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// GIDTableProvider lists the populated GID table entries of every port.
+type GIDTableProvider interface {
+	GIDTable(ctx context.Context) ([]rdma.GIDEntry, error)
+}
+
+// WithGIDTable exports every populated GID table entry as rdma_port_gid_info,
+// so the presence of RoCEv2 GIDs on the expected VLAN interfaces can be
+// checked across a fleet.
+func WithGIDTable(provider GIDTableProvider) Option {
+	return func(c *RdmaCollector) {
+		c.gidTableProvider = provider
+	}
+}
+
+// collectGIDTable exports the GID tables of the devices of the snapshot.
+func (c *RdmaCollector) collectGIDTable(ctx context.Context, ch chan<- prometheus.Metric, devices []rdma.Device) {
+	if c.gidTableProvider == nil {
+		return
+	}
+	entries, err := c.gidTableProvider.GIDTable(ctx)
+	if err != nil {
+		c.logger.Warn("rdma gid table read failed", "err", err)
+		return
+	}
+
+	present := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		present[device.Name] = true
+	}
+	for _, entry := range entries {
+		if !present[entry.Device] {
+			continue
+		}
+		labels := c.labels.port(entry.Device, entry.Port)
+		ch <- prometheus.MustNewConstMetric(
+			c.portGIDInfoDesc,
+			prometheus.GaugeValue,
+			1,
+			labels.values(strconv.Itoa(entry.Index), entry.GID, entry.Type, entry.NetDev)...,
+		)
+	}
+}
@@ -0,0 +1,29 @@
+package collector
+
+// CacheInvalidator is implemented by providers that remember device state
+// between reads, such as rdma.SysfsProvider with change detection or cached
+// counter files.
+type CacheInvalidator interface {
+	InvalidateCache()
+}
+
+// InvalidateCache drops the shared device snapshot, the deep scan result, the
+// device limits and the provider's caches, so the next scrape reads every
+// device afresh. It is meant for after planned maintenance, when cached link
+// attributes would otherwise outlive the change. It waits for a running
+// scrape to finish.
+func (c *RdmaCollector) InvalidateCache() {
+	c.collectMu.Lock()
+	defer c.collectMu.Unlock()
+
+	if c.snapshot != nil {
+		c.snapshot.devices = nil
+	}
+	clear(c.deviceLimits)
+	c.deepMu.Lock()
+	c.deepResult = nil
+	c.deepMu.Unlock()
+	if invalidator, ok := c.provider.(CacheInvalidator); ok {
+		invalidator.InvalidateCache()
+	}
+}
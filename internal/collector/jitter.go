@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// jitterQuantiles are the quantiles exported for every tracked counter rate.
+var jitterQuantiles = []float64{0.01, 0.05, 0.5, 0.95, 0.99}
+
+type jitterState struct {
+	value      uint64
+	at         time.Time
+	rates      []float64
+	next       int
+	generation uint64
+}
+
+// jitterTracker keeps the per-second rates of selected counters between
+// consecutive reads. It is only accessed while collectMu is held.
+type jitterTracker struct {
+	counters   []string
+	window     int
+	generation uint64
+	states     map[counterKey]*jitterState
+}
+
+func newJitterTracker(counters []string, window int) *jitterTracker {
+	return &jitterTracker{
+		counters: counters,
+		window:   window,
+		states:   make(map[counterKey]*jitterState),
+	}
+}
+
+// begin starts a new scrape generation.
+func (t *jitterTracker) begin() {
+	t.generation++
+}
+
+// observe records a counter value read at now and returns the rates of the
+// window, oldest first. Decreasing values are counter resets and restart the
+// measurement without adding a rate.
+func (t *jitterTracker) observe(device string, port int, counter string, value uint64, now time.Time) []float64 {
+	key := counterKey{device: device, port: port, counter: counter}
+	state, ok := t.states[key]
+	if !ok {
+		state = &jitterState{rates: make([]float64, 0, t.window)}
+		t.states[key] = state
+	} else if elapsed := now.Sub(state.at).Seconds(); value >= state.value && elapsed > 0 {
+		rate := float64(value-state.value) / elapsed
+		if len(state.rates) < t.window {
+			state.rates = append(state.rates, rate)
+		} else {
+			state.rates[state.next] = rate
+		}
+		state.next = (state.next + 1) % t.window
+	}
+	state.value = value
+	state.at = now
+	state.generation = t.generation
+	return state.rates
+}
+
+// prune forgets counters that were not observed in the current generation.
+func (t *jitterTracker) prune() {
+	for key, state := range t.states {
+		if state.generation != t.generation {
+			delete(t.states, key)
+		}
+	}
+}
+
+// WithRateJitter exports the distribution of the per-second rates of counters
+// between consecutive scrapes, over the last window scrapes, as
+// rdma_port_counter_rate. A steadily paced port shows close quantiles; wide
+// ones mean bursty traffic. Counters are looked up in counters first, then in
+// hw_counters. Scrapes should be evenly spaced and frequent enough for the
+// pacing in question; an empty counter list or a window below 2 disables it.
+func WithRateJitter(counters []string, window int) Option {
+	return func(c *RdmaCollector) {
+		if len(counters) == 0 || window < 2 {
+			return
+		}
+		c.jitter = newJitterTracker(counters, window)
+	}
+}
+
+// collectRateJitter exports the rate summaries of a port's tracked counters.
+func (c *RdmaCollector) collectRateJitter(ch chan<- prometheus.Metric, labels *portLabels, device string, port rdma.Port, now time.Time) {
+	for _, name := range c.jitter.counters {
+		value, ok := port.Stats[name]
+		if !ok {
+			value, ok = port.HwStats[name]
+		}
+		if !ok {
+			continue
+		}
+		rates := c.jitter.observe(device, port.ID, name, value, now)
+		if len(rates) == 0 {
+			continue
+		}
+
+		sorted := slices.Clone(rates)
+		slices.Sort(sorted)
+		var sum float64
+		for _, rate := range sorted {
+			sum += rate
+		}
+		quantiles := make(map[float64]float64, len(jitterQuantiles))
+		for _, q := range jitterQuantiles {
+			quantiles[q] = sorted[quantileIndex(len(sorted), q)]
+		}
+		ch <- prometheus.MustNewConstSummary(
+			c.portCounterRateDesc,
+			uint64(len(sorted)),
+			sum,
+			quantiles,
+			labels.values(name)...,
+		)
+	}
+}
+
+// quantileIndex returns the nearest-rank index of quantile q in n sorted
+// values.
+func quantileIndex(n int, q float64) int {
+	idx := int(math.Ceil(float64(n)*q)) - 1
+	return min(max(idx, 0), n-1)
+}
@@ -0,0 +1,140 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	deviceLabel = "device"
+	portLabel   = "port"
+)
+
+// portLabels holds the interned label values of a port and the matching
+// label pairs, sorted by name as prometheus.Metric.Write requires.
+type portLabels struct {
+	device string
+	port   string
+	// rail is only meaningful when withRail is set.
+	rail       string
+	withRail   bool
+	pairs      []*dto.LabelPair
+	generation uint64
+	// role is only meaningful when withRole is set.
+	role     string
+	withRole bool
+}
+
+// values returns the label values matching portLabelNames(extra...).
+func (l *portLabels) values(extra ...string) []string {
+	values := make([]string, 0, len(extra)+4)
+	values = append(values, l.device, l.port)
+	values = append(values, extra...)
+	if l.withRail {
+		values = append(values, l.rail)
+	}
+	if l.withRole {
+		values = append(values, l.role)
+	}
+	return values
+}
+
+// labelCache keeps label values and label pairs of every port across scrapes,
+// so per-counter metrics share one immutable label slice instead of building
+// their own. It is only accessed while collectMu is held.
+type labelCache struct {
+	generation uint64
+	ports      map[portKey]*portLabels
+	// rail resolves the rail of a device; nil when rail labels are disabled.
+	rail func(device string) string
+	// role resolves the role of a port; nil when role labels are disabled.
+	role func(device string, port int) string
+}
+
+func newLabelCache() *labelCache {
+	return &labelCache{ports: make(map[portKey]*portLabels)}
+}
+
+// begin starts a new scrape generation.
+func (l *labelCache) begin() {
+	l.generation++
+}
+
+// port returns the cached labels for a device port.
+func (l *labelCache) port(device string, id int) *portLabels {
+	key := portKey{device: device, port: id}
+	labels, ok := l.ports[key]
+	if ok && l.role != nil && labels.role != l.role(device, id) {
+		// The port was reclassified; rebuild its labels.
+		ok = false
+	}
+	if !ok {
+		port := strconv.Itoa(id)
+		labels = &portLabels{
+			device: device,
+			port:   port,
+			pairs: []*dto.LabelPair{
+				{Name: stringPtr(deviceLabel), Value: &device},
+				{Name: stringPtr(portLabel), Value: &port},
+			},
+		}
+		if l.rail != nil {
+			rail := l.rail(device)
+			labels.rail = rail
+			labels.withRail = true
+			// "rail" sorts after "device" and "port".
+			labels.pairs = append(labels.pairs, &dto.LabelPair{Name: stringPtr(railLabel), Value: &rail})
+		}
+		if l.role != nil {
+			role := l.role(device, id)
+			labels.role = role
+			labels.withRole = true
+			// "role" sorts after "rail".
+			labels.pairs = append(labels.pairs, &dto.LabelPair{Name: stringPtr(roleLabel), Value: &role})
+		}
+		l.ports[key] = labels
+	}
+	labels.generation = l.generation
+	return labels
+}
+
+// prune forgets ports that were not observed in the current generation.
+func (l *labelCache) prune() {
+	for key, labels := range l.ports {
+		if labels.generation != l.generation {
+			delete(l.ports, key)
+		}
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// portCounter is a constant counter, or a gauge when gauge is set, whose
+// label pairs are shared with the other counters of the same port. Label
+// pairs are immutable by the prometheus.Metric contract, so sharing them is
+// safe.
+type portCounter struct {
+	desc   *prometheus.Desc
+	labels []*dto.LabelPair
+	value  float64
+	gauge  bool
+}
+
+func (m *portCounter) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m *portCounter) Write(out *dto.Metric) error {
+	value := m.value
+	out.Label = m.labels
+	if m.gauge {
+		out.Gauge = &dto.Gauge{Value: &value}
+		return nil
+	}
+	out.Counter = &dto.Counter{Value: &value}
+	return nil
+}
@@ -0,0 +1,61 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// DeviceLimitProvider reports the maximum number of each verbs resource a
+// device supports, such as rdma.VerbsLimitProvider.
+type DeviceLimitProvider interface {
+	DeviceLimits(ctx context.Context, device string) (map[string]uint64, error)
+}
+
+// WithDeviceLimits exports the capability limits of every device as
+// rdma_device_limit{resource}, so capacity dashboards can divide the
+// resources in use by the limit. Limits do not change while a device exists,
+// so each device is queried once; a failed query is not retried until the
+// device reappears or the cache is invalidated.
+func WithDeviceLimits(provider DeviceLimitProvider) Option {
+	return func(c *RdmaCollector) {
+		c.deviceLimitProvider = provider
+		c.deviceLimits = make(map[string]map[string]uint64)
+		c.deviceLimitDesc = prometheus.NewDesc(
+			"rdma_device_limit",
+			"Maximum number of a verbs resource (qp, cq, mr, ...) the RDMA device supports, from ibv_query_device.",
+			[]string{"device", "resource"},
+			nil,
+		)
+	}
+}
+
+// collectDeviceLimits exports the limits of the devices of the snapshot and
+// forgets the ones of devices that went away.
+func (c *RdmaCollector) collectDeviceLimits(ctx context.Context, ch chan<- prometheus.Metric, devices []rdma.Device) {
+	if c.deviceLimitProvider == nil {
+		return
+	}
+	limits := make(map[string]map[string]uint64, len(devices))
+	for _, device := range devices {
+		deviceLimits, ok := c.deviceLimits[device.Name]
+		if !ok {
+			var err error
+			deviceLimits, err = c.deviceLimitProvider.DeviceLimits(ctx, device.Name)
+			if err != nil {
+				if ctx.Err() != nil {
+					// Retry on the next scrape rather than caching the timeout.
+					continue
+				}
+				c.logger.Warn("rdma device limits read failed", "device", device.Name, "err", err)
+			}
+		}
+		limits[device.Name] = deviceLimits
+		for _, resource := range sortedKeys(deviceLimits) {
+			ch <- prometheus.MustNewConstMetric(c.deviceLimitDesc, prometheus.GaugeValue, float64(deviceLimits[resource]), device.Name, resource)
+		}
+	}
+	c.deviceLimits = limits
+}
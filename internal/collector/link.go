@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/netdev"
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// LinkSettingsProvider fetches the negotiated link configuration of a netdev.
+type LinkSettingsProvider interface {
+	LinkSettings(ctx context.Context, netDev string) (netdev.LinkSettings, error)
+}
+
+const (
+	linkSettingSpeed   = "speed"
+	linkSettingDuplex  = "duplex"
+	linkSettingAutoneg = "autoneg"
+)
+
+// linkTracker remembers the last observed settings per netdev to count
+// renegotiations. It is only accessed while collectMu is held.
+type linkTracker struct {
+	last    map[string]netdev.LinkSettings
+	changes map[string]map[string]uint64
+	// present holds the netdevs of the ports of the current scrape.
+	present map[string]bool
+}
+
+func newLinkTracker() *linkTracker {
+	return &linkTracker{
+		last:    make(map[string]netdev.LinkSettings),
+		changes: make(map[string]map[string]uint64),
+		present: make(map[string]bool),
+	}
+}
+
+// begin starts a new scrape.
+func (t *linkTracker) begin() {
+	clear(t.present)
+}
+
+// prune forgets netdevs that no port had in the current scrape, so renamed
+// or removed netdevs do not accumulate. A netdev whose read merely failed is
+// still present and keeps its change counts.
+func (t *linkTracker) prune() {
+	for netDev := range t.last {
+		if !t.present[netDev] {
+			delete(t.last, netDev)
+		}
+	}
+	for netDev := range t.changes {
+		if !t.present[netDev] {
+			delete(t.changes, netDev)
+		}
+	}
+}
+
+// observe records settings for netDev and returns its cumulative change
+// counts per setting. The first observation establishes the baseline.
+func (t *linkTracker) observe(netDev string, settings netdev.LinkSettings) map[string]uint64 {
+	changes, ok := t.changes[netDev]
+	if !ok {
+		changes = map[string]uint64{
+			linkSettingSpeed:   0,
+			linkSettingDuplex:  0,
+			linkSettingAutoneg: 0,
+		}
+		t.changes[netDev] = changes
+	}
+
+	if prev, seen := t.last[netDev]; seen {
+		if prev.SpeedMbps != settings.SpeedMbps {
+			changes[linkSettingSpeed]++
+		}
+		if prev.Duplex != settings.Duplex {
+			changes[linkSettingDuplex]++
+		}
+		if prev.Autoneg != settings.Autoneg {
+			changes[linkSettingAutoneg]++
+		}
+	}
+	t.last[netDev] = settings
+	return changes
+}
+
+// WithLinkSettingsProvider configures a provider used to export netdev link
+// speed, duplex and autonegotiation state for RoCE ports.
+func WithLinkSettingsProvider(provider LinkSettingsProvider) Option {
+	return func(c *RdmaCollector) {
+		c.linkSettingsProvider = provider
+		c.links = newLinkTracker()
+	}
+}
+
+func (c *RdmaCollector) collectLinkSettings(
+	ctx context.Context,
+	ch chan<- prometheus.Metric,
+	labels *portLabels,
+	attr rdma.PortAttributes,
+	isVF bool,
+	seen map[string]bool,
+	warming bool,
+) {
+	if c.linkSettingsProvider == nil {
+		return
+	}
+	// VF netdevs report the PF's settings at best; skip them like PFC.
+	if isVF || attr.LinkLayer != "Ethernet" || attr.NetDev == "" {
+		return
+	}
+	c.links.present[attr.NetDev] = true
+
+	settings, err := c.linkSettingsProvider.LinkSettings(ctx, attr.NetDev)
+	if err != nil {
+		c.logger.Warn("netdev link settings read failed", "device", labels.device, "port", labels.port, "netdev", attr.NetDev, "err", err)
+		return
+	}
+
+	// Several ports can share a netdev; count a renegotiation only once.
+	// While warming up, renegotiations only move the baseline.
+	var changes map[string]uint64
+	if warming {
+		c.links.last[attr.NetDev] = settings
+	} else if seen[attr.NetDev] {
+		changes = c.links.changes[attr.NetDev]
+	} else {
+		changes = c.links.observe(attr.NetDev, settings)
+		seen[attr.NetDev] = true
+	}
+
+	if settings.SpeedMbps != netdev.SpeedUnknown && settings.SpeedMbps != 0 {
+		ch <- prometheus.MustNewConstMetric(c.netDevLinkSpeedDesc, prometheus.GaugeValue,
+			float64(settings.SpeedMbps)*1e6, labels.values(attr.NetDev)...)
+	}
+	if settings.Duplex != netdev.DuplexUnknown {
+		ch <- prometheus.MustNewConstMetric(c.netDevLinkDuplexDesc, prometheus.GaugeValue,
+			boolToFloat(settings.Duplex == netdev.DuplexFull), labels.values(attr.NetDev)...)
+	}
+	ch <- prometheus.MustNewConstMetric(c.netDevLinkAutonegDesc, prometheus.GaugeValue,
+		boolToFloat(settings.Autoneg), labels.values(attr.NetDev)...)
+
+	if warming {
+		return
+	}
+	for _, setting := range []string{linkSettingAutoneg, linkSettingDuplex, linkSettingSpeed} {
+		ch <- prometheus.MustNewConstMetric(c.netDevLinkChangesDesc, prometheus.CounterValue,
+			float64(changes[setting]), labels.values(attr.NetDev, setting)...)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	linkErrorRecoveryStat = "link_error_recovery"
+
+	defaultRecoveryBurstThreshold = 3
+	defaultRecoveryBurstWindow    = time.Minute
+)
+
+// recoveryBurstPolicy defines a burst of link error recoveries: at least
+// threshold of them within window. A zero threshold disables detection.
+type recoveryBurstPolicy struct {
+	threshold uint64
+	window    time.Duration
+}
+
+// WithLinkRecoveryBursts sets how many link_error_recovery increments within
+// window make a burst in stateful mode. Rates averaged over minutes hide the
+// short storms of recoveries that tend to precede a link going down for good.
+// The default is 3 recoveries within a minute; a threshold below 1 or a
+// non-positive window disables burst detection.
+func WithLinkRecoveryBursts(threshold int, window time.Duration) Option {
+	return func(c *RdmaCollector) {
+		if threshold < 1 || window <= 0 {
+			c.recoveryBursts = recoveryBurstPolicy{}
+			return
+		}
+		c.recoveryBursts = recoveryBurstPolicy{threshold: uint64(threshold), window: window}
+	}
+}
+
+// recoveryEvent is an increase of link_error_recovery between two reads.
+type recoveryEvent struct {
+	at    time.Time
+	count uint64
+}
+
+// recoveryBurst follows the link error recoveries of one port.
+type recoveryBurst struct {
+	prev  uint64
+	known bool
+	// events are the increases within the burst window, oldest first, while
+	// no burst is active.
+	events []recoveryEvent
+	// active is set from the increase completing a burst until a full window
+	// passes without recoveries; last is the time of the latest increase.
+	active bool
+	last   time.Time
+	// size is the number of recoveries of the current or latest burst.
+	size   uint64
+	bursts uint64
+}
+
+// observe records the port's link_error_recovery value read at now. The
+// first read, counter resets and reads while warming up only move the
+// baseline.
+func (b *recoveryBurst) observe(policy recoveryBurstPolicy, value uint64, now time.Time, warming bool) {
+	var increase uint64
+	if b.known && value > b.prev && !warming {
+		increase = value - b.prev
+	}
+	b.prev, b.known = value, true
+
+	cutoff := now.Add(-policy.window)
+	expired := 0
+	for expired < len(b.events) && !b.events[expired].at.After(cutoff) {
+		expired++
+	}
+	b.events = b.events[expired:]
+	if b.active && !b.last.After(cutoff) {
+		b.active = false
+	}
+	if increase == 0 {
+		return
+	}
+
+	b.last = now
+	if b.active {
+		b.size += increase
+		return
+	}
+	b.events = append(b.events, recoveryEvent{at: now, count: increase})
+	var total uint64
+	for _, event := range b.events {
+		total += event.count
+	}
+	if total >= policy.threshold {
+		b.active = true
+		b.bursts++
+		b.size = total
+		b.events = nil
+	}
+}
+
+func (c *RdmaCollector) collectRecoveryBursts(ch chan<- prometheus.Metric, labels *portLabels, state *portState) {
+	if !state.recovery.known {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.portRecoveryBurstsDesc, prometheus.CounterValue, float64(state.recovery.bursts), labels.values()...)
+	ch <- prometheus.MustNewConstMetric(c.portRecoveryBurstSizeDesc, prometheus.GaugeValue, float64(state.recovery.size), labels.values()...)
+}
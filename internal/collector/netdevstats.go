@@ -0,0 +1,78 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// NetDevStatisticsProvider reads the generic interface counters of a netdev
+// (/sys/class/net/<netdev>/statistics).
+type NetDevStatisticsProvider interface {
+	NetDevStatistics(ctx context.Context, netDev string) (map[string]uint64, error)
+}
+
+// WithNetDevStatistics exports the generic counters of the netdevs backing
+// RoCE ports (rx_dropped, tx_errors, ...) as rdma_netdev_<stat>_total. They
+// come from sysfs, so they remain available where ethtool is not, e.g.
+// without CAP_NET_ADMIN.
+func WithNetDevStatistics(provider NetDevStatisticsProvider) Option {
+	return func(c *RdmaCollector) {
+		if provider == nil {
+			return
+		}
+		c.netDevStatisticsProvider = provider
+		c.netDevStatisticsDescs = make(map[string]*prometheus.Desc)
+	}
+}
+
+// netDevStatisticsDesc returns the descriptor of a netdev statistic, e.g.
+// rx_dropped → rdma_netdev_rx_dropped_total. It is only called while
+// collectMu is held.
+func (c *RdmaCollector) netDevStatisticsDesc(stat string) *prometheus.Desc {
+	if desc, ok := c.netDevStatisticsDescs[stat]; ok {
+		return desc
+	}
+	desc := prometheus.NewDesc(
+		"rdma_netdev_"+sanitizeStatName(stat)+"_total",
+		"Netdev counter "+stat+" of the interface backing a RoCE port, from /sys/class/net/<netdev>/statistics.",
+		c.portLabelNames("netdev"),
+		nil,
+	)
+	c.netDevStatisticsDescs[stat] = desc
+	c.addDynamicDesc(desc)
+	return desc
+}
+
+func (c *RdmaCollector) collectNetDevStatistics(
+	ctx context.Context,
+	ch chan<- prometheus.Metric,
+	labels *portLabels,
+	attr rdma.PortAttributes,
+	cache map[string]netDevStatsCacheEntry,
+) {
+	if c.netDevStatisticsProvider == nil {
+		return
+	}
+	if attr.LinkLayer != "Ethernet" || attr.NetDev == "" {
+		return
+	}
+
+	// Several ports can share a netdev; read it once per scrape.
+	entry, ok := cache[attr.NetDev]
+	if !ok {
+		entry.stats, entry.err = c.netDevStatisticsProvider.NetDevStatistics(ctx, attr.NetDev)
+		cache[attr.NetDev] = entry
+	}
+	if entry.err != nil {
+		c.logger.Warn("netdev statistics read failed", "device", labels.device, "port", labels.port, "netdev", attr.NetDev, "err", entry.err)
+		return
+	}
+
+	for _, stat := range sortedKeys(entry.stats) {
+		ch <- prometheus.MustNewConstMetric(c.netDevStatisticsDesc(stat), prometheus.CounterValue,
+			float64(entry.stats[stat]), labels.values(attr.NetDev)...)
+	}
+}
@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// WithNodeDescCheck exports rdma_device_node_desc_mismatch, comparing the
+// node_desc of every device with hostname. Subnet managers and fabric tools
+// identify hosts by node_desc, which rdma-ndd sets to "<hostname> <device>";
+// a reimaged host whose node_desc was not refreshed shows up under its old
+// name.
+func WithNodeDescCheck(hostname string) Option {
+	return func(c *RdmaCollector) {
+		if hostname == "" {
+			return
+		}
+		c.hostname = hostname
+		c.nodeDescMismatchDesc = prometheus.NewDesc(
+			"rdma_device_node_desc_mismatch",
+			"Whether the device's node_desc does not start with the host name (1) or does (0).",
+			[]string{"device", "node_desc", "hostname"},
+			nil,
+		)
+	}
+}
+
+// nodeDescMatches reports whether the first word of nodeDesc names host,
+// comparing short names so FQDNs and short host names match each other.
+func nodeDescMatches(nodeDesc, host string) bool {
+	fields := strings.Fields(nodeDesc)
+	if len(fields) == 0 {
+		return false
+	}
+	return strings.EqualFold(shortHostname(fields[0]), shortHostname(host))
+}
+
+func shortHostname(name string) string {
+	short, _, _ := strings.Cut(name, ".")
+	return short
+}
+
+func (c *RdmaCollector) collectNodeDescMismatch(ch chan<- prometheus.Metric, device rdma.Device) {
+	if c.nodeDescMismatchDesc == nil || device.Attributes.NodeDesc == "" {
+		return
+	}
+	value := 0.0
+	if !nodeDescMatches(device.Attributes.NodeDesc, c.hostname) {
+		value = 1
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.nodeDescMismatchDesc,
+		prometheus.GaugeValue,
+		value,
+		device.Name,
+		device.Attributes.NodeDesc,
+		c.hostname,
+	)
+}
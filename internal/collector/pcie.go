@@ -0,0 +1,38 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+const portStateActive = "ACTIVE"
+
+// collectPCIeLimited reports whether the device's negotiated PCIe link can
+// carry the combined line rate of its active ports. Ports of a multi-port HCA
+// share one PCIe link, so their rates are summed.
+func (c *RdmaCollector) collectPCIeLimited(ch chan<- prometheus.Metric, device rdma.Device) {
+	if !device.PCIeLink.Known() {
+		return
+	}
+
+	var lineRate float64
+	for _, port := range device.Ports {
+		if port.Attributes.State != portStateActive {
+			continue
+		}
+		if rate, ok := rdma.LinkRateBps(port.Attributes.LinkSpeed); ok {
+			lineRate += rate
+		}
+	}
+	if lineRate == 0 {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.pcieLimitedDesc,
+		prometheus.GaugeValue,
+		boolToFloat(device.PCIeLink.BandwidthBps() < lineRate),
+		device.Name,
+	)
+}
@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// PKeyTableProvider lists the populated P_Key table entries of every port.
+type PKeyTableProvider interface {
+	PKeyTable(ctx context.Context) ([]rdma.PKeyEntry, error)
+}
+
+// WithPKeyTable exports every populated P_Key table entry as
+// rdma_port_pkey_info, so the partition membership of the hosts of a
+// multi-tenant InfiniBand cluster can be verified.
+func WithPKeyTable(provider PKeyTableProvider) Option {
+	return func(c *RdmaCollector) {
+		c.pkeyTableProvider = provider
+	}
+}
+
+// collectPKeyTable exports the P_Key tables of the devices of the snapshot.
+func (c *RdmaCollector) collectPKeyTable(ctx context.Context, ch chan<- prometheus.Metric, devices []rdma.Device) {
+	if c.pkeyTableProvider == nil {
+		return
+	}
+	entries, err := c.pkeyTableProvider.PKeyTable(ctx)
+	if err != nil {
+		c.logger.Warn("rdma pkey table read failed", "err", err)
+		return
+	}
+
+	present := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		present[device.Name] = true
+	}
+	for _, entry := range entries {
+		if !present[entry.Device] {
+			continue
+		}
+		labels := c.labels.port(entry.Device, entry.Port)
+		ch <- prometheus.MustNewConstMetric(
+			c.portPKeyInfoDesc,
+			prometheus.GaugeValue,
+			1,
+			labels.values(strconv.Itoa(entry.Index), entry.PKey)...,
+		)
+	}
+}
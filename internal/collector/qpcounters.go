@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// QPCounterProvider reads the kernel's per-QP statistics counters, such as
+// rdma.NetlinkProvider.
+type QPCounterProvider interface {
+	QPCounters(ctx context.Context) ([]rdma.QPCounter, error)
+}
+
+// WithQPCounters exports the counters of queue pairs bound to a kernel
+// statistics counter ("rdma statistic qp show"), labeled by counter ID, QP
+// type and, for counters with a single QP, its number. QPs are bound outside
+// the exporter. At most limit QP counters are exported per scrape, in device,
+// port and counter ID order; the rest are reported by
+// rdma_qp_counters_dropped. A limit below 1 disables it.
+func WithQPCounters(provider QPCounterProvider, limit int) Option {
+	return func(c *RdmaCollector) {
+		if limit < 1 {
+			return
+		}
+		c.qpCounterProvider = provider
+		c.qpCounterLimit = limit
+	}
+}
+
+// collectQPCounters exports the QP counters of the devices of the snapshot.
+func (c *RdmaCollector) collectQPCounters(ctx context.Context, ch chan<- prometheus.Metric, devices []rdma.Device) {
+	if c.qpCounterProvider == nil {
+		return
+	}
+	counters, err := c.qpCounterProvider.QPCounters(ctx)
+	if err != nil {
+		c.logger.Warn("rdma qp counter read failed", "err", err)
+		return
+	}
+
+	present := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		present[device.Name] = true
+	}
+	exported, dropped := 0, 0
+	for _, counter := range counters {
+		if !present[counter.Device] {
+			continue
+		}
+		if exported == c.qpCounterLimit {
+			dropped++
+			continue
+		}
+		exported++
+
+		labels := c.labels.port(counter.Device, counter.Port)
+		id := strconv.FormatUint(uint64(counter.ID), 10)
+		// Counters shared by several QPs, as in auto mode, carry no QP number.
+		lqpn := ""
+		if len(counter.QPNs) == 1 {
+			lqpn = strconv.FormatUint(uint64(counter.QPNs[0]), 10)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.qpCounterQPsDesc,
+			prometheus.GaugeValue,
+			float64(len(counter.QPNs)),
+			labels.values(id, counter.Mode, counter.QPType)...,
+		)
+		for _, name := range sortedKeys(counter.Stats) {
+			ch <- prometheus.MustNewConstMetric(
+				c.qpCounterDesc,
+				prometheus.CounterValue,
+				float64(counter.Stats[name]),
+				labels.values(id, counter.QPType, lqpn, name)...,
+			)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.qpCountersDroppedDesc, prometheus.GaugeValue, float64(dropped))
+}
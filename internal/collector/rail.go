@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"strconv"
+	"strings"
+)
+
+const railLabel = "rail"
+
+// WithRailLabels adds a rail label to every per-port series, so multi-rail
+// dashboards can group by rail instead of device name. rails maps device
+// names to rail names; devices it does not list get "rail<N>" from the
+// trailing index of the device name (mlx5_1 → rail1), or an empty rail when
+// the name has no index.
+func WithRailLabels(rails map[string]string) Option {
+	return func(c *RdmaCollector) {
+		c.labels.rail = func(device string) string {
+			if rail, ok := rails[device]; ok {
+				return rail
+			}
+			return railFromDeviceIndex(device)
+		}
+	}
+}
+
+func railFromDeviceIndex(device string) string {
+	index, err := strconv.Atoi(device[len(strings.TrimRight(device, "0123456789")):])
+	if err != nil {
+		return ""
+	}
+	return "rail" + strconv.Itoa(index)
+}
+
+// portLabelNames returns the label names of a per-port series: device and
+// port, then extra, then the rail and role when those labels are enabled.
+func (c *RdmaCollector) portLabelNames(extra ...string) []string {
+	names := make([]string, 0, len(extra)+4)
+	names = append(names, deviceLabel, portLabel)
+	names = append(names, extra...)
+	if c.labels.rail != nil {
+		names = append(names, railLabel)
+	}
+	if c.labels.role != nil {
+		names = append(names, roleLabel)
+	}
+	return names
+}
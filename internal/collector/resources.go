@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// ResourceProvider counts the verbs objects allocated on each device, such as
+// rdma.NetlinkProvider.
+type ResourceProvider interface {
+	// ResourceCounts maps device names to resource types to counts.
+	ResourceCounts(ctx context.Context) (map[string]map[string]uint64, error)
+}
+
+// ProcessResourceProvider counts the verbs objects allocated on each device
+// per owning process, such as rdma.NetlinkProvider.
+type ProcessResourceProvider interface {
+	ProcessResourceCounts(ctx context.Context) ([]rdma.ProcessResourceCount, error)
+}
+
+// resourceHelp lists the resource types exported by WithResourceProvider, as
+// named by the kernel's resource tracking.
+var resourceHelp = map[string]string{
+	"qp":    "queue pairs",
+	"cq":    "completion queues",
+	"mr":    "memory regions",
+	"pd":    "protection domains",
+	"ctx":   "user contexts",
+	"srq":   "shared receive queues",
+	"cm_id": "RDMA connection manager identifiers",
+}
+
+// WithResourceProvider exports the number of allocated verbs objects per
+// device as rdma_resource_<type> gauges, e.g. rdma_resource_qp, so leaking
+// workloads can be alerted on. Resource types the kernel adds later are
+// ignored until they are listed here.
+func WithResourceProvider(provider ResourceProvider) Option {
+	return func(c *RdmaCollector) {
+		c.resourceProvider = provider
+		c.resourceDescs = make(map[string]*prometheus.Desc, len(resourceHelp))
+		for resource, help := range resourceHelp {
+			c.resourceDescs[resource] = prometheus.NewDesc(
+				"rdma_resource_"+resource,
+				"Number of "+help+" allocated on the device, from the kernel's RDMA resource tracking.",
+				[]string{"device"},
+				nil,
+			)
+		}
+	}
+}
+
+// collectResources exports the resource counts of the devices of the
+// snapshot, so exclusions, silences and deduplication apply.
+func (c *RdmaCollector) collectResources(ctx context.Context, ch chan<- prometheus.Metric, devices []rdma.Device) {
+	if c.resourceProvider == nil {
+		return
+	}
+	counts, err := c.resourceProvider.ResourceCounts(ctx)
+	if err != nil {
+		c.logger.Warn("rdma resource read failed", "err", err)
+		return
+	}
+	for _, device := range devices {
+		resources := counts[device.Name]
+		for _, resource := range sortedKeys(resources) {
+			desc, ok := c.resourceDescs[resource]
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(resources[resource]), device.Name)
+		}
+	}
+}
+
+// processResourceHelp lists the resource types exported by
+// WithProcessResources.
+var processResourceHelp = map[string]string{
+	"qp":  "queue pairs",
+	"mr":  "memory regions",
+	"ctx": "user contexts",
+}
+
+// WithProcessResources breaks the QP, MR and user context counts down by
+// owning process as rdma_resource_<type>_by_process{device,pid,comm}, to find
+// which application leaks them. Objects owned by the kernel have pid "0" and
+// the module name in brackets as comm.
+func WithProcessResources(provider ProcessResourceProvider) Option {
+	return func(c *RdmaCollector) {
+		c.processResourceProvider = provider
+		c.processResourceDescs = make(map[string]*prometheus.Desc, len(processResourceHelp))
+		for resource, help := range processResourceHelp {
+			c.processResourceDescs[resource] = prometheus.NewDesc(
+				"rdma_resource_"+resource+"_by_process",
+				"Number of "+help+" a process has allocated on the device, from the kernel's RDMA resource tracking.",
+				[]string{"device", "pid", "comm"},
+				nil,
+			)
+		}
+	}
+}
+
+// collectProcessResources exports the per-process resource counts of the
+// devices of the snapshot.
+func (c *RdmaCollector) collectProcessResources(ctx context.Context, ch chan<- prometheus.Metric, devices []rdma.Device) {
+	if c.processResourceProvider == nil {
+		return
+	}
+	counts, err := c.processResourceProvider.ProcessResourceCounts(ctx)
+	if err != nil {
+		c.logger.Warn("rdma process resource read failed", "err", err)
+		return
+	}
+	present := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		present[device.Name] = true
+	}
+	for _, count := range counts {
+		desc, ok := c.processResourceDescs[count.Resource]
+		if !ok || !present[count.Device] {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(count.Count), count.Device, strconv.Itoa(count.PID), count.Comm)
+	}
+}
@@ -0,0 +1,26 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// ReadStatsProvider is implemented by providers that retry failed device
+// reads and count them per device.
+type ReadStatsProvider interface {
+	ReadStats() []rdma.DeviceReadStats
+}
+
+// collectReadStats exports the provider's per-device retry and error counts.
+// Devices only appear once they needed a retry or failed.
+func (c *RdmaCollector) collectReadStats(ch chan<- prometheus.Metric) {
+	provider, ok := c.provider.(ReadStatsProvider)
+	if !ok {
+		return
+	}
+	for _, stats := range provider.ReadStats() {
+		ch <- prometheus.MustNewConstMetric(c.deviceReadRetriesDesc, prometheus.CounterValue, float64(stats.Retries), stats.Device)
+		ch <- prometheus.MustNewConstMetric(c.deviceReadErrorsDesc, prometheus.CounterValue, float64(stats.Errors), stats.Device)
+	}
+}
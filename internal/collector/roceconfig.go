@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// RoCEConfigProvider reads the QoS configuration of every RoCE port.
+type RoCEConfigProvider interface {
+	RoCEConfigs(ctx context.Context) ([]rdma.RoCEConfig, error)
+}
+
+// WithRoCEConfig exports the ToS/DSCP, RDMA CM defaults, trust state,
+// per-priority ECN and DCQCN configuration of RoCE ports as
+// rdma_roce_qos_info, rdma_roce_default_version, rdma_roce_default_tos,
+// rdma_roce_ecn_enabled and rdma_roce_dcqcn_parameter, so a node whose
+// lossless configuration drifted from the rest of the fleet, or that fell
+// back to RoCEv1 after a driver reinstall, shows up in a query.
+func WithRoCEConfig(provider RoCEConfigProvider) Option {
+	return func(c *RdmaCollector) {
+		c.roceConfigProvider = provider
+	}
+}
+
+// collectRoCEConfig exports the RoCE configuration of the devices of the
+// snapshot.
+func (c *RdmaCollector) collectRoCEConfig(ctx context.Context, ch chan<- prometheus.Metric, devices []rdma.Device) {
+	if c.roceConfigProvider == nil {
+		return
+	}
+	configs, err := c.roceConfigProvider.RoCEConfigs(ctx)
+	if err != nil {
+		c.logger.Warn("rdma roce config read failed", "err", err)
+		return
+	}
+
+	present := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		present[device.Name] = true
+	}
+	for _, config := range configs {
+		if !present[config.Device] {
+			continue
+		}
+		labels := c.labels.port(config.Device, config.Port)
+		var tos, dscp, trafficClass string
+		if config.DefaultToS >= 0 {
+			tos = strconv.Itoa(config.DefaultToS)
+			dscp = strconv.Itoa(config.DefaultToS >> 2)
+		}
+		if config.TrafficClass >= 0 {
+			trafficClass = strconv.Itoa(config.TrafficClass)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.roceQoSInfoDesc,
+			prometheus.GaugeValue,
+			1,
+			labels.values(config.NetDev, config.Trust, tos, dscp, trafficClass)...,
+		)
+		if config.DefaultRoCEVersion > 0 {
+			ch <- prometheus.MustNewConstMetric(
+				c.roceDefaultVersionDesc,
+				prometheus.GaugeValue,
+				float64(config.DefaultRoCEVersion),
+				labels.values()...,
+			)
+		}
+		if config.DefaultToS >= 0 {
+			ch <- prometheus.MustNewConstMetric(
+				c.roceDefaultToSDesc,
+				prometheus.GaugeValue,
+				float64(config.DefaultToS),
+				labels.values()...,
+			)
+		}
+		for _, ecn := range config.ECN {
+			enabled := 0.0
+			if ecn.Enabled {
+				enabled = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.roceECNEnabledDesc,
+				prometheus.GaugeValue,
+				enabled,
+				labels.values(ecn.Point, strconv.Itoa(ecn.Priority))...,
+			)
+		}
+		for _, param := range config.DCQCN {
+			ch <- prometheus.MustNewConstMetric(
+				c.roceDCQCNParamDesc,
+				prometheus.GaugeValue,
+				float64(param.Value),
+				labels.values(param.Point, param.Name)...,
+			)
+		}
+	}
+}
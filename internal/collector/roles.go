@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"context"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const roleLabel = "role"
+
+// PortRoleRule assigns Role to the ports with a RoCE GID inside Prefix or on
+// a netdev of VLAN VLAN. Only one of Prefix and VLAN is set.
+type PortRoleRule struct {
+	Role   string
+	Prefix netip.Prefix
+	VLAN   int
+}
+
+// matches reports whether a GID of a port satisfies the rule.
+func (r PortRoleRule) matches(addr netip.Addr, vlan int) bool {
+	if r.VLAN != 0 {
+		return vlan == r.VLAN
+	}
+	return r.Prefix.IsValid() && r.Prefix.Contains(addr)
+}
+
+// WithPortRoles adds a role label to every per-port series, classifying ports
+// by the network they are connected to (e.g. storage, compute, management),
+// so shared dashboards can filter by role without per-site variables. A port
+// gets the role of the first rule matching any of its GIDs, or an empty role.
+// Roles are refreshed from the GID tables on every scrape except in degraded
+// mode.
+func WithPortRoles(rules []PortRoleRule, gids GIDTableProvider) Option {
+	return func(c *RdmaCollector) {
+		if len(rules) == 0 || gids == nil {
+			return
+		}
+		c.portRoleRules = rules
+		c.portRoleGIDs = gids
+		c.labels.role = func(device string, port int) string {
+			return c.portRoles[portKey{device: device, port: port}]
+		}
+	}
+}
+
+// updatePortRoles classifies the ports from their GID tables. On error the
+// previous roles are kept, so a failed read does not churn series.
+func (c *RdmaCollector) updatePortRoles(ctx context.Context) {
+	if c.portRoleGIDs == nil {
+		return
+	}
+	entries, err := c.portRoleGIDs.GIDTable(ctx)
+	if err != nil {
+		c.logger.Warn("rdma port role classification failed", "err", err)
+		return
+	}
+
+	// best holds the index of the first matching rule of each port.
+	best := make(map[portKey]int)
+	for _, entry := range entries {
+		addr, err := netip.ParseAddr(entry.GID)
+		if err != nil || addr.IsLinkLocalUnicast() {
+			continue
+		}
+		addr = addr.Unmap()
+		vlan := netDevVLAN(entry.NetDev)
+		key := portKey{device: entry.Device, port: entry.Port}
+		for i, rule := range c.portRoleRules {
+			if current, ok := best[key]; ok && current <= i {
+				break
+			}
+			if rule.matches(addr, vlan) {
+				best[key] = i
+				break
+			}
+		}
+	}
+
+	roles := make(map[portKey]string, len(best))
+	for key, i := range best {
+		roles[key] = c.portRoleRules[i].Role
+	}
+	c.portRoles = roles
+}
+
+// netDevVLAN returns the VLAN ID of a VLAN netdev named <parent>.<vid>, as
+// ip-link and most network managers name them, or 0.
+func netDevVLAN(netDev string) int {
+	dot := strings.LastIndexByte(netDev, '.')
+	if dot < 0 {
+		return 0
+	}
+	vlan, err := strconv.Atoi(netDev[dot+1:])
+	if err != nil || vlan < 1 || vlan > 4094 {
+		return 0
+	}
+	return vlan
+}
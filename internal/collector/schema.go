@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metric schema versions. A new version is introduced for every breaking
+// change to metric names or label sets, and the exporter keeps serving the
+// older versions until users migrate.
+const (
+	// SchemaV1 exports one metric name per counter.
+	SchemaV1 = 1
+	// SchemaV2 folds the unicast/multicast packet counters into
+	// rdma_port_packets_total{direction,cast}.
+	SchemaV2 = 2
+)
+
+// castCounter places a unicast or multicast packet counter in the
+// rdma_port_packets_total series of schema v2.
+type castCounter struct {
+	direction string
+	cast      string
+}
+
+// castCounters maps the documented names of the split packet counters to
+// their schema v2 labels.
+var castCounters = map[string]castCounter{
+	"port_unicast_xmit_packets":   {direction: "tx", cast: "unicast"},
+	"port_multicast_xmit_packets": {direction: "tx", cast: "multicast"},
+	"port_unicast_rcv_packets":    {direction: "rx", cast: "unicast"},
+	"port_multicast_rcv_packets":  {direction: "rx", cast: "multicast"},
+}
+
+// WithSchemaVersion selects the metric schema, SchemaV1 or SchemaV2.
+// Unknown versions are ignored, keeping SchemaV1.
+func WithSchemaVersion(version int) Option {
+	return func(c *RdmaCollector) {
+		if version < SchemaV1 || version > SchemaV2 {
+			return
+		}
+		c.schemaVersion = version
+	}
+}
+
+// castCounter reports how a counter is exported in schema v2. It returns
+// false before schema v2 or when the counter is not split by cast.
+func (c *RdmaCollector) castCounter(stat string) (castCounter, bool) {
+	if c.schemaVersion < SchemaV2 {
+		return castCounter{}, false
+	}
+	cc, ok := castCounters[c.canonicalDocName(stat)]
+	return cc, ok
+}
+
+func (c *RdmaCollector) collectCastCounter(ch chan<- prometheus.Metric, labels *portLabels, cc castCounter, value uint64) {
+	ch <- prometheus.MustNewConstMetric(
+		c.portPacketsDesc,
+		prometheus.CounterValue,
+		float64(value),
+		labels.values(cc.direction, cc.cast)...,
+	)
+}
+
+func (c *RdmaCollector) collectSchemaInfo(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.schemaInfoDesc, prometheus.GaugeValue, 1, strconv.Itoa(c.schemaVersion))
+}
@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// Silence excludes a device from collection until a point in time, e.g. for
+// the duration of a firmware flash.
+type Silence struct {
+	Device string    `json:"device"`
+	Until  time.Time `json:"until"`
+}
+
+// SetSilence silences device until the given time, replacing an earlier
+// silence of the device. A zero or past until lifts the silence.
+func (c *RdmaCollector) SetSilence(device string, until time.Time) {
+	c.silenceMu.Lock()
+	defer c.silenceMu.Unlock()
+
+	if !until.After(c.now()) {
+		delete(c.silences, device)
+		return
+	}
+	if c.silences == nil {
+		c.silences = make(map[string]time.Time)
+	}
+	c.silences[device] = until
+}
+
+// RestoreSilences replaces all silences, e.g. with those saved before a
+// restart. Expired entries are dropped.
+func (c *RdmaCollector) RestoreSilences(silences []Silence) {
+	c.silenceMu.Lock()
+	c.silences = make(map[string]time.Time, len(silences))
+	c.silenceMu.Unlock()
+
+	for _, silence := range silences {
+		c.SetSilence(silence.Device, silence.Until)
+	}
+}
+
+// Silences returns the active silences sorted by device.
+func (c *RdmaCollector) Silences() []Silence {
+	c.silenceMu.Lock()
+	defer c.silenceMu.Unlock()
+
+	now := c.now()
+	out := make([]Silence, 0, len(c.silences))
+	for device, until := range c.silences {
+		if !until.After(now) {
+			delete(c.silences, device)
+			continue
+		}
+		out = append(out, Silence{Device: device, Until: until})
+	}
+	slices.SortFunc(out, func(a, b Silence) int {
+		return strings.Compare(a.Device, b.Device)
+	})
+	return out
+}
+
+// dropSilenced removes silenced devices from a snapshot.
+func (c *RdmaCollector) dropSilenced(devices []rdma.Device) []rdma.Device {
+	silences := c.Silences()
+	if len(silences) == 0 {
+		return devices
+	}
+	return slices.DeleteFunc(slices.Clone(devices), func(device rdma.Device) bool {
+		return slices.ContainsFunc(silences, func(s Silence) bool { return s.Device == device.Name })
+	})
+}
+
+func (c *RdmaCollector) collectSilences(ch chan<- prometheus.Metric) {
+	for _, silence := range c.Silences() {
+		ch <- prometheus.MustNewConstMetric(c.deviceSilencedDesc, prometheus.GaugeValue, 1, silence.Device)
+	}
+}
@@ -0,0 +1,202 @@
+package collector
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// lifespanHwStat is the hw counter in which drivers report, in milliseconds,
+// how long the kernel caches hw counter reads.
+const lifespanHwStat = "lifespan"
+
+// snapshotCache shares one device read between the scrapes that arrive
+// within its lifespan, so several Prometheus servers or an InfluxDB output
+// scraping the same node do not each walk sysfs. It is only used while
+// collectMu is held.
+type snapshotCache struct {
+	lifespan time.Duration
+	devices  []rdma.Device
+	readAt   time.Time
+	// degraded is set when the snapshot was read without hw counters and
+	// attributes; it cannot serve a full scrape.
+	degraded bool
+	// age is the age of the snapshot served by the last scrape.
+	age    time.Duration
+	reuses uint64
+}
+
+// WithSnapshotLifespan makes scrapes within lifespan of the last device read
+// reuse its snapshot instead of reading the provider again. The lifespan is
+// extended to the longest hw counter lifespan reported by the devices, since
+// reads within it return cached values anyway. Rates and idle times are
+// computed at the time of the read, so a reused snapshot adds no samples to
+// them. A non-positive lifespan disables the cache.
+func WithSnapshotLifespan(lifespan time.Duration) Option {
+	return func(c *RdmaCollector) {
+		if lifespan <= 0 {
+			return
+		}
+		c.snapshot = &snapshotCache{lifespan: lifespan}
+		c.snapshotAgeDesc = prometheus.NewDesc(
+			"rdma_exporter_snapshot_age_seconds",
+			"Age of the device snapshot served by this scrape; 0 when it was read for this scrape.",
+			nil,
+			nil,
+		)
+		c.snapshotReusesDesc = prometheus.NewDesc(
+			"rdma_exporter_snapshot_reuses_total",
+			"Number of scrapes served from a device snapshot read by an earlier scrape.",
+			nil,
+			nil,
+		)
+	}
+}
+
+// snapshotDevices returns the device snapshot of the scrape and when it was
+// read, from the cache while it is within its lifespan.
+func (c *RdmaCollector) snapshotDevices(ctx context.Context, degraded bool) ([]rdma.Device, time.Time, error) {
+	now := c.now()
+	s := c.snapshot
+	if s == nil {
+		devices, err := c.readDevices(ctx, degraded)
+		return devices, now, err
+	}
+	if s.devices != nil && (degraded || !s.degraded) && now.Sub(s.readAt) < s.effectiveLifespan() {
+		s.age = now.Sub(s.readAt)
+		s.reuses++
+		return s.devices, s.readAt, nil
+	}
+
+	devices, err := c.readDevices(ctx, degraded)
+	if err != nil {
+		return nil, now, err
+	}
+	if devices == nil {
+		devices = []rdma.Device{}
+	}
+	s.devices = devices
+	s.readAt = now
+	s.degraded = degraded
+	s.age = 0
+	return devices, now, nil
+}
+
+// effectiveLifespan is the configured lifespan, extended to the longest hw
+// counter lifespan of the snapshot.
+func (s *snapshotCache) effectiveLifespan() time.Duration {
+	lifespan := s.lifespan
+	for _, device := range s.devices {
+		for _, port := range device.Ports {
+			if ms, ok := port.HwStats[lifespanHwStat]; ok {
+				lifespan = max(lifespan, time.Duration(ms)*time.Millisecond)
+			}
+		}
+	}
+	return lifespan
+}
+
+func (c *RdmaCollector) collectSnapshot(ch chan<- prometheus.Metric) {
+	if c.snapshot == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.snapshotAgeDesc, prometheus.GaugeValue, c.snapshot.age.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.snapshotReusesDesc, prometheus.CounterValue, float64(c.snapshot.reuses))
+}
+
+// lastSnapshot holds the device read of the last full scrape for the JSON
+// API, so its consumers are served what Prometheus saw instead of adding
+// reads of their own on busy nodes. It has its own locks so API requests do
+// not wait for a scrape in progress.
+type lastSnapshot struct {
+	// refreshMu serializes the reads of API callers that find the snapshot
+	// too old, so concurrent requests share one read.
+	refreshMu sync.Mutex
+
+	mu      sync.Mutex
+	devices []rdma.Device
+	readAt  time.Time
+}
+
+func (s *lastSnapshot) store(devices []rdma.Device, readAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if readAt.Before(s.readAt) {
+		return
+	}
+	s.devices = devices
+	s.readAt = readAt
+}
+
+// load returns a copy of the snapshot unless there is none or it is older
+// than maxAge.
+func (s *lastSnapshot) load(now time.Time, maxAge time.Duration) ([]rdma.Device, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.devices == nil || now.Sub(s.readAt) > maxAge {
+		return nil, time.Time{}, false
+	}
+	return cloneDevices(s.devices), s.readAt, true
+}
+
+// DeviceSnapshot returns a copy of the devices read by the last full scrape
+// and when they were read. When no scrape read them within maxAge, e.g.
+// before the first scrape or while nothing scrapes the exporter, they are
+// read now and kept for later callers; a non-positive maxAge reads them on
+// every call. Silenced devices are left out as in Devices.
+func (c *RdmaCollector) DeviceSnapshot(ctx context.Context, maxAge time.Duration) ([]rdma.Device, time.Time, error) {
+	if maxAge > 0 {
+		if devices, readAt, ok := c.last.load(c.now(), maxAge); ok {
+			return devices, readAt, nil
+		}
+	}
+
+	c.last.refreshMu.Lock()
+	defer c.last.refreshMu.Unlock()
+	if maxAge > 0 {
+		// Another caller may have read them while this one waited.
+		if devices, readAt, ok := c.last.load(c.now(), maxAge); ok {
+			return devices, readAt, nil
+		}
+	}
+	readAt := c.now()
+	devices, err := c.Devices(ctx)
+	if err != nil {
+		return nil, readAt, err
+	}
+	if devices == nil {
+		devices = []rdma.Device{}
+	}
+	c.last.store(devices, readAt)
+	return cloneDevices(devices), readAt, nil
+}
+
+// cloneDevices copies the devices deep enough that callers may modify their
+// ports and counters.
+func cloneDevices(devices []rdma.Device) []rdma.Device {
+	clone := make([]rdma.Device, len(devices))
+	for i, device := range devices {
+		device.HwStats = maps.Clone(device.HwStats)
+		ports := make([]rdma.Port, len(device.Ports))
+		for j, port := range device.Ports {
+			port.Stats = maps.Clone(port.Stats)
+			port.HwStats = maps.Clone(port.HwStats)
+			if port.VLStats != nil {
+				vlStats := make(map[int]map[string]uint64, len(port.VLStats))
+				for vl, stats := range port.VLStats {
+					vlStats[vl] = maps.Clone(stats)
+				}
+				port.VLStats = vlStats
+			}
+			ports[j] = port
+		}
+		device.Ports = ports
+		clone[i] = device
+	}
+	return clone
+}
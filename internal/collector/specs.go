@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CounterSpec gives a counter or hw_counter the exporter does not know a
+// canonical name and help text, like the built-in metricSpecs.
+type CounterSpec struct {
+	// Name is the canonical name the metric is named after, e.g.
+	// rdma_<name>_total. Empty keeps the counter's own name.
+	Name string `yaml:"name"`
+	Help string `yaml:"help"`
+}
+
+type counterSpecFile struct {
+	Counters map[string]CounterSpec `yaml:"counters"`
+}
+
+// LoadCounterSpecs reads counter specs from a YAML file of the form
+//
+//	counters:
+//	  <counter>:
+//	    name: <canonical name>
+//	    help: <help text>
+//
+// keyed by the file name of the counter in sysfs.
+func LoadCounterSpecs(path string) (map[string]CounterSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read counter specs %s: %w", path, err)
+	}
+
+	var file counterSpecFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse counter specs %s: %w", path, err)
+	}
+	for counter, spec := range file.Counters {
+		if counter == "" {
+			return nil, fmt.Errorf("parse counter specs %s: empty counter name", path)
+		}
+		if spec.Name == "" && spec.Help == "" {
+			return nil, fmt.Errorf("parse counter specs %s: counter %q has neither name nor help", path, counter)
+		}
+	}
+	return file.Counters, nil
+}
+
+// WithCounterSpecs adds counter specs to the built-in ones, so new vendor
+// counters get a proper name and help text without rebuilding the exporter.
+// They take precedence over the built-in specs of the same counter.
+func WithCounterSpecs(specs map[string]CounterSpec) Option {
+	return func(c *RdmaCollector) {
+		if len(specs) == 0 {
+			return
+		}
+		c.counterSpecs = make(map[string]string, len(specs))
+		c.counterHelp = make(map[string]string, len(specs))
+		for counter, spec := range specs {
+			docName := spec.Name
+			if docName == "" {
+				docName = sanitizeStatName(counter)
+			}
+			c.counterSpecs[counter] = docName
+			if spec.Help != "" {
+				c.counterHelp[docName] = spec.Help
+			}
+		}
+	}
+}
+
+// canonicalDocName returns the canonical name of a counter from the counter
+// specs or the built-in metricSpecs.
+func (c *RdmaCollector) canonicalDocName(stat string) string {
+	if docName, ok := c.counterSpecs[stat]; ok {
+		return docName
+	}
+	return canonicalDocName(stat)
+}
+
+// metricDocHelp returns the help text of a canonical name from the counter
+// specs, the help pack of driver or the built-in metricSpecs, and whether
+// there is one.
+func (c *RdmaCollector) metricDocHelp(docName, driver string) (string, bool) {
+	if help, ok := c.counterHelp[docName]; ok {
+		return help, true
+	}
+	if help, ok := driverDocHelp(driver, docName); ok {
+		return help, true
+	}
+	help, ok := metricHelpByDocName[docName]
+	return help, ok
+}
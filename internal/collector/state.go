@@ -0,0 +1,146 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+const (
+	xmitDataStat    = "port_xmit_data"
+	rcvDataStat     = "port_rcv_data"
+	xmitPacketsStat = "port_xmit_packets"
+)
+
+// retransmitHwStats are the hw_counters whose increments each cause the
+// requester to retransmit: NAK sequence errors (explicit and implied) and
+// local ACK timeouts.
+var retransmitHwStats = []string{
+	"packet_seq_err",
+	"implied_nak_seq_err",
+	"local_ack_timeout_err",
+}
+
+type portKey struct {
+	device string
+	port   int
+}
+
+// portState holds the values remembered between scrapes for a single port.
+type portState struct {
+	xmitData   uint64
+	rcvData    uint64
+	lastChange time.Time
+	generation uint64
+
+	// xmitPackets and retransmits are the previous raw values used to derive
+	// the retransmit ratio; hasRetransmits is false when the port exposes none
+	// of retransmitHwStats.
+	xmitPackets    uint64
+	retransmits    uint64
+	hasRetransmits bool
+	// retransmitRatio is the ratio over the last scrape window and is valid
+	// only when retransmitRatioOK is set.
+	retransmitRatio   float64
+	retransmitRatioOK bool
+	// observedAt is the read time of the last observed snapshot.
+	observedAt time.Time
+
+	recovery recoveryBurst
+}
+
+// stateTracker remembers per-port observations across scrapes in stateful
+// mode. It is only accessed while collectMu is held.
+type stateTracker struct {
+	generation uint64
+	ports      map[portKey]*portState
+	// recoveryBursts defines link error recovery bursts; warming is set
+	// while the current scrape is within the warm-up window.
+	recoveryBursts recoveryBurstPolicy
+	warming        bool
+}
+
+func newStateTracker() *stateTracker {
+	return &stateTracker{ports: make(map[portKey]*portState)}
+}
+
+// begin starts a new scrape generation.
+func (t *stateTracker) begin(warming bool) {
+	t.generation++
+	t.warming = warming
+}
+
+// observe records the port's data counters and returns its state. A port seen
+// for the first time is considered to have changed at now.
+func (t *stateTracker) observe(device string, port rdma.Port, now time.Time) *portState {
+	key := portKey{device: device, port: port.ID}
+	xmit := port.Stats[xmitDataStat]
+	rcv := port.Stats[rcvDataStat]
+
+	xmitPackets := port.Stats[xmitPacketsStat]
+	retransmits, hasRetransmits := sumRetransmits(port.HwStats)
+
+	state, ok := t.ports[key]
+	if !ok {
+		state = &portState{xmitData: xmit, rcvData: rcv, lastChange: now}
+		t.ports[key] = state
+	} else if !now.After(state.observedAt) {
+		// A reused snapshot was already observed; keep its ratio.
+		state.generation = t.generation
+		return state
+	} else {
+		if state.xmitData != xmit || state.rcvData != rcv {
+			state.xmitData = xmit
+			state.rcvData = rcv
+			state.lastChange = now
+		}
+		state.retransmitRatio, state.retransmitRatioOK = retransmitRatio(
+			state.xmitPackets, xmitPackets,
+			state.retransmits, retransmits,
+			state.hasRetransmits && hasRetransmits,
+		)
+	}
+	if recoveries, ok := port.Stats[linkErrorRecoveryStat]; ok && t.recoveryBursts.threshold > 0 {
+		state.recovery.observe(t.recoveryBursts, recoveries, now, t.warming)
+	}
+	state.xmitPackets = xmitPackets
+	state.retransmits = retransmits
+	state.hasRetransmits = hasRetransmits
+	state.observedAt = now
+	state.generation = t.generation
+	return state
+}
+
+func sumRetransmits(hwStats map[string]uint64) (uint64, bool) {
+	var (
+		total uint64
+		found bool
+	)
+	for _, name := range retransmitHwStats {
+		if v, ok := hwStats[name]; ok {
+			total += v
+			found = true
+		}
+	}
+	return total, found
+}
+
+// retransmitRatio derives retransmits per transmitted packet between two
+// observations. Windows with counter resets or no transmitted packets yield
+// no value rather than a misleading spike or zero.
+func retransmitRatio(prevXmit, xmit, prevRetrans, retrans uint64, ok bool) (float64, bool) {
+	if !ok || xmit <= prevXmit || retrans < prevRetrans {
+		return 0, false
+	}
+	return float64(retrans-prevRetrans) / float64(xmit-prevXmit), true
+}
+
+// prune forgets ports that were not observed in the current generation so
+// removed devices do not leak state.
+func (t *stateTracker) prune() {
+	for key, state := range t.ports {
+		if state.generation != t.generation {
+			delete(t.ports, key)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package collector
+
+// hwCounterKeyPrefix keeps hw_counters distinct from counters of the same name.
+const hwCounterKeyPrefix = "hw:"
+
+type counterKey struct {
+	device  string
+	port    int
+	counter string
+}
+
+type counterState struct {
+	value      uint64
+	unchanged  int
+	generation uint64
+}
+
+// suppressTracker decides which counter series to omit because their value
+// has not changed for a number of scrapes. It is only accessed while
+// collectMu is held.
+type suppressTracker struct {
+	// after is the number of consecutive unchanged scrapes after which a
+	// series is suppressed.
+	after int
+	// keepAlive re-emits a suppressed series every keepAlive scrapes; 0
+	// disables keep-alives.
+	keepAlive  int
+	generation uint64
+	counters   map[counterKey]*counterState
+}
+
+func newSuppressTracker(after, keepAlive int) *suppressTracker {
+	return &suppressTracker{
+		after:     after,
+		keepAlive: keepAlive,
+		counters:  make(map[counterKey]*counterState),
+	}
+}
+
+// begin starts a new scrape generation.
+func (t *suppressTracker) begin() {
+	t.generation++
+}
+
+// emit records value for the counter and reports whether its series should be
+// exported in this scrape.
+func (t *suppressTracker) emit(device string, port int, counter string, value uint64) bool {
+	key := counterKey{device: device, port: port, counter: counter}
+	state, ok := t.counters[key]
+	if !ok {
+		t.counters[key] = &counterState{value: value, generation: t.generation}
+		return true
+	}
+	state.generation = t.generation
+
+	if state.value != value {
+		state.value = value
+		state.unchanged = 0
+		return true
+	}
+	state.unchanged++
+
+	if state.unchanged < t.after {
+		return true
+	}
+	// The last emission happened at unchanged == after-1, so a keep-alive is
+	// due every keepAlive scrapes from there.
+	return t.keepAlive > 0 && (state.unchanged-t.after+1)%t.keepAlive == 0
+}
+
+// prune forgets counters that were not observed in the current generation.
+func (t *suppressTracker) prune() {
+	for key, state := range t.counters {
+		if state.generation != t.generation {
+			delete(t.counters, key)
+		}
+	}
+}
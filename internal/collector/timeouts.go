@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TimeoutCollectors lists the collectors WithCollectorTimeouts can bound,
+// each of which takes a --collect.<collector>.timeout flag. "counters" is
+// the device read every scrape depends on; when it times out the scrape
+// serves the devices of the last full scrape.
+var TimeoutCollectors = []string{
+	"counters",
+	"roce_pfc",
+	"netdev_link",
+	"netdev_statistics",
+	"netdev_ethtool",
+	"dcb",
+	"vport",
+	"ipv6_flowlabel",
+	"resources",
+	"device_limits",
+	"resources_by_process",
+	"qp_counters",
+	"gid_table",
+	"pkey_table",
+	"roce_config",
+}
+
+// WithCollectorTimeouts bounds how long individual collectors may take per
+// scrape, so one slow subsystem is cut off while the others complete. The
+// timeout of a collector that reads every port (roce_pfc, netdev_link,
+// netdev_statistics, netdev_ethtool, dcb) covers all its reads of the
+// scrape. Collectors without a timeout only stop at the scrape timeout. Unknown collectors and
+// non-positive timeouts are ignored.
+func WithCollectorTimeouts(timeouts map[string]time.Duration) Option {
+	return func(c *RdmaCollector) {
+		for name, timeout := range timeouts {
+			if timeout <= 0 || !slices.Contains(TimeoutCollectors, name) {
+				continue
+			}
+			if c.collectorTimeouts == nil {
+				c.collectorTimeouts = make(map[string]time.Duration)
+				c.collectorTimeoutCounts = make(map[string]uint64)
+				c.collectorTimeoutsDesc = prometheus.NewDesc(
+					"rdma_exporter_collector_timeouts_total",
+					"Number of scrapes in which a collector was cut off by its --collect.<collector>.timeout.",
+					[]string{"collector"},
+					nil,
+				)
+			}
+			c.collectorTimeouts[name] = timeout
+			c.collectorTimeoutCounts[name] = 0
+		}
+	}
+}
+
+// withCollectorTimeout derives the context of a collector from the scrape
+// context. The returned function must be called once the collector is done;
+// it counts the collector's own timeouts, not those of the scrape. It is only
+// called while collectMu is held.
+func (c *RdmaCollector) withCollectorTimeout(ctx context.Context, name string) (context.Context, func()) {
+	timeout, ok := c.collectorTimeouts[name]
+	if !ok {
+		return ctx, func() {}
+	}
+	sub, cancel := context.WithTimeout(ctx, timeout)
+	return sub, func() {
+		if errors.Is(sub.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			c.collectorTimeoutCounts[name]++
+			c.logger.Warn("collector timed out", "collector", name, "timeout", timeout)
+		}
+		cancel()
+	}
+}
+
+func (c *RdmaCollector) collectCollectorTimeouts(ch chan<- prometheus.Metric) {
+	if c.collectorTimeoutsDesc == nil {
+		return
+	}
+	for _, name := range sortedKeys(c.collectorTimeoutCounts) {
+		ch <- prometheus.MustNewConstMetric(c.collectorTimeoutsDesc, prometheus.CounterValue,
+			float64(c.collectorTimeoutCounts[name]), name)
+	}
+}
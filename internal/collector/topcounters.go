@@ -0,0 +1,212 @@
+package collector
+
+import (
+	"cmp"
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+type topCounterSample struct {
+	at    time.Time
+	value uint64
+}
+
+type topCounterState struct {
+	// samples are the reads of the window, oldest first, like the samples
+	// of utilizationState.
+	samples    []topCounterSample
+	generation uint64
+}
+
+// topCounter is the increase of a counter over the window.
+type topCounter struct {
+	key      counterKey
+	increase uint64
+}
+
+// compareTopCounters orders counters by increase, largest first, ties broken
+// by name so the top counters do not depend on the order of the reads.
+func compareTopCounters(a, b topCounter) int {
+	if c := cmp.Compare(b.increase, a.increase); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.key.device, b.key.device); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.key.port, b.key.port); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.key.counter, b.key.counter)
+}
+
+// topCounterHeap is a min-heap of the top counters seen so far, so its root
+// is the one with the smallest increase, the one to replace.
+type topCounterHeap []topCounter
+
+func (h topCounterHeap) Len() int           { return len(h) }
+func (h topCounterHeap) Less(i, j int) bool { return compareTopCounters(h[i], h[j]) > 0 }
+func (h topCounterHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *topCounterHeap) Push(x any) {
+	*h = append(*h, x.(topCounter))
+}
+
+func (h *topCounterHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// topCounterTracker finds the counters that increased the most over the
+// window. It is only accessed while collectMu is held.
+type topCounterTracker struct {
+	n          int
+	window     time.Duration
+	generation uint64
+	states     map[counterKey]*topCounterState
+	top        topCounterHeap
+	desc       *prometheus.Desc
+}
+
+func newTopCounterTracker(n int, window time.Duration) *topCounterTracker {
+	return &topCounterTracker{
+		n:      n,
+		window: window,
+		states: make(map[counterKey]*topCounterState),
+		top:    make(topCounterHeap, 0, n),
+		desc: prometheus.NewDesc(
+			"rdma_exporter_top_counter_increase",
+			"Increase over the top counters window of the counters and hw_counters that increased the most, identified by the FNV-1a hash of the counter name. Only the top counters are exported, so the number of series is bounded.",
+			[]string{"device", "port", "counter_hash"},
+			nil,
+		),
+	}
+}
+
+// begin starts a new scrape generation.
+func (t *topCounterTracker) begin() {
+	t.generation++
+	t.top = t.top[:0]
+}
+
+// observe records a counter read at now and offers its increase over the
+// window to the top counters. A decreasing counter or read time is a reset
+// and restarts the window; a snapshot read at the same time as the last one
+// was reused and keeps it.
+func (t *topCounterTracker) observe(key counterKey, now time.Time, value uint64) {
+	state, ok := t.states[key]
+	if !ok {
+		state = &topCounterState{}
+		t.states[key] = state
+	}
+	state.generation = t.generation
+
+	if n := len(state.samples); n > 0 && now.Equal(state.samples[n-1].at) {
+		// A reused snapshot was already recorded; rank the same window.
+		value = state.samples[n-1].value
+	} else {
+		if n > 0 {
+			last := state.samples[n-1]
+			if value < last.value || now.Before(last.at) {
+				state.samples = state.samples[:0]
+			}
+		}
+		state.samples = append(state.samples, topCounterSample{at: now, value: value})
+
+		start := now.Add(-t.window)
+		drop := 0
+		for drop+2 < len(state.samples) && !state.samples[drop+1].at.After(start) {
+			drop++
+		}
+		if drop > 0 {
+			state.samples = append(state.samples[:0], state.samples[drop:]...)
+		}
+	}
+	if len(state.samples) < 2 {
+		return
+	}
+
+	increase := value - state.samples[0].value
+	if increase == 0 {
+		return
+	}
+	candidate := topCounter{key: key, increase: increase}
+	if len(t.top) < t.n {
+		heap.Push(&t.top, candidate)
+		return
+	}
+	if compareTopCounters(candidate, t.top[0]) < 0 {
+		t.top[0] = candidate
+		heap.Fix(&t.top, 0)
+	}
+}
+
+// observePort records the counters and hw_counters of a port.
+func (t *topCounterTracker) observePort(device string, port rdma.Port, now time.Time) {
+	for name, value := range port.Stats {
+		t.observe(counterKey{device: device, port: port.ID, counter: name}, now, value)
+	}
+	for name, value := range port.HwStats {
+		t.observe(counterKey{device: device, port: port.ID, counter: hwCounterKeyPrefix + name}, now, value)
+	}
+}
+
+// prune forgets counters that were not observed in the current generation.
+func (t *topCounterTracker) prune() {
+	for key, state := range t.states {
+		if state.generation != t.generation {
+			delete(t.states, key)
+		}
+	}
+}
+
+// counterHash returns the FNV-1a hash of a counter name in hex, the value of
+// the counter_hash label.
+func counterHash(name string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// WithTopCounters exports rdma_exporter_top_counter_increase, the n counters
+// and hw_counters of all ports that increased the most over the window. It
+// is a debugging aid for finding the error counter that explodes on a
+// misbehaving node without loading every series into a dashboard. A
+// non-positive n or window disables it.
+func WithTopCounters(n int, window time.Duration) Option {
+	return func(c *RdmaCollector) {
+		if n <= 0 || window <= 0 {
+			return
+		}
+		c.topCounters = newTopCounterTracker(n, window)
+	}
+}
+
+// collectTopCounters exports the top counters of the scrape. Their names are
+// hashed, hw_counters as hw_counters/<name> since they share names with
+// counters, so the label takes one short value per counter. The set is
+// exported without a rank, so a series keeps its identity while the order
+// changes. The samples are recorded during the warm-up window but not
+// exported.
+func (c *RdmaCollector) collectTopCounters(ch chan<- prometheus.Metric, warming bool) {
+	if warming {
+		return
+	}
+	for _, top := range c.topCounters.top {
+		counter := top.key.counter
+		if name, ok := strings.CutPrefix(counter, hwCounterKeyPrefix); ok {
+			counter = "hw_counters/" + name
+		}
+		ch <- prometheus.MustNewConstMetric(c.topCounters.desc, prometheus.GaugeValue, float64(top.increase),
+			top.key.device, strconv.Itoa(top.key.port), counterHash(counter))
+	}
+}
@@ -0,0 +1,41 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// UEventProvider counts the kernel uevents of RDMA devices.
+type UEventProvider interface {
+	UEventCounts() []rdma.UEventCount
+}
+
+// WithUEvents exports rdma_device_uevents_total, the kernel uevents of every
+// RDMA device by action. A driver reload or firmware reset removes and adds
+// the device again, which otherwise only shows up as a gap in its series.
+func WithUEvents(provider UEventProvider) Option {
+	return func(c *RdmaCollector) {
+		if provider == nil {
+			return
+		}
+		c.ueventProvider = provider
+		c.deviceUEventsDesc = prometheus.NewDesc(
+			"rdma_device_uevents_total",
+			"Number of kernel uevents of an RDMA device since the exporter started, by action: add and remove when a driver registers and unregisters the device, e.g. around a driver reload or firmware reset, change and move on renames.",
+			[]string{"device", "action"},
+			nil,
+		)
+	}
+}
+
+// collectUEvents exports the uevent counts. They are exported even when
+// reading the devices fails, as that is when they matter most.
+func (c *RdmaCollector) collectUEvents(ch chan<- prometheus.Metric) {
+	if c.ueventProvider == nil {
+		return
+	}
+	for _, count := range c.ueventProvider.UEventCounts() {
+		ch <- prometheus.MustNewConstMetric(c.deviceUEventsDesc, prometheus.CounterValue, float64(count.Count), count.Device, count.Action)
+	}
+}
@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+const counterUnitTicks = "ticks"
+
+// tickCounters holds the sysfs names of the counters counted in ticks.
+var tickCounters = buildTickCounters()
+
+func buildTickCounters() map[string]struct{} {
+	ticks := make(map[string]struct{})
+	for name, spec := range metricSpecs {
+		if spec.Unit == counterUnitTicks {
+			ticks[name] = struct{}{}
+		}
+	}
+	return ticks
+}
+
+// unitCounters lists (counter, unit) pairs of metricSpecs with a unit, sorted
+// by counter name.
+var unitCounters = buildUnitCounters()
+
+type counterUnit struct {
+	counter string
+	unit    string
+}
+
+func buildUnitCounters() []counterUnit {
+	var units []counterUnit
+	for _, spec := range metricSpecs {
+		if spec.Unit != "" {
+			units = append(units, counterUnit{counter: spec.DocName, unit: spec.Unit})
+		}
+	}
+	slices.SortFunc(units, func(a, b counterUnit) int {
+		if a.counter < b.counter {
+			return -1
+		}
+		if a.counter > b.counter {
+			return 1
+		}
+		return 0
+	})
+	return units
+}
+
+// WithTickDuration sets the tick length used for tick-based counters such as
+// port_xmit_wait on ports whose provider does not report one.
+func WithTickDuration(d time.Duration) Option {
+	return func(c *RdmaCollector) {
+		if d > 0 {
+			c.tickDuration = d
+		}
+	}
+}
+
+func (c *RdmaCollector) collectCounterUnits(ch chan<- prometheus.Metric) {
+	for _, u := range unitCounters {
+		ch <- prometheus.MustNewConstMetric(c.counterUnitDesc, prometheus.GaugeValue, 1, u.counter, u.unit)
+	}
+}
+
+// collectTickDuration exports the port's tick length so consumers can convert
+// tick counters to seconds. The provider's tick length takes precedence over
+// the one set with WithTickDuration. Ports without a known tick length or
+// without tick counters, such as EFA ports, are skipped.
+func (c *RdmaCollector) collectTickDuration(ch chan<- prometheus.Metric, labels *portLabels, port rdma.Port) {
+	if !hasTickCounter(port) {
+		return
+	}
+	tick := port.TickDuration
+	if tick <= 0 {
+		tick = c.tickDuration
+	}
+	if tick <= 0 {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.portTickDurationDesc,
+		prometheus.GaugeValue,
+		tick.Seconds(),
+		labels.values()...,
+	)
+}
+
+func hasTickCounter(port rdma.Port) bool {
+	for name := range tickCounters {
+		if _, ok := port.Stats[name]; ok {
+			return true
+		}
+		if _, ok := port.HwStats[name]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+type utilizationSample struct {
+	at   time.Time
+	xmit uint64
+	rcv  uint64
+}
+
+type utilizationState struct {
+	// samples are the reads of the window, oldest first; the oldest one
+	// is at or before the start of the window when reads go back that far.
+	samples    []utilizationSample
+	generation uint64
+}
+
+// utilizationTracker keeps the data counters of every port over the rate
+// window. It is only accessed while collectMu is held.
+type utilizationTracker struct {
+	window     time.Duration
+	generation uint64
+	states     map[portKey]*utilizationState
+}
+
+func newUtilizationTracker(window time.Duration) *utilizationTracker {
+	return &utilizationTracker{
+		window: window,
+		states: make(map[portKey]*utilizationState),
+	}
+}
+
+// begin starts a new scrape generation.
+func (t *utilizationTracker) begin() {
+	t.generation++
+}
+
+// observe records the data counters of a port read at now and returns the
+// sample the rates are computed from, or false when there is none yet. A
+// decreasing counter or read time is a reset and restarts the window; a
+// snapshot read at the same time as the last one was reused and keeps it.
+func (t *utilizationTracker) observe(device string, port int, sample utilizationSample) (utilizationSample, bool) {
+	key := portKey{device: device, port: port}
+	state, ok := t.states[key]
+	if !ok {
+		state = &utilizationState{}
+		t.states[key] = state
+	}
+	state.generation = t.generation
+
+	if n := len(state.samples); n > 0 {
+		last := state.samples[n-1]
+		if sample.at.Equal(last.at) {
+			// A reused snapshot was already recorded; keep its window.
+			if n < 2 {
+				return utilizationSample{}, false
+			}
+			return state.samples[0], true
+		}
+		if sample.xmit < last.xmit || sample.rcv < last.rcv || sample.at.Before(last.at) {
+			state.samples = state.samples[:0]
+		}
+	}
+	state.samples = append(state.samples, sample)
+
+	start := sample.at.Add(-t.window)
+	drop := 0
+	for drop+2 < len(state.samples) && !state.samples[drop+1].at.After(start) {
+		drop++
+	}
+	if drop > 0 {
+		state.samples = append(state.samples[:0], state.samples[drop:]...)
+	}
+	if len(state.samples) < 2 {
+		return utilizationSample{}, false
+	}
+	return state.samples[0], true
+}
+
+// prune forgets ports that were not observed in the current generation.
+func (t *utilizationTracker) prune() {
+	for key, state := range t.states {
+		if state.generation != t.generation {
+			delete(t.states, key)
+		}
+	}
+}
+
+// WithUtilization exports rdma_port_utilization_ratio, the share of the link
+// rate the port transmitted and received over the last window, for users who
+// cannot add the PromQL to their dashboards. It is computed from
+// port_xmit_data and port_rcv_data and the rate the port reports. Scrapes
+// further apart than the window are rated over the last interval. A
+// non-positive window disables it.
+func WithUtilization(window time.Duration) Option {
+	return func(c *RdmaCollector) {
+		if window <= 0 {
+			return
+		}
+		c.utilization = newUtilizationTracker(window)
+	}
+}
+
+// collectUtilization exports the utilization of a port in both directions.
+// The samples are recorded during the warm-up window but not exported.
+func (c *RdmaCollector) collectUtilization(ch chan<- prometheus.Metric, labels *portLabels, device string, port rdma.Port, now time.Time, warming bool) {
+	xmit, ok := port.Stats["port_xmit_data"]
+	if !ok {
+		return
+	}
+	rcv, ok := port.Stats["port_rcv_data"]
+	if !ok {
+		return
+	}
+	sample := utilizationSample{at: now, xmit: xmit, rcv: rcv}
+	base, ok := c.utilization.observe(device, port.ID, sample)
+	if !ok || warming {
+		return
+	}
+	bps, ok := rdma.LinkRateBps(port.Attributes.LinkSpeed)
+	if !ok {
+		return
+	}
+
+	// capacity is the number of 4-octet words, the unit of the data
+	// counters, the link carries between the samples.
+	capacity := bps * now.Sub(base.at).Seconds() / 32
+	ch <- prometheus.MustNewConstMetric(c.portUtilizationDesc, prometheus.GaugeValue,
+		float64(xmit-base.xmit)/capacity, labels.values("tx")...)
+	ch <- prometheus.MustNewConstMetric(c.portUtilizationDesc, prometheus.GaugeValue,
+		float64(rcv-base.rcv)/capacity, labels.values("rx")...)
+}
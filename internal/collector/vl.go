@@ -0,0 +1,36 @@
+package collector
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// collectVLStats exports the per virtual lane hw counters of a port with a vl
+// label.
+func (c *RdmaCollector) collectVLStats(ch chan<- prometheus.Metric, labels *portLabels, device rdma.Device, port rdma.Port) {
+	vls := make([]int, 0, len(port.VLStats))
+	for vl := range port.VLStats {
+		vls = append(vls, vl)
+	}
+	slices.Sort(vls)
+
+	for _, vl := range vls {
+		stats := port.VLStats[vl]
+		vlLabel := strconv.Itoa(vl)
+		for _, name := range sortedKeys(stats) {
+			if c.suppress != nil && !c.suppress.emit(device.Name, port.ID, hwCounterKeyPrefix+"vl"+vlLabel+":"+name, stats[name]) {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.vlHwMetricDesc(name, device.Attributes.Driver),
+				c.valueType(name),
+				float64(stats[name]),
+				labels.values(vlLabel)...,
+			)
+		}
+	}
+}
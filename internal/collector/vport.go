@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+const vportStatMarker = "vport_"
+
+// RepresentorProvider lists switchdev VF representor netdevs.
+type RepresentorProvider interface {
+	Representors(ctx context.Context) ([]rdma.Representor, error)
+}
+
+// WithVPortMetrics exports the vport counters of switchdev VF representors as
+// rdma_vport_* series, attributed to the PF device and VF index. In switchdev
+// mode the representors, not the VF netdevs, carry the per-VF traffic seen by
+// the eswitch, which matters for OVS-offloaded RoCE.
+func WithVPortMetrics(representors RepresentorProvider, stats NetDevStatsProvider) Option {
+	return func(c *RdmaCollector) {
+		if representors == nil || stats == nil {
+			return
+		}
+		c.representorProvider = representors
+		c.vportStatsProvider = stats
+		c.vportMetrics = make(map[string]*prometheus.Desc)
+	}
+}
+
+// vportMetricName maps an ethtool stat of a representor to its metric name,
+// e.g. vport_rx_packets → rdma_vport_rx_packets_total and
+// rx_vport_rdma_unicast_packets → rdma_vport_rx_rdma_unicast_packets_total.
+// Stats that are not vport counters are skipped.
+func vportMetricName(stat string) (string, bool) {
+	if !strings.Contains(stat, vportStatMarker) {
+		return "", false
+	}
+	return "rdma_vport_" + sanitizeStatName(strings.Replace(stat, vportStatMarker, "", 1)) + "_total", true
+}
+
+// vportDesc returns the descriptor of a vport stat. It is only called while
+// collectMu is held.
+func (c *RdmaCollector) vportDesc(stat string) (*prometheus.Desc, bool) {
+	if desc, ok := c.vportMetrics[stat]; ok {
+		return desc, desc != nil
+	}
+	name, ok := vportMetricName(stat)
+	if !ok {
+		// Remember non-vport stats too, so they are not re-parsed every scrape.
+		c.vportMetrics[stat] = nil
+		return nil, false
+	}
+	desc := prometheus.NewDesc(
+		name,
+		"VF vport counter "+stat+" from the ethtool stats of the switchdev representor.",
+		[]string{"device", "pf", "vf", "netdev"},
+		nil,
+	)
+	c.vportMetrics[stat] = desc
+	c.addDynamicDesc(desc)
+	return desc, true
+}
+
+func (c *RdmaCollector) collectVPortMetrics(ctx context.Context, ch chan<- prometheus.Metric) {
+	if c.representorProvider == nil {
+		return
+	}
+
+	reps, err := c.representorProvider.Representors(ctx)
+	if err != nil {
+		c.logger.Warn("representor discovery failed", "err", err)
+		return
+	}
+
+	for _, rep := range reps {
+		stats, err := c.vportStatsProvider.Stats(ctx, rep.NetDev)
+		if err != nil {
+			if ctx.Err() != nil {
+				c.logger.Warn("vport scrape aborted by context", "device", rep.PFDevice, "netdev", rep.NetDev, "err", ctx.Err())
+				return
+			}
+			c.logger.Warn("vport scrape failed", "device", rep.PFDevice, "netdev", rep.NetDev, "err", err)
+			continue
+		}
+
+		pf := strconv.Itoa(rep.PF)
+		vf := strconv.Itoa(rep.VF)
+		for _, stat := range sortedKeys(stats) {
+			desc, ok := c.vportDesc(stat)
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(stats[stat]), rep.PFDevice, pf, vf, rep.NetDev)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WithWarmup withholds the metrics derived from earlier scrapes
+// (rdma_port_idle_seconds, rdma_port_retransmit_ratio, the link recovery
+// burst metrics, rdma_port_counter_rate, rdma_port_utilization_ratio and
+// rdma_netdev_link_settings_changes_total) for the given time after the
+// collector is created. Drivers settling after boot renegotiate links and
+// reset counters, which would otherwise fire alerts. Port state keeps being
+// tracked, so the metrics are accurate as soon as the window ends; link
+// recoveries during the window only move the baseline. A non-positive
+// duration disables the window.
+func WithWarmup(d time.Duration) Option {
+	return func(c *RdmaCollector) {
+		if d <= 0 {
+			return
+		}
+		c.warmup = d
+		c.warmupDesc = prometheus.NewDesc(
+			"rdma_exporter_warming_up",
+			"Whether the exporter is within its warm-up window after startup (1), during which metrics derived from earlier scrapes are withheld, or not (0).",
+			nil,
+			nil,
+		)
+	}
+}
+
+// warmingUp reports whether now falls within the warm-up window.
+func (c *RdmaCollector) warmingUp(now time.Time) bool {
+	return c.warmup > 0 && now.Sub(c.startTime) < c.warmup
+}
+
+func (c *RdmaCollector) collectWarmup(ch chan<- prometheus.Metric, warming bool) {
+	if c.warmupDesc == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.warmupDesc, prometheus.GaugeValue, boolToFloat(warming))
+}
@@ -0,0 +1,43 @@
+package collector
+
+import (
+	"maps"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WarningUnknownCounter counts counters without documentation in the
+// metric specs, exported with a generic help text. Each name is counted once.
+const WarningUnknownCounter = "unknown_counter"
+
+// WarningsProvider is implemented by providers that count non-fatal
+// anomalies by type, such as rdma.SysfsProvider.
+type WarningsProvider interface {
+	Warnings() map[string]uint64
+}
+
+// addWarning counts a warning raised by the collector itself. It is only
+// called while collectMu is held.
+func (c *RdmaCollector) addWarning(typ string) {
+	if c.warnings == nil {
+		c.warnings = make(map[string]uint64)
+	}
+	c.warnings[typ]++
+}
+
+// collectWarnings exports the warnings of the collector and the provider, so
+// affected nodes can be found without searching logs.
+func (c *RdmaCollector) collectWarnings(ch chan<- prometheus.Metric) {
+	counts := maps.Clone(c.warnings)
+	if provider, ok := c.provider.(WarningsProvider); ok {
+		if counts == nil {
+			counts = make(map[string]uint64)
+		}
+		for typ, n := range provider.Warnings() {
+			counts[typ] += n
+		}
+	}
+	for _, typ := range sortedKeys(counts) {
+		ch <- prometheus.MustNewConstMetric(c.warningsDesc, prometheus.CounterValue, float64(counts[typ]), typ)
+	}
+}
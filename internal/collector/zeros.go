@@ -0,0 +1,67 @@
+package collector
+
+import "github.com/yuuki/rdma_exporter/internal/rdma"
+
+// ibPortCounters are the documented counters of the InfiniBand PortCounters
+// attribute, which every port with a counters directory has regardless of
+// its driver. The other documented counters are mlx5 hw_counters.
+var ibPortCounters = map[string]struct{}{
+	"port_rcv_data":                   {},
+	"port_rcv_packets":                {},
+	"port_multicast_rcv_packets":      {},
+	"port_unicast_rcv_packets":        {},
+	"port_xmit_data":                  {},
+	"port_xmit_packets":               {},
+	"port_multicast_xmit_packets":     {},
+	"port_unicast_xmit_packets":       {},
+	"port_rcv_switch_relay_errors":    {},
+	"port_rcv_errors":                 {},
+	"port_rcv_constraint_errors":      {},
+	"local_link_integrity_errors":     {},
+	"port_xmit_wait":                  {},
+	"port_xmit_discards":              {},
+	"port_xmit_constraint_errors":     {},
+	"port_rcv_remote_physical_errors": {},
+	"symbol_error":                    {},
+	"excessive_buffer_overrun_errors": {},
+	"VL15_dropped":                    {},
+	"link_error_recovery":             {},
+	"link_downed":                     {},
+}
+
+// mlx5RoCEHwCounters are the mlx5 hw_counters that only RoCE ports have.
+// The congestion control and PPCNT counters among them are read from
+// registers a VF has no access to, so VFs lack them too.
+var mlx5RoCEHwCounters = map[string]struct{ pfOnly bool }{
+	"np_cnp_sent":                {pfOnly: true},
+	"np_ecn_marked_roce_packets": {pfOnly: true},
+	"rp_cnp_handled":             {pfOnly: true},
+	"rp_cnp_ignored":             {pfOnly: true},
+	"rx_icrc_encapsulated":       {pfOnly: true},
+	"roce_adp_retrans":           {},
+	"roce_adp_retrans_to":        {},
+	"roce_slow_restart":          {},
+	"roce_slow_restart_cnps":     {},
+	"roce_slow_restart_trans":    {},
+}
+
+// zeroStatApplies reports whether a port of device can have the documented
+// counter stat, so --collect.emit-zeros does not invent series the hardware
+// never reports: InfiniBand port counters apply to every port, mlx5
+// hw_counters only to mlx5 ports, and RoCE ones only to mlx5 Ethernet ports.
+func zeroStatApplies(stat string, device rdma.Device, port rdma.Port) bool {
+	if _, ok := ibPortCounters[stat]; ok {
+		return true
+	}
+	if driverHelpPacks[device.Attributes.Driver] != "mlx5" {
+		return false
+	}
+	scope, ok := mlx5RoCEHwCounters[stat]
+	if !ok {
+		return true
+	}
+	if port.Attributes.LinkLayer != "Ethernet" {
+		return false
+	}
+	return !scope.pfOnly || !device.IsVF
+}
@@ -1,15 +1,22 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"net/netip"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 
 	"log/slog"
+
+	"github.com/yuuki/rdma_exporter/internal/collector"
 )
 
 const (
@@ -18,21 +25,173 @@ const (
 	defaultHealthPath    = "/healthz"
 	defaultLogLevel      = "info"
 	defaultSysfsRoot     = "/sys"
+	defaultProvider      = "sysfs"
+	defaultProcfsRoot    = "/proc"
 	defaultTimeout       = 5 * time.Second
-	defaultEnableRoCEPFC = true
+	defaultRetryBackoff  = 100 * time.Millisecond
+
+	defaultStartupGracePeriod = 2 * time.Minute
+	defaultInfluxInterval     = 30 * time.Second
+	defaultPluginInterval     = time.Minute
+	defaultPluginTimeout      = 10 * time.Second
+
+	defaultKubernetesAnnotationPrefix = "rdma-exporter/"
+	defaultKubernetesRefreshInterval  = time.Minute
+
+	// NoDevicesWarn, NoDevicesFail and NoDevicesWait select what happens
+	// when the exporter starts on a host without RDMA devices.
+	NoDevicesWarn = "warn"
+	NoDevicesFail = "fail"
+	NoDevicesWait = "wait"
+
+	// DeviceDedupOff, DeviceDedupPCI and DeviceDedupGUID select how devices
+	// surfacing the same hardware are recognized.
+	DeviceDedupOff  = "off"
+	DeviceDedupPCI  = "pci"
+	DeviceDedupGUID = "guid"
+
+	defaultMetricsSchema = 1
+	latestMetricsSchema  = 2
+
+	defaultFabricIPv4PrefixLen = 24
+	defaultEnableRoCEPFC       = true
+	defaultEnableRawAPI        = false
+	defaultRawAPIMaxAge        = time.Minute
+	defaultEnableLink          = false
+	defaultEnableVPort         = false
+	defaultStateful            = false
+	defaultBuiltinGauges       = false
+	defaultRecoveryBurst       = 3
+	defaultRecoveryBurstWindow = time.Minute
+	defaultAdaptiveBudget      = false
+	defaultNodeDescCheck       = false
+	defaultCollectResources    = false
+	defaultResourcesByProcess  = false
+	defaultCollectDeviceLimits = false
+	defaultEmitZeros           = false
+	defaultByteCounters        = false
+	defaultEnableDeepScan      = false
+	defaultEnableSilenceAPI    = false
+	defaultEnableInvalidateAPI = false
+	defaultCollectProfile      = false
+	defaultRequestLogging      = false
+	defaultEnableH2C           = false
+	defaultSuppressAfter       = 0
+	defaultSuppressKeepAlive   = 10
+	defaultRateJitterWindow    = 60
+	defaultTopCounters         = 0
+	defaultTopCountersWindow   = 5 * time.Minute
+	defaultRetryAttempts       = 3
+	defaultCacheCounterFDs     = false
+	defaultCollectQPCounters   = false
+	defaultQPCounterLimit      = 256
+	defaultCollectNetDevStats  = false
+	defaultCollectGIDTable     = false
+	defaultCollectPKeyTable    = false
+	defaultCollectFlowLabels   = false
+	defaultCollectUEvents      = false
+	defaultCollectRoCEConfig   = false
+	defaultCollectDCB          = false
+	defaultEthtoolClients      = 4
+
+	defaultAttributeRefresh     = 0
+	defaultStableCounterAfter   = 0
+	defaultStableCounterRefresh = 10
 )
 
 // Config captures runtime configuration options.
 type Config struct {
 	ListenAddress        string
+	ListenInterface      string
+	AllowedCIDRs         []netip.Prefix
+	RequestLogging       bool
+	EnableH2C            bool
+	StartupGracePeriod   time.Duration
+	GRPCListenAddress    string
 	MetricsPath          string
 	HealthPath           string
 	LogLevel             slog.Level
+	Provider             string
+	SysfsOnly            bool
 	SysfsRoot            string
+	AllowedSysfsRoots    []string
+	ProcfsRoot           string
 	ScrapeTimeout        time.Duration
+	RetryAttempts        int
+	CacheCounterFDs      bool
+	RetryBackoff         time.Duration
 	EnableRoCEPFCMetrics bool
+	EnableNetDevLink     bool
+	EnableVPortMetrics   bool
+	EthtoolClients       int
+	NetDevEthtoolStats   []string
 	ExcludeDevices       []string
-	ShowVersion          bool
+	FabricIPv4PrefixLen  int
+	EnableRawAPI         bool
+	RawAPIMaxAge         time.Duration
+	EnableDeepScan       bool
+	EnableSilenceAPI     bool
+	EnableInvalidateAPI  bool
+	EnableCollectProfile bool
+	StateFile            string
+	ConditionsFile       string
+	Pidfile              string
+	User                 string
+	Group                string
+	Stateful             bool
+	RecoveryBurst        int
+	RecoveryBurstWindow  time.Duration
+	AdaptiveBudget       bool
+	NodeDescCheck        bool
+	CollectResources     bool
+	ResourcesByProcess   bool
+	CollectDeviceLimits  bool
+	CollectQPCounters    bool
+	QPCounterLimit       int
+	CollectNetDevStats   bool
+	CollectGIDTable      bool
+	CollectPKeyTable     bool
+	CollectFlowLabels    bool
+	CollectUEvents       bool
+	CollectRoCEConfig    bool
+	CollectDCB           bool
+	EmitZeros            bool
+	ByteCounters         bool
+	SuppressAfter        int
+	SuppressKeepAlive    int
+	TickDuration         time.Duration
+	SnapshotLifespan     time.Duration
+	Warmup               time.Duration
+	UtilizationWindow    time.Duration
+	CollectorTimeouts    map[string]time.Duration
+	AttributeRefresh     int
+	StableCounterAfter   int
+	StableCounterRefresh int
+	MetricsSchema        int
+	RailLabels           bool
+	Rails                map[string]string
+	PortRoles            []PortRole
+	NoDevicesPolicy      string
+	DeviceDedup          string
+	RateJitterCounters   []string
+	RateJitterWindow     int
+	GaugeCounters        []string
+	BuiltinGaugeCounters bool
+	CounterSpecsFile     string
+	TopCounters          int
+	TopCountersWindow    time.Duration
+	InfluxURL            string
+	InfluxInterval       time.Duration
+	PluginDir            string
+	PluginInterval       time.Duration
+	PluginTimeout        time.Duration
+	// KubernetesExpectations reads the node's expectations from its
+	// annotations through the API server.
+	KubernetesExpectations     bool
+	KubernetesNodeName         string
+	KubernetesAnnotationPrefix string
+	KubernetesRefreshInterval  time.Duration
+	ShowVersion                bool
 }
 
 // Parse constructs a Config from command-line flags and environment variables.
@@ -43,21 +202,433 @@ func Parse(args []string) (Config, error) {
 	fs.SetOutput(os.Stderr)
 
 	listen := fs.String("listen-address", envOrDefault("RDMA_EXPORTER_LISTEN_ADDRESS", defaultListenAddress), "Address to listen on for HTTP requests.")
+	listenInterface := fs.String("web.listen-interface", envOrDefault("RDMA_EXPORTER_WEB_LISTEN_INTERFACE", ""), "Bind the HTTP server and the gRPC API only to the addresses of this network interface; refuses to start if it is attached to an RDMA device.")
+	grpcListen := fs.String("grpc.listen-address", envOrDefault("RDMA_EXPORTER_GRPC_LISTEN_ADDRESS", ""), "Experimental: serve the gRPC API (GetDevices, StreamCounters) on this address (empty disables).")
 	metricsPath := fs.String("metrics-path", envOrDefault("RDMA_EXPORTER_METRICS_PATH", defaultMetricsPath), "HTTP path under which metrics are served.")
 	healthPath := fs.String("health-path", envOrDefault("RDMA_EXPORTER_HEALTH_PATH", defaultHealthPath), "HTTP path for health checks.")
 	logLevel := fs.String("log-level", envOrDefault("RDMA_EXPORTER_LOG_LEVEL", defaultLogLevel), "Log level (debug, info, warn, error).")
+	provider := fs.String("provider", envOrDefault("RDMA_EXPORTER_PROVIDER", defaultProvider), "Name of the registered RDMA data provider to use.")
 	sysfsRoot := fs.String("sysfs-root", envOrDefault("RDMA_EXPORTER_SYSFS_ROOT", defaultSysfsRoot), "Root of the sysfs tree to read RDMA data from.")
+	sysfsRootAllowed := fs.String("sysfs-root.allowed-prefixes", envOrDefault("RDMA_EXPORTER_SYSFS_ROOT_ALLOWED_PREFIXES", ""), "Comma-separated list of directories --sysfs-root must resolve into, after following symlinks (empty allows any root).")
+	procfsRoot := fs.String("procfs-root", envOrDefault("RDMA_EXPORTER_PROCFS_ROOT", defaultProcfsRoot), "Root of the procfs tree to read kernel settings such as RoCEv2 flow label sysctls from.")
+	pidfile := fs.String("pidfile", envOrDefault("RDMA_EXPORTER_PIDFILE", ""), "Write the process ID to this file at startup and remove it on shutdown.")
+	runAsUser := fs.String("user", envOrDefault("RDMA_EXPORTER_USER", ""), "Drop privileges to this user (name or uid) after privileged clients are initialized.")
+	runAsGroup := fs.String("group", envOrDefault("RDMA_EXPORTER_GROUP", ""), "Drop privileges to this group (name or gid); defaults to the primary group of --user.")
+	railLabels := fs.String("collect.rail-labels", envOrDefault("RDMA_EXPORTER_COLLECT_RAIL_LABELS", ""), `Add a rail label to per-port series: "auto" derives railN from the device index (mlx5_1 → rail1); a comma-separated list of device=rail pairs overrides it per device. Empty disables the label.`)
+	metricsSchema := fs.String("metrics.schema", envOrDefault("RDMA_EXPORTER_METRICS_SCHEMA", strconv.Itoa(defaultMetricsSchema)), `Metric schema version: "1" exports one metric per counter; "2" exports the unicast/multicast packet counters as rdma_port_packets_total{direction,cast}.`)
+	noDevices := fs.String("startup.no-devices", envOrDefault("RDMA_EXPORTER_STARTUP_NO_DEVICES", NoDevicesWarn), `What to do when no RDMA device is found at startup: "warn" logs and serves rdma_devices 0, "fail" exits with an error, "wait" re-discovers with exponential backoff and only serves once a device appears.`)
+	portRoleFlags := &repeatedFlag{values: parseList(os.Getenv("RDMA_EXPORTER_COLLECT_PORT_ROLE"))}
+	fs.Var(portRoleFlags, "collect.port-role", `Add a role label to per-port series from a role=CIDR or role=vlan:<id> rule matching the RoCE GIDs of the port, e.g. "storage=10.1.0.0/16"; repeatable or comma-separated, the first matching rule wins. Ports matching no rule get an empty role.`)
+	allowCIDRs := &repeatedFlag{values: parseList(os.Getenv("RDMA_EXPORTER_WEB_ALLOW_CIDR"))}
+	fs.Var(allowCIDRs, "web.allow-cidr", "Source address range (CIDR or single address) allowed to reach /metrics and the HTTP and gRPC APIs; repeatable or comma-separated. Other sources get 403 (PermissionDenied over gRPC). Empty allows any source.")
+	deviceDedup := fs.String("collect.device-dedup", envOrDefault("RDMA_EXPORTER_COLLECT_DEVICE_DEDUP", DeviceDedupOff), `Export only one of the devices surfacing the same hardware, such as RoCE LAG bond devices: "pci" matches devices by PCI function, "guid" by node_guid, "off" exports every device.`)
+	rateJitterCounters := fs.String("collect.rate-jitter-counters", envOrDefault("RDMA_EXPORTER_COLLECT_RATE_JITTER_COUNTERS", ""), "Comma-separated list of counters or hw_counters (e.g. port_xmit_data) whose scrape-to-scrape rate distribution is exported as rdma_port_counter_rate (empty disables).")
+	counterSpecsFile := fs.String("collect.counter-specs-file", envOrDefault("RDMA_EXPORTER_COLLECT_COUNTER_SPECS_FILE", ""), "YAML file mapping counter names to the canonical name and help text of their metric, for vendor counters the exporter does not know (empty uses the built-in specs only).")
+	gaugeCounters := fs.String("collect.gauge-counters", envOrDefault("RDMA_EXPORTER_COLLECT_GAUGE_COUNTERS", ""), "Comma-separated list of counters or hw_counters that report a current value rather than a count (e.g. firmware occupancy values) to export as gauges without the _total suffix.")
+	influxURL := fs.String("output.influx.url", envOrDefault("RDMA_EXPORTER_OUTPUT_INFLUX_URL", ""), "Also write all metrics in InfluxDB line protocol to this http(s)://, tcp://, udp://, unix:// or file:// URL every --output.influx.interval (empty disables).")
+	pluginDir := fs.String("plugin.dir", envOrDefault("RDMA_EXPORTER_PLUGIN_DIR", ""), "Directory of exec plugins: every executable in it is run each --plugin.interval and its JSON output exported as rdma_plugin_* (empty disables).")
 	excludeDevices := fs.String("exclude-devices", envOrDefault("RDMA_EXPORTER_EXCLUDE_DEVICES", ""), "Comma-separated list of RDMA devices to exclude from monitoring (e.g., mlx5_0,mlx5_1).")
 
-	enableRoCEPFCDefault := defaultEnableRoCEPFC
-	if raw := strings.TrimSpace(os.Getenv("RDMA_EXPORTER_ENABLE_ROCE_PFC_METRICS")); raw != "" {
-		parsed, err := strconv.ParseBool(raw)
+	sysfsOnlyDefault, err := envBoolOrDefault("RDMA_EXPORTER_SYSFS_ONLY", defaultSysfsOnly)
+	if err != nil {
+		return cfg, err
+	}
+	sysfsOnly := fs.Bool("sysfs-only", sysfsOnlyDefault, "Read everything by walking sysfs: requires --provider=sysfs, turns off the RoCEv2 PFC metrics and refuses the features that need netlink, dcbnl or ethtool. Defaults to true in binaries built with the sysfs_only tag.")
+
+	enableRoCEPFCDefault, err := envBoolOrDefault("RDMA_EXPORTER_ENABLE_ROCE_PFC_METRICS", defaultEnableRoCEPFC)
+	if err != nil {
+		return cfg, err
+	}
+	enableRoCEPFCMetrics := fs.Bool("enable-roce-pfc-metrics", enableRoCEPFCDefault, "Enable collection of RoCEv2 PFC metrics from netdev ethtool stats.")
+
+	enableRawAPIDefault, err := envBoolOrDefault("RDMA_EXPORTER_ENABLE_RAW_API", defaultEnableRawAPI)
+	if err != nil {
+		return cfg, err
+	}
+	enableLinkDefault, err := envBoolOrDefault("RDMA_EXPORTER_ENABLE_NETDEV_LINK_METRICS", defaultEnableLink)
+	if err != nil {
+		return cfg, err
+	}
+	enableNetDevLink := fs.Bool("enable-netdev-link-metrics", enableLinkDefault, "Enable collection of netdev link speed, duplex and autonegotiation via ethtool for RoCE ports.")
+
+	enableVPortDefault, err := envBoolOrDefault("RDMA_EXPORTER_ENABLE_VPORT_METRICS", defaultEnableVPort)
+	if err != nil {
+		return cfg, err
+	}
+	enableVPort := fs.Bool("enable-vport-metrics", enableVPortDefault, "Enable collection of VF vport counters from switchdev representor netdevs via ethtool.")
+
+	ethtoolClientsDefault, err := envIntOrDefault("RDMA_EXPORTER_COLLECT_ETHTOOL_CLIENTS", defaultEthtoolClients)
+	if err != nil {
+		return cfg, err
+	}
+	netDevEthtoolStats := fs.String("collect.netdev-ethtool-stats", envOrDefault("RDMA_EXPORTER_COLLECT_NETDEV_ETHTOOL_STATS", ""), "Comma-separated ethtool statistics (globs such as rx_vport_rdma_*) of the netdevs backing RoCE ports to export as rdma_netdev_ethtool_<stat>_total (empty disables).")
+	ethtoolClients := fs.Int("collect.ethtool-clients", ethtoolClientsDefault, "Maximum number of ethtool sockets used at once by concurrent collections; a socket that fails is replaced.")
+
+	builtinGaugesDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_BUILTIN_GAUGE_COUNTERS", defaultBuiltinGauges)
+	if err != nil {
+		return cfg, err
+	}
+	builtinGauges := fs.Bool("collect.builtin-gauge-counters", builtinGaugesDefault, "Export the counters and hw_counters known to report a current value (bnxt_re active_* and watermark_*, lifespan) as gauges without the _total suffix. This renames their series, e.g. rdma_lifespan_total to rdma_lifespan.")
+
+	statefulDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_STATEFUL", defaultStateful)
+	if err != nil {
+		return cfg, err
+	}
+	stateful := fs.Bool("collect.stateful", statefulDefault, "Track per-port state across scrapes to export derived metrics such as rdma_port_idle_seconds.")
+
+	recoveryBurstDefault, err := envIntOrDefault("RDMA_EXPORTER_COLLECT_LINK_RECOVERY_BURST", defaultRecoveryBurst)
+	if err != nil {
+		return cfg, err
+	}
+	recoveryBurst := fs.Int("collect.link-recovery-burst", recoveryBurstDefault, "In stateful mode, count this many link_error_recovery increments within --collect.link-recovery-burst-window as a burst in rdma_port_link_recovery_bursts_total (0 disables).")
+
+	recoveryBurstWindowDefault := defaultRecoveryBurstWindow
+	if raw := os.Getenv("RDMA_EXPORTER_COLLECT_LINK_RECOVERY_BURST_WINDOW"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
 		if err != nil {
-			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_ENABLE_ROCE_PFC_METRICS: %w", err)
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_COLLECT_LINK_RECOVERY_BURST_WINDOW: %w", err)
 		}
-		enableRoCEPFCDefault = parsed
+		recoveryBurstWindowDefault = parsed
 	}
-	enableRoCEPFCMetrics := fs.Bool("enable-roce-pfc-metrics", enableRoCEPFCDefault, "Enable collection of RoCEv2 PFC metrics from netdev ethtool stats.")
+	recoveryBurstWindow := fs.Duration("collect.link-recovery-burst-window", recoveryBurstWindowDefault, "Window within which --collect.link-recovery-burst link error recoveries make a burst.")
+
+	adaptiveBudgetDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_ADAPTIVE_BUDGET", defaultAdaptiveBudget)
+	if err != nil {
+		return cfg, err
+	}
+	adaptiveBudget := fs.Bool("collect.adaptive-budget", adaptiveBudgetDefault, "Skip hw_counters, attributes and ethtool-based collectors while the p95 scrape duration approaches --scrape-timeout.")
+
+	nodeDescCheckDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_NODE_DESC_CHECK", defaultNodeDescCheck)
+	if err != nil {
+		return cfg, err
+	}
+	nodeDescCheck := fs.Bool("collect.node-desc-check", nodeDescCheckDefault, "Export rdma_device_node_desc_mismatch, flagging devices whose node_desc does not start with the host name.")
+
+	collectResourcesDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_RESOURCES", defaultCollectResources)
+	if err != nil {
+		return cfg, err
+	}
+	collectResources := fs.Bool("collect.resources", collectResourcesDefault, "Export the number of allocated QPs, CQs, MRs, PDs, contexts, SRQs and CM IDs per device as rdma_resource_* gauges, read over RDMA netlink.")
+
+	deviceLimitsDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_DEVICE_LIMITS", defaultCollectDeviceLimits)
+	if err != nil {
+		return cfg, err
+	}
+	collectDeviceLimits := fs.Bool("collect.device-limits", deviceLimitsDefault, "Export the maximum number of QPs, CQs, MRs, PDs, SRQs, AHs and MWs each device supports as rdma_device_limit, queried with ibv_query_device. Requires a binary built with cgo and the verbs build tag.")
+
+	resourcesByProcessDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_RESOURCES_BY_PROCESS", defaultResourcesByProcess)
+	if err != nil {
+		return cfg, err
+	}
+	resourcesByProcess := fs.Bool("collect.resources.by-process", resourcesByProcessDefault, "With --collect.resources, also break QP, MR and user context counts down by owning process as rdma_resource_*_by_process{pid,comm}.")
+
+	collectQPCountersDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_QP_COUNTERS", defaultCollectQPCounters)
+	if err != nil {
+		return cfg, err
+	}
+	collectQPCounters := fs.Bool("collect.qp-counters", collectQPCountersDefault, "Export the per-QP statistics counters of queue pairs bound with \"rdma statistic qp\" as rdma_qp_counter_*, read over RDMA netlink.")
+
+	qpCounterLimitDefault, err := envIntOrDefault("RDMA_EXPORTER_COLLECT_QP_COUNTERS_LIMIT", defaultQPCounterLimit)
+	if err != nil {
+		return cfg, err
+	}
+	qpCounterLimit := fs.Int("collect.qp-counters.limit", qpCounterLimitDefault, "Maximum number of QP counters exported per scrape; the rest are counted in rdma_qp_counters_dropped.")
+
+	netDevStatsDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_NETDEV_STATISTICS", defaultCollectNetDevStats)
+	if err != nil {
+		return cfg, err
+	}
+	collectNetDevStats := fs.Bool("collect.netdev-statistics", netDevStatsDefault, "Export the generic counters in /sys/class/net/<netdev>/statistics of the netdevs backing RoCE ports as rdma_netdev_*_total; unlike the ethtool-based metrics it needs no CAP_NET_ADMIN.")
+
+	gidTableDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_GID_TABLE", defaultCollectGIDTable)
+	if err != nil {
+		return cfg, err
+	}
+	collectGIDTable := fs.Bool("collect.gid-table", gidTableDefault, "Export every populated GID table entry with its RoCE type and netdev as rdma_port_gid_info.")
+
+	pkeyTableDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_PKEY_TABLE", defaultCollectPKeyTable)
+	if err != nil {
+		return cfg, err
+	}
+	collectPKeyTable := fs.Bool("collect.pkey-table", pkeyTableDefault, "Export every populated partition key table entry as rdma_port_pkey_info.")
+
+	flowLabelsDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_IPV6_FLOWLABEL_SYSCTLS", defaultCollectFlowLabels)
+	if err != nil {
+		return cfg, err
+	}
+	collectFlowLabels := fs.Bool("collect.ipv6-flowlabel-sysctls", flowLabelsDefault, "Export the net.ipv6 flow label sysctls (auto_flowlabels, flowlabel_state_ranges, flowlabel_reflect) as rdma_ipv6_flowlabel_sysctl_info. They do not affect the RoCEv2 UDP source port.")
+
+	roceConfigDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_ROCE_CONFIG", defaultCollectRoCEConfig)
+	if err != nil {
+		return cfg, err
+	}
+	collectRoCEConfig := fs.Bool("collect.roce-config", roceConfigDefault, "Export the ToS/DSCP, RDMA CM defaults, trust state, per-priority ECN and DCQCN configuration of RoCE ports as rdma_roce_qos_info, rdma_roce_default_version, rdma_roce_default_tos, rdma_roce_ecn_enabled and rdma_roce_dcqcn_parameter.")
+
+	dcbDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_DCB", defaultCollectDCB)
+	if err != nil {
+		return cfg, err
+	}
+	collectDCB := fs.Bool("collect.dcb", dcbDefault, "Export the PFC and ETS configuration of the netdevs backing RoCE ports, read over dcbnl, as rdma_pfc_priority_enabled, rdma_pfc_delay_bits, rdma_ets_bandwidth_percent and rdma_ets_priority_traffic_class (Linux only).")
+
+	ueventsDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_UEVENTS", defaultCollectUEvents)
+	if err != nil {
+		return cfg, err
+	}
+	collectUEvents := fs.Bool("collect.uevents", ueventsDefault, "Watch kernel uevents of RDMA devices and export their add, remove and change events as rdma_device_uevents_total (Linux only).")
+
+	rateJitterWindowDefault, err := envIntOrDefault("RDMA_EXPORTER_COLLECT_RATE_JITTER_WINDOW", defaultRateJitterWindow)
+	if err != nil {
+		return cfg, err
+	}
+	rateJitterWindow := fs.Int("collect.rate-jitter-window", rateJitterWindowDefault, "Number of recent scrape-to-scrape rates rdma_port_counter_rate is computed over.")
+
+	emitZerosDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_EMIT_ZEROS", defaultEmitZeros)
+	if err != nil {
+		return cfg, err
+	}
+	emitZeros := fs.Bool("collect.emit-zeros", emitZerosDefault, "Emit explicit zero series for documented counters a driver does not expose.")
+
+	byteCountersDefault, err := envBoolOrDefault("RDMA_EXPORTER_COLLECT_BYTE_COUNTERS", defaultByteCounters)
+	if err != nil {
+		return cfg, err
+	}
+	byteCounters := fs.Bool("collect.byte-counters", byteCountersDefault, "Also export port_xmit_data and port_rcv_data, which count 4-octet words, in bytes as rdma_port_xmit_bytes_total and rdma_port_rcv_bytes_total.")
+
+	suppressAfterDefault, err := envIntOrDefault("RDMA_EXPORTER_COLLECT_SUPPRESS_UNCHANGED_AFTER", defaultSuppressAfter)
+	if err != nil {
+		return cfg, err
+	}
+	suppressAfter := fs.Int("collect.suppress-unchanged-after", suppressAfterDefault, "Experimental: omit counter series unchanged for this many consecutive scrapes (0 disables).")
+
+	suppressKeepAliveDefault, err := envIntOrDefault("RDMA_EXPORTER_COLLECT_SUPPRESS_UNCHANGED_KEEPALIVE", defaultSuppressKeepAlive)
+	if err != nil {
+		return cfg, err
+	}
+	suppressKeepAlive := fs.Int("collect.suppress-unchanged-keepalive", suppressKeepAliveDefault, "Re-emit suppressed counter series every this many scrapes (0 disables keep-alives).")
+
+	attributeRefreshDefault, err := envIntOrDefault("RDMA_EXPORTER_COLLECT_ATTRIBUTE_REFRESH", defaultAttributeRefresh)
+	if err != nil {
+		return cfg, err
+	}
+	attributeRefresh := fs.Int("collect.attribute-refresh", attributeRefreshDefault, "Re-read device and port attributes only every this many reads, or when the port state changes (0 reads them every time).")
+
+	stableCounterAfterDefault, err := envIntOrDefault("RDMA_EXPORTER_COLLECT_STABLE_COUNTER_AFTER", defaultStableCounterAfter)
+	if err != nil {
+		return cfg, err
+	}
+	stableCounterAfter := fs.Int("collect.stable-counter-after", stableCounterAfterDefault, "Treat a counter as stable after this many unchanged reads and poll it only every --collect.stable-counter-refresh reads (0 disables).")
+
+	stableCounterRefreshDefault, err := envIntOrDefault("RDMA_EXPORTER_COLLECT_STABLE_COUNTER_REFRESH", defaultStableCounterRefresh)
+	if err != nil {
+		return cfg, err
+	}
+	stableCounterRefresh := fs.Int("collect.stable-counter-refresh", stableCounterRefreshDefault, "Re-read stable counters every this many reads; increments of a stable counter are reported up to this many reads minus one late.")
+
+	requestLoggingDefault, err := envBoolOrDefault("RDMA_EXPORTER_WEB_REQUEST_LOGGING", defaultRequestLogging)
+	if err != nil {
+		return cfg, err
+	}
+	requestLogging := fs.Bool("web.request-logging", requestLoggingDefault, "Log every HTTP request with method, path, status, duration and remote address.")
+
+	enableH2CDefault, err := envBoolOrDefault("RDMA_EXPORTER_WEB_H2C", defaultEnableH2C)
+	if err != nil {
+		return cfg, err
+	}
+	enableH2C := fs.Bool("web.h2c", enableH2CDefault, "Also accept HTTP/2 without TLS (h2c, prior knowledge) on the listener, for service meshes and proxies that scrape over HTTP/2.")
+
+	enableRawAPI := fs.Bool("enable-raw-api", enableRawAPIDefault, "Serve the raw counter snapshot as gzip-compressed JSON under /api/v1/raw.")
+
+	rawAPIMaxAgeDefault := defaultRawAPIMaxAge
+	if raw := os.Getenv("RDMA_EXPORTER_RAW_API_MAX_AGE"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_RAW_API_MAX_AGE: %w", err)
+		}
+		rawAPIMaxAgeDefault = parsed
+	}
+	rawAPIMaxAge := fs.Duration("raw-api.max-age", rawAPIMaxAgeDefault, "Serve /api/v1/raw, /api/v1/conditions and the gRPC API from the last scrape while it is at most this old, reading the devices again only when it is older (0 reads them for every request).")
+
+	enableDeepScanDefault, err := envBoolOrDefault("RDMA_EXPORTER_ENABLE_DEEP_SCAN", defaultEnableDeepScan)
+	if err != nil {
+		return cfg, err
+	}
+	enableDeepScan := fs.Bool("enable-deep-scan", enableDeepScanDefault, "Serve POST /-/collect/deep, which runs the expensive collectors once on demand.")
+
+	enableSilenceAPIDefault, err := envBoolOrDefault("RDMA_EXPORTER_ENABLE_SILENCE_API", defaultEnableSilenceAPI)
+	if err != nil {
+		return cfg, err
+	}
+	enableSilenceAPI := fs.Bool("enable-silence-api", enableSilenceAPIDefault, "Serve /api/v1/silence, which excludes a device from collection for a given duration.")
+
+	enableInvalidateAPIDefault, err := envBoolOrDefault("RDMA_EXPORTER_ENABLE_INVALIDATE_API", defaultEnableInvalidateAPI)
+	if err != nil {
+		return cfg, err
+	}
+	enableInvalidateAPI := fs.Bool("enable-invalidate-api", enableInvalidateAPIDefault, "Serve POST /-/invalidate-cache, which drops all cached device, attribute and counter state like SIGUSR2.")
+
+	enableCollectProfileDefault, err := envBoolOrDefault("RDMA_EXPORTER_ENABLE_COLLECT_PROFILE", defaultCollectProfile)
+	if err != nil {
+		return cfg, err
+	}
+	enableCollectProfile := fs.Bool("enable-collect-profile", enableCollectProfileDefault, "Serve GET /debug/collect-profile?seconds=N, which returns a pprof CPU profile of N seconds of back-to-back device reads.")
+	stateFile := fs.String("state.file", envOrDefault("RDMA_EXPORTER_STATE_FILE", ""), "File that keeps device silences across restarts (empty keeps them in memory only).")
+	conditionsFile := fs.String("conditions.file", envOrDefault("RDMA_EXPORTER_CONDITIONS_FILE", ""), "YAML file of threshold conditions evaluated on every scrape, exported as rdma_condition_active and listed at /api/v1/conditions (empty disables both).")
+
+	retryAttemptsDefault, err := envIntOrDefault("RDMA_EXPORTER_SYSFS_RETRY_ATTEMPTS", defaultRetryAttempts)
+	if err != nil {
+		return cfg, err
+	}
+	retryAttempts := fs.Int("sysfs.retry-attempts", retryAttemptsDefault, "Number of attempts to read a device whose sysfs files return a transient error such as EBUSY.")
+
+	cacheCounterFDsDefault, err := envBoolOrDefault("RDMA_EXPORTER_SYSFS_CACHE_COUNTER_FDS", defaultCacheCounterFDs)
+	if err != nil {
+		return cfg, err
+	}
+	cacheCounterFDs := fs.Bool("sysfs.cache-counter-fds", cacheCounterFDsDefault, "Keep counter and hw_counter files open between scrapes and re-read them with pread, using one file descriptor per counter.")
+
+	retryBackoffDefault := defaultRetryBackoff
+	if raw := os.Getenv("RDMA_EXPORTER_SYSFS_RETRY_BACKOFF"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_SYSFS_RETRY_BACKOFF: %w", err)
+		}
+		retryBackoffDefault = parsed
+	}
+	retryBackoff := fs.Duration("sysfs.retry-backoff", retryBackoffDefault, "Wait before the first retry of a device read; doubles on every further retry.")
+
+	fabricPrefixDefault, err := envIntOrDefault("RDMA_EXPORTER_FABRIC_IPV4_PREFIX_LENGTH", defaultFabricIPv4PrefixLen)
+	if err != nil {
+		return cfg, err
+	}
+	fabricIPv4PrefixLen := fs.Int("fabric-ipv4-prefix-length", fabricPrefixDefault, "Prefix length used to derive the fabric label from IPv4-mapped RoCE GIDs.")
+
+	tickDurationDefault := time.Duration(0)
+	if raw := os.Getenv("RDMA_EXPORTER_COLLECT_TICK_DURATION"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_COLLECT_TICK_DURATION: %w", err)
+		}
+		tickDurationDefault = parsed
+	}
+	tickDuration := fs.Duration("collect.tick-duration", tickDurationDefault, "Tick length of tick-based counters such as port_xmit_wait, exported as rdma_port_tick_duration_seconds when the provider does not report it (0 leaves it unknown).")
+
+	snapshotLifespanDefault := time.Duration(0)
+	if raw := os.Getenv("RDMA_EXPORTER_COLLECT_SNAPSHOT_LIFESPAN"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_COLLECT_SNAPSHOT_LIFESPAN: %w", err)
+		}
+		snapshotLifespanDefault = parsed
+	}
+	snapshotLifespan := fs.Duration("collect.snapshot-lifespan", snapshotLifespanDefault, "Serve scrapes arriving within this long of the last device read from its snapshot, at least for the hw counter lifespan the devices report (0 reads devices for every scrape).")
+
+	warmupDefault := time.Duration(0)
+	if raw := os.Getenv("RDMA_EXPORTER_COLLECT_WARMUP"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_COLLECT_WARMUP: %w", err)
+		}
+		warmupDefault = parsed
+	}
+	warmup := fs.Duration("collect.warmup", warmupDefault, "Withhold metrics derived from earlier scrapes (idle time, retransmit ratio, counter rates, link setting changes) for this long after startup while drivers settle (0 disables).")
+
+	utilizationWindowDefault := time.Duration(0)
+	if raw := os.Getenv("RDMA_EXPORTER_COLLECT_UTILIZATION_WINDOW"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_COLLECT_UTILIZATION_WINDOW: %w", err)
+		}
+		utilizationWindowDefault = parsed
+	}
+	utilizationWindow := fs.Duration("collect.utilization-window", utilizationWindowDefault, "Export rdma_port_utilization_ratio, the share of the link rate each port transmitted and received, averaged over this window (0 disables).")
+
+	topCountersDefault, err := envIntOrDefault("RDMA_EXPORTER_COLLECT_TOP_COUNTERS", defaultTopCounters)
+	if err != nil {
+		return cfg, err
+	}
+	topCounters := fs.Int("collect.top-counters", topCountersDefault, "Debugging aid: export the counters and hw_counters of all ports that increased the most over --collect.top-counters-window as rdma_exporter_top_counter_increase, this many of them (0 disables).")
+
+	topCountersWindowDefault := defaultTopCountersWindow
+	if raw := os.Getenv("RDMA_EXPORTER_COLLECT_TOP_COUNTERS_WINDOW"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_COLLECT_TOP_COUNTERS_WINDOW: %w", err)
+		}
+		topCountersWindowDefault = parsed
+	}
+	topCountersWindow := fs.Duration("collect.top-counters-window", topCountersWindowDefault, "Window over which the increase of the counters ranked by --collect.top-counters is computed.")
+
+	collectorTimeoutFlags := make(map[string]*time.Duration, len(collector.TimeoutCollectors))
+	for _, name := range collector.TimeoutCollectors {
+		env := "RDMA_EXPORTER_COLLECT_" + strings.ToUpper(name) + "_TIMEOUT"
+		var timeoutDefault time.Duration
+		if raw := os.Getenv(env); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", env, err)
+			}
+			timeoutDefault = parsed
+		}
+		collectorTimeoutFlags[name] = fs.Duration("collect."+strings.ReplaceAll(name, "_", "-")+".timeout", timeoutDefault,
+			"Cut the "+name+" collector off after this long within a scrape, so the others still complete (0 bounds it by --scrape-timeout only).")
+	}
+
+	influxIntervalDefault := defaultInfluxInterval
+	if raw := os.Getenv("RDMA_EXPORTER_OUTPUT_INFLUX_INTERVAL"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_OUTPUT_INFLUX_INTERVAL: %w", err)
+		}
+		influxIntervalDefault = parsed
+	}
+	influxInterval := fs.Duration("output.influx.interval", influxIntervalDefault, "Interval between two writes to --output.influx.url.")
+
+	pluginIntervalDefault := defaultPluginInterval
+	if raw := os.Getenv("RDMA_EXPORTER_PLUGIN_INTERVAL"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_PLUGIN_INTERVAL: %w", err)
+		}
+		pluginIntervalDefault = parsed
+	}
+	pluginInterval := fs.Duration("plugin.interval", pluginIntervalDefault, "Interval between two runs of every plugin in --plugin.dir.")
+
+	pluginTimeoutDefault := defaultPluginTimeout
+	if raw := os.Getenv("RDMA_EXPORTER_PLUGIN_TIMEOUT"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_PLUGIN_TIMEOUT: %w", err)
+		}
+		pluginTimeoutDefault = parsed
+	}
+	pluginTimeout := fs.Duration("plugin.timeout", pluginTimeoutDefault, "Time after which a plugin run is killed and counted as failed.")
+
+	kubernetesExpectationsDefault, err := envBoolOrDefault("RDMA_EXPORTER_KUBERNETES_NODE_EXPECTATIONS", false)
+	if err != nil {
+		return cfg, err
+	}
+	kubernetesExpectations := fs.Bool("kubernetes.node-expectations", kubernetesExpectationsDefault, "Read the node's expected devices, active ports and port rate from its annotations through the Kubernetes API server and export rdma_expected and rdma_expectation_violated.")
+	kubernetesNodeName := fs.String("kubernetes.node-name", envOrDefault("RDMA_EXPORTER_KUBERNETES_NODE_NAME", os.Getenv("NODE_NAME")), "Name of the node object to read expectations from; defaults to $NODE_NAME, set from spec.nodeName with the downward API.")
+	kubernetesAnnotationPrefix := fs.String("kubernetes.annotation-prefix", envOrDefault("RDMA_EXPORTER_KUBERNETES_ANNOTATION_PREFIX", defaultKubernetesAnnotationPrefix), "Prefix of the node annotations holding expectations, e.g. rdma-exporter/expected-ports.")
+	kubernetesRefreshDefault := defaultKubernetesRefreshInterval
+	if raw := os.Getenv("RDMA_EXPORTER_KUBERNETES_REFRESH_INTERVAL"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_KUBERNETES_REFRESH_INTERVAL: %w", err)
+		}
+		kubernetesRefreshDefault = parsed
+	}
+	kubernetesRefresh := fs.Duration("kubernetes.refresh-interval", kubernetesRefreshDefault, "Interval between two reads of the node object for --kubernetes.node-expectations.")
+
+	startupGraceDefault := defaultStartupGracePeriod
+	if raw := os.Getenv("RDMA_EXPORTER_WEB_STARTUP_GRACE_PERIOD"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RDMA_EXPORTER_WEB_STARTUP_GRACE_PERIOD: %w", err)
+		}
+		startupGraceDefault = parsed
+	}
+	startupGrace := fs.Duration("web.startup-grace-period", startupGraceDefault, "How long /-/started waits for an RDMA device before reporting startup complete with none.")
 
 	timeoutDefault := defaultTimeout
 	if envTimeout := os.Getenv("RDMA_EXPORTER_SCRAPE_TIMEOUT"); envTimeout != "" {
@@ -77,25 +648,296 @@ func Parse(args []string) (Config, error) {
 		return cfg, fmt.Errorf("parse flags: %w", err)
 	}
 
+	if *fabricIPv4PrefixLen < 1 || *fabricIPv4PrefixLen > 32 {
+		return cfg, fmt.Errorf("invalid fabric IPv4 prefix length %d: must be between 1 and 32", *fabricIPv4PrefixLen)
+	}
+
+	if *suppressAfter < 0 || *suppressKeepAlive < 0 {
+		return cfg, fmt.Errorf("invalid unchanged counter suppression: after and keep-alive must not be negative")
+	}
+
+	if *attributeRefresh < 0 || *stableCounterAfter < 0 {
+		return cfg, fmt.Errorf("invalid change detection: attribute refresh and stable counter threshold must not be negative")
+	}
+
+	if *stableCounterRefresh < 1 {
+		return cfg, fmt.Errorf("invalid stable counter refresh %d: must be at least 1", *stableCounterRefresh)
+	}
+
+	if *resourcesByProcess && !*collectResources {
+		return cfg, errors.New("--collect.resources.by-process requires --collect.resources")
+	}
+
+	for _, pattern := range parseList(*netDevEthtoolStats) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return cfg, fmt.Errorf("invalid netdev ethtool stat pattern %q: %w", pattern, err)
+		}
+	}
+
+	if *ethtoolClients < 1 {
+		return cfg, fmt.Errorf("invalid ethtool client count %d: must be positive", *ethtoolClients)
+	}
+
+	if *qpCounterLimit < 1 {
+		return cfg, fmt.Errorf("invalid qp counter limit %d: must be at least 1", *qpCounterLimit)
+	}
+
+	if *retryAttempts < 1 {
+		return cfg, fmt.Errorf("invalid sysfs retry attempts %d: must be at least 1", *retryAttempts)
+	}
+
+	if *retryBackoff < 0 {
+		return cfg, fmt.Errorf("invalid sysfs retry backoff %s: must not be negative", *retryBackoff)
+	}
+
+	switch *noDevices {
+	case NoDevicesWarn, NoDevicesFail, NoDevicesWait:
+	default:
+		return cfg, fmt.Errorf("invalid startup no-devices policy %q: must be %q, %q or %q", *noDevices, NoDevicesWarn, NoDevicesFail, NoDevicesWait)
+	}
+
+	allowedCIDRs, err := parseCIDRs(allowCIDRs.values)
+	if err != nil {
+		return cfg, err
+	}
+
+	switch *deviceDedup {
+	case DeviceDedupOff, DeviceDedupPCI, DeviceDedupGUID:
+	default:
+		return cfg, fmt.Errorf("invalid device dedup mode %q: must be %q, %q or %q", *deviceDedup, DeviceDedupOff, DeviceDedupPCI, DeviceDedupGUID)
+	}
+
+	if *startupGrace < 0 {
+		return cfg, fmt.Errorf("invalid startup grace period %s: must not be negative", *startupGrace)
+	}
+
+	if *rateJitterWindow < 2 {
+		return cfg, fmt.Errorf("invalid rate jitter window %d: must be at least 2", *rateJitterWindow)
+	}
+
+	if *influxInterval <= 0 {
+		return cfg, fmt.Errorf("invalid influx output interval %s: must be positive", *influxInterval)
+	}
+
+	if *pluginInterval <= 0 || *pluginTimeout <= 0 {
+		return cfg, fmt.Errorf("invalid plugin interval %s or timeout %s: must be positive", *pluginInterval, *pluginTimeout)
+	}
+
+	if *kubernetesExpectations && strings.TrimSpace(*kubernetesNodeName) == "" {
+		return cfg, errors.New("--kubernetes.node-expectations requires --kubernetes.node-name or NODE_NAME")
+	}
+	if *kubernetesRefresh <= 0 {
+		return cfg, fmt.Errorf("invalid kubernetes refresh interval %s: must be positive", *kubernetesRefresh)
+	}
+
+	if *tickDuration < 0 {
+		return cfg, fmt.Errorf("invalid tick duration %s: must not be negative", *tickDuration)
+	}
+
+	if *rawAPIMaxAge < 0 {
+		return cfg, fmt.Errorf("invalid raw API max age %s: must not be negative", *rawAPIMaxAge)
+	}
+
+	if *snapshotLifespan < 0 {
+		return cfg, fmt.Errorf("invalid snapshot lifespan %s: must not be negative", *snapshotLifespan)
+	}
+
+	if *warmup < 0 {
+		return cfg, fmt.Errorf("invalid warm-up %s: must not be negative", *warmup)
+	}
+
+	if *utilizationWindow < 0 {
+		return cfg, fmt.Errorf("invalid utilization window %s: must not be negative", *utilizationWindow)
+	}
+
+	if *recoveryBurst < 0 {
+		return cfg, fmt.Errorf("invalid link recovery burst %d: must not be negative", *recoveryBurst)
+	}
+	if *recoveryBurstWindow <= 0 {
+		return cfg, fmt.Errorf("invalid link recovery burst window %s: must be positive", *recoveryBurstWindow)
+	}
+
+	if *topCounters < 0 {
+		return cfg, fmt.Errorf("invalid top counters %d: must not be negative", *topCounters)
+	}
+	if *topCountersWindow <= 0 {
+		return cfg, fmt.Errorf("invalid top counters window %s: must be positive", *topCountersWindow)
+	}
+
+	if *sysfsOnly {
+		if *provider != defaultProvider {
+			return cfg, fmt.Errorf("--sysfs-only requires --provider=%s, got %q", defaultProvider, *provider)
+		}
+		// The RoCEv2 PFC metrics are on by default, so they are turned off
+		// rather than refused.
+		*enableRoCEPFCMetrics = false
+		for _, feature := range []struct {
+			flag    string
+			enabled bool
+		}{
+			{"enable-netdev-link-metrics", *enableNetDevLink},
+			{"enable-vport-metrics", *enableVPort},
+			{"collect.netdev-ethtool-stats", *netDevEthtoolStats != ""},
+			{"collect.resources", *collectResources},
+			{"collect.device-limits", *collectDeviceLimits},
+			{"collect.qp-counters", *collectQPCounters},
+			{"collect.dcb", *collectDCB},
+			{"collect.uevents", *collectUEvents},
+		} {
+			if feature.enabled {
+				return cfg, fmt.Errorf("--%s cannot be used with --sysfs-only: it is not read from sysfs", feature.flag)
+			}
+		}
+	}
+
+	var collectorTimeouts map[string]time.Duration
+	for _, name := range collector.TimeoutCollectors {
+		timeout := *collectorTimeoutFlags[name]
+		if timeout < 0 {
+			return cfg, fmt.Errorf("invalid %s collector timeout %s: must not be negative", name, timeout)
+		}
+		if timeout == 0 {
+			continue
+		}
+		if collectorTimeouts == nil {
+			collectorTimeouts = make(map[string]time.Duration)
+		}
+		collectorTimeouts[name] = timeout
+	}
+
 	level, err := parseLogLevel(*logLevel)
 	if err != nil {
 		return cfg, err
 	}
 
+	rails, err := parseRails(*railLabels)
+	if err != nil {
+		return cfg, err
+	}
+
+	portRoles, err := parsePortRoles(portRoleFlags.values)
+	if err != nil {
+		return cfg, err
+	}
+
+	schema, err := parseMetricsSchema(*metricsSchema)
+	if err != nil {
+		return cfg, err
+	}
+
 	cfg = Config{
 		ListenAddress:        *listen,
+		ListenInterface:      *listenInterface,
+		AllowedCIDRs:         allowedCIDRs,
+		RequestLogging:       *requestLogging,
+		EnableH2C:            *enableH2C,
+		StartupGracePeriod:   *startupGrace,
+		GRPCListenAddress:    *grpcListen,
 		MetricsPath:          *metricsPath,
 		HealthPath:           *healthPath,
 		LogLevel:             level,
+		Provider:             *provider,
+		SysfsOnly:            *sysfsOnly,
 		SysfsRoot:            *sysfsRoot,
+		AllowedSysfsRoots:    parseList(*sysfsRootAllowed),
+		ProcfsRoot:           *procfsRoot,
 		ScrapeTimeout:        *scrapeTimeout,
+		RetryAttempts:        *retryAttempts,
+		CacheCounterFDs:      *cacheCounterFDs,
+		RetryBackoff:         *retryBackoff,
 		EnableRoCEPFCMetrics: *enableRoCEPFCMetrics,
-		ExcludeDevices:       parseDeviceList(*excludeDevices),
-		ShowVersion:          *showVersion,
+		EnableNetDevLink:     *enableNetDevLink,
+		EnableVPortMetrics:   *enableVPort,
+		EthtoolClients:       *ethtoolClients,
+		NetDevEthtoolStats:   parseList(*netDevEthtoolStats),
+		ExcludeDevices:       parseList(*excludeDevices),
+		FabricIPv4PrefixLen:  *fabricIPv4PrefixLen,
+		EnableRawAPI:         *enableRawAPI,
+		RawAPIMaxAge:         *rawAPIMaxAge,
+		EnableDeepScan:       *enableDeepScan,
+		EnableSilenceAPI:     *enableSilenceAPI,
+		EnableInvalidateAPI:  *enableInvalidateAPI,
+		EnableCollectProfile: *enableCollectProfile,
+		StateFile:            *stateFile,
+		ConditionsFile:       *conditionsFile,
+		Pidfile:              *pidfile,
+		User:                 *runAsUser,
+		Group:                *runAsGroup,
+		Stateful:             *stateful,
+		RecoveryBurst:        *recoveryBurst,
+		RecoveryBurstWindow:  *recoveryBurstWindow,
+		AdaptiveBudget:       *adaptiveBudget,
+		NodeDescCheck:        *nodeDescCheck,
+		CollectResources:     *collectResources,
+		ResourcesByProcess:   *resourcesByProcess,
+		CollectDeviceLimits:  *collectDeviceLimits,
+		CollectQPCounters:    *collectQPCounters,
+		QPCounterLimit:       *qpCounterLimit,
+		CollectNetDevStats:   *collectNetDevStats,
+		CollectGIDTable:      *collectGIDTable,
+		CollectPKeyTable:     *collectPKeyTable,
+		CollectFlowLabels:    *collectFlowLabels,
+		CollectUEvents:       *collectUEvents,
+		CollectRoCEConfig:    *collectRoCEConfig,
+		CollectDCB:           *collectDCB,
+		EmitZeros:            *emitZeros,
+		ByteCounters:         *byteCounters,
+		SuppressAfter:        *suppressAfter,
+		SuppressKeepAlive:    *suppressKeepAlive,
+		TickDuration:         *tickDuration,
+		SnapshotLifespan:     *snapshotLifespan,
+		Warmup:               *warmup,
+		UtilizationWindow:    *utilizationWindow,
+		CollectorTimeouts:    collectorTimeouts,
+		AttributeRefresh:     *attributeRefresh,
+		StableCounterAfter:   *stableCounterAfter,
+		StableCounterRefresh: *stableCounterRefresh,
+		MetricsSchema:        schema,
+		RailLabels:           *railLabels != "",
+		Rails:                rails,
+		PortRoles:            portRoles,
+		NoDevicesPolicy:      *noDevices,
+		DeviceDedup:          *deviceDedup,
+		RateJitterCounters:   parseList(*rateJitterCounters),
+		RateJitterWindow:     *rateJitterWindow,
+		GaugeCounters:        parseList(*gaugeCounters),
+		BuiltinGaugeCounters: *builtinGauges,
+		CounterSpecsFile:     *counterSpecsFile,
+		TopCounters:          *topCounters,
+		TopCountersWindow:    *topCountersWindow,
+		InfluxURL:            *influxURL,
+		InfluxInterval:       *influxInterval,
+		PluginDir:            *pluginDir,
+		PluginInterval:       *pluginInterval,
+		PluginTimeout:        *pluginTimeout,
+
+		KubernetesExpectations:     *kubernetesExpectations,
+		KubernetesNodeName:         strings.TrimSpace(*kubernetesNodeName),
+		KubernetesAnnotationPrefix: *kubernetesAnnotationPrefix,
+		KubernetesRefreshInterval:  *kubernetesRefresh,
+		ShowVersion:                *showVersion,
 	}
 	return cfg, nil
 }
 
+// Hash returns a short fingerprint of the effective configuration. Two
+// exporters started with the same flags and environment return the same
+// hash, so fleets can spot nodes running divergent settings. ShowVersion is
+// left out as it never reaches a running exporter, and KubernetesNodeName as
+// it differs on every node by design.
+func (c Config) Hash() string {
+	c.ShowVersion = false
+	c.KubernetesNodeName = ""
+	// encoding/json sorts map keys, so Rails hashes deterministically.
+	data, err := json.Marshal(c)
+	if err != nil {
+		// Config only holds plain values; this cannot happen.
+		panic(fmt.Sprintf("config: marshal for hash: %v", err))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
 func envOrDefault(key, fallback string) string {
 	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
 		return value
@@ -103,6 +945,30 @@ func envOrDefault(key, fallback string) string {
 	return fallback
 }
 
+func envBoolOrDefault(key string, fallback bool) (bool, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(raw)
+	if err != nil {
+		return fallback, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+func envIntOrDefault(key string, fallback int) (int, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(raw)
+	if err != nil {
+		return fallback, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
 func parseLogLevel(value string) (slog.Level, error) {
 	switch strings.ToLower(strings.TrimSpace(value)) {
 	case "debug":
@@ -118,7 +984,111 @@ func parseLogLevel(value string) (slog.Level, error) {
 	}
 }
 
-func parseDeviceList(list string) []string {
+// parseMetricsSchema parses --metrics.schema, accepting "2" and "v2".
+func parseMetricsSchema(value string) (int, error) {
+	version, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(value), "v"))
+	if err != nil || version < 1 || version > latestMetricsSchema {
+		return 0, fmt.Errorf("invalid metrics schema %q: must be between 1 and %d", value, latestMetricsSchema)
+	}
+	return version, nil
+}
+
+// parseRails parses --collect.rail-labels. "auto" yields no overrides.
+func parseRails(value string) (map[string]string, error) {
+	if value == "" || value == "auto" {
+		return nil, nil
+	}
+	rails := make(map[string]string)
+	for _, entry := range parseList(value) {
+		device, rail, ok := strings.Cut(entry, "=")
+		device, rail = strings.TrimSpace(device), strings.TrimSpace(rail)
+		if !ok || device == "" || rail == "" {
+			return nil, fmt.Errorf("invalid rail label %q: must be device=rail", entry)
+		}
+		rails[device] = rail
+	}
+	return rails, nil
+}
+
+// PortRole classifies the ports with a RoCE GID inside Prefix, or on a netdev
+// of VLAN VLAN, as Role.
+type PortRole struct {
+	Role   string
+	Prefix netip.Prefix
+	VLAN   int
+}
+
+// parsePortRoles parses --collect.port-role rules, keeping their order.
+func parsePortRoles(values []string) ([]PortRole, error) {
+	var roles []PortRole
+	for _, value := range values {
+		role, network, ok := strings.Cut(value, "=")
+		role, network = strings.TrimSpace(role), strings.TrimSpace(network)
+		if !ok || role == "" || network == "" {
+			return nil, fmt.Errorf("invalid port role %q: must be role=CIDR or role=vlan:<id>", value)
+		}
+		if vid, ok := strings.CutPrefix(network, "vlan:"); ok {
+			vlan, err := strconv.Atoi(vid)
+			if err != nil || vlan < 1 || vlan > 4094 {
+				return nil, fmt.Errorf("invalid port role %q: VLAN ID must be between 1 and 4094", value)
+			}
+			roles = append(roles, PortRole{Role: role, VLAN: vlan})
+			continue
+		}
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port role %q: %w", value, err)
+		}
+		roles = append(roles, PortRole{Role: role, Prefix: prefix.Masked()})
+	}
+	return roles, nil
+}
+
+// repeatedFlag collects the values of a flag given several times, each of
+// which may be a comma-separated list. The first value given on the command
+// line replaces the default taken from the environment.
+type repeatedFlag struct {
+	values []string
+	set    bool
+}
+
+func (f *repeatedFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.values, ",")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	if !f.set {
+		f.values = nil
+		f.set = true
+	}
+	f.values = append(f.values, parseList(value)...)
+	return nil
+}
+
+// parseCIDRs parses --web.allow-cidr. A bare address allows just itself.
+func parseCIDRs(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if addr, err := netip.ParseAddr(value); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed CIDR %q: %w", value, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	if len(prefixes) == 0 {
+		return nil, nil
+	}
+	return prefixes, nil
+}
+
+func parseList(list string) []string {
 	if list == "" {
 		return nil
 	}
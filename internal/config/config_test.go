@@ -2,6 +2,9 @@ package config
 
 import (
 	"log/slog"
+	"maps"
+	"net/netip"
+	"slices"
 	"testing"
 	"time"
 )
@@ -26,9 +29,21 @@ func TestParseDefaults(t *testing.T) {
 	if cfg.ScrapeTimeout != defaultTimeout {
 		t.Fatalf("expected scrape timeout %v, got %v", defaultTimeout, cfg.ScrapeTimeout)
 	}
+	if cfg.StartupGracePeriod != defaultStartupGracePeriod {
+		t.Fatalf("expected startup grace period %v, got %v", defaultStartupGracePeriod, cfg.StartupGracePeriod)
+	}
 	if !cfg.EnableRoCEPFCMetrics {
 		t.Fatalf("expected RoCE PFC metrics to be enabled by default")
 	}
+	if cfg.EnableRawAPI {
+		t.Fatalf("expected raw API to be disabled by default")
+	}
+	if cfg.AdaptiveBudget {
+		t.Fatalf("expected adaptive budget to be disabled by default")
+	}
+	if cfg.MetricsSchema != defaultMetricsSchema {
+		t.Fatalf("expected metrics schema %d by default, got %d", defaultMetricsSchema, cfg.MetricsSchema)
+	}
 	if cfg.ShowVersion {
 		t.Fatalf("expected show version to be false by default")
 	}
@@ -96,6 +111,52 @@ func TestRoCEPFCMetricsToggleRejectsInvalidEnv(t *testing.T) {
 	}
 }
 
+func TestSysfsOnly(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_SYSFS_ONLY", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.SysfsOnly || cfg.EnableRoCEPFCMetrics {
+		t.Fatalf("expected sysfs-only mode with RoCE PFC metrics off, got %+v", cfg)
+	}
+
+	if _, err := Parse([]string{"--provider=netlink"}); err == nil {
+		t.Fatalf("expected error for the netlink provider in sysfs-only mode")
+	}
+	if _, err := Parse([]string{"--collect.dcb"}); err == nil {
+		t.Fatalf("expected error for dcbnl collection in sysfs-only mode")
+	}
+	if _, err := Parse([]string{"--sysfs-only=false", "--collect.dcb"}); err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+}
+
+func TestEnableRawAPIFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_ENABLE_RAW_API", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.EnableRawAPI {
+		t.Fatalf("expected raw API to be enabled by env")
+	}
+}
+
+func TestAdaptiveBudgetFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_ADAPTIVE_BUDGET", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.AdaptiveBudget {
+		t.Fatalf("expected adaptive budget to be enabled by env")
+	}
+}
+
 func TestInvalidDurationFromEnv(t *testing.T) {
 	t.Setenv("RDMA_EXPORTER_SCRAPE_TIMEOUT", "notaduration")
 
@@ -146,6 +207,97 @@ func TestExcludeDevicesFromEnv(t *testing.T) {
 	}
 }
 
+func TestAllowedSysfsRootsFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_SYSFS_ROOT_ALLOWED_PREFIXES", "/sys, /host/sys")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	if !slices.Equal(cfg.AllowedSysfsRoots, []string{"/sys", "/host/sys"}) {
+		t.Fatalf("expected [/sys /host/sys], got %v", cfg.AllowedSysfsRoots)
+	}
+}
+
+func TestRailLabels(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   string
+		enabled bool
+		rails   map[string]string
+		wantErr bool
+	}{
+		{name: "disabled", value: ""},
+		{name: "auto", value: "auto", enabled: true},
+		{name: "map", value: "mlx5_0=rail0, mlx5_4=storage", enabled: true, rails: map[string]string{"mlx5_0": "rail0", "mlx5_4": "storage"}},
+		{name: "missing rail", value: "mlx5_0=", wantErr: true},
+		{name: "missing separator", value: "mlx5_0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := Parse([]string{"--collect.rail-labels", tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.RailLabels != tt.enabled {
+				t.Fatalf("expected rail labels %v, got %v", tt.enabled, cfg.RailLabels)
+			}
+			if !maps.Equal(cfg.Rails, tt.rails) {
+				t.Fatalf("expected rails %v, got %v", tt.rails, cfg.Rails)
+			}
+		})
+	}
+}
+
+func TestPortRoles(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []PortRole
+		wantErr bool
+	}{
+		{name: "disabled"},
+		{
+			name: "cidr and vlan",
+			args: []string{"--collect.port-role", "storage=vlan:100, compute=10.1.2.3/16", "--collect.port-role", "management=2001:db8::/32"},
+			want: []PortRole{
+				{Role: "storage", VLAN: 100},
+				{Role: "compute", Prefix: netip.MustParsePrefix("10.1.0.0/16")},
+				{Role: "management", Prefix: netip.MustParsePrefix("2001:db8::/32")},
+			},
+		},
+		{name: "missing role", args: []string{"--collect.port-role", "=10.0.0.0/8"}, wantErr: true},
+		{name: "invalid cidr", args: []string{"--collect.port-role", "storage=10.0.0.0/33"}, wantErr: true},
+		{name: "invalid vlan", args: []string{"--collect.port-role", "storage=vlan:4095"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !slices.Equal(cfg.PortRoles, tt.want) {
+				t.Fatalf("expected port roles %v, got %v", tt.want, cfg.PortRoles)
+			}
+		})
+	}
+}
+
 func TestExcludeDevicesEmpty(t *testing.T) {
 	t.Parallel()
 
@@ -159,7 +311,7 @@ func TestExcludeDevicesEmpty(t *testing.T) {
 	}
 }
 
-func TestParseDeviceList(t *testing.T) {
+func TestParseList(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
@@ -177,14 +329,14 @@ func TestParseDeviceList(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := parseDeviceList(tt.input)
+			got := parseList(tt.input)
 			if len(got) != len(tt.want) {
-				t.Errorf("parseDeviceList(%q) length = %d, want %d", tt.input, len(got), len(tt.want))
+				t.Errorf("parseList(%q) length = %d, want %d", tt.input, len(got), len(tt.want))
 				return
 			}
 			for i := range got {
 				if got[i] != tt.want[i] {
-					t.Errorf("parseDeviceList(%q)[%d] = %q, want %q", tt.input, i, got[i], tt.want[i])
+					t.Errorf("parseList(%q)[%d] = %q, want %q", tt.input, i, got[i], tt.want[i])
 				}
 			}
 		})
@@ -195,3 +347,681 @@ func defaultLogLevelValue() slog.Level {
 	lvl, _ := parseLogLevel(defaultLogLevel)
 	return lvl
 }
+
+func TestFabricIPv4PrefixLengthValidation(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse([]string{"--fabric-ipv4-prefix-length", "16"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.FabricIPv4PrefixLen != 16 {
+		t.Fatalf("expected prefix length 16, got %d", cfg.FabricIPv4PrefixLen)
+	}
+
+	if _, err := Parse([]string{"--fabric-ipv4-prefix-length", "33"}); err == nil {
+		t.Fatalf("expected error for out-of-range prefix length")
+	}
+}
+
+func TestSuppressUnchangedFlags(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse([]string{"--collect.suppress-unchanged-after", "5", "--collect.suppress-unchanged-keepalive", "0"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.SuppressAfter != 5 || cfg.SuppressKeepAlive != 0 {
+		t.Fatalf("expected after=5 keepalive=0, got after=%d keepalive=%d", cfg.SuppressAfter, cfg.SuppressKeepAlive)
+	}
+
+	if _, err := Parse([]string{"--collect.suppress-unchanged-after", "-1"}); err == nil {
+		t.Fatalf("expected error for negative suppression threshold")
+	}
+}
+
+func TestChangeDetectionFlags(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse([]string{"--collect.attribute-refresh", "30", "--collect.stable-counter-after", "5"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.AttributeRefresh != 30 || cfg.StableCounterAfter != 5 || cfg.StableCounterRefresh != defaultStableCounterRefresh {
+		t.Fatalf("unexpected change detection settings: refresh=%d after=%d counter refresh=%d",
+			cfg.AttributeRefresh, cfg.StableCounterAfter, cfg.StableCounterRefresh)
+	}
+
+	for _, args := range [][]string{
+		{"--collect.attribute-refresh", "-1"},
+		{"--collect.stable-counter-after", "-1"},
+		{"--collect.stable-counter-refresh", "0"},
+	} {
+		if _, err := Parse(args); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+}
+
+func TestRetryPolicyFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_SYSFS_RETRY_ATTEMPTS", "5")
+	t.Setenv("RDMA_EXPORTER_SYSFS_RETRY_BACKOFF", "250ms")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.RetryAttempts != 5 || cfg.RetryBackoff != 250*time.Millisecond {
+		t.Fatalf("expected 5 attempts with 250ms backoff, got %d and %s", cfg.RetryAttempts, cfg.RetryBackoff)
+	}
+
+	if _, err := Parse([]string{"--sysfs.retry-attempts", "0"}); err == nil {
+		t.Fatalf("expected error for zero retry attempts")
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	t.Parallel()
+
+	parse := func(args ...string) Config {
+		t.Helper()
+		cfg, err := Parse(args)
+		if err != nil {
+			t.Fatalf("Parse returned error: %v", err)
+		}
+		return cfg
+	}
+
+	base := parse("--collect.rail-labels", "mlx5_0=rail0,mlx5_1=rail1")
+	if len(base.Hash()) != 16 {
+		t.Fatalf("expected a 16 character hash, got %q", base.Hash())
+	}
+	if got := parse("--collect.rail-labels", "mlx5_1=rail1,mlx5_0=rail0").Hash(); got != base.Hash() {
+		t.Fatalf("expected equal configs to hash equally, got %s and %s", got, base.Hash())
+	}
+	if got := parse("--collect.rail-labels", "mlx5_0=rail0,mlx5_1=rail1", "--version").Hash(); got != base.Hash() {
+		t.Fatalf("expected --version not to change the hash")
+	}
+	if got := parse("--collect.rail-labels", "mlx5_0=rail0,mlx5_1=rail1", "--scrape-timeout", "9s").Hash(); got == base.Hash() {
+		t.Fatalf("expected a different scrape timeout to change the hash")
+	}
+}
+
+func TestParseMetricsSchema(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{input: "1", want: 1},
+		{input: "2", want: 2},
+		{input: "v2", want: 2},
+		{input: "3", wantErr: true},
+		{input: "0", wantErr: true},
+		{input: "latest", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMetricsSchema(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("parseMetricsSchema(%q): expected error", tt.input)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Fatalf("parseMetricsSchema(%q) = %d, %v; want %d", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestNoDevicesPolicy(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.NoDevicesPolicy != NoDevicesWarn {
+		t.Fatalf("expected %q by default, got %q", NoDevicesWarn, cfg.NoDevicesPolicy)
+	}
+
+	cfg, err = Parse([]string{"--startup.no-devices", "wait"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.NoDevicesPolicy != NoDevicesWait {
+		t.Fatalf("expected %q, got %q", NoDevicesWait, cfg.NoDevicesPolicy)
+	}
+
+	if _, err := Parse([]string{"--startup.no-devices", "ignore"}); err == nil {
+		t.Fatalf("expected error for unknown policy")
+	}
+}
+
+func TestDeviceDedup(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.DeviceDedup != DeviceDedupOff {
+		t.Fatalf("expected %q by default, got %q", DeviceDedupOff, cfg.DeviceDedup)
+	}
+
+	cfg, err = Parse([]string{"--collect.device-dedup", "pci"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.DeviceDedup != DeviceDedupPCI {
+		t.Fatalf("expected %q, got %q", DeviceDedupPCI, cfg.DeviceDedup)
+	}
+
+	if _, err := Parse([]string{"--collect.device-dedup", "port"}); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+}
+
+func TestSilenceAPI(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_STATE_FILE", "/var/lib/rdma_exporter/state.json")
+
+	cfg, err := Parse([]string{"--enable-silence-api"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.EnableSilenceAPI || cfg.StateFile != "/var/lib/rdma_exporter/state.json" {
+		t.Fatalf("unexpected silence config: enabled=%v state file %q", cfg.EnableSilenceAPI, cfg.StateFile)
+	}
+}
+
+func TestAllowCIDR(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_WEB_ALLOW_CIDR", "192.0.2.0/24")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if want := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}; !slices.Equal(cfg.AllowedCIDRs, want) {
+		t.Fatalf("expected %v from the environment, got %v", want, cfg.AllowedCIDRs)
+	}
+
+	// Flags replace the environment and may be repeated.
+	cfg, err = Parse([]string{"--web.allow-cidr", "10.1.2.3/8,2001:db8::/32", "--web.allow-cidr", "198.51.100.7"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("198.51.100.7/32"),
+	}
+	if !slices.Equal(cfg.AllowedCIDRs, want) {
+		t.Fatalf("expected %v, got %v", want, cfg.AllowedCIDRs)
+	}
+
+	if _, err := Parse([]string{"--web.allow-cidr", "10.0.0.0/33"}); err == nil {
+		t.Fatalf("expected error for invalid CIDR")
+	}
+}
+
+func TestGaugeCounters(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_GAUGE_COUNTERS", "fw_buffer_occupancy")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !slices.Equal(cfg.GaugeCounters, []string{"fw_buffer_occupancy"}) {
+		t.Fatalf("unexpected gauge counters from env %v", cfg.GaugeCounters)
+	}
+
+	if cfg.BuiltinGaugeCounters {
+		t.Fatalf("expected built-in gauge counters to be off by default")
+	}
+
+	cfg, err = Parse([]string{"--collect.gauge-counters", "fw_buffer_occupancy, fw_credits", "--collect.builtin-gauge-counters"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !slices.Equal(cfg.GaugeCounters, []string{"fw_buffer_occupancy", "fw_credits"}) {
+		t.Fatalf("unexpected gauge counters %v", cfg.GaugeCounters)
+	}
+	if !cfg.BuiltinGaugeCounters {
+		t.Fatalf("expected built-in gauge counters to be enabled")
+	}
+}
+
+func TestTopCounters(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.TopCounters != 0 || cfg.TopCountersWindow != 5*time.Minute {
+		t.Fatalf("unexpected default top counters %d over %s", cfg.TopCounters, cfg.TopCountersWindow)
+	}
+
+	cfg, err = Parse([]string{"--collect.top-counters", "10", "--collect.top-counters-window", "1m"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.TopCounters != 10 || cfg.TopCountersWindow != time.Minute {
+		t.Fatalf("unexpected top counters %d over %s", cfg.TopCounters, cfg.TopCountersWindow)
+	}
+
+	for _, args := range [][]string{
+		{"--collect.top-counters", "-1"},
+		{"--collect.top-counters-window", "0s"},
+	} {
+		if _, err := Parse(args); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+}
+
+func TestLinkRecoveryBurst(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.RecoveryBurst != 3 || cfg.RecoveryBurstWindow != time.Minute {
+		t.Fatalf("unexpected default link recovery burst %d within %s", cfg.RecoveryBurst, cfg.RecoveryBurstWindow)
+	}
+
+	cfg, err = Parse([]string{"--collect.link-recovery-burst", "5", "--collect.link-recovery-burst-window", "2m"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.RecoveryBurst != 5 || cfg.RecoveryBurstWindow != 2*time.Minute {
+		t.Fatalf("unexpected link recovery burst %d within %s", cfg.RecoveryBurst, cfg.RecoveryBurstWindow)
+	}
+
+	for _, args := range [][]string{
+		{"--collect.link-recovery-burst", "-1"},
+		{"--collect.link-recovery-burst-window", "0s"},
+	} {
+		if _, err := Parse(args); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+}
+
+func TestCounterSpecsFileFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_COUNTER_SPECS_FILE", "/etc/rdma_exporter/counters.yaml")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.CounterSpecsFile != "/etc/rdma_exporter/counters.yaml" {
+		t.Fatalf("unexpected counter specs file %q", cfg.CounterSpecsFile)
+	}
+}
+
+func TestConditionsFileFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_CONDITIONS_FILE", "/etc/rdma_exporter/conditions.yaml")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.ConditionsFile != "/etc/rdma_exporter/conditions.yaml" {
+		t.Fatalf("unexpected conditions file %q", cfg.ConditionsFile)
+	}
+}
+
+func TestRateJitter(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse([]string{"--collect.rate-jitter-counters", "port_xmit_data, port_rcv_data", "--collect.rate-jitter-window", "30"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !slices.Equal(cfg.RateJitterCounters, []string{"port_xmit_data", "port_rcv_data"}) || cfg.RateJitterWindow != 30 {
+		t.Fatalf("unexpected rate jitter config %v over %d", cfg.RateJitterCounters, cfg.RateJitterWindow)
+	}
+
+	if _, err := Parse([]string{"--collect.rate-jitter-window", "1"}); err == nil {
+		t.Fatalf("expected error for window below 2")
+	}
+}
+
+func TestInfluxOutputFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_OUTPUT_INFLUX_URL", "udp://influx:8089")
+	t.Setenv("RDMA_EXPORTER_OUTPUT_INFLUX_INTERVAL", "10s")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.InfluxURL != "udp://influx:8089" || cfg.InfluxInterval != 10*time.Second {
+		t.Fatalf("unexpected influx output %q every %s", cfg.InfluxURL, cfg.InfluxInterval)
+	}
+
+	if _, err := Parse([]string{"--output.influx.interval", "0s"}); err == nil {
+		t.Fatalf("expected error for zero interval")
+	}
+}
+
+func TestTickDurationFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_TICK_DURATION", "4us")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.TickDuration != 4*time.Microsecond {
+		t.Fatalf("expected tick duration 4us, got %s", cfg.TickDuration)
+	}
+
+	cfg, err = Parse([]string{"--collect.tick-duration", "2us"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.TickDuration != 2*time.Microsecond {
+		t.Fatalf("expected the flag to override the environment, got %s", cfg.TickDuration)
+	}
+
+	if _, err := Parse([]string{"--collect.tick-duration", "-1us"}); err == nil {
+		t.Fatalf("expected error for a negative tick duration")
+	}
+}
+
+func TestQPCountersFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_QP_COUNTERS", "true")
+	t.Setenv("RDMA_EXPORTER_COLLECT_QP_COUNTERS_LIMIT", "32")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.CollectQPCounters || cfg.QPCounterLimit != 32 {
+		t.Fatalf("expected qp counters limited to 32, got %t and %d", cfg.CollectQPCounters, cfg.QPCounterLimit)
+	}
+
+	if _, err := Parse([]string{"--collect.qp-counters.limit", "0"}); err == nil {
+		t.Fatalf("expected error for zero qp counter limit")
+	}
+}
+
+func TestEthtoolClients(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_ETHTOOL_CLIENTS", "8")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.EthtoolClients != 8 {
+		t.Fatalf("expected 8 ethtool clients, got %d", cfg.EthtoolClients)
+	}
+
+	if _, err := Parse([]string{"--collect.ethtool-clients", "0"}); err == nil {
+		t.Fatalf("expected error for zero ethtool clients")
+	}
+}
+
+func TestNetDevEthtoolStats(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse([]string{"--collect.netdev-ethtool-stats", "rx_discards_phy, rx_vport_rdma_*"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if want := []string{"rx_discards_phy", "rx_vport_rdma_*"}; !slices.Equal(cfg.NetDevEthtoolStats, want) {
+		t.Fatalf("expected ethtool stats %v, got %v", want, cfg.NetDevEthtoolStats)
+	}
+
+	if _, err := Parse([]string{"--collect.netdev-ethtool-stats", "rx_[prio"}); err == nil {
+		t.Fatalf("expected error for malformed pattern")
+	}
+}
+
+func TestNetDevStatisticsFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_NETDEV_STATISTICS", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.CollectNetDevStats {
+		t.Fatalf("expected netdev statistics to be enabled from env")
+	}
+}
+
+func TestGIDTableFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_GID_TABLE", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.CollectGIDTable {
+		t.Fatalf("expected gid table to be enabled from env")
+	}
+}
+
+func TestPKeyTableFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_PKEY_TABLE", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.CollectPKeyTable {
+		t.Fatalf("expected pkey table to be enabled from env")
+	}
+}
+
+func TestUEventsFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_UEVENTS", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.CollectUEvents {
+		t.Fatalf("expected uevents to be enabled from env")
+	}
+}
+
+func TestCollectProfileFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_ENABLE_COLLECT_PROFILE", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.EnableCollectProfile {
+		t.Fatalf("expected collect profile endpoint to be enabled from env")
+	}
+}
+
+func TestRoCEConfigFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_ROCE_CONFIG", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.CollectRoCEConfig {
+		t.Fatalf("expected roce config to be enabled from env")
+	}
+}
+
+func TestDCBFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_DCB", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.CollectDCB {
+		t.Fatalf("expected dcb to be enabled from env")
+	}
+}
+
+func TestByteCountersFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_BYTE_COUNTERS", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.ByteCounters {
+		t.Fatalf("expected byte counters to be enabled from env")
+	}
+}
+
+func TestWarmupFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_WARMUP", "5m")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.Warmup != 5*time.Minute {
+		t.Fatalf("expected warm-up 5m, got %s", cfg.Warmup)
+	}
+
+	if _, err := Parse([]string{"--collect.warmup", "-1s"}); err == nil {
+		t.Fatalf("expected error for negative warm-up")
+	}
+}
+
+func TestUtilizationWindowFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_UTILIZATION_WINDOW", "1m")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.UtilizationWindow != time.Minute {
+		t.Fatalf("expected utilization window 1m, got %s", cfg.UtilizationWindow)
+	}
+
+	if _, err := Parse([]string{"--collect.utilization-window", "-1s"}); err == nil {
+		t.Fatalf("expected error for negative utilization window")
+	}
+}
+
+func TestCollectorTimeouts(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_GID_TABLE_TIMEOUT", "2s")
+
+	cfg, err := Parse([]string{"--collect.netdev-link.timeout", "1s"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	want := map[string]time.Duration{"gid_table": 2 * time.Second, "netdev_link": time.Second}
+	if !maps.Equal(cfg.CollectorTimeouts, want) {
+		t.Fatalf("expected collector timeouts %v, got %v", want, cfg.CollectorTimeouts)
+	}
+
+	if _, err := Parse([]string{"--collect.counters.timeout", "-1s"}); err == nil {
+		t.Fatalf("expected error for negative collector timeout")
+	}
+}
+
+func TestH2CFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_WEB_H2C", "true")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.EnableH2C {
+		t.Fatalf("expected h2c to be enabled from env")
+	}
+}
+
+func TestSnapshotLifespanFromEnv(t *testing.T) {
+	t.Setenv("RDMA_EXPORTER_COLLECT_SNAPSHOT_LIFESPAN", "5s")
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.SnapshotLifespan != 5*time.Second {
+		t.Fatalf("expected snapshot lifespan 5s, got %s", cfg.SnapshotLifespan)
+	}
+
+	if _, err := Parse([]string{"--collect.snapshot-lifespan", "-1s"}); err == nil {
+		t.Fatalf("expected error for negative snapshot lifespan")
+	}
+}
+
+func TestRawAPIMaxAge(t *testing.T) {
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.RawAPIMaxAge != time.Minute {
+		t.Fatalf("expected default raw API max age 1m, got %s", cfg.RawAPIMaxAge)
+	}
+
+	t.Setenv("RDMA_EXPORTER_RAW_API_MAX_AGE", "0s")
+	cfg, err = Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if cfg.RawAPIMaxAge != 0 {
+		t.Fatalf("expected raw API max age 0s from env, got %s", cfg.RawAPIMaxAge)
+	}
+
+	if _, err := Parse([]string{"--raw-api.max-age", "-1s"}); err == nil {
+		t.Fatalf("expected error for negative raw API max age")
+	}
+}
+
+func TestResourcesByProcessRequiresResources(t *testing.T) {
+	if _, err := Parse([]string{"--collect.resources.by-process"}); err == nil {
+		t.Fatalf("expected error without --collect.resources")
+	}
+
+	cfg, err := Parse([]string{"--collect.resources", "--collect.resources.by-process"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.ResourcesByProcess {
+		t.Fatalf("expected per-process resources to be enabled")
+	}
+}
+
+func TestKubernetesNodeExpectations(t *testing.T) {
+	t.Setenv("NODE_NAME", "")
+	if _, err := Parse([]string{"--kubernetes.node-expectations"}); err == nil {
+		t.Fatalf("expected error without a node name")
+	}
+
+	t.Setenv("NODE_NAME", "gpu-node-1")
+	cfg, err := Parse([]string{"--kubernetes.node-expectations"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if !cfg.KubernetesExpectations || cfg.KubernetesNodeName != "gpu-node-1" {
+		t.Fatalf("expected expectations of node gpu-node-1, got %t %q", cfg.KubernetesExpectations, cfg.KubernetesNodeName)
+	}
+	if cfg.KubernetesAnnotationPrefix != "rdma-exporter/" || cfg.KubernetesRefreshInterval != time.Minute {
+		t.Fatalf("unexpected defaults: prefix %q, refresh interval %s", cfg.KubernetesAnnotationPrefix, cfg.KubernetesRefreshInterval)
+	}
+
+	other, err := Parse([]string{"--kubernetes.node-expectations", "--kubernetes.node-name", "gpu-node-2"})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if other.KubernetesNodeName != "gpu-node-2" {
+		t.Fatalf("expected the flag to override NODE_NAME, got %q", other.KubernetesNodeName)
+	}
+	if other.Hash() != cfg.Hash() {
+		t.Fatalf("expected the node name not to change the hash")
+	}
+
+	if _, err := Parse([]string{"--kubernetes.refresh-interval", "0s"}); err == nil {
+		t.Fatalf("expected error for a zero refresh interval")
+	}
+}
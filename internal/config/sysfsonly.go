@@ -0,0 +1,7 @@
+//go:build !sysfs_only
+
+package config
+
+// defaultSysfsOnly is the default of --sysfs-only; binaries built with the
+// sysfs_only tag default to it.
+const defaultSysfsOnly = false
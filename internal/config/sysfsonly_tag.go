@@ -0,0 +1,5 @@
+//go:build sysfs_only
+
+package config
+
+const defaultSysfsOnly = true
@@ -0,0 +1,298 @@
+// Package grpcapi serves the experimental gRPC API defined in pkg/api/rdmav1.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+	"github.com/yuuki/rdma_exporter/pkg/api/rdmav1"
+)
+
+const (
+	// DefaultStreamInterval is used when StreamCounters is called without an
+	// interval.
+	DefaultStreamInterval = 10 * time.Second
+	// MinStreamInterval bounds how often a stream sends a snapshot.
+	MinStreamInterval = time.Second
+)
+
+// DeviceSource returns a device snapshot and when it was read, reading the
+// devices only when the last read is older than maxAge.
+// *collector.RdmaCollector implements it, so the gRPC API serves what the
+// last scrape read, with the same device exclusions as the exposition.
+type DeviceSource interface {
+	DeviceSnapshot(ctx context.Context, maxAge time.Duration) ([]rdma.Device, time.Time, error)
+}
+
+// Options contains the configuration of the gRPC server.
+type Options struct {
+	ListenAddress string
+	// ListenAddresses, when set, replaces ListenAddress with explicit
+	// host:port pairs, the addresses of --web.listen-interface.
+	ListenAddresses []string
+	// ScrapeTimeout bounds each device snapshot.
+	ScrapeTimeout time.Duration
+	// MaxAge is how old the last scrape's devices may be before a snapshot
+	// reads them again (0 reads them for every snapshot).
+	MaxAge time.Duration
+	// AllowedCIDRs, when set, restricts every RPC to these source ranges,
+	// like --web.allow-cidr does for the HTTP endpoints.
+	AllowedCIDRs []netip.Prefix
+	// Rejected, when set, counts the RPCs refused by AllowedCIDRs.
+	Rejected prometheus.Counter
+}
+
+// Server serves the RdmaExporter gRPC service.
+type Server struct {
+	rdmav1.UnimplementedRdmaExporterServer
+
+	grpcServer      *grpc.Server
+	allowed         []netip.Prefix
+	rejected        prometheus.Counter
+	listenAddress   string
+	listenAddresses []string
+	devices         DeviceSource
+	logger          *slog.Logger
+	scrapeTimeout   time.Duration
+	maxAge          time.Duration
+	minInterval     time.Duration
+	now             func() time.Time
+}
+
+// New constructs a Server reading devices from source.
+func New(opts Options, source DeviceSource, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	s := &Server{
+		allowed:         opts.AllowedCIDRs,
+		rejected:        opts.Rejected,
+		listenAddress:   opts.ListenAddress,
+		listenAddresses: opts.ListenAddresses,
+		devices:         source,
+		logger:          logger,
+		scrapeTimeout:   opts.ScrapeTimeout,
+		maxAge:          opts.MaxAge,
+		minInterval:     MinStreamInterval,
+		now:             time.Now,
+	}
+	s.grpcServer = grpc.NewServer(
+		grpc.UnaryInterceptor(s.restrictUnary),
+		grpc.StreamInterceptor(s.restrictStream),
+	)
+	rdmav1.RegisterRdmaExporterServer(s.grpcServer, s)
+	return s
+}
+
+// checkSource refuses RPCs whose peer address is in none of the allowed
+// source ranges. An empty allowed list lets every RPC through.
+func (s *Server) checkSource(ctx context.Context, method string) error {
+	if len(s.allowed) == 0 {
+		return nil
+	}
+	p, ok := peer.FromContext(ctx)
+	if ok && sourceAllowed(p.Addr, s.allowed) {
+		return nil
+	}
+	if s.rejected != nil {
+		s.rejected.Inc()
+	}
+	remote := ""
+	if ok {
+		remote = p.Addr.String()
+	}
+	s.logger.Debug("rejected grpc call from disallowed source", "method", method, "remote", remote)
+	return status.Error(codes.PermissionDenied, "forbidden")
+}
+
+func (s *Server) restrictUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.checkSource(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) restrictStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.checkSource(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// sourceAllowed reports whether addr falls into one of allowed. IPv4-mapped
+// IPv6 sources match IPv4 prefixes.
+func sourceAllowed(addr net.Addr, allowed []netip.Prefix) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range allowed {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenAndServe listens on the configured addresses and serves until
+// Shutdown.
+func (s *Server) ListenAndServe() error {
+	addrs := s.listenAddresses
+	if len(addrs) == 0 {
+		addrs = []string{s.listenAddress}
+	}
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("listen on %s: %w", addr, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	errCh := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			errCh <- s.Serve(ln)
+		}(ln)
+	}
+
+	var firstErr error
+	for range listeners {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+			// Stop the remaining listeners so the caller sees the failure.
+			s.grpcServer.Stop()
+		}
+	}
+	return firstErr
+}
+
+// Serve serves on ln until Shutdown.
+func (s *Server) Serve(ln net.Listener) error {
+	err := s.grpcServer.Serve(ln)
+	if errors.Is(err, grpc.ErrServerStopped) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting RPCs and waits for running ones. Streams only end
+// when their clients cancel them, so it stops forcibly once ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// GetDevices implements rdmav1.RdmaExporterServer.
+func (s *Server) GetDevices(ctx context.Context, _ *rdmav1.GetDevicesRequest) (*rdmav1.Snapshot, error) {
+	return s.snapshot(ctx)
+}
+
+// StreamCounters implements rdmav1.RdmaExporterServer.
+func (s *Server) StreamCounters(req *rdmav1.StreamCountersRequest, stream grpc.ServerStreamingServer[rdmav1.Snapshot]) error {
+	interval := DefaultStreamInterval
+	if req.GetInterval() != nil {
+		interval = max(req.GetInterval().AsDuration(), s.minInterval)
+	}
+
+	ctx := stream.Context()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		snapshot, err := s.snapshot(ctx)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(snapshot); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) snapshot(ctx context.Context) (*rdmav1.Snapshot, error) {
+	if s.scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.scrapeTimeout)
+		defer cancel()
+	}
+
+	devices, readAt, err := s.devices.DeviceSnapshot(ctx, s.maxAge)
+	if err != nil && !errors.Is(err, rdma.ErrNoDevices) {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		s.logger.Warn("grpc snapshot failed", "err", err)
+		return nil, status.Error(codes.Internal, "device snapshot failed")
+	}
+	return newSnapshot(readAt, max(s.now().Sub(readAt), 0), devices), nil
+}
+
+// newSnapshot converts devices the same way the raw JSON API does, keeping
+// counters and hw_counters apart since the same file name can appear in both.
+func newSnapshot(readAt time.Time, age time.Duration, devices []rdma.Device) *rdmav1.Snapshot {
+	snapshot := &rdmav1.Snapshot{
+		Timestamp: timestamppb.New(readAt),
+		Age:       durationpb.New(age),
+		Devices:   make([]*rdmav1.Device, 0, len(devices)),
+	}
+	for _, device := range devices {
+		ports := make([]*rdmav1.Port, 0, len(device.Ports))
+		for _, port := range device.Ports {
+			ports = append(ports, &rdmav1.Port{
+				Id:         uint32(port.ID),
+				Counters:   maps.Clone(port.Stats),
+				HwCounters: maps.Clone(port.HwStats),
+			})
+		}
+		snapshot.Devices = append(snapshot.Devices, &rdmav1.Device{Name: device.Name, Ports: ports})
+	}
+	sort.Slice(snapshot.Devices, func(i, j int) bool {
+		return snapshot.Devices[i].GetName() < snapshot.Devices[j].GetName()
+	})
+	return snapshot
+}
@@ -0,0 +1,220 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+	"github.com/yuuki/rdma_exporter/pkg/api/rdmav1"
+)
+
+type stubSource struct {
+	devices []rdma.Device
+	readAt  time.Time
+	err     error
+}
+
+func (s *stubSource) DeviceSnapshot(context.Context, time.Duration) ([]rdma.Device, time.Time, error) {
+	return s.devices, s.readAt, s.err
+}
+
+func newTestClient(t *testing.T, source DeviceSource) (*Server, rdmav1.RdmaExporterClient) {
+	t.Helper()
+
+	srv := New(Options{}, source, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv.minInterval = time.Millisecond
+	srv.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	ln := bufconn.Listen(1 << 20)
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { srv.grpcServer.Stop() })
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return srv, rdmav1.NewRdmaExporterClient(conn)
+}
+
+func testDevices() []rdma.Device {
+	return []rdma.Device{
+		{
+			Name: "mlx5_1",
+			Ports: []rdma.Port{{
+				ID:      1,
+				Stats:   map[string]uint64{"port_xmit_data": 10},
+				HwStats: map[string]uint64{"out_of_buffer": 3, "port_xmit_data": 11},
+			}},
+		},
+		{Name: "mlx5_0", Ports: []rdma.Port{{ID: 1, Stats: map[string]uint64{"port_rcv_data": 5}}}},
+	}
+}
+
+func TestGetDevices(t *testing.T) {
+	t.Parallel()
+
+	_, client := newTestClient(t, &stubSource{devices: testDevices(), readAt: time.Unix(1700000000-30, 0)})
+	snapshot, err := client.GetDevices(context.Background(), &rdmav1.GetDevicesRequest{})
+	if err != nil {
+		t.Fatalf("GetDevices returned error: %v", err)
+	}
+
+	if got := snapshot.GetTimestamp().AsTime(); !got.Equal(time.Unix(1700000000-30, 0)) {
+		t.Fatalf("expected the read time as timestamp, got %v", got)
+	}
+	if got := snapshot.GetAge().AsDuration(); got != 30*time.Second {
+		t.Fatalf("expected an age of 30s, got %v", got)
+	}
+	devices := snapshot.GetDevices()
+	if len(devices) != 2 || devices[0].GetName() != "mlx5_0" || devices[1].GetName() != "mlx5_1" {
+		t.Fatalf("expected devices sorted by name, got %v", devices)
+	}
+	port := devices[1].GetPorts()[0]
+	if counters := port.GetCounters(); len(counters) != 1 || counters["port_xmit_data"] != 10 {
+		t.Fatalf("expected only the sysfs counters, got %v", counters)
+	}
+	if hw := port.GetHwCounters(); len(hw) != 2 || hw["out_of_buffer"] != 3 || hw["port_xmit_data"] != 11 {
+		t.Fatalf("expected hw_counters to be kept apart, got %v", hw)
+	}
+}
+
+func TestGetDevicesError(t *testing.T) {
+	t.Parallel()
+
+	_, client := newTestClient(t, &stubSource{err: errors.New("boom")})
+	_, err := client.GetDevices(context.Background(), &rdmav1.GetDevicesRequest{})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected Internal, got %v", err)
+	}
+}
+
+func TestStreamCounters(t *testing.T) {
+	t.Parallel()
+
+	_, client := newTestClient(t, &stubSource{devices: testDevices()})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.StreamCounters(ctx, &rdmav1.StreamCountersRequest{Interval: durationpb.New(time.Millisecond)})
+	if err != nil {
+		t.Fatalf("StreamCounters returned error: %v", err)
+	}
+	for i := range 3 {
+		snapshot, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv %d returned error: %v", i, err)
+		}
+		if len(snapshot.GetDevices()) != 2 {
+			t.Fatalf("expected 2 devices, got %d", len(snapshot.GetDevices()))
+		}
+	}
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Fatalf("expected Canceled after cancel, got %v", err)
+	}
+}
+
+func TestShutdownStopsStreams(t *testing.T) {
+	t.Parallel()
+
+	srv, client := newTestClient(t, &stubSource{devices: testDevices()})
+	stream, err := client.StreamCounters(context.Background(), &rdmav1.StreamCountersRequest{Interval: durationpb.New(time.Hour)})
+	if err != nil {
+		t.Fatalf("StreamCounters returned error: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv returned error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected forced stop after deadline, got %v", err)
+	}
+	if _, err := stream.Recv(); err == nil {
+		t.Fatalf("expected stream to end after shutdown")
+	}
+}
+
+func TestListenAndServeClosesListenersOnFailure(t *testing.T) {
+	t.Parallel()
+
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer taken.Close()
+
+	srv := New(Options{ListenAddresses: []string{"127.0.0.1:0", taken.Addr().String()}}, &stubSource{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := srv.ListenAndServe(); err == nil {
+		t.Fatal("expected an error when one of the addresses is in use")
+	}
+}
+
+func TestAllowedCIDRs(t *testing.T) {
+	t.Parallel()
+
+	rejected := prometheus.NewCounter(prometheus.CounterOpts{Name: "rejected_total"})
+	newClient := func(allowed string) rdmav1.RdmaExporterClient {
+		t.Helper()
+		srv := New(Options{
+			AllowedCIDRs: []netip.Prefix{netip.MustParsePrefix(allowed)},
+			Rejected:     rejected,
+		}, &stubSource{devices: testDevices()}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		go func() { _ = srv.Serve(ln) }()
+		t.Cleanup(func() { srv.grpcServer.Stop() })
+
+		conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return rdmav1.NewRdmaExporterClient(conn)
+	}
+
+	denied := newClient("10.0.0.0/8")
+	if _, err := denied.GetDevices(context.Background(), &rdmav1.GetDevicesRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied from GetDevices, got %v", err)
+	}
+	stream, err := denied.StreamCounters(context.Background(), &rdmav1.StreamCountersRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied from StreamCounters, got %v", err)
+	}
+	if got := testutil.ToFloat64(rejected); got != 2 {
+		t.Fatalf("expected 2 rejected calls, got %v", got)
+	}
+
+	allowed := newClient("127.0.0.0/8")
+	if _, err := allowed.GetDevices(context.Background(), &rdmav1.GetDevicesRequest{}); err != nil {
+		t.Fatalf("expected an allowed source to be served, got %v", err)
+	}
+	if got := testutil.ToFloat64(rejected); got != 2 {
+		t.Fatalf("expected allowed calls not to be counted, got %v rejected", got)
+	}
+}
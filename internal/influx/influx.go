@@ -0,0 +1,321 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Options configures a Writer.
+type Options struct {
+	// URL is where samples are written: http:// and https:// URLs receive a
+	// POST per interval (e.g. an InfluxDB /write or /api/v2/write endpoint);
+	// tcp://host:port, udp://host:port and unix:///path are written to as
+	// sockets; file:///path is appended to.
+	URL string
+	// Interval is the time between two writes.
+	Interval time.Duration
+	// Timeout bounds a single gather and write.
+	Timeout time.Duration
+}
+
+// Source returns the exporter's last exposition and when it was gathered,
+// gathering anew when it is older than maxAge. *server.Exposition implements
+// it, so writes reuse the samples of the last scrape instead of collecting
+// as if they were scrapes themselves.
+type Source interface {
+	Last(ctx context.Context, maxAge time.Duration) ([]*dto.MetricFamily, time.Time, error)
+}
+
+// Writer periodically writes the exporter's metrics in InfluxDB line
+// protocol.
+type Writer struct {
+	opts   Options
+	target *url.URL
+	source Source
+	logger *slog.Logger
+	client *http.Client
+}
+
+// New validates opts and returns a Writer for source.
+func New(opts Options, source Source, logger *slog.Logger) (*Writer, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	target, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse influx url: %w", err)
+	}
+	switch target.Scheme {
+	case "http", "https":
+	case "tcp", "udp":
+		if target.Host == "" {
+			return nil, fmt.Errorf("influx url %q has no host", opts.URL)
+		}
+	case "unix", "file":
+		if target.Path == "" {
+			return nil, fmt.Errorf("influx url %q has no path", opts.URL)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported influx url scheme %q: must be http, https, tcp, udp, unix or file", target.Scheme)
+	}
+	if opts.Interval <= 0 {
+		return nil, errors.New("influx write interval must be positive")
+	}
+	return &Writer{
+		opts:   opts,
+		target: target,
+		source: source,
+		logger: logger,
+		client: &http.Client{},
+	}, nil
+}
+
+// Run writes every interval until ctx is done. Failed writes are logged and
+// retried at the next interval; samples of a failed write are dropped.
+func (w *Writer) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.WriteOnce(ctx); err != nil && ctx.Err() == nil {
+				w.logger.Warn("influx write failed", "url", w.redactedURL(), "err", err)
+			}
+		}
+	}
+}
+
+// WriteOnce writes the last exposition to the target, stamped with the time
+// it was gathered. An exposition older than the write interval, e.g. when
+// nothing scrapes the exporter, is gathered anew.
+func (w *Writer) WriteOnce(ctx context.Context) error {
+	if w.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.opts.Timeout)
+		defer cancel()
+	}
+
+	mfs, gatheredAt, err := w.source.Last(ctx, w.opts.Interval)
+	if err != nil && len(mfs) == 0 {
+		return fmt.Errorf("gather: %w", err)
+	}
+	// Partial gathers still carry the exporter's own error counters.
+	var buf bytes.Buffer
+	if err := Encode(&buf, mfs, gatheredAt); err != nil {
+		return err
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return w.send(ctx, buf.Bytes())
+}
+
+func (w *Writer) send(ctx context.Context, data []byte) error {
+	switch w.target.Scheme {
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.target.String(), bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		resp, err := w.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	case "file":
+		f, err := os.OpenFile(w.target.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	default:
+		address := w.target.Host
+		if w.target.Scheme == "unix" {
+			address = w.target.Path
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, w.target.Scheme, address)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetWriteDeadline(deadline)
+		}
+		if w.target.Scheme == "udp" {
+			return writeDatagrams(conn, data)
+		}
+		_, err = conn.Write(data)
+		return err
+	}
+}
+
+// maxDatagram keeps UDP writes below the common 1500 byte MTU.
+const maxDatagram = 1400
+
+// writeDatagrams sends whole lines, packed into datagrams of at most
+// maxDatagram bytes where possible.
+func writeDatagrams(conn net.Conn, data []byte) error {
+	for len(data) > 0 {
+		end := len(data)
+		if end > maxDatagram {
+			end = bytes.LastIndexByte(data[:maxDatagram], '\n') + 1
+			if end == 0 {
+				// A single line longer than maxDatagram goes out on its own.
+				end = bytes.IndexByte(data, '\n') + 1
+			}
+		}
+		if _, err := conn.Write(data[:end]); err != nil {
+			return err
+		}
+		data = data[end:]
+	}
+	return nil
+}
+
+// redactedURL hides the credentials of the target URL.
+func (w *Writer) redactedURL() string {
+	return RedactURL(w.target)
+}
+
+// RedactURL returns target with its credentials hidden: the password of the
+// user info and the p and token query parameters, which InfluxDB accepts
+// credentials as.
+func RedactURL(target *url.URL) string {
+	u := *target
+	if u.RawQuery != "" {
+		q := u.Query()
+		for _, key := range []string{"p", "token"} {
+			if q.Has(key) {
+				q.Set(key, "xxxxx")
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// Encode writes mfs in InfluxDB line protocol, one line per sample. The
+// measurement is the metric name and labels become tags. Fields follow the
+// layout of Telegraf's Prometheus input: counters write "counter", gauges
+// "gauge" and untyped metrics "value"; summaries and histograms write "sum"
+// and "count" plus one field per quantile or bucket bound. NaN and infinite
+// values, which line protocol cannot carry, are skipped.
+func Encode(w io.Writer, mfs []*dto.MetricFamily, now time.Time) error {
+	var b strings.Builder
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			fields := metricFields(mf.GetType(), m)
+			if len(fields) == 0 {
+				continue
+			}
+			b.Reset()
+			b.WriteString(measurementEscaper.Replace(mf.GetName()))
+			for _, label := range m.GetLabel() {
+				if label.GetValue() == "" {
+					continue
+				}
+				b.WriteByte(',')
+				b.WriteString(tagEscaper.Replace(label.GetName()))
+				b.WriteByte('=')
+				b.WriteString(tagEscaper.Replace(label.GetValue()))
+			}
+			for i, f := range fields {
+				if i == 0 {
+					b.WriteByte(' ')
+				} else {
+					b.WriteByte(',')
+				}
+				b.WriteString(tagEscaper.Replace(f.key))
+				b.WriteByte('=')
+				b.WriteString(f.value)
+			}
+			ts := now
+			if m.TimestampMs != nil {
+				ts = time.UnixMilli(m.GetTimestampMs())
+			}
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatInt(ts.UnixNano(), 10))
+			b.WriteByte('\n')
+			if _, err := io.WriteString(w, b.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type field struct {
+	key   string
+	value string
+}
+
+func metricFields(typ dto.MetricType, m *dto.Metric) []field {
+	var fields []field
+	addFloat := func(key string, v float64) {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return
+		}
+		fields = append(fields, field{key: key, value: strconv.FormatFloat(v, 'g', -1, 64)})
+	}
+
+	switch typ {
+	case dto.MetricType_COUNTER:
+		addFloat("counter", m.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		addFloat("gauge", m.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		addFloat("value", m.GetUntyped().GetValue())
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		addFloat("sum", s.GetSampleSum())
+		addFloat("count", float64(s.GetSampleCount()))
+		for _, q := range s.GetQuantile() {
+			addFloat(strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64), q.GetValue())
+		}
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		h := m.GetHistogram()
+		addFloat("sum", h.GetSampleSum())
+		addFloat("count", float64(h.GetSampleCount()))
+		for _, bucket := range h.GetBucket() {
+			// The +Inf bucket equals count.
+			if math.IsInf(bucket.GetUpperBound(), +1) {
+				continue
+			}
+			addFloat(strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64), float64(bucket.GetCumulativeCount()))
+		}
+	}
+	return fields
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
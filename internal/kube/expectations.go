@@ -0,0 +1,166 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yuuki/rdma_exporter/internal/collector"
+)
+
+// Annotation keys, below the configured prefix, that set the expectations of
+// a node, e.g. rdma-exporter/expected-ports=8.
+const (
+	ExpectedDevicesKey  = "expected-devices"
+	ExpectedPortsKey    = "expected-ports"
+	ExpectedPortRateKey = "expected-port-rate"
+)
+
+// Expectations is the desired RDMA state of a node. Unset fields are -1.
+type Expectations struct {
+	// Devices is the number of RDMA devices the node should have.
+	Devices int
+	// ActivePorts is the number of ports that should be ACTIVE.
+	ActivePorts int
+	// PortRateGbps is the link rate, in Gb/s, every active port should run
+	// at, at least.
+	PortRateGbps float64
+}
+
+// Unset reports whether no expectation is set.
+func (e Expectations) Unset() bool {
+	return e.Devices < 0 && e.ActivePorts < 0 && e.PortRateGbps < 0
+}
+
+// ParseExpectations reads the expectations from the annotations whose keys
+// start with prefix. An invalid value leaves its expectation unset and is
+// reported in the returned error, while the valid ones are still returned.
+func ParseExpectations(annotations map[string]string, prefix string) (Expectations, error) {
+	expectations := Expectations{Devices: -1, ActivePorts: -1, PortRateGbps: -1}
+	var errs []error
+	if value, ok := annotations[prefix+ExpectedDevicesKey]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			errs = append(errs, fmt.Errorf("invalid annotation %s=%q: must be a non-negative integer", prefix+ExpectedDevicesKey, value))
+		} else {
+			expectations.Devices = n
+		}
+	}
+	if value, ok := annotations[prefix+ExpectedPortsKey]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			errs = append(errs, fmt.Errorf("invalid annotation %s=%q: must be a non-negative integer", prefix+ExpectedPortsKey, value))
+		} else {
+			expectations.ActivePorts = n
+		}
+	}
+	if value, ok := annotations[prefix+ExpectedPortRateKey]; ok {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			errs = append(errs, fmt.Errorf("invalid annotation %s=%q: must be a positive number of Gb/s", prefix+ExpectedPortRateKey, value))
+		} else {
+			expectations.PortRateGbps = rate
+		}
+	}
+	return expectations, errors.Join(errs...)
+}
+
+// nodeReader reads the annotations of a node; *Client implements it.
+type nodeReader interface {
+	NodeAnnotations(ctx context.Context, name string) (map[string]string, error)
+}
+
+// WatcherOptions configures a NodeWatcher.
+type WatcherOptions struct {
+	// Node is the name of the node object, usually passed in with the
+	// downward API.
+	Node string
+	// Prefix is prepended to the annotation keys.
+	Prefix string
+	// Interval is the time between two reads of the node object.
+	Interval time.Duration
+}
+
+// NodeWatcher re-reads the expectations from the node's annotations in the
+// background, so scrapes never wait for the API server.
+type NodeWatcher struct {
+	client nodeReader
+	opts   WatcherOptions
+	logger *slog.Logger
+
+	mu           sync.Mutex
+	expectations Expectations
+	loaded       bool
+}
+
+// NewNodeWatcher validates opts and returns a NodeWatcher reading through
+// client.
+func NewNodeWatcher(client *Client, opts WatcherOptions, logger *slog.Logger) (*NodeWatcher, error) {
+	return newNodeWatcher(client, opts, logger)
+}
+
+func newNodeWatcher(client nodeReader, opts WatcherOptions, logger *slog.Logger) (*NodeWatcher, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if opts.Node == "" {
+		return nil, errors.New("kubernetes node name must be set")
+	}
+	if opts.Interval <= 0 {
+		return nil, errors.New("kubernetes refresh interval must be positive")
+	}
+	return &NodeWatcher{client: client, opts: opts, logger: logger}, nil
+}
+
+// Run reads the node object right away and then every interval until ctx is
+// done.
+func (w *NodeWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		w.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh reads the node object once. A failed read keeps the expectations
+// of the last successful one, so a restarting API server does not flap the
+// violation metrics.
+func (w *NodeWatcher) refresh(ctx context.Context) {
+	annotations, err := w.client.NodeAnnotations(ctx, w.opts.Node)
+	if err != nil {
+		if ctx.Err() == nil {
+			w.logger.Warn("failed to read node expectations", "node", w.opts.Node, "err", err)
+		}
+		return
+	}
+	expectations, err := ParseExpectations(annotations, w.opts.Prefix)
+	if err != nil {
+		w.logger.Warn("ignoring invalid node expectations", "node", w.opts.Node, "err", err)
+	}
+	w.mu.Lock()
+	w.expectations = expectations
+	w.loaded = true
+	w.mu.Unlock()
+}
+
+// Expectations returns the expectations of the last successful read, for
+// collector.WithExpectations. ok is false until the node object was read
+// once.
+func (w *NodeWatcher) Expectations() (expectations collector.Expectations, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return collector.Expectations{
+		Devices:      w.expectations.Devices,
+		ActivePorts:  w.expectations.ActivePorts,
+		PortRateGbps: w.expectations.PortRateGbps,
+	}, w.loaded
+}
@@ -0,0 +1,111 @@
+// Package kube reads the node object of the exporter from the Kubernetes API
+// server. It talks to the API server with the pod's service account over
+// plain HTTPS rather than through client-go, as a single GET per refresh is
+// all it needs.
+package kube
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// serviceAccountDir is where the kubelet mounts the pod's service
+	// account token and the cluster CA.
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenFile         = "token"
+	caFile            = "ca.crt"
+
+	// maxNodeSize bounds the node object read from the API server; status
+	// carries the node's image list and can reach a few hundred KiB.
+	maxNodeSize = 8 << 20
+	// requestTimeout bounds a request whose context has no deadline.
+	requestTimeout = 10 * time.Second
+)
+
+// Client reads node objects from the API server.
+type Client struct {
+	baseURL    string
+	tokenPath  string
+	httpClient *http.Client
+}
+
+// NewInClusterClient returns a Client for the API server of the cluster the
+// exporter runs in, authenticated with the pod's service account.
+func NewInClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes pod: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, caFile))
+	if err != nil {
+		return nil, fmt.Errorf("read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("service account CA contains no certificates")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &Client{
+		baseURL:    "https://" + net.JoinHostPort(host, port),
+		tokenPath:  filepath.Join(serviceAccountDir, tokenFile),
+		httpClient: &http.Client{Transport: transport},
+	}, nil
+}
+
+// NodeAnnotations returns the annotations of the node named name. The
+// service account needs permission to get nodes.
+func (c *Client) NodeAnnotations(ctx context.Context, name string) (map[string]string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/v1/nodes/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	// Bound service account tokens are rotated by the kubelet, so the token
+	// is read for every request.
+	if c.tokenPath != "" {
+		token, err := os.ReadFile(c.tokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get node %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("get node %s: %s: %s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var node struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxNodeSize)).Decode(&node); err != nil {
+		return nil, fmt.Errorf("decode node %s: %w", name, err)
+	}
+	return node.Metadata.Annotations, nil
+}
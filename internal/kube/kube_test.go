@@ -0,0 +1,144 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientNodeAnnotations(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/nodes/gpu-node-1":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"kind":"Node","metadata":{"name":"gpu-node-1","annotations":{"rdma-exporter/expected-ports":"8"}},"status":{}}`))
+		default:
+			http.Error(w, `{"kind":"Status","reason":"NotFound"}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tokenPath := filepath.Join(t.TempDir(), tokenFile)
+	if err := os.WriteFile(tokenPath, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{baseURL: srv.URL, tokenPath: tokenPath, httpClient: srv.Client()}
+
+	annotations, err := client.NodeAnnotations(context.Background(), "gpu-node-1")
+	if err != nil {
+		t.Fatalf("NodeAnnotations returned error: %v", err)
+	}
+	if got := annotations["rdma-exporter/expected-ports"]; got != "8" {
+		t.Fatalf("expected annotation value 8, got %q", got)
+	}
+
+	if _, err := client.NodeAnnotations(context.Background(), "missing"); err == nil {
+		t.Fatalf("expected error for a missing node")
+	}
+
+	// The token is re-read for every request, so a rotated token is used.
+	if err := os.WriteFile(tokenPath, []byte("rotated"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.NodeAnnotations(context.Background(), "gpu-node-1"); err == nil {
+		t.Fatalf("expected the rotated token to be sent and rejected")
+	}
+}
+
+func TestParseExpectations(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        Expectations
+		wantErr     bool
+	}{
+		{
+			name:        "none",
+			annotations: map[string]string{"other/expected-ports": "8"},
+			want:        Expectations{Devices: -1, ActivePorts: -1, PortRateGbps: -1},
+		},
+		{
+			name: "all",
+			annotations: map[string]string{
+				"rdma-exporter/expected-devices":   "8",
+				"rdma-exporter/expected-ports":     "8",
+				"rdma-exporter/expected-port-rate": "400",
+			},
+			want: Expectations{Devices: 8, ActivePorts: 8, PortRateGbps: 400},
+		},
+		{
+			name: "invalid values are left unset",
+			annotations: map[string]string{
+				"rdma-exporter/expected-devices":   "eight",
+				"rdma-exporter/expected-ports":     "4",
+				"rdma-exporter/expected-port-rate": "0",
+			},
+			want:    Expectations{Devices: -1, ActivePorts: 4, PortRateGbps: -1},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseExpectations(tt.annotations, "rdma-exporter/")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExpectations error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("ParseExpectations = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeNodeReader struct {
+	annotations map[string]string
+	err         error
+}
+
+func (f *fakeNodeReader) NodeAnnotations(context.Context, string) (map[string]string, error) {
+	return f.annotations, f.err
+}
+
+func TestNodeWatcherKeepsLastExpectationsOnError(t *testing.T) {
+	t.Parallel()
+
+	reader := &fakeNodeReader{err: errors.New("connection refused")}
+	watcher, err := newNodeWatcher(reader, WatcherOptions{Node: "gpu-node-1", Prefix: "rdma-exporter/", Interval: time.Minute}, nil)
+	if err != nil {
+		t.Fatalf("newNodeWatcher returned error: %v", err)
+	}
+
+	watcher.refresh(context.Background())
+	if _, ok := watcher.Expectations(); ok {
+		t.Fatalf("expected no expectations before a successful read")
+	}
+
+	reader.annotations, reader.err = map[string]string{"rdma-exporter/expected-ports": "2"}, nil
+	watcher.refresh(context.Background())
+	reader.err = errors.New("connection refused")
+	watcher.refresh(context.Background())
+	got, ok := watcher.Expectations()
+	if !ok || got.ActivePorts != 2 {
+		t.Fatalf("expected the last read's 2 active ports, got %+v (ok=%t)", got, ok)
+	}
+
+	if _, err := newNodeWatcher(reader, WatcherOptions{Interval: time.Minute}, nil); err == nil {
+		t.Fatalf("expected error without a node name")
+	}
+}
@@ -2,43 +2,135 @@ package netdev
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"syscall"
 )
 
+var errClosed = errors.New("ethtool stats provider is closed")
+
 type statsClient interface {
 	Stats(intf string) (map[string]uint64, error)
+	LinkSettings(intf string) (LinkSettings, error)
 	Close()
 }
 
-// EthtoolStatsProvider reads per-interface counters via ethtool.
+// Duplex values reported by ethtool (DUPLEX_HALF, DUPLEX_FULL, DUPLEX_UNKNOWN).
+const (
+	DuplexHalf    uint8 = 0x00
+	DuplexFull    uint8 = 0x01
+	DuplexUnknown uint8 = 0xff
+)
+
+// SpeedUnknown is reported when the link is down or the driver cannot tell.
+const SpeedUnknown uint32 = 0xffffffff
+
+// LinkSettings is the negotiated link configuration of a netdev.
+type LinkSettings struct {
+	// SpeedMbps is the link speed in Mb/s, or SpeedUnknown.
+	SpeedMbps uint32
+	Duplex    uint8
+	Autoneg   bool
+}
+
+// EthtoolStatsProvider reads per-interface counters via ethtool. It keeps a
+// pool of clients, each with a socket of its own, so concurrent collections
+// do not queue behind one socket. Clients are opened on demand up to the pool
+// size; one whose socket fails (e.g. ENOBUFS under memory pressure) is closed
+// and the request retried once on a fresh one.
 type EthtoolStatsProvider struct {
+	open func() (statsClient, error)
+	// slots holds a token per client in use, bounding them to the pool size.
+	slots chan struct{}
+
 	mu     sync.Mutex
-	client statsClient
+	idle   []statsClient
+	closed bool
 }
 
-func newEthtoolStatsProvider(client statsClient) *EthtoolStatsProvider {
-	return &EthtoolStatsProvider{client: client}
+func newEthtoolStatsProvider(size int, open func() (statsClient, error)) *EthtoolStatsProvider {
+	return &EthtoolStatsProvider{open: open, slots: make(chan struct{}, max(size, 1))}
 }
 
-// Stats fetches counters for the specified netdev.
-func (p *EthtoolStatsProvider) Stats(ctx context.Context, netDev string) (map[string]uint64, error) {
+// isSocketError reports whether err means the client's socket is unusable
+// rather than that the interface cannot answer the request.
+func isSocketError(err error) bool {
+	return errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EBADF) || errors.Is(err, syscall.ENOTSOCK)
+}
+
+// do runs fn with a client from the pool, waiting for one while all are in
+// use. A client failing with a socket error is replaced and fn retried once.
+func (p *EthtoolStatsProvider) do(ctx context.Context, fn func(statsClient) error) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	var err error
+	for range 2 {
+		var client statsClient
+		client, err = p.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		err = fn(client)
+		if !isSocketError(err) {
+			p.release(client)
+			return err
+		}
+		client.Close()
+	}
+	return err
+}
+
+func (p *EthtoolStatsProvider) acquire(ctx context.Context) (statsClient, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errClosed
+	}
+	if n := len(p.idle); n > 0 {
+		client := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return client, nil
+	}
+	p.mu.Unlock()
+
+	client, err := p.open()
+	if err != nil {
+		return nil, fmt.Errorf("open ethtool client: %w", err)
+	}
+	return client, nil
+}
 
+func (p *EthtoolStatsProvider) release(client statsClient) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-
-	if err := ctx.Err(); err != nil {
-		return nil, err
+	if p.closed {
+		client.Close()
+		return
 	}
+	p.idle = append(p.idle, client)
+}
 
-	stats, err := p.client.Stats(netDev)
+// Stats fetches counters for the specified netdev.
+func (p *EthtoolStatsProvider) Stats(ctx context.Context, netDev string) (map[string]uint64, error) {
+	var stats map[string]uint64
+	err := p.do(ctx, func(client statsClient) error {
+		var err error
+		stats, err = client.Stats(netDev)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("read ethtool stats for %s: %w", netDev, err)
 	}
-
 	out := make(map[string]uint64, len(stats))
 	for k, v := range stats {
 		out[k] = v
@@ -46,15 +138,33 @@ func (p *EthtoolStatsProvider) Stats(ctx context.Context, netDev string) (map[st
 	return out, nil
 }
 
-// Close closes the underlying ethtool client.
+// LinkSettings fetches the negotiated speed, duplex and autonegotiation state
+// for the specified netdev.
+func (p *EthtoolStatsProvider) LinkSettings(ctx context.Context, netDev string) (LinkSettings, error) {
+	var settings LinkSettings
+	err := p.do(ctx, func(client statsClient) error {
+		var err error
+		settings, err = client.LinkSettings(netDev)
+		return err
+	})
+	if err != nil {
+		return LinkSettings{}, fmt.Errorf("read ethtool link settings for %s: %w", netDev, err)
+	}
+	return settings, nil
+}
+
+// Close closes the idle clients of the pool; clients in use are closed when
+// their request finishes.
 func (p *EthtoolStatsProvider) Close() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-
-	if p.client == nil {
+	if p.closed {
 		return nil
 	}
-	p.client.Close()
-	p.client = nil
+	for _, client := range p.idle {
+		client.Close()
+	}
+	p.idle = nil
+	p.closed = true
 	return nil
 }
@@ -1,4 +1,4 @@
-//go:build linux
+//go:build linux && !sysfs_only
 
 package netdev
 
@@ -8,11 +8,40 @@ import (
 	"github.com/safchain/ethtool"
 )
 
-// NewEthtoolStatsProvider creates a provider backed by an ethtool client.
-func NewEthtoolStatsProvider() (*EthtoolStatsProvider, error) {
-	client, err := ethtool.NewEthtool()
+// ethtoolClient adapts *ethtool.Ethtool to statsClient.
+type ethtoolClient struct {
+	*ethtool.Ethtool
+}
+
+func (c ethtoolClient) LinkSettings(intf string) (LinkSettings, error) {
+	var cmd ethtool.EthtoolCmd
+	speed, err := c.CmdGet(&cmd, intf)
+	if err != nil {
+		return LinkSettings{}, err
+	}
+	return LinkSettings{
+		SpeedMbps: speed,
+		Duplex:    cmd.Duplex,
+		Autoneg:   cmd.Autoneg != 0,
+	}, nil
+}
+
+// NewEthtoolStatsProvider creates a provider backed by a pool of up to size
+// ethtool clients. The first client is opened right away, so a host without
+// ethtool support fails here rather than on every scrape.
+func NewEthtoolStatsProvider(size int) (*EthtoolStatsProvider, error) {
+	open := func() (statsClient, error) {
+		client, err := ethtool.NewEthtool()
+		if err != nil {
+			return nil, err
+		}
+		return ethtoolClient{client}, nil
+	}
+	client, err := open()
 	if err != nil {
 		return nil, fmt.Errorf("open ethtool client: %w", err)
 	}
-	return newEthtoolStatsProvider(client), nil
+	p := newEthtoolStatsProvider(size, open)
+	p.idle = append(p.idle, client)
+	return p, nil
 }
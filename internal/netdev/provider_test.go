@@ -3,11 +3,16 @@ package netdev
 import (
 	"context"
 	"errors"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
 type stubStatsClient struct {
 	stats map[string]uint64
+	link  LinkSettings
 	err   error
 
 	closed bool
@@ -26,10 +31,23 @@ func (s *stubStatsClient) Stats(_ string) (map[string]uint64, error) {
 	return out, nil
 }
 
+func (s *stubStatsClient) LinkSettings(_ string) (LinkSettings, error) {
+	s.calls++
+	if s.err != nil {
+		return LinkSettings{}, s.err
+	}
+	return s.link, nil
+}
+
 func (s *stubStatsClient) Close() {
 	s.closed = true
 }
 
+// singleClient returns a provider whose pool opens client.
+func singleClient(client *stubStatsClient) *EthtoolStatsProvider {
+	return newEthtoolStatsProvider(1, func() (statsClient, error) { return client, nil })
+}
+
 func TestEthtoolStatsProvider_Stats(t *testing.T) {
 	t.Parallel()
 
@@ -38,7 +56,7 @@ func TestEthtoolStatsProvider_Stats(t *testing.T) {
 			"rx_prio0_pause": 12,
 		},
 	}
-	provider := newEthtoolStatsProvider(client)
+	provider := singleClient(client)
 
 	got, err := provider.Stats(context.Background(), "ens1f0np0")
 	if err != nil {
@@ -56,7 +74,7 @@ func TestEthtoolStatsProvider_ContextCanceled(t *testing.T) {
 	t.Parallel()
 
 	client := &stubStatsClient{}
-	provider := newEthtoolStatsProvider(client)
+	provider := singleClient(client)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
@@ -74,7 +92,7 @@ func TestEthtoolStatsProvider_StatsError(t *testing.T) {
 	t.Parallel()
 
 	client := &stubStatsClient{err: errors.New("boom")}
-	provider := newEthtoolStatsProvider(client)
+	provider := singleClient(client)
 
 	_, err := provider.Stats(context.Background(), "ens1f0np0")
 	if err == nil {
@@ -86,7 +104,10 @@ func TestEthtoolStatsProvider_Close(t *testing.T) {
 	t.Parallel()
 
 	client := &stubStatsClient{}
-	provider := newEthtoolStatsProvider(client)
+	provider := singleClient(client)
+	if _, err := provider.Stats(context.Background(), "ens1f0np0"); err != nil {
+		t.Fatalf("Stats returned error: %v", err)
+	}
 
 	if err := provider.Close(); err != nil {
 		t.Fatalf("Close returned error: %v", err)
@@ -95,3 +116,134 @@ func TestEthtoolStatsProvider_Close(t *testing.T) {
 		t.Fatalf("expected stats client to be closed")
 	}
 }
+
+func TestEthtoolStatsProvider_LinkSettings(t *testing.T) {
+	t.Parallel()
+
+	want := LinkSettings{SpeedMbps: 100000, Duplex: DuplexFull, Autoneg: true}
+	provider := singleClient(&stubStatsClient{link: want})
+
+	got, err := provider.LinkSettings(context.Background(), "ens1f0np0")
+	if err != nil {
+		t.Fatalf("LinkSettings returned error: %v", err)
+	}
+	if got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestEthtoolStatsProvider_StatsAfterClose(t *testing.T) {
+	t.Parallel()
+
+	provider := singleClient(&stubStatsClient{})
+	if err := provider.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if _, err := provider.Stats(context.Background(), "ens1f0np0"); err == nil {
+		t.Fatalf("expected error after close")
+	}
+	if _, err := provider.LinkSettings(context.Background(), "ens1f0np0"); err == nil {
+		t.Fatalf("expected error after close")
+	}
+}
+
+func TestEthtoolStatsProvider_ReconnectsOnSocketError(t *testing.T) {
+	t.Parallel()
+
+	broken := &stubStatsClient{err: syscall.ENOBUFS}
+	healthy := &stubStatsClient{stats: map[string]uint64{"rx_prio3_pause": 5}}
+	clients := []*stubStatsClient{broken, healthy}
+	opened := 0
+	provider := newEthtoolStatsProvider(2, func() (statsClient, error) {
+		client := clients[opened]
+		opened++
+		return client, nil
+	})
+
+	got, err := provider.Stats(context.Background(), "ens1f0np0")
+	if err != nil {
+		t.Fatalf("Stats returned error: %v", err)
+	}
+	if got["rx_prio3_pause"] != 5 {
+		t.Fatalf("expected rx_prio3_pause=5, got %v", got)
+	}
+	if !broken.closed || healthy.closed {
+		t.Fatalf("expected only the broken client to be closed, got broken %t, healthy %t", broken.closed, healthy.closed)
+	}
+
+	// The healthy client is reused.
+	if _, err := provider.Stats(context.Background(), "ens1f0np0"); err != nil {
+		t.Fatalf("Stats returned error: %v", err)
+	}
+	if opened != 2 || healthy.calls != 2 {
+		t.Fatalf("expected 2 clients opened and 2 calls on the healthy one, got %d and %d", opened, healthy.calls)
+	}
+}
+
+func TestEthtoolStatsProvider_InterfaceErrorKeepsClient(t *testing.T) {
+	t.Parallel()
+
+	client := &stubStatsClient{err: syscall.EOPNOTSUPP}
+	provider := singleClient(client)
+	if _, err := provider.Stats(context.Background(), "ens1f0np0"); !errors.Is(err, syscall.EOPNOTSUPP) {
+		t.Fatalf("expected EOPNOTSUPP, got %v", err)
+	}
+	if client.closed || client.calls != 1 {
+		t.Fatalf("expected the client to stay open without a retry, got closed %t after %d calls", client.closed, client.calls)
+	}
+}
+
+// blockingClient answers once release is closed.
+type blockingClient struct {
+	started chan<- struct{}
+	release <-chan struct{}
+}
+
+func (b blockingClient) Stats(string) (map[string]uint64, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return nil, nil
+}
+
+func (b blockingClient) LinkSettings(string) (LinkSettings, error) {
+	return LinkSettings{}, nil
+}
+
+func (b blockingClient) Close() {}
+
+func TestEthtoolStatsProvider_PoolBoundsConcurrency(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	var opened atomic.Int32
+	provider := newEthtoolStatsProvider(2, func() (statsClient, error) {
+		opened.Add(1)
+		return blockingClient{started: started, release: release}, nil
+	})
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := provider.Stats(context.Background(), "ens1f0np0"); err != nil {
+				t.Errorf("Stats returned error: %v", err)
+			}
+		}()
+	}
+
+	// Two requests run in parallel on clients of their own; the third waits.
+	<-started
+	<-started
+	select {
+	case <-started:
+		t.Fatalf("expected the third request to wait for a client")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	wg.Wait()
+	if got := opened.Load(); got != 2 {
+		t.Fatalf("expected 2 clients opened, got %d", got)
+	}
+}
@@ -1,10 +1,11 @@
-//go:build !linux
+//go:build !linux || sysfs_only
 
 package netdev
 
 import "errors"
 
-// NewEthtoolStatsProvider is only supported on Linux hosts.
-func NewEthtoolStatsProvider() (*EthtoolStatsProvider, error) {
-	return nil, errors.New("ethtool stats provider is supported on linux only")
+// NewEthtoolStatsProvider is only supported on Linux hosts, in builds without
+// the sysfs_only tag.
+func NewEthtoolStatsProvider(int) (*EthtoolStatsProvider, error) {
+	return nil, errors.New("ethtool stats provider is supported on linux only and is left out by the sysfs_only build tag")
 }
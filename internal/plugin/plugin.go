@@ -0,0 +1,504 @@
+// Package plugin runs exec plugins: site-provided executables, typically
+// wrappers around vendor tools such as mlxlink, whose JSON output is
+// re-exported as rdma_plugin_* metrics.
+//
+// Every executable file in the plugin directory is run without arguments
+// once per interval, with a timeout, and must print a single JSON document to
+// stdout:
+//
+//	{"metrics": [
+//	  {"name": "link_ber", "help": "Raw bit error rate from mlxlink.", "type": "gauge",
+//	   "labels": {"device": "mlx5_0", "port": "1"}, "value": 1.5e-12}
+//	]}
+//
+// name becomes rdma_plugin_<name>; type is "gauge" (the default) or
+// "counter"; a plugin label naming the plugin is added to every series. A run
+// that fails, times out, or prints invalid output keeps none of its metrics
+// and is reported through the plugin health metrics.
+//
+// The directory and the plugins must be owned by root or the exporter's user
+// and not be writable by group or others; otherwise nothing is run from them.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricPrefix = "rdma_plugin_"
+
+	// maxOutput bounds what is read from a plugin's stdout.
+	maxOutput = 4 << 20
+	// maxStderr bounds the part of a plugin's stderr that is logged.
+	maxStderr = 1 << 10
+	// waitDelay bounds the wait for a killed plugin's output pipes, which a
+	// grandchild can keep open.
+	waitDelay = time.Second
+)
+
+var (
+	// namePattern matches valid metric name suffixes and label names.
+	namePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	// reservedNames are the names of the health metrics below the prefix.
+	reservedNames = []string{"up", "duration_seconds", "errors_total", "last_success_timestamp_seconds"}
+)
+
+// Options configures a Runner.
+type Options struct {
+	// Dir holds the plugin executables.
+	Dir string
+	// Interval is the time between two runs of every plugin.
+	Interval time.Duration
+	// Timeout bounds a single plugin run.
+	Timeout time.Duration
+}
+
+// Output is the document a plugin prints to stdout.
+type Output struct {
+	Metrics []Metric `json:"metrics"`
+}
+
+// Metric is a single sample of a plugin's output.
+type Metric struct {
+	Name   string            `json:"name"`
+	Help   string            `json:"help"`
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// family holds the samples of one metric name of a plugin's output.
+type family struct {
+	name       string
+	plugin     string
+	help       string
+	desc       *prometheus.Desc
+	valueType  prometheus.ValueType
+	labelNames []string
+	series     map[string]bool
+	metrics    []prometheus.Metric
+}
+
+// compatible reports whether the registry can expose the samples of f and
+// other as one metric family.
+func (f *family) compatible(other *family) bool {
+	return f.help == other.help && f.valueType == other.valueType && slices.Equal(f.labelNames, other.labelNames)
+}
+
+// result is the outcome of a plugin's last run.
+type result struct {
+	families []*family
+	// metrics are the samples of families that do not clash with another
+	// plugin's; see resolveConflicts.
+	metrics     []prometheus.Metric
+	up          bool
+	duration    time.Duration
+	errors      uint64
+	lastSuccess time.Time
+}
+
+// Runner runs the plugins of a directory in the background and exports their
+// last successful output and health. It implements prometheus.Collector; as
+// plugin metrics are only known after a run, it is an unchecked collector.
+type Runner struct {
+	opts   Options
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	results map[string]*result
+
+	upDesc          *prometheus.Desc
+	durationDesc    *prometheus.Desc
+	errorsDesc      *prometheus.Desc
+	lastSuccessDesc *prometheus.Desc
+}
+
+// NewRunner validates opts and returns a Runner.
+func NewRunner(opts Options, logger *slog.Logger) (*Runner, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if opts.Dir == "" {
+		return nil, errors.New("plugin directory must be set")
+	}
+	if opts.Interval <= 0 || opts.Timeout <= 0 {
+		return nil, errors.New("plugin interval and timeout must be positive")
+	}
+	return &Runner{
+		opts:    opts,
+		logger:  logger,
+		results: make(map[string]*result),
+		upDesc: prometheus.NewDesc(
+			metricPrefix+"up",
+			"Whether the last run of the plugin succeeded and printed valid output (1) or not (0).",
+			[]string{"plugin"},
+			nil,
+		),
+		durationDesc: prometheus.NewDesc(
+			metricPrefix+"duration_seconds",
+			"Duration of the last run of the plugin.",
+			[]string{"plugin"},
+			nil,
+		),
+		errorsDesc: prometheus.NewDesc(
+			metricPrefix+"errors_total",
+			"Number of plugin runs that failed, timed out or printed invalid output.",
+			[]string{"plugin"},
+			nil,
+		),
+		lastSuccessDesc: prometheus.NewDesc(
+			metricPrefix+"last_success_timestamp_seconds",
+			"Unix time of the last successful run of the plugin.",
+			[]string{"plugin"},
+			nil,
+		),
+	}, nil
+}
+
+// Run runs all plugins immediately and then every interval until ctx is done.
+func (r *Runner) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	for {
+		r.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce runs every plugin of the directory once, concurrently. The
+// directory is listed on every call, so plugins can be added or removed
+// without a restart.
+func (r *Runner) RunOnce(ctx context.Context) {
+	plugins, err := r.discover()
+	if err != nil {
+		r.logger.Warn("failed to list plugins", "dir", r.opts.Dir, "err", err)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		runsMu sync.Mutex
+		runs   = make(map[string]run, len(plugins))
+	)
+	for name, path := range plugins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run := r.runPlugin(ctx, name, path)
+			runsMu.Lock()
+			runs[name] = run
+			runsMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name := range r.results {
+		if _, ok := plugins[name]; !ok {
+			delete(r.results, name)
+		}
+	}
+	for name, run := range runs {
+		res, ok := r.results[name]
+		if !ok {
+			res = &result{}
+			r.results[name] = res
+		}
+		res.duration = run.duration
+		if run.err != nil {
+			res.up = false
+			res.families = nil
+			res.errors++
+			continue
+		}
+		res.up = true
+		res.families = run.families
+		res.lastSuccess = run.finished
+	}
+	r.resolveConflicts()
+}
+
+// resolveConflicts sets the metrics of every plugin, leaving out the
+// families that clash with a family of the same name from another plugin:
+// the registry refuses to expose one name with different help, type or label
+// names, which would fail every scrape. Plugins are checked in name order, so
+// the first one keeps the family.
+func (r *Runner) resolveConflicts() {
+	owners := make(map[string]*family)
+	for _, name := range slices.Sorted(maps.Keys(r.results)) {
+		res := r.results[name]
+		res.metrics = nil
+		for _, fam := range res.families {
+			owner, ok := owners[fam.name]
+			if !ok {
+				owners[fam.name] = fam
+			} else if !owner.compatible(fam) {
+				r.logger.Error("dropping plugin metric that clashes with another plugin's", "plugin", name, "metric", metricPrefix+fam.name, "owner", owner.plugin)
+				continue
+			}
+			res.metrics = append(res.metrics, fam.metrics...)
+		}
+	}
+}
+
+// discover maps plugin names to the executables of the plugin directory. The
+// name is the file name without extension.
+func (r *Runner) discover() (map[string]string, error) {
+	info, err := os.Stat(r.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPermissions(r.opts.Dir, info); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(r.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	plugins := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(r.opts.Dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		if err := checkPermissions(path, info); err != nil {
+			r.logger.Warn("ignoring plugin", "path", path, "err", err)
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if _, dup := plugins[name]; dup {
+			r.logger.Warn("ignoring plugin with duplicate name", "plugin", name, "path", path)
+			continue
+		}
+		plugins[name] = path
+	}
+	return plugins, nil
+}
+
+// checkPermissions returns an error unless path is owned by root or the
+// exporter's user and is not writable by group or others, so no other user
+// can make the exporter run an executable of theirs.
+func checkPermissions(path string, info fs.FileInfo) error {
+	if perm := info.Mode().Perm(); perm&0o022 != 0 {
+		return fmt.Errorf("%s is writable by group or others (mode %s)", path, perm)
+	}
+	if uid, ok := fileOwner(info); ok && uid != 0 && uid != os.Geteuid() {
+		return fmt.Errorf("%s is owned by uid %d, neither root nor the exporter's user", path, uid)
+	}
+	return nil
+}
+
+// run is the outcome of a single plugin run.
+type run struct {
+	families []*family
+	duration time.Duration
+	finished time.Time
+	err      error
+}
+
+func (r *Runner) runPlugin(ctx context.Context, name, path string) run {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	families, err := r.exec(ctx, name, path)
+	if err != nil {
+		r.logger.Warn("plugin run failed", "plugin", name, "err", err)
+	}
+	finished := time.Now()
+	return run{families: families, duration: finished.Sub(start), finished: finished, err: err}
+}
+
+func (r *Runner) exec(ctx context.Context, name, path string) ([]*family, error) {
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = r.opts.Dir
+	cmd.WaitDelay = waitDelay
+	var stdout limitedBuffer
+	stdout.limit = maxOutput
+	var stderr limitedBuffer
+	stderr.limit = maxStderr
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("timed out after %s", r.opts.Timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	if stdout.truncated {
+		return nil, fmt.Errorf("output exceeds %d bytes", maxOutput)
+	}
+	return parse(name, stdout.Bytes())
+}
+
+// Parse validates the output of plugin name and converts it to metrics.
+// Samples sharing a name must use the same label names and be unique.
+func Parse(name string, data []byte) ([]prometheus.Metric, error) {
+	families, err := parse(name, data)
+	if err != nil {
+		return nil, err
+	}
+	var metrics []prometheus.Metric
+	for _, fam := range families {
+		metrics = append(metrics, fam.metrics...)
+	}
+	return metrics, nil
+}
+
+// parse is Parse, keeping the samples grouped by metric family in the order
+// their names first appear.
+func parse(name string, data []byte) ([]*family, error) {
+	var out Output
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode output: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("decode output: trailing data after the JSON document")
+	}
+
+	var families []*family
+	byName := make(map[string]*family)
+	for _, m := range out.Metrics {
+		if !namePattern.MatchString(m.Name) || slices.Contains(reservedNames, m.Name) {
+			return nil, fmt.Errorf("invalid metric name %q", m.Name)
+		}
+		valueType, err := parseType(m.Type)
+		if err != nil {
+			return nil, fmt.Errorf("metric %q: %w", m.Name, err)
+		}
+		labelNames := make([]string, 0, len(m.Labels)+1)
+		for label := range m.Labels {
+			if !namePattern.MatchString(label) || label == "plugin" || strings.HasPrefix(label, "__") {
+				return nil, fmt.Errorf("metric %q: invalid label name %q", m.Name, label)
+			}
+			labelNames = append(labelNames, label)
+		}
+		slices.Sort(labelNames)
+
+		fam, ok := byName[m.Name]
+		if !ok {
+			help := m.Help
+			if help == "" {
+				help = "Metric reported by an exec plugin."
+			}
+			fam = &family{
+				name:       m.Name,
+				plugin:     name,
+				help:       help,
+				desc:       prometheus.NewDesc(metricPrefix+m.Name, help, append([]string{"plugin"}, labelNames...), nil),
+				valueType:  valueType,
+				labelNames: labelNames,
+				series:     make(map[string]bool),
+			}
+			byName[m.Name] = fam
+			families = append(families, fam)
+		} else if !slices.Equal(fam.labelNames, labelNames) || fam.valueType != valueType {
+			return nil, fmt.Errorf("metric %q: samples differ in type or label names", m.Name)
+		}
+
+		values := make([]string, 0, len(labelNames)+1)
+		values = append(values, name)
+		for _, label := range labelNames {
+			values = append(values, m.Labels[label])
+		}
+		key := strings.Join(values, "\xff")
+		if fam.series[key] {
+			return nil, fmt.Errorf("metric %q: duplicate labels %v", m.Name, m.Labels)
+		}
+		fam.series[key] = true
+
+		metric, err := prometheus.NewConstMetric(fam.desc, valueType, m.Value, values...)
+		if err != nil {
+			return nil, fmt.Errorf("metric %q: %w", m.Name, err)
+		}
+		fam.metrics = append(fam.metrics, metric)
+	}
+	return families, nil
+}
+
+func parseType(typ string) (prometheus.ValueType, error) {
+	switch typ {
+	case "", "gauge":
+		return prometheus.GaugeValue, nil
+	case "counter":
+		return prometheus.CounterValue, nil
+	}
+	return 0, fmt.Errorf("unsupported type %q: must be gauge or counter", typ)
+}
+
+// Describe implements prometheus.Collector. It sends nothing, which makes the
+// Runner an unchecked collector.
+func (r *Runner) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (r *Runner) Collect(ch chan<- prometheus.Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range slices.Sorted(maps.Keys(r.results)) {
+		res := r.results[name]
+		up := 0.0
+		if res.up {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(r.upDesc, prometheus.GaugeValue, up, name)
+		ch <- prometheus.MustNewConstMetric(r.durationDesc, prometheus.GaugeValue, res.duration.Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(r.errorsDesc, prometheus.CounterValue, float64(res.errors), name)
+		if !res.lastSuccess.IsZero() {
+			ch <- prometheus.MustNewConstMetric(r.lastSuccessDesc, prometheus.GaugeValue, float64(res.lastSuccess.UnixNano())/1e9, name)
+		}
+		for _, metric := range res.metrics {
+			ch <- metric
+		}
+	}
+}
+
+// limitedBuffer keeps the first limit bytes written to it and drops the rest,
+// so a runaway plugin cannot exhaust memory.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newDiscardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func writePlugin(t *testing.T, dir, name, script string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatalf("write plugin %s: %v", name, err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		output  string
+		want    int
+		wantErr bool
+	}{
+		{name: "empty", output: `{"metrics": []}`, want: 0},
+		{name: "gauge and counter", output: `{"metrics": [
+			{"name": "link_ber", "labels": {"device": "mlx5_0", "port": "1"}, "value": 1e-12},
+			{"name": "link_ber", "labels": {"device": "mlx5_1", "port": "1"}, "value": 2e-12},
+			{"name": "module_resets_total", "type": "counter", "value": 3}
+		]}`, want: 3},
+		{name: "not json", output: `link_ber 1`, wantErr: true},
+		{name: "trailing data", output: `{"metrics": []} {}`, wantErr: true},
+		{name: "unknown field", output: `{"metrics": [], "extra": 1}`, wantErr: true},
+		{name: "invalid name", output: `{"metrics": [{"name": "link-ber", "value": 1}]}`, wantErr: true},
+		{name: "reserved name", output: `{"metrics": [{"name": "up", "value": 1}]}`, wantErr: true},
+		{name: "reserved label", output: `{"metrics": [{"name": "x", "labels": {"plugin": "a"}, "value": 1}]}`, wantErr: true},
+		{name: "unsupported type", output: `{"metrics": [{"name": "x", "type": "histogram", "value": 1}]}`, wantErr: true},
+		{name: "inconsistent labels", output: `{"metrics": [
+			{"name": "x", "labels": {"device": "mlx5_0"}, "value": 1},
+			{"name": "x", "labels": {"port": "1"}, "value": 1}
+		]}`, wantErr: true},
+		{name: "duplicate series", output: `{"metrics": [
+			{"name": "x", "labels": {"device": "mlx5_0"}, "value": 1},
+			{"name": "x", "labels": {"device": "mlx5_0"}, "value": 2}
+		]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		metrics, err := Parse("mlxlink", []byte(tt.output))
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: unexpected error %v", tt.name, err)
+		}
+		if len(metrics) != tt.want {
+			t.Fatalf("%s: expected %d metrics, got %d", tt.name, tt.want, len(metrics))
+		}
+	}
+}
+
+func TestRunnerExportsPluginMetricsAndHealth(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writePlugin(t, dir, "mlxlink.sh", `echo '{"metrics": [{"name": "link_ber", "help": "Raw BER.", "labels": {"device": "mlx5_0"}, "value": 0.5}]}'`)
+	writePlugin(t, dir, "broken", "echo 'no such device' >&2; exit 3\n")
+	writePlugin(t, dir, "slow", "sleep 5\n")
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	runner, err := NewRunner(Options{Dir: dir, Interval: time.Minute, Timeout: 500 * time.Millisecond}, newDiscardLogger())
+	if err != nil {
+		t.Fatalf("NewRunner returned error: %v", err)
+	}
+	runner.RunOnce(context.Background())
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(runner)
+	expected := `
+# HELP rdma_plugin_errors_total Number of plugin runs that failed, timed out or printed invalid output.
+# TYPE rdma_plugin_errors_total counter
+rdma_plugin_errors_total{plugin="broken"} 1
+rdma_plugin_errors_total{plugin="mlxlink"} 0
+rdma_plugin_errors_total{plugin="slow"} 1
+# HELP rdma_plugin_link_ber Raw BER.
+# TYPE rdma_plugin_link_ber gauge
+rdma_plugin_link_ber{device="mlx5_0",plugin="mlxlink"} 0.5
+# HELP rdma_plugin_up Whether the last run of the plugin succeeded and printed valid output (1) or not (0).
+# TYPE rdma_plugin_up gauge
+rdma_plugin_up{plugin="broken"} 0
+rdma_plugin_up{plugin="mlxlink"} 1
+rdma_plugin_up{plugin="slow"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_plugin_errors_total", "rdma_plugin_link_ber", "rdma_plugin_up"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+
+	// A plugin that starts failing drops its metrics; a removed one is forgotten.
+	writePlugin(t, dir, "mlxlink.sh", "echo '{'\n")
+	if err := os.Remove(filepath.Join(dir, "slow")); err != nil {
+		t.Fatal(err)
+	}
+	runner.RunOnce(context.Background())
+	if count, err := testutil.GatherAndCount(reg, "rdma_plugin_link_ber"); err != nil || count != 0 {
+		t.Fatalf("expected no plugin metrics after a failed run, got %d (err=%v)", count, err)
+	}
+	if count, err := testutil.GatherAndCount(reg, "rdma_plugin_up"); err != nil || count != 2 {
+		t.Fatalf("expected two plugins after removing one, got %d (err=%v)", count, err)
+	}
+}
+
+func TestRunnerDropsFamiliesClashingAcrossPlugins(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writePlugin(t, dir, "a", `echo '{"metrics": [{"name": "link_ber", "help": "Raw BER.", "value": 0.5}, {"name": "temp", "value": 40}]}'`)
+	writePlugin(t, dir, "b", `echo '{"metrics": [{"name": "link_ber", "help": "Effective BER.", "value": 0.1}, {"name": "temp", "value": 41}]}'`)
+
+	runner, err := NewRunner(Options{Dir: dir, Interval: time.Minute, Timeout: 5 * time.Second}, newDiscardLogger())
+	if err != nil {
+		t.Fatalf("NewRunner returned error: %v", err)
+	}
+	runner.RunOnce(context.Background())
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(runner)
+	expected := `
+# HELP rdma_plugin_link_ber Raw BER.
+# TYPE rdma_plugin_link_ber gauge
+rdma_plugin_link_ber{plugin="a"} 0.5
+# HELP rdma_plugin_temp Metric reported by an exec plugin.
+# TYPE rdma_plugin_temp gauge
+rdma_plugin_temp{plugin="a"} 40
+rdma_plugin_temp{plugin="b"} 41
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "rdma_plugin_link_ber", "rdma_plugin_temp"); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestRunnerRefusesWritablePlugins(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writePlugin(t, dir, "good", `echo '{"metrics": [{"name": "ok", "value": 1}]}'`)
+	writePlugin(t, dir, "shared", `echo '{"metrics": [{"name": "shared", "value": 1}]}'`)
+	if err := os.Chmod(filepath.Join(dir, "shared"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	runner, err := NewRunner(Options{Dir: dir, Interval: time.Minute, Timeout: 5 * time.Second}, newDiscardLogger())
+	if err != nil {
+		t.Fatalf("NewRunner returned error: %v", err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(runner)
+
+	runner.RunOnce(context.Background())
+	if count, err := testutil.GatherAndCount(reg, "rdma_plugin_up"); err != nil || count != 1 {
+		t.Fatalf("expected only the plugin not writable by others to run, got %d (err=%v)", count, err)
+	}
+
+	// A directory writable by others runs nothing at all.
+	if err := os.Chmod(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	runner.RunOnce(context.Background())
+	if count, err := testutil.GatherAndCount(reg, "rdma_plugin_up"); err != nil || count != 0 {
+		t.Fatalf("expected no plugins from a world-writable directory, got %d (err=%v)", count, err)
+	}
+}
+
+func TestNewRunnerRejectsInvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	for _, opts := range []Options{
+		{Interval: time.Minute, Timeout: time.Second},
+		{Dir: "/plugins", Interval: 0, Timeout: time.Second},
+		{Dir: "/plugins", Interval: time.Minute, Timeout: 0},
+	} {
+		if _, err := NewRunner(opts, newDiscardLogger()); err == nil {
+			t.Fatalf("expected error for %+v", opts)
+		}
+	}
+}
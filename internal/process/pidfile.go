@@ -0,0 +1,26 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// WritePidfile records the current process ID at path.
+func WritePidfile(path string) error {
+	data := []byte(strconv.Itoa(os.Getpid()) + "\n")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write pidfile %s: %w", path, err)
+	}
+	return nil
+}
+
+// RemovePidfile deletes the pidfile, ignoring a file that is already gone.
+func RemovePidfile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove pidfile %s: %w", path, err)
+	}
+	return nil
+}
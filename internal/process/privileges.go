@@ -0,0 +1,72 @@
+package process
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// credentials is the resolved numeric identity to switch to.
+type credentials struct {
+	uid int
+	gid int
+}
+
+// resolveCredentials looks up userName and groupName. When groupName is empty
+// the user's primary group is used; when userName is empty only the group
+// changes and uid is -1.
+func resolveCredentials(userName, groupName string) (credentials, error) {
+	creds := credentials{uid: -1, gid: -1}
+
+	if userName != "" {
+		u, err := lookupUser(userName)
+		if err != nil {
+			return creds, err
+		}
+		if creds.uid, err = strconv.Atoi(u.Uid); err != nil {
+			return creds, fmt.Errorf("parse uid %q: %w", u.Uid, err)
+		}
+		if creds.gid, err = strconv.Atoi(u.Gid); err != nil {
+			return creds, fmt.Errorf("parse gid %q: %w", u.Gid, err)
+		}
+	}
+
+	if groupName != "" {
+		g, err := lookupGroup(groupName)
+		if err != nil {
+			return creds, err
+		}
+		if creds.gid, err = strconv.Atoi(g.Gid); err != nil {
+			return creds, fmt.Errorf("parse gid %q: %w", g.Gid, err)
+		}
+	}
+	return creds, nil
+}
+
+// lookupUser accepts either a user name or a numeric uid.
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u, nil
+	}
+	if _, convErr := strconv.Atoi(name); convErr == nil {
+		if byID, idErr := user.LookupId(name); idErr == nil {
+			return byID, nil
+		}
+	}
+	return nil, fmt.Errorf("lookup user %q: %w", name, err)
+}
+
+// lookupGroup accepts either a group name or a numeric gid.
+func lookupGroup(name string) (*user.Group, error) {
+	g, err := user.LookupGroup(name)
+	if err == nil {
+		return g, nil
+	}
+	if _, convErr := strconv.Atoi(name); convErr == nil {
+		if byID, idErr := user.LookupGroupId(name); idErr == nil {
+			return byID, nil
+		}
+	}
+	return nil, fmt.Errorf("lookup group %q: %w", name, err)
+}
@@ -0,0 +1,89 @@
+//go:build linux
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// DropPrivileges switches the process to userName/groupName. It must be called
+// after privileged resources (ethtool sockets, pidfile) are opened. Supplementary
+// groups are cleared and the group is changed before the user so the setgid
+// call is still permitted.
+func DropPrivileges(userName, groupName string) error {
+	if userName == "" && groupName == "" {
+		return nil
+	}
+
+	creds, err := resolveCredentials(userName, groupName)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Setgroups([]int{creds.gid}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(creds.gid); err != nil {
+		return fmt.Errorf("setgid %d: %w", creds.gid, err)
+	}
+	if creds.uid >= 0 {
+		if err := syscall.Setuid(creds.uid); err != nil {
+			return fmt.Errorf("setuid %d: %w", creds.uid, err)
+		}
+	}
+	return nil
+}
+
+// CheckPidfileDir fails when the directory of the pidfile at path would not
+// let userName/groupName remove the file after DropPrivileges, which would
+// leave a stale pidfile on shutdown. Only owner and mode bits are checked;
+// ACLs are not.
+func CheckPidfileDir(path, userName, groupName string) error {
+	if userName == "" {
+		return nil
+	}
+
+	creds, err := resolveCredentials(userName, groupName)
+	if err != nil {
+		return err
+	}
+	if creds.uid == 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("stat pidfile directory: %w", err)
+	}
+	if !removableBy(info, creds) {
+		return fmt.Errorf("pidfile directory %s is not writable by uid %d, so the pidfile could not be removed on shutdown", dir, creds.uid)
+	}
+	return nil
+}
+
+// removableBy reports whether creds may delete a root-owned file in the
+// directory described by info.
+func removableBy(info os.FileInfo, creds credentials) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	if info.Mode()&os.ModeSticky != 0 && int(st.Uid) != creds.uid {
+		return false
+	}
+
+	const writeSearch = 0o3
+	perm := info.Mode().Perm()
+	switch {
+	case int(st.Uid) == creds.uid:
+		return perm>>6&writeSearch == writeSearch
+	case int(st.Gid) == creds.gid:
+		return perm>>3&writeSearch == writeSearch
+	default:
+		return perm&writeSearch == writeSearch
+	}
+}
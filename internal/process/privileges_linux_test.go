@@ -0,0 +1,53 @@
+//go:build linux
+
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestRemovableBy(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	owner := credentials{uid: int(st.Uid), gid: int(st.Gid)}
+	other := credentials{uid: int(st.Uid) + 1000, gid: int(st.Gid) + 1000}
+
+	cases := []struct {
+		name  string
+		mode  os.FileMode
+		creds credentials
+		want  bool
+	}{
+		{name: "owner", mode: 0o755, creds: owner, want: true},
+		{name: "owner read-only", mode: 0o555, creds: owner, want: false},
+		{name: "other", mode: 0o755, creds: other, want: false},
+		{name: "group", mode: 0o775, creds: credentials{uid: other.uid, gid: owner.gid}, want: true},
+		{name: "world", mode: 0o777, creds: other, want: true},
+		{name: "sticky", mode: 0o777 | os.ModeSticky, creds: other, want: false},
+	}
+	for _, tc := range cases {
+		path := filepath.Join(dir, tc.name)
+		if err := os.Mkdir(path, 0o700); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.Chmod(path, tc.mode); err != nil {
+			t.Fatalf("chmod: %v", err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		if got := removableBy(info, tc.creds); got != tc.want {
+			t.Errorf("%s: expected %t, got %t", tc.name, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package process
+
+import "errors"
+
+// DropPrivileges is only supported on Linux hosts.
+func DropPrivileges(userName, groupName string) error {
+	if userName == "" && groupName == "" {
+		return nil
+	}
+	return errors.New("dropping privileges is supported on linux only")
+}
+
+// CheckPidfileDir has nothing to check where DropPrivileges is unsupported.
+func CheckPidfileDir(path, userName, groupName string) error {
+	return nil
+}
@@ -0,0 +1,67 @@
+package process
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPidfile_WriteAndRemove(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "rdma_exporter.pid")
+	if err := WritePidfile(path); err != nil {
+		t.Fatalf("WritePidfile returned error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read pidfile: %v", err)
+	}
+	if got, want := strings.TrimSpace(string(data)), strconv.Itoa(os.Getpid()); got != want {
+		t.Fatalf("expected pid %s, got %s", want, got)
+	}
+
+	if err := RemovePidfile(path); err != nil {
+		t.Fatalf("RemovePidfile returned error: %v", err)
+	}
+	if err := RemovePidfile(path); err != nil {
+		t.Fatalf("RemovePidfile on missing file returned error: %v", err)
+	}
+}
+
+func TestResolveCredentials_CurrentUser(t *testing.T) {
+	t.Parallel()
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("current user unavailable: %v", err)
+	}
+
+	creds, err := resolveCredentials(current.Username, "")
+	if err != nil {
+		t.Fatalf("resolveCredentials returned error: %v", err)
+	}
+	if strconv.Itoa(creds.uid) != current.Uid || strconv.Itoa(creds.gid) != current.Gid {
+		t.Fatalf("expected %s:%s, got %d:%d", current.Uid, current.Gid, creds.uid, creds.gid)
+	}
+
+	byID, err := resolveCredentials(current.Uid, current.Gid)
+	if err != nil {
+		t.Fatalf("resolveCredentials by id returned error: %v", err)
+	}
+	if byID != creds {
+		t.Fatalf("expected numeric lookup %+v to match %+v", byID, creds)
+	}
+}
+
+func TestResolveCredentials_UnknownUser(t *testing.T) {
+	t.Parallel()
+
+	if _, err := resolveCredentials("rdma-exporter-no-such-user", ""); err == nil {
+		t.Fatalf("expected error for unknown user")
+	}
+}
@@ -0,0 +1,96 @@
+package rdma
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// aerFiles maps the PCIe AER statistics files under the PCI device directory
+// to the severity they report. Each file holds "<error> <count>" lines.
+var aerFiles = map[string]string{
+	"aer_dev_correctable": "correctable",
+	"aer_dev_nonfatal":    "nonfatal",
+	"aer_dev_fatal":       "fatal",
+}
+
+// DeviceAER holds the PCIe Advanced Error Reporting counters of one RDMA
+// device's PCI function.
+type DeviceAER struct {
+	Device string
+	// Counters maps severity ("correctable", "nonfatal", "fatal") to error
+	// name (e.g. "BadTLP", "TOTAL_ERR_COR") to count.
+	Counters map[string]map[string]uint64
+}
+
+// AER reads PCIe AER counters for every non-excluded device. Devices whose
+// PCI function does not expose AER (no kernel support or a VF) are skipped.
+func (p *SysfsProvider) AER(ctx context.Context) ([]DeviceAER, error) {
+	p.mu.RLock()
+	root := p.sysfsRoot
+	p.mu.RUnlock()
+
+	classDir := filepath.Join(root, classInfinibandPath)
+	entries, err := os.ReadDir(classDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", classDir, err)
+	}
+
+	var result []DeviceAER
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name := entry.Name()
+		if p.isExcluded(name) {
+			continue
+		}
+
+		deviceDir := filepath.Join(classDir, name, deviceDirName)
+		counters := make(map[string]map[string]uint64)
+		for file, severity := range aerFiles {
+			raw, err := p.readFile(filepath.Join(deviceDir, file))
+			if err != nil {
+				continue
+			}
+			if parsed := parseAERStats(raw); len(parsed) > 0 {
+				counters[severity] = parsed
+			}
+		}
+		if len(counters) == 0 {
+			continue
+		}
+		result = append(result, DeviceAER{Device: name, Counters: counters})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Device < result[j].Device })
+	return result, nil
+}
+
+func parseAERStats(raw []byte) map[string]uint64 {
+	stats := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		stats[fields[0]] = value
+	}
+	return stats
+}
@@ -0,0 +1,286 @@
+package rdma
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// ChangeDetection trades freshness for fewer sysfs reads, for setups that
+// scrape every second. sysfs does not update file mtimes when a value
+// changes, so changes are detected by content instead: a port's attributes
+// are re-read early when its state files change, and counters that kept the
+// same value are polled less often. The zero value disables it.
+type ChangeDetection struct {
+	// AttributeRefresh re-reads device and port attributes only every this
+	// many reads, or earlier when the port's state or phys_state changes.
+	// Values below 2 read them every time.
+	AttributeRefresh int
+	// StableCounterAfter marks a counter stable once it kept its value for
+	// this many consecutive reads. 0 disables stable counter detection.
+	StableCounterAfter int
+	// StableCounterRefresh re-reads stable counters only every this many
+	// reads, so an increment of a stable counter shows up at most
+	// StableCounterRefresh-1 reads late. Values below 2 disable stable counter
+	// detection.
+	StableCounterRefresh int
+}
+
+func (c ChangeDetection) attributesEnabled() bool {
+	return c.AttributeRefresh > 1
+}
+
+func (c ChangeDetection) countersEnabled() bool {
+	return c.StableCounterAfter > 0 && c.StableCounterRefresh > 1
+}
+
+// deviceInfo is the device-level data of a Device, everything but its ports.
+type deviceInfo struct {
+	pciAddr    string
+	isVF       bool
+	pfDevice   string
+	pcieLink   PCIeLink
+	attributes DeviceAttributes
+}
+
+type cachedDeviceInfo struct {
+	info    deviceInfo
+	readGen uint64
+	seenGen uint64
+}
+
+type cachedPortAttributes struct {
+	// probe is the raw content of the port's state files when attr was read.
+	probe   string
+	attr    PortAttributes
+	readGen uint64
+	seenGen uint64
+}
+
+type cachedCounter struct {
+	value     uint64
+	unchanged int
+	readGen   uint64
+	seenGen   uint64
+}
+
+// changeTracker remembers what earlier reads returned. Every Devices call is
+// one generation; entries record the generation they were last read and seen
+// in.
+type changeTracker struct {
+	cfg ChangeDetection
+
+	mu       sync.Mutex
+	gen      uint64
+	devices  map[string]*cachedDeviceInfo
+	ports    map[string]*cachedPortAttributes
+	counters map[string]*cachedCounter
+}
+
+func newChangeTracker(cfg ChangeDetection) *changeTracker {
+	return &changeTracker{
+		cfg:      cfg,
+		devices:  make(map[string]*cachedDeviceInfo),
+		ports:    make(map[string]*cachedPortAttributes),
+		counters: make(map[string]*cachedCounter),
+	}
+}
+
+// SetChangeDetection configures change detection. It resets everything
+// remembered so far.
+func (p *SysfsProvider) SetChangeDetection(cfg ChangeDetection) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !cfg.attributesEnabled() && !cfg.countersEnabled() {
+		p.changes = nil
+		return
+	}
+	p.changes = newChangeTracker(cfg)
+}
+
+// InvalidateCache forgets the attributes and counters remembered by change
+// detection and closes cached counter files, so the next read sees sysfs as
+// it is now, e.g. right after a firmware upgrade or a cable swap.
+func (p *SysfsProvider) InvalidateCache() {
+	p.mu.RLock()
+	tracker := p.changes
+	fds := p.counterFDs
+	p.mu.RUnlock()
+
+	if tracker != nil {
+		tracker.reset()
+	}
+	if fds != nil {
+		fds.closeAll()
+	}
+}
+
+func (p *SysfsProvider) changeTracker() *changeTracker {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.changes
+}
+
+// begin starts a new generation and returns it.
+func (t *changeTracker) begin() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.gen++
+	return t.gen
+}
+
+// reset forgets every entry.
+func (t *changeTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.devices)
+	clear(t.ports)
+	clear(t.counters)
+}
+
+// prune forgets entries that were not seen in generation gen, such as
+// removed devices.
+func (t *changeTracker) prune(gen uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, entry := range t.devices {
+		if entry.seenGen < gen {
+			delete(t.devices, key)
+		}
+	}
+	for key, entry := range t.ports {
+		if entry.seenGen < gen {
+			delete(t.ports, key)
+		}
+	}
+	for key, entry := range t.counters {
+		if entry.seenGen < gen {
+			delete(t.counters, key)
+		}
+	}
+}
+
+func (t *changeTracker) cachedDevice(key string) (deviceInfo, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.devices[key]
+	if !ok || t.gen-entry.readGen >= uint64(t.cfg.AttributeRefresh) {
+		return deviceInfo{}, false
+	}
+	entry.seenGen = t.gen
+	return entry.info, true
+}
+
+func (t *changeTracker) storeDevice(key string, info deviceInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.devices[key] = &cachedDeviceInfo{info: info, readGen: t.gen, seenGen: t.gen}
+}
+
+func (t *changeTracker) cachedPort(key, probe string) (PortAttributes, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.ports[key]
+	if !ok || entry.probe != probe || t.gen-entry.readGen >= uint64(t.cfg.AttributeRefresh) {
+		return PortAttributes{}, false
+	}
+	entry.seenGen = t.gen
+	return entry.attr, true
+}
+
+func (t *changeTracker) storePort(key, probe string, attr PortAttributes) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ports[key] = &cachedPortAttributes{probe: probe, attr: attr, readGen: t.gen, seenGen: t.gen}
+}
+
+// stableCounter returns the remembered value of a stable counter whose
+// refresh is not due yet.
+func (t *changeTracker) stableCounter(path string) (uint64, bool) {
+	if !t.cfg.countersEnabled() {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.counters[path]
+	if !ok || entry.unchanged < t.cfg.StableCounterAfter || t.gen-entry.readGen >= uint64(t.cfg.StableCounterRefresh) {
+		return 0, false
+	}
+	entry.seenGen = t.gen
+	return entry.value, true
+}
+
+// observeCounter records a value read from sysfs.
+func (t *changeTracker) observeCounter(path string, value uint64) {
+	if !t.cfg.countersEnabled() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.counters[path]
+	if !ok {
+		entry = &cachedCounter{}
+		t.counters[path] = entry
+	}
+	if ok && entry.value == value {
+		entry.unchanged++
+	} else {
+		entry.unchanged = 0
+	}
+	entry.value = value
+	entry.readGen = t.gen
+	entry.seenGen = t.gen
+}
+
+// readDeviceInfoCached reads the device-level data of a device, reusing the
+// last result until the attribute refresh is due.
+func (p *SysfsProvider) readDeviceInfoCached(ctx context.Context, root, device string) (deviceInfo, error) {
+	tracker := p.changeTracker()
+	if tracker == nil || !tracker.cfg.attributesEnabled() {
+		return p.readDeviceInfo(ctx, root, device)
+	}
+	key := root + "\x00" + device
+	if info, ok := tracker.cachedDevice(key); ok {
+		return info, nil
+	}
+	info, err := p.readDeviceInfo(ctx, root, device)
+	if err != nil {
+		return deviceInfo{}, err
+	}
+	tracker.storeDevice(key, info)
+	return info, nil
+}
+
+// readPortAttributesCached reads the attributes of a port, reusing the last
+// result while the port's state files are unchanged and the attribute
+// refresh is not due.
+func (p *SysfsProvider) readPortAttributesCached(ctx context.Context, root, device string, port int) (PortAttributes, error) {
+	tracker := p.changeTracker()
+	if tracker == nil || !tracker.cfg.attributesEnabled() {
+		return p.readPortAttributes(ctx, root, device, port)
+	}
+	key, probe := p.portProbe(root, device, port)
+	if attr, ok := tracker.cachedPort(key, probe); ok {
+		return attr, nil
+	}
+	attr, err := p.readPortAttributes(ctx, root, device, port)
+	if err != nil {
+		return PortAttributes{}, err
+	}
+	tracker.storePort(key, probe, attr)
+	return attr, nil
+}
+
+// portProbe returns the cache key of a port and the content of its state
+// files, which change whenever the link goes up or down.
+func (p *SysfsProvider) portProbe(root, device string, port int) (key, probe string) {
+	portDir := filepath.Join(root, classInfinibandPath, device, portsDirName, strconv.Itoa(port))
+	for _, name := range []string{stateFile, physStateFile} {
+		// A missing file probes as empty, like readPortAttributes treats it.
+		data, _ := p.readFile(filepath.Join(portDir, name))
+		probe += string(data) + "\x00"
+	}
+	return portDir, probe
+}
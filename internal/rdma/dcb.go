@@ -0,0 +1,140 @@
+package rdma
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// dcbnl message layout and attributes.
+// ref. https://codebrowser.dev/linux/linux/include/uapi/linux/dcbnl.h.html
+const (
+	// dcbmsgLen is the size of struct dcbmsg, which precedes the attributes.
+	dcbmsgLen = 4
+
+	dcbCmdIEEEGet = 21
+
+	dcbAttrIfName  = 1
+	dcbAttrIEEE    = 13
+	dcbAttrIEEEETS = 1
+	dcbAttrIEEEPFC = 2
+
+	// struct ieee_ets: willing, ets_cap and cbs, then the 8-byte
+	// tc_tx_bw, tc_rx_bw, tc_tsa, prio_tc, ... arrays.
+	ieeeETSTxBWOffset   = 3
+	ieeeETSPrioTCOffset = 27
+	ieeeETSMinLen       = ieeeETSPrioTCOffset + DCBPriorities
+	// struct ieee_pfc: pfc_cap, pfc_en, mbc, padding, then the u16 delay.
+	ieeePFCEnOffset    = 1
+	ieeePFCDelayOffset = 4
+	ieeePFCMinLen      = ieeePFCDelayOffset + 2
+)
+
+// DCBPriorities is the number of IEEE 802.1p priorities, and of traffic
+// classes a netdev can have.
+const DCBPriorities = 8
+
+// errDCBUnsupported is returned by dcbConn when the netdev's driver does not
+// implement dcbnl.
+var errDCBUnsupported = errors.New("netdev does not support dcbnl")
+
+// DCBConfig is the IEEE 802.1Qaz/Qbb configuration of a netdev: which
+// priorities are lossless and how transmit bandwidth is shared between
+// traffic classes.
+type DCBConfig struct {
+	// PFC is false when the driver reports no PFC configuration.
+	PFC bool
+	// PFCEnabled has bit n set when PFC is enabled for priority n.
+	PFCEnabled uint8
+	// PFCDelay is the allowance for the round-trip delay of the link, in bits.
+	PFCDelay uint16
+
+	// ETS is false when the driver reports no ETS configuration.
+	ETS bool
+	// ETSBandwidth is the share of transmit bandwidth of each traffic class,
+	// in percent.
+	ETSBandwidth [DCBPriorities]uint8
+	// PriorityTC maps each priority to its traffic class.
+	PriorityTC [DCBPriorities]uint8
+}
+
+// dcbConn sends dcbnl requests over rtnetlink and returns the payloads of
+// the replies.
+type dcbConn interface {
+	request(ctx context.Context, payload []byte) ([][]byte, error)
+	Close() error
+}
+
+// DCBReader reads the DCB configuration of netdevs over dcbnl, the
+// rtnetlink interface behind the dcb and lldptool tools. It reports what the
+// driver has applied, whether configured from the host or negotiated by
+// firmware DCBX.
+type DCBReader struct {
+	conn dcbConn
+}
+
+// NewDCBReader opens an rtnetlink socket.
+func NewDCBReader() (*DCBReader, error) {
+	conn, err := dialDCB()
+	if err != nil {
+		return nil, fmt.Errorf("open dcbnl socket: %w", err)
+	}
+	return &DCBReader{conn: conn}, nil
+}
+
+// DCB returns the IEEE DCB configuration of netDev. A netdev whose driver
+// does not implement dcbnl has none, which is not an error.
+func (r *DCBReader) DCB(ctx context.Context, netDev string) (DCBConfig, error) {
+	payload := []byte{0, dcbCmdIEEEGet, 0, 0}
+	payload = appendNlattr(payload, dcbAttrIfName, append([]byte(netDev), 0))
+	replies, err := r.conn.request(ctx, payload)
+	if errors.Is(err, errDCBUnsupported) {
+		return DCBConfig{}, nil
+	}
+	if err != nil {
+		return DCBConfig{}, fmt.Errorf("dcbnl get %s: %w", netDev, err)
+	}
+	if len(replies) == 0 {
+		return DCBConfig{}, fmt.Errorf("dcbnl get %s: no reply", netDev)
+	}
+	config, err := parseDCBReply(replies[0])
+	if err != nil {
+		return DCBConfig{}, fmt.Errorf("dcbnl get %s: %w", netDev, err)
+	}
+	return config, nil
+}
+
+// Close closes the rtnetlink socket.
+func (r *DCBReader) Close() error {
+	return r.conn.Close()
+}
+
+// parseDCBReply parses the reply to DCB_CMD_IEEE_GET. The ETS and PFC
+// attributes are only present when the driver reports them.
+func parseDCBReply(data []byte) (DCBConfig, error) {
+	var config DCBConfig
+	if len(data) < dcbmsgLen {
+		return config, errors.New("truncated dcbnl message")
+	}
+	attrs, err := parseNlattrs(data[dcbmsgLen:])
+	if err != nil {
+		return config, err
+	}
+	ieee, err := parseNlattrs(attrs.get(dcbAttrIEEE))
+	if err != nil {
+		return config, err
+	}
+
+	if ets := ieee.get(dcbAttrIEEEETS); len(ets) >= ieeeETSMinLen {
+		config.ETS = true
+		copy(config.ETSBandwidth[:], ets[ieeeETSTxBWOffset:])
+		copy(config.PriorityTC[:], ets[ieeeETSPrioTCOffset:])
+	}
+	if pfc := ieee.get(dcbAttrIEEEPFC); len(pfc) >= ieeePFCMinLen {
+		config.PFC = true
+		config.PFCEnabled = pfc[ieeePFCEnOffset]
+		config.PFCDelay = binary.NativeEndian.Uint16(pfc[ieeePFCDelayOffset:])
+	}
+	return config, nil
+}
@@ -0,0 +1,30 @@
+package rdma
+
+import (
+	"context"
+	"time"
+)
+
+// WaitForDevices enumerates devices until at least one is found, waiting
+// initial between the first attempts and doubling the wait up to max. It
+// calls retry after every attempt that found no device, with the error of
+// that attempt if any. It returns when devices are found or ctx is done.
+func WaitForDevices(ctx context.Context, provider Provider, initial, max time.Duration, retry func(wait time.Duration, err error)) ([]Device, error) {
+	wait := initial
+	for {
+		devices, err := provider.Devices(ctx)
+		if err == nil && len(devices) > 0 {
+			return devices, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if retry != nil {
+			retry(wait, err)
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+		wait = min(wait*2, max)
+	}
+}
@@ -0,0 +1,103 @@
+package rdma
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// irdmaDriverName is the driver of the RDMA function of Intel E810 and X722
+// NICs, bound to an auxiliary device of the ice or i40e PCI driver.
+const irdmaDriverName = "irdma"
+
+// irdmaDeviceStatPrefixes match the hw_counters irdma gathers for the IP, TCP
+// and UDP traffic of the whole PCI function rather than for the RDMA traffic
+// of the port, e.g. ip4InOctets, tcpRetransSegs and RxUDP.
+var irdmaDeviceStatPrefixes = []string{"ip4", "ip6", "tcp", "rxVlan", "RxUDP", "TxUDP"}
+
+// cxgb4DriverName is the driver of Chelsio T5 and T6 adapters, whose iWARP
+// function is registered by iw_cxgb4.
+const cxgb4DriverName = "cxgb4"
+
+// cxgb4DeviceStatPrefixes match the hw_counters of the TCP offload engine
+// iw_cxgb4 publishes under every port, e.g. ip4InSegs and ip6OutRsts. The
+// engine is shared by all ports of the adapter.
+var cxgb4DeviceStatPrefixes = []string{"ip4", "ip6"}
+
+// hfi1DriverName is the driver of Intel Omni-Path host fabric interfaces.
+const hfi1DriverName = "hfi1"
+
+// hfi1VLStatPattern matches the hw_counters hfi1 keeps per virtual lane,
+// which carry the VL as a suffix, e.g. TxFlitVL0 and TxWaitVL15.
+var hfi1VLStatPattern = regexp.MustCompile(`^(.+)VL(\d+)$`)
+
+// applyDriverQuirks adjusts a device to the sysfs layout of its driver.
+func applyDriverQuirks(device *Device, driver string) {
+	switch driver {
+	case irdmaDriverName:
+		moveDeviceStats(device, irdmaDeviceStatPrefixes)
+	case cxgb4DriverName:
+		moveDeviceStats(device, cxgb4DeviceStatPrefixes)
+	case hfi1DriverName:
+		moveHFI1VLStats(device)
+	}
+}
+
+// moveDeviceStats moves the hw_counters matching prefixes, which drivers such
+// as irdma and cxgb4 publish under their ports although they count for the
+// whole function or adapter, to the device, so they are not mistaken for
+// counters of the port's RDMA traffic. With several ports, the copies agree
+// and the one of the last port is kept.
+func moveDeviceStats(device *Device, prefixes []string) {
+	for _, port := range device.Ports {
+		for name, value := range port.HwStats {
+			if !hasAnyPrefix(name, prefixes) {
+				continue
+			}
+			if device.HwStats == nil {
+				device.HwStats = make(map[string]uint64)
+			}
+			device.HwStats[name] = value
+			delete(port.HwStats, name)
+		}
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// moveHFI1VLStats moves the per-VL hw_counters of hfi1 ports to VLStats under
+// the name without the VL suffix, so each VL is a label value rather than a
+// metric of its own. Counters from hw_counters/vl<N> directories win over
+// suffixed ones of the same name.
+func moveHFI1VLStats(device *Device) {
+	for i := range device.Ports {
+		port := &device.Ports[i]
+		for name, value := range port.HwStats {
+			m := hfi1VLStatPattern.FindStringSubmatch(name)
+			if m == nil {
+				continue
+			}
+			vl, err := strconv.Atoi(m[2])
+			if err != nil {
+				continue
+			}
+			delete(port.HwStats, name)
+			if port.VLStats == nil {
+				port.VLStats = make(map[int]map[string]uint64)
+			}
+			if port.VLStats[vl] == nil {
+				port.VLStats[vl] = make(map[string]uint64)
+			}
+			if _, ok := port.VLStats[vl][m[1]]; !ok {
+				port.VLStats[vl][m[1]] = value
+			}
+		}
+	}
+}
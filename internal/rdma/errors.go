@@ -0,0 +1,42 @@
+package rdma
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// Providers wrap these errors so callers can branch with errors.Is instead of
+// matching messages.
+var (
+	// ErrNoDevices is returned by Devices when the host has no RDMA
+	// subsystem, i.e. /sys/class/infiniband does not exist because no RDMA
+	// driver is loaded. Callers serving a device list treat it as an empty
+	// one.
+	ErrNoDevices = errors.New("no rdma devices")
+	// ErrPermission is returned when a sysfs directory the provider walks
+	// cannot be read, typically because the exporter runs unprivileged or
+	// confined by an LSM. Single unreadable counter files are skipped with
+	// WarningCounterUnreadable instead.
+	ErrPermission = errors.New("rdma sysfs not readable")
+	// ErrUnsupportedLayout is returned when a sysfs path the provider walks
+	// exists but is not shaped as expected, e.g. a file where the kernel
+	// creates a directory.
+	ErrUnsupportedLayout = errors.New("unsupported rdma sysfs layout")
+)
+
+// classifyError wraps err with ErrPermission or ErrUnsupportedLayout when it
+// stems from a denied or misshapen sysfs path. Other errors are returned as
+// they are.
+func classifyError(err error) error {
+	switch {
+	case err == nil, errors.Is(err, ErrPermission), errors.Is(err, ErrUnsupportedLayout):
+		return err
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("%w: %w", ErrPermission, err)
+	case errors.Is(err, syscall.ENOTDIR):
+		return fmt.Errorf("%w: %w", ErrUnsupportedLayout, err)
+	}
+	return err
+}
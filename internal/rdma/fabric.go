@@ -0,0 +1,74 @@
+package rdma
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	gidsDirName = "gids"
+
+	defaultFabricIPv4PrefixLength = 24
+)
+
+// fabricFromGID derives a fabric identifier from a single GID.
+//
+// InfiniBand ports carry the subnet manager's subnet prefix in the upper 64
+// bits of every GID, so it is returned verbatim (e.g. "fe80:0000:0000:0000").
+// RoCE GIDs are IP addresses: link-local and zero GIDs are ignored, IPv6
+// addresses yield their /64 and IPv4-mapped addresses yield the network of
+// ipv4PrefixLen bits, since sysfs does not expose the netmask.
+func fabricFromGID(gid, linkLayer string, ipv4PrefixLen int) string {
+	gid = strings.TrimSpace(gid)
+	if linkLayer == "InfiniBand" {
+		parts := strings.Split(gid, ":")
+		if len(parts) != 8 {
+			return ""
+		}
+		return strings.Join(parts[:4], ":")
+	}
+
+	ip := net.ParseIP(gid)
+	if ip == nil || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		mask := net.CIDRMask(ipv4PrefixLen, 32)
+		return fmt.Sprintf("%s/%d", v4.Mask(mask), ipv4PrefixLen)
+	}
+	return fmt.Sprintf("%s/64", ip.Mask(net.CIDRMask(64, 128)))
+}
+
+// readPortFabric scans the port's GID table in index order and returns the
+// first fabric identifier it can derive.
+func (p *SysfsProvider) readPortFabric(portDir, linkLayer string, ipv4PrefixLen int) string {
+	dir := filepath.Join(portDir, gidsDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	indices := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		if idx, err := strconv.Atoi(entry.Name()); err == nil {
+			indices = append(indices, idx)
+		}
+	}
+	slices.Sort(indices)
+
+	for _, idx := range indices {
+		data, err := p.readFile(filepath.Join(dir, strconv.Itoa(idx)))
+		if err != nil {
+			continue
+		}
+		if fabric := fabricFromGID(string(data), linkLayer, ipv4PrefixLen); fabric != "" {
+			return fabric
+		}
+	}
+	return ""
+}
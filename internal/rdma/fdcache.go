@@ -0,0 +1,148 @@
+package rdma
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// counterFileBufSize fits any decimal uint64 plus a newline. Larger files are
+// read through readFile instead.
+const counterFileBufSize = 64
+
+// counterFDCache keeps counter files open between reads. sysfs regenerates an
+// attribute's content on every read at offset 0, so a pread on a cached
+// descriptor returns the current value with one syscall instead of the
+// open/read/close of os.ReadFile.
+type counterFDCache struct {
+	mu    sync.Mutex
+	gen   uint64
+	files map[string]*cachedFD
+}
+
+type cachedFD struct {
+	f       *os.File
+	seenGen uint64
+}
+
+func newCounterFDCache() *counterFDCache {
+	return &counterFDCache{files: make(map[string]*cachedFD)}
+}
+
+// SetCounterFDCache keeps counter and hw_counter files open across reads when
+// enabled. This trades one file descriptor per counter for fewer syscalls per
+// scrape. Disabling it closes all cached descriptors.
+func (p *SysfsProvider) SetCounterFDCache(enabled bool) {
+	p.mu.Lock()
+	old := p.counterFDs
+	p.counterFDs = nil
+	if enabled {
+		p.counterFDs = newCounterFDCache()
+	}
+	p.mu.Unlock()
+
+	if old != nil {
+		old.closeAll()
+	}
+}
+
+// Close releases the cached counter file descriptors.
+func (p *SysfsProvider) Close() error {
+	p.SetCounterFDCache(false)
+	return nil
+}
+
+func (p *SysfsProvider) counterFDCache() *counterFDCache {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.counterFDs
+}
+
+// readCounterFile reads a counter file, through a cached descriptor when the
+// cache is enabled. Any failure of a cached descriptor, such as ENODEV after
+// the device was removed, drops it and falls back to a fresh read.
+func (p *SysfsProvider) readCounterFile(path string) ([]byte, error) {
+	cache := p.counterFDCache()
+	if cache == nil {
+		return p.readFile(path)
+	}
+
+	f, err := cache.open(path)
+	if err != nil {
+		return p.readFile(path)
+	}
+	buf := make([]byte, counterFileBufSize)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		cache.drop(path, f)
+		return p.readFile(path)
+	}
+	if n == len(buf) {
+		return p.readFile(path)
+	}
+	return buf[:n], nil
+}
+
+// open returns the cached descriptor of path, opening it on first use.
+func (c *counterFDCache) open(path string) (*os.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.files[path]; ok {
+		entry.seenGen = c.gen
+		return entry.f, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	c.files[path] = &cachedFD{f: f, seenGen: c.gen}
+	return f, nil
+}
+
+func (c *counterFDCache) drop(path string, f *os.File) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.files[path]; ok && entry.f == f {
+		delete(c.files, path)
+	}
+	f.Close()
+}
+
+// begin starts a new generation and returns it.
+func (c *counterFDCache) begin() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	return c.gen
+}
+
+// prune closes descriptors not used in generation gen, such as those of
+// removed devices.
+func (c *counterFDCache) prune(gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, entry := range c.files {
+		if entry.seenGen < gen {
+			entry.f.Close()
+			delete(c.files, path)
+		}
+	}
+}
+
+func (c *counterFDCache) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, entry := range c.files {
+		entry.f.Close()
+		delete(c.files, path)
+	}
+}
+
+func (c *counterFDCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.files)
+}
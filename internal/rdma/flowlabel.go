@@ -0,0 +1,65 @@
+package rdma
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultProcfsRoot = "/proc"
+
+// ipv6SysctlDir holds the IPv6 flow label knobs.
+const ipv6SysctlDir = "sys/net/ipv6"
+
+// FlowLabelSettings captures the node's net.ipv6 flow label sysctls. They only
+// govern the flow labels of the kernel's IPv6 socket stack; RoCEv2 traffic
+// takes its UDP source port from the GRH flow label ib_core sets, which they
+// do not affect. Fields are empty when the kernel does not expose the
+// corresponding sysctl.
+type FlowLabelSettings struct {
+	AutoFlowLabels       string
+	FlowLabelStateRanges string
+	FlowLabelReflect     string
+}
+
+// Empty reports whether none of the knobs could be read.
+func (s FlowLabelSettings) Empty() bool {
+	return s == FlowLabelSettings{}
+}
+
+// SysctlProvider reads node-wide kernel settings from procfs.
+type SysctlProvider struct {
+	procfsRoot string
+}
+
+// NewSysctlProvider returns a SysctlProvider rooted at procfsRoot, falling back
+// to /proc when empty.
+func NewSysctlProvider(procfsRoot string) *SysctlProvider {
+	if procfsRoot == "" {
+		procfsRoot = defaultProcfsRoot
+	}
+	return &SysctlProvider{procfsRoot: filepath.Clean(procfsRoot)}
+}
+
+// FlowLabelSettings reads the net.ipv6 flow label sysctls.
+func (p *SysctlProvider) FlowLabelSettings(ctx context.Context) (FlowLabelSettings, error) {
+	if err := ctx.Err(); err != nil {
+		return FlowLabelSettings{}, err
+	}
+
+	dir := filepath.Join(p.procfsRoot, ipv6SysctlDir)
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	return FlowLabelSettings{
+		AutoFlowLabels:       read("auto_flowlabels"),
+		FlowLabelStateRanges: read("flowlabel_state_ranges"),
+		FlowLabelReflect:     read("flowlabel_reflect"),
+	}, nil
+}
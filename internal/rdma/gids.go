@@ -0,0 +1,114 @@
+package rdma
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const gidTypesDirName = "types"
+
+// GIDEntry is a populated entry of a port's GID table.
+type GIDEntry struct {
+	Device string
+	Port   int
+	Index  int
+	GID    string
+	// Type is the GID type as sysfs reports it, "IB/RoCE v1" or "RoCE v2".
+	// Empty for InfiniBand ports of kernels without gid_attrs.
+	Type string
+	// NetDev is the netdev, e.g. a VLAN interface, a RoCE GID belongs to.
+	NetDev string
+}
+
+// GIDTable returns the populated GID table entries of every port, as listed
+// by "show_gids", sorted by device, port and index. Zero GIDs mark unused
+// entries and are skipped. Excluded devices are skipped.
+func (p *SysfsProvider) GIDTable(ctx context.Context) ([]GIDEntry, error) {
+	p.mu.RLock()
+	root := p.sysfsRoot
+	p.mu.RUnlock()
+
+	ibDir := filepath.Join(root, classInfinibandPath)
+	devices, err := os.ReadDir(ibDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", ibDir, err)
+	}
+
+	var entries []GIDEntry
+	for _, device := range devices {
+		if p.isExcluded(device.Name()) {
+			continue
+		}
+		portsDir := filepath.Join(ibDir, device.Name(), portsDirName)
+		ports, err := os.ReadDir(portsDir)
+		if err != nil {
+			continue
+		}
+		for _, port := range ports {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			portID, err := strconv.Atoi(port.Name())
+			if err != nil {
+				continue
+			}
+			entries = append(entries, p.readPortGIDs(device.Name(), portID, filepath.Join(portsDir, port.Name()))...)
+		}
+	}
+	slices.SortFunc(entries, func(a, b GIDEntry) int {
+		return cmp.Or(cmp.Compare(a.Device, b.Device), cmp.Compare(a.Port, b.Port), cmp.Compare(a.Index, b.Index))
+	})
+	return entries, nil
+}
+
+// readPortGIDs reads the populated entries of one port's GID table. The
+// gid_attrs files of unused entries cannot be read, so they are only read
+// for non-zero GIDs.
+func (p *SysfsProvider) readPortGIDs(device string, port int, portDir string) []GIDEntry {
+	dir := filepath.Join(portDir, gidsDirName)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	read := func(path string) string {
+		data, err := p.readFile(path)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	var entries []GIDEntry
+	for _, file := range files {
+		index, err := strconv.Atoi(file.Name())
+		if err != nil {
+			continue
+		}
+		gid := read(filepath.Join(dir, file.Name()))
+		if ip := net.ParseIP(gid); ip == nil || ip.IsUnspecified() {
+			continue
+		}
+		entries = append(entries, GIDEntry{
+			Device: device,
+			Port:   port,
+			Index:  index,
+			GID:    gid,
+			Type:   read(filepath.Join(portDir, gidAttrsDirName, gidTypesDirName, file.Name())),
+			NetDev: read(filepath.Join(portDir, gidAttrsDirName, ndevsDirName, file.Name())),
+		})
+	}
+	return entries
+}
@@ -0,0 +1,52 @@
+package rdma
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// iWARP RNICs, such as Chelsio adapters (iw_cxgb4) and Intel NICs with irdma
+// in iWARP mode, run RDMA over TCP. Their ports report the Ethernet link
+// layer but have no InfiniBand addressing, so lid and sm_lid read 0, and no
+// RoCE GID attributes to find their netdev by.
+const (
+	iwarpNodeType = "RNIC"
+	netDirName    = "net"
+	devPortFile   = "dev_port"
+)
+
+// isIWARPDevice reports whether the node_type of device is an iWARP RNIC.
+func (p *SysfsProvider) isIWARPDevice(root, device string) bool {
+	data, err := p.readFile(filepath.Join(root, classInfinibandPath, device, nodeTypeFile))
+	if err != nil {
+		return false
+	}
+	return normalizePortState(strings.TrimSpace(string(data)), nodeTypeNames) == iwarpNodeType
+}
+
+// readIWARPNetDev returns the netdev of an iWARP port from the net directory
+// of the device's parent. An adapter with several ports lists a netdev per
+// port there, told apart by dev_port, which counts from 0 where RDMA ports
+// count from 1. Empty when there is none.
+func (p *SysfsProvider) readIWARPNetDev(root, device string, port int) string {
+	dir := filepath.Join(root, classInfinibandPath, device, deviceDirName, netDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	if len(entries) == 1 {
+		return entries[0].Name()
+	}
+	for _, entry := range entries {
+		data, err := p.readFile(filepath.Join(dir, entry.Name(), devPortFile))
+		if err != nil {
+			continue
+		}
+		if devPort, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && devPort == port-1 {
+			return entry.Name()
+		}
+	}
+	return ""
+}
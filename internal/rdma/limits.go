@@ -0,0 +1,75 @@
+package rdma
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// LimitResources lists the verbs resources whose maximum VerbsLimitProvider
+// reports, named like the kernel's resource tracking so a limit can be set
+// against the rdma_resource_* count of the same name.
+var LimitResources = []string{"qp", "cq", "mr", "pd", "srq", "ah", "mw"}
+
+var errVerbsNotBuiltIn = errors.New("device limits need libibverbs: build with cgo and the verbs build tag")
+
+// VerbsLimitProvider reads the device capability limits (max_qp, max_cq,
+// max_mr, ...) with ibv_query_device. The kernel publishes them neither in
+// sysfs nor over RDMA netlink, so it opens a verbs context on the device,
+// which needs read and write access to /dev/infiniband/uverbs*. It is only
+// functional in binaries built with cgo and the verbs build tag, see
+// VerbsBuiltIn.
+type VerbsLimitProvider struct {
+	mu sync.Mutex
+	// queries holds the query of each device that has not returned yet.
+	queries map[string]*deviceLimitsQuery
+}
+
+// deviceLimitsQuery is one ibv_query_device call; done is closed once limits
+// and err are set.
+type deviceLimitsQuery struct {
+	done   chan struct{}
+	limits map[string]uint64
+	err    error
+}
+
+// NewVerbsLimitProvider returns a VerbsLimitProvider, or an error when the
+// binary was built without libibverbs.
+func NewVerbsLimitProvider() (*VerbsLimitProvider, error) {
+	if !VerbsBuiltIn {
+		return nil, errVerbsNotBuiltIn
+	}
+	return &VerbsLimitProvider{queries: make(map[string]*deviceLimitsQuery)}, nil
+}
+
+// DeviceLimits maps the resources of LimitResources to the maximum the device
+// supports. ibv_query_device cannot be interrupted, so the query runs in its
+// own goroutine and is abandoned when ctx ends; a later call for the device
+// waits for that query instead of starting another one.
+func (p *VerbsLimitProvider) DeviceLimits(ctx context.Context, device string) (map[string]uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	query, ok := p.queries[device]
+	if !ok {
+		query = &deviceLimitsQuery{done: make(chan struct{})}
+		p.queries[device] = query
+		go func() {
+			query.limits, query.err = queryDeviceLimits(device)
+			p.mu.Lock()
+			delete(p.queries, device)
+			p.mu.Unlock()
+			close(query.done)
+		}()
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-query.done:
+		return query.limits, query.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux || !cgo || !verbs || sysfs_only
+
+package rdma
+
+// VerbsBuiltIn reports whether the binary links libibverbs; the verbs build
+// tag, which requires cgo, turns it on.
+const VerbsBuiltIn = false
+
+func queryDeviceLimits(string) (map[string]uint64, error) {
+	return nil, errVerbsNotBuiltIn
+}
@@ -0,0 +1,54 @@
+//go:build linux && cgo && verbs && !sysfs_only
+
+package rdma
+
+/*
+#cgo LDFLAGS: -libverbs
+#include <infiniband/verbs.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// VerbsBuiltIn reports whether the binary links libibverbs; the verbs build
+// tag, which requires cgo, turns it on.
+const VerbsBuiltIn = true
+
+func queryDeviceLimits(device string) (map[string]uint64, error) {
+	var n C.int
+	list, err := C.ibv_get_device_list(&n)
+	if list == nil {
+		return nil, fmt.Errorf("list verbs devices: %w", err)
+	}
+	defer C.ibv_free_device_list(list)
+
+	for _, dev := range unsafe.Slice(list, int(n)) {
+		if C.GoString(C.ibv_get_device_name(dev)) != device {
+			continue
+		}
+		vctx, err := C.ibv_open_device(dev)
+		if vctx == nil {
+			return nil, classifyError(fmt.Errorf("open verbs device %s: %w", device, err))
+		}
+		defer C.ibv_close_device(vctx)
+
+		var attr C.struct_ibv_device_attr
+		if rc := C.ibv_query_device(vctx, &attr); rc != 0 {
+			return nil, fmt.Errorf("query verbs device %s: %w", device, syscall.Errno(rc))
+		}
+		return map[string]uint64{
+			"qp":  uint64(attr.max_qp),
+			"cq":  uint64(attr.max_cq),
+			"mr":  uint64(attr.max_mr),
+			"pd":  uint64(attr.max_pd),
+			"srq": uint64(attr.max_srq),
+			"ah":  uint64(attr.max_ah),
+			"mw":  uint64(attr.max_mw),
+		}, nil
+	}
+	return nil, fmt.Errorf("verbs device %s not found", device)
+}
@@ -0,0 +1,81 @@
+package rdma
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const classNetPath = "class/net"
+
+// FabricNetDevs returns the network interfaces attached to RDMA devices: the
+// netdevs bound to RoCE ports via gid_attrs, plus any interface sharing a PCI
+// function with an RDMA device (e.g. IPoIB interfaces such as ib0).
+func (p *SysfsProvider) FabricNetDevs(ctx context.Context) ([]string, error) {
+	devices, err := p.Devices(ctx)
+	if err != nil && !errors.Is(err, ErrNoDevices) {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	root := p.sysfsRoot
+	p.mu.RUnlock()
+
+	names := make(map[string]bool)
+	pciAddrs := make(map[string]bool)
+	for _, device := range devices {
+		if device.PCIAddr != "" {
+			pciAddrs[device.PCIAddr] = true
+		}
+		for _, port := range device.Ports {
+			if port.Attributes.NetDev != "" {
+				names[port.Attributes.NetDev] = true
+			}
+		}
+	}
+
+	classDir := filepath.Join(root, classNetPath)
+	entries, err := os.ReadDir(classDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read %s: %w", classDir, err)
+	}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		_, addr := resolvePCIFunction(filepath.Join(classDir, entry.Name(), deviceDirName))
+		if addr != "" && pciAddrs[addr] {
+			names[entry.Name()] = true
+		}
+	}
+
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// netDevStatisticsDirName is the directory of generic interface counters
+// (rx_dropped, tx_errors, ...) that every netdev exposes in sysfs.
+const netDevStatisticsDirName = "statistics"
+
+// NetDevStatistics reads the generic counters in
+// /sys/class/net/<netDev>/statistics. Unlike ethtool stats they are readable
+// without CAP_NET_ADMIN.
+func (p *SysfsProvider) NetDevStatistics(ctx context.Context, netDev string) (map[string]uint64, error) {
+	p.mu.RLock()
+	root := p.sysfsRoot
+	p.mu.RUnlock()
+
+	stats, err := p.readCounterDir(ctx, filepath.Join(root, classNetPath, netDev, netDevStatisticsDirName))
+	if err != nil {
+		return nil, fmt.Errorf("read statistics for %s: %w", netDev, err)
+	}
+	return stats, nil
+}
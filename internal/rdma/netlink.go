@@ -0,0 +1,589 @@
+package rdma
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// NetlinkProviderName is the registry name of NetlinkProvider.
+const NetlinkProviderName = "netlink"
+
+// RDMA_NLDEV message types and attributes.
+// ref. https://codebrowser.dev/linux/linux/include/uapi/rdma/rdma_netlink.h.html
+const (
+	rdmaNLNldev = 5
+
+	nldevCmdGet     = 1
+	nldevCmdPortGet = 5
+	nldevCmdResGet  = 9
+	nldevCmdStatGet = 17
+
+	nldevCmdResQPGet  = 10
+	nldevCmdResMRGet  = 13
+	nldevCmdResCtxGet = 22
+
+	nldevAttrDevIndex           = 1
+	nldevAttrDevName            = 2
+	nldevAttrPortIndex          = 3
+	nldevAttrCapFlags           = 4
+	nldevAttrFWVersion          = 5
+	nldevAttrNodeGUID           = 6
+	nldevAttrSysImageGUID       = 7
+	nldevAttrSubnetPrefix       = 8
+	nldevAttrLID                = 9
+	nldevAttrSMLID              = 10
+	nldevAttrLMC                = 11
+	nldevAttrPortState          = 12
+	nldevAttrPortPhysState      = 13
+	nldevAttrDevNodeType        = 14
+	nldevAttrResSummary         = 15
+	nldevAttrResSummaryEntry    = 16
+	nldevAttrResSummaryName     = 17
+	nldevAttrResSummaryCurr     = 18
+	nldevAttrResQP              = 19
+	nldevAttrResQPEntry         = 20
+	nldevAttrResLQPN            = 21
+	nldevAttrResType            = 26
+	nldevAttrResPID             = 28
+	nldevAttrResKernName        = 29
+	nldevAttrResMR              = 40
+	nldevAttrResMREntry         = 41
+	nldevAttrNdevName           = 51
+	nldevAttrDevProtocol        = 67
+	nldevAttrStatMode           = 74
+	nldevAttrStatRes            = 75
+	nldevAttrStatCounter        = 77
+	nldevAttrStatCounterEntry   = 78
+	nldevAttrStatCounterID      = 79
+	nldevAttrStatHwCounters     = 80
+	nldevAttrStatHwCounterEntry = 81
+	nldevAttrStatHwCounterName  = 82
+	nldevAttrStatHwCounterValue = 83
+	nldevAttrResCtx             = 86
+	nldevAttrResCtxEntry        = 87
+)
+
+const (
+	// The message type of an RDMA netlink request is client<<10 | command.
+	nldevClientShift = 10
+
+	nlaHeaderLen = 4
+	nlaAlignTo   = 4
+	// nlaTypeMask strips the nested and byte order flags of an attribute type.
+	nlaTypeMask = 0x3fff
+)
+
+// nldevMessageType returns the netlink message type of an RDMA_NLDEV command.
+func nldevMessageType(cmd uint16) uint16 {
+	return rdmaNLNldev<<nldevClientShift | cmd
+}
+
+// nldevConn sends RDMA_NLDEV requests. request returns the attribute payloads
+// of the replies: one per object for dumps, a single one otherwise.
+type nldevConn interface {
+	request(ctx context.Context, cmd uint16, dump bool, attrs []byte) ([][]byte, error)
+	Close() error
+}
+
+// NetlinkProvider implements Provider on top of the RDMA netlink interface
+// (RDMA_NLDEV, as used by the rdma tool). Devices and ports are enumerated
+// with one dump each instead of a directory walk per device, and hw counters
+// come from the kernel's statistics API, which also reports optional counters
+// missing from sysfs. The standard IB counters are only published in sysfs,
+// so they are still read from each port's counters directory.
+//
+// Link width and speed, node descriptions, PCI information and RoCE fabrics
+// are not available over netlink and stay empty.
+type NetlinkProvider struct {
+	conn  nldevConn
+	sysfs *SysfsProvider
+	// procfsRoot is where the command names of resource owners are read.
+	procfsRoot string
+}
+
+// NewNetlinkProvider opens an RDMA netlink socket.
+func NewNetlinkProvider() (*NetlinkProvider, error) {
+	conn, err := dialNldev()
+	if err != nil {
+		return nil, fmt.Errorf("open rdma netlink socket: %w", err)
+	}
+	return newNetlinkProvider(conn), nil
+}
+
+func newNetlinkProvider(conn nldevConn) *NetlinkProvider {
+	return &NetlinkProvider{conn: conn, sysfs: NewSysfsProvider(), procfsRoot: defaultProcfsRoot}
+}
+
+// SetSysfsRoot overrides the sysfs root the standard IB counters are read
+// from. See SysfsProvider.SetSysfsRoot.
+func (p *NetlinkProvider) SetSysfsRoot(root string) error {
+	return p.sysfs.SetSysfsRoot(root)
+}
+
+// SetAllowedSysfsRoots restricts the sysfs root. See
+// SysfsProvider.SetAllowedSysfsRoots.
+func (p *NetlinkProvider) SetAllowedSysfsRoots(roots []string) error {
+	return p.sysfs.SetAllowedSysfsRoots(roots)
+}
+
+// SetExcludeDevices configures which devices should be completely skipped.
+func (p *NetlinkProvider) SetExcludeDevices(devices []string) {
+	p.sysfs.SetExcludeDevices(devices)
+}
+
+// SetCounterFDCache keeps the standard counter files open across reads. See
+// SysfsProvider.SetCounterFDCache.
+func (p *NetlinkProvider) SetCounterFDCache(enabled bool) {
+	p.sysfs.SetCounterFDCache(enabled)
+}
+
+// InvalidateCache drops cached sysfs state. See
+// SysfsProvider.InvalidateCache.
+func (p *NetlinkProvider) InvalidateCache() {
+	p.sysfs.InvalidateCache()
+}
+
+// NetDevStatistics reads the generic counters of netDev from sysfs. See
+// SysfsProvider.NetDevStatistics.
+func (p *NetlinkProvider) NetDevStatistics(ctx context.Context, netDev string) (map[string]uint64, error) {
+	return p.sysfs.NetDevStatistics(ctx, netDev)
+}
+
+// GIDTable reads the GID tables of every port from sysfs. See
+// SysfsProvider.GIDTable.
+func (p *NetlinkProvider) GIDTable(ctx context.Context) ([]GIDEntry, error) {
+	return p.sysfs.GIDTable(ctx)
+}
+
+// PKeyTable reads the P_Key tables of every port from sysfs. See
+// SysfsProvider.PKeyTable.
+func (p *NetlinkProvider) PKeyTable(ctx context.Context) ([]PKeyEntry, error) {
+	return p.sysfs.PKeyTable(ctx)
+}
+
+// RoCEConfigs reads the QoS configuration of every RoCE port from sysfs. See
+// SysfsProvider.RoCEConfigs.
+func (p *NetlinkProvider) RoCEConfigs(ctx context.Context) ([]RoCEConfig, error) {
+	return p.sysfs.RoCEConfigs(ctx)
+}
+
+// SetProcfsRoot overrides the procfs root the command names of resource
+// owners are read from.
+func (p *NetlinkProvider) SetProcfsRoot(root string) {
+	if root == "" {
+		root = defaultProcfsRoot
+	}
+	p.procfsRoot = filepath.Clean(root)
+}
+
+// Close closes the netlink socket and cached counter files.
+func (p *NetlinkProvider) Close() error {
+	return errors.Join(p.conn.Close(), p.sysfs.Close())
+}
+
+// nldevDevice is a device as reported by RDMA_NLDEV_CMD_GET.
+type nldevDevice struct {
+	index    uint32
+	name     string
+	protocol string
+	attrs    DeviceAttributes
+}
+
+// nldevPort is a port as reported by RDMA_NLDEV_CMD_PORT_GET.
+type nldevPort struct {
+	devIndex uint32
+	id       int
+	attrs    PortAttributes
+}
+
+// Devices returns a snapshot of RDMA devices and associated ports. Errors
+// wrap ErrPermission or ErrUnsupportedLayout like those of SysfsProvider.
+func (p *NetlinkProvider) Devices(ctx context.Context) ([]Device, error) {
+	devices, err := p.readDevices(ctx)
+	return devices, classifyError(err)
+}
+
+func (p *NetlinkProvider) readDevices(ctx context.Context) ([]Device, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	replies, err := p.conn.request(ctx, nldevCmdGet, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dump rdma devices: %w", err)
+	}
+	var nlDevices []nldevDevice
+	for _, reply := range replies {
+		dev, err := parseNldevDevice(reply)
+		if err != nil {
+			return nil, err
+		}
+		if p.sysfs.isExcluded(dev.name) {
+			continue
+		}
+		nlDevices = append(nlDevices, dev)
+	}
+	if len(nlDevices) == 0 {
+		return nil, nil
+	}
+
+	replies, err = p.conn.request(ctx, nldevCmdPortGet, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dump rdma ports: %w", err)
+	}
+	portsByDev := make(map[uint32][]nldevPort)
+	for _, reply := range replies {
+		port, err := parseNldevPort(reply)
+		if err != nil {
+			return nil, err
+		}
+		portsByDev[port.devIndex] = append(portsByDev[port.devIndex], port)
+	}
+
+	p.sysfs.mu.RLock()
+	root := p.sysfs.sysfsRoot
+	fds := p.sysfs.counterFDs
+	p.sysfs.mu.RUnlock()
+	var fdGen uint64
+	if fds != nil {
+		fdGen = fds.begin()
+	}
+
+	devices := make([]Device, 0, len(nlDevices))
+	for _, dev := range nlDevices {
+		// The driver decides how the ports are read.
+		dev.attrs.Driver = readDriver(filepath.Join(root, classInfinibandPath, dev.name, deviceDirName))
+		ports := make([]Port, 0, len(portsByDev[dev.index]))
+		for _, nlPort := range portsByDev[dev.index] {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			port, err := p.readPort(ctx, root, dev, nlPort)
+			if err != nil {
+				return nil, err
+			}
+			ports = append(ports, port)
+		}
+		slices.SortFunc(ports, func(a, b Port) int { return a.ID - b.ID })
+		// The statistics API only reports port counters.
+		hwStats, err := p.sysfs.readDeviceHwCounters(ctx, root, dev.name)
+		if err != nil {
+			return nil, err
+		}
+		dev.attrs.BoardID, dev.attrs.HCAType = p.sysfs.readBoardInfo(root, dev.name)
+		device := Device{
+			Name:       dev.name,
+			Attributes: dev.attrs,
+			HwStats:    hwStats,
+			Ports:      ports,
+		}
+		applyDriverQuirks(&device, dev.attrs.Driver)
+		devices = append(devices, device)
+	}
+	slices.SortFunc(devices, func(a, b Device) int { return strings.Compare(a.Name, b.Name) })
+
+	if fds != nil {
+		fds.prune(fdGen)
+	}
+	return devices, nil
+}
+
+func (p *NetlinkProvider) readPort(ctx context.Context, root string, dev nldevDevice, nlPort nldevPort) (Port, error) {
+	dir := filepath.Join(root, classInfinibandPath, dev.name, portsDirName, strconv.Itoa(nlPort.id))
+	stats, err := p.sysfs.readPortCounters(ctx, dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return Port{}, fmt.Errorf("read counters for %s port %d: %w", dev.name, nlPort.id, err)
+	}
+
+	var req []byte
+	req = appendNlattrU32(req, nldevAttrDevIndex, dev.index)
+	req = appendNlattrU32(req, nldevAttrPortIndex, uint32(nlPort.id))
+	var hwStats map[string]uint64
+	replies, err := p.conn.request(ctx, nldevCmdStatGet, false, req)
+	switch {
+	case err == nil && len(replies) > 0:
+		hwStats, err = parseNldevHwCounters(replies[0])
+		if err != nil {
+			return Port{}, fmt.Errorf("parse hw counters for %s port %d: %w", dev.name, nlPort.id, err)
+		}
+	case err != nil && !errors.Is(err, syscall.EOPNOTSUPP) && !errors.Is(err, syscall.EINVAL):
+		// Drivers without hw counters answer EOPNOTSUPP, like a missing
+		// hw_counters directory in sysfs.
+		return Port{}, fmt.Errorf("read hw counters for %s port %d: %w", dev.name, nlPort.id, err)
+	}
+	// The statistics API has no notion of virtual lanes, so hfi1, the only
+	// driver with per-VL subdirectories, still has them read from sysfs.
+	var vlStats map[int]map[string]uint64
+	if dev.attrs.Driver == hfi1DriverName {
+		hwDir := filepath.Join(dir, hwCountersDirName)
+		entries, err := os.ReadDir(hwDir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return Port{}, fmt.Errorf("read vl counters for %s port %d: %w", dev.name, nlPort.id, err)
+		}
+		vlStats, err = p.sysfs.readVLCounters(ctx, hwDir, entries)
+		if err != nil {
+			return Port{}, fmt.Errorf("read vl counters for %s port %d: %w", dev.name, nlPort.id, err)
+		}
+	}
+
+	attrs := nlPort.attrs
+	attrs.LinkLayer = linkLayerFromProtocol(dev.protocol)
+	if attrs.LinkLayer != "InfiniBand" {
+		// The subnet prefix only identifies InfiniBand fabrics.
+		attrs.Fabric = ""
+	}
+	return Port{
+		ID:         nlPort.id,
+		Stats:      stats,
+		HwStats:    hwStats,
+		Attributes: attrs,
+		VLStats:    vlStats,
+	}, nil
+}
+
+// ResourceCounts returns the number of allocated verbs objects per device
+// and resource type ("qp", "cq", "mr", "pd", "ctx", "srq", "cm_id"), as shown
+// by "rdma resource show". Excluded devices are skipped.
+func (p *NetlinkProvider) ResourceCounts(ctx context.Context) (map[string]map[string]uint64, error) {
+	replies, err := p.conn.request(ctx, nldevCmdResGet, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dump rdma resources: %w", err)
+	}
+	counts := make(map[string]map[string]uint64, len(replies))
+	for _, reply := range replies {
+		device, resources, err := parseNldevResources(reply)
+		if err != nil {
+			return nil, err
+		}
+		if p.sysfs.isExcluded(device) {
+			continue
+		}
+		counts[device] = resources
+	}
+	return counts, nil
+}
+
+func parseNldevResources(data []byte) (string, map[string]uint64, error) {
+	attrs, err := parseNlattrs(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse rdma resources: %w", err)
+	}
+	device := attrs.str(nldevAttrDevName)
+	if device == "" {
+		return "", nil, errors.New("parse rdma resources: missing device name")
+	}
+	entries, err := parseNlattrs(attrs.get(nldevAttrResSummary))
+	if err != nil {
+		return "", nil, fmt.Errorf("parse rdma resources of %s: %w", device, err)
+	}
+	resources := make(map[string]uint64, len(entries))
+	for _, entry := range entries {
+		if entry.typ != nldevAttrResSummaryEntry {
+			continue
+		}
+		fields, err := parseNlattrs(entry.data)
+		if err != nil {
+			return "", nil, fmt.Errorf("parse rdma resources of %s: %w", device, err)
+		}
+		name := fields.str(nldevAttrResSummaryName)
+		if name == "" || !fields.has(nldevAttrResSummaryCurr) {
+			continue
+		}
+		resources[name] = fields.u64(nldevAttrResSummaryCurr)
+	}
+	return device, resources, nil
+}
+
+// linkLayerFromProtocol maps RDMA_NLDEV_ATTR_DEV_PROTOCOL to the link_layer
+// values of sysfs.
+func linkLayerFromProtocol(protocol string) string {
+	switch protocol {
+	case "ib", "opa":
+		return "InfiniBand"
+	case "roce", "iw":
+		return "Ethernet"
+	}
+	return ""
+}
+
+func parseNldevDevice(data []byte) (nldevDevice, error) {
+	attrs, err := parseNlattrs(data)
+	if err != nil {
+		return nldevDevice{}, fmt.Errorf("parse rdma device: %w", err)
+	}
+	dev := nldevDevice{
+		index:    attrs.u32(nldevAttrDevIndex),
+		name:     attrs.str(nldevAttrDevName),
+		protocol: attrs.str(nldevAttrDevProtocol),
+		attrs: DeviceAttributes{
+			FWVer:    attrs.str(nldevAttrFWVersion),
+			NodeType: nodeTypeNames[int(attrs.u8(nldevAttrDevNodeType))],
+		},
+	}
+	if attrs.has(nldevAttrNodeGUID) {
+		dev.attrs.NodeGUID = formatGUID(attrs.u64(nldevAttrNodeGUID))
+	}
+	if attrs.has(nldevAttrSysImageGUID) {
+		dev.attrs.SysImageGUID = formatGUID(attrs.u64(nldevAttrSysImageGUID))
+	}
+	if dev.name == "" {
+		return nldevDevice{}, errors.New("parse rdma device: missing device name")
+	}
+	return dev, nil
+}
+
+func parseNldevPort(data []byte) (nldevPort, error) {
+	attrs, err := parseNlattrs(data)
+	if err != nil {
+		return nldevPort{}, fmt.Errorf("parse rdma port: %w", err)
+	}
+	if !attrs.has(nldevAttrDevIndex) || !attrs.has(nldevAttrPortIndex) {
+		return nldevPort{}, errors.New("parse rdma port: missing device or port index")
+	}
+	port := nldevPort{
+		devIndex: attrs.u32(nldevAttrDevIndex),
+		id:       int(attrs.u32(nldevAttrPortIndex)),
+		attrs: PortAttributes{
+			State:     portStateNames[int(attrs.u8(nldevAttrPortState))],
+			PhysState: portPhysStateNames[int(attrs.u8(nldevAttrPortPhysState))],
+			NetDev:    attrs.str(nldevAttrNdevName),
+		},
+	}
+	if attrs.has(nldevAttrSubnetPrefix) {
+		port.attrs.Fabric = formatGUID(attrs.u64(nldevAttrSubnetPrefix))
+	}
+	// The kernel only reports LIDs for InfiniBand ports.
+	if attrs.has(nldevAttrLID) {
+		port.attrs.LID = attrs.u32(nldevAttrLID)
+		port.attrs.SMLID = attrs.u32(nldevAttrSMLID)
+		port.attrs.LMC = uint32(attrs.u8(nldevAttrLMC))
+		// The upper 32 bits hold cap_mask2, which sysfs does not show.
+		port.attrs.CapMask = uint32(attrs.u64(nldevAttrCapFlags))
+		port.attrs.HasLID = true
+	}
+	return port, nil
+}
+
+func parseNldevHwCounters(data []byte) (map[string]uint64, error) {
+	attrs, err := parseNlattrs(data)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseNlattrs(attrs.get(nldevAttrStatHwCounters))
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string]uint64, len(entries))
+	for _, entry := range entries {
+		if entry.typ != nldevAttrStatHwCounterEntry {
+			continue
+		}
+		fields, err := parseNlattrs(entry.data)
+		if err != nil {
+			return nil, err
+		}
+		name := fields.str(nldevAttrStatHwCounterName)
+		if name == "" || !fields.has(nldevAttrStatHwCounterValue) {
+			continue
+		}
+		counters[name] = fields.u64(nldevAttrStatHwCounterValue)
+	}
+	return counters, nil
+}
+
+// formatGUID formats a 64-bit GUID or subnet prefix like sysfs does, e.g.
+// "ec0d:9a03:0078:6d28".
+func formatGUID(v uint64) string {
+	return fmt.Sprintf("%04x:%04x:%04x:%04x", v>>48, v>>32&0xffff, v>>16&0xffff, v&0xffff)
+}
+
+type nlattr struct {
+	typ  uint16
+	data []byte
+}
+
+type nlattrs []nlattr
+
+// parseNlattrs splits a netlink attribute stream. Nested attributes are left
+// for the caller to parse.
+func parseNlattrs(b []byte) (nlattrs, error) {
+	var attrs nlattrs
+	for len(b) > 0 {
+		if len(b) < nlaHeaderLen {
+			return nil, errors.New("truncated netlink attribute header")
+		}
+		length := int(binary.NativeEndian.Uint16(b[0:2]))
+		typ := binary.NativeEndian.Uint16(b[2:4]) & nlaTypeMask
+		if length < nlaHeaderLen || length > len(b) {
+			return nil, fmt.Errorf("invalid netlink attribute length %d", length)
+		}
+		attrs = append(attrs, nlattr{typ: typ, data: b[nlaHeaderLen:length]})
+		b = b[min(nlaAlign(length), len(b)):]
+	}
+	return attrs, nil
+}
+
+func nlaAlign(n int) int {
+	return (n + nlaAlignTo - 1) &^ (nlaAlignTo - 1)
+}
+
+func (a nlattrs) get(typ uint16) []byte {
+	for _, attr := range a {
+		if attr.typ == typ {
+			return attr.data
+		}
+	}
+	return nil
+}
+
+func (a nlattrs) has(typ uint16) bool {
+	return slices.ContainsFunc(a, func(attr nlattr) bool { return attr.typ == typ })
+}
+
+func (a nlattrs) str(typ uint16) string {
+	return strings.TrimRight(string(a.get(typ)), "\x00")
+}
+
+func (a nlattrs) u8(typ uint16) uint8 {
+	if data := a.get(typ); len(data) >= 1 {
+		return data[0]
+	}
+	return 0
+}
+
+func (a nlattrs) u32(typ uint16) uint32 {
+	if data := a.get(typ); len(data) >= 4 {
+		return binary.NativeEndian.Uint32(data)
+	}
+	return 0
+}
+
+func (a nlattrs) u64(typ uint16) uint64 {
+	if data := a.get(typ); len(data) >= 8 {
+		return binary.NativeEndian.Uint64(data)
+	}
+	return 0
+}
+
+func appendNlattr(b []byte, typ uint16, data []byte) []byte {
+	b = binary.NativeEndian.AppendUint16(b, uint16(nlaHeaderLen+len(data)))
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	b = append(b, data...)
+	for len(b)%nlaAlignTo != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func appendNlattrU32(b []byte, typ uint16, v uint32) []byte {
+	return appendNlattr(b, typ, binary.NativeEndian.AppendUint32(nil, v))
+}
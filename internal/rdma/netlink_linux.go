@@ -0,0 +1,164 @@
+//go:build linux && !sysfs_only
+
+package rdma
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// nldevRequestTimeout bounds a request whose context has no deadline.
+	nldevRequestTimeout = 10 * time.Second
+	nldevRecvBufSize    = 64 << 10
+)
+
+// netlinkSocket is a netlink socket. Requests are serialized so replies can
+// be matched by sequence number.
+type netlinkSocket struct {
+	mu  sync.Mutex
+	fd  int
+	seq uint32
+}
+
+func dialNetlink(protocol int) (*netlinkSocket, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, protocol)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &netlinkSocket{fd: fd}, nil
+}
+
+// nldevSocket is an RDMA netlink socket.
+type nldevSocket struct {
+	*netlinkSocket
+}
+
+func dialNldev() (nldevConn, error) {
+	s, err := dialNetlink(unix.NETLINK_RDMA)
+	if err != nil {
+		return nil, err
+	}
+	return nldevSocket{s}, nil
+}
+
+func (s nldevSocket) request(ctx context.Context, cmd uint16, dump bool, attrs []byte) ([][]byte, error) {
+	flags := uint16(unix.NLM_F_REQUEST)
+	if dump {
+		flags |= unix.NLM_F_DUMP
+	} else {
+		flags |= unix.NLM_F_ACK
+	}
+	return s.exchange(ctx, nldevMessageType(cmd), flags, attrs)
+}
+
+// dcbSocket is an rtnetlink socket for dcbnl requests.
+type dcbSocket struct {
+	*netlinkSocket
+}
+
+func dialDCB() (dcbConn, error) {
+	s, err := dialNetlink(unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	return dcbSocket{s}, nil
+}
+
+func (s dcbSocket) request(ctx context.Context, payload []byte) ([][]byte, error) {
+	replies, err := s.exchange(ctx, unix.RTM_GETDCB, unix.NLM_F_REQUEST|unix.NLM_F_ACK, payload)
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		return nil, errDCBUnsupported
+	}
+	return replies, err
+}
+
+// exchange sends a message of type typ and returns the payloads of its
+// replies, up to the end of a dump or the acknowledgement of a request.
+func (s *netlinkSocket) exchange(ctx context.Context, typ, flags uint16, payload []byte) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(nldevRequestTimeout)
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(s.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return nil, err
+	}
+
+	s.seq++
+	msg := make([]byte, 0, unix.NLMSG_HDRLEN+len(payload))
+	msg = binary.NativeEndian.AppendUint32(msg, uint32(unix.NLMSG_HDRLEN+len(payload)))
+	msg = binary.NativeEndian.AppendUint16(msg, typ)
+	msg = binary.NativeEndian.AppendUint16(msg, flags)
+	msg = binary.NativeEndian.AppendUint32(msg, s.seq)
+	msg = binary.NativeEndian.AppendUint32(msg, 0)
+	msg = append(msg, payload...)
+	if err := unix.Sendto(s.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var replies [][]byte
+	for {
+		buf := make([]byte, nldevRecvBufSize)
+		n, _, err := unix.Recvfrom(s.fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				return nil, fmt.Errorf("no netlink reply within %s", timeout)
+			}
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != s.seq {
+				// A late reply to an earlier, timed out request.
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return replies, nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, errors.New("truncated netlink error message")
+				}
+				if errno := int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+					return nil, unix.Errno(-errno)
+				}
+				// The acknowledgement of a non-dump request.
+				return replies, nil
+			default:
+				replies = append(replies, m.Data)
+			}
+		}
+	}
+}
+
+func (s *netlinkSocket) Close() error {
+	return unix.Close(s.fd)
+}
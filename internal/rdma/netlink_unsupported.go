@@ -0,0 +1,13 @@
+//go:build !linux || sysfs_only
+
+package rdma
+
+import "errors"
+
+func dialNldev() (nldevConn, error) {
+	return nil, errors.New("rdma netlink is supported on linux only and is left out by the sysfs_only build tag")
+}
+
+func dialDCB() (dcbConn, error) {
+	return nil, errors.New("dcbnl is supported on linux only and is left out by the sysfs_only build tag")
+}
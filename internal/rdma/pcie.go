@@ -0,0 +1,96 @@
+package rdma
+
+import (
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	currentLinkSpeedFile = "current_link_speed"
+	currentLinkWidthFile = "current_link_width"
+)
+
+// PCIeLink describes a negotiated PCIe link.
+type PCIeLink struct {
+	// SpeedGTs is the per-lane transfer rate in GT/s (e.g. 8 for Gen3).
+	SpeedGTs float64
+	// Width is the number of negotiated lanes.
+	Width int
+}
+
+// Known reports whether both speed and width were read.
+func (l PCIeLink) Known() bool {
+	return l.SpeedGTs > 0 && l.Width > 0
+}
+
+// BandwidthBps returns the usable unidirectional bandwidth in bits per second
+// after line encoding: 8b/10b up to Gen2 and 128b/130b from Gen3. Gen6 FLIT
+// mode overhead is not modeled.
+func (l PCIeLink) BandwidthBps() float64 {
+	if !l.Known() {
+		return 0
+	}
+	efficiency := 128.0 / 130.0
+	if l.SpeedGTs < 8 {
+		efficiency = 8.0 / 10.0
+	}
+	return l.SpeedGTs * 1e9 * float64(l.Width) * efficiency
+}
+
+// readPCIeLink reads current_link_speed ("8.0 GT/s PCIe") and
+// current_link_width ("16") from a PCI function directory.
+func (p *SysfsProvider) readPCIeLink(pciDir string) PCIeLink {
+	var link PCIeLink
+	if raw, err := p.readFile(filepath.Join(pciDir, currentLinkSpeedFile)); err == nil {
+		if fields := strings.Fields(string(raw)); len(fields) > 0 {
+			if speed, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				link.SpeedGTs = speed
+			}
+		}
+	}
+	if raw, err := p.readFile(filepath.Join(pciDir, currentLinkWidthFile)); err == nil {
+		if width, err := strconv.Atoi(strings.TrimSpace(string(raw))); err == nil {
+			link.Width = width
+		}
+	}
+	return link
+}
+
+// LinkRateBps parses the port rate attribute ("200 Gb/sec (4X HDR)") into bits
+// per second.
+func LinkRateBps(rate string) (float64, bool) {
+	fields := strings.Fields(rate)
+	if len(fields) < 2 || fields[1] != "Gb/sec" {
+		return 0, false
+	}
+	gbps, err := strconv.ParseFloat(fields[0], 64)
+	// ParseFloat accepts "Inf" and "NaN", which no link runs at.
+	if err != nil || gbps <= 0 || math.IsInf(gbps, 0) || math.IsNaN(gbps) {
+		return 0, false
+	}
+	return gbps * 1e9, true
+}
+
+// LinkWidthLanes parses a port width ("4X") into its number of lanes. It
+// falls back to the width in the rate attribute ("100 Gb/sec (4X EDR)") when
+// width is empty.
+func LinkWidthLanes(width, rate string) (int, bool) {
+	if width == "" {
+		open := strings.IndexByte(rate, '(')
+		if open < 0 {
+			return 0, false
+		}
+		fields := strings.Fields(rate[open+1:])
+		if len(fields) == 0 {
+			return 0, false
+		}
+		width = strings.TrimSuffix(fields[0], ")")
+	}
+	lanes, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(width), "X"))
+	if err != nil || lanes <= 0 {
+		return 0, false
+	}
+	return lanes, true
+}
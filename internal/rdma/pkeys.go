@@ -0,0 +1,102 @@
+package rdma
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const pkeysDirName = "pkeys"
+
+// PKeyEntry is a populated entry of a port's partition key table.
+type PKeyEntry struct {
+	Device string
+	Port   int
+	Index  int
+	// PKey is the partition key, e.g. "0xffff". Bit 15 is set for full
+	// members and clear for limited members of the partition.
+	PKey string
+}
+
+// PKeyTable returns the populated P_Key table entries of every port, sorted
+// by device, port and index. Entries whose partition number is zero mark
+// unused entries and are skipped. Excluded devices are skipped.
+func (p *SysfsProvider) PKeyTable(ctx context.Context) ([]PKeyEntry, error) {
+	p.mu.RLock()
+	root := p.sysfsRoot
+	p.mu.RUnlock()
+
+	ibDir := filepath.Join(root, classInfinibandPath)
+	devices, err := os.ReadDir(ibDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", ibDir, err)
+	}
+
+	var entries []PKeyEntry
+	for _, device := range devices {
+		if p.isExcluded(device.Name()) {
+			continue
+		}
+		portsDir := filepath.Join(ibDir, device.Name(), portsDirName)
+		ports, err := os.ReadDir(portsDir)
+		if err != nil {
+			continue
+		}
+		for _, port := range ports {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			portID, err := strconv.Atoi(port.Name())
+			if err != nil {
+				continue
+			}
+			entries = append(entries, p.readPortPKeys(device.Name(), portID, filepath.Join(portsDir, port.Name()))...)
+		}
+	}
+	slices.SortFunc(entries, func(a, b PKeyEntry) int {
+		return cmp.Or(cmp.Compare(a.Device, b.Device), cmp.Compare(a.Port, b.Port), cmp.Compare(a.Index, b.Index))
+	})
+	return entries, nil
+}
+
+// readPortPKeys reads the populated entries of one port's P_Key table.
+func (p *SysfsProvider) readPortPKeys(device string, port int, portDir string) []PKeyEntry {
+	dir := filepath.Join(portDir, pkeysDirName)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var entries []PKeyEntry
+	for _, file := range files {
+		index, err := strconv.Atoi(file.Name())
+		if err != nil {
+			continue
+		}
+		data, err := p.readFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		pkey, err := strconv.ParseUint(strings.TrimSpace(string(data)), 0, 16)
+		if err != nil || pkey&0x7fff == 0 {
+			continue
+		}
+		entries = append(entries, PKeyEntry{
+			Device: device,
+			Port:   port,
+			Index:  index,
+			PKey:   fmt.Sprintf("0x%04x", pkey),
+		})
+	}
+	return entries
+}
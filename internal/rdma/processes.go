@@ -0,0 +1,163 @@
+package rdma
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// processResourceKind describes how the objects of a resource type are
+// dumped over netlink.
+type processResourceKind struct {
+	name  string
+	cmd   uint16
+	nest  uint16
+	entry uint16
+}
+
+// processResourceKinds lists the resource types ProcessResourceCounts breaks
+// down by owner.
+var processResourceKinds = []processResourceKind{
+	{name: "qp", cmd: nldevCmdResQPGet, nest: nldevAttrResQP, entry: nldevAttrResQPEntry},
+	{name: "mr", cmd: nldevCmdResMRGet, nest: nldevAttrResMR, entry: nldevAttrResMREntry},
+	{name: "ctx", cmd: nldevCmdResCtxGet, nest: nldevAttrResCtx, entry: nldevAttrResCtxEntry},
+}
+
+// ProcessResourceCount is the number of objects of one resource type that an
+// owner holds on a device.
+type ProcessResourceCount struct {
+	Device string
+	// Resource is "qp", "mr" or "ctx".
+	Resource string
+	// PID is 0 for objects owned by the kernel, whose Comm is the owning
+	// module in brackets, e.g. "[ib_core]".
+	PID int
+	// Comm is the command name of the process, or empty when it exited
+	// before it could be read.
+	Comm  string
+	Count uint64
+}
+
+// nldevOwner identifies the owner of a resource object.
+type nldevOwner struct {
+	pid  int
+	kern string
+}
+
+// ProcessResourceCounts returns the number of QPs, MRs and user contexts per
+// device and owning process, as listed by "rdma resource show qp|mr|ctx",
+// sorted by device, resource and PID. The kernel only reports objects of
+// processes in the caller's PID namespace. Resource types the kernel cannot
+// dump are skipped. Excluded devices are skipped.
+func (p *NetlinkProvider) ProcessResourceCounts(ctx context.Context) ([]ProcessResourceCount, error) {
+	replies, err := p.conn.request(ctx, nldevCmdGet, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dump rdma devices: %w", err)
+	}
+
+	comms := make(map[int]string)
+	var counts []ProcessResourceCount
+	for _, reply := range replies {
+		dev, err := parseNldevDevice(reply)
+		if err != nil {
+			return nil, err
+		}
+		if p.sysfs.isExcluded(dev.name) {
+			continue
+		}
+		req := appendNlattrU32(nil, nldevAttrDevIndex, dev.index)
+		for _, kind := range processResourceKinds {
+			objects, err := p.conn.request(ctx, kind.cmd, true, req)
+			if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.EINVAL) {
+				// Older kernels cannot dump user contexts.
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("dump %s resources of %s: %w", kind.name, dev.name, err)
+			}
+			owners := make(map[nldevOwner]uint64)
+			for _, object := range objects {
+				if err := parseNldevResourceOwners(dev.name, kind, object, owners); err != nil {
+					return nil, err
+				}
+			}
+			for owner, count := range owners {
+				counts = append(counts, ProcessResourceCount{
+					Device:   dev.name,
+					Resource: kind.name,
+					PID:      owner.pid,
+					Comm:     p.ownerComm(owner, comms),
+					Count:    count,
+				})
+			}
+		}
+	}
+	slices.SortFunc(counts, func(a, b ProcessResourceCount) int {
+		return cmp.Or(
+			cmp.Compare(a.Device, b.Device),
+			cmp.Compare(a.Resource, b.Resource),
+			cmp.Compare(a.PID, b.PID),
+			cmp.Compare(a.Comm, b.Comm),
+		)
+	})
+	return counts, nil
+}
+
+// parseNldevResourceOwners adds the owners of the objects in one reply of a
+// resource dump to owners.
+func parseNldevResourceOwners(device string, kind processResourceKind, data []byte, owners map[nldevOwner]uint64) error {
+	attrs, err := parseNlattrs(data)
+	if err != nil {
+		return fmt.Errorf("parse %s resources of %s: %w", kind.name, device, err)
+	}
+	entries, err := parseNlattrs(attrs.get(kind.nest))
+	if err != nil {
+		return fmt.Errorf("parse %s resources of %s: %w", kind.name, device, err)
+	}
+	for _, entry := range entries {
+		if entry.typ != kind.entry {
+			continue
+		}
+		fields, err := parseNlattrs(entry.data)
+		if err != nil {
+			return fmt.Errorf("parse %s resources of %s: %w", kind.name, device, err)
+		}
+		var owner nldevOwner
+		switch {
+		case fields.has(nldevAttrResPID):
+			owner.pid = int(fields.u32(nldevAttrResPID))
+		case fields.has(nldevAttrResKernName):
+			owner.kern = fields.str(nldevAttrResKernName)
+		}
+		owners[owner]++
+	}
+	return nil
+}
+
+// ownerComm returns the command name of owner, reading it from procfs once
+// per process and call.
+func (p *NetlinkProvider) ownerComm(owner nldevOwner, comms map[int]string) string {
+	if owner.pid == 0 {
+		if owner.kern == "" {
+			return ""
+		}
+		return "[" + owner.kern + "]"
+	}
+	if comm, ok := comms[owner.pid]; ok {
+		return comm
+	}
+	data, err := os.ReadFile(filepath.Join(p.procfsRoot, strconv.Itoa(owner.pid), "comm"))
+	comm := ""
+	if err == nil {
+		comm = strings.TrimSpace(string(data))
+	}
+	comms[owner.pid] = comm
+	return comm
+}
@@ -7,10 +7,12 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 	"unicode"
 )
 
@@ -22,12 +24,27 @@ const (
 	gidAttrsDirName     = "gid_attrs"
 	ndevsDirName        = "ndevs"
 	countersDirName     = "counters"
+	countersExtDirName  = "counters_ext"
 	hwCountersDirName   = "hw_counters"
 	linkLayerFile       = "link_layer"
 	stateFile           = "state"
 	physStateFile       = "phys_state"
 	linkWidthFile       = "link_width"
 	rateFile            = "rate"
+	ibdevFile           = "ibdev"
+	portFile            = "port"
+	fwVerFile           = "fw_ver"
+	nodeGUIDFile        = "node_guid"
+	nodeDescFile        = "node_desc"
+	nodeTypeFile        = "node_type"
+	lidFile             = "lid"
+	smLIDFile           = "sm_lid"
+	lmcFile             = "lmc"
+	capMaskFile         = "cap_mask"
+	boardIDFile         = "board_id"
+	hcaTypeFile         = "hca_type"
+	sysImageGUIDFile    = "sys_image_guid"
+	driverLinkName      = "driver"
 
 	// SR-IOV PF/VF detection paths.
 	deviceDirName    = "device"          // symlink under class/infiniband/<dev>/device → PCI addr
@@ -37,6 +54,9 @@ const (
 )
 
 var (
+	// pciAddrPattern matches a PCI function address (domain:bus:device.function).
+	pciAddrPattern = regexp.MustCompile(`^[0-9a-f]{4,8}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
 	// ref. https://codebrowser.dev/linux/linux/include/rdma/ib_verbs.h.html#ib_port_state
 	portStateNames = map[int]string{
 		0: "NOP",
@@ -56,9 +76,21 @@ var (
 		6: "LINK_ERROR_RECOVERY",
 		7: "PHY_TEST",
 	}
+	// ref. https://codebrowser.dev/linux/linux/include/rdma/ib_verbs.h.html#rdma_node_type
+	nodeTypeNames = map[int]string{
+		1: "CA",
+		2: "SWITCH",
+		3: "ROUTER",
+		4: "RNIC",
+		5: "USNIC",
+		6: "USNIC_UDP",
+		7: "UNSPECIFIED",
+	}
 )
 
-// Provider exposes RDMA device information sourced from sysfs.
+// Provider exposes RDMA device information sourced from sysfs. Devices may
+// return ErrNoDevices instead of an empty snapshot; failures wrap
+// ErrPermission or ErrUnsupportedLayout where they apply.
 type Provider interface {
 	Devices(ctx context.Context) ([]Device, error)
 }
@@ -75,15 +107,52 @@ type Device struct {
 	// PFDevice is the IB device name of the parent Physical Function (e.g. "mlx5_0").
 	// Only populated when IsVF is true; empty for PFs.
 	PFDevice string
-	Ports    []Port
+	// PCIeLink is the negotiated PCIe link of the device's PCI function. Zero
+	// when sysfs does not report it (e.g. VFs or non-PCI devices).
+	PCIeLink PCIeLink
+	// Attributes holds device-level metadata shared by all ports.
+	Attributes DeviceAttributes
+	// HwStats holds device-scoped hw counters from
+	// /sys/class/infiniband/<dev>/hw_counters, which some drivers expose in
+	// addition to or instead of per-port ones. Nil when there are none.
+	HwStats map[string]uint64
+	Ports   []Port
 }
 
-// Port contains counters and metadata for a single HCA port.
+// DeviceAttributes captures device-level metadata exposed by sysfs under
+// /sys/class/infiniband/<dev>. Values are empty when the file is missing.
+type DeviceAttributes struct {
+	FWVer    string
+	NodeGUID string
+	NodeDesc string
+	// NodeType is the canonical node type name (e.g. "CA", "RNIC").
+	NodeType string
+
+	// BoardID and HCAType identify the board and chip model (e.g.
+	// "MT_0000000222", "MT4123"); only some drivers expose them.
+	BoardID      string
+	HCAType      string
+	SysImageGUID string
+
+	// Driver is the kernel driver bound to the device's parent (e.g.
+	// "mlx5_core", "efa"). Empty when sysfs does not link one.
+	Driver string
+}
+
+// Port contains counters and metadata for a single HCA port. Stats is nil
+// when the port has no counters directory, like the ports of AWS EFA devices.
 type Port struct {
 	ID         int
 	Stats      map[string]uint64
 	HwStats    map[string]uint64
 	Attributes PortAttributes
+	// TickDuration is the length of one tick of tick-based counters such as
+	// port_xmit_wait. Zero when unknown; sysfs does not report it.
+	TickDuration time.Duration
+
+	// VLStats holds the hw counters of single virtual lanes, keyed by VL
+	// number, as Omni-Path hfi1 ports expose them. Nil when the port has none.
+	VLStats map[int]map[string]uint64
 }
 
 // PortAttributes captures descriptive metadata exposed by sysfs.
@@ -94,31 +163,76 @@ type PortAttributes struct {
 	LinkWidth string
 	LinkSpeed string
 	NetDev    string
+	// Fabric identifies the network the port is attached to: the IB subnet
+	// prefix, or the IP prefix of the first global RoCE GID.
+	Fabric string
+
+	// LID, SMLID, LMC and CapMask are the port's lid, sm_lid, lmc and
+	// cap_mask; HasLID is false when the port does not report them.
+	LID     uint32
+	SMLID   uint32
+	LMC     uint32
+	CapMask uint32
+	HasLID  bool
 }
 
 // SysfsProvider implements Provider backed by the node's sysfs.
 type SysfsProvider struct {
 	mu             sync.RWMutex
 	sysfsRoot      string
+	allowedRoots   []string
 	excludeDevices map[string]bool
+
+	fabricIPv4PrefixLen int
+
+	// changes is non-nil when change detection is enabled.
+	changes *changeTracker
+	// counterFDs is non-nil when counter files are kept open.
+	counterFDs *counterFDCache
+
+	retry     RetryPolicy
+	statsMu   sync.Mutex
+	readStats map[string]*DeviceReadStats
+	warnings  warningCounts
+
+	// drivers caches the driver of each device, keyed by root and device
+	// name, for reads that skip the attributes it is read with. Reads with
+	// attributes replace it.
+	driversMu sync.Mutex
+	drivers   map[string]string
+
+	// readFile reads a single sysfs file; tests replace it to emulate slow
+	// or misbehaving filesystems.
+	readFile func(name string) ([]byte, error)
 }
 
 // NewSysfsProvider returns a SysfsProvider using the default sysfs root.
 func NewSysfsProvider() *SysfsProvider {
-	return &SysfsProvider{sysfsRoot: defaultSysfsRoot}
+	return &SysfsProvider{
+		sysfsRoot:           defaultSysfsRoot,
+		fabricIPv4PrefixLen: defaultFabricIPv4PrefixLength,
+		retry:               RetryPolicy{Attempts: 1},
+		readFile:            os.ReadFile,
+	}
 }
 
 // SetSysfsRoot overrides the root directory used to read sysfs.
-// Passing an empty string resets the provider to the default.
-func (p *SysfsProvider) SetSysfsRoot(root string) {
+// Passing an empty string resets the provider to the default. When an
+// allowlist is configured with SetAllowedSysfsRoots, roots outside it are
+// rejected and the current root is kept.
+func (p *SysfsProvider) SetSysfsRoot(root string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	if root == "" {
-		p.sysfsRoot = defaultSysfsRoot
-		return
+		root = defaultSysfsRoot
+	}
+	root = filepath.Clean(root)
+	if err := p.checkSysfsRoot(root); err != nil {
+		return err
 	}
-	p.sysfsRoot = filepath.Clean(root)
+	p.sysfsRoot = root
+	return nil
 }
 
 // SetExcludeDevices configures which devices should be completely skipped.
@@ -132,70 +246,260 @@ func (p *SysfsProvider) SetExcludeDevices(devices []string) {
 	}
 }
 
+// SetFabricIPv4PrefixLength sets the prefix length used to derive the fabric
+// of RoCE ports whose GIDs are IPv4-mapped. Values outside 1-32 are ignored.
+func (p *SysfsProvider) SetFabricIPv4PrefixLength(bits int) {
+	if bits < 1 || bits > 32 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fabricIPv4PrefixLen = bits
+}
+
 func (p *SysfsProvider) isExcluded(device string) bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return p.excludeDevices[device]
 }
 
-// Devices returns a snapshot of RDMA devices and associated ports.
+// ReadOptions trims the work done by DevicesWithOptions. The zero value reads
+// everything, like Devices.
+type ReadOptions struct {
+	// SkipHwCounters leaves Port.HwStats nil.
+	SkipHwCounters bool
+	// SkipAttributes leaves device and port metadata empty: PCI information,
+	// device and port attributes and MAD devices.
+	SkipAttributes bool
+}
+
+// Devices returns a snapshot of RDMA devices and associated ports. It returns
+// ErrNoDevices when the RDMA class directory does not exist, and errors
+// wrapping ErrPermission or ErrUnsupportedLayout when sysfs cannot be walked.
 func (p *SysfsProvider) Devices(ctx context.Context) ([]Device, error) {
+	return p.DevicesWithOptions(ctx, ReadOptions{})
+}
+
+// DevicesWithOptions returns a snapshot of RDMA devices, reading only what
+// opts asks for.
+func (p *SysfsProvider) DevicesWithOptions(ctx context.Context, opts ReadOptions) ([]Device, error) {
 	p.mu.RLock()
 	root := p.sysfsRoot
+	tracker := p.changes
+	fds := p.counterFDs
 	p.mu.RUnlock()
 
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
 
-	return p.devicesFromRoot(ctx, root)
+	var gen, fdGen uint64
+	if tracker != nil {
+		gen = tracker.begin()
+	}
+	if fds != nil {
+		fdGen = fds.begin()
+	}
+	devices, err := p.devicesFromRoot(ctx, root, opts)
+	if err == nil && !opts.SkipAttributes {
+		p.storeDrivers(root, devices)
+	}
+	// A partial read would forget the entries it did not reach.
+	if err == nil && opts == (ReadOptions{}) {
+		if tracker != nil {
+			tracker.prune(gen)
+		}
+		if fds != nil {
+			fds.prune(fdGen)
+		}
+	}
+	return devices, classifyError(err)
 }
 
-func (p *SysfsProvider) deviceFromRoot(ctx context.Context, root, deviceName string) (Device, error) {
+func (p *SysfsProvider) deviceFromRoot(ctx context.Context, root, deviceName string, opts ReadOptions) (Device, error) {
 	if ctx.Err() != nil {
 		return Device{}, ctx.Err()
 	}
 
+	ports, err := p.portsFromRoot(ctx, root, deviceName, opts)
+	if err != nil {
+		return Device{}, fmt.Errorf("collect ports for %s: %w", deviceName, err)
+	}
+	var hwStats map[string]uint64
+	if !opts.SkipHwCounters {
+		hwStats, err = p.readDeviceHwCounters(ctx, root, deviceName)
+		if err != nil {
+			return Device{}, err
+		}
+	}
+	if opts.SkipAttributes {
+		device := Device{Name: deviceName, HwStats: hwStats, Ports: ports}
+		applyDriverQuirks(&device, p.cachedDriver(root, deviceName))
+		return device, nil
+	}
+
+	info, err := p.readDeviceInfoCached(ctx, root, deviceName)
+	if err != nil {
+		return Device{}, err
+	}
+
+	device := Device{
+		Name:       deviceName,
+		PCIAddr:    info.pciAddr,
+		IsVF:       info.isVF,
+		PFDevice:   info.pfDevice,
+		PCIeLink:   info.pcieLink,
+		Attributes: info.attributes,
+		HwStats:    hwStats,
+		Ports:      ports,
+	}
+	applyDriverQuirks(&device, info.attributes.Driver)
+	return device, nil
+}
+
+// readDeviceHwCounters reads the device-scoped hw_counters directory, if the
+// driver has one.
+func (p *SysfsProvider) readDeviceHwCounters(ctx context.Context, root, deviceName string) (map[string]uint64, error) {
+	stats, err := p.readCounterDir(ctx, filepath.Join(root, classInfinibandPath, deviceName, hwCountersDirName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read hw counters for %s: %w", deviceName, err)
+	}
+	return stats, nil
+}
+
+func (p *SysfsProvider) readDeviceInfo(ctx context.Context, root, deviceName string) (deviceInfo, error) {
 	// Resolve PCI address and PF/VF relationship via sysfs device symlink.
 	devicePath := filepath.Join(root, classInfinibandPath, deviceName, deviceDirName)
-	pciAddr, isVF, pfDevice := p.readDevicePCIInfo(root, devicePath)
+	pciDir, pciAddr, isVF, pfDevice := p.readDevicePCIInfo(root, devicePath)
+	var pcieLink PCIeLink
+	if pciDir != "" {
+		pcieLink = p.readPCIeLink(pciDir)
+	}
 
-	ports, err := p.portsFromRoot(ctx, root, deviceName)
+	attrs, err := p.readDeviceAttributes(ctx, root, deviceName)
 	if err != nil {
-		return Device{}, fmt.Errorf("collect ports for %s: %w", deviceName, err)
+		return deviceInfo{}, err
 	}
 
-	return Device{
-		Name:     deviceName,
-		PCIAddr:  pciAddr,
-		IsVF:     isVF,
-		PFDevice: pfDevice,
-		Ports:    ports,
+	return deviceInfo{
+		pciAddr:    pciAddr,
+		isVF:       isVF,
+		pfDevice:   pfDevice,
+		pcieLink:   pcieLink,
+		attributes: attrs,
 	}, nil
 }
 
-// readDevicePCIInfo returns the PCI address, whether the device is a SR-IOV VF,
+func (p *SysfsProvider) readDeviceAttributes(ctx context.Context, root, device string) (DeviceAttributes, error) {
+	deviceDir := filepath.Join(root, classInfinibandPath, device)
+
+	// Like port attributes, device attribute files are optional.
+	read := func(name string) string {
+		if ctx.Err() != nil {
+			return ""
+		}
+		data, err := p.readFile(filepath.Join(deviceDir, name))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	attrs := DeviceAttributes{
+		FWVer:    read(fwVerFile),
+		NodeGUID: read(nodeGUIDFile),
+		NodeDesc: read(nodeDescFile),
+		NodeType: normalizePortState(read(nodeTypeFile), nodeTypeNames),
+	}
+	attrs.SysImageGUID = read(sysImageGUIDFile)
+	attrs.BoardID, attrs.HCAType = p.readBoardInfo(root, device)
+	attrs.Driver = readDriver(filepath.Join(deviceDir, deviceDirName))
+	if err := ctx.Err(); err != nil {
+		return DeviceAttributes{}, err
+	}
+	return attrs, nil
+}
+
+// readDriver returns the name of the kernel driver bound to the parent device
+// behind devicePath, e.g. mlx5_core for a PCI function or irdma for the
+// auxiliary device of an Intel NIC.
+func readDriver(devicePath string) string {
+	link, err := os.Readlink(filepath.Join(devicePath, driverLinkName))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(link)
+}
+
+// cachedDriver returns the driver of a device, resolving its driver link
+// only when the device is not cached yet.
+func (p *SysfsProvider) cachedDriver(root, device string) string {
+	key := root + "\x00" + device
+	p.driversMu.Lock()
+	driver, ok := p.drivers[key]
+	p.driversMu.Unlock()
+	if ok {
+		return driver
+	}
+	driver = readDriver(filepath.Join(root, classInfinibandPath, device, deviceDirName))
+	p.driversMu.Lock()
+	defer p.driversMu.Unlock()
+	if p.drivers == nil {
+		p.drivers = make(map[string]string)
+	}
+	p.drivers[key] = driver
+	return driver
+}
+
+// storeDrivers replaces the cached drivers with those of devices, read with
+// their attributes, which also forgets removed devices.
+func (p *SysfsProvider) storeDrivers(root string, devices []Device) {
+	drivers := make(map[string]string, len(devices))
+	for _, device := range devices {
+		drivers[root+"\x00"+device.Name] = device.Attributes.Driver
+	}
+	p.driversMu.Lock()
+	defer p.driversMu.Unlock()
+	p.drivers = drivers
+}
+
+// readBoardInfo reads the board_id and hca_type files of a device, which
+// have no RDMA netlink equivalent.
+func (p *SysfsProvider) readBoardInfo(root, device string) (boardID, hcaType string) {
+	read := func(name string) string {
+		data, err := p.readFile(filepath.Join(root, classInfinibandPath, device, name))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+	return read(boardIDFile), read(hcaTypeFile)
+}
+
+// readDevicePCIInfo returns the PCI function directory and address, whether the device is a SR-IOV VF,
 // and (for VFs) the IB device name of the parent PF.
 //
 // Detection algorithm:
-//  1. Read the device symlink to extract the PCI address
+//  1. Resolve the PCI function behind the device symlink
 //     e.g. /sys/class/infiniband/mlx5_12/device → ../../../0000:1a:00.1
+//     (see resolvePCIFunction for layouts where the link is not the function)
 //  2. Check for the physfn symlink (present only on VFs)
 //     e.g. /sys/class/infiniband/mlx5_12/device/physfn → ../0000:1a:00.0
 //  3. For VFs, resolve the PF PCI address and look up its IB device name
 //     e.g. /sys/bus/pci/devices/0000:1a:00.0/infiniband/ → mlx5_0
-func (p *SysfsProvider) readDevicePCIInfo(root, devicePath string) (pciAddr string, isVF bool, pfDevice string) {
-	// Step 1: extract PCI address from device symlink target basename.
-	if link, err := os.Readlink(devicePath); err == nil {
-		pciAddr = filepath.Base(link) // e.g. "0000:1a:00.1"
+func (p *SysfsProvider) readDevicePCIInfo(root, devicePath string) (pciDir, pciAddr string, isVF bool, pfDevice string) {
+	// Step 1: locate the PCI function directory and its address.
+	pciDir, pciAddr = resolvePCIFunction(devicePath)
+	if pciAddr == "" {
+		return "", "", false, ""
 	}
 
 	// Step 2: physfn symlink exists only on VFs.
-	physfnPath := filepath.Join(devicePath, physfnLinkName)
+	physfnPath := filepath.Join(pciDir, physfnLinkName)
 	physfnLink, err := os.Readlink(physfnPath)
 	if err != nil {
 		// No physfn → this is a PF (or symlink resolution failed; treat as PF).
-		return pciAddr, false, ""
+		return pciDir, pciAddr, false, ""
 	}
 
 	// Step 3: resolve PF PCI address and find the corresponding IB device name.
@@ -208,15 +512,48 @@ func (p *SysfsProvider) readDevicePCIInfo(root, devicePath string) (pciAddr stri
 		pfDevice = entries[0].Name() // e.g. "mlx5_0"
 	}
 
-	return pciAddr, true, pfDevice
+	return pciDir, pciAddr, true, pfDevice
+}
+
+// resolvePCIFunction returns the directory and address of the PCI function
+// backing devicePath. On x86 the device symlink points straight at the
+// function, so its basename is the address. Other layouts need a walk up the
+// resolved path:
+//   - arm64 BlueField DPUs expose scalable functions whose device link points
+//     at an auxiliary device (…/0000:03:00.0/mlx5_core.sf.2).
+//   - ppc64le PowerVM vPHBs and Intel VMD use PCI domains wider than four hex
+//     digits (e.g. 10030:01:00.0), which must still be recognized.
+func resolvePCIFunction(devicePath string) (dir, addr string) {
+	link, err := os.Readlink(devicePath)
+	if err != nil {
+		return "", ""
+	}
+	if base := filepath.Base(link); pciAddrPattern.MatchString(base) {
+		return devicePath, base
+	}
+
+	resolved, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return "", ""
+	}
+	for dir := resolved; ; {
+		if base := filepath.Base(dir); pciAddrPattern.MatchString(base) {
+			return dir, base
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
 }
 
-func (p *SysfsProvider) devicesFromRoot(ctx context.Context, root string) ([]Device, error) {
+func (p *SysfsProvider) devicesFromRoot(ctx context.Context, root string, opts ReadOptions) ([]Device, error) {
 	classDir := filepath.Join(root, classInfinibandPath)
 	entries, err := os.ReadDir(classDir)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			return nil, nil
+			return nil, fmt.Errorf("%w: %s does not exist", ErrNoDevices, classDir)
 		}
 		return nil, err
 	}
@@ -242,7 +579,11 @@ func (p *SysfsProvider) devicesFromRoot(ctx context.Context, root string) ([]Dev
 			continue
 		}
 
-		device, err := p.deviceFromRoot(ctx, root, name)
+		device, skip, err := p.deviceWithRetry(ctx, root, name, opts)
+		if skip {
+			// Counted in ReadStats; the other devices are still reported.
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -251,7 +592,7 @@ func (p *SysfsProvider) devicesFromRoot(ctx context.Context, root string) ([]Dev
 	return devices, nil
 }
 
-func (p *SysfsProvider) portsFromRoot(ctx context.Context, root, device string) ([]Port, error) {
+func (p *SysfsProvider) portsFromRoot(ctx context.Context, root, device string, opts ReadOptions) ([]Port, error) {
 	dir := filepath.Join(root, classInfinibandPath, device, portsDirName)
 	entries, err := os.ReadDir(dir)
 	if err != nil {
@@ -272,21 +613,42 @@ func (p *SysfsProvider) portsFromRoot(ctx context.Context, root, device string)
 		}
 		portID, err := strconv.Atoi(entry.Name())
 		if err != nil {
+			p.warnings.add(WarningUnexpectedPortEntry)
 			continue
 		}
 
-		stats, err := p.readCounterDir(filepath.Join(dir, entry.Name(), countersDirName))
+		stats, err := p.readPortCounters(ctx, filepath.Join(dir, entry.Name()))
 		if err != nil {
 			return nil, fmt.Errorf("read counters for %s port %d: %w", device, portID, err)
 		}
-		hwStats, err := p.readCounterDir(filepath.Join(dir, entry.Name(), hwCountersDirName))
-		if err != nil && !errors.Is(err, fs.ErrNotExist) {
-			return nil, fmt.Errorf("read hw counters for %s port %d: %w", device, portID, err)
+		var hwStats map[string]uint64
+		var vlStats map[int]map[string]uint64
+		if !opts.SkipHwCounters {
+			// The listing of hw_counters serves the counters and the per-VL
+			// subdirectories alike.
+			hwDir := filepath.Join(dir, entry.Name(), hwCountersDirName)
+			hwEntries, err := os.ReadDir(hwDir)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("read hw counters for %s port %d: %w", device, portID, err)
+			}
+			if err == nil {
+				hwStats, err = p.readCounterEntries(ctx, hwDir, hwEntries)
+				if err != nil {
+					return nil, fmt.Errorf("read hw counters for %s port %d: %w", device, portID, err)
+				}
+				vlStats, err = p.readVLCounters(ctx, hwDir, hwEntries)
+				if err != nil {
+					return nil, fmt.Errorf("read vl counters for %s port %d: %w", device, portID, err)
+				}
+			}
 		}
 
-		attr, err := p.readPortAttributes(root, device, portID)
-		if err != nil {
-			return nil, err
+		var attr PortAttributes
+		if !opts.SkipAttributes {
+			attr, err = p.readPortAttributesCached(ctx, root, device, portID)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		ports = append(ports, Port{
@@ -294,16 +656,46 @@ func (p *SysfsProvider) portsFromRoot(ctx context.Context, root, device string)
 			Stats:      stats,
 			HwStats:    hwStats,
 			Attributes: attr,
+			VLStats:    vlStats,
 		})
 	}
 	return ports, nil
 }
 
-func (p *SysfsProvider) readPortAttributes(root, device string, port int) (PortAttributes, error) {
+// readPortCounters reads the counters directory of a port. Drivers such as
+// mlx4 and qib keep 32-bit counters there, which wrap within minutes on fast
+// links, and publish 64-bit versions in counters_ext (port_xmit_data_64, ...,
+// port_unicast_xmit_packets); those replace the 32-bit values under the
+// name without the _64 suffix. Ports without a counters directory, such as
+// those of AWS EFA devices, which only have hw_counters, get nil stats.
+func (p *SysfsProvider) readPortCounters(ctx context.Context, portDir string) (map[string]uint64, error) {
+	stats, err := p.readCounterDir(ctx, filepath.Join(portDir, countersDirName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	ext, err := p.readCounterDir(ctx, filepath.Join(portDir, countersExtDirName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if stats == nil && len(ext) > 0 {
+		stats = make(map[string]uint64, len(ext))
+	}
+	for name, value := range ext {
+		stats[strings.TrimSuffix(name, "_64")] = value
+	}
+	return stats, nil
+}
+
+func (p *SysfsProvider) readPortAttributes(ctx context.Context, root, device string, port int) (PortAttributes, error) {
 	portDir := filepath.Join(root, classInfinibandPath, device, portsDirName, strconv.Itoa(port))
 
+	// Attribute files are optional, so read errors leave the value empty; only
+	// cancellation aborts the walk.
 	readRaw := func(name string) string {
-		data, err := os.ReadFile(filepath.Join(portDir, name))
+		if ctx.Err() != nil {
+			return ""
+		}
+		data, err := p.readFile(filepath.Join(portDir, name))
 		if err != nil {
 			return ""
 		}
@@ -318,21 +710,67 @@ func (p *SysfsProvider) readPortAttributes(root, device string, port int) (PortA
 		return value
 	}
 
-	state := normalizePortState(readRaw(stateFile), portStateNames)
-	physState := normalizePortState(readRaw(physStateFile), portPhysStateNames)
-	netDev := readPortNetDev(portDir)
+	p.mu.RLock()
+	ipv4PrefixLen := p.fabricIPv4PrefixLen
+	p.mu.RUnlock()
 
-	return PortAttributes{
-		LinkLayer: read(linkLayerFile),
-		State:     state,
-		PhysState: physState,
+	linkLayer := read(linkLayerFile)
+	attr := PortAttributes{
+		LinkLayer: linkLayer,
+		State:     normalizePortState(readRaw(stateFile), portStateNames),
+		PhysState: normalizePortState(readRaw(physStateFile), portPhysStateNames),
 		LinkWidth: read(linkWidthFile),
 		LinkSpeed: read(rateFile),
-		NetDev:    netDev,
-	}, nil
+		NetDev:    p.readPortNetDev(ctx, portDir),
+	}
+	var iwarp bool
+	if ctx.Err() == nil {
+		iwarp = p.isIWARPDevice(root, device)
+		if iwarp && attr.NetDev == "" {
+			attr.NetDev = p.readIWARPNetDev(root, device, port)
+		}
+		attr.Fabric = p.readPortFabric(portDir, linkLayer, ipv4PrefixLen)
+		if !iwarp {
+			p.readPortLID(portDir, &attr)
+		}
+	}
+	if linkLayer == "Ethernet" && attr.NetDev == "" && !iwarp {
+		if _, err := os.Stat(filepath.Join(portDir, gidAttrsDirName)); errors.Is(err, fs.ErrNotExist) {
+			p.warnings.add(WarningLegacyLayout)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return PortAttributes{}, err
+	}
+	return attr, nil
+}
+
+// readPortLID fills the LID attributes of attr. lid, sm_lid and cap_mask are
+// hex ("0x1a"), lmc is decimal; ParseUint accepts both.
+func (p *SysfsProvider) readPortLID(portDir string, attr *PortAttributes) {
+	read := func(name string) (uint32, bool) {
+		data, err := p.readFile(filepath.Join(portDir, name))
+		if err != nil {
+			return 0, false
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 0, 32)
+		if err != nil {
+			return 0, false
+		}
+		return uint32(value), true
+	}
+
+	lid, ok := read(lidFile)
+	if !ok {
+		return
+	}
+	attr.LID, attr.HasLID = lid, true
+	attr.SMLID, _ = read(smLIDFile)
+	attr.LMC, _ = read(lmcFile)
+	attr.CapMask, _ = read(capMaskFile)
 }
 
-func readPortNetDev(portDir string) string {
+func (p *SysfsProvider) readPortNetDev(ctx context.Context, portDir string) string {
 	ndevsPath := filepath.Join(portDir, gidAttrsDirName, ndevsDirName)
 	entries, err := os.ReadDir(ndevsPath)
 	if err != nil {
@@ -340,10 +778,13 @@ func readPortNetDev(portDir string) string {
 	}
 
 	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ""
+		}
 		if entry.IsDir() {
 			continue
 		}
-		data, err := os.ReadFile(filepath.Join(ndevsPath, entry.Name()))
+		data, err := p.readFile(filepath.Join(ndevsPath, entry.Name()))
 		if err != nil {
 			continue
 		}
@@ -380,6 +821,28 @@ func normalizePortState(value string, names map[int]string) string {
 	return value
 }
 
+// PortStateValue returns the numeric value of a port state as State reports
+// it, e.g. 4 for "ACTIVE", following the enum of the sysfs state file.
+func PortStateValue(state string) (int, bool) {
+	return stateValue(state, portStateNames)
+}
+
+// PortPhysStateValue returns the numeric value of a physical port state as
+// PhysState reports it, e.g. 5 for "LINK_UP", following the enum of the sysfs
+// phys_state file.
+func PortPhysStateValue(state string) (int, bool) {
+	return stateValue(state, portPhysStateNames)
+}
+
+func stateValue(state string, names map[int]string) (int, bool) {
+	for number, name := range names {
+		if name == state {
+			return number, true
+		}
+	}
+	return 0, false
+}
+
 func canonicalFromLabel(label string, names map[int]string) string {
 	normalized := normalizeLabelKey(label)
 	if normalized == "" {
@@ -434,29 +897,56 @@ func extractFirstNumber(value string) (int, bool) {
 	return 0, false
 }
 
-func (p *SysfsProvider) readCounterDir(path string) (map[string]uint64, error) {
+func (p *SysfsProvider) readCounterDir(ctx context.Context, path string) (map[string]uint64, error) {
 	entries, err := os.ReadDir(path)
 	if err != nil {
 		return nil, err
 	}
+	return p.readCounterEntries(ctx, path, entries)
+}
+
+// readCounterEntries reads the counter files among entries, the listing of
+// the directory path.
+func (p *SysfsProvider) readCounterEntries(ctx context.Context, path string, entries []os.DirEntry) (map[string]uint64, error) {
+	tracker := p.changeTracker()
 	counters := make(map[string]uint64, len(entries))
 	for _, entry := range entries {
+		// hw_counters reads can hit the firmware mailbox, so honour
+		// cancellation between files rather than only between ports.
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		if !entry.Type().IsRegular() {
 			continue
 		}
-		raw, err := os.ReadFile(filepath.Join(path, entry.Name()))
+		file := filepath.Join(path, entry.Name())
+		if tracker != nil {
+			if value, ok := tracker.stableCounter(file); ok {
+				counters[entry.Name()] = value
+				continue
+			}
+		}
+		raw, err := p.readCounterFile(file)
 		if err != nil {
-			if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EOPNOTSUPP) ||
-				os.IsNotExist(err) || os.IsPermission(err) {
+			if os.IsNotExist(err) {
+				// Removed between listing and reading.
+				continue
+			}
+			if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EOPNOTSUPP) || os.IsPermission(err) {
+				p.warnings.add(WarningCounterUnreadable)
 				continue
 			}
 			return nil, err
 		}
 		value, err := strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
 		if err != nil {
+			p.warnings.add(WarningCounterParse)
 			continue
 		}
 		counters[entry.Name()] = value
+		if tracker != nil {
+			tracker.observeCounter(file, value)
+		}
 	}
 	return counters, nil
 }
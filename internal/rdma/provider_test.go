@@ -2,10 +2,20 @@ package rdma
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
+	"io/fs"
+	"maps"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestSysfsProviderDevicesFromCustomRoot(t *testing.T) {
@@ -31,6 +41,23 @@ func TestSysfsProviderDevicesFromCustomRoot(t *testing.T) {
 	if len(device.Ports) != 2 {
 		t.Fatalf("expected 2 ports, got %d", len(device.Ports))
 	}
+	wantAttrs := DeviceAttributes{
+		FWVer:    "20.31.1014",
+		NodeGUID: "0c42:a103:0000:0001",
+		NodeDesc: "host01 mlx5_0",
+		NodeType: "CA",
+
+		BoardID:      "MT_0000000222",
+		HCAType:      "MT4123",
+		SysImageGUID: "0c42:a103:0000:0000",
+	}
+	if device.Attributes != wantAttrs {
+		t.Fatalf("unexpected device attributes %+v, want %+v", device.Attributes, wantAttrs)
+	}
+	wantHwStats := map[string]uint64{"keep_alive_rcvd": 7, "submitted_cmds": 42}
+	if !reflect.DeepEqual(device.HwStats, wantHwStats) {
+		t.Fatalf("unexpected device hw counters %v, want %v", device.HwStats, wantHwStats)
+	}
 
 	port1 := device.Ports[0]
 	if port1.ID != 1 {
@@ -60,6 +87,12 @@ func TestSysfsProviderDevicesFromCustomRoot(t *testing.T) {
 	if want, got := "ens1f0np0", port1.Attributes.NetDev; got != want {
 		t.Fatalf("expected netdev %q, got %q", want, got)
 	}
+	if want, got := "fe80:0000:0000:0001", port1.Attributes.Fabric; got != want {
+		t.Fatalf("expected fabric %q, got %q", want, got)
+	}
+	if a := port1.Attributes; !a.HasLID || a.LID != 0x1a || a.SMLID != 1 || a.LMC != 0 || a.CapMask != 0xa651e848 {
+		t.Fatalf("unexpected lid attributes lid=%#x sm_lid=%#x lmc=%d cap_mask=%#x (has=%t)", a.LID, a.SMLID, a.LMC, a.CapMask, a.HasLID)
+	}
 
 	port2 := device.Ports[1]
 	if port2.ID != 2 {
@@ -160,6 +193,9 @@ func TestSysfsProviderVFDetection(t *testing.T) {
 	if pf.PFDevice != "" {
 		t.Errorf("PF PFDevice: want empty, got %q", pf.PFDevice)
 	}
+	if pf.Attributes.Driver != "" {
+		t.Errorf("PF Driver: want empty without a driver link, got %q", pf.Attributes.Driver)
+	}
 
 	// --- VF assertions ---
 	if vf.Name != "mlx5_4" {
@@ -174,6 +210,284 @@ func TestSysfsProviderVFDetection(t *testing.T) {
 	if vf.PFDevice != "mlx5_0" {
 		t.Errorf("VF PFDevice: want mlx5_0, got %q", vf.PFDevice)
 	}
+	if vf.Attributes.Driver != "mlx5_core" {
+		t.Errorf("VF Driver: want mlx5_core, got %q", vf.Attributes.Driver)
+	}
+}
+
+func TestSysfsProviderSwitchManagementPort(t *testing.T) {
+	t.Parallel()
+
+	// Switches expose their management port as port 0.
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", "ibswitch"))
+
+	devices, err := provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+	if len(devices) != 1 || len(devices[0].Ports) != 1 {
+		t.Fatalf("expected 1 device with 1 port, got %+v", devices)
+	}
+
+	device := devices[0]
+	if want, got := "SWITCH", device.Attributes.NodeType; got != want {
+		t.Fatalf("expected node type %q, got %q", want, got)
+	}
+	port := device.Ports[0]
+	if port.ID != 0 {
+		t.Fatalf("expected port ID 0, got %d", port.ID)
+	}
+	if got := port.Stats["port_xmit_data"]; got != 51920044 {
+		t.Fatalf("expected port_xmit_data=51920044, got %d", got)
+	}
+	if got := port.Stats["port_rcv_switch_relay_errors"]; got != 0 {
+		t.Fatalf("expected port_rcv_switch_relay_errors=0, got %d", got)
+	}
+	if want, got := "ACTIVE", port.Attributes.State; got != want {
+		t.Fatalf("expected state %q, got %q", want, got)
+	}
+	if a := port.Attributes; !a.HasLID || a.LID != 5 || a.SMLID != 1 {
+		t.Fatalf("unexpected lid attributes lid=%#x sm_lid=%#x (has=%t)", a.LID, a.SMLID, a.HasLID)
+	}
+
+	pkeys, err := provider.PKeyTable(context.Background())
+	if err != nil {
+		t.Fatalf("PKeyTable returned error: %v", err)
+	}
+	wantPKeys := []PKeyEntry{{Device: "mlx5_0", Port: 0, Index: 0, PKey: "0xffff"}}
+	if !reflect.DeepEqual(pkeys, wantPKeys) {
+		t.Fatalf("unexpected pkeys:\n got %+v\nwant %+v", pkeys, wantPKeys)
+	}
+}
+
+func TestSysfsProviderEFADevice(t *testing.T) {
+	t.Parallel()
+
+	// EFA ports have hw_counters but no counters directory.
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", "efa"))
+
+	devices, err := provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+	if len(devices) != 1 || len(devices[0].Ports) != 1 {
+		t.Fatalf("expected 1 device with 1 port, got %+v", devices)
+	}
+
+	device := devices[0]
+	if want, got := "efa", device.Attributes.Driver; got != want {
+		t.Fatalf("expected driver %q, got %q", want, got)
+	}
+	if want, got := "UNSPECIFIED", device.Attributes.NodeType; got != want {
+		t.Fatalf("expected node type %q, got %q", want, got)
+	}
+	if want, got := "0000:00:1b.0", device.PCIAddr; got != want {
+		t.Fatalf("expected PCI address %q, got %q", want, got)
+	}
+	if got := device.HwStats["completed_cmds"]; got != 1294 {
+		t.Fatalf("expected completed_cmds=1294, got %d", got)
+	}
+
+	port := device.Ports[0]
+	if port.Stats != nil {
+		t.Fatalf("expected nil stats without a counters directory, got %v", port.Stats)
+	}
+	if got := port.HwStats["tx_bytes"]; got != 10737418240 {
+		t.Fatalf("expected tx_bytes=10737418240, got %d", got)
+	}
+	if want, got := "Unspecified", port.Attributes.LinkLayer; got != want {
+		t.Fatalf("expected link layer %q, got %q", want, got)
+	}
+	if want, got := "ACTIVE", port.Attributes.State; got != want {
+		t.Fatalf("expected state %q, got %q", want, got)
+	}
+}
+
+func TestSysfsProviderIRDMADevice(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", "irdma"))
+
+	devices, err := provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+	if len(devices) != 1 || len(devices[0].Ports) != 1 {
+		t.Fatalf("expected 1 device with 1 port, got %+v", devices)
+	}
+
+	device := devices[0]
+	if want, got := "irdma", device.Attributes.Driver; got != want {
+		t.Fatalf("expected driver %q, got %q", want, got)
+	}
+	// The device link points at the auxiliary device of the ice function.
+	if want, got := "0000:17:00.0", device.PCIAddr; got != want {
+		t.Fatalf("expected PCI address %q, got %q", want, got)
+	}
+
+	// Counters of the whole function move to the device.
+	port := device.Ports[0]
+	for _, name := range []string{"ip4InOctets", "ip6InPkts", "tcpRetransSegs", "rxVlanErrors", "RxUDP", "TxUDP"} {
+		if _, ok := device.HwStats[name]; !ok {
+			t.Errorf("expected %s among the device hw counters, got %v", name, device.HwStats)
+		}
+		if _, ok := port.HwStats[name]; ok {
+			t.Errorf("expected %s to leave the port hw counters", name)
+		}
+	}
+	if got := device.HwStats["ip4InOctets"]; got != 123456789 {
+		t.Fatalf("expected ip4InOctets=123456789, got %d", got)
+	}
+	for _, name := range []string{"cnpHandled", "iwInRdmaReads", "RxECNMrkd"} {
+		if _, ok := port.HwStats[name]; !ok {
+			t.Errorf("expected %s among the port hw counters, got %v", name, port.HwStats)
+		}
+	}
+	if port.Stats != nil {
+		t.Fatalf("expected nil stats without a counters directory, got %v", port.Stats)
+	}
+}
+
+func TestSysfsProviderCxgb4Device(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", "cxgb4"))
+
+	devices, err := provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+	if len(devices) != 1 || len(devices[0].Ports) != 2 {
+		t.Fatalf("expected 1 device with 2 ports, got %+v", devices)
+	}
+
+	device := devices[0]
+	if want, got := "RNIC", device.Attributes.NodeType; got != want {
+		t.Fatalf("expected node type %q, got %q", want, got)
+	}
+	// The TCP offload engine counters of the adapter move to the device.
+	if got := device.HwStats["ip4InSegs"]; got != 1200 {
+		t.Fatalf("expected ip4InSegs=1200 among the device hw counters, got %v", device.HwStats)
+	}
+	for _, port := range device.Ports {
+		if len(port.HwStats) != 0 {
+			t.Errorf("expected no hw counters left on port %d, got %v", port.ID, port.HwStats)
+		}
+		if port.Attributes.HasLID {
+			t.Errorf("expected no LID on iWARP port %d, got %+v", port.ID, port.Attributes)
+		}
+	}
+	// Without RoCE GIDs, the netdev is matched by dev_port.
+	if want, got := "enp4s0f4", device.Ports[0].Attributes.NetDev; got != want {
+		t.Fatalf("expected port 1 netdev %q, got %q", want, got)
+	}
+	if want, got := "enp4s0f4d1", device.Ports[1].Attributes.NetDev; got != want {
+		t.Fatalf("expected port 2 netdev %q, got %q", want, got)
+	}
+	if got := provider.Warnings()[WarningLegacyLayout]; got != 0 {
+		t.Fatalf("expected no legacy layout warnings for iWARP ports, got %d", got)
+	}
+}
+
+func TestSysfsProviderHFI1Device(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", "hfi1"))
+
+	devices, err := provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+	if len(devices) != 1 || len(devices[0].Ports) != 1 {
+		t.Fatalf("expected 1 device with 1 port, got %+v", devices)
+	}
+	if want, got := "hfi1", devices[0].Attributes.Driver; got != want {
+		t.Fatalf("expected driver %q, got %q", want, got)
+	}
+
+	// Suffixed counters and vl<N> directories both end up in VLStats.
+	port := devices[0].Ports[0]
+	if want := map[string]uint64{"TxPkt": 500, "RxPkt": 400}; !maps.Equal(port.HwStats, want) {
+		t.Fatalf("expected port hw counters %v, got %v", want, port.HwStats)
+	}
+	want := map[int]map[string]uint64{
+		0:  {"RxFlit": 90, "TxFlit": 100, "TxWait": 7},
+		1:  {"RxFlit": 10},
+		15: {"TxFlit": 3},
+	}
+	if !reflect.DeepEqual(port.VLStats, want) {
+		t.Fatalf("expected vl counters %v, got %v", want, port.VLStats)
+	}
+	if got := port.Stats["port_xmit_data"]; got != 1000 {
+		t.Fatalf("expected port_xmit_data=1000, got %d", got)
+	}
+}
+
+func TestSysfsProviderArchitectureQuirks(t *testing.T) {
+	t.Parallel()
+
+	type want struct {
+		pciAddr  string
+		isVF     bool
+		pfDevice string
+	}
+	tests := []struct {
+		name    string
+		fixture string
+		want    map[string]want
+	}{
+		{
+			// BlueField scalable functions link to an auxiliary device below
+			// the PCI function.
+			name:    "arm64 auxiliary scalable function",
+			fixture: "arm64",
+			want: map[string]want{
+				"mlx5_0": {pciAddr: "0000:03:00.0"},
+				"mlx5_2": {pciAddr: "0000:03:00.0"},
+			},
+		},
+		{
+			// PowerVM vPHBs use PCI domains wider than four hex digits.
+			name:    "ppc64le vPHB wide domain",
+			fixture: "ppc64le",
+			want: map[string]want{
+				"mlx5_0": {pciAddr: "10030:01:00.0"},
+				"mlx5_1": {pciAddr: "10030:01:00.2", isVF: true, pfDevice: "mlx5_0"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			provider := NewSysfsProvider()
+			provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", tt.fixture))
+
+			devices, err := provider.Devices(context.Background())
+			if err != nil {
+				t.Fatalf("Devices returned error: %v", err)
+			}
+			if len(devices) != len(tt.want) {
+				t.Fatalf("expected %d devices, got %d", len(tt.want), len(devices))
+			}
+			for _, device := range devices {
+				expected, ok := tt.want[device.Name]
+				if !ok {
+					t.Fatalf("unexpected device %q", device.Name)
+				}
+				got := want{pciAddr: device.PCIAddr, isVF: device.IsVF, pfDevice: device.PFDevice}
+				if got != expected {
+					t.Errorf("%s: expected %+v, got %+v", device.Name, expected, got)
+				}
+			}
+		})
+	}
 }
 
 func TestSetExcludeDevices(t *testing.T) {
@@ -223,7 +537,7 @@ func TestSysfsProvider_ReadCounterDirSkipsUnreadableCounters(t *testing.T) {
 	}
 
 	provider := NewSysfsProvider()
-	counters, err := provider.readCounterDir(dir)
+	counters, err := provider.readCounterDir(context.Background(), dir)
 	if err != nil {
 		t.Fatalf("readCounterDir returned error: %v", err)
 	}
@@ -248,11 +562,1893 @@ func TestSysfsProvider_ReadCounterDirSkipsUnreadableCounters(t *testing.T) {
 	}
 }
 
-func writeCounter(t *testing.T, dir, name, contents string) string {
-	t.Helper()
-	path := filepath.Join(dir, name)
-	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
-		t.Fatalf("write counter %s: %v", name, err)
+// cancelAfterReads emulates a slow sysfs: each read sleeps and the context is
+// canceled once limit files have been read.
+func cancelAfterReads(provider *SysfsProvider, cancel context.CancelFunc, limit int) *int {
+	var reads int
+	provider.readFile = func(name string) ([]byte, error) {
+		reads++
+		time.Sleep(time.Millisecond)
+		if reads >= limit {
+			cancel()
+		}
+		return os.ReadFile(name)
+	}
+	return &reads
+}
+
+func TestSysfsProvider_ReadCounterDirHonorsCancellation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		writeCounter(t, dir, name, "1")
+	}
+
+	provider := NewSysfsProvider()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reads := cancelAfterReads(provider, cancel, 2)
+
+	_, err := provider.readCounterDir(ctx, dir)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+	if *reads != 2 {
+		t.Fatalf("expected walk to stop after 2 reads, got %d", *reads)
+	}
+}
+
+func TestSysfsProvider_DevicesCanceledDuringAttributeReads(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", "basic"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Port 1 has three counter files; cancel on the first attribute read.
+	reads := cancelAfterReads(provider, cancel, 4)
+
+	_, err := provider.Devices(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+	if *reads != 4 {
+		t.Fatalf("expected walk to stop after 4 reads, got %d", *reads)
+	}
+}
+
+func TestSysfsProvider_SetSysfsRootAllowlist(t *testing.T) {
+	t.Parallel()
+
+	allowed := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Mkdir(filepath.Join(allowed, "sys"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(allowed, "escape")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		root    string
+		wantErr bool
+	}{
+		{"prefix itself", allowed, false},
+		{"below prefix", filepath.Join(allowed, "sys"), false},
+		{"outside prefix", outside, true},
+		{"dot-dot traversal", filepath.Join(allowed, "sys", "..", ".."), true},
+		{"symlink escape", filepath.Join(allowed, "escape"), true},
+		{"missing root", filepath.Join(allowed, "missing"), true},
+		{"default root", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			provider := NewSysfsProvider()
+			if err := provider.SetAllowedSysfsRoots([]string{allowed}); err != nil {
+				t.Fatalf("SetAllowedSysfsRoots returned error: %v", err)
+			}
+			err := provider.SetSysfsRoot(tt.root)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetSysfsRoot(%q) error = %v, wantErr %v", tt.root, err, tt.wantErr)
+			}
+			if err != nil && provider.sysfsRoot != defaultSysfsRoot {
+				t.Fatalf("rejected root changed sysfs root to %q", provider.sysfsRoot)
+			}
+		})
+	}
+}
+
+func TestSysfsProvider_SetSysfsRootWithoutAllowlist(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSysfsProvider()
+	if err := provider.SetSysfsRoot(filepath.Join("testdata", "missing")); err != nil {
+		t.Fatalf("SetSysfsRoot without allowlist returned error: %v", err)
+	}
+}
+
+func TestSysfsProvider_DevicesWithOptions(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSysfsProvider()
+	if err := provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", "basic")); err != nil {
+		t.Fatalf("SetSysfsRoot returned error: %v", err)
+	}
+
+	devices, err := provider.DevicesWithOptions(context.Background(), ReadOptions{SkipHwCounters: true, SkipAttributes: true})
+	if err != nil {
+		t.Fatalf("DevicesWithOptions returned error: %v", err)
+	}
+	if len(devices) != 1 || len(devices[0].Ports) != 2 {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+
+	device := devices[0]
+	if device.Attributes != (DeviceAttributes{}) || device.PCIAddr != "" || device.HwStats != nil {
+		t.Fatalf("expected no device attributes or hw counters, got %+v", device)
+	}
+	port := device.Ports[0]
+	if got := port.Stats["port_xmit_data"]; got != 123 {
+		t.Fatalf("expected port_xmit_data=123, got %d", got)
+	}
+	if port.HwStats != nil {
+		t.Fatalf("expected no hw counters, got %v", port.HwStats)
+	}
+	if port.Attributes != (PortAttributes{}) {
+		t.Fatalf("expected no port attributes, got %+v", port.Attributes)
+	}
+}
+
+func TestSysfsProvider_CachesDriverForDegradedReads(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	devicePath := filepath.Join(root, classInfinibandPath, "irdma0", deviceDirName)
+	if err := os.MkdirAll(devicePath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(devicePath, driverLinkName)
+	if err := os.Symlink("../../../bus/auxiliary/drivers/irdma", link); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := NewSysfsProvider()
+	if got := provider.cachedDriver(root, "irdma0"); got != "irdma" {
+		t.Fatalf("expected driver irdma, got %q", got)
+	}
+	// The link is resolved once per device.
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if got := provider.cachedDriver(root, "irdma0"); got != "irdma" {
+		t.Fatalf("expected the cached driver irdma, got %q", got)
+	}
+
+	// A read with attributes replaces the cache.
+	provider.storeDrivers(root, []Device{{Name: "irdma0", Attributes: DeviceAttributes{Driver: "mlx5_core"}}})
+	if got := provider.cachedDriver(root, "irdma0"); got != "mlx5_core" {
+		t.Fatalf("expected driver mlx5_core after a full read, got %q", got)
+	}
+}
+
+// countingReads wraps readFile so tests can count reads by file path suffix
+// and override file contents.
+func countingReads(provider *SysfsProvider) (reads map[string]int, contents map[string]string) {
+	reads = make(map[string]int)
+	contents = make(map[string]string)
+	provider.readFile = func(name string) ([]byte, error) {
+		reads[name]++
+		if content, ok := contents[name]; ok {
+			return []byte(content), nil
+		}
+		return os.ReadFile(name)
+	}
+	return reads, contents
+}
+
+func TestSysfsProvider_ChangeDetectionAttributes(t *testing.T) {
+	t.Parallel()
+
+	root := filepath.Join("testdata", "sysfs", "basic")
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(root)
+	provider.SetChangeDetection(ChangeDetection{AttributeRefresh: 3})
+	reads, contents := countingReads(provider)
+
+	portDir := filepath.Join(root, classInfinibandPath, "mlx5_0", portsDirName, "1")
+	linkLayer := filepath.Join(portDir, linkLayerFile)
+	fwVer := filepath.Join(root, classInfinibandPath, "mlx5_0", fwVerFile)
+
+	read := func() Device {
+		t.Helper()
+		devices, err := provider.Devices(context.Background())
+		if err != nil {
+			t.Fatalf("Devices returned error: %v", err)
+		}
+		return devices[0]
+	}
+
+	for i := range 3 {
+		device := read()
+		if device.Attributes.FWVer != "20.31.1014" || device.Ports[0].Attributes.LinkLayer != "InfiniBand" {
+			t.Fatalf("read %d: unexpected attributes %+v", i+1, device)
+		}
+	}
+	if reads[linkLayer] != 1 || reads[fwVer] != 1 {
+		t.Fatalf("expected attributes to be read once within the refresh, got link_layer=%d fw_ver=%d", reads[linkLayer], reads[fwVer])
+	}
+
+	read()
+	if reads[linkLayer] != 2 || reads[fwVer] != 2 {
+		t.Fatalf("expected attributes to be re-read after the refresh, got link_layer=%d fw_ver=%d", reads[linkLayer], reads[fwVer])
+	}
+
+	// A state change invalidates the port's attributes right away.
+	contents[filepath.Join(portDir, stateFile)] = "1: DOWN"
+	if got := read().Ports[0].Attributes.State; got != "DOWN" {
+		t.Fatalf("expected state DOWN after the change, got %q", got)
+	}
+	if reads[linkLayer] != 3 || reads[fwVer] != 2 {
+		t.Fatalf("expected only the port to be re-read, got link_layer=%d fw_ver=%d", reads[linkLayer], reads[fwVer])
+	}
+
+	// Invalidation re-reads everything on the next read.
+	provider.InvalidateCache()
+	read()
+	if reads[linkLayer] != 4 || reads[fwVer] != 3 {
+		t.Fatalf("expected attributes to be re-read after invalidation, got link_layer=%d fw_ver=%d", reads[linkLayer], reads[fwVer])
+	}
+}
+
+func TestSysfsProvider_ChangeDetectionStableCounters(t *testing.T) {
+	t.Parallel()
+
+	root := filepath.Join("testdata", "sysfs", "basic")
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(root)
+	provider.SetChangeDetection(ChangeDetection{StableCounterAfter: 2, StableCounterRefresh: 3})
+	reads, contents := countingReads(provider)
+
+	counter := filepath.Join(root, classInfinibandPath, "mlx5_0", portsDirName, "1", countersDirName, "port_xmit_data")
+
+	read := func() uint64 {
+		t.Helper()
+		devices, err := provider.Devices(context.Background())
+		if err != nil {
+			t.Fatalf("Devices returned error: %v", err)
+		}
+		return devices[0].Ports[0].Stats["port_xmit_data"]
+	}
+
+	// Three unchanged reads make the counter stable; the next two are served
+	// from the cache and the sixth refreshes it.
+	for i := range 6 {
+		if got := read(); got != 123 {
+			t.Fatalf("read %d: expected port_xmit_data=123, got %d", i+1, got)
+		}
+	}
+	if reads[counter] != 4 {
+		t.Fatalf("expected 4 sysfs reads of a stable counter in 6 scrapes, got %d", reads[counter])
+	}
+
+	// An increment of a stable counter shows up with the next refresh, after
+	// which the counter is read every scrape again.
+	contents[counter] = "200"
+	for i, want := range []uint64{123, 123, 200, 200, 200} {
+		if got := read(); got != want {
+			t.Fatalf("read %d after the change: expected port_xmit_data=%d, got %d", i+1, want, got)
+		}
+	}
+	if reads[counter] != 7 {
+		t.Fatalf("expected a changed counter to be read every scrape, got %d reads", reads[counter])
+	}
+}
+
+func TestSysfsProvider_RetriesTransientErrors(t *testing.T) {
+	t.Parallel()
+
+	root := filepath.Join("testdata", "sysfs", "basic")
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(root)
+	provider.SetRetryPolicy(RetryPolicy{Attempts: 3})
+
+	counter := filepath.Join(root, classInfinibandPath, "mlx5_0", portsDirName, "1", countersDirName, "port_xmit_data")
+	failures := 2
+	provider.readFile = func(name string) ([]byte, error) {
+		if name == counter && failures != 0 {
+			failures--
+			return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EBUSY}
+		}
+		return os.ReadFile(name)
+	}
+
+	devices, err := provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("expected the device to be read after retries, got %d devices", len(devices))
+	}
+	want := []DeviceReadStats{{Device: "mlx5_0", Retries: 2}}
+	if got := provider.ReadStats(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected read stats %+v, want %+v", got, want)
+	}
+
+	// A device that stays busy is left out instead of failing the read.
+	failures = -1
+	devices, err = provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+	if len(devices) != 0 {
+		t.Fatalf("expected the busy device to be skipped, got %d devices", len(devices))
+	}
+	want = []DeviceReadStats{{Device: "mlx5_0", Retries: 4, Errors: 1}}
+	if got := provider.ReadStats(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected read stats %+v, want %+v", got, want)
+	}
+}
+
+func TestSysfsProvider_DoesNotRetryPermanentErrors(t *testing.T) {
+	t.Parallel()
+
+	root := filepath.Join("testdata", "sysfs", "basic")
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(root)
+	provider.SetRetryPolicy(RetryPolicy{Attempts: 3})
+
+	counter := filepath.Join(root, classInfinibandPath, "mlx5_0", portsDirName, "1", countersDirName, "port_xmit_data")
+	provider.readFile = func(name string) ([]byte, error) {
+		if name == counter {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EIO}
+		}
+		return os.ReadFile(name)
+	}
+
+	if _, err := provider.Devices(context.Background()); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected EIO, got %v", err)
+	}
+	want := []DeviceReadStats{{Device: "mlx5_0", Errors: 1}}
+	if got := provider.ReadStats(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected read stats %+v, want %+v", got, want)
+	}
+}
+
+// sequenceProvider returns one result per Devices call, repeating the last.
+type sequenceProvider struct {
+	results []int
+	calls   int
+}
+
+func (s *sequenceProvider) Devices(context.Context) ([]Device, error) {
+	n := s.results[min(s.calls, len(s.results)-1)]
+	s.calls++
+	if n < 0 {
+		return nil, errors.New("enumeration failed")
+	}
+	return make([]Device, n), nil
+}
+
+func TestSysfsProvider_DevicesErrors(t *testing.T) {
+	t.Parallel()
+
+	notDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(notDir, "class"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(notDir, classInfinibandPath), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		root string
+		want error
+	}{
+		{"missing class directory", t.TempDir(), ErrNoDevices},
+		{"class directory is a file", notDir, ErrUnsupportedLayout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			provider := NewSysfsProvider()
+			if err := provider.SetSysfsRoot(tt.root); err != nil {
+				t.Fatal(err)
+			}
+			devices, err := provider.Devices(context.Background())
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			if devices != nil {
+				t.Fatalf("expected no devices, got %+v", devices)
+			}
+		})
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"permission", &fs.PathError{Op: "open", Path: "/sys/class/infiniband", Err: syscall.EACCES}, ErrPermission},
+		{"not a directory", &fs.PathError{Op: "readdirent", Path: "/sys/class/infiniband", Err: syscall.ENOTDIR}, ErrUnsupportedLayout},
+		{"other", syscall.EIO, syscall.EIO},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyError(tt.err)
+			if !errors.Is(got, tt.want) || !errors.Is(got, tt.err) {
+				t.Fatalf("expected %v wrapping %v, got %v", tt.want, tt.err, got)
+			}
+		})
+	}
+	if classifyError(nil) != nil {
+		t.Fatal("expected nil for nil")
+	}
+}
+
+func TestWaitForDevices(t *testing.T) {
+	t.Parallel()
+
+	provider := &sequenceProvider{results: []int{0, -1, 0, 2}}
+	var waits []time.Duration
+	var errs int
+	devices, err := WaitForDevices(context.Background(), provider, time.Millisecond, 3*time.Millisecond, func(wait time.Duration, err error) {
+		waits = append(waits, wait)
+		if err != nil {
+			errs++
+		}
+	})
+	if err != nil {
+		t.Fatalf("WaitForDevices returned error: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+	if want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}; !slices.Equal(waits, want) {
+		t.Fatalf("unexpected backoff %v, want %v", waits, want)
+	}
+	if errs != 1 {
+		t.Fatalf("expected the failed attempt to be reported, got %d errors", errs)
+	}
+}
+
+func TestWaitForDevicesCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	provider := &sequenceProvider{results: []int{0}}
+	_, err := WaitForDevices(ctx, provider, time.Hour, time.Hour, func(time.Duration, error) {
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestSysfsProvider_Representors(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", "switchdev"))
+
+	reps, err := provider.Representors(context.Background())
+	if err != nil {
+		t.Fatalf("Representors returned error: %v", err)
+	}
+	want := []Representor{
+		{NetDev: "ens1f0npf0vf0", PFDevice: "mlx5_0", PF: 0, VF: 0},
+		{NetDev: "ens1f0npf0vf1", PFDevice: "mlx5_0", PF: 0, VF: 1},
+		{NetDev: "eth_c1", PFDevice: "mlx5_1", PF: 1, VF: 2},
+	}
+	if !reflect.DeepEqual(reps, want) {
+		t.Fatalf("unexpected representors:\n got %+v\nwant %+v", reps, want)
+	}
+
+	provider.SetExcludeDevices([]string{"mlx5_0"})
+	reps, err = provider.Representors(context.Background())
+	if err != nil {
+		t.Fatalf("Representors returned error: %v", err)
+	}
+	if len(reps) != 1 || reps[0].NetDev != "eth_c1" {
+		t.Fatalf("expected representors of excluded devices to be skipped, got %+v", reps)
+	}
+}
+
+func writeCounter(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("write counter %s: %v", name, err)
+	}
+	return path
+}
+
+func TestSysctlProvider_FlowLabelSettings(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSysctlProvider(filepath.Join("testdata", "procfs", "basic"))
+	settings, err := provider.FlowLabelSettings(context.Background())
+	if err != nil {
+		t.Fatalf("FlowLabelSettings returned error: %v", err)
+	}
+
+	want := FlowLabelSettings{AutoFlowLabels: "1", FlowLabelStateRanges: "1", FlowLabelReflect: "0"}
+	if settings != want {
+		t.Fatalf("expected %+v, got %+v", want, settings)
+	}
+}
+
+func TestSysctlProvider_FlowLabelSettingsMissing(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSysctlProvider(t.TempDir())
+	settings, err := provider.FlowLabelSettings(context.Background())
+	if err != nil {
+		t.Fatalf("FlowLabelSettings returned error: %v", err)
+	}
+	if !settings.Empty() {
+		t.Fatalf("expected empty settings, got %+v", settings)
+	}
+}
+
+func TestFabricFromGID(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		gid       string
+		linkLayer string
+		want      string
+	}{
+		{"ib subnet prefix", "fec0:0000:0000:0001:0c42:a103:0000:0001\n", "InfiniBand", "fec0:0000:0000:0001"},
+		{"ib malformed", "garbage", "InfiniBand", ""},
+		{"roce link local", "fe80:0000:0000:0000:0e42:a1ff:fe00:0001", "Ethernet", ""},
+		{"roce zero gid", "0000:0000:0000:0000:0000:0000:0000:0000", "Ethernet", ""},
+		{"roce ipv4 mapped", "0000:0000:0000:0000:0000:ffff:0a01:0203", "Ethernet", "10.1.2.0/24"},
+		{"roce ipv6 global", "2001:0db8:0001:0002:0e42:a1ff:fe00:0001", "Ethernet", "2001:db8:1:2::/64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fabricFromGID(tt.gid, tt.linkLayer, 24); got != tt.want {
+				t.Fatalf("fabricFromGID(%q) = %q, want %q", tt.gid, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSysfsProvider_AER(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", "aer"))
+
+	devices, err := provider.AER(context.Background())
+	if err != nil {
+		t.Fatalf("AER returned error: %v", err)
+	}
+
+	want := []DeviceAER{
+		{
+			Device: "mlx5_0",
+			Counters: map[string]map[string]uint64{
+				"correctable": {"RxErr": 2, "BadTLP": 1, "BadDLLP": 0, "TOTAL_ERR_COR": 3},
+				"fatal":       {"Undefined": 0, "TOTAL_ERR_FATAL": 0},
+				"nonfatal":    {"Undefined": 0, "CmpltTO": 4, "TOTAL_ERR_NONFATAL": 4},
+			},
+		},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Fatalf("expected %+v, got %+v", want, devices)
+	}
+}
+
+func TestSysfsProviderPCIeLink(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", "ppc64le"))
+
+	devices, err := provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+
+	links := make(map[string]PCIeLink, len(devices))
+	for _, device := range devices {
+		links[device.Name] = device.PCIeLink
+	}
+	if want := (PCIeLink{SpeedGTs: 8, Width: 16}); links["mlx5_0"] != want {
+		t.Fatalf("mlx5_0: expected %+v, got %+v", want, links["mlx5_0"])
+	}
+	if links["mlx5_1"].Known() {
+		t.Fatalf("mlx5_1: expected unknown PCIe link, got %+v", links["mlx5_1"])
+	}
+}
+
+func TestPCIeLinkBandwidth(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		link PCIeLink
+		want float64
+	}{
+		{"gen2 x8", PCIeLink{SpeedGTs: 5, Width: 8}, 32e9},
+		{"gen3 x16", PCIeLink{SpeedGTs: 8, Width: 16}, 8e9 * 16 * (128.0 / 130.0)},
+		{"unknown", PCIeLink{SpeedGTs: 16}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.link.BandwidthBps(); math.Abs(got-tt.want) > 1 {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLinkRateBps(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		rate   string
+		want   float64
+		wantOK bool
+	}{
+		{"200 Gb/sec (4X HDR)", 200e9, true},
+		{"2.5 Gb/sec (1X SDR)", 2.5e9, true},
+		{"", 0, false},
+		{"unknown", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := LinkRateBps(tt.rate)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("LinkRateBps(%q) = %v, %v; want %v, %v", tt.rate, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestLinkWidthLanes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		width  string
+		rate   string
+		want   int
+		wantOK bool
+	}{
+		{"4X", "100 Gb/sec (4X EDR)", 4, true},
+		{"12X", "", 12, true},
+		// The width attribute is missing on some providers.
+		{"", "200 Gb/sec (2X NDR)", 2, true},
+		{"", "25 Gb/sec (1X)", 1, true},
+		{"", "100 Gb/sec", 0, false},
+		{"wide", "", 0, false},
+		{"0X", "", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := LinkWidthLanes(tt.width, tt.rate)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("LinkWidthLanes(%q, %q) = %v, %v; want %v, %v", tt.width, tt.rate, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestSysfsProvider_CounterFDCache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name, value string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	write("port_xmit_data", "10\n")
+	write("port_rcv_data", "20\n")
+
+	provider := NewSysfsProvider()
+	provider.SetCounterFDCache(true)
+	t.Cleanup(func() { provider.Close() })
+	cache := provider.counterFDCache()
+
+	read := func() map[string]uint64 {
+		t.Helper()
+		counters, err := provider.readCounterDir(context.Background(), dir)
+		if err != nil {
+			t.Fatalf("readCounterDir returned error: %v", err)
+		}
+		return counters
+	}
+
+	cache.begin()
+	if got := read(); got["port_xmit_data"] != 10 || got["port_rcv_data"] != 20 {
+		t.Fatalf("unexpected counters %v", got)
+	}
+	if cache.len() != 2 {
+		t.Fatalf("expected 2 cached descriptors, got %d", cache.len())
+	}
+
+	// Cached descriptors see new values.
+	write("port_xmit_data", "11\n")
+	if err := os.Remove(filepath.Join(dir, "port_rcv_data")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	gen := cache.begin()
+	if got := read(); got["port_xmit_data"] != 11 || len(got) != 1 {
+		t.Fatalf("unexpected counters after update %v", got)
+	}
+	cache.prune(gen)
+	if cache.len() != 1 {
+		t.Fatalf("expected the removed counter's descriptor to be closed, %d left", cache.len())
+	}
+
+	provider.SetCounterFDCache(false)
+	if cache.len() != 0 {
+		t.Fatalf("expected all descriptors closed after disabling, %d left", cache.len())
+	}
+}
+
+// BenchmarkReadCounterDir compares reading a port's hw_counters with
+// os.ReadFile against cached descriptors. Run it with TMPDIR on a tmpfs such
+// as /dev/shm to leave the disk out of the comparison.
+func BenchmarkReadCounterDir(b *testing.B) {
+	dir := b.TempDir()
+	for i := range 120 {
+		name := filepath.Join(dir, "counter_"+strconv.Itoa(i))
+		if err := os.WriteFile(name, []byte(strconv.Itoa(i*1000)+"\n"), 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, cached := range []bool{false, true} {
+		name := "readfile"
+		if cached {
+			name = "cached_fds"
+		}
+		b.Run(name, func(b *testing.B) {
+			provider := NewSysfsProvider()
+			provider.SetCounterFDCache(cached)
+			defer provider.Close()
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := provider.readCounterDir(ctx, dir); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// sysfsFuzzSeeds are file contents seen from real and broken drivers.
+var sysfsFuzzSeeds = []string{
+	"",
+	"0\n",
+	"18446744073709551615\n",
+	"18446744073709551616\n",
+	"-1\n",
+	"4: ACTIVE\n",
+	"5: LinkUp\n",
+	"ACTIVE",
+	"200 Gb/sec (4X HDR)\n",
+	"Inf Gb/sec",
+	"NaN Gb/sec",
+	"fe80:0000:0000:0000:0000:0000:0000:0001\n",
+	"0000:0000:0000:0000:0000:ffff:0a00:0001\n",
+	"N/A\n",
+	"\x00\xff\xfe",
+}
+
+func FuzzReadCounterDir(f *testing.F) {
+	for _, seed := range sysfsFuzzSeeds {
+		f.Add([]byte(seed))
+	}
+
+	dir := f.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "port_xmit_data"), nil, 0o644); err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		provider := NewSysfsProvider()
+		provider.readFile = func(string) ([]byte, error) { return data, nil }
+
+		counters, err := provider.readCounterDir(context.Background(), dir)
+		if err != nil {
+			t.Fatalf("readCounterDir returned error: %v", err)
+		}
+		want, parseErr := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		got, ok := counters["port_xmit_data"]
+		if ok != (parseErr == nil) || (ok && got != want) {
+			t.Fatalf("readCounterDir(%q) = %d, %v; ParseUint gives %d, %v", data, got, ok, want, parseErr)
+		}
+	})
+}
+
+func TestPortStateValue(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value   string
+		numeric func(string) (int, bool)
+		names   map[int]string
+	}{
+		{value: "4: ACTIVE", numeric: PortStateValue, names: portStateNames},
+		{value: "1: DOWN", numeric: PortStateValue, names: portStateNames},
+		{value: "5: LinkUp", numeric: PortPhysStateValue, names: portPhysStateNames},
+		{value: "3: Disabled", numeric: PortPhysStateValue, names: portPhysStateNames},
+	}
+	for _, tt := range tests {
+		want, err := strconv.Atoi(tt.value[:1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := tt.numeric(normalizePortState(tt.value, tt.names))
+		if !ok || got != want {
+			t.Fatalf("numeric state of %q = %d, %v; want %d", tt.value, got, ok, want)
+		}
+	}
+
+	if _, ok := PortStateValue(""); ok {
+		t.Fatalf("expected no numeric value for an empty state")
+	}
+}
+
+func FuzzNormalizePortState(f *testing.F) {
+	for _, seed := range sysfsFuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		for _, names := range []map[int]string{portStateNames, portPhysStateNames} {
+			got := normalizePortState(value, names)
+			if (got == "") != (strings.TrimSpace(value) == "") {
+				t.Fatalf("normalizePortState(%q) = %q", value, got)
+			}
+		}
+	})
+}
+
+func FuzzLinkRateBps(f *testing.F) {
+	for _, seed := range sysfsFuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, rate string) {
+		got, ok := LinkRateBps(rate)
+		if ok && (got <= 0 || math.IsInf(got, 0) || math.IsNaN(got)) {
+			t.Fatalf("LinkRateBps(%q) = %v, true", rate, got)
+		}
+		if !ok && got != 0 {
+			t.Fatalf("LinkRateBps(%q) = %v, false", rate, got)
+		}
+	})
+}
+
+// FuzzSysfsProviderDevices feeds the same content to every file of a device
+// tree, covering counters, attributes, GIDs and PCIe parsing in one read.
+func FuzzSysfsProviderDevices(f *testing.F) {
+	for _, seed := range sysfsFuzzSeeds {
+		f.Add([]byte(seed))
+	}
+
+	root := filepath.Join("testdata", "sysfs", "basic")
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		provider := NewSysfsProvider()
+		provider.SetSysfsRoot(root)
+		provider.readFile = func(string) ([]byte, error) { return data, nil }
+
+		devices, err := provider.Devices(context.Background())
+		if err != nil {
+			return
+		}
+		for _, device := range devices {
+			for _, port := range device.Ports {
+				if port.Attributes.State != "" && strings.TrimSpace(string(data)) == "" {
+					t.Fatalf("port %s/%d has state %q from empty files", device.Name, port.ID, port.Attributes.State)
+				}
+			}
+		}
+	})
+}
+
+func TestSysfsProviderFabricNetDevs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		fixture string
+		want    []string
+	}{
+		{"roce gid netdev", "basic", []string{"ens1f0np0"}},
+		{"ipoib shares pci function", "vf", []string{"ib0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			provider := NewSysfsProvider()
+			provider.SetSysfsRoot(filepath.Join("testdata", "sysfs", tt.fixture))
+
+			got, err := provider.FabricNetDevs(context.Background())
+			if err != nil {
+				t.Fatalf("FabricNetDevs returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSysfsProviderNetDevStatistics(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, classNetPath, "ens1f0np0", netDevStatisticsDirName)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeCounter(t, dir, "rx_dropped", "12\n")
+	writeCounter(t, dir, "tx_errors", "3\n")
+
+	provider := NewSysfsProvider()
+	provider.SetSysfsRoot(root)
+
+	got, err := provider.NetDevStatistics(context.Background(), "ens1f0np0")
+	if err != nil {
+		t.Fatalf("NetDevStatistics returned error: %v", err)
+	}
+	want := map[string]uint64{"rx_dropped": 12, "tx_errors": 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if _, err := provider.NetDevStatistics(context.Background(), "eth9"); err == nil {
+		t.Fatalf("expected error for missing netdev")
+	}
+}
+
+func TestGUIDVendor(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		guid string
+		want string
+	}{
+		{"0c42:a103:0000:0001", VendorNVIDIA},
+		{"ec0d:9a03:0078:6d28", VendorNVIDIA},
+		{"0011:7501:0165:2c4a", VendorIntel},
+		// bnxt_re: MAC 00:0a:f7:12:34:56 as a modified EUI-64.
+		{"020a:f7ff:fe12:3456", VendorBroadcom},
+		{"1234:5600:0000:0001", ""},
+		{"", ""},
+		{"0c42:a103", ""},
+		{"zzzz:a103:0000:0001", ""},
+	}
+	for _, tt := range tests {
+		if got := GUIDVendor(tt.guid); got != tt.want {
+			t.Fatalf("GUIDVendor(%q) = %q, want %q", tt.guid, got, tt.want)
+		}
+	}
+}
+
+func TestSysfsProviderGIDTable(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	portDir := filepath.Join(root, classInfinibandPath, "mlx5_0", portsDirName, "1")
+	gids := filepath.Join(portDir, gidsDirName)
+	types := filepath.Join(portDir, gidAttrsDirName, gidTypesDirName)
+	ndevs := filepath.Join(portDir, gidAttrsDirName, ndevsDirName)
+	for _, dir := range []string{gids, types, ndevs} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCounter(t, gids, "0", "fe80:0000:0000:0000:0e42:a1ff:fe03:0001\n")
+	writeCounter(t, types, "0", "IB/RoCE v1\n")
+	writeCounter(t, ndevs, "0", "ens1f0np0\n")
+	writeCounter(t, gids, "1", "fe80:0000:0000:0000:0e42:a1ff:fe03:0001\n")
+	writeCounter(t, types, "1", "RoCE v2\n")
+	writeCounter(t, ndevs, "1", "ens1f0np0\n")
+	writeCounter(t, gids, "3", "0000:0000:0000:0000:0000:ffff:0a00:0a01\n")
+	writeCounter(t, types, "3", "RoCE v2\n")
+	writeCounter(t, ndevs, "3", "ens1f0np0.100\n")
+	// Unused entries read as zero GIDs.
+	writeCounter(t, gids, "2", "0000:0000:0000:0000:0000:0000:0000:0000\n")
+	writeCounter(t, gids, "10", "0000:0000:0000:0000:0000:0000:0000:0000\n")
+
+	provider := NewSysfsProvider()
+	if err := provider.SetSysfsRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	got, err := provider.GIDTable(context.Background())
+	if err != nil {
+		t.Fatalf("GIDTable returned error: %v", err)
+	}
+	want := []GIDEntry{
+		{Device: "mlx5_0", Port: 1, Index: 0, GID: "fe80:0000:0000:0000:0e42:a1ff:fe03:0001", Type: "IB/RoCE v1", NetDev: "ens1f0np0"},
+		{Device: "mlx5_0", Port: 1, Index: 1, GID: "fe80:0000:0000:0000:0e42:a1ff:fe03:0001", Type: "RoCE v2", NetDev: "ens1f0np0"},
+		{Device: "mlx5_0", Port: 1, Index: 3, GID: "0000:0000:0000:0000:0000:ffff:0a00:0a01", Type: "RoCE v2", NetDev: "ens1f0np0.100"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected gid table:\n%+v\nwant:\n%+v", got, want)
+	}
+
+	provider.SetExcludeDevices([]string{"mlx5_0"})
+	if got, err := provider.GIDTable(context.Background()); err != nil || len(got) != 0 {
+		t.Fatalf("expected no entries for excluded devices, got %+v (err=%v)", got, err)
+	}
+}
+
+func TestSysfsProviderPKeyTable(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	pkeys := filepath.Join(root, classInfinibandPath, "mlx5_0", portsDirName, "1", pkeysDirName)
+	if err := os.MkdirAll(pkeys, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeCounter(t, pkeys, "0", "0xffff\n")
+	writeCounter(t, pkeys, "2", "0xa12\n")
+	// Unused entries read as zero, with or without the membership bit.
+	writeCounter(t, pkeys, "1", "0x0000\n")
+	writeCounter(t, pkeys, "3", "0x8000\n")
+
+	provider := NewSysfsProvider()
+	if err := provider.SetSysfsRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	got, err := provider.PKeyTable(context.Background())
+	if err != nil {
+		t.Fatalf("PKeyTable returned error: %v", err)
+	}
+	want := []PKeyEntry{
+		{Device: "mlx5_0", Port: 1, Index: 0, PKey: "0xffff"},
+		{Device: "mlx5_0", Port: 1, Index: 2, PKey: "0x0a12"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected pkey table:\n%+v\nwant:\n%+v", got, want)
+	}
+
+	provider.SetExcludeDevices([]string{"mlx5_0"})
+	if got, err := provider.PKeyTable(context.Background()); err != nil || len(got) != 0 {
+		t.Fatalf("expected no entries for excluded devices, got %+v (err=%v)", got, err)
+	}
+}
+
+func TestSysfsProviderRoCEConfigs(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	port := filepath.Join(root, classInfinibandPath, "mlx5_0", portsDirName, "1")
+	ndevs := filepath.Join(port, gidAttrsDirName, ndevsDirName)
+	ibPort := filepath.Join(root, classInfinibandPath, "mlx5_1", portsDirName, "1")
+	tc := filepath.Join(root, classInfinibandPath, "mlx5_0", tcDirName, "1")
+	cm := filepath.Join(root, configfsRDMACMPath, "mlx5_0", portsDirName, "1")
+	netDev := filepath.Join(root, classNetPath, "ens1f0np0")
+	np := filepath.Join(netDev, ecnDirName, "roce_np", ecnEnableDirName)
+	rp := filepath.Join(netDev, ecnDirName, "roce_rp", ecnEnableDirName)
+	for _, dir := range []string{ndevs, ibPort, tc, cm, filepath.Join(netDev, "qos"), np, rp} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCounter(t, port, linkLayerFile, "Ethernet\n")
+	writeCounter(t, ndevs, "0", "ens1f0np0\n")
+	writeCounter(t, ibPort, linkLayerFile, "InfiniBand\n")
+	writeCounter(t, tc, trafficClassFile, "Global tclass=106\n")
+	writeCounter(t, cm, defaultRoCEToSFile, "106\n")
+	writeCounter(t, cm, defaultRoCEModeFile, "RoCE v2\n")
+	writeCounter(t, netDev, qosTrustFile, "dscp\n")
+	writeCounter(t, np, "3", "1\n")
+	writeCounter(t, np, "0", "0\n")
+	writeCounter(t, rp, "3", "1\n")
+	writeCounter(t, filepath.Dir(np), "cnp_dscp", "48\n")
+	writeCounter(t, filepath.Dir(rp), "rpg_min_rate", "1\n")
+	writeCounter(t, filepath.Dir(rp), "rpg_max_rate", "0\n")
+	writeCounter(t, filepath.Dir(rp), "clamp_tgt_rate", "unsupported\n")
+
+	provider := NewSysfsProvider()
+	if err := provider.SetSysfsRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	got, err := provider.RoCEConfigs(context.Background())
+	if err != nil {
+		t.Fatalf("RoCEConfigs returned error: %v", err)
+	}
+	want := []RoCEConfig{{
+		Device:             "mlx5_0",
+		Port:               1,
+		NetDev:             "ens1f0np0",
+		DefaultToS:         106,
+		DefaultRoCEVersion: 2,
+		TrafficClass:       106,
+		Trust:              "dscp",
+		ECN: []ECNConfig{
+			{Point: "np", Priority: 0, Enabled: false},
+			{Point: "np", Priority: 3, Enabled: true},
+			{Point: "rp", Priority: 3, Enabled: true},
+		},
+		DCQCN: []DCQCNParam{
+			{Point: "np", Name: "cnp_dscp", Value: 48},
+			{Point: "rp", Name: "rpg_max_rate", Value: 0},
+			{Point: "rp", Name: "rpg_min_rate", Value: 1},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected RoCE configs:\n%+v\nwant:\n%+v", got, want)
+	}
+
+	// Without MLNX_OFED and configfs, only the ECN switches remain.
+	for _, path := range []string{filepath.Join(tc, trafficClassFile), filepath.Join(cm, defaultRoCEToSFile), filepath.Join(cm, defaultRoCEModeFile), filepath.Join(netDev, qosTrustFile)} {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err = provider.RoCEConfigs(context.Background())
+	if err != nil {
+		t.Fatalf("RoCEConfigs returned error: %v", err)
+	}
+	if len(got) != 1 || got[0].DefaultToS != -1 || got[0].DefaultRoCEVersion != 0 || got[0].TrafficClass != -1 || got[0].Trust != "" || len(got[0].ECN) != 3 {
+		t.Fatalf("unexpected RoCE configs without optional sources: %+v", got)
+	}
+}
+
+type staticProvider struct {
+	devices []Device
+}
+
+func (p staticProvider) Devices(context.Context) ([]Device, error) {
+	return p.devices, nil
+}
+
+func TestProviderRegistry(t *testing.T) {
+	t.Parallel()
+
+	RegisterProvider("registry-test", func(cfg ProviderConfig) (Provider, error) {
+		return staticProvider{devices: []Device{{Name: cfg.SysfsRoot}}}, nil
+	})
+
+	if names := ProviderNames(); !slices.Contains(names, "registry-test") {
+		t.Fatalf("expected registry-test in registered providers %v", names)
+	}
+
+	provider, err := NewProvider("registry-test", ProviderConfig{SysfsRoot: "mlx5_9"})
+	if err != nil {
+		t.Fatalf("NewProvider returned error: %v", err)
+	}
+	devices, err := provider.Devices(context.Background())
+	if err != nil || len(devices) != 1 || devices[0].Name != "mlx5_9" {
+		t.Fatalf("unexpected devices %+v (err=%v)", devices, err)
+	}
+
+	if _, err := NewProvider("does-not-exist", ProviderConfig{}); err == nil {
+		t.Fatalf("expected error for unknown provider")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic on duplicate registration")
+		}
+	}()
+	RegisterProvider("registry-test", func(ProviderConfig) (Provider, error) { return nil, nil })
+}
+
+func TestSysfsProviderFromRegistry(t *testing.T) {
+	t.Parallel()
+
+	if !slices.Contains(ProviderNames(), DefaultProviderName) {
+		t.Skip("sysfs provider excluded by build tag")
+	}
+
+	provider, err := NewProvider(DefaultProviderName, ProviderConfig{
+		SysfsRoot:      filepath.Join("testdata", "sysfs", "vf"),
+		ExcludeDevices: []string{"mlx5_4"},
+	})
+	if err != nil {
+		t.Fatalf("NewProvider returned error: %v", err)
+	}
+	devices, err := provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+	if len(devices) != 1 || devices[0].Name != "mlx5_0" {
+		t.Fatalf("expected only mlx5_0, got %+v", devices)
+	}
+}
+
+// fakeNldevConn answers RDMA_NLDEV requests from canned attribute payloads.
+type fakeNldevConn struct {
+	devices   [][]byte
+	ports     [][]byte
+	resources [][]byte
+	// qpStats maps device indexes to a STAT_GET dump of QP counters.
+	qpStats map[uint32][]byte
+	// objects maps resource dump commands to device indexes to replies;
+	// missing commands answer EOPNOTSUPP.
+	objects map[uint16]map[uint32][][]byte
+	// stats maps "devIndex/port" to a STAT_GET reply; missing entries answer
+	// EOPNOTSUPP.
+	stats  map[string][]byte
+	closed bool
+}
+
+func (c *fakeNldevConn) request(_ context.Context, cmd uint16, dump bool, attrs []byte) ([][]byte, error) {
+	switch cmd {
+	case nldevCmdGet:
+		return c.devices, nil
+	case nldevCmdPortGet:
+		return c.ports, nil
+	case nldevCmdResGet:
+		return c.resources, nil
+	case nldevCmdStatGet:
+		parsed, err := parseNlattrs(attrs)
+		if err != nil {
+			return nil, err
+		}
+		if dump {
+			if parsed.u32(nldevAttrStatRes) != nldevAttrResQP {
+				return nil, syscall.EINVAL
+			}
+			if reply, ok := c.qpStats[parsed.u32(nldevAttrDevIndex)]; ok {
+				return [][]byte{reply}, nil
+			}
+			return nil, syscall.EOPNOTSUPP
+		}
+		key := strconv.Itoa(int(parsed.u32(nldevAttrDevIndex))) + "/" + strconv.Itoa(int(parsed.u32(nldevAttrPortIndex)))
+		if reply, ok := c.stats[key]; ok {
+			return [][]byte{reply}, nil
+		}
+		return nil, syscall.EOPNOTSUPP
+	case nldevCmdResQPGet, nldevCmdResMRGet, nldevCmdResCtxGet:
+		byDevice, ok := c.objects[cmd]
+		if !ok {
+			return nil, syscall.EOPNOTSUPP
+		}
+		parsed, err := parseNlattrs(attrs)
+		if err != nil {
+			return nil, err
+		}
+		return byDevice[parsed.u32(nldevAttrDevIndex)], nil
+	}
+	return nil, syscall.EOPNOTSUPP
+}
+
+func (c *fakeNldevConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func nlString(b []byte, typ uint16, s string) []byte {
+	return appendNlattr(b, typ, append([]byte(s), 0))
+}
+
+func nlU8(b []byte, typ uint16, v uint8) []byte {
+	return appendNlattr(b, typ, []byte{v})
+}
+
+func nlU64(b []byte, typ uint16, v uint64) []byte {
+	return appendNlattr(b, typ, binary.NativeEndian.AppendUint64(nil, v))
+}
+
+func nldevDeviceReply(index uint32, name, protocol string) []byte {
+	var b []byte
+	b = appendNlattrU32(b, nldevAttrDevIndex, index)
+	b = nlString(b, nldevAttrDevName, name)
+	b = nlString(b, nldevAttrFWVersion, "28.39.1002")
+	b = nlU64(b, nldevAttrNodeGUID, 0xec0d9a0300786d28)
+	b = nlU64(b, nldevAttrSysImageGUID, 0xec0d9a0300786d28)
+	b = nlU8(b, nldevAttrDevNodeType, 1)
+	return nlString(b, nldevAttrDevProtocol, protocol)
+}
+
+func nldevPortReply(devIndex, port uint32, netdev string) []byte {
+	var b []byte
+	b = appendNlattrU32(b, nldevAttrDevIndex, devIndex)
+	b = appendNlattrU32(b, nldevAttrPortIndex, port)
+	b = nlU64(b, nldevAttrSubnetPrefix, 0xfe80000000000000)
+	b = nlU8(b, nldevAttrPortState, 4)
+	b = nlU8(b, nldevAttrPortPhysState, 5)
+	if netdev != "" {
+		b = nlString(b, nldevAttrNdevName, netdev)
+	} else {
+		// InfiniBand ports report LIDs.
+		b = nlU64(b, nldevAttrCapFlags, 0x3_a651e848)
+		b = appendNlattrU32(b, nldevAttrLID, 0x1a)
+		b = appendNlattrU32(b, nldevAttrSMLID, 1)
+		b = nlU8(b, nldevAttrLMC, 0)
+	}
+	return b
+}
+
+func nldevStatReply(counters map[string]uint64) []byte {
+	var entries []byte
+	for _, name := range slices.Sorted(maps.Keys(counters)) {
+		var entry []byte
+		entry = nlString(entry, nldevAttrStatHwCounterName, name)
+		entry = nlU64(entry, nldevAttrStatHwCounterValue, counters[name])
+		entries = appendNlattr(entries, nldevAttrStatHwCounterEntry|nlaFlagNested, entry)
+	}
+	return appendNlattr(nil, nldevAttrStatHwCounters|nlaFlagNested, entries)
+}
+
+// nlaFlagNested is NLA_F_NESTED, which the kernel sets on nested attributes.
+const nlaFlagNested = 0x8000
+
+func TestNetlinkProviderDevices(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	counters := filepath.Join(root, classInfinibandPath, "mlx5_0", portsDirName, "1", countersDirName)
+	if err := os.MkdirAll(counters, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeCounter(t, counters, "port_rcv_data", "1024\n")
+	// board_id and hca_type are only exposed in sysfs.
+	writeCounter(t, filepath.Join(root, classInfinibandPath, "mlx5_0"), boardIDFile, "MT_0000000838\n")
+	writeCounter(t, filepath.Join(root, classInfinibandPath, "mlx5_0"), hcaTypeFile, "MT4129\n")
+
+	conn := &fakeNldevConn{
+		devices: [][]byte{
+			nldevDeviceReply(2, "mlx5_1", "ib"),
+			nldevDeviceReply(1, "mlx5_0", "roce"),
+			nldevDeviceReply(3, "mlx5_2", "roce"),
+		},
+		ports: [][]byte{
+			nldevPortReply(1, 1, "eth0"),
+			nldevPortReply(2, 1, ""),
+			nldevPortReply(3, 1, "eth2"),
+		},
+		stats: map[string][]byte{
+			"1/1": nldevStatReply(map[string]uint64{"out_of_buffer": 3, "rx_write_requests": 42}),
+		},
+	}
+	provider := newNetlinkProvider(conn)
+	if err := provider.SetSysfsRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	provider.SetExcludeDevices([]string{"mlx5_2"})
+
+	devices, err := provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+
+	attrs := DeviceAttributes{FWVer: "28.39.1002", NodeGUID: "ec0d:9a03:0078:6d28", NodeType: "CA", SysImageGUID: "ec0d:9a03:0078:6d28"}
+	boardAttrs := attrs
+	boardAttrs.BoardID, boardAttrs.HCAType = "MT_0000000838", "MT4129"
+	expected := []Device{
+		{
+			Name:       "mlx5_0",
+			Attributes: boardAttrs,
+			Ports: []Port{{
+				ID:      1,
+				Stats:   map[string]uint64{"port_rcv_data": 1024},
+				HwStats: map[string]uint64{"out_of_buffer": 3, "rx_write_requests": 42},
+				Attributes: PortAttributes{
+					LinkLayer: "Ethernet",
+					State:     "ACTIVE",
+					PhysState: "LINK_UP",
+					NetDev:    "eth0",
+				},
+			}},
+		},
+		{
+			Name:       "mlx5_1",
+			Attributes: attrs,
+			Ports: []Port{{
+				ID: 1,
+				Attributes: PortAttributes{
+					LinkLayer: "InfiniBand",
+					State:     "ACTIVE",
+					PhysState: "LINK_UP",
+					Fabric:    "fe80:0000:0000:0000",
+					LID:       0x1a,
+					SMLID:     1,
+					CapMask:   0xa651e848,
+					HasLID:    true,
+				},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Fatalf("unexpected devices:\n%+v\nwant:\n%+v", devices, expected)
+	}
+
+	if err := provider.Close(); err != nil || !conn.closed {
+		t.Fatalf("expected Close to close the socket (err=%v)", err)
+	}
+}
+
+func TestParseNlattrs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+		want    int
+	}{
+		{name: "empty", data: nil, want: 0},
+		{name: "padded", data: nlString(appendNlattrU32(nil, 1, 7), 2, "mlx5_0"), want: 2},
+		{name: "truncated header", data: []byte{8, 0}, wantErr: true},
+		{name: "length beyond buffer", data: []byte{12, 0, 1, 0, 0, 0, 0, 0}, wantErr: true},
+		{name: "length below header", data: []byte{2, 0, 1, 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		attrs, err := parseNlattrs(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: unexpected error %v", tt.name, err)
+		}
+		if len(attrs) != tt.want {
+			t.Fatalf("%s: expected %d attributes, got %d", tt.name, tt.want, len(attrs))
+		}
+	}
+
+	attrs, _ := parseNlattrs(nlString(appendNlattrU32(nil, 1, 7), 2, "mlx5_0"))
+	if attrs.u32(1) != 7 || attrs.str(2) != "mlx5_0" || attrs.u64(1) != 0 || attrs.has(3) {
+		t.Fatalf("unexpected attribute accessors on %+v", attrs)
+	}
+}
+
+func TestNldevMessageType(t *testing.T) {
+	t.Parallel()
+
+	// RDMA_NL_GET_TYPE(RDMA_NL_NLDEV, RDMA_NLDEV_CMD_STAT_GET)
+	if got := nldevMessageType(nldevCmdStatGet); got != 0x1411 {
+		t.Fatalf("unexpected message type %#x", got)
+	}
+}
+
+func nldevResourceReply(name string, counts map[string]uint64) []byte {
+	var entries []byte
+	for _, resource := range slices.Sorted(maps.Keys(counts)) {
+		var entry []byte
+		entry = nlString(entry, nldevAttrResSummaryName, resource)
+		entry = nlU64(entry, nldevAttrResSummaryCurr, counts[resource])
+		entries = appendNlattr(entries, nldevAttrResSummaryEntry|nlaFlagNested, entry)
+	}
+	var b []byte
+	b = appendNlattrU32(b, nldevAttrDevIndex, 1)
+	b = nlString(b, nldevAttrDevName, name)
+	return appendNlattr(b, nldevAttrResSummary|nlaFlagNested, entries)
+}
+
+func TestNetlinkProviderResourceCounts(t *testing.T) {
+	t.Parallel()
+
+	conn := &fakeNldevConn{
+		resources: [][]byte{
+			nldevResourceReply("mlx5_0", map[string]uint64{"pd": 3, "cq": 24, "qp": 12, "cm_id": 0}),
+			nldevResourceReply("mlx5_1", map[string]uint64{"qp": 1}),
+		},
+	}
+	provider := newNetlinkProvider(conn)
+	provider.SetExcludeDevices([]string{"mlx5_1"})
+
+	counts, err := provider.ResourceCounts(context.Background())
+	if err != nil {
+		t.Fatalf("ResourceCounts returned error: %v", err)
+	}
+	expected := map[string]map[string]uint64{
+		"mlx5_0": {"pd": 3, "cq": 24, "qp": 12, "cm_id": 0},
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("unexpected resource counts %v", counts)
+	}
+
+	conn.resources = [][]byte{{8, 0}}
+	if _, err := provider.ResourceCounts(context.Background()); err == nil {
+		t.Fatalf("expected error for a malformed reply")
+	}
+}
+
+func TestSysfsProviderPrefersExtendedCounters(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	portDir := filepath.Join(root, classInfinibandPath, "mlx4_0", portsDirName, "1")
+	for _, dir := range []string{filepath.Join(portDir, countersDirName), filepath.Join(portDir, countersExtDirName)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCounter(t, filepath.Join(portDir, countersDirName), "port_xmit_data", "4294967295\n")
+	writeCounter(t, filepath.Join(portDir, countersDirName), "symbol_error", "2\n")
+	writeCounter(t, filepath.Join(portDir, countersExtDirName), "port_xmit_data_64", "68719476736\n")
+	writeCounter(t, filepath.Join(portDir, countersExtDirName), "port_unicast_xmit_packets", "7\n")
+
+	provider := NewSysfsProvider()
+	if err := provider.SetSysfsRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+	expected := map[string]uint64{
+		"port_xmit_data":            68719476736,
+		"port_unicast_xmit_packets": 7,
+		"symbol_error":              2,
+	}
+	if len(devices) != 1 || len(devices[0].Ports) != 1 || !reflect.DeepEqual(devices[0].Ports[0].Stats, expected) {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+}
+
+func TestSysfsProviderWarnings(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	device := filepath.Join(root, classInfinibandPath, "mlx5_0")
+	portDir := filepath.Join(device, portsDirName, "1")
+	for _, dir := range []string{
+		filepath.Join(portDir, countersDirName),
+		filepath.Join(device, portsDirName, "lost+found"),
+	} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCounter(t, filepath.Join(portDir, countersDirName), "port_rcv_data", "10\n")
+	writeCounter(t, filepath.Join(portDir, countersDirName), "port_xmit_data", "N/A\n")
+	// An Ethernet port without gid_attrs, as on old kernels.
+	writeCounter(t, portDir, linkLayerFile, "Ethernet\n")
+
+	provider := NewSysfsProvider()
+	if err := provider.SetSysfsRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := provider.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices returned error: %v", err)
+	}
+	if len(devices) != 1 || len(devices[0].Ports) != 1 || devices[0].Ports[0].Stats["port_rcv_data"] != 10 {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+
+	expected := map[string]uint64{
+		WarningCounterParse:        1,
+		WarningUnexpectedPortEntry: 1,
+		WarningLegacyLayout:        1,
+	}
+	if got := provider.Warnings(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected warnings %v", got)
+	}
+}
+
+type testQPCounter struct {
+	port, id uint32
+	mode     uint32
+	qpType   int
+	qpns     []uint32
+	stats    map[string]uint64
+}
+
+func nldevQPCounterReply(name string, counters ...testQPCounter) []byte {
+	var entries []byte
+	for _, counter := range counters {
+		var entry []byte
+		entry = appendNlattrU32(entry, nldevAttrPortIndex, counter.port)
+		entry = appendNlattrU32(entry, nldevAttrStatCounterID, counter.id)
+		entry = appendNlattrU32(entry, nldevAttrStatMode, counter.mode)
+		if counter.qpType >= 0 {
+			entry = nlU8(entry, nldevAttrResType, uint8(counter.qpType))
+		}
+		var qps []byte
+		for _, qpn := range counter.qpns {
+			qps = appendNlattr(qps, nldevAttrResQPEntry|nlaFlagNested, appendNlattrU32(nil, nldevAttrResLQPN, qpn))
+		}
+		entry = appendNlattr(entry, nldevAttrResQP|nlaFlagNested, qps)
+		entry = append(entry, nldevStatReply(counter.stats)...)
+		entries = appendNlattr(entries, nldevAttrStatCounterEntry|nlaFlagNested, entry)
+	}
+	var b []byte
+	b = nlString(b, nldevAttrDevName, name)
+	return appendNlattr(b, nldevAttrStatCounter|nlaFlagNested, entries)
+}
+
+func TestNetlinkProviderQPCounters(t *testing.T) {
+	t.Parallel()
+
+	conn := &fakeNldevConn{
+		devices: [][]byte{
+			nldevDeviceReply(1, "mlx5_0", "roce"),
+			nldevDeviceReply(2, "mlx5_1", "roce"),
+			nldevDeviceReply(3, "siw0", "iw"),
+		},
+		qpStats: map[uint32][]byte{
+			1: nldevQPCounterReply("mlx5_0",
+				testQPCounter{port: 1, id: 2, mode: 2, qpType: -1, qpns: []uint32{178}, stats: map[string]uint64{"packet_seq_err": 3}},
+				testQPCounter{port: 1, id: 1, mode: 1, qpType: 2, qpns: []uint32{180, 179}, stats: map[string]uint64{"out_of_sequence": 1}},
+			),
+			2: nldevQPCounterReply("mlx5_1",
+				testQPCounter{port: 1, id: 1, mode: 1, qpType: 4, qpns: []uint32{7}, stats: map[string]uint64{}},
+			),
+		},
+	}
+	provider := newNetlinkProvider(conn)
+	provider.SetExcludeDevices([]string{"mlx5_1"})
+
+	counters, err := provider.QPCounters(context.Background())
+	if err != nil {
+		t.Fatalf("QPCounters returned error: %v", err)
+	}
+	expected := []QPCounter{
+		{Device: "mlx5_0", Port: 1, ID: 1, Mode: "auto", QPType: "RC", QPNs: []uint32{179, 180}, Stats: map[string]uint64{"out_of_sequence": 1}},
+		{Device: "mlx5_0", Port: 1, ID: 2, Mode: "manual", QPNs: []uint32{178}, Stats: map[string]uint64{"packet_seq_err": 3}},
+	}
+	if !reflect.DeepEqual(counters, expected) {
+		t.Fatalf("unexpected qp counters:\n%+v\nwant:\n%+v", counters, expected)
+	}
+}
+
+// nldevObjectsReply builds one reply of a resource object dump with an object per
+// owner; owners are PIDs or, for kernel objects, module names.
+func nldevObjectsReply(nest, entry uint16, owners ...any) []byte {
+	var objects []byte
+	for _, owner := range owners {
+		var fields []byte
+		switch owner := owner.(type) {
+		case int:
+			fields = appendNlattrU32(fields, nldevAttrResPID, uint32(owner))
+		case string:
+			fields = nlString(fields, nldevAttrResKernName, owner)
+		}
+		objects = appendNlattr(objects, entry|nlaFlagNested, fields)
+	}
+	return appendNlattr(nlString(nil, nldevAttrDevName, "mlx5_0"), nest|nlaFlagNested, objects)
+}
+
+func TestNetlinkProviderProcessResourceCounts(t *testing.T) {
+	t.Parallel()
+
+	procfs := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(procfs, "4242"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeCounter(t, filepath.Join(procfs, "4242"), "comm", "trainer\n")
+
+	conn := &fakeNldevConn{
+		devices: [][]byte{nldevDeviceReply(1, "mlx5_0", "roce")},
+		objects: map[uint16]map[uint32][][]byte{
+			nldevCmdResQPGet: {1: {
+				nldevObjectsReply(nldevAttrResQP, nldevAttrResQPEntry, "ib_core", "ib_core", 4242),
+				nldevObjectsReply(nldevAttrResQP, nldevAttrResQPEntry, 4242, 4343),
+			}},
+			nldevCmdResMRGet: {1: {
+				nldevObjectsReply(nldevAttrResMR, nldevAttrResMREntry, 4242),
+			}},
+		},
+	}
+	provider := newNetlinkProvider(conn)
+	provider.SetProcfsRoot(procfs)
+
+	counts, err := provider.ProcessResourceCounts(context.Background())
+	if err != nil {
+		t.Fatalf("ProcessResourceCounts returned error: %v", err)
+	}
+	// 4343 has no comm file, as if it exited; user contexts are unsupported.
+	expected := []ProcessResourceCount{
+		{Device: "mlx5_0", Resource: "mr", PID: 4242, Comm: "trainer", Count: 1},
+		{Device: "mlx5_0", Resource: "qp", PID: 0, Comm: "[ib_core]", Count: 2},
+		{Device: "mlx5_0", Resource: "qp", PID: 4242, Comm: "trainer", Count: 2},
+		{Device: "mlx5_0", Resource: "qp", PID: 4343, Comm: "", Count: 1},
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("unexpected process resource counts:\n%+v\nwant:\n%+v", counts, expected)
+	}
+}
+
+func TestParseUEvent(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		msg  string
+		want UEvent
+		ok   bool
+	}{
+		{
+			name: "add",
+			msg:  "add@/devices/pci0000:00/0000:00:01.0/0000:1a:00.0/infiniband/mlx5_0\x00ACTION=add\x00DEVPATH=/devices/pci0000:00/0000:00:01.0/0000:1a:00.0/infiniband/mlx5_0\x00SUBSYSTEM=infiniband\x00NAME=mlx5_0\x00SEQNUM=4242\x00",
+			want: UEvent{Action: "add", Device: "mlx5_0"},
+			ok:   true,
+		},
+		{
+			name: "remove",
+			msg:  "remove@/devices/virtual/infiniband/rxe0\x00ACTION=remove\x00DEVPATH=/devices/virtual/infiniband/rxe0\x00SUBSYSTEM=infiniband\x00SEQNUM=4243\x00",
+			want: UEvent{Action: "remove", Device: "rxe0"},
+			ok:   true,
+		},
+		{
+			name: "other subsystem",
+			msg:  "add@/devices/pci0000:00/0000:00:01.0/0000:1a:00.0/infiniband_verbs/uverbs0\x00ACTION=add\x00DEVPATH=/devices/pci0000:00/0000:00:01.0/0000:1a:00.0/infiniband_verbs/uverbs0\x00SUBSYSTEM=infiniband_verbs\x00",
+		},
+		{
+			name: "udev",
+			msg:  "libudev\x00\xfe\xed\xca\xfe",
+		},
+		{
+			name: "missing devpath",
+			msg:  "change@/devices/virtual/infiniband/rxe0\x00ACTION=change\x00SUBSYSTEM=infiniband\x00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := ParseUEvent([]byte(tt.msg))
+			if ok != tt.ok || got != tt.want {
+				t.Fatalf("ParseUEvent() = %+v, %t; want %+v, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+type stubUEventConn struct {
+	msgs []string
+	done chan struct{}
+}
+
+func (s *stubUEventConn) receive() ([]byte, error) {
+	if len(s.msgs) == 0 {
+		select {
+		case <-s.done:
+		default:
+			close(s.done)
+		}
+		time.Sleep(time.Millisecond)
+		return nil, nil
+	}
+	msg := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	return []byte(msg), nil
+}
+
+func (s *stubUEventConn) Close() error {
+	return nil
+}
+
+func TestUEventWatcherCountsEvents(t *testing.T) {
+	t.Parallel()
+
+	event := func(action, device string) string {
+		return action + "@/devices/virtual/infiniband/" + device + "\x00ACTION=" + action + "\x00DEVPATH=/devices/virtual/infiniband/" + device + "\x00SUBSYSTEM=infiniband\x00"
+	}
+	conn := &stubUEventConn{
+		msgs: []string{
+			event("remove", "mlx5_0"),
+			event("add", "mlx5_0"),
+			event("remove", "mlx5_0"),
+			event("add", "mlx5_0"),
+			event("add", "mlx5_1"),
+			"libudev\x00",
+		},
+		done: make(chan struct{}),
+	}
+	watcher := newUEventWatcher(conn)
+	watcher.SetExcludeDevices([]string{"mlx5_1"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- watcher.Run(ctx)
+	}()
+	<-conn.done
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+
+	want := []UEventCount{
+		{Device: "mlx5_0", Action: "add", Count: 2},
+		{Device: "mlx5_0", Action: "remove", Count: 2},
+	}
+	if got := watcher.UEventCounts(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected uevent counts:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+// fakeDCBConn answers dcbnl requests with a canned reply per netdev.
+type fakeDCBConn struct {
+	replies map[string][]byte
+	err     error
+}
+
+func (c *fakeDCBConn) request(_ context.Context, payload []byte) ([][]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	attrs, err := parseNlattrs(payload[dcbmsgLen:])
+	if err != nil {
+		return nil, err
+	}
+	reply, ok := c.replies[attrs.str(dcbAttrIfName)]
+	if !ok {
+		return nil, syscall.ENODEV
+	}
+	return [][]byte{reply}, nil
+}
+
+func (c *fakeDCBConn) Close() error { return nil }
+
+func TestDCBReader(t *testing.T) {
+	t.Parallel()
+
+	ets := make([]byte, 59)
+	copy(ets[ieeeETSTxBWOffset:], []byte{10, 50, 40, 0, 0, 0, 0, 0})
+	copy(ets[ieeeETSPrioTCOffset:], []byte{0, 0, 0, 1, 2, 2, 2, 2})
+	pfc := make([]byte, 136)
+	pfc[ieeePFCEnOffset] = 1 << 3
+	binary.NativeEndian.PutUint16(pfc[ieeePFCDelayOffset:], 32)
+	ieee := appendNlattr(nil, dcbAttrIEEEETS, ets)
+	ieee = appendNlattr(ieee, dcbAttrIEEEPFC, pfc)
+
+	reply := []byte{0, dcbCmdIEEEGet, 0, 0}
+	reply = appendNlattr(reply, dcbAttrIfName, []byte("ens1f0np0\x00"))
+	reply = appendNlattr(reply, dcbAttrIEEE, ieee)
+	etsOnly := []byte{0, dcbCmdIEEEGet, 0, 0}
+	etsOnly = appendNlattr(etsOnly, dcbAttrIEEE, appendNlattr(nil, dcbAttrIEEEETS, ets))
+
+	reader := &DCBReader{conn: &fakeDCBConn{replies: map[string][]byte{
+		"ens1f0np0": reply,
+		"ens1f1np1": etsOnly,
+	}}}
+
+	tests := []struct {
+		netDev  string
+		want    DCBConfig
+		wantErr bool
+	}{
+		{
+			netDev: "ens1f0np0",
+			want: DCBConfig{
+				PFC:          true,
+				PFCEnabled:   1 << 3,
+				PFCDelay:     32,
+				ETS:          true,
+				ETSBandwidth: [8]uint8{10, 50, 40},
+				PriorityTC:   [8]uint8{0, 0, 0, 1, 2, 2, 2, 2},
+			},
+		},
+		{
+			netDev: "ens1f1np1",
+			want: DCBConfig{
+				ETS:          true,
+				ETSBandwidth: [8]uint8{10, 50, 40},
+				PriorityTC:   [8]uint8{0, 0, 0, 1, 2, 2, 2, 2},
+			},
+		},
+		{netDev: "missing0", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := reader.DCB(context.Background(), tt.netDev)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("DCB(%s) error = %v, wantErr %v", tt.netDev, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Fatalf("DCB(%s) = %+v, want %+v", tt.netDev, got, tt.want)
+		}
+	}
+
+	unsupported := &DCBReader{conn: &fakeDCBConn{err: errDCBUnsupported}}
+	if got, err := unsupported.DCB(context.Background(), "eth0"); err != nil || got != (DCBConfig{}) {
+		t.Fatalf("expected no configuration for a netdev without dcbnl, got %+v, %v", got, err)
 	}
-	return path
 }
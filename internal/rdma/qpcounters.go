@@ -0,0 +1,151 @@
+package rdma
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"syscall"
+)
+
+// QP counter binding modes, as reported by "rdma statistic qp mode".
+// ref. https://codebrowser.dev/linux/linux/include/rdma/rdma_counter.h.html
+var qpCounterModeNames = map[uint32]string{
+	0: "none",
+	1: "auto",
+	2: "manual",
+}
+
+// ref. https://codebrowser.dev/linux/linux/include/rdma/ib_verbs.h.html#ib_qp_type
+var qpTypeNames = map[uint8]string{
+	0:    "SMI",
+	1:    "GSI",
+	2:    "RC",
+	3:    "UC",
+	4:    "UD",
+	5:    "RAW_IPV6",
+	6:    "RAW_ETHERTYPE",
+	8:    "RAW_PACKET",
+	9:    "XRC_INI",
+	10:   "XRC_TGT",
+	0xff: "DRIVER",
+}
+
+// QPCounter is a kernel statistics counter that queue pairs are bound to,
+// either one per QP type in auto mode ("rdma statistic qp set ... auto type
+// on") or explicitly ("rdma statistic qp bind").
+type QPCounter struct {
+	Device string
+	Port   int
+	ID     uint32
+	// Mode is "auto" or "manual".
+	Mode string
+	// QPType is the QP type the counter groups (e.g. "RC") in auto mode by
+	// type; empty otherwise.
+	QPType string
+	// QPNs lists the local QP numbers bound to the counter.
+	QPNs  []uint32
+	Stats map[string]uint64
+}
+
+// QPCounters returns the QP counters of every device, sorted by device, port
+// and counter ID. Ports without bound QPs have none. Excluded devices are
+// skipped.
+func (p *NetlinkProvider) QPCounters(ctx context.Context) ([]QPCounter, error) {
+	replies, err := p.conn.request(ctx, nldevCmdGet, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dump rdma devices: %w", err)
+	}
+
+	var counters []QPCounter
+	for _, reply := range replies {
+		dev, err := parseNldevDevice(reply)
+		if err != nil {
+			return nil, err
+		}
+		if p.sysfs.isExcluded(dev.name) {
+			continue
+		}
+
+		var req []byte
+		req = appendNlattrU32(req, nldevAttrDevIndex, dev.index)
+		req = appendNlattrU32(req, nldevAttrStatRes, nldevAttrResQP)
+		stats, err := p.conn.request(ctx, nldevCmdStatGet, true, req)
+		if errors.Is(err, syscall.EOPNOTSUPP) {
+			// Drivers without hw counters cannot bind QPs.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("dump qp counters of %s: %w", dev.name, err)
+		}
+		for _, stat := range stats {
+			parsed, err := parseNldevQPCounters(dev.name, stat)
+			if err != nil {
+				return nil, err
+			}
+			counters = append(counters, parsed...)
+		}
+	}
+	slices.SortFunc(counters, func(a, b QPCounter) int {
+		return cmp.Or(cmp.Compare(a.Device, b.Device), cmp.Compare(a.Port, b.Port), cmp.Compare(a.ID, b.ID))
+	})
+	return counters, nil
+}
+
+// parseNldevQPCounters parses one reply of a STAT_GET dump of QP counters.
+func parseNldevQPCounters(device string, data []byte) ([]QPCounter, error) {
+	attrs, err := parseNlattrs(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse qp counters of %s: %w", device, err)
+	}
+	entries, err := parseNlattrs(attrs.get(nldevAttrStatCounter))
+	if err != nil {
+		return nil, fmt.Errorf("parse qp counters of %s: %w", device, err)
+	}
+
+	var counters []QPCounter
+	for _, entry := range entries {
+		if entry.typ != nldevAttrStatCounterEntry {
+			continue
+		}
+		fields, err := parseNlattrs(entry.data)
+		if err != nil {
+			return nil, fmt.Errorf("parse qp counters of %s: %w", device, err)
+		}
+		counter := QPCounter{
+			Device: device,
+			Port:   int(fields.u32(nldevAttrPortIndex)),
+			ID:     fields.u32(nldevAttrStatCounterID),
+			Mode:   qpCounterModeNames[fields.u32(nldevAttrStatMode)],
+		}
+		if fields.has(nldevAttrResType) {
+			counter.QPType = qpTypeNames[fields.u8(nldevAttrResType)]
+		}
+
+		qps, err := parseNlattrs(fields.get(nldevAttrResQP))
+		if err != nil {
+			return nil, fmt.Errorf("parse qp counters of %s: %w", device, err)
+		}
+		for _, qp := range qps {
+			if qp.typ != nldevAttrResQPEntry {
+				continue
+			}
+			qpFields, err := parseNlattrs(qp.data)
+			if err != nil {
+				return nil, fmt.Errorf("parse qp counters of %s: %w", device, err)
+			}
+			if qpFields.has(nldevAttrResLQPN) {
+				counter.QPNs = append(counter.QPNs, qpFields.u32(nldevAttrResLQPN))
+			}
+		}
+		slices.Sort(counter.QPNs)
+
+		counter.Stats, err = parseNldevHwCounters(entry.data)
+		if err != nil {
+			return nil, fmt.Errorf("parse qp counters of %s: %w", device, err)
+		}
+		counters = append(counters, counter)
+	}
+	return counters, nil
+}
@@ -0,0 +1,75 @@
+package rdma
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// DefaultProviderName is the provider used when none is configured.
+const DefaultProviderName = "sysfs"
+
+// ProviderConfig carries the settings shared by all providers. Providers
+// ignore fields that do not apply to them.
+type ProviderConfig struct {
+	SysfsRoot string
+	// AllowedSysfsRoots restricts SysfsRoot to these directory prefixes.
+	AllowedSysfsRoots   []string
+	ExcludeDevices      []string
+	FabricIPv4PrefixLen int
+	ChangeDetection     ChangeDetection
+	RetryPolicy         RetryPolicy
+	// CacheCounterFDs keeps counter files open between reads.
+	CacheCounterFDs bool
+	// ProcfsRoot is where providers look up processes, e.g. resource owners.
+	ProcfsRoot string
+}
+
+// ProviderFactory builds a Provider from the common configuration.
+type ProviderFactory func(cfg ProviderConfig) (Provider, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]ProviderFactory)
+)
+
+// RegisterProvider makes a provider selectable by name. It is intended to be
+// called from init functions, so registration files can be included or left
+// out with build tags. Registering a name twice panics.
+func RegisterProvider(name string, factory ProviderFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("rdma: RegisterProvider factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("rdma: RegisterProvider called twice for provider " + name)
+	}
+	registry[name] = factory
+}
+
+// NewProvider builds the provider registered under name.
+func NewProvider(name string, cfg ProviderConfig) (Provider, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q (available: %v)", name, ProviderNames())
+	}
+	return factory(cfg)
+}
+
+// ProviderNames returns the registered provider names in sorted order.
+func ProviderNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,31 @@
+//go:build !no_netlink_provider && !sysfs_only
+
+package rdma
+
+func init() {
+	RegisterProvider(NetlinkProviderName, newNetlinkProviderFromConfig)
+}
+
+// newNetlinkProviderFromConfig builds a NetlinkProvider. Change detection,
+// retries and the fabric prefix length only apply to sysfs walks and are
+// ignored.
+func newNetlinkProviderFromConfig(cfg ProviderConfig) (Provider, error) {
+	provider, err := NewNetlinkProvider()
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.SetAllowedSysfsRoots(cfg.AllowedSysfsRoots); err != nil {
+		provider.Close()
+		return nil, err
+	}
+	if err := provider.SetSysfsRoot(cfg.SysfsRoot); err != nil {
+		provider.Close()
+		return nil, err
+	}
+	if len(cfg.ExcludeDevices) > 0 {
+		provider.SetExcludeDevices(cfg.ExcludeDevices)
+	}
+	provider.SetCounterFDCache(cfg.CacheCounterFDs)
+	provider.SetProcfsRoot(cfg.ProcfsRoot)
+	return provider, nil
+}
@@ -0,0 +1,25 @@
+//go:build !no_sysfs_provider
+
+package rdma
+
+func init() {
+	RegisterProvider(DefaultProviderName, newSysfsProviderFromConfig)
+}
+
+func newSysfsProviderFromConfig(cfg ProviderConfig) (Provider, error) {
+	provider := NewSysfsProvider()
+	if err := provider.SetAllowedSysfsRoots(cfg.AllowedSysfsRoots); err != nil {
+		return nil, err
+	}
+	if err := provider.SetSysfsRoot(cfg.SysfsRoot); err != nil {
+		return nil, err
+	}
+	if len(cfg.ExcludeDevices) > 0 {
+		provider.SetExcludeDevices(cfg.ExcludeDevices)
+	}
+	provider.SetFabricIPv4PrefixLength(cfg.FabricIPv4PrefixLen)
+	provider.SetChangeDetection(cfg.ChangeDetection)
+	provider.SetRetryPolicy(cfg.RetryPolicy)
+	provider.SetCounterFDCache(cfg.CacheCounterFDs)
+	return provider, nil
+}
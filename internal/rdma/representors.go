@@ -0,0 +1,98 @@
+package rdma
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const physPortNameFile = "phys_port_name"
+
+// representorPortName matches the phys_port_name of a switchdev VF
+// representor, e.g. "pf0vf3", or "c1pf0vf3" for an external controller on
+// BlueField DPUs.
+var representorPortName = regexp.MustCompile(`^(?:c[0-9]+)?pf([0-9]+)vf([0-9]+)$`)
+
+// Representor is a switchdev VF representor netdev. Its ethtool stats carry
+// the vport counters of the VF it represents.
+type Representor struct {
+	NetDev string
+	// PFDevice is the RDMA device of the PF whose eswitch the VF belongs to.
+	PFDevice string
+	PF       int
+	VF       int
+}
+
+// Representors lists the VF representor netdevs of non-excluded RDMA
+// devices. Representors share the PCI function of their eswitch manager PF,
+// which attributes them to an RDMA device.
+func (p *SysfsProvider) Representors(ctx context.Context) ([]Representor, error) {
+	p.mu.RLock()
+	root := p.sysfsRoot
+	p.mu.RUnlock()
+
+	pfByPCIAddr := make(map[string]string)
+	ibDir := filepath.Join(root, classInfinibandPath)
+	ibEntries, err := os.ReadDir(ibDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", ibDir, err)
+	}
+	for _, entry := range ibEntries {
+		name := entry.Name()
+		if p.isExcluded(name) {
+			continue
+		}
+		if _, addr := resolvePCIFunction(filepath.Join(ibDir, name, deviceDirName)); addr != "" {
+			pfByPCIAddr[addr] = name
+		}
+	}
+	if len(pfByPCIAddr) == 0 {
+		return nil, nil
+	}
+
+	netDir := filepath.Join(root, classNetPath)
+	netEntries, err := os.ReadDir(netDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", netDir, err)
+	}
+
+	var result []Representor
+	for _, entry := range netEntries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name := entry.Name()
+		raw, err := p.readFile(filepath.Join(netDir, name, physPortNameFile))
+		if err != nil {
+			continue
+		}
+		match := representorPortName.FindStringSubmatch(strings.TrimSpace(string(raw)))
+		if match == nil {
+			continue
+		}
+		_, addr := resolvePCIFunction(filepath.Join(netDir, name, deviceDirName))
+		pfDevice, ok := pfByPCIAddr[addr]
+		if !ok {
+			continue
+		}
+		pf, _ := strconv.Atoi(match[1])
+		vf, _ := strconv.Atoi(match[2])
+		result = append(result, Representor{NetDev: name, PFDevice: pfDevice, PF: pf, VF: vf})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].NetDev < result[j].NetDev })
+	return result, nil
+}
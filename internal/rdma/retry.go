@@ -0,0 +1,136 @@
+package rdma
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how often a device read failing with a transient
+// error is retried. Firmware updates and resets make mlx5 counter files
+// return EBUSY or EAGAIN for a few hundred milliseconds.
+type RetryPolicy struct {
+	// Attempts is the total number of reads per device, including the
+	// first. Values below 1 mean a single attempt.
+	Attempts int
+	// Backoff is the wait before the first retry; it doubles on every
+	// further retry.
+	Backoff time.Duration
+}
+
+// DeviceReadStats counts the retries and failed reads of one device since
+// the provider was created.
+type DeviceReadStats struct {
+	Device  string
+	Retries uint64
+	Errors  uint64
+}
+
+// SetRetryPolicy configures retries of device reads that fail with a
+// transient error.
+func (p *SysfsProvider) SetRetryPolicy(policy RetryPolicy) {
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	if policy.Backoff < 0 {
+		policy.Backoff = 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retry = policy
+}
+
+// ReadStats returns the retry and error counts of every device that needed
+// a retry or failed, sorted by device name.
+func (p *SysfsProvider) ReadStats() []DeviceReadStats {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	out := make([]DeviceReadStats, 0, len(p.readStats))
+	for _, stats := range p.readStats {
+		out = append(out, *stats)
+	}
+	slices.SortFunc(out, func(a, b DeviceReadStats) int {
+		return strings.Compare(a.Device, b.Device)
+	})
+	return out
+}
+
+func (p *SysfsProvider) recordRead(device string, retries uint64, failed bool) {
+	if retries == 0 && !failed {
+		return
+	}
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	if p.readStats == nil {
+		p.readStats = make(map[string]*DeviceReadStats)
+	}
+	stats, ok := p.readStats[device]
+	if !ok {
+		stats = &DeviceReadStats{Device: device}
+		p.readStats[device] = stats
+	}
+	stats.Retries += retries
+	if failed {
+		stats.Errors++
+	}
+}
+
+// isTransientReadError reports whether a sysfs read error is expected to
+// clear up on its own.
+func isTransientReadError(err error) bool {
+	return errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR)
+}
+
+// deviceWithRetry reads a device, retrying transient errors according to the
+// retry policy. skip is true when the device still failed with a transient
+// error after the last attempt; the caller leaves it out of the snapshot
+// instead of failing the whole read.
+func (p *SysfsProvider) deviceWithRetry(ctx context.Context, root, name string, opts ReadOptions) (device Device, skip bool, err error) {
+	p.mu.RLock()
+	policy := p.retry
+	p.mu.RUnlock()
+
+	backoff := policy.Backoff
+	var retries uint64
+	for attempt := 1; ; attempt++ {
+		device, err = p.deviceFromRoot(ctx, root, name, opts)
+		if err == nil {
+			p.recordRead(name, retries, false)
+			return device, false, nil
+		}
+		if ctx.Err() != nil || !isTransientReadError(err) {
+			p.recordRead(name, retries, true)
+			return Device{}, false, err
+		}
+		if attempt >= policy.Attempts {
+			p.recordRead(name, retries, true)
+			return Device{}, true, err
+		}
+
+		retries++
+		if err := sleepContext(ctx, backoff); err != nil {
+			p.recordRead(name, retries, true)
+			return Device{}, false, err
+		}
+		backoff *= 2
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,232 @@
+package rdma
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// configfsRDMACMPath holds the rdma_cm settings of a device once an
+	// administrator created /sys/kernel/config/rdma_cm/<dev>.
+	configfsRDMACMPath = "kernel/config/rdma_cm"
+	defaultRoCEToSFile = "default_roce_tos"
+	// defaultRoCEModeFile reads a GID type, "IB/RoCE v1" or "RoCE v2".
+	defaultRoCEModeFile = "default_roce_mode"
+	// tcDirName and qosTrustFile are only exposed by MLNX_OFED.
+	tcDirName        = "tc"
+	trafficClassFile = "traffic_class"
+	qosTrustFile     = "qos/trust"
+	ecnDirName       = "ecn"
+	ecnEnableDirName = "enable"
+)
+
+// ecnPoints maps the mlx5 ECN directories of a netdev to the congestion
+// control role they configure.
+var ecnPoints = map[string]string{
+	"roce_np": "np",
+	"roce_rp": "rp",
+}
+
+// trafficClassPattern matches the traffic class in MLNX_OFED's
+// tc/<port>/traffic_class, which reads e.g. "Global tclass=106".
+var trafficClassPattern = regexp.MustCompile(`tclass=(\d+)`)
+
+// RoCEConfig is the QoS configuration that decides which traffic class and
+// congestion control the RDMA traffic of a RoCE port gets.
+type RoCEConfig struct {
+	Device string
+	Port   int
+	NetDev string
+	// DefaultToS is the ToS byte of RDMA CM connections that do not set one,
+	// from configfs rdma_cm. -1 when it is not configured.
+	DefaultToS int
+	// DefaultRoCEVersion is the RoCE version, 1 or 2, of RDMA CM connections,
+	// from configfs rdma_cm. 0 when it is not configured.
+	DefaultRoCEVersion int
+	// TrafficClass is the traffic class MLNX_OFED forces on all traffic of
+	// the port. -1 when none is set.
+	TrafficClass int
+	// Trust is the QoS trust state of the netdev, "pcp" or "dscp". Empty when
+	// the driver does not expose it.
+	Trust string
+	// ECN lists the per-priority ECN switches of the netdev, sorted by point
+	// and priority.
+	ECN []ECNConfig
+	// DCQCN lists the congestion control parameters next to the ECN
+	// switches, sorted by point and name.
+	DCQCN []DCQCNParam
+}
+
+// ECNConfig is whether ECN is enabled for one priority of a netdev.
+type ECNConfig struct {
+	// Point is "np" for the notification point, which sends CNPs for marked
+	// packets, or "rp" for the reaction point, which throttles on them.
+	Point    string
+	Priority int
+	Enabled  bool
+}
+
+// DCQCNParam is a DCQCN parameter of a netdev, such as the reaction point's
+// rpg_min_rate or the notification point's cnp_dscp. Units depend on the
+// parameter.
+type DCQCNParam struct {
+	Point string
+	Name  string
+	Value int64
+}
+
+// RoCEConfigs returns the QoS configuration of every RoCE port, sorted by
+// device and port. Excluded devices are skipped.
+func (p *SysfsProvider) RoCEConfigs(ctx context.Context) ([]RoCEConfig, error) {
+	p.mu.RLock()
+	root := p.sysfsRoot
+	p.mu.RUnlock()
+
+	ibDir := filepath.Join(root, classInfinibandPath)
+	devices, err := os.ReadDir(ibDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", ibDir, err)
+	}
+
+	var configs []RoCEConfig
+	for _, device := range devices {
+		if p.isExcluded(device.Name()) {
+			continue
+		}
+		portsDir := filepath.Join(ibDir, device.Name(), portsDirName)
+		ports, err := os.ReadDir(portsDir)
+		if err != nil {
+			continue
+		}
+		for _, port := range ports {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			portID, err := strconv.Atoi(port.Name())
+			if err != nil {
+				continue
+			}
+			portDir := filepath.Join(portsDir, port.Name())
+			if p.readTrimmed(filepath.Join(portDir, linkLayerFile)) != "Ethernet" {
+				continue
+			}
+			configs = append(configs, p.readRoCEConfig(ctx, root, device.Name(), portID, portDir))
+		}
+	}
+	slices.SortFunc(configs, func(a, b RoCEConfig) int {
+		return cmp.Or(cmp.Compare(a.Device, b.Device), cmp.Compare(a.Port, b.Port))
+	})
+	return configs, nil
+}
+
+// readRoCEConfig reads the configuration of one RoCE port. Every source is
+// optional, so missing files leave their field unset.
+func (p *SysfsProvider) readRoCEConfig(ctx context.Context, root, device string, port int, portDir string) RoCEConfig {
+	config := RoCEConfig{
+		Device:       device,
+		Port:         port,
+		NetDev:       p.readPortNetDev(ctx, portDir),
+		DefaultToS:   -1,
+		TrafficClass: -1,
+	}
+
+	portName := strconv.Itoa(port)
+	tos := p.readTrimmed(filepath.Join(root, configfsRDMACMPath, device, portsDirName, portName, defaultRoCEToSFile))
+	if value, err := strconv.ParseUint(tos, 10, 8); err == nil {
+		config.DefaultToS = int(value)
+	}
+	switch p.readTrimmed(filepath.Join(root, configfsRDMACMPath, device, portsDirName, portName, defaultRoCEModeFile)) {
+	case "IB/RoCE v1":
+		config.DefaultRoCEVersion = 1
+	case "RoCE v2":
+		config.DefaultRoCEVersion = 2
+	}
+	tc := p.readTrimmed(filepath.Join(root, classInfinibandPath, device, tcDirName, portName, trafficClassFile))
+	if m := trafficClassPattern.FindStringSubmatch(tc); m != nil {
+		if value, err := strconv.ParseUint(m[1], 10, 8); err == nil {
+			config.TrafficClass = int(value)
+		}
+	}
+	if config.NetDev == "" {
+		return config
+	}
+
+	netDevDir := filepath.Join(root, classNetPath, config.NetDev)
+	config.Trust = p.readTrimmed(filepath.Join(netDevDir, qosTrustFile))
+	for dir, point := range ecnPoints {
+		pointDir := filepath.Join(netDevDir, ecnDirName, dir)
+		config.ECN = append(config.ECN, p.readECNSwitches(filepath.Join(pointDir, ecnEnableDirName), point)...)
+		config.DCQCN = append(config.DCQCN, p.readDCQCNParams(pointDir, point)...)
+	}
+	slices.SortFunc(config.ECN, func(a, b ECNConfig) int {
+		return cmp.Or(cmp.Compare(a.Point, b.Point), cmp.Compare(a.Priority, b.Priority))
+	})
+	slices.SortFunc(config.DCQCN, func(a, b DCQCNParam) int {
+		return cmp.Or(cmp.Compare(a.Point, b.Point), cmp.Compare(a.Name, b.Name))
+	})
+	return config
+}
+
+// readECNSwitches reads the per-priority files of an ECN enable directory.
+func (p *SysfsProvider) readECNSwitches(enableDir, point string) []ECNConfig {
+	files, err := os.ReadDir(enableDir)
+	if err != nil {
+		return nil
+	}
+	var switches []ECNConfig
+	for _, file := range files {
+		priority, err := strconv.Atoi(file.Name())
+		if err != nil {
+			continue
+		}
+		enabled := p.readTrimmed(filepath.Join(enableDir, file.Name()))
+		if enabled != "0" && enabled != "1" {
+			continue
+		}
+		switches = append(switches, ECNConfig{Point: point, Priority: priority, Enabled: enabled == "1"})
+	}
+	return switches
+}
+
+// readDCQCNParams reads the numeric files of an ECN point directory, the
+// DCQCN parameters mlx5 exposes next to the enable directory.
+func (p *SysfsProvider) readDCQCNParams(pointDir, point string) []DCQCNParam {
+	files, err := os.ReadDir(pointDir)
+	if err != nil {
+		return nil
+	}
+	var params []DCQCNParam
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		value, err := strconv.ParseInt(p.readTrimmed(filepath.Join(pointDir, file.Name())), 10, 64)
+		if err != nil {
+			continue
+		}
+		params = append(params, DCQCNParam{Point: point, Name: file.Name(), Value: value})
+	}
+	return params
+}
+
+// readTrimmed returns the trimmed content of an optional sysfs file, or an
+// empty string when it cannot be read.
+func (p *SysfsProvider) readTrimmed(path string) string {
+	data, err := p.readFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
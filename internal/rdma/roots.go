@@ -0,0 +1,69 @@
+package rdma
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSysfsRootNotAllowed is returned by SetSysfsRoot when the root, after
+// resolving symlinks, lies outside every allowed prefix.
+var ErrSysfsRootNotAllowed = errors.New("sysfs root is not allowed")
+
+// SetAllowedSysfsRoots restricts the roots accepted by SetSysfsRoot to the
+// given directory prefixes. Prefixes are resolved once, so a symlink swapped
+// in later cannot widen the allowlist. An empty list accepts any root.
+func (p *SysfsProvider) SetAllowedSysfsRoots(prefixes []string) error {
+	resolved := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		path, err := resolveRoot(prefix)
+		if err != nil {
+			return fmt.Errorf("resolve allowed sysfs root %q: %w", prefix, err)
+		}
+		resolved = append(resolved, path)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.allowedRoots = resolved
+	return nil
+}
+
+// checkSysfsRoot verifies root against the allowlist. The caller holds p.mu.
+func (p *SysfsProvider) checkSysfsRoot(root string) error {
+	if len(p.allowedRoots) == 0 {
+		return nil
+	}
+
+	// Resolve symlinks so a link inside an allowed prefix cannot point the
+	// exporter at an arbitrary directory.
+	resolved, err := resolveRoot(root)
+	if err != nil {
+		return fmt.Errorf("resolve sysfs root %q: %w", root, err)
+	}
+	for _, prefix := range p.allowedRoots {
+		if withinDir(prefix, resolved) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s resolves to %s, outside %s", ErrSysfsRootNotAllowed, root, resolved, strings.Join(p.allowedRoots, ", "))
+}
+
+func resolveRoot(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// withinDir reports whether path is dir or lies below it. Both must be clean
+// absolute paths.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
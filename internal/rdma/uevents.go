@@ -0,0 +1,154 @@
+package rdma
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"path"
+	"slices"
+	"sync"
+)
+
+// ueventSubsystem is the kobject subsystem of RDMA devices.
+const ueventSubsystem = "infiniband"
+
+// UEvent is a kernel uevent of an RDMA device, such as "add" when a driver
+// registers the device and "remove" when it unregisters it.
+type UEvent struct {
+	Action string
+	Device string
+}
+
+// UEventCount is the number of uevents with an action a device has seen.
+type UEventCount struct {
+	Device string
+	Action string
+	Count  uint64
+}
+
+// ParseUEvent parses a kernel uevent message, "<action>@<devpath>" followed
+// by KEY=VALUE pairs, all NUL-terminated. It reports false for messages that
+// are not about an RDMA device.
+func ParseUEvent(msg []byte) (UEvent, bool) {
+	fields := bytes.Split(msg, []byte{0})
+	if len(fields) == 0 || !bytes.ContainsRune(fields[0], '@') {
+		// Messages re-broadcast by udev start with "libudev".
+		return UEvent{}, false
+	}
+
+	var event UEvent
+	var subsystem, devPath string
+	for _, field := range fields[1:] {
+		key, value, ok := bytes.Cut(field, []byte{'='})
+		if !ok {
+			continue
+		}
+		switch string(key) {
+		case "ACTION":
+			event.Action = string(value)
+		case "SUBSYSTEM":
+			subsystem = string(value)
+		case "DEVPATH":
+			devPath = string(value)
+		}
+	}
+	if subsystem != ueventSubsystem || event.Action == "" || devPath == "" {
+		return UEvent{}, false
+	}
+	event.Device = path.Base(devPath)
+	return event, true
+}
+
+// ueventConn receives kernel uevent messages. receive returns a nil message
+// when no message arrived within a short timeout, so callers can check for
+// cancellation.
+type ueventConn interface {
+	receive() ([]byte, error)
+	Close() error
+}
+
+// UEventWatcher counts the kernel uevents of RDMA devices, such as the remove
+// and add pairs of a driver reload or firmware reset, which otherwise only
+// show up as gaps in the metrics.
+type UEventWatcher struct {
+	conn ueventConn
+
+	mu             sync.Mutex
+	counts         map[UEvent]uint64
+	excludeDevices map[string]bool
+}
+
+// NewUEventWatcher opens a kernel uevent netlink socket. It fails on
+// platforms other than Linux.
+func NewUEventWatcher() (*UEventWatcher, error) {
+	conn, err := dialUEvents()
+	if err != nil {
+		return nil, err
+	}
+	return newUEventWatcher(conn), nil
+}
+
+func newUEventWatcher(conn ueventConn) *UEventWatcher {
+	return &UEventWatcher{
+		conn:   conn,
+		counts: make(map[UEvent]uint64),
+	}
+}
+
+// SetExcludeDevices configures device names whose uevents are not counted.
+func (w *UEventWatcher) SetExcludeDevices(devices []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.excludeDevices = make(map[string]bool, len(devices))
+	for _, dev := range devices {
+		w.excludeDevices[dev] = true
+	}
+}
+
+// Run counts uevents until ctx is done or receiving fails.
+func (w *UEventWatcher) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		msg, err := w.conn.receive()
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			continue
+		}
+		event, ok := ParseUEvent(msg)
+		if !ok {
+			continue
+		}
+		w.mu.Lock()
+		if !w.excludeDevices[event.Device] {
+			w.counts[event]++
+		}
+		w.mu.Unlock()
+	}
+	return ctx.Err()
+}
+
+// UEventCounts returns the number of uevents per device and action seen so
+// far, ordered by device and action.
+func (w *UEventWatcher) UEventCounts() []UEventCount {
+	w.mu.Lock()
+	counts := make([]UEventCount, 0, len(w.counts))
+	for event, count := range w.counts {
+		counts = append(counts, UEventCount{Device: event.Device, Action: event.Action, Count: count})
+	}
+	w.mu.Unlock()
+
+	slices.SortFunc(counts, func(a, b UEventCount) int {
+		if c := cmp.Compare(a.Device, b.Device); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Action, b.Action)
+	})
+	return counts
+}
+
+// Close closes the uevent socket.
+func (w *UEventWatcher) Close() error {
+	return w.conn.Close()
+}
@@ -0,0 +1,58 @@
+//go:build linux && !sysfs_only
+
+package rdma
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ueventKernelGroup is the netlink multicast group of uevents sent by
+	// the kernel, as opposed to the ones re-broadcast by udev.
+	ueventKernelGroup = 1
+	// ueventPollInterval bounds how long receive blocks.
+	ueventPollInterval = time.Second
+	ueventRecvBufSize  = 8 << 10
+)
+
+type ueventSocket struct {
+	fd  int
+	buf []byte
+}
+
+func dialUEvents() (ueventConn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: ueventKernelGroup}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	tv := unix.NsecToTimeval(ueventPollInterval.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &ueventSocket{fd: fd, buf: make([]byte, ueventRecvBufSize)}, nil
+}
+
+func (s *ueventSocket) receive() ([]byte, error) {
+	n, _, err := unix.Recvfrom(s.fd, s.buf, 0)
+	if err != nil {
+		// ENOBUFS means uevents were dropped during a burst; the socket
+		// keeps working.
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) || errors.Is(err, unix.ENOBUFS) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s.buf[:n], nil
+}
+
+func (s *ueventSocket) Close() error {
+	return unix.Close(s.fd)
+}
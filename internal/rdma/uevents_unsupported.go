@@ -0,0 +1,9 @@
+//go:build !linux || sysfs_only
+
+package rdma
+
+import "errors"
+
+func dialUEvents() (ueventConn, error) {
+	return nil, errors.New("kernel uevents are supported on linux only and are left out by the sysfs_only build tag")
+}
@@ -0,0 +1,82 @@
+package rdma
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Vendor names returned by GUIDVendor.
+const (
+	VendorNVIDIA   = "NVIDIA"
+	VendorIntel    = "Intel"
+	VendorBroadcom = "Broadcom"
+)
+
+// guidVendors maps the IEEE OUIs found in the top 24 bits of RDMA node GUIDs
+// to the adapter vendor. Mellanox OUIs are reported as NVIDIA. The list only
+// covers the OUIs of RDMA-capable adapters.
+var guidVendors = map[uint32]string{
+	0x0002c9: VendorNVIDIA,
+	0x00258b: VendorNVIDIA,
+	0x043f72: VendorNVIDIA,
+	0x08c0eb: VendorNVIDIA,
+	0x0c42a1: VendorNVIDIA,
+	0x1070fd: VendorNVIDIA,
+	0x1c34da: VendorNVIDIA,
+	0x248a07: VendorNVIDIA,
+	0x506b4b: VendorNVIDIA,
+	0x58a2e1: VendorNVIDIA,
+	0x5c2573: VendorNVIDIA,
+	0x7cfe90: VendorNVIDIA,
+	0x88e9a4: VendorNVIDIA,
+	0x946dae: VendorNVIDIA,
+	0x98039b: VendorNVIDIA,
+	0x9c63c0: VendorNVIDIA,
+	0xa088c2: VendorNVIDIA,
+	0xb83fd2: VendorNVIDIA,
+	0xb8599f: VendorNVIDIA,
+	0xb8cef6: VendorNVIDIA,
+	0xc470bd: VendorNVIDIA,
+	0xe41d2d: VendorNVIDIA,
+	0xe8ebd3: VendorNVIDIA,
+	0xec0d9a: VendorNVIDIA,
+
+	// 00:11:75 came with QLogic's InfiniBand business and is used by
+	// TrueScale and Omni-Path HFIs.
+	0x001175: VendorIntel,
+	0x001b21: VendorIntel,
+	0x001e67: VendorIntel,
+	0x3cfdfe: VendorIntel,
+	0x40a6b7: VendorIntel,
+	0x6805ca: VendorIntel,
+	0x6cfe54: VendorIntel,
+	0x90e2ba: VendorIntel,
+	0xa0369f: VendorIntel,
+	0xb49691: VendorIntel,
+	0xf8f21e: VendorIntel,
+
+	0x000af7: VendorBroadcom,
+	0x001018: VendorBroadcom,
+	0xbc97e1: VendorBroadcom,
+}
+
+// GUIDVendor returns the vendor whose OUI a GUID such as
+// "0c42:a103:0000:0001" carries, or "" when it is unknown or the GUID cannot
+// be parsed.
+func GUIDVendor(guid string) string {
+	digits := strings.ReplaceAll(guid, ":", "")
+	if len(digits) != 16 {
+		return ""
+	}
+	value, err := strconv.ParseUint(digits, 16, 64)
+	if err != nil {
+		return ""
+	}
+	oui := uint32(value >> 40)
+	if vendor, ok := guidVendors[oui]; ok {
+		return vendor
+	}
+	// RoCE drivers such as bnxt_re derive the node GUID from the MAC
+	// address as a modified EUI-64, which flips the universal/local bit.
+	return guidVendors[oui^0x020000]
+}
@@ -0,0 +1,46 @@
+package rdma
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// vlDirPattern matches the per virtual lane subdirectories of a port's
+// hw_counters directory, e.g. hw_counters/vl0.
+var vlDirPattern = regexp.MustCompile(`^vl(\d+)$`)
+
+// readVLCounters reads the per-VL subdirectories among entries, the listing
+// of a hw_counters directory. It returns nil when there are none.
+func (p *SysfsProvider) readVLCounters(ctx context.Context, hwDir string, entries []os.DirEntry) (map[int]map[string]uint64, error) {
+	var stats map[int]map[string]uint64
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		m := vlDirPattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		vl, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		counters, err := p.readCounterDir(ctx, filepath.Join(hwDir, entry.Name()))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		if stats == nil {
+			stats = make(map[int]map[string]uint64)
+		}
+		stats[vl] = counters
+	}
+	return stats, nil
+}
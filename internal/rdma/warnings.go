@@ -0,0 +1,56 @@
+package rdma
+
+import (
+	"maps"
+	"sync"
+)
+
+// Warning types counted by SysfsProvider.Warnings. Each marks data that was
+// skipped or could not be resolved without failing the read.
+const (
+	// WarningCounterParse counts counter files whose content is not an
+	// unsigned integer.
+	WarningCounterParse = "counter_parse_error"
+	// WarningCounterUnreadable counts counter files the kernel refused to
+	// read (EINVAL, EOPNOTSUPP or a permission error).
+	WarningCounterUnreadable = "counter_unreadable"
+	// WarningUnexpectedPortEntry counts entries under ports/ that are not a
+	// port number.
+	WarningUnexpectedPortEntry = "unexpected_port_entry"
+	// WarningLegacyLayout counts reads of Ethernet ports without the
+	// gid_attrs directory of current kernels, whose netdev is then unknown.
+	// iWARP ports have no RoCE GIDs and are not counted.
+	WarningLegacyLayout = "legacy_layout"
+)
+
+// warningCounts counts warnings by type. The zero value is ready to use.
+type warningCounts struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func (w *warningCounts) add(typ string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.counts == nil {
+		w.counts = make(map[string]uint64)
+	}
+	w.counts[typ]++
+}
+
+func (w *warningCounts) snapshot() map[string]uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return maps.Clone(w.counts)
+}
+
+// Warnings returns the number of warnings per type since the provider was
+// created. Types that never occurred are missing.
+func (p *SysfsProvider) Warnings() map[string]uint64 {
+	return p.warnings.snapshot()
+}
+
+// Warnings returns the warnings of the standard counter reads from sysfs.
+func (p *NetlinkProvider) Warnings() map[string]uint64 {
+	return p.sysfs.Warnings()
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// RawAPIPath serves the raw counter snapshot for non-Prometheus consumers.
+const RawAPIPath = "/api/v1/raw"
+
+// rawSnapshot is the document served by RawAPIPath. Devices maps
+// device → port → counters. Timestamp is when the devices were read and
+// AgeSeconds how long before the request that was.
+type rawSnapshot struct {
+	Timestamp  time.Time                     `json:"timestamp"`
+	AgeSeconds float64                       `json:"age_seconds"`
+	Devices    map[string]map[string]rawPort `json:"devices"`
+}
+
+// rawPort keeps the counters and hw_counters of a port apart, as drivers
+// report some counters under the same name in both directories.
+type rawPort struct {
+	Counters   map[string]uint64 `json:"counters"`
+	HwCounters map[string]uint64 `json:"hw_counters"`
+}
+
+func (s *Server) handleRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	if s.scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.scrapeTimeout)
+		defer cancel()
+	}
+
+	devices, readAt, err := s.collector.DeviceSnapshot(ctx, s.rawAPIMaxAge)
+	if err != nil && !errors.Is(err, rdma.ErrNoDevices) {
+		s.logger.Warn("raw snapshot failed", "err", err)
+		http.Error(w, "raw snapshot failed", http.StatusInternalServerError)
+		return
+	}
+
+	snapshot := rawSnapshot{
+		Timestamp:  readAt.UTC(),
+		AgeSeconds: max(s.now().Sub(readAt), 0).Seconds(),
+		Devices:    make(map[string]map[string]rawPort, len(devices)),
+	}
+	for _, device := range devices {
+		ports := make(map[string]rawPort, len(device.Ports))
+		for _, port := range device.Ports {
+			ports[strconv.Itoa(port.ID)] = rawPort{
+				Counters:   nonNilCounters(port.Stats),
+				HwCounters: nonNilCounters(port.HwStats),
+			}
+		}
+		snapshot.Devices[device.Name] = ports
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(http.StatusOK)
+
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(snapshot); err != nil {
+		s.logger.Error("encode raw snapshot failed", "err", err)
+	}
+	if err := gz.Close(); err != nil {
+		s.logger.Error("flush raw snapshot failed", "err", err)
+	}
+}
+
+// nonNilCounters returns counters, or an empty map when it is nil, so ports
+// without hw_counters encode as {} rather than null.
+func nonNilCounters(counters map[string]uint64) map[string]uint64 {
+	if counters == nil {
+		return map[string]uint64{}
+	}
+	return counters
+}
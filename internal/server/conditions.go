@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// ConditionsAPIPath lists the configured conditions and whether each holds,
+// for pollers that cannot evaluate alerting rules themselves.
+const ConditionsAPIPath = "/api/v1/conditions"
+
+const conditionActiveMetric = "rdma_condition_active"
+
+// Condition is a threshold rule evaluated in-exporter against the series of
+// every scrape. It holds while any series of Metric whose labels match Labels
+// compares to Value with Op.
+type Condition struct {
+	Name        string            `yaml:"name" json:"name"`
+	Description string            `yaml:"description" json:"description,omitempty"`
+	Metric      string            `yaml:"metric" json:"metric"`
+	Labels      map[string]string `yaml:"labels" json:"labels,omitempty"`
+	Op          string            `yaml:"op" json:"op"`
+	Value       float64           `yaml:"value" json:"value"`
+}
+
+type conditionFile struct {
+	Conditions []Condition `yaml:"conditions"`
+}
+
+var conditionOps = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+// LoadConditions reads conditions from a YAML file of the form
+//
+//	conditions:
+//	  - name: <condition>
+//	    description: <text>
+//	    metric: <metric name>
+//	    labels: {<label>: <value>}
+//	    op: <one of > >= < <= == !=>
+//	    value: <threshold>
+//
+// Condition names must be unique; labels and description are optional.
+func LoadConditions(path string) ([]Condition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read conditions %s: %w", path, err)
+	}
+
+	var file conditionFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse conditions %s: %w", path, err)
+	}
+	seen := make(map[string]bool, len(file.Conditions))
+	for i, cond := range file.Conditions {
+		switch {
+		case cond.Name == "":
+			return nil, fmt.Errorf("parse conditions %s: condition %d has no name", path, i+1)
+		case seen[cond.Name]:
+			return nil, fmt.Errorf("parse conditions %s: duplicate condition %q", path, cond.Name)
+		case cond.Metric == "":
+			return nil, fmt.Errorf("parse conditions %s: condition %q has no metric", path, cond.Name)
+		case conditionOps[cond.Op] == nil:
+			return nil, fmt.Errorf("parse conditions %s: condition %q has invalid op %q", path, cond.Name, cond.Op)
+		}
+		seen[cond.Name] = true
+	}
+	return file.Conditions, nil
+}
+
+// conditionMatch is a series that satisfies a condition.
+type conditionMatch struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// conditionResult is a condition evaluated against one scrape.
+type conditionResult struct {
+	Condition
+	Active  bool             `json:"active"`
+	Matches []conditionMatch `json:"matches,omitempty"`
+}
+
+// evaluateConditions evaluates conds against the gathered families. Only
+// counter, gauge and untyped series are compared; a condition whose metric is
+// absent does not hold.
+func evaluateConditions(conds []Condition, mfs []*dto.MetricFamily) []conditionResult {
+	families := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf
+	}
+
+	results := make([]conditionResult, 0, len(conds))
+	for _, cond := range conds {
+		result := conditionResult{Condition: cond}
+		compare := conditionOps[cond.Op]
+		for _, m := range families[cond.Metric].GetMetric() {
+			value, ok := metricValue(m)
+			if !ok || !matchLabels(m, cond.Labels) || !compare(value, cond.Value) {
+				continue
+			}
+			labels := make(map[string]string, len(m.GetLabel()))
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			result.Matches = append(result.Matches, conditionMatch{Labels: labels, Value: value})
+		}
+		result.Active = len(result.Matches) > 0
+		results = append(results, result)
+	}
+	return results
+}
+
+func metricValue(m *dto.Metric) (float64, bool) {
+	switch {
+	case m.Counter != nil:
+		return m.Counter.GetValue(), true
+	case m.Gauge != nil:
+		return m.Gauge.GetValue(), true
+	case m.Untyped != nil:
+		return m.Untyped.GetValue(), true
+	}
+	return 0, false
+}
+
+func matchLabels(m *dto.Metric, want map[string]string) bool {
+	matched := 0
+	for _, pair := range m.GetLabel() {
+		value, ok := want[pair.GetName()]
+		if !ok {
+			continue
+		}
+		if value != pair.GetValue() {
+			return false
+		}
+		matched++
+	}
+	return matched == len(want)
+}
+
+const conditionActiveHelp = "Whether the in-exporter condition holds (1) or not (0) in this scrape."
+
+var conditionActiveDesc = prometheus.NewDesc(conditionActiveMetric, conditionActiveHelp, []string{"condition"}, nil)
+
+// conditionFamily renders results as the rdma_condition_active family, which
+// is added to the scrape it was evaluated against rather than lagging it by
+// one as a registered collector would.
+func conditionFamily(results []conditionResult) (*dto.MetricFamily, error) {
+	mf := &dto.MetricFamily{
+		Name: proto.String(conditionActiveMetric),
+		Help: proto.String(conditionActiveHelp),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	for _, result := range results {
+		value := 0.0
+		if result.Active {
+			value = 1
+		}
+		m := &dto.Metric{}
+		if err := prometheus.MustNewConstMetric(conditionActiveDesc, prometheus.GaugeValue, value, result.Name).Write(m); err != nil {
+			return nil, err
+		}
+		mf.Metric = append(mf.Metric, m)
+	}
+	return mf, nil
+}
+
+// conditionsDocument is the document served by ConditionsAPIPath. Timestamp
+// is when the evaluated exposition was gathered and AgeSeconds how long
+// before the request that was.
+type conditionsDocument struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	AgeSeconds float64           `json:"age_seconds"`
+	Conditions []conditionResult `json:"conditions"`
+}
+
+func (s *Server) handleConditions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Conditions are evaluated against the last scrape, like the raw
+	// counter API, so polling them does not advance the per-scrape trackers.
+	mfs, gatheredAt, ok := s.gatherLast(w, r, s.rawAPIMaxAge)
+	if !ok {
+		return
+	}
+	doc := conditionsDocument{
+		Timestamp:  gatheredAt.UTC(),
+		AgeSeconds: max(s.now().Sub(gatheredAt), 0).Seconds(),
+		Conditions: evaluateConditions(s.conditions, mfs),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		s.logger.Error("encode conditions failed", "err", err)
+	}
+}
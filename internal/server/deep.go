@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+// DeepScanPath triggers a one-off run of the expensive collectors. The result
+// is served with the next scrapes, so runbooks can refresh heavy data on
+// demand rather than paying for it on every scrape.
+const DeepScanPath = "/-/collect/deep"
+
+func (s *Server) handleDeepScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	if s.scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.scrapeTimeout)
+		defer cancel()
+	}
+
+	if err := s.collector.DeepScan(ctx); err != nil {
+		s.logger.Warn("deep scan failed", "err", err)
+		http.Error(w, "deep scan failed", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("deep scan completed", "remote", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/yuuki/rdma_exporter/internal/collector"
+)
+
+// Exposition gathers the registry one gather at a time and keeps the result
+// of the last successful one. Every gather counts as a scrape for the
+// collector's per-scrape trackers (stateful mode, suppression, rate jitter),
+// so consumers other than the metrics handler read the last exposition with
+// Last instead of gathering on their own. Every gather includes the
+// rdma_condition_active family evaluated against it, so all consumers see
+// the condition state.
+type Exposition struct {
+	registry   prometheus.Gatherer
+	collector  *collector.RdmaCollector
+	conditions []Condition
+	logger     *slog.Logger
+	now        func() time.Time
+
+	// sem serializes gathers, so the collector's context is set for one
+	// gather at a time. It is a channel rather than a mutex so waiting for
+	// it respects the caller's context.
+	sem chan struct{}
+
+	mu     sync.Mutex
+	last   []*dto.MetricFamily
+	lastAt time.Time
+}
+
+func newExposition(registry prometheus.Gatherer, col *collector.RdmaCollector, conditions []Condition, logger *slog.Logger) *Exposition {
+	return &Exposition{
+		registry:   registry,
+		collector:  col,
+		conditions: conditions,
+		logger:     logger,
+		now:        time.Now,
+		sem:        make(chan struct{}, 1),
+	}
+}
+
+// Gather collects the registry under ctx, waiting for a running gather to
+// finish first. The families are sorted (see sortMetricFamilies) and shared
+// with later Last calls, so callers must not modify them; the returned slice
+// itself is the caller's. On failure the families gathered so far are
+// returned with the error.
+func (e *Exposition) Gather(ctx context.Context) ([]*dto.MetricFamily, error) {
+	select {
+	case e.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	type gatherResult struct {
+		metrics []*dto.MetricFamily
+		err     error
+	}
+	resultCh := make(chan gatherResult, 1)
+	go func() {
+		defer func() { <-e.sem }()
+		if e.collector != nil {
+			e.collector.SetContext(ctx)
+			defer e.collector.ResetContext()
+		}
+		gatheredAt := e.now()
+		mfs, err := e.registry.Gather()
+		if err == nil && len(e.conditions) > 0 {
+			mf, condErr := conditionFamily(evaluateConditions(e.conditions, mfs))
+			if condErr != nil {
+				e.logger.Error("evaluate conditions failed", "err", condErr)
+			} else {
+				mfs = append(mfs, mf)
+			}
+		}
+		sortMetricFamilies(mfs)
+		if err == nil {
+			e.mu.Lock()
+			e.last, e.lastAt = mfs, gatheredAt
+			e.mu.Unlock()
+		}
+		resultCh <- gatherResult{metrics: mfs, err: err}
+	}()
+
+	select {
+	case result := <-resultCh:
+		return append([]*dto.MetricFamily(nil), result.metrics...), result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Last returns the families of the last successful gather and when it
+// started. When there is none, or it is older than maxAge, it gathers and
+// later calls share that result.
+func (e *Exposition) Last(ctx context.Context, maxAge time.Duration) ([]*dto.MetricFamily, time.Time, error) {
+	e.mu.Lock()
+	last, lastAt := e.last, e.lastAt
+	e.mu.Unlock()
+	if last != nil && e.now().Sub(lastAt) <= maxAge {
+		return append([]*dto.MetricFamily(nil), last...), lastAt, nil
+	}
+
+	gatheredAt := e.now()
+	mfs, err := e.Gather(ctx)
+	return mfs, gatheredAt, err
+}
@@ -0,0 +1,22 @@
+package server
+
+import "net/http"
+
+// InvalidateCachePath drops every cached device, attribute and counter state,
+// so the next scrape reflects a planned change at once.
+const InvalidateCachePath = "/-/invalidate-cache"
+
+func (s *Server) handleInvalidateCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.collector.InvalidateCache()
+	s.logger.Info("caches invalidated", "remote", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
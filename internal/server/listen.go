@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+)
+
+// interfaceAddrs returns the addresses assigned to a network interface. Tests
+// replace it to avoid depending on the host's interfaces.
+var interfaceAddrs = func(name string) ([]net.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return iface.Addrs()
+}
+
+// InterfaceListenAddresses resolves the addresses of iface into host:port
+// listen addresses, taking the port from listenAddress. It refuses to bind
+// when iface is one of fabricInterfaces or shares an address with one of them,
+// so the exporter never becomes reachable from the RDMA fabric by mistake.
+// IPv6 link-local addresses are skipped.
+func InterfaceListenAddresses(iface, listenAddress string, fabricInterfaces []string) ([]string, error) {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("parse listen address %q: %w", listenAddress, err)
+	}
+	if host != "" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+			return nil, fmt.Errorf("listen address %q must not name a host when binding to interface %s", listenAddress, iface)
+		}
+	}
+	if slices.Contains(fabricInterfaces, iface) {
+		return nil, fmt.Errorf("interface %s is attached to an RDMA device", iface)
+	}
+
+	ips, err := interfaceIPs(iface)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("interface %s has no usable addresses", iface)
+	}
+
+	for _, fabric := range fabricInterfaces {
+		fabricIPs, err := interfaceIPs(fabric)
+		if err != nil {
+			// Fabric netdevs in other network namespaces are not visible;
+			// they cannot share addresses with iface either.
+			continue
+		}
+		for _, ip := range ips {
+			if slices.ContainsFunc(fabricIPs, ip.Equal) {
+				return nil, fmt.Errorf("address %s of interface %s is also assigned to fabric interface %s", ip, iface, fabric)
+			}
+		}
+	}
+
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	return addrs, nil
+}
+
+func interfaceIPs(name string) ([]net.IP, error) {
+	addrs, err := interfaceAddrs(name)
+	if err != nil {
+		return nil, fmt.Errorf("resolve interface %s: %w", name, err)
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.IsLinkLocalUnicast() && ipNet.IP.To4() == nil {
+			continue
+		}
+		ips = append(ips, ipNet.IP)
+	}
+	return ips, nil
+}
+
+// listenAndServeAll serves on every address and returns the first error.
+func (s *Server) listenAndServeAll(addrs []string) error {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("listen on %s: %w", addr, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	errCh := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			errCh <- s.httpServer.Serve(ln)
+		}(ln)
+	}
+
+	var firstErr error
+	for range listeners {
+		if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) && firstErr == nil {
+			firstErr = err
+			// Stop the remaining listeners so the caller sees the failure.
+			s.httpServer.Close()
+		}
+	}
+	return firstErr
+}
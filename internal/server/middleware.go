@@ -0,0 +1,120 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedHandler labels requests that matched no route, so scrapers hitting
+// a wrong path show up without creating one series per path.
+const unmatchedHandler = "other"
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func newRequestsCounter(registry prometheus.Registerer) *prometheus.CounterVec {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rdma_exporter_http_requests_total",
+		Help: "Total number of HTTP requests served by the exporter, by route, method and status code.",
+	}, []string{"handler", "method", "code"})
+	registry.MustRegister(requests)
+	return requests
+}
+
+// instrument counts every request by matched route pattern and, when
+// logRequests is set, logs it. It must wrap the ServeMux so r.Pattern is
+// populated by the time the handler returns.
+func instrument(next http.Handler, requests *prometheus.CounterVec, logger *slog.Logger, logRequests bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		handler := r.Pattern
+		if handler == "" {
+			handler = unmatchedHandler
+		}
+		method := r.Method
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+			http.MethodDelete, http.MethodPatch, http.MethodOptions:
+		default:
+			// Arbitrary methods would otherwise create unbounded series.
+			method = "OTHER"
+		}
+		requests.WithLabelValues(handler, method, strconv.Itoa(rec.status)).Inc()
+
+		if logRequests {
+			logger.Info("http request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rec.status,
+				"duration", time.Since(start),
+				"remote", r.RemoteAddr)
+		}
+	})
+}
+
+func newRejectedCounter(registry prometheus.Registerer) prometheus.Counter {
+	rejected := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rdma_exporter_http_rejected_requests_total",
+		Help: "Total number of HTTP requests and gRPC calls rejected because their source address is outside --web.allow-cidr.",
+	})
+	registry.MustRegister(rejected)
+	return rejected
+}
+
+// restrict answers 403 to requests whose source address is in none of
+// allowed. An empty allowed list lets every request through.
+func restrict(next http.Handler, allowed []netip.Prefix, rejected prometheus.Counter, logger *slog.Logger) http.Handler {
+	if len(allowed) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !sourceAllowed(r.RemoteAddr, allowed) {
+			rejected.Inc()
+			logger.Debug("rejected http request from disallowed source", "path", r.URL.Path, "remote", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sourceAllowed reports whether the host of remoteAddr falls into one of
+// allowed. IPv4-mapped IPv6 sources match IPv4 prefixes.
+func sourceAllowed(remoteAddr string, allowed []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const deviceSeriesMaxMetric = "rdma_exporter_scrape_device_series_max"
+
+// seriesPeaks keeps the largest number of series a scrape has served since
+// start, overall and per device, so the ingestion cost of enabling a
+// collector can be read off the exporter rather than estimated.
+type seriesPeaks struct {
+	mu      sync.Mutex
+	total   int
+	devices map[string]int
+
+	totalDesc  *prometheus.Desc
+	deviceDesc *prometheus.Desc
+}
+
+func newSeriesPeaks(registry prometheus.Registerer) *seriesPeaks {
+	p := &seriesPeaks{
+		devices: make(map[string]int),
+		totalDesc: prometheus.NewDesc(
+			"rdma_exporter_scrape_series_max",
+			"Largest number of series served by a single scrape since the exporter started.",
+			nil, nil,
+		),
+		deviceDesc: prometheus.NewDesc(
+			deviceSeriesMaxMetric,
+			"Largest number of series with the device label set to the device served by a single scrape since the exporter started.",
+			[]string{"device"}, nil,
+		),
+	}
+	registry.MustRegister(p)
+	return p
+}
+
+// observe records the series of one scrape. Devices missing from it are
+// forgotten, so removed or renamed devices do not keep their peaks. The
+// per-device peaks themselves are not counted towards a device, which would
+// otherwise keep a removed device present.
+func (p *seriesPeaks) observe(mfs []*dto.MetricFamily) {
+	total := 0
+	devices := make(map[string]int)
+	for _, mf := range mfs {
+		total += len(mf.GetMetric())
+		if mf.GetName() == deviceSeriesMaxMetric {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "device" {
+					devices[label.GetValue()]++
+					break
+				}
+			}
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = max(p.total, total)
+	for device, n := range devices {
+		p.devices[device] = max(p.devices[device], n)
+	}
+	for device := range p.devices {
+		if _, ok := devices[device]; !ok {
+			delete(p.devices, device)
+		}
+	}
+}
+
+func (p *seriesPeaks) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.totalDesc
+	ch <- p.deviceDesc
+}
+
+func (p *seriesPeaks) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(p.totalDesc, prometheus.GaugeValue, float64(p.total))
+	for device, n := range p.devices {
+		ch <- prometheus.MustNewConstMetric(p.deviceDesc, prometheus.GaugeValue, float64(n), device)
+	}
+}
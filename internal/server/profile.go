@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"time"
+)
+
+const (
+	// CollectProfilePath reads the devices repeatedly under the CPU profiler
+	// and returns the pprof profile, so slow device reads can be profiled
+	// without restarting the exporter with profiling flags.
+	CollectProfilePath = "/debug/collect-profile"
+
+	defaultCollectProfileSeconds = 5
+	maxCollectProfileSeconds     = 60
+)
+
+// handleCollectProfile profiles device reads for ?seconds= (5 by default).
+// The profiler samples 100 times a second, so a single read of a few
+// milliseconds would yield an empty profile; reads are repeated back to back
+// instead. They go to the collector's provider, not through the registry, so
+// they neither wait for nor advance the per-scrape trackers.
+func (s *Server) handleCollectProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	seconds := defaultCollectProfileSeconds
+	if raw := r.URL.Query().Get("seconds"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxCollectProfileSeconds {
+			http.Error(w, "invalid seconds: must be an integer between 1 and "+strconv.Itoa(maxCollectProfileSeconds), http.StatusBadRequest)
+			return
+		}
+		seconds = n
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(seconds)*time.Second)
+	defer cancel()
+
+	var profile bytes.Buffer
+	// Only one CPU profile can run per process.
+	if err := pprof.StartCPUProfile(&profile); err != nil {
+		http.Error(w, "a cpu profile is already running", http.StatusConflict)
+		return
+	}
+	start := time.Now()
+	reads, failures := 0, 0
+	var readErr error
+	for ctx.Err() == nil {
+		if _, err := s.collector.Devices(ctx); err != nil && ctx.Err() == nil {
+			failures++
+			readErr = err
+		}
+		reads++
+	}
+	pprof.StopCPUProfile()
+	if r.Context().Err() != nil {
+		return
+	}
+	if readErr != nil {
+		// The profile of failing reads is still worth having.
+		s.logger.Warn("profiled device reads failed", "failures", failures, "err", readErr)
+	}
+	s.logger.Info("device reads profiled", "remote", r.RemoteAddr, "duration", time.Since(start), "reads", reads)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="collect.pprof"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(profile.Bytes())
+}
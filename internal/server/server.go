@@ -1,10 +1,18 @@
 package server
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"errors"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -18,18 +26,66 @@ import (
 // Options contains the configuration required to start the HTTP server.
 type Options struct {
 	ListenAddress string
-	MetricsPath   string
-	HealthPath    string
-	ScrapeTimeout time.Duration
+	// ListenAddresses, when set, replaces ListenAddress with explicit
+	// host:port pairs (see InterfaceListenAddresses).
+	ListenAddresses []string
+	MetricsPath     string
+	HealthPath      string
+	ScrapeTimeout   time.Duration
+	// EnableRawAPI serves the raw counter snapshot under RawAPIPath.
+	EnableRawAPI bool
+	// RawAPIMaxAge is how old the last scrape may be to serve RawAPIPath and
+	// ConditionsAPIPath; older ones are replaced by a fresh read. Zero reads
+	// the devices for every request.
+	RawAPIMaxAge time.Duration
+	// EnableDeepScan serves the on-demand deep scan trigger under DeepScanPath.
+	EnableDeepScan bool
+	// EnableSilenceAPI serves device silences under SilenceAPIPath.
+	EnableSilenceAPI bool
+	// EnableInvalidation serves the cache invalidation trigger under
+	// InvalidateCachePath.
+	EnableInvalidation bool
+	// EnableCollectProfile serves a CPU profile of one collection under
+	// CollectProfilePath.
+	EnableCollectProfile bool
+	// StateFile, when set, is where silences are saved on every change.
+	StateFile string
+	// RequestLogging logs every HTTP request at info level.
+	RequestLogging bool
+	// StartupGracePeriod is how long StartedPath waits for a device before
+	// reporting success with none.
+	StartupGracePeriod time.Duration
+	// AllowedCIDRs, when set, restricts every endpoint but the health and
+	// startup probes to these source ranges.
+	AllowedCIDRs []netip.Prefix
+	// EnableH2C additionally accepts HTTP/2 without TLS (h2c) with prior
+	// knowledge on the listener.
+	EnableH2C bool
+	// Conditions are evaluated on every gather of the exposition, exported
+	// as rdma_condition_active and listed under ConditionsAPIPath.
+	Conditions []Condition
 }
 
 // Server wraps an http.Server with Prometheus-specific handlers.
 type Server struct {
-	httpServer    *http.Server
-	registry      *prometheus.Registry
-	collector     *collector.RdmaCollector
-	logger        *slog.Logger
-	scrapeTimeout time.Duration
+	httpServer      *http.Server
+	listenAddresses []string
+	registry        *prometheus.Registry
+	collector       *collector.RdmaCollector
+	logger          *slog.Logger
+	scrapeTimeout   time.Duration
+	rawAPIMaxAge    time.Duration
+	stateFile       string
+	silenceMu       sync.Mutex
+	peaks           *seriesPeaks
+	conditions      []Condition
+	exposition      *Exposition
+	rejected        prometheus.Counter
+
+	startTime    time.Time
+	startupGrace time.Duration
+	started      atomic.Bool
+	now          func() time.Time
 }
 
 // New constructs a Server using the provided registry and collector.
@@ -39,32 +95,85 @@ func New(opts Options, registry *prometheus.Registry, col *collector.RdmaCollect
 	}
 
 	s := &Server{
-		registry:      registry,
-		collector:     col,
-		logger:        logger,
-		scrapeTimeout: opts.ScrapeTimeout,
+		registry:        registry,
+		collector:       col,
+		logger:          logger,
+		scrapeTimeout:   opts.ScrapeTimeout,
+		rawAPIMaxAge:    opts.RawAPIMaxAge,
+		stateFile:       opts.StateFile,
+		listenAddresses: opts.ListenAddresses,
+		peaks:           newSeriesPeaks(registry),
+		conditions:      opts.Conditions,
+		exposition:      newExposition(registry, col, opts.Conditions, logger),
+		startTime:       time.Now(),
+		startupGrace:    opts.StartupGracePeriod,
+		now:             time.Now,
 	}
 
 	mux := http.NewServeMux()
 
+	// Probes stay reachable from the kubelet and load balancers.
+	if len(opts.AllowedCIDRs) > 0 {
+		s.rejected = newRejectedCounter(registry)
+	}
+	restricted := func(h http.Handler) http.Handler {
+		return restrict(h, opts.AllowedCIDRs, s.rejected, logger)
+	}
+
 	metricsHandler := promhttp.InstrumentMetricHandler(
 		registry,
 		http.HandlerFunc(s.handleMetrics),
 	)
 
-	mux.Handle(opts.MetricsPath, metricsHandler)
+	mux.Handle(opts.MetricsPath, restricted(metricsHandler))
 	mux.HandleFunc(opts.HealthPath, s.handleHealth)
+	if col != nil {
+		mux.HandleFunc(StartedPath, s.handleStarted)
+	}
+	if opts.EnableRawAPI && col != nil {
+		mux.Handle(RawAPIPath, restricted(http.HandlerFunc(s.handleRaw)))
+	}
+	if opts.EnableDeepScan && col != nil {
+		mux.Handle(DeepScanPath, restricted(http.HandlerFunc(s.handleDeepScan)))
+	}
+	if opts.EnableSilenceAPI && col != nil {
+		mux.Handle(SilenceAPIPath, restricted(http.HandlerFunc(s.handleSilence)))
+	}
+	if opts.EnableInvalidation && col != nil {
+		mux.Handle(InvalidateCachePath, restricted(http.HandlerFunc(s.handleInvalidateCache)))
+	}
+	if opts.EnableCollectProfile && col != nil {
+		mux.Handle(CollectProfilePath, restricted(http.HandlerFunc(s.handleCollectProfile)))
+	}
+	if len(opts.Conditions) > 0 {
+		mux.Handle(ConditionsAPIPath, restricted(http.HandlerFunc(s.handleConditions)))
+	}
 
 	s.httpServer = &http.Server{
 		Addr:              opts.ListenAddress,
-		Handler:           mux,
+		Handler:           instrument(mux, newRequestsCounter(registry), logger, opts.RequestLogging),
 		ReadHeaderTimeout: 5 * time.Second,
 	}
+	if opts.EnableH2C {
+		var protocols http.Protocols
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		s.httpServer.Protocols = &protocols
+	}
 	return s
 }
 
+// Exposition returns the gatherer the metrics handler collects through, for
+// consumers that read the last exposition instead of gathering on their own.
+func (s *Server) Exposition() *Exposition {
+	return s.exposition
+}
+
 // ListenAndServe starts the HTTP server.
 func (s *Server) ListenAndServe() error {
+	if len(s.listenAddresses) > 0 {
+		return s.listenAndServeAll(s.listenAddresses)
+	}
 	err := s.httpServer.ListenAndServe()
 	if errors.Is(err, http.ErrServerClosed) {
 		return nil
@@ -72,12 +181,74 @@ func (s *Server) ListenAndServe() error {
 	return err
 }
 
+// RejectedRequests returns the counter of requests refused by the
+// --web.allow-cidr allowlist, or nil when it is not set, so the gRPC API can
+// count its rejections with the HTTP ones.
+func (s *Server) RejectedRequests() prometheus.Counter {
+	return s.rejected
+}
+
 // Shutdown gracefully stops the HTTP server.
 func (s *Server) Shutdown(ctx context.Context) error {
 	return s.httpServer.Shutdown(ctx)
 }
 
+// metricsMethods are the methods the metrics handler answers.
+const metricsMethods = http.MethodGet + ", " + http.MethodHead
+
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	// Accept headers the exposition formats do not cover fall back to the
+	// text format, as promhttp does.
+	contentType := expfmt.Negotiate(r.Header)
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodHead:
+		// Proxies probe with HEAD; answer without collecting.
+		w.Header().Set("Content-Type", string(contentType))
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		w.Header().Set("Allow", metricsMethods)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mfs, ok := s.gather(w, r)
+	if !ok {
+		return
+	}
+
+	s.peaks.observe(mfs)
+
+	// Encode before writing anything, so an encoding error can still be
+	// reported with a status code instead of a truncated exposition.
+	var buf bytes.Buffer
+	encoder := expfmt.NewEncoder(&buf, contentType)
+	for _, mf := range mfs {
+		if err := encoder.Encode(mf); err != nil {
+			s.logger.Error("encode metric family failed", "family", mf.GetName(), "err", err)
+			http.Error(w, "error encoding metrics", http.StatusInternalServerError)
+			return
+		}
+	}
+	if closer, ok := encoder.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			s.logger.Error("encode metrics failed", "err", err)
+			http.Error(w, "error encoding metrics", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", string(contentType))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if _, err := buf.WriteTo(w); err != nil {
+		s.logger.Debug("write metrics failed", "err", err)
+	}
+}
+
+// gather collects the registry within the scrape timeout of r. On failure it
+// writes the error response and reports false.
+func (s *Server) gather(w http.ResponseWriter, r *http.Request) ([]*dto.MetricFamily, bool) {
 	ctx := r.Context()
 	if s.scrapeTimeout > 0 {
 		var cancel context.CancelFunc
@@ -85,47 +256,66 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 		defer cancel()
 	}
 
-	if s.collector != nil {
-		s.collector.SetContext(ctx)
-		defer s.collector.ResetContext()
-	}
+	mfs, err := s.exposition.Gather(ctx)
+	return mfs, s.gatherOK(ctx, w, err)
+}
 
-	type gatherResult struct {
-		metrics []*dto.MetricFamily
-		err     error
+// gatherLast is gather for consumers other than the metrics handler: it
+// serves the last exposition unless it is older than maxAge.
+func (s *Server) gatherLast(w http.ResponseWriter, r *http.Request, maxAge time.Duration) ([]*dto.MetricFamily, time.Time, bool) {
+	ctx := r.Context()
+	if s.scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.scrapeTimeout)
+		defer cancel()
 	}
 
-	resultCh := make(chan gatherResult, 1)
-	go func() {
-		mfs, err := s.registry.Gather()
-		resultCh <- gatherResult{metrics: mfs, err: err}
-	}()
+	mfs, gatheredAt, err := s.exposition.Last(ctx, maxAge)
+	return mfs, gatheredAt, s.gatherOK(ctx, w, err)
+}
 
-	var result gatherResult
-	select {
-	case result = <-resultCh:
-	case <-ctx.Done():
+// gatherOK reports whether a gather under ctx succeeded, answering the
+// request with an error otherwise.
+func (s *Server) gatherOK(ctx context.Context, w http.ResponseWriter, err error) bool {
+	switch {
+	case err != nil && ctx.Err() != nil:
 		s.logger.Warn("metrics gather timed out", "err", ctx.Err())
 		http.Error(w, "scrape timed out", http.StatusGatewayTimeout)
-		return
+		return false
+	case err != nil:
+		s.logger.Error("metrics gather failed", "err", err)
+		http.Error(w, "metrics gather failed", http.StatusInternalServerError)
+		return false
 	}
+	return true
+}
 
-	if result.err != nil {
-		s.logger.Error("metrics gather failed", "err", result.err)
-		http.Error(w, "metrics gather failed", http.StatusInternalServerError)
-		return
+// sortMetricFamilies orders families by name and their series by label
+// pairs, so series keep their position in the exposition from scrape to
+// scrape and raw scrapes can be diffed line by line. prometheus.Registry
+// gathers in this order already; sorting here keeps the guarantee whatever
+// the gatherer.
+func sortMetricFamilies(mfs []*dto.MetricFamily) {
+	slices.SortFunc(mfs, compareFamilyNames)
+	for _, mf := range mfs {
+		slices.SortStableFunc(mf.Metric, compareMetricLabels)
 	}
+}
 
-	contentType := expfmt.Negotiate(r.Header)
-	w.Header().Set("Content-Type", string(contentType))
+func compareFamilyNames(a, b *dto.MetricFamily) int {
+	return strings.Compare(a.GetName(), b.GetName())
+}
 
-	encoder := expfmt.NewEncoder(w, contentType)
-	for _, mf := range result.metrics {
-		if err := encoder.Encode(mf); err != nil {
-			s.logger.Error("encode metric family failed", "err", err)
-			return
+func compareMetricLabels(a, b *dto.Metric) int {
+	for i := range min(len(a.Label), len(b.Label)) {
+		if c := cmp.Or(
+			strings.Compare(a.Label[i].GetName(), b.Label[i].GetName()),
+			strings.Compare(a.Label[i].GetValue(), b.Label[i].GetValue()),
+		); c != 0 {
+			return c
 		}
 	}
+	return cmp.Compare(len(a.Label), len(b.Label))
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
@@ -0,0 +1,1083 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/yuuki/rdma_exporter/internal/collector"
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+	"github.com/yuuki/rdma_exporter/internal/state"
+)
+
+type stubProvider struct {
+	devices []rdma.Device
+	err     error
+}
+
+func (s *stubProvider) Devices(context.Context) ([]rdma.Device, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.devices, nil
+}
+
+func newDiscardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func newTestServer(t *testing.T, opts Options, provider collector.Provider, colOpts ...collector.Option) *Server {
+	t.Helper()
+
+	if opts.MetricsPath == "" {
+		opts.MetricsPath = "/metrics"
+	}
+	if opts.HealthPath == "" {
+		opts.HealthPath = "/healthz"
+	}
+
+	logger := newDiscardLogger()
+	col := collector.New(provider, logger, colOpts...)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(col)
+	return New(opts, registry, col, logger)
+}
+
+func basicDevices() []rdma.Device {
+	return []rdma.Device{
+		{
+			Name: "mlx5_0",
+			Ports: []rdma.Port{
+				{
+					ID:      1,
+					Stats:   map[string]uint64{"port_xmit_data": 10},
+					HwStats: map[string]uint64{"out_of_buffer": 3},
+				},
+			},
+		},
+	}
+}
+
+func TestServer_RawAPI(t *testing.T) {
+	t.Parallel()
+
+	devices := basicDevices()
+	// A hw counter named like a sysfs counter must not overwrite it.
+	devices[0].Ports[0].HwStats["port_xmit_data"] = 99
+	srv := newTestServer(t, Options{EnableRawAPI: true}, &stubProvider{devices: devices})
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RawAPIPath, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("expected gzip content encoding, got %q", got)
+	}
+
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	var snapshot rawSnapshot
+	if err := json.NewDecoder(gz).Decode(&snapshot); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+
+	port := snapshot.Devices["mlx5_0"]["1"]
+	if port.Counters["port_xmit_data"] != 10 {
+		t.Fatalf("expected counter port_xmit_data=10, got %d", port.Counters["port_xmit_data"])
+	}
+	if port.HwCounters["port_xmit_data"] != 99 || port.HwCounters["out_of_buffer"] != 3 {
+		t.Fatalf("expected hw counters port_xmit_data=99 and out_of_buffer=3, got %v", port.HwCounters)
+	}
+}
+
+type countingProvider struct {
+	mu      sync.Mutex
+	devices []rdma.Device
+	reads   int
+}
+
+func (p *countingProvider) Devices(context.Context) ([]rdma.Device, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reads++
+	return p.devices, nil
+}
+
+func (p *countingProvider) set(devices []rdma.Device) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.devices = devices
+}
+
+func (p *countingProvider) readCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reads
+}
+
+func getRawSnapshot(t *testing.T, srv *Server) rawSnapshot {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RawAPIPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	var snapshot rawSnapshot
+	if err := json.NewDecoder(gz).Decode(&snapshot); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	return snapshot
+}
+
+func TestServer_RawAPIServesLastScrape(t *testing.T) {
+	t.Parallel()
+
+	provider := &countingProvider{devices: basicDevices()}
+	srv := newTestServer(t, Options{EnableRawAPI: true, RawAPIMaxAge: time.Hour}, provider)
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 from metrics, got %d", rec.Code)
+	}
+	updated := basicDevices()
+	updated[0].Ports[0].Stats["port_xmit_data"] = 20
+	provider.set(updated)
+
+	for range 2 {
+		snapshot := getRawSnapshot(t, srv)
+		if got := snapshot.Devices["mlx5_0"]["1"].Counters["port_xmit_data"]; got != 10 {
+			t.Fatalf("expected the scraped port_xmit_data=10, got %d", got)
+		}
+		if snapshot.Timestamp.IsZero() || snapshot.AgeSeconds < 0 {
+			t.Fatalf("unexpected snapshot time %s, age %v", snapshot.Timestamp, snapshot.AgeSeconds)
+		}
+	}
+	if got := provider.readCount(); got != 1 {
+		t.Fatalf("expected the raw API to reuse the scrape's read, got %d reads", got)
+	}
+}
+
+func TestServer_RawAPIReadsWhenSnapshotTooOld(t *testing.T) {
+	t.Parallel()
+
+	provider := &countingProvider{devices: basicDevices()}
+	srv := newTestServer(t, Options{EnableRawAPI: true}, provider)
+
+	getRawSnapshot(t, srv)
+	updated := basicDevices()
+	updated[0].Ports[0].Stats["port_xmit_data"] = 20
+	provider.set(updated)
+
+	snapshot := getRawSnapshot(t, srv)
+	if got := snapshot.Devices["mlx5_0"]["1"].Counters["port_xmit_data"]; got != 20 {
+		t.Fatalf("expected a fresh port_xmit_data=20 without a max age, got %d", got)
+	}
+	if got := provider.readCount(); got != 2 {
+		t.Fatalf("expected a read per request, got %d", got)
+	}
+}
+
+func TestExpositionLastReusesScrape(t *testing.T) {
+	t.Parallel()
+
+	provider := &countingProvider{devices: basicDevices()}
+	srv := newTestServer(t, Options{}, provider)
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 from metrics, got %d", rec.Code)
+	}
+
+	mfs, _, err := srv.Exposition().Last(context.Background(), time.Hour)
+	if err != nil {
+		t.Fatalf("Last returned error: %v", err)
+	}
+	if len(mfs) == 0 {
+		t.Fatalf("expected the families of the scrape")
+	}
+	if got := provider.readCount(); got != 1 {
+		t.Fatalf("expected Last to reuse the scrape, got %d reads", got)
+	}
+
+	// A zero max age gathers anew.
+	if _, _, err := srv.Exposition().Last(context.Background(), 0); err != nil {
+		t.Fatalf("Last returned error: %v", err)
+	}
+	if got := provider.readCount(); got != 2 {
+		t.Fatalf("expected a stale exposition to be gathered again, got %d reads", got)
+	}
+}
+
+func TestExpositionGatherRespectsContext(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, Options{}, &countingProvider{devices: basicDevices()})
+	exposition := srv.Exposition()
+
+	// Hold the gather slot as a running gather would.
+	exposition.sem <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := exposition.Gather(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait for a running gather to time out, got %v", err)
+	}
+	<-exposition.sem
+
+	if _, err := exposition.Gather(context.Background()); err != nil {
+		t.Fatalf("Gather returned error: %v", err)
+	}
+}
+
+func TestServer_RawAPIDisabledByDefault(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, Options{}, &stubProvider{devices: basicDevices()})
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RawAPIPath, nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rec.Code)
+	}
+}
+
+type stubDeepScanProvider struct {
+	calls int
+}
+
+func (s *stubDeepScanProvider) AER(context.Context) ([]rdma.DeviceAER, error) {
+	s.calls++
+	return nil, nil
+}
+
+func TestServer_DeepScan(t *testing.T) {
+	t.Parallel()
+
+	deep := &stubDeepScanProvider{}
+	srv := newTestServer(t, Options{EnableDeepScan: true}, &stubProvider{devices: basicDevices()},
+		collector.WithDeepScan(deep))
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DeepScanPath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405 for GET, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, DeepScanPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if deep.calls != 1 {
+		t.Fatalf("expected one deep scan, got %d", deep.calls)
+	}
+}
+
+func TestServer_DeepScanDisabledByDefault(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, Options{}, &stubProvider{devices: basicDevices()})
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, DeepScanPath, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rec.Code)
+	}
+}
+
+type invalidatingProvider struct {
+	stubProvider
+	invalidations int
+}
+
+func (p *invalidatingProvider) InvalidateCache() {
+	p.invalidations++
+}
+
+func TestServer_InvalidateCache(t *testing.T) {
+	t.Parallel()
+
+	provider := &invalidatingProvider{stubProvider: stubProvider{devices: basicDevices()}}
+	srv := newTestServer(t, Options{EnableInvalidation: true}, provider)
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, InvalidateCachePath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405 for GET, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, InvalidateCachePath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if provider.invalidations != 1 {
+		t.Fatalf("expected one invalidation, got %d", provider.invalidations)
+	}
+
+	disabled := newTestServer(t, Options{}, provider)
+	rec = httptest.NewRecorder()
+	disabled.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, InvalidateCachePath, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 when disabled, got %d", rec.Code)
+	}
+}
+
+func TestServer_CollectProfile(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, Options{EnableCollectProfile: true}, &stubProvider{devices: basicDevices()})
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, CollectProfilePath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405 for POST, got %d", rec.Code)
+	}
+
+	for _, seconds := range []string{"0", "61", "soon"} {
+		rec = httptest.NewRecorder()
+		srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, CollectProfilePath+"?seconds="+seconds, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for seconds=%s, got %d", seconds, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, CollectProfilePath+"?seconds=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// pprof profiles are gzip-compressed protocol buffers.
+	if _, err := gzip.NewReader(rec.Body); err != nil {
+		t.Fatalf("expected a gzip-compressed profile: %v", err)
+	}
+
+	disabled := newTestServer(t, Options{}, &stubProvider{devices: basicDevices()})
+	rec = httptest.NewRecorder()
+	disabled.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, CollectProfilePath, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 when disabled, got %d", rec.Code)
+	}
+}
+
+func TestServer_Silence(t *testing.T) {
+	t.Parallel()
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	srv := newTestServer(t, Options{EnableSilenceAPI: true, StateFile: stateFile}, &stubProvider{devices: basicDevices()})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, SilenceAPIPath, strings.NewReader(body)))
+		return rec
+	}
+
+	for _, body := range []string{`{"device":"mlx5_0"}`, `{"duration":"1h"}`, `{"device":"mlx5_0","duration":"-1h"}`, `{"device":"mlx5_0","duration":"1h","extra":1}`} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", body, rec.Code)
+		}
+	}
+
+	rec := post(`{"device":"mlx5_0","duration":"2h"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var list silenceList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(list.Silences) != 1 || list.Silences[0].Device != "mlx5_0" {
+		t.Fatalf("unexpected silences %+v", list.Silences)
+	}
+
+	saved, err := state.Load(stateFile)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if len(saved.Silences) != 1 || saved.Silences[0].Device != "mlx5_0" {
+		t.Fatalf("unexpected persisted silences %+v", saved.Silences)
+	}
+
+	if count, err := testutil.GatherAndCount(srv.registry, "rdma_port_info"); err != nil || count != 0 {
+		t.Fatalf("expected the silenced device to be excluded, got %d port_info series (err=%v)", count, err)
+	}
+
+	if rec := post(`{"device":"mlx5_0","duration":"0s"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 when lifting, got %d", rec.Code)
+	}
+	if silences := srv.collector.Silences(); len(silences) != 0 {
+		t.Fatalf("expected no silences after lifting, got %+v", silences)
+	}
+}
+
+func TestServer_ConcurrentSilencesArePersisted(t *testing.T) {
+	t.Parallel()
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	srv := newTestServer(t, Options{EnableSilenceAPI: true, StateFile: stateFile}, &stubProvider{devices: basicDevices()})
+
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"device":"mlx5_%d","duration":"1h"}`, i)
+			rec := httptest.NewRecorder()
+			srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, SilenceAPIPath, strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Errorf("expected status 200 for %s, got %d", body, rec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	saved, err := state.Load(stateFile)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if len(saved.Silences) != 16 {
+		t.Fatalf("expected all 16 silences to be persisted, got %d", len(saved.Silences))
+	}
+}
+
+func TestServer_SilenceDisabledByDefault(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, Options{}, &stubProvider{devices: basicDevices()})
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, SilenceAPIPath, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rec.Code)
+	}
+}
+
+func TestServer_Started(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		provider *stubProvider
+		elapsed  time.Duration
+		want     int
+	}{
+		{"devices found", &stubProvider{devices: basicDevices()}, 0, http.StatusOK},
+		{"no devices within grace", &stubProvider{}, time.Second, http.StatusServiceUnavailable},
+		{"no devices after grace", &stubProvider{}, time.Minute, http.StatusOK},
+		{"enumeration failed", &stubProvider{err: errors.New("boom")}, time.Minute, http.StatusServiceUnavailable},
+		{"no rdma subsystem within grace", &stubProvider{err: rdma.ErrNoDevices}, time.Second, http.StatusServiceUnavailable},
+		{"no rdma subsystem after grace", &stubProvider{err: rdma.ErrNoDevices}, time.Minute, http.StatusOK},
+		{"sysfs not readable", &stubProvider{err: fmt.Errorf("%w: boom", rdma.ErrPermission)}, time.Minute, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := newTestServer(t, Options{StartupGracePeriod: 30 * time.Second}, tt.provider)
+			srv.now = func() time.Time { return srv.startTime.Add(tt.elapsed) }
+
+			rec := httptest.NewRecorder()
+			srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, StartedPath, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestServer_StartedStaysStarted(t *testing.T) {
+	t.Parallel()
+
+	provider := &stubProvider{devices: basicDevices()}
+	srv := newTestServer(t, Options{}, provider)
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, StartedPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	// A later failure is a liveness concern, not a startup one.
+	provider.err = errors.New("boom")
+	rec = httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, StartedPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 after startup, got %d", rec.Code)
+	}
+}
+
+func TestInterfaceListenAddresses(t *testing.T) {
+	ifaces := map[string][]string{
+		"mgmt0":     {"10.0.0.5/24", "fe80::1/64", "2001:db8::5/64"},
+		"ens1f0np0": {"192.168.100.5/24"},
+		"shared0":   {"192.168.100.5/24"},
+		"empty0":    {"fe80::2/64"},
+	}
+	orig := interfaceAddrs
+	t.Cleanup(func() { interfaceAddrs = orig })
+	interfaceAddrs = func(name string) ([]net.Addr, error) {
+		cidrs, ok := ifaces[name]
+		if !ok {
+			return nil, errors.New("no such interface")
+		}
+		addrs := make([]net.Addr, 0, len(cidrs))
+		for _, cidr := range cidrs {
+			ip, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				t.Fatalf("parse %s: %v", cidr, err)
+			}
+			ipNet.IP = ip
+			addrs = append(addrs, ipNet)
+		}
+		return addrs, nil
+	}
+
+	fabric := []string{"ens1f0np0"}
+	tests := []struct {
+		name    string
+		iface   string
+		listen  string
+		want    []string
+		wantErr bool
+	}{
+		{"binds interface addresses", "mgmt0", ":9879", []string{"10.0.0.5:9879", "[2001:db8::5]:9879"}, false},
+		{"unspecified host allowed", "mgmt0", "0.0.0.0:9879", []string{"10.0.0.5:9879", "[2001:db8::5]:9879"}, false},
+		{"explicit host rejected", "mgmt0", "10.0.0.5:9879", nil, true},
+		{"fabric interface rejected", "ens1f0np0", ":9879", nil, true},
+		{"fabric address rejected", "shared0", ":9879", nil, true},
+		{"only link-local rejected", "empty0", ":9879", nil, true},
+		{"unknown interface", "missing0", ":9879", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := InterfaceListenAddresses(tt.iface, tt.listen, fabric)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestServer_CountsRequests(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, Options{}, &stubProvider{devices: basicDevices()})
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/healthz", nil),
+		httptest.NewRequest(http.MethodGet, "/healthz", nil),
+		httptest.NewRequest(http.MethodGet, "/wrong-path", nil),
+		httptest.NewRequest("BREW", "/healthz", nil),
+	} {
+		srv.httpServer.Handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expected := `
+# HELP rdma_exporter_http_requests_total Total number of HTTP requests served by the exporter, by route, method and status code.
+# TYPE rdma_exporter_http_requests_total counter
+rdma_exporter_http_requests_total{code="200",handler="/healthz",method="GET"} 2
+rdma_exporter_http_requests_total{code="200",handler="/healthz",method="OTHER"} 1
+rdma_exporter_http_requests_total{code="404",handler="other",method="GET"} 1
+`
+	if err := testutil.GatherAndCompare(srv.registry, strings.NewReader(expected), "rdma_exporter_http_requests_total"); err != nil {
+		t.Fatalf("unexpected request metrics: %v", err)
+	}
+}
+
+func TestServer_RequestLogging(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	registry := prometheus.NewRegistry()
+	srv := New(Options{MetricsPath: "/metrics", HealthPath: "/healthz", RequestLogging: true}, registry, nil, logger)
+
+	srv.httpServer.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	out := buf.String()
+	for _, want := range []string{"msg=\"http request\"", "method=GET", "path=/healthz", "status=200", "remote="} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in log output %q", want, out)
+		}
+	}
+}
+
+func TestServer_AllowCIDR(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, Options{
+		EnableRawAPI: true,
+		AllowedCIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")},
+	}, &stubProvider{devices: basicDevices()})
+
+	tests := []struct {
+		path   string
+		remote string
+		want   int
+	}{
+		{path: "/metrics", remote: "10.1.2.3:40000", want: http.StatusOK},
+		{path: "/metrics", remote: "[::ffff:10.1.2.3]:40000", want: http.StatusOK},
+		{path: "/metrics", remote: "[2001:db8::1]:40000", want: http.StatusOK},
+		{path: "/metrics", remote: "192.0.2.1:40000", want: http.StatusForbidden},
+		{path: RawAPIPath, remote: "192.0.2.1:40000", want: http.StatusForbidden},
+		{path: RawAPIPath, remote: "10.1.2.3:40000", want: http.StatusOK},
+		// Probes are never restricted.
+		{path: "/healthz", remote: "192.0.2.1:40000", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.RemoteAddr = tt.remote
+		rec := httptest.NewRecorder()
+		srv.httpServer.Handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Fatalf("GET %s from %s: expected %d, got %d", tt.path, tt.remote, tt.want, rec.Code)
+		}
+	}
+
+	expected := `
+# HELP rdma_exporter_http_rejected_requests_total Total number of HTTP requests and gRPC calls rejected because their source address is outside --web.allow-cidr.
+# TYPE rdma_exporter_http_rejected_requests_total counter
+rdma_exporter_http_rejected_requests_total 2
+`
+	if err := testutil.GatherAndCompare(srv.registry, strings.NewReader(expected), "rdma_exporter_http_rejected_requests_total"); err != nil {
+		t.Fatalf("unexpected rejected requests: %v", err)
+	}
+}
+
+func TestServer_H2C(t *testing.T) {
+	t.Parallel()
+
+	var h1, h2c http.Protocols
+	h1.SetHTTP1(true)
+	h2c.SetUnencryptedHTTP2(true)
+
+	tests := []struct {
+		name      string
+		enable    bool
+		protocols *http.Protocols
+		wantProto int
+		wantErr   bool
+	}{
+		{name: "http/1.1 with h2c enabled", enable: true, protocols: &h1, wantProto: 1},
+		{name: "h2c with h2c enabled", enable: true, protocols: &h2c, wantProto: 2},
+		{name: "http/1.1 by default", protocols: &h1, wantProto: 1},
+		{name: "h2c by default", protocols: &h2c, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := newTestServer(t, Options{EnableH2C: tt.enable}, &stubProvider{devices: basicDevices()})
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+			go srv.httpServer.Serve(ln)
+			t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+			client := &http.Client{
+				Transport: &http.Transport{Protocols: tt.protocols},
+				Timeout:   5 * time.Second,
+			}
+			resp, err := client.Get("http://" + ln.Addr().String() + "/metrics")
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("expected h2c request to fail, got %s", resp.Proto)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GET /metrics: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK || resp.ProtoMajor != tt.wantProto {
+				t.Fatalf("expected 200 over HTTP/%d, got %d over %s", tt.wantProto, resp.StatusCode, resp.Proto)
+			}
+			if !strings.Contains(string(body), "rdma_port_xmit_data_total") {
+				t.Fatalf("expected port counters in the response")
+			}
+		})
+	}
+}
+
+func TestSeriesPeaks(t *testing.T) {
+	t.Parallel()
+
+	family := func(name string, devices ...string) *dto.MetricFamily {
+		mf := &dto.MetricFamily{Name: &name}
+		for i := range devices {
+			m := &dto.Metric{}
+			if devices[i] != "" {
+				labelName := "device"
+				m.Label = []*dto.LabelPair{{Name: &labelName, Value: &devices[i]}}
+			}
+			mf.Metric = append(mf.Metric, m)
+		}
+		return mf
+	}
+
+	registry := prometheus.NewRegistry()
+	peaks := newSeriesPeaks(registry)
+	peaks.observe([]*dto.MetricFamily{
+		family("rdma_port_xmit_data_total", "mlx5_0", "mlx5_0", "mlx5_1"),
+		family("rdma_devices", ""),
+	})
+	// A smaller scrape leaves the peaks alone; a new device adds its own
+	// and a removed one is forgotten, even though its peak is still part
+	// of the scrape.
+	peaks.observe([]*dto.MetricFamily{
+		family("rdma_exporter_scrape_device_series_max", "mlx5_0", "mlx5_1"),
+		family("rdma_port_xmit_data_total", "mlx5_1", "mlx5_1", "mlx5_2"),
+	})
+
+	expected := `
+# HELP rdma_exporter_scrape_device_series_max Largest number of series with the device label set to the device served by a single scrape since the exporter started.
+# TYPE rdma_exporter_scrape_device_series_max gauge
+rdma_exporter_scrape_device_series_max{device="mlx5_1"} 2
+rdma_exporter_scrape_device_series_max{device="mlx5_2"} 1
+# HELP rdma_exporter_scrape_series_max Largest number of series served by a single scrape since the exporter started.
+# TYPE rdma_exporter_scrape_series_max gauge
+rdma_exporter_scrape_series_max 5
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestSortMetricFamilies(t *testing.T) {
+	t.Parallel()
+
+	metric := func(labels ...string) *dto.Metric {
+		m := &dto.Metric{}
+		for i := 0; i < len(labels); i += 2 {
+			m.Label = append(m.Label, &dto.LabelPair{Name: &labels[i], Value: &labels[i+1]})
+		}
+		return m
+	}
+	family := func(name string, metrics ...*dto.Metric) *dto.MetricFamily {
+		return &dto.MetricFamily{Name: &name, Metric: metrics}
+	}
+	mfs := []*dto.MetricFamily{
+		family("rdma_port_xmit_data_total",
+			metric("device", "mlx5_1", "port", "1"),
+			metric("device", "mlx5_0", "port", "2"),
+			metric("device", "mlx5_0", "port", "1"),
+		),
+		family("rdma_devices", metric()),
+	}
+
+	sortMetricFamilies(mfs)
+
+	var got []string
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			series := mf.GetName()
+			for _, label := range m.Label {
+				series += " " + label.GetName() + "=" + label.GetValue()
+			}
+			got = append(got, series)
+		}
+	}
+	want := []string{
+		"rdma_devices",
+		"rdma_port_xmit_data_total device=mlx5_0 port=1",
+		"rdma_port_xmit_data_total device=mlx5_0 port=2",
+		"rdma_port_xmit_data_total device=mlx5_1 port=1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected order:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestServer_MetricsOrderIsStable(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, Options{}, &stubProvider{devices: basicDevices()})
+
+	// series returns the series of a scrape without their values.
+	series := func() []string {
+		rec := httptest.NewRecorder()
+		srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", rec.Code)
+		}
+		var lines []string
+		for line := range strings.Lines(rec.Body.String()) {
+			if i := strings.LastIndexByte(line, ' '); i >= 0 && !strings.HasPrefix(line, "#") {
+				line = line[:i]
+			}
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	// The first scrape creates series such as the request counters.
+	series()
+	first := series()
+	for range 3 {
+		if next := series(); !reflect.DeepEqual(first, next) {
+			t.Fatalf("series order changed between scrapes:\n%v\nthen:\n%v", first, next)
+		}
+	}
+}
+
+func TestServer_MetricsMethodsAndNegotiation(t *testing.T) {
+	t.Parallel()
+
+	provider := &countingProvider{devices: basicDevices()}
+	srv := newTestServer(t, Options{}, provider)
+
+	tests := []struct {
+		name            string
+		method          string
+		accept          string
+		wantCode        int
+		wantContentType string
+		wantBody        bool
+	}{
+		{name: "head", method: http.MethodHead, wantCode: http.StatusOK, wantContentType: "text/plain; version=0.0.4"},
+		{name: "post", method: http.MethodPost, wantCode: http.StatusMethodNotAllowed},
+		{name: "delete", method: http.MethodDelete, wantCode: http.StatusMethodNotAllowed},
+		{name: "no accept", method: http.MethodGet, wantCode: http.StatusOK, wantContentType: "text/plain; version=0.0.4", wantBody: true},
+		{name: "unsupported accept", method: http.MethodGet, accept: "application/json;q=1, */*;garbage", wantCode: http.StatusOK, wantContentType: "text/plain; version=0.0.4", wantBody: true},
+		{
+			name:            "protobuf",
+			method:          http.MethodGet,
+			accept:          "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited",
+			wantBody:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/metrics", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		before := provider.readCount()
+		rec := httptest.NewRecorder()
+		srv.httpServer.Handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantCode {
+			t.Fatalf("%s: expected status %d, got %d", tt.name, tt.wantCode, rec.Code)
+		}
+		if tt.wantCode == http.StatusMethodNotAllowed {
+			if got := rec.Header().Get("Allow"); got != "GET, HEAD" {
+				t.Fatalf("%s: expected Allow: GET, HEAD, got %q", tt.name, got)
+			}
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.wantContentType) {
+			t.Fatalf("%s: expected content type %q, got %q", tt.name, tt.wantContentType, got)
+		}
+		if collected := provider.readCount() > before; collected != tt.wantBody || (rec.Body.Len() > 0) != tt.wantBody {
+			t.Fatalf("%s: expected a collection and body: %v, got collection %v and %d bytes", tt.name, tt.wantBody, collected, rec.Body.Len())
+		}
+	}
+}
+
+func TestLoadConditions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+		want    []Condition
+		wantErr string
+	}{
+		{
+			name: "valid",
+			content: `conditions:
+  - name: out_of_buffer
+    description: Receive WQEs ran out
+    metric: rdma_out_of_buffer_total
+    labels: {device: mlx5_0}
+    op: ">"
+    value: 0
+`,
+			want: []Condition{{
+				Name:        "out_of_buffer",
+				Description: "Receive WQEs ran out",
+				Metric:      "rdma_out_of_buffer_total",
+				Labels:      map[string]string{"device": "mlx5_0"},
+				Op:          ">",
+			}},
+		},
+		{name: "empty", content: ""},
+		{name: "unknown field", content: "conditions:\n  - name: a\n    metric: m\n    op: '>'\n    threshold: 1\n", wantErr: "field threshold not found"},
+		{name: "no name", content: "conditions:\n  - metric: m\n    op: '>'\n", wantErr: "condition 1 has no name"},
+		{name: "no metric", content: "conditions:\n  - name: a\n    op: '>'\n", wantErr: `condition "a" has no metric`},
+		{name: "invalid op", content: "conditions:\n  - name: a\n    metric: m\n    op: '=>'\n", wantErr: `condition "a" has invalid op "=>"`},
+		{name: "duplicate", content: "conditions:\n  - {name: a, metric: m, op: '>'}\n  - {name: a, metric: n, op: '<'}\n", wantErr: `duplicate condition "a"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "conditions.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatalf("write conditions: %v", err)
+			}
+			got, err := LoadConditions(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConditions returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected conditions:\n%+v\nwant:\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Conditions(t *testing.T) {
+	t.Parallel()
+
+	conditions := []Condition{
+		{Name: "out_of_buffer", Metric: "rdma_out_of_buffer_total", Op: ">", Value: 0},
+		{Name: "other_device", Metric: "rdma_out_of_buffer_total", Labels: map[string]string{"device": "mlx5_1"}, Op: ">", Value: 0},
+		{Name: "missing", Metric: "rdma_no_such_metric", Op: ">=", Value: 0},
+	}
+	provider := &stubProvider{devices: basicDevices()}
+	srv := newTestServer(t, Options{Conditions: conditions, RawAPIMaxAge: time.Hour}, provider)
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	for _, want := range []string{
+		`rdma_condition_active{condition="missing"} 0`,
+		`rdma_condition_active{condition="other_device"} 0`,
+		`rdma_condition_active{condition="out_of_buffer"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want+"\n") {
+			t.Fatalf("expected %q in exposition:\n%s", want, rec.Body.String())
+		}
+	}
+
+	// The conditions are evaluated against the scrape above rather than a
+	// read of their own, which would fail now.
+	provider.err = errors.New("sysfs unreadable")
+	rec = httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ConditionsAPIPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	var doc conditionsDocument
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("decode conditions: %v", err)
+	}
+	if len(doc.Conditions) != 3 {
+		t.Fatalf("expected 3 conditions, got %+v", doc.Conditions)
+	}
+	active := doc.Conditions[0]
+	if !active.Active || len(active.Matches) != 1 {
+		t.Fatalf("expected out_of_buffer to hold with one match, got %+v", active)
+	}
+	if match := active.Matches[0]; match.Value != 3 || match.Labels["device"] != "mlx5_0" {
+		t.Fatalf("unexpected match %+v", match)
+	}
+	for _, result := range doc.Conditions[1:] {
+		if result.Active || len(result.Matches) != 0 {
+			t.Fatalf("expected %s not to hold, got %+v", result.Name, result)
+		}
+	}
+}
+
+func TestExpositionIncludesConditions(t *testing.T) {
+	t.Parallel()
+
+	conditions := []Condition{
+		{Name: "out_of_buffer", Metric: "rdma_out_of_buffer_total", Op: ">", Value: 0},
+	}
+	srv := newTestServer(t, Options{Conditions: conditions}, &stubProvider{devices: basicDevices()})
+
+	// Consumers of the shared exposition, such as the Influx output, see
+	// the condition state without going through the metrics handler.
+	mfs, _, err := srv.Exposition().Last(context.Background(), time.Hour)
+	if err != nil {
+		t.Fatalf("Last returned error: %v", err)
+	}
+	var family *dto.MetricFamily
+	for _, mf := range mfs {
+		if mf.GetName() == conditionActiveMetric {
+			family = mf
+		}
+	}
+	if family == nil {
+		t.Fatalf("expected %s in the exposition", conditionActiveMetric)
+	}
+	if metrics := family.GetMetric(); len(metrics) != 1 || metrics[0].GetGauge().GetValue() != 1 {
+		t.Fatalf("expected out_of_buffer to be active, got %v", metrics)
+	}
+}
+
+func TestServer_ConditionsDisabledByDefault(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, Options{}, &stubProvider{devices: basicDevices()})
+
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ConditionsAPIPath, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(rec.Body.String(), "rdma_condition_active") {
+		t.Fatalf("expected no condition series without conditions")
+	}
+}
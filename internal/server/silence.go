@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yuuki/rdma_exporter/internal/collector"
+	"github.com/yuuki/rdma_exporter/internal/state"
+)
+
+// SilenceAPIPath lists silences (GET) and silences a device (POST), excluding
+// it from collection during planned maintenance such as firmware flashes.
+const SilenceAPIPath = "/api/v1/silence"
+
+// silenceRequest is the POST body of SilenceAPIPath. A zero duration lifts
+// the device's silence.
+type silenceRequest struct {
+	Device   string `json:"device"`
+	Duration string `json:"duration"`
+}
+
+type silenceList struct {
+	Silences []collector.Silence `json:"silences"`
+}
+
+func (s *Server) handleSilence(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeSilences(w, http.StatusOK)
+	case http.MethodPost:
+		s.postSilence(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) postSilence(w http.ResponseWriter, r *http.Request) {
+	var req silenceRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 4<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid silence request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Device = strings.TrimSpace(req.Device)
+	if req.Device == "" {
+		http.Error(w, "invalid silence request: device is required", http.StatusBadRequest)
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration < 0 {
+		http.Error(w, "invalid silence request: duration must be a non-negative Go duration such as 2h", http.StatusBadRequest)
+		return
+	}
+
+	var until time.Time
+	if duration > 0 {
+		until = s.now().Add(duration)
+	}
+
+	// Changing the silences and saving them is one step, so concurrent
+	// requests cannot save their snapshots out of order and the file always
+	// ends up with the latest silences.
+	s.silenceMu.Lock()
+	defer s.silenceMu.Unlock()
+	s.collector.SetSilence(req.Device, until)
+	if duration > 0 {
+		s.logger.Info("device silenced", "device", req.Device, "until", until, "remote", r.RemoteAddr)
+	} else {
+		s.logger.Info("device silence lifted", "device", req.Device, "remote", r.RemoteAddr)
+	}
+
+	if s.stateFile != "" {
+		if err := state.Save(s.stateFile, state.State{Silences: s.collector.Silences()}); err != nil {
+			s.logger.Error("failed to persist silences", "err", err)
+			http.Error(w, "silence applied but not persisted", http.StatusInternalServerError)
+			return
+		}
+	}
+	s.writeSilences(w, http.StatusOK)
+}
+
+func (s *Server) writeSilences(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(silenceList{Silences: s.collector.Silences()}); err != nil {
+		s.logger.Error("encode silences failed", "err", err)
+	}
+}
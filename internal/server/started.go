@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/yuuki/rdma_exporter/internal/rdma"
+)
+
+// StartedPath answers Kubernetes startup probes. It returns 200 once device
+// enumeration has found a device, or once it succeeds with no devices after
+// the startup grace period, so slow driver initialization at boot does not
+// get the pod killed before the devices appear.
+const StartedPath = "/-/started"
+
+func (s *Server) handleStarted(w http.ResponseWriter, r *http.Request) {
+	if !s.started.Load() {
+		ctx := r.Context()
+		if s.scrapeTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.scrapeTimeout)
+			defer cancel()
+		}
+
+		devices, err := s.collector.Devices(ctx)
+		switch {
+		case errors.Is(err, rdma.ErrNoDevices):
+			// The RDMA driver may still be loading.
+		case errors.Is(err, rdma.ErrPermission):
+			// Waiting does not help; say so instead of failing silently.
+			s.logger.Warn("startup probe cannot read rdma sysfs", "err", err)
+			http.Error(w, "rdma sysfs not readable", http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			s.logger.Debug("startup probe enumeration failed", "err", err)
+			http.Error(w, "device enumeration failed", http.StatusServiceUnavailable)
+			return
+		}
+		if len(devices) == 0 && s.now().Sub(s.startTime) < s.startupGrace {
+			http.Error(w, "no devices yet", http.StatusServiceUnavailable)
+			return
+		}
+		if s.started.CompareAndSwap(false, true) {
+			s.logger.Info("startup complete", "devices", len(devices), "after", s.now().Sub(s.startTime).Round(time.Millisecond).String())
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
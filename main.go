@@ -5,10 +5,12 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,9 +18,14 @@ import (
 
 	"github.com/yuuki/rdma_exporter/internal/collector"
 	"github.com/yuuki/rdma_exporter/internal/config"
+	"github.com/yuuki/rdma_exporter/internal/influx"
+	"github.com/yuuki/rdma_exporter/internal/kube"
 	"github.com/yuuki/rdma_exporter/internal/netdev"
+	"github.com/yuuki/rdma_exporter/internal/plugin"
+	"github.com/yuuki/rdma_exporter/internal/process"
 	"github.com/yuuki/rdma_exporter/internal/rdma"
 	"github.com/yuuki/rdma_exporter/internal/server"
+	"github.com/yuuki/rdma_exporter/internal/state"
 )
 
 var (
@@ -27,6 +34,10 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == supportBundleCommand {
+		os.Exit(runSupportBundle(os.Args[2:]))
+	}
+
 	cfg, err := config.Parse(os.Args[1:])
 	if err != nil {
 		if errors.Is(err, flag.ErrHelp) {
@@ -37,64 +48,251 @@ func main() {
 	}
 
 	if cfg.ShowVersion {
-		fmt.Printf("rdma_exporter v%s\ncommit: %s\nbuilt with: %s\n", version, commit, runtime.Version())
+		fmt.Printf("rdma_exporter v%s\ncommit: %s\nbuilt with: %s\nproviders: %s\ngrpc api: %t\nverbs: %t\n",
+			version, commit, runtime.Version(), strings.Join(rdma.ProviderNames(), ", "), grpcBuiltIn, rdma.VerbsBuiltIn)
 		os.Exit(0)
 	}
 
 	logger := newLogger(cfg.LogLevel)
 	logger.Info("starting prometheus rdma exporter",
 		"listen_address", cfg.ListenAddress,
+		"listen_interface", cfg.ListenInterface,
+		"allow_cidrs", cfg.AllowedCIDRs,
+		"request_logging", cfg.RequestLogging,
+		"web_h2c", cfg.EnableH2C,
+		"startup_grace_period", cfg.StartupGracePeriod.String(),
+		"grpc_listen_address", cfg.GRPCListenAddress,
 		"metrics_path", cfg.MetricsPath,
 		"health_path", cfg.HealthPath,
 		"scrape_timeout", cfg.ScrapeTimeout.String(),
+		"sysfs_retry_attempts", cfg.RetryAttempts,
+		"sysfs_retry_backoff", cfg.RetryBackoff.String(),
+		"sysfs_cache_counter_fds", cfg.CacheCounterFDs,
+		"provider", cfg.Provider,
+		"sysfs_only", cfg.SysfsOnly,
 		"sysfs_root", cfg.SysfsRoot,
+		"procfs_root", cfg.ProcfsRoot,
 		"enable_roce_pfc_metrics", cfg.EnableRoCEPFCMetrics,
+		"enable_netdev_link_metrics", cfg.EnableNetDevLink,
+		"collect_netdev_statistics", cfg.CollectNetDevStats,
+		"collect_gid_table", cfg.CollectGIDTable,
+		"collect_pkey_table", cfg.CollectPKeyTable,
+		"collect_ipv6_flowlabel_sysctls", cfg.CollectFlowLabels,
+		"collect_device_limits", cfg.CollectDeviceLimits,
+		"collect_uevents", cfg.CollectUEvents,
+		"collect_roce_config", cfg.CollectRoCEConfig,
+		"collect_dcb", cfg.CollectDCB,
+		"enable_vport_metrics", cfg.EnableVPortMetrics,
+		"ethtool_clients", cfg.EthtoolClients,
+		"netdev_ethtool_stats", cfg.NetDevEthtoolStats,
+		"enable_raw_api", cfg.EnableRawAPI,
+		"raw_api_max_age", cfg.RawAPIMaxAge.String(),
+		"enable_deep_scan", cfg.EnableDeepScan,
+		"enable_silence_api", cfg.EnableSilenceAPI,
+		"enable_invalidate_api", cfg.EnableInvalidateAPI,
+		"enable_collect_profile", cfg.EnableCollectProfile,
+		"state_file", cfg.StateFile,
+		"stateful", cfg.Stateful,
+		"link_recovery_burst", cfg.RecoveryBurst,
+		"link_recovery_burst_window", cfg.RecoveryBurstWindow.String(),
+		"no_devices_policy", cfg.NoDevicesPolicy,
+		"device_dedup", cfg.DeviceDedup,
+		"rate_jitter_counters", cfg.RateJitterCounters,
+		"rate_jitter_window", cfg.RateJitterWindow,
+		"top_counters", cfg.TopCounters,
+		"top_counters_window", cfg.TopCountersWindow.String(),
+		"adaptive_budget", cfg.AdaptiveBudget,
+		"node_desc_check", cfg.NodeDescCheck,
+		"kubernetes_node_expectations", cfg.KubernetesExpectations,
+		"emit_zeros", cfg.EmitZeros,
+		"byte_counters", cfg.ByteCounters,
+		"tick_duration", cfg.TickDuration.String(),
+		"snapshot_lifespan", cfg.SnapshotLifespan.String(),
+		"warmup", cfg.Warmup.String(),
+		"utilization_window", cfg.UtilizationWindow.String(),
+		"collector_timeouts", cfg.CollectorTimeouts,
+		"attribute_refresh", cfg.AttributeRefresh,
+		"stable_counter_after", cfg.StableCounterAfter,
+		"stable_counter_refresh", cfg.StableCounterRefresh,
+		"rail_labels", cfg.RailLabels,
+		"port_roles", len(cfg.PortRoles),
+		"metrics_schema", cfg.MetricsSchema,
+		"suppress_unchanged_after", cfg.SuppressAfter,
+		"suppress_unchanged_keepalive", cfg.SuppressKeepAlive,
+		"config_hash", cfg.Hash(),
 	)
 
-	provider := rdma.NewSysfsProvider()
-	if cfg.SysfsRoot != "" {
-		provider.SetSysfsRoot(cfg.SysfsRoot)
+	listenAddresses, grpcListenAddresses, err := resolveListenAddresses(cfg)
+	if err != nil {
+		logger.Error("refusing to start", "interface", cfg.ListenInterface, "err", err)
+		os.Exit(1)
 	}
-	if len(cfg.ExcludeDevices) > 0 {
-		provider.SetExcludeDevices(cfg.ExcludeDevices)
-		logger.Info("excluding devices from monitoring", "devices", cfg.ExcludeDevices)
+	if len(listenAddresses) > 0 {
+		logger.Info("binding to interface addresses", "interface", cfg.ListenInterface, "addresses", listenAddresses, "grpc_addresses", grpcListenAddresses)
+	}
+
+	exp, err := newExporter(cfg, logger)
+	if err != nil {
+		logger.Error("failed to initialize exporter", "provider", cfg.Provider, "err", err)
+		os.Exit(1)
 	}
 
-	collectorOpts := make([]collector.Option, 0, 1)
-	var ethtoolProvider *netdev.EthtoolStatsProvider
-	if cfg.EnableRoCEPFCMetrics {
-		ethtoolStatsProvider, err := netdev.NewEthtoolStatsProvider()
+	if cfg.StateFile != "" {
+		st, err := state.Load(cfg.StateFile)
 		if err != nil {
-			logger.Warn("failed to initialize RoCE PFC stats provider; PFC metrics are disabled", "err", err)
+			// Losing silences must not keep the exporter from serving.
+			logger.Warn("ignoring unreadable state file", "err", err)
 		} else {
-			ethtoolProvider = ethtoolStatsProvider
-			collectorOpts = append(collectorOpts, collector.WithNetDevStatsProvider(ethtoolStatsProvider))
+			exp.collector.RestoreSilences(st.Silences)
+			if silences := exp.collector.Silences(); len(silences) > 0 {
+				logger.Info("restored device silences", "silences", len(silences))
+			}
 		}
 	}
 
-	rdmaCollector := collector.New(provider, logger, collectorOpts...)
+	var conditions []server.Condition
+	if cfg.ConditionsFile != "" {
+		conditions, err = server.LoadConditions(cfg.ConditionsFile)
+		if err != nil {
+			logger.Error("failed to load conditions", "err", err)
+			os.Exit(1)
+		}
+		logger.Info("loaded conditions", "file", cfg.ConditionsFile, "conditions", len(conditions))
+	}
 
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(
-		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
-		prometheus.NewGoCollector(),
-		rdmaCollector,
-	)
+	// The pidfile is written before dropping privileges, but removed after,
+	// so its directory must be writable by --user.
+	if cfg.Pidfile != "" {
+		if err := process.CheckPidfileDir(cfg.Pidfile, cfg.User, cfg.Group); err != nil {
+			logger.Error("refusing to start", "pidfile", cfg.Pidfile, "user", cfg.User, "err", err)
+			os.Exit(1)
+		}
+		if err := process.WritePidfile(cfg.Pidfile); err != nil {
+			logger.Error("failed to write pidfile", "err", err)
+			os.Exit(1)
+		}
+	}
+	removePidfile := func() {
+		if cfg.Pidfile == "" {
+			return
+		}
+		if err := process.RemovePidfile(cfg.Pidfile); err != nil {
+			logger.Warn("failed to remove pidfile", "err", err)
+		}
+	}
+	if err := process.DropPrivileges(cfg.User, cfg.Group); err != nil {
+		logger.Error("failed to drop privileges", "user", cfg.User, "group", cfg.Group, "err", err)
+		removePidfile()
+		os.Exit(1)
+	}
+	if cfg.User != "" || cfg.Group != "" {
+		logger.Info("dropped privileges", "uid", os.Getuid(), "gid", os.Getgid())
+	}
+
+	// A signal while waiting for devices ends the exporter like one while
+	// serving does.
+	discoveryCtx, stopDiscovery := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err = checkDevices(discoveryCtx, cfg, logger, exp.collector)
+	interrupted := discoveryCtx.Err() != nil
+	stopDiscovery()
+	if err != nil {
+		if interrupted {
+			logger.Info("signal received while waiting for rdma devices, shutting down")
+			exp.Close()
+			removePidfile()
+			return
+		}
+		logger.Error("refusing to start", "policy", cfg.NoDevicesPolicy, "err", err)
+		removePidfile()
+		os.Exit(1)
+	}
 
 	srv := server.New(server.Options{
-		ListenAddress: cfg.ListenAddress,
-		MetricsPath:   cfg.MetricsPath,
-		HealthPath:    cfg.HealthPath,
-		ScrapeTimeout: cfg.ScrapeTimeout,
-	}, registry, rdmaCollector, logger)
+		ListenAddress:      cfg.ListenAddress,
+		ListenAddresses:    listenAddresses,
+		MetricsPath:        cfg.MetricsPath,
+		HealthPath:         cfg.HealthPath,
+		ScrapeTimeout:      cfg.ScrapeTimeout,
+		EnableRawAPI:       cfg.EnableRawAPI,
+		RawAPIMaxAge:       cfg.RawAPIMaxAge,
+		EnableDeepScan:     cfg.EnableDeepScan,
+		EnableSilenceAPI:   cfg.EnableSilenceAPI,
+		EnableInvalidation: cfg.EnableInvalidateAPI,
+		StateFile:          cfg.StateFile,
+		RequestLogging:     cfg.RequestLogging,
+		StartupGracePeriod: cfg.StartupGracePeriod,
+		AllowedCIDRs:       cfg.AllowedCIDRs,
+		EnableH2C:          cfg.EnableH2C,
+
+		EnableCollectProfile: cfg.EnableCollectProfile,
+		Conditions:           conditions,
+	}, exp.registry, exp.collector, logger)
+
+	influxCtx, stopInflux := context.WithCancel(context.Background())
+	defer stopInflux()
+	if cfg.InfluxURL != "" {
+		writer, err := influx.New(influx.Options{
+			URL:      cfg.InfluxURL,
+			Interval: cfg.InfluxInterval,
+			Timeout:  cfg.ScrapeTimeout,
+		}, srv.Exposition(), logger)
+		if err != nil {
+			logger.Error("invalid influx output", "err", err)
+			removePidfile()
+			os.Exit(1)
+		}
+		logger.Info("writing metrics in influx line protocol", "interval", cfg.InfluxInterval.String())
+		go writer.Run(influxCtx)
+	}
+
+	pluginCtx, stopPlugins := context.WithCancel(context.Background())
+	defer stopPlugins()
+	if exp.plugins != nil {
+		logger.Info("running exec plugins", "dir", cfg.PluginDir, "interval", cfg.PluginInterval.String())
+		go exp.plugins.Run(pluginCtx)
+	}
+
+	expectationsCtx, stopExpectations := context.WithCancel(context.Background())
+	defer stopExpectations()
+	if exp.expectations != nil {
+		logger.Info("reading node expectations from kubernetes", "node", cfg.KubernetesNodeName, "interval", cfg.KubernetesRefreshInterval.String())
+		go exp.expectations.Run(expectationsCtx)
+	}
 
-	errCh := make(chan error, 1)
+	ueventCtx, stopUEvents := context.WithCancel(context.Background())
+	defer stopUEvents()
+	if exp.uevents != nil {
+		go func() {
+			if err := exp.uevents.Run(ueventCtx); err != nil && ueventCtx.Err() == nil {
+				logger.Warn("kernel uevent watcher stopped", "err", err)
+			}
+		}()
+	}
+
+	errCh := make(chan error, 2)
 	go func() {
 		if serveErr := srv.ListenAndServe(); serveErr != nil {
 			errCh <- serveErr
 		}
 	}()
 
+	stopGRPC, err := startGRPC(cfg, grpcListenAddresses, exp.collector, srv.RejectedRequests(), logger, errCh)
+	if err != nil {
+		logger.Error("refusing to start", "err", err)
+		removePidfile()
+		os.Exit(1)
+	}
+
+	// SIGUSR2 drops cached state, like POST /-/invalidate-cache.
+	usr2Ch := make(chan os.Signal, 1)
+	signal.Notify(usr2Ch, syscall.SIGUSR2)
+	go func() {
+		for range usr2Ch {
+			exp.collector.InvalidateCache()
+			logger.Info("caches invalidated", "signal", syscall.SIGUSR2.String())
+		}
+	}()
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
@@ -103,23 +301,409 @@ func main() {
 		logger.Info("signal received, shutting down", "signal", sig.String())
 	case serveErr := <-errCh:
 		logger.Error("server exited with error", "err", serveErr)
+		removePidfile()
 		os.Exit(1)
 	}
 
+	stopInflux()
+	stopPlugins()
+	stopUEvents()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	stopGRPC(ctx)
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error("graceful shutdown failed", "err", err)
+		removePidfile()
 		os.Exit(1)
 	}
-	if ethtoolProvider != nil {
-		if err := ethtoolProvider.Close(); err != nil {
-			logger.Warn("failed to close RoCE PFC stats provider", "err", err)
+	exp.Close()
+	removePidfile()
+
+	logger.Info("shutdown complete")
+}
+
+// nodeName returns the name of the node the exporter runs on: $NODE_NAME,
+// which a DaemonSet sets from spec.nodeName with the downward API, or else
+// the host name. Without hostNetwork a pod's host name is the pod's name.
+func nodeName() (string, error) {
+	if name := strings.TrimSpace(os.Getenv("NODE_NAME")); name != "" {
+		return name, nil
+	}
+	return os.Hostname()
+}
+
+// resolveListenAddresses returns the addresses of --web.listen-interface for
+// the HTTP server and, when --grpc.listen-address is set, for the gRPC API,
+// or nil when the exporter should bind to the listen addresses as given.
+// Fabric interfaces are detected from every RDMA device, ignoring
+// --exclude-devices, so excluding a device cannot open the exporter to its
+// fabric.
+func resolveListenAddresses(cfg config.Config) (web, grpc []string, err error) {
+	if cfg.ListenInterface == "" {
+		return nil, nil, nil
+	}
+
+	provider := rdma.NewSysfsProvider()
+	if err := provider.SetAllowedSysfsRoots(cfg.AllowedSysfsRoots); err != nil {
+		return nil, nil, err
+	}
+	if err := provider.SetSysfsRoot(cfg.SysfsRoot); err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	fabric, err := provider.FabricNetDevs(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("detect fabric interfaces: %w", err)
+	}
+	web, err = server.InterfaceListenAddresses(cfg.ListenInterface, cfg.ListenAddress, fabric)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.GRPCListenAddress != "" {
+		grpc, err = server.InterfaceListenAddresses(cfg.ListenInterface, cfg.GRPCListenAddress, fabric)
+		if err != nil {
+			return nil, nil, fmt.Errorf("grpc: %w", err)
 		}
 	}
+	return web, grpc, nil
+}
 
-	logger.Info("shutdown complete")
+// exporter holds the collector and registry shared by the HTTP server and
+// subcommands such as support-bundle.
+type exporter struct {
+	collector *collector.RdmaCollector
+	registry  *prometheus.Registry
+	logger    *slog.Logger
+
+	provider        rdma.Provider
+	ethtoolProvider *netdev.EthtoolStatsProvider
+	// plugins is set when exec plugins are configured.
+	plugins *plugin.Runner
+	// uevents is set when kernel uevents of RDMA devices are counted.
+	uevents *rdma.UEventWatcher
+	// netlink is set when resource counts or QP counters are read over a
+	// netlink socket of their own, because the provider does not report them.
+	netlink *rdma.NetlinkProvider
+	// dcb is set when the DCB configuration of netdevs is exported.
+	dcb *rdma.DCBReader
+	// expectations is set when the node's expectations are read from its
+	// Kubernetes annotations.
+	expectations *kube.NodeWatcher
+}
+
+func newExporter(cfg config.Config, logger *slog.Logger) (*exporter, error) {
+	provider, err := rdma.NewProvider(cfg.Provider, rdma.ProviderConfig{
+		SysfsRoot:           cfg.SysfsRoot,
+		AllowedSysfsRoots:   cfg.AllowedSysfsRoots,
+		ExcludeDevices:      cfg.ExcludeDevices,
+		FabricIPv4PrefixLen: cfg.FabricIPv4PrefixLen,
+		ChangeDetection: rdma.ChangeDetection{
+			AttributeRefresh:     cfg.AttributeRefresh,
+			StableCounterAfter:   cfg.StableCounterAfter,
+			StableCounterRefresh: cfg.StableCounterRefresh,
+		},
+		RetryPolicy: rdma.RetryPolicy{
+			Attempts: cfg.RetryAttempts,
+			Backoff:  cfg.RetryBackoff,
+		},
+		CacheCounterFDs: cfg.CacheCounterFDs,
+		ProcfsRoot:      cfg.ProcfsRoot,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.ExcludeDevices) > 0 {
+		logger.Info("excluding devices from monitoring", "devices", cfg.ExcludeDevices)
+	}
+
+	e := &exporter{logger: logger, provider: provider}
+
+	collectorOpts := make([]collector.Option, 0, 8)
+	if cfg.CollectFlowLabels {
+		collectorOpts = append(collectorOpts, collector.WithFlowLabelSettings(rdma.NewSysctlProvider(cfg.ProcfsRoot)))
+	}
+	if cfg.Stateful {
+		collectorOpts = append(collectorOpts, collector.WithStatefulMode(), collector.WithLinkRecoveryBursts(cfg.RecoveryBurst, cfg.RecoveryBurstWindow))
+	}
+	if cfg.NodeDescCheck {
+		hostname, err := nodeName()
+		if err != nil {
+			logger.Warn("failed to read host name; node_desc check is disabled", "err", err)
+		} else {
+			collectorOpts = append(collectorOpts, collector.WithNodeDescCheck(hostname))
+		}
+	}
+	if cfg.CollectResources {
+		if resources, ok := provider.(collector.ResourceProvider); ok {
+			collectorOpts = append(collectorOpts, collector.WithResourceProvider(resources))
+		} else if nl, err := e.openNetlink(cfg); err != nil {
+			logger.Warn("failed to open rdma netlink socket; resource metrics are disabled", "err", err)
+		} else {
+			collectorOpts = append(collectorOpts, collector.WithResourceProvider(nl))
+		}
+	}
+	if cfg.CollectDeviceLimits {
+		if limits, err := rdma.NewVerbsLimitProvider(); err != nil {
+			logger.Warn("device limit metrics are disabled", "err", err)
+		} else {
+			collectorOpts = append(collectorOpts, collector.WithDeviceLimits(limits))
+		}
+	}
+	if cfg.ResourcesByProcess {
+		if resources, ok := provider.(collector.ProcessResourceProvider); ok {
+			collectorOpts = append(collectorOpts, collector.WithProcessResources(resources))
+		} else if nl, err := e.openNetlink(cfg); err != nil {
+			logger.Warn("failed to open rdma netlink socket; per-process resource metrics are disabled", "err", err)
+		} else {
+			collectorOpts = append(collectorOpts, collector.WithProcessResources(nl))
+		}
+	}
+	if cfg.CollectQPCounters {
+		if qpCounters, ok := provider.(collector.QPCounterProvider); ok {
+			collectorOpts = append(collectorOpts, collector.WithQPCounters(qpCounters, cfg.QPCounterLimit))
+		} else if nl, err := e.openNetlink(cfg); err != nil {
+			logger.Warn("failed to open rdma netlink socket; qp counter metrics are disabled", "err", err)
+		} else {
+			collectorOpts = append(collectorOpts, collector.WithQPCounters(nl, cfg.QPCounterLimit))
+		}
+	}
+	if cfg.CollectNetDevStats {
+		if stats, ok := provider.(collector.NetDevStatisticsProvider); ok {
+			collectorOpts = append(collectorOpts, collector.WithNetDevStatistics(stats))
+		} else {
+			logger.Warn("provider does not support netdev statistics; netdev statistics metrics are disabled", "provider", cfg.Provider)
+		}
+	}
+	if cfg.CollectGIDTable {
+		if gids, ok := provider.(collector.GIDTableProvider); ok {
+			collectorOpts = append(collectorOpts, collector.WithGIDTable(gids))
+		} else {
+			logger.Warn("provider does not support gid tables; gid table metrics are disabled", "provider", cfg.Provider)
+		}
+	}
+	if cfg.CollectPKeyTable {
+		if pkeys, ok := provider.(collector.PKeyTableProvider); ok {
+			collectorOpts = append(collectorOpts, collector.WithPKeyTable(pkeys))
+		} else {
+			logger.Warn("provider does not support pkey tables; pkey table metrics are disabled", "provider", cfg.Provider)
+		}
+	}
+	if cfg.CollectRoCEConfig {
+		if roceConfigs, ok := provider.(collector.RoCEConfigProvider); ok {
+			collectorOpts = append(collectorOpts, collector.WithRoCEConfig(roceConfigs))
+		} else {
+			logger.Warn("provider does not support roce configuration; roce config metrics are disabled", "provider", cfg.Provider)
+		}
+	}
+	if cfg.CollectDCB {
+		dcb, err := rdma.NewDCBReader()
+		if err != nil {
+			logger.Warn("failed to open dcbnl socket; dcb metrics are disabled", "err", err)
+		} else {
+			e.dcb = dcb
+			collectorOpts = append(collectorOpts, collector.WithDCB(dcb))
+		}
+	}
+	if cfg.KubernetesExpectations {
+		client, err := kube.NewInClusterClient()
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes node expectations: %w", err)
+		}
+		watcher, err := kube.NewNodeWatcher(client, kube.WatcherOptions{
+			Node:     cfg.KubernetesNodeName,
+			Prefix:   cfg.KubernetesAnnotationPrefix,
+			Interval: cfg.KubernetesRefreshInterval,
+		}, logger)
+		if err != nil {
+			return nil, err
+		}
+		e.expectations = watcher
+		collectorOpts = append(collectorOpts, collector.WithExpectations(watcher))
+	}
+	if cfg.DeviceDedup != config.DeviceDedupOff {
+		collectorOpts = append(collectorOpts, collector.WithDeviceDedup(cfg.DeviceDedup))
+	}
+	if len(cfg.RateJitterCounters) > 0 {
+		collectorOpts = append(collectorOpts, collector.WithRateJitter(cfg.RateJitterCounters, cfg.RateJitterWindow))
+	}
+	if cfg.AdaptiveBudget {
+		collectorOpts = append(collectorOpts, collector.WithScrapeBudget(cfg.ScrapeTimeout))
+	}
+	collectorOpts = append(collectorOpts, collector.WithSchemaVersion(cfg.MetricsSchema))
+	if cfg.EmitZeros {
+		collectorOpts = append(collectorOpts, collector.WithEmitZeros())
+	}
+	if cfg.CounterSpecsFile != "" {
+		specs, err := collector.LoadCounterSpecs(cfg.CounterSpecsFile)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("loaded counter specs", "file", cfg.CounterSpecsFile, "counters", len(specs))
+		collectorOpts = append(collectorOpts, collector.WithCounterSpecs(specs))
+	}
+	if cfg.BuiltinGaugeCounters {
+		collectorOpts = append(collectorOpts, collector.WithBuiltinGaugeCounters())
+	}
+	if len(cfg.GaugeCounters) > 0 {
+		collectorOpts = append(collectorOpts, collector.WithGaugeCounters(cfg.GaugeCounters))
+	}
+	if cfg.TopCounters > 0 {
+		collectorOpts = append(collectorOpts, collector.WithTopCounters(cfg.TopCounters, cfg.TopCountersWindow))
+	}
+	if cfg.CollectUEvents {
+		if watcher, err := rdma.NewUEventWatcher(); err != nil {
+			logger.Warn("failed to open kernel uevent socket; uevent metrics are disabled", "err", err)
+		} else {
+			watcher.SetExcludeDevices(cfg.ExcludeDevices)
+			e.uevents = watcher
+			collectorOpts = append(collectorOpts, collector.WithUEvents(watcher))
+		}
+	}
+	if cfg.ByteCounters {
+		collectorOpts = append(collectorOpts, collector.WithByteCounters())
+	}
+	if cfg.TickDuration > 0 {
+		collectorOpts = append(collectorOpts, collector.WithTickDuration(cfg.TickDuration))
+	}
+	if cfg.SnapshotLifespan > 0 {
+		collectorOpts = append(collectorOpts, collector.WithSnapshotLifespan(cfg.SnapshotLifespan))
+	}
+	if cfg.Warmup > 0 {
+		collectorOpts = append(collectorOpts, collector.WithWarmup(cfg.Warmup))
+	}
+	if cfg.UtilizationWindow > 0 {
+		collectorOpts = append(collectorOpts, collector.WithUtilization(cfg.UtilizationWindow))
+	}
+	if len(cfg.CollectorTimeouts) > 0 {
+		collectorOpts = append(collectorOpts, collector.WithCollectorTimeouts(cfg.CollectorTimeouts))
+	}
+	if cfg.SuppressAfter > 0 {
+		collectorOpts = append(collectorOpts, collector.WithSuppressUnchanged(cfg.SuppressAfter, cfg.SuppressKeepAlive))
+	}
+	if cfg.RailLabels {
+		collectorOpts = append(collectorOpts, collector.WithRailLabels(cfg.Rails))
+	}
+	if len(cfg.PortRoles) > 0 {
+		if gids, ok := provider.(collector.GIDTableProvider); ok {
+			rules := make([]collector.PortRoleRule, 0, len(cfg.PortRoles))
+			for _, role := range cfg.PortRoles {
+				rules = append(rules, collector.PortRoleRule{Role: role.Role, Prefix: role.Prefix, VLAN: role.VLAN})
+			}
+			collectorOpts = append(collectorOpts, collector.WithPortRoles(rules, gids))
+		} else {
+			logger.Warn("provider does not support gid tables; port role labels are disabled", "provider", cfg.Provider)
+		}
+	}
+	if cfg.EnableDeepScan {
+		if deep, ok := provider.(collector.DeepScanProvider); ok {
+			collectorOpts = append(collectorOpts, collector.WithDeepScan(deep))
+		} else {
+			logger.Warn("provider does not support deep scans; deep scan is disabled", "provider", cfg.Provider)
+		}
+	}
+	if cfg.EnableRoCEPFCMetrics || cfg.EnableNetDevLink || cfg.EnableVPortMetrics || len(cfg.NetDevEthtoolStats) > 0 {
+		ethtoolStatsProvider, err := netdev.NewEthtoolStatsProvider(cfg.EthtoolClients)
+		if err != nil {
+			logger.Warn("failed to initialize ethtool provider; PFC, netdev link, netdev ethtool and vport metrics are disabled", "err", err)
+		} else {
+			e.ethtoolProvider = ethtoolStatsProvider
+			if cfg.EnableRoCEPFCMetrics {
+				collectorOpts = append(collectorOpts, collector.WithNetDevStatsProvider(ethtoolStatsProvider))
+			}
+			if cfg.EnableNetDevLink {
+				collectorOpts = append(collectorOpts, collector.WithLinkSettingsProvider(ethtoolStatsProvider))
+			}
+			if len(cfg.NetDevEthtoolStats) > 0 {
+				collectorOpts = append(collectorOpts, collector.WithNetDevEthtoolStats(ethtoolStatsProvider, cfg.NetDevEthtoolStats))
+			}
+			if cfg.EnableVPortMetrics {
+				if reps, ok := provider.(collector.RepresentorProvider); ok {
+					collectorOpts = append(collectorOpts, collector.WithVPortMetrics(reps, ethtoolStatsProvider))
+				} else {
+					logger.Warn("provider does not support representor discovery; vport metrics are disabled", "provider", cfg.Provider)
+				}
+			}
+		}
+	}
+
+	e.collector = collector.New(provider, logger, collectorOpts...)
+
+	configHash := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "rdma_exporter_config_hash",
+		Help:        "Constant 1 labeled with a fingerprint of the exporter's effective configuration.",
+		ConstLabels: prometheus.Labels{"hash": cfg.Hash()},
+	})
+	configHash.Set(1)
+
+	e.registry = prometheus.NewRegistry()
+	e.registry.MustRegister(
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+		configHash,
+		e.collector,
+	)
+
+	if cfg.PluginDir != "" {
+		runner, err := plugin.NewRunner(plugin.Options{
+			Dir:      cfg.PluginDir,
+			Interval: cfg.PluginInterval,
+			Timeout:  cfg.PluginTimeout,
+		}, logger)
+		if err != nil {
+			return nil, err
+		}
+		e.plugins = runner
+		e.registry.MustRegister(runner)
+	}
+	return e, nil
+}
+
+// Close releases providers that hold kernel resources.
+func (e *exporter) Close() {
+	if closer, ok := e.provider.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			e.logger.Warn("failed to close rdma provider", "err", err)
+		}
+	}
+	if e.ethtoolProvider != nil {
+		if err := e.ethtoolProvider.Close(); err != nil {
+			e.logger.Warn("failed to close ethtool provider", "err", err)
+		}
+	}
+	if e.uevents != nil {
+		if err := e.uevents.Close(); err != nil {
+			e.logger.Warn("failed to close kernel uevent socket", "err", err)
+		}
+	}
+	if e.netlink != nil {
+		if err := e.netlink.Close(); err != nil {
+			e.logger.Warn("failed to close rdma netlink socket", "err", err)
+		}
+	}
+	if e.dcb != nil {
+		if err := e.dcb.Close(); err != nil {
+			e.logger.Warn("failed to close dcbnl socket", "err", err)
+		}
+	}
+}
+
+// openNetlink returns the exporter's own netlink socket, opening it on first
+// use so resource counts and QP counters share one.
+func (e *exporter) openNetlink(cfg config.Config) (*rdma.NetlinkProvider, error) {
+	if e.netlink != nil {
+		return e.netlink, nil
+	}
+	nl, err := rdma.NewNetlinkProvider()
+	if err != nil {
+		return nil, err
+	}
+	nl.SetExcludeDevices(cfg.ExcludeDevices)
+	nl.SetProcfsRoot(cfg.ProcfsRoot)
+	e.netlink = nl
+	return nl, nil
 }
 
 func newLogger(level slog.Level) *slog.Logger {
@@ -0,0 +1,46 @@
+//go:build !no_grpc
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/collector"
+	"github.com/yuuki/rdma_exporter/internal/config"
+	"github.com/yuuki/rdma_exporter/internal/grpcapi"
+)
+
+// grpcBuiltIn reports whether the gRPC API is part of the binary; the
+// no_grpc build tag leaves it and its dependencies out.
+const grpcBuiltIn = true
+
+// startGRPC serves the gRPC API when --grpc.listen-address is set, on
+// listenAddresses instead when --web.listen-interface resolved them, sending
+// serve errors to errCh. The returned function stops it.
+func startGRPC(cfg config.Config, listenAddresses []string, col *collector.RdmaCollector, rejected prometheus.Counter, logger *slog.Logger, errCh chan<- error) (func(context.Context), error) {
+	if cfg.GRPCListenAddress == "" {
+		return func(context.Context) {}, nil
+	}
+	srv := grpcapi.New(grpcapi.Options{
+		ListenAddress:   cfg.GRPCListenAddress,
+		ListenAddresses: listenAddresses,
+		ScrapeTimeout:   cfg.ScrapeTimeout,
+		MaxAge:          cfg.RawAPIMaxAge,
+		AllowedCIDRs:    cfg.AllowedCIDRs,
+		Rejected:        rejected,
+	}, col, logger)
+	go func() {
+		if serveErr := srv.ListenAndServe(); serveErr != nil {
+			errCh <- fmt.Errorf("grpc: %w", serveErr)
+		}
+	}()
+	return func(ctx context.Context) {
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Warn("grpc streams stopped forcibly", "err", err)
+		}
+	}, nil
+}
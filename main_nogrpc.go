@@ -0,0 +1,25 @@
+//go:build no_grpc
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yuuki/rdma_exporter/internal/collector"
+	"github.com/yuuki/rdma_exporter/internal/config"
+)
+
+const grpcBuiltIn = false
+
+// startGRPC refuses --grpc.listen-address in binaries built without the gRPC
+// API rather than silently not serving it.
+func startGRPC(cfg config.Config, _ []string, _ *collector.RdmaCollector, _ prometheus.Counter, _ *slog.Logger, _ chan<- error) (func(context.Context), error) {
+	if cfg.GRPCListenAddress != "" {
+		return nil, errors.New("--grpc.listen-address is set, but the gRPC API is not built into this binary (no_grpc build tag)")
+	}
+	return func(context.Context) {}, nil
+}
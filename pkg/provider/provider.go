@@ -0,0 +1,66 @@
+// Package provider is the stable extension point for RDMA data sources.
+//
+// Downstream builds add a provider (for example one backed by a vendor SDK)
+// by registering it from an init function and blank-importing the package
+// from main, optionally behind a build tag:
+//
+//	//go:build vendorsdk
+//
+//	package vendorsdk
+//
+//	import "github.com/yuuki/rdma_exporter/pkg/provider"
+//
+//	func init() {
+//		provider.Register("vendorsdk", func(cfg provider.Config) (provider.Provider, error) {
+//			return newSDKProvider(cfg)
+//		})
+//	}
+//
+// The exporter then selects it with --provider=vendorsdk. The types below are
+// aliases of the exporter's internal types and follow the module's semantic
+// versioning: fields may be added, but existing ones are not removed or
+// changed within a major version.
+package provider
+
+import "github.com/yuuki/rdma_exporter/internal/rdma"
+
+// Provider enumerates RDMA devices together with their port counters.
+type Provider = rdma.Provider
+
+// Config carries the settings shared by all providers.
+type Config = rdma.ProviderConfig
+
+// Factory builds a Provider from Config.
+type Factory = rdma.ProviderFactory
+
+// Device, Port, PortAttributes and PCIeLink describe what a provider returns.
+type (
+	Device         = rdma.Device
+	Port           = rdma.Port
+	PortAttributes = rdma.PortAttributes
+	PCIeLink       = rdma.PCIeLink
+)
+
+// ErrNoDevices, ErrPermission and ErrUnsupportedLayout are the errors the
+// exporter branches on. Providers return them, wrapped or not, where they
+// apply: ErrNoDevices when the host has no RDMA subsystem, which scrapes as
+// zero devices, and the others when the data source cannot be read.
+var (
+	ErrNoDevices         = rdma.ErrNoDevices
+	ErrPermission        = rdma.ErrPermission
+	ErrUnsupportedLayout = rdma.ErrUnsupportedLayout
+)
+
+// DefaultName is the built-in sysfs provider.
+const DefaultName = rdma.DefaultProviderName
+
+// Register makes a provider selectable by name. Registering a name twice
+// panics.
+func Register(name string, factory Factory) {
+	rdma.RegisterProvider(name, factory)
+}
+
+// Names returns the registered provider names in sorted order.
+func Names() []string {
+	return rdma.ProviderNames()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/yuuki/rdma_exporter/internal/bundle"
+	"github.com/yuuki/rdma_exporter/internal/config"
+)
+
+const supportBundleCommand = "support-bundle"
+
+// runSupportBundle implements "rdma_exporter support-bundle [flags] [-- exporter flags]".
+// Exporter flags after "--" select the same sysfs root, exclusions and
+// collectors the running exporter uses.
+func runSupportBundle(args []string) int {
+	fs := flag.NewFlagSet("rdma_exporter "+supportBundleCommand, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	output := fs.String("output", fmt.Sprintf("rdma_exporter-support-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z")), "Path of the tar.gz bundle to write (\"-\" for stdout).")
+	redact := fs.Bool("redact", false, "Replace GIDs, GUIDs and IP addresses in the bundle.")
+	timeout := fs.Duration("timeout", 30*time.Second, "Upper bound for gathering the bundle.")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	cfg, err := config.Parse(fs.Args())
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	// Logs go to stderr so "--output -" keeps stdout a clean tarball.
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel}))
+	exp, err := newExporter(cfg, logger)
+	if err != nil {
+		logger.Error("failed to initialize exporter", "provider", cfg.Provider, "err", err)
+		return 1
+	}
+	defer exp.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	exp.collector.SetContext(ctx)
+	defer exp.collector.ResetContext()
+
+	write := func(w io.Writer) error {
+		return bundle.Write(ctx, w, bundle.Options{
+			SysfsRoot: cfg.SysfsRoot,
+			Config:    cfg,
+			Gatherer:  exp.registry,
+			Version:   fmt.Sprintf("rdma_exporter v%s\ncommit: %s\nbuilt with: %s\n", version, commit, runtime.Version()),
+			Redact:    *redact,
+		})
+	}
+	if *output == "-" {
+		err = write(os.Stdout)
+	} else {
+		err = writeFileAtomic(*output, write)
+	}
+	if err != nil {
+		logger.Error("write support bundle failed", "err", err)
+		return 1
+	}
+	if *output != "-" {
+		logger.Info("support bundle written", "path", *output)
+	}
+	return 0
+}
+
+// writeFileAtomic writes name through a temporary file in the same
+// directory that is renamed into place once write and the final close
+// succeeded, so a failed or interrupted run leaves no truncated bundle
+// behind.
+func writeFileAtomic(name string, write func(io.Writer) error) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if err := write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", f.Name(), err)
+	}
+	return os.Rename(f.Name(), name)
+}